@@ -0,0 +1,16 @@
+// Package tfbackend embeds the files the server binary ships with, so a
+// single static binary can be installed without the source tree.
+package tfbackend
+
+import "embed"
+
+// Files holds the default backend_service.cfg and the OpenAPI spec
+//
+//go:embed backend_service.cfg openapi.yaml
+var Files embed.FS
+
+// Names of the embedded files
+const (
+	DefaultConfigFile = "backend_service.cfg"
+	OpenAPIFile       = "openapi.yaml"
+)
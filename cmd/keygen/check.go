@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authHealthPath is the authenticated health endpoint used by `keygen check`
+const authHealthPath = "/api/v1/health"
+
+// runCheck implements `keygen check`, which confirms that a key is accepted
+// by a running server (and optionally that it matches auth.cfg)
+func runCheck(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	server := fs.String("server", "", "base URL of the running server, e.g. https://host:8080")
+	orgFlag := fs.String("org", "", "organization ID (UUID)")
+	apiKey := fs.String("key", "", "plaintext API key to check")
+	authConfig := fs.String("auth-config", "", "also verify the key against this auth.cfg")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *server == "" || *orgFlag == "" || *apiKey == "" {
+		return fmt.Errorf("--server, --org and --key are required")
+	}
+	orgID, err := uuid.Parse(*orgFlag)
+	if err != nil {
+		return fmt.Errorf("invalid org ID: %s", *orgFlag)
+	}
+
+	if *authConfig != "" {
+		if err := checkAuthConfig(*authConfig, orgID, *apiKey); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "OK   key matches %s for org %s\n", *authConfig, orgID)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	version, err := checkServer(client, *server, orgID, *apiKey)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "OK   %s accepted the key for org %s (version %s)\n", *server, orgID, version)
+	return nil
+}
+
+// checkAuthConfig verifies that the key matches one of the org's keys in
+// an auth.cfg file
+func checkAuthConfig(filePath string, orgID uuid.UUID, apiKey string) error {
+	orgs, err := readInitConfig(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read auth config: %w", err)
+	}
+
+	for _, org := range orgs {
+		if org.OrgID != orgID {
+			continue
+		}
+		for _, line := range org.APIKeys {
+			storedKey, err := auth.ParseKeyLine(line)
+			if err != nil {
+				return fmt.Errorf("invalid key line for org %s: %w", orgID, err)
+			}
+			if isBcryptHash(storedKey.Key) {
+				if bcrypt.CompareHashAndPassword([]byte(storedKey.Key), []byte(apiKey)) != nil {
+					continue
+				}
+			} else if storedKey.Key != apiKey {
+				continue
+			}
+
+			if storedKey.Expired(time.Now()) {
+				return fmt.Errorf("key %s for org %s in %s has expired", storedKey.ID(), orgID, filePath)
+			}
+			return nil
+		}
+		return fmt.Errorf("key does not match any key for org %s in %s", orgID, filePath)
+	}
+
+	return fmt.Errorf("org %s not found in %s", orgID, filePath)
+}
+
+// isBcryptHash reports whether a stored key is a bcrypt hash rather than a
+// legacy plaintext key
+func isBcryptHash(storedKey string) bool {
+	_, err := bcrypt.Cost([]byte(storedKey))
+	return err == nil
+}
+
+// checkServer performs an authenticated health call and returns the
+// server version
+func checkServer(client *http.Client, server string, orgID uuid.UUID, apiKey string) (string, error) {
+	url := strings.TrimRight(server, "/") + authHealthPath
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL: %w", err)
+	}
+	req.Header.Set("X-Org-ID", orgID.String())
+	req.Header.Set("X-API-Key", apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return "", fmt.Errorf("server rejected the key for org %s", orgID)
+	case http.StatusNotFound:
+		return "", fmt.Errorf("server does not provide %s (upgrade the server)", authHealthPath)
+	default:
+		return "", fmt.Errorf("unexpected status %d from server", resp.StatusCode)
+	}
+
+	var health struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+		OrgID   string `json:"org_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return "", fmt.Errorf("failed to decode health response: %w", err)
+	}
+	if health.OrgID != orgID.String() {
+		return "", fmt.Errorf("server authenticated org %q, expected %s", health.OrgID, orgID)
+	}
+	if health.Status != "healthy" {
+		return "", fmt.Errorf("server reported status %q", health.Status)
+	}
+
+	return health.Version, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/servertest"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRunCheck(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+
+	var stdout bytes.Buffer
+	err := runCheck([]string{"--server", srv.URL, "--org", srv.OrgID.String(), "--key", srv.APIKey}, &stdout)
+	if err != nil {
+		t.Fatalf("Expected check to pass: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "accepted the key") {
+		t.Errorf("Unexpected output: %s", stdout.String())
+	}
+
+	err = runCheck([]string{"--server", srv.URL, "--org", srv.OrgID.String(), "--key", "wrong-key"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Expected rejected key error, got %v", err)
+	}
+}
+
+func TestRunCheckAuthConfig(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(srv.APIKey), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash key: %v", err)
+	}
+	authConfig := filepath.Join(t.TempDir(), "auth.cfg")
+	content := fmt.Sprintf("[%s]\n%s\n", srv.OrgID, hashed)
+	if err := os.WriteFile(authConfig, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write auth config: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	args := []string{"--server", srv.URL, "--org", srv.OrgID.String(), "--key", srv.APIKey, "--auth-config", authConfig}
+	if err := runCheck(args, &stdout); err != nil {
+		t.Fatalf("Expected check to pass: %v", err)
+	}
+
+	// A key past its expiry is reported as expired, not as a mismatch
+	expired := fmt.Sprintf("[%s]\n%s expires=2020-01-01\n", srv.OrgID, hashed)
+	if err := os.WriteFile(authConfig, []byte(expired), 0600); err != nil {
+		t.Fatalf("Failed to write auth config: %v", err)
+	}
+	if err := runCheck(args, &stdout); err == nil || !strings.Contains(err.Error(), "has expired") {
+		t.Errorf("Expected expired key error, got %v", err)
+	}
+
+	otherOrg := uuid.New().String()
+	args = []string{"--server", srv.URL, "--org", otherOrg, "--key", srv.APIKey, "--auth-config", authConfig}
+	if err := runCheck(args, &stdout); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected org not found error, got %v", err)
+	}
+}
+
+func TestRunCheckInvalidFlags(t *testing.T) {
+	var stdout bytes.Buffer
+
+	if err := runCheck([]string{"--server", "http://127.0.0.1:1"}, &stdout); err == nil {
+		t.Error("Expected error for missing --org and --key")
+	}
+	if err := runCheck([]string{"--server", "http://127.0.0.1:1", "--org", "not-a-uuid", "--key", "k"}, &stdout); err == nil {
+		t.Error("Expected error for invalid org ID")
+	}
+}
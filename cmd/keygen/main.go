@@ -2,19 +2,22 @@ package main
 
 import (
 	"bufio"
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/auth"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	bcryptCost = 12 // Higher cost = more secure but slower
+
+	// preHashedPrefix marks init-config lines holding an existing bcrypt hash
+	preHashedPrefix = "hash:"
 )
 
 // OrgConfig represents an organization's configuration with API keys
@@ -24,6 +27,26 @@ type OrgConfig struct {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "scaffold":
+			if err := runScaffold(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Failed to scaffold init config: %v", err)
+			}
+			return
+		case "check":
+			if err := runCheck(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Check failed: %v", err)
+			}
+			return
+		case "rotate":
+			if err := runRotate(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Failed to rotate keys: %v", err)
+			}
+			return
+		}
+	}
+
 	inputFile := "./init-config.cfg"
 	outputFile := "./auth.cfg"
 
@@ -121,9 +144,13 @@ func readInitConfig(filePath string) ([]OrgConfig, error) {
 	return orgs, nil
 }
 
-// generateAuthConfig generates the auth.cfg file with hashed API keys
+// generateAuthConfig generates the auth.cfg file with hashed API keys. Key
+// attributes given after a key in the init config, such as expires and
+// label, are kept, and hashed keys get their creation time and, for
+// prefixed keys, their key ID.
 func generateAuthConfig(orgs []OrgConfig, outputPath string) error {
-	file, err := os.Create(outputPath)
+	// auth.cfg must not be readable by other users
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -138,6 +165,8 @@ func generateAuthConfig(orgs []OrgConfig, outputPath string) error {
 	fmt.Fprintf(writer, "# Format: [OrgID]\n")
 	fmt.Fprintf(writer, "# followed by bcrypt-hashed API keys (one per line)\n\n")
 
+	now := time.Now().UTC().Truncate(time.Second)
+
 	for i, org := range orgs {
 		if i > 0 {
 			fmt.Fprintf(writer, "\n")
@@ -146,13 +175,39 @@ func generateAuthConfig(orgs []OrgConfig, outputPath string) error {
 		// Write org ID header
 		fmt.Fprintf(writer, "[%s]\n", org.OrgID.String())
 
-		// Hash and write each API key
-		for _, apiKey := range org.APIKeys {
+		// Hash and write each API key with its attributes
+		for _, line := range org.APIKeys {
+			key, err := auth.ParseKeyLine(line)
+			if err != nil {
+				return fmt.Errorf("invalid key line for org %s: %w", org.OrgID, err)
+			}
+			apiKey := key.Key
+
+			// Keys hashed elsewhere are passed through untouched
+			if hashedKey, ok := strings.CutPrefix(apiKey, preHashedPrefix); ok {
+				hashedKey = strings.TrimSpace(hashedKey)
+				if _, err := bcrypt.Cost([]byte(hashedKey)); err != nil {
+					return fmt.Errorf("invalid pre-hashed key for org %s: %w", org.OrgID, err)
+				}
+				key.Key = hashedKey
+				fmt.Fprintf(writer, "%s\n", key)
+				log.Printf("Passed through pre-hashed API key for org %s: %s...", org.OrgID, hashedKey[:20])
+				continue
+			}
+
 			hashedKey, err := hashAPIKey(apiKey)
 			if err != nil {
 				return fmt.Errorf("failed to hash API key for org %s: %w", org.OrgID, err)
 			}
-			fmt.Fprintf(writer, "%s\n", hashedKey)
+			key.Key = hashedKey
+			if key.CreatedAt.IsZero() {
+				key.CreatedAt = now
+			}
+			// The server only compares prefixed keys with the hash of their ID
+			if keyID, ok := auth.ParseAPIKeyID(apiKey); ok {
+				key.KeyID = keyID
+			}
+			fmt.Fprintf(writer, "%s\n", key)
 			log.Printf("Hashed API key for org %s: %s -> %s...", org.OrgID, apiKey, hashedKey[:20])
 		}
 	}
@@ -170,10 +225,8 @@ func hashAPIKey(apiKey string) (string, error) {
 }
 
 // generateRandomAPIKey generates a cryptographically secure random API key
+// in the prefixed etk_<key ID>_<secret> form
 func generateRandomAPIKey() (string, error) {
-	b := make([]byte, 32) // 32 bytes = 256 bits
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.URLEncoding.EncodeToString(b), nil
+	apiKey, _, err := auth.GenerateAPIKey()
+	return apiKey, err
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/eterrain/tf-backend-service/internal/auth"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -372,6 +373,96 @@ func TestGenerateAuthConfigInvalidPath(t *testing.T) {
 	}
 }
 
+func TestGenerateAuthConfigPreHashed(t *testing.T) {
+	preHashed, err := bcrypt.GenerateFromPassword([]byte("migrated-key"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash key: %v", err)
+	}
+
+	inputFile := filepath.Join(t.TempDir(), "init-config.cfg")
+	content := "[11111111-2222-3333-4444-555555555555]\nhash:" + string(preHashed) + "\n"
+	if err := os.WriteFile(inputFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write init config: %v", err)
+	}
+
+	orgs, err := readInitConfig(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read init config: %v", err)
+	}
+
+	outputFile := filepath.Join(t.TempDir(), "auth.cfg")
+	if err := generateAuthConfig(orgs, outputFile); err != nil {
+		t.Fatalf("generateAuthConfig failed: %v", err)
+	}
+
+	output, _ := os.ReadFile(outputFile)
+	if !strings.Contains(string(output), "\n"+string(preHashed)+"\n") {
+		t.Errorf("Expected pre-hashed key to be passed through untouched, got:\n%s", output)
+	}
+	if strings.Contains(string(output), "hash:") {
+		t.Error("Expected hash: prefix to be stripped")
+	}
+	if bcrypt.CompareHashAndPassword(preHashed, []byte("migrated-key")) != nil {
+		t.Error("Expected passed-through hash to validate the original key")
+	}
+
+	orgs[0].APIKeys = []string{"hash:not-a-bcrypt-hash"}
+	if err := generateAuthConfig(orgs, outputFile); err == nil {
+		t.Error("Expected error for invalid pre-hashed key")
+	}
+}
+
+func TestGenerateAuthConfigKeyAttributes(t *testing.T) {
+	preHashed, err := bcrypt.GenerateFromPassword([]byte("migrated-key"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash key: %v", err)
+	}
+	orgs := []OrgConfig{{
+		OrgID: uuid.MustParse("11111111-2222-3333-4444-555555555555"),
+		APIKeys: []string{
+			"ci-key expires=2030-01-01 label=ci%20runner",
+			"hash:" + string(preHashed) + " expires=2030-06-01T00:00:00Z",
+			"etk_3f9a1c04b2e7_secret",
+		},
+	}}
+
+	outputFile := filepath.Join(t.TempDir(), "auth.cfg")
+	if err := generateAuthConfig(orgs, outputFile); err != nil {
+		t.Fatalf("generateAuthConfig failed: %v", err)
+	}
+
+	store, err := auth.LoadFileStore(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to load generated auth config: %v", err)
+	}
+	defer store.Close()
+
+	keys, _ := store.Keys(orgs[0].OrgID)
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(keys))
+	}
+	if keys[0].Label != "ci runner" || keys[0].ExpiresAt == nil || keys[0].ExpiresAt.Format("2006-01-02") != "2030-01-01" || keys[0].CreatedAt == nil {
+		t.Errorf("Expected label, expiry and creation time on the hashed key, got %+v", keys[0])
+	}
+	if keys[1].ExpiresAt == nil || keys[1].CreatedAt != nil {
+		t.Errorf("Expected the pre-hashed key to keep only its own attributes, got %+v", keys[1])
+	}
+	if valid, err := store.ValidateCredentials(orgs[0].OrgID, "ci-key"); err != nil || !valid {
+		t.Errorf("Expected the hashed key to validate, got %v, %v", valid, err)
+	}
+	if keys[2].ID != "3f9a1c04b2e7" {
+		t.Errorf("Expected the prefixed key to be stored with its key ID, got %+v", keys[2])
+	}
+	if valid, err := store.ValidateCredentials(orgs[0].OrgID, "etk_3f9a1c04b2e7_secret"); err != nil || !valid {
+		t.Errorf("Expected the prefixed key to validate, got %v, %v", valid, err)
+	}
+
+	orgs[0].APIKeys = []string{"ci-key expires=someday"}
+	if err := generateAuthConfig(orgs, outputFile); err == nil {
+		t.Error("Expected error for an invalid key attribute")
+	}
+}
+
 func TestGenerateRandomAPIKey(t *testing.T) {
 	// Generate multiple keys
 	keys := make(map[string]bool)
@@ -396,6 +487,11 @@ func TestGenerateRandomAPIKey(t *testing.T) {
 		if strings.ContainsAny(key, "+/") {
 			t.Errorf("Key contains non-URL-safe base64 characters: %s", key)
 		}
+
+		// Should carry a key ID
+		if _, ok := auth.ParseAPIKeyID(key); !ok {
+			t.Errorf("Key is not prefixed with a key ID: %s", key)
+		}
 	}
 }
 
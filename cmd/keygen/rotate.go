@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/google/uuid"
+)
+
+// defaultRotationOverlap is how long old keys keep working after a rotation
+const defaultRotationOverlap = 7 * 24 * time.Hour
+
+// runRotate implements `keygen rotate`, which adds a new key to an org in
+// auth.cfg and deprecates the old ones after an overlap window. A running
+// server picks up the change through its file watcher.
+func runRotate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	authConfig := fs.String("auth-config", "./auth.cfg", "auth config file to update")
+	orgFlag := fs.String("org", "", "organization ID (UUID)")
+	keyID := fs.String("key-id", "", "ID of the key to rotate (default: all active keys)")
+	overlap := fs.Duration("overlap", defaultRotationOverlap, "how long the old keys keep working")
+	deprecatedAfter := fs.String("deprecated-after", "", "end of the overlap window (RFC 3339 or YYYY-MM-DD), overrides --overlap")
+	cost := fs.Int("cost", bcryptCost, "bcrypt cost of the new key")
+	list := fs.Bool("list", false, "list the org's keys instead of rotating")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *orgFlag == "" {
+		return fmt.Errorf("--org is required")
+	}
+	orgID, err := uuid.Parse(*orgFlag)
+	if err != nil {
+		return fmt.Errorf("invalid org ID: %s", *orgFlag)
+	}
+
+	store, err := auth.LoadFileStore(*authConfig)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		keys, ok := store.Keys(orgID)
+		if !ok {
+			return fmt.Errorf("org %s not found in %s", orgID, *authConfig)
+		}
+		printKeys(stdout, keys)
+		return nil
+	}
+
+	deprecateAfter := time.Now().Add(*overlap)
+	if *deprecatedAfter != "" {
+		deprecateAfter, err = parseDeprecatedAfter(*deprecatedAfter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := store.SetKeyCost(*cost); err != nil {
+		return err
+	}
+
+	rotation, err := store.RotateKeys(orgID, *keyID, deprecateAfter)
+	if err != nil {
+		return fmt.Errorf("failed to rotate keys: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Rotated keys for org %s in %s\n\n", orgID, *authConfig)
+	fmt.Fprintf(stdout, "New API key:      %s\n", rotation.APIKey)
+	fmt.Fprintf(stdout, "New key ID:       %s\n", rotation.KeyID)
+	fmt.Fprintf(stdout, "Deprecated keys:  %v\n", rotation.DeprecatedKeyIDs)
+	fmt.Fprintf(stdout, "Revoked after:    %s\n", rotation.DeprecatedAfter.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "\nVerify with: keygen check --server <url> --org %s --key <new key>\n", orgID)
+	return nil
+}
+
+// parseDeprecatedAfter parses an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseDeprecatedAfter(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --deprecated-after %q: use RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// printKeys prints one row per key with its deprecation state
+func printKeys(w io.Writer, keys []auth.KeyInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY ID\tHASHED\tDEPRECATED AFTER")
+	for _, key := range keys {
+		deprecatedAfter := "-"
+		if key.DeprecatedAfter != nil {
+			deprecatedAfter = key.DeprecatedAfter.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", key.ID, key.Hashed, deprecatedAfter)
+	}
+	tw.Flush()
+}
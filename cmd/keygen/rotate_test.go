@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRunRotate(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	authConfig := filepath.Join(t.TempDir(), "auth.cfg")
+	if err := os.WriteFile(authConfig, []byte(fmt.Sprintf("[%s]\nold-key\n", orgID)), 0600); err != nil {
+		t.Fatalf("Failed to write auth config: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	args := []string{"--auth-config", authConfig, "--org", orgID.String(), "--deprecated-after", "2030-01-01", "--cost", "4"}
+	if err := runRotate(args, &stdout); err != nil {
+		t.Fatalf("runRotate failed: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "New API key:") {
+		t.Errorf("Unexpected output: %s", stdout.String())
+	}
+
+	content, _ := os.ReadFile(authConfig)
+	if !strings.Contains(string(content), "old-key deprecated_after=2030-01-01T00:00:00Z") || !strings.Contains(string(content), "$2a$04$") {
+		t.Errorf("Unexpected auth config after rotation:\n%s", content)
+	}
+
+	stdout.Reset()
+	if err := runRotate([]string{"--auth-config", authConfig, "--org", orgID.String(), "--list"}, &stdout); err != nil {
+		t.Fatalf("runRotate --list failed: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "2030-01-01T00:00:00Z") {
+		t.Errorf("Expected deprecated key in listing, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunRotateInvalidFlags(t *testing.T) {
+	var stdout bytes.Buffer
+
+	if err := runRotate([]string{}, &stdout); err == nil {
+		t.Error("Expected error for missing --org")
+	}
+	if err := runRotate([]string{"--org", uuid.New().String(), "--auth-config", "/nonexistent/auth.cfg"}, &stdout); err == nil {
+		t.Error("Expected error for missing auth config")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+)
+
+// ScaffoldOrg is a generated organization with its plaintext API keys
+type ScaffoldOrg struct {
+	Name string
+	OrgConfig
+}
+
+// runScaffold implements `keygen scaffold`, which writes an init-config.cfg
+// with fresh org IDs and random API keys and prints the mapping table
+func runScaffold(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("scaffold", flag.ContinueOnError)
+	orgCount := fs.Int("orgs", 5, "number of organizations to generate (ignored with --names)")
+	keysPerOrg := fs.Int("keys-per-org", 2, "number of API keys per organization")
+	namesFile := fs.String("names", "", "file with one organization name per line")
+	outputFile := fs.String("output", "./init-config.cfg", "init config file to write")
+	force := fs.Bool("force", false, "overwrite the output file if it exists")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *keysPerOrg < 1 {
+		return fmt.Errorf("--keys-per-org must be at least 1")
+	}
+
+	var names []string
+	if *namesFile != "" {
+		var err error
+		names, err = readOrgNames(*namesFile)
+		if err != nil {
+			return fmt.Errorf("failed to read org names: %w", err)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no organization names in %s", *namesFile)
+		}
+	} else {
+		if *orgCount < 1 {
+			return fmt.Errorf("--orgs must be at least 1")
+		}
+		for i := 1; i <= *orgCount; i++ {
+			names = append(names, fmt.Sprintf("org-%d", i))
+		}
+	}
+
+	orgs, err := scaffoldOrgs(names, *keysPerOrg)
+	if err != nil {
+		return err
+	}
+
+	if err := writeInitConfig(orgs, *outputFile, *force); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Wrote %d organization(s) to %s\n\n", len(orgs), *outputFile)
+	printMappingTable(stdout, orgs)
+	return nil
+}
+
+// readOrgNames reads organization names, one per line, skipping blank
+// lines and comments
+func readOrgNames(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	var names []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate organization name on line %d: %s", lineNum, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	return names, nil
+}
+
+// scaffoldOrgs generates an org ID and keysPerOrg random API keys per name
+func scaffoldOrgs(names []string, keysPerOrg int) ([]ScaffoldOrg, error) {
+	orgs := make([]ScaffoldOrg, 0, len(names))
+	for _, name := range names {
+		org := ScaffoldOrg{
+			Name:      name,
+			OrgConfig: OrgConfig{OrgID: uuid.New()},
+		}
+		for i := 0; i < keysPerOrg; i++ {
+			apiKey, err := generateRandomAPIKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate API key: %w", err)
+			}
+			org.APIKeys = append(org.APIKeys, apiKey)
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, nil
+}
+
+// writeInitConfig writes the orgs in init-config.cfg format, with each
+// org's name as a comment above its header
+func writeInitConfig(orgs []ScaffoldOrg, outputPath string, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	// The file holds plaintext API keys, so keep it private
+	file, err := os.OpenFile(outputPath, flags, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", outputPath)
+		}
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+
+	fmt.Fprintf(writer, "# Initial configuration file\n")
+	fmt.Fprintf(writer, "# Generated by keygen scaffold - contains plaintext API keys\n")
+	fmt.Fprintf(writer, "# Run keygen to produce auth.cfg, then store this file securely or delete it\n\n")
+
+	for i, org := range orgs {
+		if i > 0 {
+			fmt.Fprintf(writer, "\n")
+		}
+		fmt.Fprintf(writer, "# %s\n", org.Name)
+		fmt.Fprintf(writer, "[%s]\n", org.OrgID.String())
+		for _, apiKey := range org.APIKeys {
+			fmt.Fprintf(writer, "%s\n", apiKey)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// printMappingTable prints one row per API key with its org name and ID
+func printMappingTable(w io.Writer, orgs []ScaffoldOrg) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tORG ID\tAPI KEY")
+	for _, org := range orgs {
+		for _, apiKey := range org.APIKeys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", org.Name, org.OrgID, apiKey)
+		}
+	}
+	tw.Flush()
+}
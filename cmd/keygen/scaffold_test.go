@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScaffold(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "init-config.cfg")
+
+	var stdout bytes.Buffer
+	if err := runScaffold([]string{"--orgs", "3", "--keys-per-org", "2", "--output", outputFile}, &stdout); err != nil {
+		t.Fatalf("runScaffold failed: %v", err)
+	}
+
+	// The generated file must be readable by keygen itself
+	orgs, err := readInitConfig(outputFile)
+	if err != nil {
+		t.Fatalf("Generated config is not valid: %v", err)
+	}
+	if len(orgs) != 3 {
+		t.Fatalf("Expected 3 orgs, got %d", len(orgs))
+	}
+
+	seen := make(map[string]bool)
+	for _, org := range orgs {
+		if len(org.APIKeys) != 2 {
+			t.Errorf("Expected 2 keys for org %s, got %d", org.OrgID, len(org.APIKeys))
+		}
+		for _, key := range org.APIKeys {
+			if seen[key] {
+				t.Errorf("Duplicate API key generated: %s", key)
+			}
+			seen[key] = true
+			if !strings.Contains(stdout.String(), key) {
+				t.Errorf("Mapping table is missing key %s", key)
+			}
+		}
+		if !strings.Contains(stdout.String(), org.OrgID.String()) {
+			t.Errorf("Mapping table is missing org %s", org.OrgID)
+		}
+	}
+
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to stat output: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600 permissions, got %o", info.Mode().Perm())
+	}
+
+	// Existing files are only overwritten with --force
+	if err := runScaffold([]string{"--output", outputFile}, &stdout); err == nil {
+		t.Error("Expected error when output file exists")
+	}
+	if err := runScaffold([]string{"--output", outputFile, "--force"}, &stdout); err != nil {
+		t.Errorf("Expected --force to overwrite: %v", err)
+	}
+}
+
+func TestRunScaffoldWithNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	namesFile := filepath.Join(tmpDir, "orgs.txt")
+	outputFile := filepath.Join(tmpDir, "init-config.cfg")
+
+	if err := os.WriteFile(namesFile, []byte("# demo orgs\nacme\n\nglobex\n"), 0644); err != nil {
+		t.Fatalf("Failed to write names file: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runScaffold([]string{"--names", namesFile, "--keys-per-org", "1", "--output", outputFile}, &stdout); err != nil {
+		t.Fatalf("runScaffold failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFile)
+	for _, name := range []string{"# acme", "# globex"} {
+		if !strings.Contains(string(content), name) {
+			t.Errorf("Expected %q in generated config", name)
+		}
+	}
+
+	orgs, err := readInitConfig(outputFile)
+	if err != nil || len(orgs) != 2 {
+		t.Fatalf("Expected 2 orgs, got %d (err: %v)", len(orgs), err)
+	}
+
+	if err := os.WriteFile(namesFile, []byte("acme\nacme\n"), 0644); err != nil {
+		t.Fatalf("Failed to write names file: %v", err)
+	}
+	if err := runScaffold([]string{"--names", namesFile, "--output", outputFile, "--force"}, &stdout); err == nil {
+		t.Error("Expected error for duplicate names")
+	}
+}
+
+func TestRunScaffoldInvalidFlags(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "init-config.cfg")
+	var stdout bytes.Buffer
+
+	if err := runScaffold([]string{"--orgs", "0", "--output", outputFile}, &stdout); err == nil {
+		t.Error("Expected error for --orgs 0")
+	}
+	if err := runScaffold([]string{"--keys-per-org", "0", "--output", outputFile}, &stdout); err == nil {
+		t.Error("Expected error for --keys-per-org 0")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/fsperm"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// demoStateName is the state seeded in demo mode
+const demoStateName = "demo"
+
+// demoEnvironment is the throwaway instance started by `server --demo`.
+// States are kept in memory; uploaded data and the generated auth.cfg live
+// in a temporary directory that is removed on shutdown.
+type demoEnvironment struct {
+	Dir     string
+	OrgID   uuid.UUID
+	APIKey  string
+	BaseURL string
+}
+
+// setupDemo creates the temporary directory, provisions a demo organization
+// with a random key into its auth.cfg and points the configuration at it
+func setupDemo(cfg *config.Config) (*demoEnvironment, error) {
+	dir, err := os.MkdirTemp("", "tf-backend-demo-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create demo directory: %w", err)
+	}
+	env := &demoEnvironment{Dir: dir}
+
+	if _, err := auth.CreateAuthConfig(env.AuthFile()); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	credStore, err := auth.LoadFileStore(env.AuthFile())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	org, err := credStore.ProvisionOrg(uuid.Nil, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to provision demo organization: %w", err)
+	}
+	env.OrgID = uuid.MustParse(org.OrgID)
+	env.APIKey = org.APIKey
+
+	cfg.StorageType = "csv"
+	cfg.StoragePath = filepath.Join(dir, "data")
+	cfg.SnapshotFile = ""
+	cfg.AuthBootstrap = false
+	cfg.PermissionCheck = fsperm.ModeOff
+
+	scheme := "http"
+	if cfg.EnableTLS {
+		scheme = "https"
+	}
+	host := cfg.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	env.BaseURL = fmt.Sprintf("%s://%s:%d", scheme, host, cfg.Port)
+	return env, nil
+}
+
+// AuthFile returns the path of the demo auth config
+func (e *demoEnvironment) AuthFile() string {
+	return filepath.Join(e.Dir, "auth.cfg")
+}
+
+// Close removes the demo directory with everything written to it
+func (e *demoEnvironment) Close() error {
+	return os.RemoveAll(e.Dir)
+}
+
+// Seed stores a sample state and a few data rows for the demo organization
+func (e *demoEnvironment) Seed(store storage.Storage, dataStore storage.DataStorage) error {
+	state, err := json.MarshalIndent(map[string]interface{}{
+		"version":           4,
+		"terraform_version": "1.6.0",
+		"serial":            1,
+		"lineage":           uuid.New().String(),
+		"outputs": map[string]interface{}{
+			"environment": map[string]interface{}{"value": "demo", "type": "string"},
+		},
+		"resources": []interface{}{},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := store.PutState(e.OrgID, demoStateName, state); err != nil {
+		return fmt.Errorf("failed to seed demo state: %w", err)
+	}
+
+	rows := []map[string]interface{}{
+		{"resource_type": "aws_instance", "name": "web", "region": "us-east-1", "count": 2},
+		{"resource_type": "aws_s3_bucket", "name": "assets", "region": "us-east-1", "count": 1},
+		{"resource_type": "google_compute_instance", "name": "worker", "region": "europe-west1", "count": 3},
+	}
+	for _, row := range rows {
+		if err := dataStore.AppendData(e.OrgID, row); err != nil {
+			return fmt.Errorf("failed to seed demo data: %w", err)
+		}
+	}
+	return nil
+}
+
+var demoSnippets = template.Must(template.New("demo").Parse(`
+Demo mode: throwaway data in {{.Dir}}, removed on shutdown
+
+Organization: {{.OrgID}}
+API key:      {{.APIKey}}
+
+Terraform backend (state "{{.State}}" is already seeded):
+
+terraform {
+  backend "http" {
+    address        = "{{.BaseURL}}/api/v1/state/{{.State}}"
+    lock_address   = "{{.BaseURL}}/api/v1/state/{{.State}}/lock"
+    unlock_address = "{{.BaseURL}}/api/v1/state/{{.State}}/lock"
+    lock_method    = "POST"
+    unlock_method  = "DELETE"
+
+    headers = {
+      X-Org-ID  = "{{.OrgID}}"
+      X-API-Key = "{{.APIKey}}"
+    }
+  }
+}
+
+Terraform provider:
+
+provider "your_provider" {
+  url    = "{{.BaseURL}}"
+  org_id = "{{.OrgID}}"
+  apikey = "{{.APIKey}}"
+}
+
+Sample data:
+
+curl {{.BaseURL}}/api/v1/data -H "X-Org-ID: {{.OrgID}}" -H "X-API-Key: {{.APIKey}}"
+`))
+
+// PrintSnippets writes the demo credentials with ready-to-paste Terraform
+// backend and provider config
+func (e *demoEnvironment) PrintSnippets(w io.Writer) error {
+	return demoSnippets.Execute(w, struct {
+		*demoEnvironment
+		State string
+	}{e, demoStateName})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+)
+
+func TestDemoEnvironment(t *testing.T) {
+	cfg := &config.Config{Host: "0.0.0.0", Port: 7777, StorageType: "mysql", PermissionCheck: "enforce"}
+	env, err := setupDemo(cfg)
+	if err != nil {
+		t.Fatalf("setupDemo failed: %v", err)
+	}
+	defer env.Close()
+
+	if cfg.StorageType != "csv" || !strings.HasPrefix(cfg.StoragePath, env.Dir) || cfg.PermissionCheck != "off" {
+		t.Errorf("Expected the config to point at the demo directory, got %+v", cfg)
+	}
+	if env.BaseURL != "http://127.0.0.1:7777" {
+		t.Errorf("Unexpected base URL %s", env.BaseURL)
+	}
+
+	credStore, err := auth.LoadFileStore(env.AuthFile())
+	if err != nil {
+		t.Fatalf("Failed to load demo auth config: %v", err)
+	}
+	if valid, err := credStore.ValidateCredentials(env.OrgID, env.APIKey); err != nil || !valid {
+		t.Errorf("Expected the demo key to be valid: %v, %v", valid, err)
+	}
+
+	store := storage.NewMemoryStorage()
+	dataStore, err := storage.NewCSVStorage(cfg.StoragePath)
+	if err != nil {
+		t.Fatalf("Failed to create CSV storage: %v", err)
+	}
+	if err := env.Seed(store, dataStore); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	if _, err := store.GetState(env.OrgID, demoStateName); err != nil {
+		t.Errorf("Expected the demo state to be seeded: %v", err)
+	}
+	if rows, err := dataStore.GetOrgData(env.OrgID); err != nil || len(rows) == 0 {
+		t.Errorf("Expected seeded data rows, got %d: %v", len(rows), err)
+	}
+
+	var stdout bytes.Buffer
+	if err := env.PrintSnippets(&stdout); err != nil {
+		t.Fatalf("PrintSnippets failed: %v", err)
+	}
+	for _, want := range []string{
+		`backend "http"`,
+		`address        = "http://127.0.0.1:7777/api/v1/state/demo"`,
+		`X-Org-ID  = "` + env.OrgID.String() + `"`,
+		`X-API-Key = "` + env.APIKey + `"`,
+		`org_id = "` + env.OrgID.String() + `"`,
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("Expected %q in the printed config:\n%s", want, stdout.String())
+		}
+	}
+
+	if err := env.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(env.Dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the demo directory to be removed, got %v", err)
+	}
+}
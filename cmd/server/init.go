@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	tfbackend "github.com/eterrain/tf-backend-service"
+	"github.com/google/uuid"
+)
+
+// runInit implements `server init`, which writes a starter
+// backend_service.cfg and an init-config.cfg with one organization and a
+// random API key, for installs without the source tree
+func runInit(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory to write the starter files to")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	defaults, err := tfbackend.Files.ReadFile(tfbackend.DefaultConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded config: %w", err)
+	}
+
+	orgID := uuid.New()
+	key := make([]byte, 32) // 32 bytes = 256 bits, like keygen
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate API key: %w", err)
+	}
+	apiKey := base64.URLEncoding.EncodeToString(key)
+	initConfig := "# Initial configuration file\n" +
+		"# Generated by server init - contains plaintext API keys\n" +
+		"# Run keygen to produce auth.cfg, then store this file securely or delete it\n\n" +
+		fmt.Sprintf("[%s]\n%s\n", orgID, apiKey)
+
+	files := []struct {
+		name    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{"backend_service.cfg", defaults, 0644},
+		// Holds plaintext keys, so only the owner may read it
+		{"init-config.cfg", []byte(initConfig), 0600},
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", *dir, err)
+	}
+	if !*force {
+		for _, file := range files {
+			path := filepath.Join(*dir, file.name)
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			}
+		}
+	}
+	for _, file := range files {
+		path := filepath.Join(*dir, file.name)
+		if err := os.WriteFile(path, file.content, file.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		// WriteFile keeps the mode of an existing file
+		if err := os.Chmod(path, file.mode); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+		}
+		fmt.Fprintf(stdout, "Wrote %s\n", path)
+	}
+
+	fmt.Fprintf(stdout, "\nOrganization: %s\nAPI key:      %s\n\n", orgID, apiKey)
+	fmt.Fprintln(stdout, "Next steps:")
+	fmt.Fprintln(stdout, "  1. Review backend_service.cfg (storage type, TLS, admin key)")
+	fmt.Fprintln(stdout, "  2. Hash the keys into auth.cfg: keygen init-config.cfg auth.cfg")
+	fmt.Fprintln(stdout, "  3. Start the server from this directory")
+	return nil
+}
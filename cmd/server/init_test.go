@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tfbackend "github.com/eterrain/tf-backend-service"
+	"github.com/google/uuid"
+)
+
+func TestRunInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "etc")
+
+	var stdout bytes.Buffer
+	if err := runInit([]string{"--dir", dir}, &stdout); err != nil {
+		t.Fatalf("runInit failed: %v", err)
+	}
+
+	defaults, _ := tfbackend.Files.ReadFile(tfbackend.DefaultConfigFile)
+	written, err := os.ReadFile(filepath.Join(dir, "backend_service.cfg"))
+	if err != nil {
+		t.Fatalf("Failed to read backend_service.cfg: %v", err)
+	}
+	if !bytes.Equal(written, defaults) {
+		t.Error("backend_service.cfg does not match the embedded defaults")
+	}
+
+	initPath := filepath.Join(dir, "init-config.cfg")
+	data, err := os.ReadFile(initPath)
+	if err != nil {
+		t.Fatalf("Failed to read init-config.cfg: %v", err)
+	}
+	var header, key string
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "["):
+			header = strings.Trim(line, "[]")
+		default:
+			key = line
+		}
+	}
+	if _, err := uuid.Parse(header); err != nil {
+		t.Errorf("Expected an org ID header, got %q", header)
+	}
+	if len(key) < 40 || !strings.Contains(stdout.String(), key) {
+		t.Errorf("Expected a random API key that is printed, got %q", key)
+	}
+
+	info, err := os.Stat(initPath)
+	if err != nil {
+		t.Fatalf("Failed to stat init-config.cfg: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected init-config.cfg mode 0600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestRunInitKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "backend_service.cfg")
+	if err := os.WriteFile(existing, []byte("custom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runInit([]string{"--dir", dir}, &stdout); err == nil {
+		t.Fatal("Expected error when backend_service.cfg exists")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "init-config.cfg")); !os.IsNotExist(err) {
+		t.Error("Expected no files to be written without --force")
+	}
+
+	if err := runInit([]string{"--dir", dir, "--force"}, &stdout); err != nil {
+		t.Fatalf("runInit --force failed: %v", err)
+	}
+	if data, _ := os.ReadFile(existing); string(data) == "custom" {
+		t.Error("Expected --force to overwrite backend_service.cfg")
+	}
+}
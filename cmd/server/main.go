@@ -2,54 +2,247 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/audit"
 	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
 	"github.com/eterrain/tf-backend-service/internal/config"
-	"github.com/eterrain/tf-backend-service/internal/handlers"
+	"github.com/eterrain/tf-backend-service/internal/csvcrypt"
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/deprecation"
+	"github.com/eterrain/tf-backend-service/internal/download"
+	"github.com/eterrain/tf-backend-service/internal/durability"
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/features"
+	"github.com/eterrain/tf-backend-service/internal/geoip"
+	"github.com/eterrain/tf-backend-service/internal/health"
+	"github.com/eterrain/tf-backend-service/internal/ingesthook"
+	"github.com/eterrain/tf-backend-service/internal/logging"
+	"github.com/eterrain/tf-backend-service/internal/logrotate"
+	"github.com/eterrain/tf-backend-service/internal/metrics"
 	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/notify"
+	"github.com/eterrain/tf-backend-service/internal/outbound"
+	"github.com/eterrain/tf-backend-service/internal/policy"
+	"github.com/eterrain/tf-backend-service/internal/public"
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/querycache"
+	"github.com/eterrain/tf-backend-service/internal/quota"
+	"github.com/eterrain/tf-backend-service/internal/ratelimit"
+	"github.com/eterrain/tf-backend-service/internal/recorder"
+	"github.com/eterrain/tf-backend-service/internal/rollup"
+	"github.com/eterrain/tf-backend-service/internal/runtimestats"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/server"
+	"github.com/eterrain/tf-backend-service/internal/statequeue"
 	"github.com/eterrain/tf-backend-service/internal/storage"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
+	"github.com/eterrain/tf-backend-service/internal/usage"
 )
 
 const version = "1.0.0"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if err := runInit(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Failed to write starter files: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		if err := runMigrateStorage(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Storage migration failed: %v", err)
+		}
+		return
+	}
+
+	fixPerms := flag.Bool("fix-perms", false, "remove insecure permission bits from auth.cfg, the data directory and the TLS key before starting")
+	demo := flag.Bool("demo", false, "start a throwaway instance with a demo organization and sample data in a temporary directory")
+	flag.Parse()
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	logRotation := logrotate.Config{
+		MaxSize:    cfg.LogRotateMaxSize,
+		Interval:   cfg.LogRotateInterval,
+		MaxBackups: cfg.LogRotateMaxBackups,
+		MaxAge:     cfg.LogRotateMaxAge,
+		Compress:   cfg.LogRotateCompress,
+	}
+
+	// Structured application log, configured before anything else is logged
+	appLog, err := logging.Configure(logging.Config{
+		Level:    cfg.LogLevel,
+		Format:   cfg.LogFormat,
+		File:     cfg.LogFile,
+		Rotation: logRotation,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+	defer appLog.Close()
+
+	// Demo mode provisions its own organization and keeps all data in a
+	// temporary directory
+	authFile := "./auth.cfg"
+	var demoEnv *demoEnvironment
+	if *demo {
+		demoEnv, err = setupDemo(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up demo mode: %v", err)
+		}
+		defer func() {
+			if err := demoEnv.Close(); err != nil {
+				log.Printf("Error removing demo directory: %v", err)
+			}
+		}()
+		authFile = demoEnv.AuthFile()
+		log.Printf("WARNING: Demo mode; data is kept in %s and removed on shutdown", demoEnv.Dir)
+	}
+
 	log.Printf("Starting Terraform Backend Service v%s", version)
 	log.Printf("Server will listen on %s", cfg.Address())
 
+	// Startup banner: every setting with its source, secrets redacted
+	effectiveConfig := append(cfg.Effective(), config.FlagSettings(flag.CommandLine)...)
+	if cfg.File() != "" {
+		log.Printf("Configuration loaded from %s", cfg.File())
+	} else {
+		log.Printf("Configuration loaded from the environment")
+	}
+	for _, setting := range effectiveConfig {
+		slog.Info("Effective setting", "name", setting.Name, "value", setting.Value, "source", setting.Source)
+	}
+
+	// Route security events to the SIEM stream before anything is logged
+	securityLog, err := security.Configure(security.Config{
+		Output:        cfg.SecurityLogOutput,
+		Format:        cfg.SecurityLogFormat,
+		File:          cfg.SecurityLogFile,
+		SyslogAddress: cfg.SecuritySyslogAddress,
+		Rotation:      logRotation,
+		Version:       version,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure security log: %v", err)
+	}
+	defer securityLog.Close()
+	if cfg.SecurityLogOutput != security.OutputApp {
+		log.Printf("Security events written to %s as %s", cfg.SecurityLogOutput, cfg.SecurityLogFormat)
+	}
+
+	// Credentials and stored data must not be exposed to other local users
+	if err := checkPermissions(cfg, *fixPerms); err != nil {
+		log.Fatalf("Permission check failed: %v (restart with --fix-perms or set PERMISSION_CHECK=warn)", err)
+	}
+
+	// CSV dialect of stored files and exports
+	csvFormat, err := csvfmt.Parse(cfg.CSVDelimiter, cfg.CSVQuote, cfg.CSVBOM)
+	if err != nil {
+		log.Fatalf("Invalid CSV format: %v", err)
+	}
+
+	// Encrypt CSV data files at rest with the master key
+	var csvEncryptionKey []byte
+	if cfg.CSVEncryptionKeyFile != "" {
+		csvEncryptionKey, err = csvcrypt.LoadKeyFile(cfg.CSVEncryptionKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load CSV encryption key: %v", err)
+		}
+	}
+
+	// Sync file writes of CSV and file state storage as configured
+	writeDurability, err := durability.New(durability.Level(cfg.WriteDurability), cfg.WriteSyncInterval)
+	if err != nil {
+		log.Fatalf("Invalid write durability: %v", err)
+	}
+	defer func() {
+		if err := writeDurability.Close(); err != nil {
+			log.Printf("Error syncing written files: %v", err)
+		}
+	}()
+
 	// Initialize storage
 	var store storage.Storage
 	var dataStore storage.DataStorage
+	var mysqlStore *storage.MySQLStorage
 	switch cfg.StorageType {
 	case "memory":
-		store = storage.NewMemoryStorage()
-		log.Println("Using in-memory storage")
+		if cfg.SnapshotFile == "" {
+			store = storage.NewMemoryStorage()
+			log.Println("Using in-memory storage")
+			break
+		}
+		memoryStore, err := storage.NewPersistentMemoryStorage(cfg.SnapshotFile, cfg.SnapshotInterval)
+		if err != nil {
+			log.Fatalf("Failed to initialize memory storage: %v", err)
+		}
+		defer func() {
+			if err := memoryStore.Close(); err != nil {
+				log.Printf("Error writing memory snapshot: %v", err)
+			}
+		}()
+		store = memoryStore
+		log.Printf("Using in-memory storage with snapshots to %s every %v", cfg.SnapshotFile, cfg.SnapshotInterval)
 	case "csv":
 		csvStore, err := storage.NewCSVStorage(cfg.StoragePath)
 		if err != nil {
 			log.Fatalf("Failed to initialize CSV storage: %v", err)
 		}
+		if err := csvStore.SetLayout(cfg.CSVLayout); err != nil {
+			log.Fatalf("Failed to configure CSV storage: %v", err)
+		}
+		csvStore.SetFormat(csvFormat)
+		csvStore.SetDurability(writeDurability)
+		if csvEncryptionKey != nil {
+			if err := csvStore.SetEncryption(csvEncryptionKey); err != nil {
+				log.Fatalf("Failed to configure CSV encryption: %v", err)
+			}
+			log.Println("CSV data files are encrypted at rest")
+		}
 		dataStore = csvStore
-		log.Printf("Using CSV storage at: %s", cfg.StoragePath)
+		log.Printf("Using CSV storage at: %s (layout: %s)", cfg.StoragePath, cfg.CSVLayout)
+
+		if demoEnv != nil {
+			store = storage.NewMemoryStorage()
+			log.Println("Using in-memory state storage")
+			break
+		}
+
+		stateDir := filepath.Join(cfg.StoragePath, "states")
+		stateStore, err := storage.NewFileStateStorage(stateDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize state storage: %v", err)
+		}
+		stateStore.SetDurability(writeDurability)
+		store = stateStore
+		log.Printf("Using file state storage at: %s (write durability: %s)", stateDir, writeDurability.Level())
 	case "mysql":
-		mysqlStore, err := storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
+		var err error
+		mysqlStore, err = storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
 		if err != nil {
 			log.Fatalf("Failed to initialize MySQL storage: %v", err)
 		}
 		defer mysqlStore.Close()
+		mysqlStore.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+		store = mysqlStore
 		dataStore = mysqlStore
 		log.Printf("Using MySQL storage at: %s:%d/%s", cfg.DBHost, cfg.DBPort, cfg.DBName)
 	case "dual":
@@ -58,13 +251,25 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to initialize CSV storage: %v", err)
 		}
-		log.Printf("CSV storage initialized at: %s", cfg.StoragePath)
+		if err := csvStore.SetLayout(cfg.CSVLayout); err != nil {
+			log.Fatalf("Failed to configure CSV storage: %v", err)
+		}
+		csvStore.SetFormat(csvFormat)
+		csvStore.SetDurability(writeDurability)
+		if csvEncryptionKey != nil {
+			if err := csvStore.SetEncryption(csvEncryptionKey); err != nil {
+				log.Fatalf("Failed to configure CSV encryption: %v", err)
+			}
+			log.Println("CSV data files are encrypted at rest")
+		}
+		log.Printf("CSV storage initialized at: %s (layout: %s)", cfg.StoragePath, cfg.CSVLayout)
 
-		mysqlStore, err := storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
+		mysqlStore, err = storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
 		if err != nil {
 			log.Fatalf("Failed to initialize MySQL storage: %v", err)
 		}
 		defer mysqlStore.Close()
+		mysqlStore.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
 		log.Printf("MySQL storage initialized at: %s:%d/%s", cfg.DBHost, cfg.DBPort, cfg.DBName)
 
 		// Create dual storage wrapper
@@ -72,16 +277,141 @@ func main() {
 		defer dualStore.Close()
 		dataStore = dualStore
 		log.Println("Using dual storage (CSV + MySQL)")
+
+		// States are kept in MySQL only, shared by every server using the database
+		store = mysqlStore
 	default:
 		log.Fatalf("Unsupported storage type: %s (supported: memory, csv, mysql, dual)", cfg.StorageType)
 	}
 
+	if demoEnv != nil {
+		if err := demoEnv.Seed(store, dataStore); err != nil {
+			log.Fatalf("Failed to seed demo data: %v", err)
+		}
+		log.Printf("Seeded demo state %q and sample data", demoStateName)
+	}
+
+	// Expire locks left behind by clients that died
+	if cfg.StateLockTTL > 0 {
+		expirer, ok := store.(storage.LockExpirer)
+		if !ok {
+			log.Fatalf("State lock expiry is not supported by %s storage", cfg.StorageType)
+		}
+		expirer.SetLockTTL(cfg.StateLockTTL)
+		log.Printf("State locks expire after %v without a heartbeat", cfg.StateLockTTL)
+	}
+
+	// Inject faults into storage and auth for resilience tests
+	var faultInjector *faults.Injector
+	if cfg.FaultInjectionEnabled {
+		faultInjector = faults.NewInjector()
+		for _, backend := range []interface{}{store, dataStore} {
+			if injectable, ok := backend.(storage.FaultInjectable); ok {
+				injectable.SetFaultInjector(faultInjector)
+			}
+		}
+		log.Println("WARNING: Fault injection enabled; requests may be delayed or failed on purpose (never use in production)")
+	}
+
+	// Build the HTTP clients of calls to external systems
+	outboundClients, err := outbound.New(outbound.Config{
+		Proxy:    cfg.OutboundProxy,
+		NoProxy:  cfg.OutboundNoProxy,
+		CABundle: cfg.OutboundCABundle,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure outbound HTTP: %v", err)
+	}
+	if cfg.OutboundProxy != outbound.ProxyEnvironment || cfg.OutboundCABundle != "" {
+		log.Printf("Outbound HTTP: proxy %s, CA bundle %q", cfg.OutboundProxy, cfg.OutboundCABundle)
+	}
+
+	// Initialize notification channels for operational events
+	notifyRoutes := make(map[string][]string)
+	for eventType, channels := range cfg.NotifyRoutes {
+		notifyRoutes[eventType] = notify.SplitList(channels)
+	}
+	notifier, err := notify.New(notify.Config{
+		SlackWebhookURL: cfg.NotifySlackWebhookURL,
+		WebhookURL:      cfg.NotifyWebhookURL,
+		SMTP: notify.SMTPConfig{
+			Host:     cfg.NotifySMTPHost,
+			Port:     cfg.NotifySMTPPort,
+			Username: cfg.NotifySMTPUsername,
+			Password: cfg.NotifySMTPPassword,
+			From:     cfg.NotifySMTPFrom,
+			To:       notify.SplitList(cfg.NotifySMTPTo),
+		},
+		HTTPClient: outboundClients.Client(10 * time.Second),
+		Routes:     notifyRoutes,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize notifications: %v", err)
+	}
+	defer notifier.Close()
+
+	// Start without organizations on first run; they are provisioned
+	// through the admin API
+	if cfg.AuthBootstrap {
+		created, err := auth.CreateAuthConfig(authFile)
+		if err != nil {
+			log.Fatalf("Failed to create authentication config: %v", err)
+		}
+		if created {
+			log.Printf("WARNING: %s not found, created an empty one; provision organizations with POST /admin/v1/orgs", authFile)
+		}
+	}
+
 	// Initialize credential store from auth.cfg file
-	credStore, err := auth.NewFileStore("./auth.cfg")
+	credStore, err := auth.NewFileStore(authFile)
 	if err != nil {
 		log.Fatalf("Failed to load authentication config: %v", err)
 	}
-	log.Println("Authentication credentials loaded from ./auth.cfg")
+	log.Printf("Authentication credentials loaded from %s", authFile)
+
+	if cfg.RehashOnUse {
+		if err := credStore.EnableRehash(cfg.BcryptCost); err != nil {
+			log.Fatalf("Failed to enable API key rehashing: %v", err)
+		}
+		log.Printf("Rehash-on-use enabled: keys below bcrypt cost %d are upgraded on successful validation", cfg.BcryptCost)
+	}
+
+	// Keys issued by rotation are hashed at the configured cost
+	if err := credStore.SetKeyCost(cfg.BcryptCost); err != nil {
+		log.Fatalf("Failed to set API key bcrypt cost: %v", err)
+	}
+
+	// Revoke rotated-out keys once their overlap window has ended
+	credStore.EnableAutoRevoke(time.Minute)
+	credStore.SetFaultInjector(faultInjector)
+
+	credStore.SetReloadErrorHandler(func(err error) {
+		notifier.Notify(notify.Event{
+			Type:     notify.EventReloadFailure,
+			Severity: notify.SeverityCritical,
+			Subject:  "Failed to reload " + authFile,
+			Message:  fmt.Sprintf("Automatic reload of the auth config failed; the previous credentials remain active: %v", err),
+		})
+	})
+
+	credStore.SetWatcherHandler(func(status auth.WatcherStatus) {
+		if status.Healthy {
+			notifier.Notify(notify.Event{
+				Type:     notify.EventWatcherHealth,
+				Severity: notify.SeverityInfo,
+				Subject:  "Auth config watcher restarted",
+				Message:  fmt.Sprintf("The %s file watcher was restarted and credentials were reloaded", authFile),
+				Fields:   map[string]interface{}{"restarts": status.Restarts},
+			})
+			return
+		}
+		notifier.Notify(notify.Event{
+			Type:     notify.EventWatcherHealth,
+			Severity: notify.SeverityCritical,
+			Subject:  "Auth config watcher failed",
+			Message:  fmt.Sprintf("Changes to %s are not picked up until the watcher restarts: %s", authFile, status.LastError),
+		})
+	})
 
 	// Ensure file watcher is closed on shutdown
 	defer func() {
@@ -90,75 +420,539 @@ func main() {
 		}
 	}()
 
-	// Initialize per-organization rate limiter (60 requests per minute per org)
-	orgRateLimiter := custommw.NewPerOrgRateLimiter(60)
+	// Self-test provisions ephemeral orgs into an in-memory store chained after auth.cfg
+	var authStore auth.CredentialStore = credStore
+	var selfTestCreds *auth.InMemoryStore
+	var selfTestLimiter *custommw.IPRateLimiter
+	if cfg.SelfTestEnabled {
+		selfTestCreds = auth.NewInMemoryStore()
+		authStore = auth.NewChainStore(credStore, selfTestCreds)
+		selfTestLimiter = custommw.NewIPRateLimiter(float64(cfg.SelfTestRunsPerMinute), 0, cfg.RateLimitMaxBuckets)
+		log.Println("Self-test endpoint enabled at /api/v1/selftest")
+	}
+
+	// Accept recently validated credentials while auth.cfg reloads or
+	// credential validation fail
+	var graceStore *auth.GraceStore
+	if cfg.AuthGracePeriod > 0 {
+		graceStore = auth.NewGraceStore(authStore, cfg.AuthGracePeriod)
+		graceStore.SetReadOnly(cfg.AuthGraceReadOnly)
+		graceStore.SetDegraded(func() bool {
+			return credStore.ConsecutiveReloadFailures() >= int64(cfg.AuthGraceReloadFailures)
+		})
+		authStore = graceStore
+		log.Printf("Auth grace mode enabled: credentials validated within %v keep working while the auth backend is degraded (read-only: %v)",
+			cfg.AuthGracePeriod, cfg.AuthGraceReadOnly)
+	}
+
+	// Initialize per-organization rate limiter
+	orgRateLimiter := custommw.NewPerOrgRateLimiter(float64(cfg.RateLimitRequestsPerMinute))
+	if cfg.RateLimitBurst > 0 {
+		orgRateLimiter.SetBurst(float64(cfg.RateLimitBurst))
+	}
+	if cfg.RateLimitUploadRequestsPerMinute > 0 {
+		orgRateLimiter.SetTier(custommw.TierUpload, float64(cfg.RateLimitUploadRequestsPerMinute), float64(cfg.RateLimitUploadBurst))
+		log.Printf("Uploads limited to %d req/min per org", cfg.RateLimitUploadRequestsPerMinute)
+	}
+	if cfg.RateLimitStateRequestsPerMinute > 0 {
+		orgRateLimiter.SetTier(custommw.TierState, float64(cfg.RateLimitStateRequestsPerMinute), float64(cfg.RateLimitStateBurst))
+		log.Printf("State requests limited to %d req/min per org", cfg.RateLimitStateRequestsPerMinute)
+	}
+	orgRateLimiter.SetMaxBuckets(cfg.RateLimitMaxBuckets)
 	defer orgRateLimiter.Stop()
-	log.Println("Per-organization rate limiter initialized (60 req/min per org)")
+	log.Printf("Per-organization rate limiter initialized (%d req/min per org, burst %d)",
+		cfg.RateLimitRequestsPerMinute, int(orgRateLimiter.Burst()))
+
+	// Limit each client IP before authentication
+	var ipRateLimiter *custommw.IPRateLimiter
+	if cfg.RateLimitIPRequestsPerMinute > 0 {
+		ipRateLimiter = custommw.NewIPRateLimiter(float64(cfg.RateLimitIPRequestsPerMinute), float64(cfg.RateLimitIPBurst), cfg.RateLimitMaxBuckets)
+		log.Printf("Per-IP rate limiter initialized (%d req/min per client IP)", cfg.RateLimitIPRequestsPerMinute)
+	}
 
-	// Initialize handlers
-	var stateHandler *handlers.StateHandler
-	var uploadHandler *handlers.UploadHandler
+	// Load per-organization rate limit exemptions and multipliers
+	rateLimitOverrides, err := ratelimit.NewStore(cfg.RateLimitOverridesFile)
+	if err != nil {
+		log.Fatalf("Failed to load rate limit overrides: %v", err)
+	}
+	orgRateLimiter.SetOverrides(rateLimitOverrides)
 
-	if store != nil {
-		stateHandler = handlers.NewStateHandler(store)
+	// Initialize soft quota warnings
+	var quotaChecker *quota.Checker
+	if cfg.QuotaWarningsEnabled {
+		sizer, _ := dataStore.(storage.SizeReporter)
+		quotaChecker = quota.NewChecker(quota.Config{
+			StorageSoftLimit: cfg.QuotaStorageLimit,
+			WarnRatio:        cfg.QuotaWarnRatio,
+			NotifyCooldown:   cfg.QuotaNotifyCooldown,
+		}, orgRateLimiter, sizer, notifier)
+		log.Printf("Soft quota warnings enabled at %.0f%% of quota", cfg.QuotaWarnRatio*100)
 	}
-	if dataStore != nil {
-		uploadHandler = handlers.NewUploadHandler(dataStore)
+
+	// Initialize daily ingest caps
+	var ingestCaps *quota.IngestCaps
+	if cfg.QuotaDailyIngestBytes > 0 || cfg.QuotaDailyIngestRows > 0 {
+		ingestCaps, err = quota.NewIngestCaps(quota.IngestConfig{
+			MaxBytes: cfg.QuotaDailyIngestBytes,
+			MaxRows:  cfg.QuotaDailyIngestRows,
+		}, cfg.QuotaIngestFile, time.Minute)
+		if err != nil {
+			log.Fatalf("Failed to initialize daily ingest caps: %v", err)
+		}
+		defer func() {
+			if err := ingestCaps.Close(); err != nil {
+				log.Printf("Error persisting ingest counters: %v", err)
+			}
+		}()
+		log.Printf("Daily ingest caps enabled (bytes: %d, rows: %d), counters persisted to %s", cfg.QuotaDailyIngestBytes, cfg.QuotaDailyIngestRows, cfg.QuotaIngestFile)
 	}
-	healthHandler := handlers.NewHealthHandler(version)
 
-	// Setup router
-	r := chi.NewRouter()
-
-	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	// Security: Limit request body size to prevent DoS attacks
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Set maximum request body size to 10MB
-			r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
-			next.ServeHTTP(w, r)
+	// Initialize usage accounting for billing exports
+	var usageMeter *usage.Meter
+	if cfg.BillingEnabled {
+		usageMeter, err = usage.NewMeter(cfg.BillingUsageFile, time.Minute)
+		if err != nil {
+			log.Fatalf("Failed to initialize usage meter: %v", err)
+		}
+		defer func() {
+			if err := usageMeter.Close(); err != nil {
+				log.Printf("Error persisting usage counters: %v", err)
+			}
+		}()
+		log.Printf("Usage accounting enabled, counters persisted to %s", cfg.BillingUsageFile)
+	}
+
+	// Record uploads and state changes for the change feed
+	var changeLog *changes.Log
+	if cfg.ChangeLogEnabled {
+		changeLog, err = changes.Open(cfg.ChangeLogFile, cfg.ChangeLogRetention)
+		if err != nil {
+			log.Fatalf("Failed to open change log: %v", err)
+		}
+		defer func() {
+			if err := changeLog.Close(); err != nil {
+				log.Printf("Error closing change log: %v", err)
+			}
+		}()
+		log.Printf("Change feed enabled at GET /api/v1/changes, persisted to %s", cfg.ChangeLogFile)
+	}
+
+	// Record state reads, writes and locks for audit exports
+	var auditLog *audit.Log
+	if cfg.AuditEnabled {
+		auditLog, err = audit.Open(audit.Config{
+			Dir:       cfg.AuditDir,
+			Retention: cfg.AuditRetention,
+			DropDir:   cfg.AuditDropDir,
 		})
-	})
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		auditLog.Start(time.Hour)
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				log.Printf("Error closing audit log: %v", err)
+			}
+		}()
+		log.Printf("State access audit log enabled in %s", cfg.AuditDir)
+		if cfg.AuditDropDir != "" {
+			log.Printf("Completed days of the audit log are dropped to %s", cfg.AuditDropDir)
+		}
+	}
 
-	// Security: Limit concurrent requests to prevent resource exhaustion
-	r.Use(middleware.Throttle(100))
+	// Load per-organization upload allowlists
+	taxonomyStore, err := taxonomy.NewStore(cfg.TaxonomyFile)
+	if err != nil {
+		log.Fatalf("Failed to load upload taxonomy: %v", err)
+	}
 
-	// Health check endpoint (no auth required)
-	r.Get("/health", healthHandler.Check)
+	// Load per-organization attribute normalization rules
+	normalizer, err := normalize.NewStore(cfg.NormalizationFile)
+	if err != nil {
+		log.Fatalf("Failed to load normalization rules: %v", err)
+	}
+
+	// Load the transformation hooks run on accepted uploads
+	var uploadHooks *ingesthook.Runner
+	if cfg.UploadHooksFile != "" {
+		uploadHooks, err = ingesthook.LoadFile(cfg.UploadHooksFile, cfg.UploadHookTimeout)
+		if err != nil {
+			log.Fatalf("Failed to load upload hooks: %v", err)
+		}
+		log.Printf("Upload hooks enabled: %s", strings.Join(uploadHooks.Names(), ", "))
+	}
 
-	// Protected routes with authentication
-	r.Route("/api/v1", func(r chi.Router) {
-		// Apply authentication middleware
-		r.Use(auth.Middleware(credStore))
+	// Cache data query responses until the organization's data changes
+	var queryCache *querycache.Cache
+	if cfg.QueryCacheEnabled {
+		queryCache = querycache.New(querycache.Config{MaxBytes: cfg.QueryCacheMaxBytes, TTL: cfg.QueryCacheTTL})
+		log.Printf("Query cache enabled (%d bytes, TTL %v)", cfg.QueryCacheMaxBytes, cfg.QueryCacheTTL)
+	}
 
-		// Apply per-organization rate limiting (after auth so we have org ID)
-		r.Use(custommw.RateLimitMiddleware(orgRateLimiter))
+	// Keep rejected uploads so data from misbehaving clients can be recovered
+	var quarantineStore *quarantine.Store
+	if cfg.QuarantineEnabled {
+		quarantineStore, err = quarantine.Open(quarantine.Config{
+			Dir:       cfg.QuarantineDir,
+			MaxBytes:  cfg.QuarantineMaxBytes,
+			Retention: cfg.QuarantineRetention,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open quarantine: %v", err)
+		}
+		entries, bytes := quarantineStore.Stats()
+		log.Printf("Upload quarantine enabled in %s (%d entries, %d bytes)", cfg.QuarantineDir, entries, bytes)
+	}
 
-		// Data upload endpoints (for Terraform provider)
-		if uploadHandler != nil {
-			r.Post("/upload", uploadHandler.UploadData)
-			r.Get("/data", uploadHandler.GetOrgData)
+	// Maintain daily rollups so summaries do not scan the raw data
+	var rollups *rollup.Builder
+	if cfg.RollupEnabled {
+		sumFields, err := storage.ParseFields(cfg.RollupSumFields)
+		if err != nil {
+			log.Fatalf("Invalid rollup sum fields: %v", err)
 		}
+		rollups, err = rollup.NewBuilder(dataStore, rollup.Config{
+			Dir:       cfg.RollupDir,
+			Interval:  cfg.RollupInterval,
+			SumFields: sumFields,
+			Settle:    time.Minute,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize rollups: %v", err)
+		}
+		if queryCache != nil {
+			// Summaries served from rollups change when they are rebuilt
+			rollups.SetOnBuild(queryCache.Invalidate)
+		}
+		rollups.Start()
+		defer rollups.Close()
+		log.Printf("Daily rollups enabled in %s (interval %v)", cfg.RollupDir, cfg.RollupInterval)
+	}
 
-		// State management endpoints (if using memory storage)
-		if stateHandler != nil {
-			// Terraform backend API endpoints
-			r.Route("/state/{name}", func(r chi.Router) {
-				r.Get("/", stateHandler.GetState)
-				r.Post("/", stateHandler.PutState)
-				r.Delete("/", stateHandler.DeleteState)
-			})
+	// Announce deprecated APIs and record who still uses them
+	var deprecations *deprecation.Tracker
+	if rules, err := deprecation.ParseRules(cfg.Deprecations); err != nil {
+		log.Fatalf("Invalid deprecations: %v", err)
+	} else if len(rules) > 0 {
+		deprecations = deprecation.NewTracker(rules)
+		for _, rule := range rules {
+			log.Printf("Deprecated API: %s", rule)
+		}
+	}
 
-			// Lock endpoints
-			r.Post("/state/{name}/lock", stateHandler.LockState)
-			r.Delete("/state/{name}/lock", stateHandler.UnlockState)
+	// Gate experimental endpoints behind feature flags
+	featureFlags, err := features.Parse(cfg.Features)
+	if err != nil {
+		log.Fatalf("Invalid feature flags: %v", err)
+	}
+	log.Printf("Feature flags enabled: %s", strings.Join(featureFlags.List(), ", "))
+
+	// Record which client versions are in use before breaking changes
+	clientVersions := clients.NewTracker()
+
+	// Serialize concurrent writes of the same state
+	stateWrites := statequeue.New(statequeue.Config{MaxQueue: cfg.StateWriteQueue, QueueTimeout: cfg.StateWriteQueueTimeout})
+
+	// Issue session tokens so bcrypt runs once per session, not per request
+	var tokenIssuer *auth.TokenIssuer
+	if cfg.SessionTokensEnabled {
+		if cfg.SessionTokenSecret == "" {
+			log.Println("WARNING: SESSION_TOKEN_SECRET is not set, session tokens are only valid on this instance until restart")
+		}
+		tokenIssuer, err = auth.NewTokenIssuer([]byte(cfg.SessionTokenSecret), cfg.SessionTokenTTL)
+		if err != nil {
+			log.Fatalf("Failed to initialize session tokens: %v", err)
+		}
+		log.Printf("Session tokens enabled at POST /api/v1/token (TTL %v)", cfg.SessionTokenTTL)
+	}
+
+	// Sign download URLs so states and exports can be shared without a key
+	var downloadSigner *download.Signer
+	if cfg.DownloadURLsEnabled {
+		if cfg.DownloadURLSecret == "" {
+			log.Println("WARNING: DOWNLOAD_URL_SECRET is not set, download URLs are only valid on this instance until restart")
+		}
+		downloadSigner, err = download.NewSigner([]byte(cfg.DownloadURLSecret), cfg.DownloadURLMaxTTL)
+		if err != nil {
+			log.Fatalf("Failed to initialize download URLs: %v", err)
+		}
+		log.Printf("Signed download URLs enabled at POST /api/v1/downloads (max TTL %v)", cfg.DownloadURLMaxTTL)
+	}
+
+	// Reject replayed state-changing requests captured from logs
+	var replayGuard *auth.ReplayGuard
+	if cfg.ReplayProtection {
+		replayGuard = auth.NewReplayGuard(cfg.ReplayWindow)
+		defer replayGuard.Stop()
+		log.Printf("Replay protection enabled (window %v)", cfg.ReplayWindow)
+	}
+
+	// Lock out clients guessing API keys
+	var authLockout *auth.FailureTracker
+	if cfg.AuthLockoutThreshold > 0 {
+		authLockout = auth.NewFailureTracker(cfg.AuthLockoutThreshold, cfg.AuthLockoutWindow, cfg.AuthLockoutDuration, cfg.AuthLockoutMaxDuration, cfg.AuthLockoutMaxTracked)
+		defer authLockout.Stop()
+		log.Printf("Auth lockout enabled (%d failures within %v, lockout %v up to %v)",
+			cfg.AuthLockoutThreshold, cfg.AuthLockoutWindow, cfg.AuthLockoutDuration, cfg.AuthLockoutMaxDuration)
+	}
+
+	// Evaluate authorization policies with OPA after authentication
+	var policyEvaluator policy.Evaluator
+	if cfg.PolicyOPAURL != "" {
+		opaClient, err := policy.NewOPAClient(cfg.PolicyOPAURL, cfg.PolicyDecisionPath, cfg.PolicyTimeout)
+		if err != nil {
+			log.Fatalf("Failed to initialize policy evaluation: %v", err)
+		}
+		opaClient.SetTransport(outboundClients.Transport())
+		if cfg.PolicyDir != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			loaded, err := opaClient.LoadPolicies(ctx, cfg.PolicyDir)
+			cancel()
+			if err != nil {
+				log.Fatalf("Failed to load policies from %s: %v", cfg.PolicyDir, err)
+			}
+			log.Printf("Loaded %d policies from %s into OPA", loaded, cfg.PolicyDir)
 		}
+		policyEvaluator = opaClient
+		log.Printf("Authorization policies enabled (OPA %s, decision %s, fail-open: %v)", cfg.PolicyOPAURL, cfg.PolicyDecisionPath, cfg.PolicyFailOpen)
+	}
+
+	// Only proxies in this list may set the client IP
+	// Serve only the configured endpoints without authentication
+	publicEndpoints, err := public.Parse(cfg.PublicEndpoints)
+	if err != nil {
+		log.Fatalf("Invalid public endpoints: %v", err)
+	}
+	log.Printf("Public endpoints: %s", strings.Join(publicEndpoints.List(), ", "))
+
+	trustedProxies, err := custommw.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	if len(trustedProxies) > 0 {
+		log.Printf("Honoring forwarding headers from trusted proxies: %s", cfg.TrustedProxies)
+	}
+
+	// Resolve client countries and enforce the country lists
+	var geoIP *geoip.Enforcer
+	if cfg.GeoIPDatabase != "" {
+		db, err := geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			log.Fatalf("Failed to load GeoIP database: %v", err)
+		}
+		// Both lists were validated with the configuration
+		allow, _ := geoip.ParseCountries(cfg.GeoIPAllowCountries)
+		deny, _ := geoip.ParseCountries(cfg.GeoIPDenyCountries)
+		orgLists, err := geoip.NewStore(cfg.GeoIPListsFile)
+		if err != nil {
+			log.Fatalf("Failed to load country lists: %v", err)
+		}
+		geoIP = geoip.NewEnforcer(db, geoip.Lists{Allow: allow, Deny: deny}, orgLists, cfg.GeoIPAllowUnknown)
+		log.Printf("GeoIP enabled with %d ranges from %s (allow: %v, deny: %v)", db.Len(), cfg.GeoIPDatabase, allow, deny)
+	}
+
+	// Initialize Prometheus metrics labeled with the storage backend
+	var serverMetrics *metrics.Metrics
+	if cfg.MetricsEnabled {
+		serverMetrics = metrics.New(cfg.StorageType)
+		serverMetrics.ObserveRateLimiter(orgRateLimiter)
+		if authLockout != nil {
+			serverMetrics.ObserveAuthLockout(authLockout)
+		}
+		if mysqlStore != nil {
+			serverMetrics.ObserveDBPool(mysqlStore)
+		}
+		credStore.SetCompareObserver(serverMetrics.ObserveKeyCompare)
+		if cfg.MetricsAddress != "" {
+			log.Printf("Prometheus metrics enabled at http://%s/metrics", cfg.MetricsAddress)
+		} else {
+			log.Println("Prometheus metrics enabled at /metrics")
+		}
+	}
+
+	// Probe the storage backend so /ready reports degraded disks or databases
+	storageMonitor := health.NewMonitor(health.Config{
+		Interval:         cfg.ProbeInterval,
+		Timeout:          cfg.ProbeTimeout,
+		FailureThreshold: cfg.ProbeFailureThreshold,
+		LatencyThreshold: cfg.ProbeLatencyThreshold,
+	}, probeObserver(serverMetrics))
+	if prober, ok := store.(storage.Prober); ok {
+		storageMonitor.Add(cfg.StorageType, prober)
+	}
+	if prober, ok := dataStore.(storage.Prober); ok {
+		storageMonitor.Add(cfg.StorageType, prober)
+	}
+	storageMonitor.Start()
+	defer storageMonitor.Stop()
+	log.Printf("Storage health probes enabled (every %v, readiness at /ready)", cfg.ProbeInterval)
+
+	// Initialize load shedding (state lock/unlock is always admitted)
+	loadShedder := custommw.NewLoadShedder(custommw.LoadShedConfig{
+		MaxInFlight:    cfg.MaxInFlight,
+		MaxQueue:       cfg.MaxQueue,
+		QueueTimeout:   cfg.QueueTimeout,
+		ShedInFlight:   cfg.ShedInFlight,
+		ShedQueueDepth: cfg.ShedQueueDepth,
+		RetryAfter:     cfg.ShedRetryAfter,
+	}, custommw.ClassifyRequest)
+	log.Printf("Load shedding enabled (max %d in-flight, shedding low-priority traffic at %d)", cfg.MaxInFlight, cfg.ShedInFlight)
+
+	// Per-route-group request timeouts
+	routeTimeouts := custommw.RouteTimeouts{
+		Default: cfg.TimeoutDefault,
+		State:   cfg.TimeoutState,
+		Upload:  cfg.TimeoutUpload,
+		Export:  cfg.TimeoutExport,
+		Admin:   cfg.TimeoutAdmin,
+	}
+
+	// Record sanitized requests of orgs being debugged
+	var requestRecorder *recorder.Recorder
+	if cfg.RecordingEnabled {
+		requestRecorder = recorder.New(cfg.RecordingBufferSize, cfg.RecordingMaxBody)
+		log.Printf("Request recording enabled (buffer %d, bodies up to %d bytes)", cfg.RecordingBufferSize, cfg.RecordingMaxBody)
+	}
+
+	// Subsystem counters for GET /admin/v1/runtime
+	runtimeStats := runtimestats.NewRegistry()
+	runtimeStats.Register("auth", func() map[string]int64 {
+		orgs, reloads, failures := credStore.Stats()
+		watcher, _ := credStore.WatcherStatus()
+		stats := map[string]int64{"orgs": int64(orgs), "watcher_reloads": reloads, "watcher_reload_failures": failures, "watcher_restarts": watcher.Restarts}
+		if graceStore != nil {
+			entries, accepted := graceStore.Stats()
+			stats["grace_entries"] = int64(entries)
+			stats["grace_accepted"] = accepted
+		}
+		return stats
+	})
+	runtimeStats.Register("clients", func() map[string]int64 {
+		return map[string]int64{"versions": int64(clientVersions.Stats())}
+	})
+	runtimeStats.Register("state_writes", func() map[string]int64 {
+		active, queued, conflicts, rejected := stateWrites.Stats()
+		return map[string]int64{"active": int64(active), "queued": int64(queued), "conflicts": conflicts, "rejected": rejected}
+	})
+	runtimeStats.Register("ratelimit", func() map[string]int64 {
+		buckets, evictions, rejections := orgRateLimiter.Stats()
+		return map[string]int64{"buckets": int64(buckets), "evictions": evictions, "rejections": rejections}
+	})
+	if ipRateLimiter != nil {
+		runtimeStats.Register("ip_ratelimit", func() map[string]int64 {
+			buckets, rejections := ipRateLimiter.Stats()
+			return map[string]int64{"buckets": int64(buckets), "rejections": rejections}
+		})
+	}
+	if dualStore, ok := dataStore.(*storage.DualStorage); ok {
+		runtimeStats.Register("dual", func() map[string]int64 {
+			csvMissing, mysqlMissing := dualStore.Divergence()
+			return map[string]int64{"orgs_csv_missing": int64(csvMissing), "orgs_mysql_missing": int64(mysqlMissing)}
+		})
+	}
+	if mysqlStore != nil {
+		runtimeStats.Register("mysql", func() map[string]int64 {
+			statements, hits, misses, tables := mysqlStore.Stats()
+			pool := mysqlStore.PoolStats()
+			return map[string]int64{
+				"cached_statements": int64(statements), "statement_hits": hits, "statement_misses": misses, "cached_tables": int64(tables),
+				"open_connections": int64(pool.OpenConnections), "in_use_connections": int64(pool.InUse), "idle_connections": int64(pool.Idle),
+				"wait_count": pool.WaitCount, "wait_duration_ms": pool.WaitDuration.Milliseconds(),
+			}
+		})
+	}
+	if rollups != nil {
+		runtimeStats.Register("rollup", func() map[string]int64 {
+			orgs, builds, failures := rollups.Stats()
+			return map[string]int64{"orgs": int64(orgs), "builds": builds, "build_failures": failures}
+		})
+	}
+	if queryCache != nil {
+		runtimeStats.Register("query_cache", func() map[string]int64 {
+			entries, bytes, hits, misses := queryCache.Stats()
+			return map[string]int64{"entries": int64(entries), "bytes": bytes, "hits": hits, "misses": misses}
+		})
+	}
+	if uploadHooks != nil {
+		runtimeStats.Register("upload_hooks", uploadHooks.Stats)
+	}
+	if quarantineStore != nil {
+		runtimeStats.Register("quarantine", func() map[string]int64 {
+			entries, bytes := quarantineStore.Stats()
+			return map[string]int64{"entries": int64(entries), "bytes": bytes}
+		})
+	}
+	if cfg.StorageType == "csv" || cfg.StorageType == "dual" {
+		runtimeStats.Register("durability", func() map[string]int64 {
+			syncs, failures, pending := writeDurability.Stats()
+			return map[string]int64{"syncs": syncs, "sync_failures": failures, "pending_syncs": int64(pending)}
+		})
+	}
+	if replayGuard != nil {
+		runtimeStats.Register("replay", func() map[string]int64 {
+			return map[string]int64{"nonces": int64(replayGuard.Size())}
+		})
+	}
+	if authLockout != nil {
+		runtimeStats.Register("auth_lockout", func() map[string]int64 {
+			tracked, locked, lockouts, rejections := authLockout.Stats()
+			return map[string]int64{"tracked": int64(tracked), "locked": int64(locked), "lockouts": lockouts, "rejections": rejections}
+		})
+	}
+
+	// Setup router
+	r := server.NewRouter(server.Options{
+		Version:             version,
+		Credentials:         authStore,
+		StateStorage:        store,
+		DataStorage:         dataStore,
+		RateLimiter:         orgRateLimiter,
+		IPRateLimiter:       ipRateLimiter,
+		RateLimitOverrides:  rateLimitOverrides,
+		UsageMeter:          usageMeter,
+		SelfTestCredentials: selfTestCreds,
+		SelfTestLimiter:     selfTestLimiter,
+		AdminAPIKey:         cfg.AdminAPIKey,
+		KeyStore:            credStore,
+		Metrics:             serverMetrics,
+		SeparateMetrics:     cfg.MetricsAddress != "",
+		LoadShedder:         loadShedder,
+		QuotaChecker:        quotaChecker,
+		IngestCaps:          ingestCaps,
+		Timeouts:            routeTimeouts,
+		MaxClockSkew:        cfg.MaxClockSkew,
+		MaxObservationAge:   cfg.MaxObservationAge,
+		Taxonomy:            taxonomyStore,
+		Normalizer:          normalizer,
+		Rollups:             rollups,
+		StorageMonitor:      storageMonitor,
+		Changes:             changeLog,
+		Audit:               auditLog,
+		Tokens:              tokenIssuer,
+		Downloads:           downloadSigner,
+		ReplayGuard:         replayGuard,
+		AuthLockout:         authLockout,
+		Policy:              policyEvaluator,
+		PolicyFailOpen:      cfg.PolicyFailOpen,
+		GeoIP:               geoIP,
+		TrustedProxies:      trustedProxies,
+		FullSyncConcurrency: cfg.FullSyncMaxConcurrent,
+		FullSyncInterval:    cfg.FullSyncChunkInterval,
+		MaxStateSize:        cfg.MaxStateSize,
+		CSVFormat:           csvFormat,
+		Runtime:             runtimeStats,
+		Config:              effectiveConfig,
+		ConfigFile:          cfg.File(),
+		Recorder:            requestRecorder,
+		Faults:              faultInjector,
+		Deprecations:        deprecations,
+		Clients:             clientVersions,
+		StateWrites:         stateWrites,
+		QueryCache:          queryCache,
+		Quarantine:          quarantineStore,
+		UploadHooks:         uploadHooks,
+		Features:            featureFlags,
+		Public:              publicEndpoints,
 	})
 
 	// Create HTTP server
@@ -186,7 +980,30 @@ func main() {
 		}
 	}()
 
+	// Serve /metrics on its own listener, so scrapes can be kept off the
+	// public API port
+	var metricsSrv *http.Server
+	if serverMetrics != nil && cfg.MetricsAddress != "" {
+		metricsSrv = &http.Server{
+			Addr:         cfg.MetricsAddress,
+			Handler:      serverMetrics.ListenerHandler(),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
+
 	log.Println("Server started successfully")
+	if demoEnv != nil {
+		if err := demoEnv.PrintSnippets(os.Stdout); err != nil {
+			log.Printf("Error printing demo config: %v", err)
+		}
+	}
 	log.Println("Press Ctrl+C to stop")
 
 	// Wait for interrupt signal to gracefully shutdown the server
@@ -203,6 +1020,19 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down metrics server: %v", err)
+		}
+	}
 
 	log.Println("Server stopped")
 }
+
+// probeObserver exports storage probe results when metrics are enabled
+func probeObserver(m *metrics.Metrics) health.Observer {
+	if m == nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/csvcrypt"
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// migrationBackend is a storage backend migrated from or to, opened with
+// the settings of backend_service.cfg
+type migrationBackend struct {
+	data   storage.DataStorage
+	states storage.Storage
+	close  func() error
+}
+
+// runMigrateStorage implements `server migrate-storage`, which copies the
+// data rows and states of every organization (or one) from one storage
+// backend to another and verifies them. Rows and states already in the
+// destination are skipped, so an interrupted migration is resumed by
+// running it again.
+func runMigrateStorage(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	from := fs.String("from", "", "backend to migrate from: csv or mysql")
+	to := fs.String("to", "", "backend to migrate to: csv or mysql")
+	orgFlag := fs.String("org", "", "only migrate this organization (UUID)")
+	batchSize := fs.Int("batch-size", storage.DefaultMigrationBatchSize, "number of rows imported at a time")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *from != "csv" && *from != "mysql" {
+		return fmt.Errorf("--from must be csv or mysql, got %q", *from)
+	}
+	if *to != "csv" && *to != "mysql" {
+		return fmt.Errorf("--to must be csv or mysql, got %q", *to)
+	}
+	if *from == *to {
+		return fmt.Errorf("--from and --to must be different backends")
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	orgID := uuid.Nil
+	if *orgFlag != "" {
+		var err error
+		orgID, err = uuid.Parse(*orgFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --org: %w", err)
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	source, err := openMigrationBackend(*from, cfg)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	destination, err := openMigrationBackend(*to, cfg)
+	if err != nil {
+		return err
+	}
+	defer destination.close()
+
+	migrator := &storage.Migrator{
+		FromData:   source.data,
+		ToData:     destination.data,
+		FromStates: source.states,
+		ToStates:   destination.states,
+		BatchSize:  *batchSize,
+	}
+	fmt.Fprintf(stdout, "Migrating storage from %s to %s\n", *from, *to)
+	return migrate(migrator, orgID, stdout)
+}
+
+// openMigrationBackend opens CSV storage (with file state storage in its
+// states directory) or MySQL storage as the server would
+func openMigrationBackend(kind string, cfg *config.Config) (*migrationBackend, error) {
+	if kind == "mysql" {
+		mysqlStore, err := storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MySQL storage: %w", err)
+		}
+		return &migrationBackend{data: mysqlStore, states: mysqlStore, close: mysqlStore.Close}, nil
+	}
+
+	csvStore, err := storage.NewCSVStorage(cfg.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize CSV storage: %w", err)
+	}
+	if err := csvStore.SetLayout(cfg.CSVLayout); err != nil {
+		return nil, err
+	}
+	format, err := csvfmt.Parse(cfg.CSVDelimiter, cfg.CSVQuote, cfg.CSVBOM)
+	if err != nil {
+		return nil, err
+	}
+	csvStore.SetFormat(format)
+	if cfg.CSVEncryptionKeyFile != "" {
+		key, err := csvcrypt.LoadKeyFile(cfg.CSVEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := csvStore.SetEncryption(key); err != nil {
+			return nil, err
+		}
+	}
+	stateStore, err := storage.NewFileStateStorage(filepath.Join(cfg.StoragePath, "states"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize state storage: %w", err)
+	}
+	return &migrationBackend{data: csvStore, states: stateStore, close: func() error { return nil }}, nil
+}
+
+// migrate migrates one organization, or all of the source's if orgID is
+// uuid.Nil, printing progress to stdout. It carries on past organizations
+// that fail and reports how many did at the end.
+func migrate(migrator *storage.Migrator, orgID uuid.UUID, stdout io.Writer) error {
+	orgIDs := []uuid.UUID{orgID}
+	if orgID == uuid.Nil {
+		var err error
+		orgIDs, err = migrator.Orgs()
+		if err != nil {
+			return err
+		}
+	}
+	migrator.Progress = func(p storage.MigrationProgress) {
+		unit := "row(s)"
+		if p.Kind == "states" {
+			unit = "state(s)"
+		}
+		fmt.Fprintf(stdout, "  %d/%d %s\n", p.Done, p.Total, unit)
+	}
+
+	failed := 0
+	var rows, states int
+	for i, orgID := range orgIDs {
+		fmt.Fprintf(stdout, "[%d/%d] org %s\n", i+1, len(orgIDs), orgID)
+		result, err := migrator.MigrateOrg(orgID)
+		if err != nil {
+			fmt.Fprintf(stdout, "  ERROR: org %s: %v\n", orgID, err)
+			failed++
+			continue
+		}
+		for _, name := range result.LockedStates {
+			fmt.Fprintf(stdout, "  WARNING: state %q is locked in the source; the lock was not migrated\n", name)
+		}
+		fmt.Fprintf(stdout, "  copied %d of %d row(s) and %d of %d state(s), verified\n",
+			result.RowsCopied, result.Rows, result.StatesCopied, result.States)
+		rows += result.RowsCopied
+		states += result.StatesCopied
+	}
+
+	fmt.Fprintf(stdout, "Migrated %d organization(s): %d row(s) and %d state(s) copied\n", len(orgIDs)-failed, rows, states)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d organization(s) failed to migrate; run the command again to resume", failed, len(orgIDs))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestRunMigrateStorageFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--to", "mysql"}, "--from must be csv or mysql"},
+		{[]string{"--from", "csv", "--to", "memory"}, "--to must be csv or mysql"},
+		{[]string{"--from", "csv", "--to", "csv"}, "must be different"},
+		{[]string{"--from", "csv", "--to", "mysql", "--org", "nope"}, "invalid --org"},
+		{[]string{"--from", "csv", "--to", "mysql", "--batch-size", "0"}, "--batch-size"},
+	}
+	for _, tt := range tests {
+		err := runMigrateStorage(tt.args, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: expected error containing %q, got %v", tt.args, tt.want, err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	csvStore, err := storage.NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCSVStorage failed: %v", err)
+	}
+	orgs := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, orgID := range orgs {
+		csvStore.AppendData(orgID, map[string]interface{}{"count": 1})
+	}
+
+	migrator := &storage.Migrator{FromData: csvStore, ToData: storage.NewMemoryDataStorage()}
+	var stdout bytes.Buffer
+	if err := migrate(migrator, uuid.Nil, &stdout); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Migrated 2 organization(s): 2 row(s) and 0 state(s) copied") {
+		t.Errorf("Unexpected output:\n%s", stdout.String())
+	}
+
+	// Running again for one organization copies nothing
+	stdout.Reset()
+	if err := migrate(migrator, orgs[0], &stdout); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "copied 0 of 1 row(s)") {
+		t.Errorf("Unexpected output:\n%s", stdout.String())
+	}
+}
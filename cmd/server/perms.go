@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/fsperm"
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// checkPermissions verifies that auth.cfg, the data directory, the TLS key
+// and the CSV encryption key are not exposed to other local users. With fix set the offending
+// bits are removed; otherwise insecure permissions stop startup in enforce
+// mode and are logged in warn mode.
+func checkPermissions(cfg *config.Config, fix bool) error {
+	if cfg.PermissionCheck == fsperm.ModeOff && !fix {
+		return nil
+	}
+
+	rules := []fsperm.Rule{
+		fsperm.AuthConfig("./auth.cfg"),
+		fsperm.DataDir(cfg.StoragePath),
+	}
+	if cfg.EnableTLS {
+		rules = append(rules, fsperm.PrivateKey(cfg.KeyFile))
+	}
+	if cfg.CSVEncryptionKeyFile != "" {
+		rules = append(rules, fsperm.PrivateKey(cfg.CSVEncryptionKeyFile))
+	}
+
+	problems, err := fsperm.Check(rules)
+	if err != nil || len(problems) == 0 {
+		return err
+	}
+
+	if fix {
+		if err := fsperm.Fix(problems); err != nil {
+			return err
+		}
+		for _, p := range problems {
+			slog.Info("Fixed file permissions", "path", p.Path, "mode", fmt.Sprintf("%04o", p.Mode), "fixed", fmt.Sprintf("%04o", p.Fixed()))
+		}
+		return nil
+	}
+
+	for _, p := range problems {
+		security.Emit(security.Event{
+			Name:     "permissions.insecure",
+			Severity: security.SeverityWarning,
+			Message:  "Insecure file permissions",
+		}.With("path", p.Path).With("mode", p.Mode.String()).With("reason", p.Reason))
+	}
+	if cfg.PermissionCheck == fsperm.ModeEnforce {
+		return fsperm.Error(problems)
+	}
+	for _, p := range problems {
+		slog.Warn("Insecure file permissions; restart with --fix-perms to fix", "path", p.Path, "mode", p.Mode.String(), "reason", p.Reason)
+	}
+	return nil
+}
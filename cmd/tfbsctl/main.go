@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/csvcrypt"
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "normalize-csv":
+		err = runNormalizeCSV(os.Args[2:])
+	case "fsck-states":
+		err = runFsckStates(os.Args[2:])
+	case "verify-storage":
+		err = runVerifyStorage(os.Args[2:])
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+// usage prints the list of available commands
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: tfbsctl <command> [flags]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  normalize-csv   Rewrite CSV data files in wide layout with complete headers\n")
+	fmt.Fprintf(os.Stderr, "  fsck-states     Verify stored state files and report corrupt or orphaned files\n")
+	fmt.Fprintf(os.Stderr, "  verify-storage  Compare an organization's rows between the CSV and MySQL sides of dual storage\n")
+}
+
+// runNormalizeCSV rewrites historical CSV files so their headers match the
+// per-org header manifests, padding old rows and converting JSON-layout rows.
+// Rewritten files use the dialect given by --delimiter, --quote and --bom.
+func runNormalizeCSV(args []string) error {
+	fs := flag.NewFlagSet("normalize-csv", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "./data", "CSV storage directory")
+	orgFlag := fs.String("org", "", "Only normalize this organization (UUID)")
+	delimiter := fs.String("delimiter", "comma", "Delimiter of rewritten files: comma, semicolon, tab or pipe")
+	quote := fs.String("quote", "minimal", "Quoting of rewritten files: minimal or all")
+	bom := fs.Bool("bom", false, "Write a UTF-8 byte order mark at the start of rewritten files")
+	keyFile := fs.String("encryption-key-file", "", "Master key file of encrypted CSV storage (rewritten files are encrypted)")
+	fs.Parse(args)
+
+	format, err := csvfmt.Parse(*delimiter, *quote, *bom)
+	if err != nil {
+		return err
+	}
+
+	csvStore, err := storage.NewCSVStorage(*dataDir)
+	if err != nil {
+		return err
+	}
+	csvStore.SetFormat(format)
+	if *keyFile != "" {
+		key, err := csvcrypt.LoadKeyFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		if err := csvStore.SetEncryption(key); err != nil {
+			return err
+		}
+	}
+
+	var orgIDs []uuid.UUID
+	if *orgFlag != "" {
+		orgID, err := uuid.Parse(*orgFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --org: %w", err)
+		}
+		orgIDs = []uuid.UUID{orgID}
+	} else {
+		orgIDs, err = csvStore.ListOrgs()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Normalizing %d CSV file(s) in %s", len(orgIDs), *dataDir)
+
+	failed := 0
+	for _, orgID := range orgIDs {
+		if err := csvStore.NormalizeHeaders(orgID); err != nil {
+			log.Printf("ERROR: Failed to normalize org %s: %v", orgID, err)
+			failed++
+			continue
+		}
+		log.Printf("Normalized org %s", orgID)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) could not be normalized", failed, len(orgIDs))
+	}
+	return nil
+}
+
+// runFsckStates verifies every state of file-based state storage against its
+// checksum and reports corrupt and orphaned files. It changes nothing and
+// fails if any corrupt or orphaned file is found.
+func runFsckStates(args []string) error {
+	fs := flag.NewFlagSet("fsck-states", flag.ExitOnError)
+	stateDir := fs.String("state-dir", "./data/states", "State storage directory (<STORAGE_PATH>/states)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*stateDir); err != nil {
+		return err
+	}
+	stateStore, err := storage.NewFileStateStorage(*stateDir)
+	if err != nil {
+		return err
+	}
+	report, err := stateStore.CheckStates()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range report.Issues {
+			fmt.Printf("%-10s  %s: %s\n", issue.Problem, issue.Path, issue.Detail)
+		}
+		fmt.Printf("%d state(s), %d lock(s): %d corrupt, %d orphaned, %d unverified\n", report.States, report.Locks,
+			report.Count(storage.StateProblemCorrupt), report.Count(storage.StateProblemOrphaned), report.Count(storage.StateProblemUnverified))
+	}
+
+	if bad := report.Count(storage.StateProblemCorrupt) + report.Count(storage.StateProblemOrphaned); bad > 0 {
+		return fmt.Errorf("%d corrupt or orphaned file(s) in %s", bad, *stateDir)
+	}
+	return nil
+}
+
+// runVerifyStorage compares an organization's rows in a time range between
+// the CSV and MySQL sides of dual storage, using the CSV and MySQL settings
+// of the server configuration. It changes nothing and fails if the sides
+// differ.
+func runVerifyStorage(args []string) error {
+	fs := flag.NewFlagSet("verify-storage", flag.ExitOnError)
+	orgFlag := fs.String("org", "", "Organization to verify (UUID, required)")
+	sinceFlag := fs.String("since", "", "Only compare rows at or after this time (RFC3339 or YYYY-MM-DD)")
+	untilFlag := fs.String("until", "", "Only compare rows before this time (RFC3339 or YYYY-MM-DD)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	orgID, err := uuid.Parse(*orgFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --org: %w", err)
+	}
+	since, err := parseTimeFlag("since", *sinceFlag)
+	if err != nil {
+		return err
+	}
+	until, err := parseTimeFlag("until", *untilFlag)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	csvStore, err := storage.NewCSVStorage(cfg.StoragePath)
+	if err != nil {
+		return err
+	}
+	if err := csvStore.SetLayout(cfg.CSVLayout); err != nil {
+		return err
+	}
+	if cfg.CSVEncryptionKeyFile != "" {
+		key, err := csvcrypt.LoadKeyFile(cfg.CSVEncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := csvStore.SetEncryption(key); err != nil {
+			return err
+		}
+	}
+	mysqlStore, err := storage.NewMySQLStorage(cfg.DSN(), cfg.DBName)
+	if err != nil {
+		return err
+	}
+	dualStore := storage.NewDualStorage(csvStore, mysqlStore)
+	defer dualStore.Close()
+
+	report, err := dualStore.Verify(orgID, since, until)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range report.Discrepancies {
+			data, _ := json.Marshal(d.Data)
+			fmt.Printf("missing from %-5s  %s  %s  %s\n", d.MissingFrom, d.Timestamp.Format(time.RFC3339), d.Hash[:12], data)
+		}
+		fmt.Printf("CSV:   %d row(s), hash %s\n", report.CSVRows, report.CSVHash)
+		fmt.Printf("MySQL: %d row(s), hash %s\n", report.MySQLRows, report.MySQLHash)
+	}
+
+	if !report.Consistent() {
+		return fmt.Errorf("%d row(s) of org %s differ between CSV and MySQL", len(report.Discrepancies), orgID)
+	}
+	return nil
+}
+
+// parseTimeFlag parses an RFC3339 timestamp or a date; empty is the zero time
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: use RFC3339 or YYYY-MM-DD", name, value)
+	}
+	return t, nil
+}
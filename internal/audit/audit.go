@@ -0,0 +1,404 @@
+// Package audit records every Terraform state read, write and lock in a
+// stable, CloudTrail-like JSON event schema, so state access can be shipped
+// to security data lakes and queried with the tools built for CloudTrail.
+//
+// Events are appended to one JSON Lines file per UTC day and kept for the
+// configured retention. They can be exported per organization through the
+// admin API, and, with a drop directory, every completed day is written out
+// as one file per organization for collectors to pick up.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventVersion is the version of the event schema. Fields are only added
+// within a version, never renamed or removed.
+const EventVersion = "1.0"
+
+// EventSource identifies the service in events
+const EventSource = "tf-backend-service"
+
+// Event names
+const (
+	EventGetState         = "GetState"
+	EventPutState         = "PutState"
+	EventDeleteState      = "DeleteState"
+	EventLockState        = "LockState"
+	EventUnlockState      = "UnlockState"
+	EventRefreshLock      = "RefreshLock"
+	EventForceUnlockState = "ForceUnlockState"
+	EventGetLockHistory   = "GetLockHistory"
+	EventListStates       = "ListStates"
+)
+
+// dayFormat names the daily event files
+const dayFormat = "2006-01-02"
+
+// MaxExportRange bounds the time range of a single export
+const MaxExportRange = 31 * 24 * time.Hour
+
+// ErrInvalidRange is returned for empty or too long export ranges
+var ErrInvalidRange = errors.New("invalid export range")
+
+// Identity is the caller of an event
+type Identity struct {
+	Type  string `json:"type"` // "APIKey"
+	KeyID string `json:"keyId,omitempty"`
+}
+
+// Event is a state access event
+type Event struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventID           string            `json:"eventID"`
+	EventTime         time.Time         `json:"eventTime"`
+	EventSource       string            `json:"eventSource"`
+	EventName         string            `json:"eventName"`
+	ReadOnly          bool              `json:"readOnly"`
+	OrgID             string            `json:"orgId"`
+	UserIdentity      Identity          `json:"userIdentity"`
+	SourceIPAddress   string            `json:"sourceIPAddress,omitempty"`
+	UserAgent         string            `json:"userAgent,omitempty"`
+	RequestID         string            `json:"requestID,omitempty"`
+	RequestParameters map[string]string `json:"requestParameters,omitempty"`
+	HTTPStatus        int               `json:"httpStatus"`
+	ErrorCode         string            `json:"errorCode,omitempty"`
+}
+
+// Export is an export of events, in the layout of CloudTrail log files
+type Export struct {
+	Records []Event `json:"Records"`
+}
+
+// Config configures the audit log
+type Config struct {
+	// Dir holds the daily event files
+	Dir string
+
+	// Retention is how long daily event files are kept (0 = forever)
+	Retention time.Duration
+
+	// DropDir receives one file per organization for every completed day
+	// (empty disables file drops)
+	DropDir string
+}
+
+// Log is the state access audit log. It is safe for concurrent use.
+type Log struct {
+	config Config
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+
+	// dropMu serializes file drops and pruning
+	dropMu sync.Mutex
+
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// Open opens the audit log in the configured directory
+func Open(config Config) (*Log, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("audit directory is required")
+	}
+	if err := os.MkdirAll(config.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	if config.DropDir != "" {
+		if err := os.MkdirAll(config.DropDir, 0750); err != nil {
+			return nil, fmt.Errorf("failed to create audit drop directory: %w", err)
+		}
+	}
+
+	return &Log{config: config, stopChan: make(chan struct{}), now: time.Now}, nil
+}
+
+// dayPath returns the path of the event file of a day
+func (l *Log) dayPath(day string) string {
+	return filepath.Join(l.config.Dir, "state-"+day+".jsonl")
+}
+
+// Record appends an event, filling in its ID, time, version and source
+func (l *Log) Record(event Event) {
+	if event.EventTime.IsZero() {
+		event.EventTime = l.now().UTC()
+	}
+	if event.EventID == "" {
+		event.EventID = uuid.NewString()
+	}
+	event.EventVersion = EventVersion
+	event.EventSource = EventSource
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode audit event", "event", event.EventName, "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	day := event.EventTime.UTC().Format(dayFormat)
+	if l.file == nil || day != l.day {
+		if l.file != nil {
+			l.file.Close()
+			l.file = nil
+		}
+		file, err := os.OpenFile(l.dayPath(day), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			slog.Error("Failed to open audit log", "error", err)
+			return
+		}
+		l.file, l.day = file, day
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write audit event", "error", err)
+	}
+}
+
+// Export returns the events of an organization between from (inclusive)
+// and to (exclusive), oldest first
+func (l *Log) Export(orgID uuid.UUID, from, to time.Time) ([]Event, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidRange)
+	}
+	if to.Sub(from) > MaxExportRange {
+		return nil, fmt.Errorf("%w: longer than %s", ErrInvalidRange, MaxExportRange)
+	}
+
+	events := []Event{}
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		dayEvents, err := l.readDay(day.Format(dayFormat), func(event Event) bool {
+			return event.OrgID == orgID.String() && !event.EventTime.Before(from) && event.EventTime.Before(to)
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, dayEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].EventTime.Before(events[j].EventTime) })
+	return events, nil
+}
+
+// readDay returns the events of a day file that match the filter. A
+// missing file has no events; unreadable lines are skipped.
+func (l *Log) readDay(day string, match func(Event) bool) ([]Event, error) {
+	file, err := os.Open(l.dayPath(day))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if match(event) {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return events, nil
+}
+
+// Start drops completed days and prunes expired event files now and then
+// every interval, until Close
+func (l *Log) Start(interval time.Duration) {
+	l.maintain()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.maintain()
+			case <-l.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// maintain runs the file drop and retention, logging failures
+func (l *Log) maintain() {
+	if l.config.DropDir != "" {
+		if dropped, err := l.DropCompletedDays(); err != nil {
+			slog.Error("Failed to drop audit files", "dir", l.config.DropDir, "error", err)
+		} else if dropped > 0 {
+			slog.Info("Dropped state audit files", "days", dropped, "dir", l.config.DropDir)
+		}
+	}
+	if err := l.prune(); err != nil {
+		slog.Error("Failed to prune audit log", "error", err)
+	}
+}
+
+// days returns the days with an event file, oldest first
+func (l *Log) days() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(l.config.Dir, "state-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]string, 0, len(matches))
+	for _, match := range matches {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "state-"), ".jsonl")
+		if _, err := time.Parse(dayFormat, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// DropCompletedDays writes the events of every completed day that was not
+// dropped yet to <drop dir>/<org ID>/<day>.json, in the Export layout, and
+// returns the number of days dropped. A marker file records dropped days,
+// so each day is dropped once even across restarts.
+func (l *Log) DropCompletedDays() (int, error) {
+	l.dropMu.Lock()
+	defer l.dropMu.Unlock()
+
+	days, err := l.days()
+	if err != nil {
+		return 0, err
+	}
+
+	today := l.now().UTC().Format(dayFormat)
+	dropped := 0
+	for _, day := range days {
+		if day >= today {
+			continue
+		}
+		marker := filepath.Join(l.config.DropDir, ".dropped-"+day)
+		if _, err := os.Stat(marker); err == nil {
+			continue
+		}
+
+		if err := l.dropDay(day); err != nil {
+			return dropped, fmt.Errorf("failed to drop %s: %w", day, err)
+		}
+		if err := os.WriteFile(marker, nil, 0640); err != nil {
+			return dropped, fmt.Errorf("failed to mark %s as dropped: %w", day, err)
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// dropDay writes the events of a day to one file per organization
+func (l *Log) dropDay(day string) error {
+	events, err := l.readDay(day, func(Event) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	byOrg := make(map[string][]Event)
+	for _, event := range events {
+		byOrg[event.OrgID] = append(byOrg[event.OrgID], event)
+	}
+
+	for orgID, orgEvents := range byOrg {
+		if _, err := uuid.Parse(orgID); err != nil {
+			continue
+		}
+		dir := filepath.Join(l.config.DropDir, orgID)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(Export{Records: orgEvents})
+		if err != nil {
+			return err
+		}
+		// Written under a temporary name so collectors never see a partial file
+		path := filepath.Join(dir, day+".json")
+		if err := os.WriteFile(path+".tmp", data, 0640); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune removes event files older than the retention
+func (l *Log) prune() error {
+	if l.config.Retention <= 0 {
+		return nil
+	}
+
+	l.dropMu.Lock()
+	defer l.dropMu.Unlock()
+
+	days, err := l.days()
+	if err != nil {
+		return err
+	}
+
+	cutoff := l.now().UTC().Add(-l.config.Retention).Format(dayFormat)
+	for _, day := range days {
+		if day >= cutoff {
+			break
+		}
+		if err := os.Remove(l.dayPath(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if l.config.DropDir != "" {
+			os.Remove(filepath.Join(l.config.DropDir, ".dropped-"+day))
+		}
+	}
+	return nil
+}
+
+// Close stops the background maintenance and closes the current event file
+func (l *Log) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.stopChan)
+		l.wg.Wait()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.file != nil {
+			err = l.file.Close()
+			l.file = nil
+		}
+	})
+	return err
+}
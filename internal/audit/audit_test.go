@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestLogRecordAndExport(t *testing.T) {
+	auditLog, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer auditLog.Close()
+
+	orgID, otherOrgID := uuid.New(), uuid.New()
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	auditLog.Record(Event{EventName: EventGetState, OrgID: orgID.String(), EventTime: day.Add(23 * time.Hour)})
+	auditLog.Record(Event{EventName: EventPutState, OrgID: orgID.String(), EventTime: day.Add(25 * time.Hour)})
+	auditLog.Record(Event{EventName: EventPutState, OrgID: otherOrgID.String(), EventTime: day.Add(25 * time.Hour)})
+
+	events, err := auditLog.Export(orgID, day, day.Add(48*time.Hour))
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(events) != 2 || events[0].EventName != EventGetState || events[1].EventName != EventPutState {
+		t.Fatalf("Unexpected events %+v", events)
+	}
+	if events[0].EventVersion != EventVersion || events[0].EventSource != EventSource || events[0].EventID == "" {
+		t.Errorf("Expected version, source and ID to be filled in, got %+v", events[0])
+	}
+
+	// The range is [from, to)
+	events, _ = auditLog.Export(orgID, day.Add(24*time.Hour), day.Add(25*time.Hour))
+	if len(events) != 0 {
+		t.Errorf("Expected no events before to, got %+v", events)
+	}
+
+	if _, err := auditLog.Export(orgID, day, day); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Expected invalid range error for an empty range, got %v", err)
+	}
+	if _, err := auditLog.Export(orgID, day, day.Add(MaxExportRange+time.Hour)); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Expected invalid range error for a long range, got %v", err)
+	}
+}
+
+func TestLogDropCompletedDays(t *testing.T) {
+	dropDir := filepath.Join(t.TempDir(), "drop")
+	auditLog, err := Open(Config{Dir: t.TempDir(), DropDir: dropDir, Retention: 48 * time.Hour})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer auditLog.Close()
+
+	now := time.Date(2025, 5, 3, 12, 0, 0, 0, time.UTC)
+	auditLog.now = func() time.Time { return now }
+
+	orgID := uuid.New()
+	for _, at := range []time.Time{now.Add(-72 * time.Hour), now.Add(-24 * time.Hour), now} {
+		auditLog.Record(Event{EventName: EventLockState, OrgID: orgID.String(), EventTime: at})
+	}
+
+	dropped, err := auditLog.DropCompletedDays()
+	if err != nil || dropped != 2 {
+		t.Fatalf("Expected 2 completed days to be dropped, got %d, %v", dropped, err)
+	}
+	if _, err := os.Stat(filepath.Join(dropDir, orgID.String(), "2025-05-03.json")); !os.IsNotExist(err) {
+		t.Error("Expected the current day not to be dropped")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dropDir, orgID.String(), "2025-05-02.json"))
+	if err != nil {
+		t.Fatalf("Expected a drop file for 2025-05-02: %v", err)
+	}
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil || len(export.Records) != 1 || export.Records[0].EventName != EventLockState {
+		t.Errorf("Unexpected drop file %s (%v)", data, err)
+	}
+
+	// Each day is dropped once
+	if dropped, err := auditLog.DropCompletedDays(); err != nil || dropped != 0 {
+		t.Errorf("Expected no new drops, got %d, %v", dropped, err)
+	}
+
+	// Days past the retention are pruned
+	if err := auditLog.prune(); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	days, _ := auditLog.days()
+	if len(days) != 2 || days[0] != "2025-05-02" {
+		t.Errorf("Expected 2025-04-30 to be pruned, got %v", days)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	auditLog, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer auditLog.Close()
+
+	orgID := uuid.New()
+	credentials := auth.NewInMemoryStore()
+	credentials.AddCredentials(orgID, "test-key")
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(credentials))
+	r.Use(Middleware(auditLog))
+	r.Route("/state/{name}", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) })
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	r.Post("/state/{name}/lock", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/state/prod", nil),
+		httptest.NewRequest(http.MethodPost, "/state/prod?ID=lock-1", nil),
+		httptest.NewRequest(http.MethodPost, "/state/prod/lock", nil),
+	} {
+		req.Header.Set("X-Org-ID", orgID.String())
+		req.Header.Set("X-API-Key", "test-key")
+		req.Header.Set("User-Agent", "Terraform/1.9.0")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	events, err := auditLog.Export(orgID, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", events)
+	}
+
+	get, put, lock := events[0], events[1], events[2]
+	if get.EventName != EventGetState || !get.ReadOnly || get.HTTPStatus != http.StatusNotFound || get.ErrorCode != "NotFound" {
+		t.Errorf("Unexpected read event %+v", get)
+	}
+	if get.RequestParameters["stateName"] != "prod" || get.UserAgent != "Terraform/1.9.0" || get.SourceIPAddress != "192.0.2.1" {
+		t.Errorf("Expected request details in the read event, got %+v", get)
+	}
+	if put.EventName != EventPutState || put.ReadOnly || put.RequestParameters["lockId"] != "lock-1" || put.ErrorCode != "" {
+		t.Errorf("Unexpected write event %+v", put)
+	}
+	if lock.EventName != EventLockState {
+		t.Errorf("Unexpected lock event %+v", lock)
+	}
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware records an event for every request to the state routes. It
+// must run after the authentication middleware and inside the routes, so
+// the org ID and URL parameters are resolved.
+func Middleware(auditLog *Log) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID, ok := auth.GetOrgIDFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			event := Event{
+				EventName:       eventName(r),
+				ReadOnly:        r.Method == http.MethodGet || r.Method == http.MethodHead,
+				OrgID:           orgID.String(),
+				UserIdentity:    Identity{Type: "APIKey"},
+				SourceIPAddress: host(r.RemoteAddr),
+				UserAgent:       r.UserAgent(),
+				RequestID:       middleware.GetReqID(r.Context()),
+				HTTPStatus:      status,
+			}
+			if key, ok := auth.GetKeyFromContext(r.Context()); ok {
+				event.UserIdentity.KeyID = key.ID()
+			}
+			if status >= 400 {
+				event.ErrorCode = strings.ReplaceAll(http.StatusText(status), " ", "")
+			}
+
+			params := map[string]string{}
+			if name := chi.URLParam(r, "name"); name != "" {
+				params["stateName"] = name
+			}
+			if prefix := chi.URLParam(r, "prefix"); prefix != "" {
+				params["prefix"] = prefix
+			}
+			// Terraform sends the lock ID with writes of locked states
+			if lockID := r.URL.Query().Get("ID"); lockID != "" {
+				params["lockId"] = lockID
+			}
+			if len(params) > 0 {
+				event.RequestParameters = params
+			}
+
+			auditLog.Record(event)
+		})
+	}
+}
+
+// eventName returns the event name of a state request from its method and
+// route
+func eventName(r *http.Request) string {
+	pattern := ""
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		pattern = strings.TrimSuffix(rctx.RoutePattern(), "/")
+	}
+
+	switch {
+	case strings.HasSuffix(pattern, "/lock/refresh"):
+		return EventRefreshLock
+	case strings.HasSuffix(pattern, "/force-unlock"):
+		return EventForceUnlockState
+	case strings.HasSuffix(pattern, "/lock-history"):
+		return EventGetLockHistory
+	case strings.HasSuffix(pattern, "/lock") && r.Method == http.MethodPost, r.Method == "LOCK":
+		return EventLockState
+	case strings.HasSuffix(pattern, "/lock") && r.Method == http.MethodDelete, r.Method == "UNLOCK":
+		return EventUnlockState
+	case !strings.Contains(pattern, "{name}"):
+		return EventListStates
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return EventGetState
+	case r.Method == http.MethodDelete:
+		return EventDeleteState
+	default:
+		return EventPutState
+	}
+}
+
+// host returns the IP of a host:port address
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// AdminMiddleware creates a middleware that protects operator-only routes
+// with a shared admin key passed in the X-Admin-Key header
+func AdminMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			providedKey := r.Header.Get("X-Admin-Key")
+			if providedKey == "" {
+				security.Emit(security.Request(r, "admin.missing_key", security.SeverityWarning, "Missing X-Admin-Key header"))
+				http.Error(w, "Missing X-Admin-Key header", http.StatusUnauthorized)
+				return
+			}
+
+			// Use constant-time comparison to prevent timing attacks
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(providedKey)) != 1 {
+				security.Emit(security.Request(r, "admin.failure", security.SeverityCritical, "Failed admin authentication"))
+				http.Error(w, "Invalid admin credentials", http.StatusUnauthorized)
+				return
+			}
+
+			security.Emit(security.Request(r, "admin.success", security.SeverityInfo, "Successful admin authentication"))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxGraceEntries bounds the cache of recently validated credentials
+const maxGraceEntries = 10000
+
+// GraceStore wraps a credential store and remembers credentials it
+// validated recently. While the store is degraded (it returns errors, or
+// the degraded check reports repeated reload failures), credentials
+// validated within the grace period keep working instead of all traffic
+// failing. Requests accepted this way are logged loudly and, if read-only,
+// limited to GET and HEAD requests by Middleware.
+type GraceStore struct {
+	store    CredentialStore
+	period   time.Duration
+	readOnly bool
+	degraded func() bool
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]graceEntry // sha256 of org ID and API key -> entry
+
+	accepted atomic.Int64
+}
+
+// graceEntry is a credential validated by the wrapped store
+type graceEntry struct {
+	orgID       uuid.UUID
+	key         StoredKey
+	validatedAt time.Time
+}
+
+// NewGraceStore creates a credential store that falls back to credentials
+// validated by store within period while store is degraded
+func NewGraceStore(store CredentialStore, period time.Duration) *GraceStore {
+	return &GraceStore{
+		store:    store,
+		period:   period,
+		readOnly: true,
+		degraded: func() bool { return false },
+		now:      time.Now,
+		entries:  make(map[string]graceEntry),
+	}
+}
+
+// SetDegraded sets the check reporting that the wrapped store may have
+// lost credentials, e.g. after repeated reload failures. Credentials it
+// rejects are then accepted from the cache too. It must be called before
+// the store is used.
+func (g *GraceStore) SetDegraded(degraded func() bool) {
+	g.degraded = degraded
+}
+
+// SetReadOnly sets whether requests accepted during the grace period are
+// limited to reads (the default)
+func (g *GraceStore) SetReadOnly(readOnly bool) {
+	g.readOnly = readOnly
+}
+
+// Authenticate validates the credentials with the wrapped store, falling
+// back to the cache while it is degraded. grace reports whether the
+// credentials were accepted from the cache.
+func (g *GraceStore) Authenticate(orgID uuid.UUID, apiKey string) (key StoredKey, valid, grace bool, err error) {
+	if authenticator, ok := g.store.(KeyAuthenticator); ok {
+		key, valid, err = authenticator.AuthenticateKey(orgID, apiKey)
+	} else {
+		valid, err = g.store.ValidateCredentials(orgID, apiKey)
+	}
+
+	cacheKey := graceCacheKey(orgID, apiKey)
+	switch {
+	case err == nil && valid:
+		g.remember(cacheKey, graceEntry{orgID: orgID, key: key, validatedAt: g.now()})
+		return key, true, false, nil
+
+	case err == nil && !g.degraded():
+		// Revoked keys must not outlive their removal
+		g.forget(cacheKey)
+		return StoredKey{}, false, false, nil
+	}
+
+	entry, ok := g.lookup(cacheKey)
+	if !ok {
+		return key, valid, false, err
+	}
+	g.accepted.Add(1)
+	reason := "credential reloads failing"
+	if err != nil {
+		reason = err.Error()
+	}
+	slog.Warn("Auth backend degraded, accepting previously validated credentials",
+		"org_id", orgID, "reason", reason, "validated_at", entry.validatedAt.UTC().Format(time.RFC3339))
+	return entry.key, true, true, nil
+}
+
+// AuthenticateKey validates the credentials, falling back to the cache
+// while the wrapped store is degraded
+func (g *GraceStore) AuthenticateKey(orgID uuid.UUID, apiKey string) (StoredKey, bool, error) {
+	key, valid, _, err := g.Authenticate(orgID, apiKey)
+	return key, valid, err
+}
+
+// ValidateCredentials checks if the provided credentials are valid, falling
+// back to the cache while the wrapped store is degraded
+func (g *GraceStore) ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error) {
+	_, valid, _, err := g.Authenticate(orgID, apiKey)
+	return valid, err
+}
+
+// LookupKey returns the key with the given ID from the wrapped store or,
+// while it is degraded, from the cache, so session tokens keep working
+func (g *GraceStore) LookupKey(orgID uuid.UUID, keyID string) (StoredKey, bool) {
+	if lookup, ok := g.store.(KeyLookup); ok {
+		if key, found := lookup.LookupKey(orgID, keyID); found {
+			return key, true
+		}
+	}
+	if !g.degraded() {
+		return StoredKey{}, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for _, entry := range g.entries {
+		if entry.orgID == orgID && entry.key.ID() == keyID && g.fresh(entry, now) {
+			return entry.key, true
+		}
+	}
+	return StoredKey{}, false
+}
+
+// Stats returns the number of cached credentials and of requests accepted
+// from the cache since the store was created
+func (g *GraceStore) Stats() (entries int, accepted int64) {
+	g.mu.Lock()
+	entries = len(g.entries)
+	g.mu.Unlock()
+	return entries, g.accepted.Load()
+}
+
+// remember caches validated credentials, pruning stale entries when the
+// cache is full
+func (g *GraceStore) remember(cacheKey string, entry graceEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.entries[cacheKey]; !exists && len(g.entries) >= maxGraceEntries {
+		for k, e := range g.entries {
+			if !g.fresh(e, entry.validatedAt) {
+				delete(g.entries, k)
+			}
+		}
+		if len(g.entries) >= maxGraceEntries {
+			return
+		}
+	}
+	g.entries[cacheKey] = entry
+}
+
+// forget removes credentials from the cache
+func (g *GraceStore) forget(cacheKey string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, cacheKey)
+}
+
+// lookup returns cached credentials validated within the grace period
+// whose key has not expired since
+func (g *GraceStore) lookup(cacheKey string) (graceEntry, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry, ok := g.entries[cacheKey]
+	if !ok || !g.fresh(entry, g.now()) {
+		return graceEntry{}, false
+	}
+	return entry, true
+}
+
+// fresh reports whether an entry may still be used at the given time
+func (g *GraceStore) fresh(entry graceEntry, now time.Time) bool {
+	return now.Sub(entry.validatedAt) <= g.period && !entry.key.Expired(now)
+}
+
+// graceCacheKey identifies credentials without keeping the API key in memory
+func graceCacheKey(orgID uuid.UUID, apiKey string) string {
+	sum := sha256.Sum256(append(orgID[:], apiKey...))
+	return hex.EncodeToString(sum[:])
+}
+
+// graceReadAllowed reports whether a request accepted during the grace
+// period may proceed
+func (g *GraceStore) graceReadAllowed(r *http.Request) bool {
+	return !g.readOnly || r.Method == http.MethodGet || r.Method == http.MethodHead
+}
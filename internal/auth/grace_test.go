@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// flakyStore wraps an in-memory store and fails validation on demand
+type flakyStore struct {
+	*InMemoryStore
+	err error
+}
+
+func (s *flakyStore) ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.InMemoryStore.ValidateCredentials(orgID, apiKey)
+}
+
+func TestGraceStore(t *testing.T) {
+	inner := &flakyStore{InMemoryStore: NewInMemoryStore()}
+	orgID := uuid.New()
+	inner.AddCredentials(orgID, "key-1")
+
+	g := NewGraceStore(inner, 10*time.Minute)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return clock }
+	degraded := false
+	g.SetDegraded(func() bool { return degraded })
+
+	// Validation errors before the credentials were seen are not masked
+	inner.err = errors.New("backend unreachable")
+	if _, valid, _, err := g.Authenticate(orgID, "key-1"); err == nil || valid {
+		t.Fatalf("Expected the error for unseen credentials, got valid=%v err=%v", valid, err)
+	}
+
+	inner.err = nil
+	if _, valid, grace, err := g.Authenticate(orgID, "key-1"); err != nil || !valid || grace {
+		t.Fatalf("Expected normal validation, got valid=%v grace=%v err=%v", valid, grace, err)
+	}
+
+	inner.err = errors.New("backend unreachable")
+	clock = clock.Add(5 * time.Minute)
+	if _, valid, grace, err := g.Authenticate(orgID, "key-1"); err != nil || !valid || !grace {
+		t.Errorf("Expected cached credentials within the grace period, got valid=%v grace=%v err=%v", valid, grace, err)
+	}
+	if _, valid, _, _ := g.Authenticate(orgID, "key-2"); valid {
+		t.Error("Expected unknown credentials to be rejected")
+	}
+	clock = clock.Add(6 * time.Minute)
+	if _, valid, _, _ := g.Authenticate(orgID, "key-1"); valid {
+		t.Error("Expected cached credentials to expire after the grace period")
+	}
+
+	// Credentials lost by a failed reload are only accepted while degraded
+	inner.err = nil
+	g.Authenticate(orgID, "key-1")
+	inner.RemoveCredentials(orgID)
+	degraded = true
+	if _, valid, grace, _ := g.Authenticate(orgID, "key-1"); !valid || !grace {
+		t.Errorf("Expected cached credentials while degraded, got valid=%v grace=%v", valid, grace)
+	}
+	degraded = false
+	if _, valid, _, _ := g.Authenticate(orgID, "key-1"); valid {
+		t.Error("Expected rejected credentials outside grace mode")
+	}
+	degraded = true
+	if _, valid, _, _ := g.Authenticate(orgID, "key-1"); valid {
+		t.Error("Expected credentials rejected by a healthy store to be forgotten")
+	}
+
+	if entries, accepted := g.Stats(); entries != 0 || accepted != 2 {
+		t.Errorf("Expected 0 entries and 2 accepted, got %d and %d", entries, accepted)
+	}
+}
+
+func TestMiddlewareGraceReadOnly(t *testing.T) {
+	inner := &flakyStore{InMemoryStore: NewInMemoryStore()}
+	orgID := uuid.New()
+	inner.AddCredentials(orgID, "key-1")
+	g := NewGraceStore(inner, time.Minute)
+
+	handler := Middleware(g)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/data", nil)
+		req.Header.Set("X-Org-ID", orgID.String())
+		req.Header.Set("X-API-Key", "key-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 while healthy, got %d", rec.Code)
+	}
+
+	inner.err = errors.New("backend unreachable")
+	if rec := do(http.MethodGet); rec.Code != http.StatusOK {
+		t.Errorf("Expected reads in grace mode, got %d", rec.Code)
+	}
+	rec := do(http.MethodPost)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After for writes in grace mode, got %d", rec.Code)
+	}
+
+	g.SetReadOnly(false)
+	if rec := do(http.MethodPost); rec.Code != http.StatusOK {
+		t.Errorf("Expected writes in grace mode when not read-only, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,846 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Key attributes follow the stored key on the same line of the auth config
+// file, separated by whitespace:
+//
+// $2a$12$hashedAPIKey1... deprecated_after=2025-06-01T00:00:00Z
+// $2a$12$hashedAPIKey2... scopes=state:read expires=2025-07-01T00:00:00Z parent=762c08fc17a1
+// $2a$12$hashedAPIKey3... label=ci%20runner created=2025-05-01T09:30:00Z
+// $2a$12$hashedAPIKey4... id=3f9a1c04b2e7
+const (
+	attrID              = "id"
+	attrDeprecatedAfter = "deprecated_after"
+	attrScopes          = "scopes"
+	attrExpires         = "expires"
+	attrParent          = "parent"
+	attrLabel           = "label"
+	attrCreated         = "created"
+)
+
+// maxLabelLength bounds key labels in bytes
+const maxLabelLength = 100
+
+// APIKeyPrefix starts generated API keys, which have the form
+// etk_<key ID>_<secret>. The key ID is not secret; it lets the store find
+// the one stored hash to compare instead of trying every key of the org.
+const APIKeyPrefix = "etk_"
+
+// keyIDLength is the length of key IDs in hex characters
+const keyIDLength = 12
+
+// Key management errors
+var (
+	ErrOrgNotFound = errors.New("organization not found")
+	ErrOrgExists   = errors.New("organization already exists")
+	ErrKeyNotFound = errors.New("key not found")
+	ErrForbidden   = errors.New("not permitted")
+	ErrLastKey     = errors.New("cannot revoke the last key")
+
+	ErrInvalidKeyRequest = errors.New("invalid key request")
+)
+
+// DefaultKeyCost is the bcrypt cost used for keys generated by the store
+const DefaultKeyCost = 12
+
+// StoredKey is an API key entry of the auth config file
+type StoredKey struct {
+	// Key is the bcrypt hash (or legacy plaintext key)
+	Key string
+
+	// KeyID is the ID embedded in the plaintext of prefixed keys; empty for
+	// legacy keys, which have to be found by comparing every hash
+	KeyID string
+
+	// DeprecatedAfter is the end of the rotation overlap window; the key is
+	// revoked afterwards. Zero means the key is not deprecated.
+	DeprecatedAfter time.Time
+
+	// Scopes limits what the key may do; nil means DefaultScopes
+	Scopes []string
+
+	// ExpiresAt is when the key stops working; zero means it does not expire
+	ExpiresAt time.Time
+
+	// Parent is the ID of the key that issued this key, if any
+	Parent string
+
+	// Label is a free-form description of the key, e.g. its client
+	Label string
+
+	// CreatedAt is when the key was added; zero for keys added before
+	// creation times were recorded
+	CreatedAt time.Time
+}
+
+// ID returns a short, stable identifier of the key that does not reveal it
+func (k StoredKey) ID() string {
+	if k.KeyID != "" {
+		return k.KeyID
+	}
+	sum := sha256.Sum256([]byte(k.Key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Deprecated reports whether the key is in a rotation overlap window
+func (k StoredKey) Deprecated() bool {
+	return !k.DeprecatedAfter.IsZero()
+}
+
+// Expired reports whether the key's overlap window has ended or the key
+// has passed its expiry
+func (k StoredKey) Expired(now time.Time) bool {
+	if k.Deprecated() && now.After(k.DeprecatedAfter) {
+		return true
+	}
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// String formats the key as an auth config line
+func (k StoredKey) String() string {
+	line := k.Key
+	if k.KeyID != "" {
+		line += " " + attrID + "=" + k.KeyID
+	}
+	if len(k.Scopes) > 0 {
+		line += " " + attrScopes + "=" + strings.Join(k.Scopes, ",")
+	}
+	if !k.ExpiresAt.IsZero() {
+		line += " " + attrExpires + "=" + k.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if k.Parent != "" {
+		line += " " + attrParent + "=" + k.Parent
+	}
+	if k.Label != "" {
+		line += " " + attrLabel + "=" + url.PathEscape(k.Label)
+	}
+	if !k.CreatedAt.IsZero() {
+		line += " " + attrCreated + "=" + k.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	if k.Deprecated() {
+		line += " " + attrDeprecatedAfter + "=" + k.DeprecatedAfter.UTC().Format(time.RFC3339)
+	}
+	return line
+}
+
+// Info describes the key without revealing it
+func (k StoredKey) Info() KeyInfo {
+	info := KeyInfo{
+		ID:     k.ID(),
+		Hashed: isBcryptHash(k.Key),
+		Scopes: k.EffectiveScopes(),
+		Parent: k.Parent,
+		Label:  k.Label,
+	}
+	if k.Deprecated() {
+		deprecatedAfter := k.DeprecatedAfter
+		info.DeprecatedAfter = &deprecatedAfter
+	}
+	if !k.ExpiresAt.IsZero() {
+		expiresAt := k.ExpiresAt
+		info.ExpiresAt = &expiresAt
+	}
+	if !k.CreatedAt.IsZero() {
+		createdAt := k.CreatedAt
+		info.CreatedAt = &createdAt
+	}
+	return info
+}
+
+// KeyInfo describes a stored key without revealing it
+type KeyInfo struct {
+	ID              string     `json:"id"`
+	Hashed          bool       `json:"hashed"`
+	Scopes          []string   `json:"scopes"`
+	Label           string     `json:"label,omitempty"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	Parent          string     `json:"parent,omitempty"`
+	DeprecatedAfter *time.Time `json:"deprecated_after,omitempty"`
+}
+
+// ParseKeyLine parses a key line of the auth config file. Lines whose
+// trailing fields are not known attributes are treated as a single key so
+// legacy plaintext keys containing spaces keep working.
+func ParseKeyLine(line string) (StoredKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) <= 1 || !isAttribute(fields[1]) {
+		return StoredKey{Key: line}, nil
+	}
+
+	key := StoredKey{Key: fields[0]}
+	for _, field := range fields[1:] {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return StoredKey{}, fmt.Errorf("invalid key attribute %q", field)
+		}
+		switch name {
+		case attrID:
+			if !isKeyID(value) {
+				return StoredKey{}, fmt.Errorf("invalid %s %q: expected %d hex characters", attrID, value, keyIDLength)
+			}
+			key.KeyID = value
+		case attrDeprecatedAfter:
+			t, err := parseKeyTime(value)
+			if err != nil {
+				return StoredKey{}, fmt.Errorf("invalid %s: %w", attrDeprecatedAfter, err)
+			}
+			key.DeprecatedAfter = t
+		case attrExpires:
+			t, err := parseKeyTime(value)
+			if err != nil {
+				return StoredKey{}, fmt.Errorf("invalid %s: %w", attrExpires, err)
+			}
+			key.ExpiresAt = t
+		case attrScopes:
+			scopes := strings.Split(value, ",")
+			if err := ValidateScopes(scopes); err != nil {
+				return StoredKey{}, err
+			}
+			key.Scopes = scopes
+		case attrParent:
+			key.Parent = value
+		case attrLabel:
+			label, err := url.PathUnescape(value)
+			if err != nil {
+				return StoredKey{}, fmt.Errorf("invalid %s: %w", attrLabel, err)
+			}
+			if err := ValidateLabel(label); err != nil {
+				return StoredKey{}, err
+			}
+			key.Label = label
+		case attrCreated:
+			t, err := parseKeyTime(value)
+			if err != nil {
+				return StoredKey{}, fmt.Errorf("invalid %s: %w", attrCreated, err)
+			}
+			key.CreatedAt = t
+		default:
+			return StoredKey{}, fmt.Errorf("unknown key attribute %q", name)
+		}
+	}
+
+	return key, nil
+}
+
+// isAttribute reports whether a field looks like a key attribute
+func isAttribute(field string) bool {
+	name, _, ok := strings.Cut(field, "=")
+	if !ok {
+		return false
+	}
+	switch name {
+	case attrID, attrDeprecatedAfter, attrScopes, attrExpires, attrParent, attrLabel, attrCreated:
+		return true
+	}
+	return false
+}
+
+// ValidateLabel checks that a key label is short and printable
+func ValidateLabel(label string) error {
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("key label too long: %d bytes (maximum %d)", len(label), maxLabelLength)
+	}
+	for _, r := range label {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("key label %q contains control characters", label)
+		}
+	}
+	return nil
+}
+
+// parseKeyTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (midnight UTC)
+func parseKeyTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 timestamp or YYYY-MM-DD date", value)
+	}
+	return t, nil
+}
+
+// storedKeyField returns the stored key of an auth config key line
+func storedKeyField(line string) string {
+	key, err := ParseKeyLine(line)
+	if err != nil {
+		return line
+	}
+	return key.Key
+}
+
+// GenerateAPIKey generates a cryptographically secure random API key of
+// the form etk_<key ID>_<secret> and returns it with its key ID
+func GenerateAPIKey() (apiKey, keyID string, err error) {
+	id := make([]byte, keyIDLength/2)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	keyID = hex.EncodeToString(id)
+	return APIKeyPrefix + keyID + "_" + base64.RawURLEncoding.EncodeToString(secret), keyID, nil
+}
+
+// ParseAPIKeyID returns the key ID embedded in a prefixed API key
+func ParseAPIKeyID(apiKey string) (string, bool) {
+	rest, ok := strings.CutPrefix(apiKey, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	keyID, secret, ok := strings.Cut(rest, "_")
+	if !ok || secret == "" || !isKeyID(keyID) {
+		return "", false
+	}
+	return keyID, true
+}
+
+// isKeyID reports whether value is a well-formed key ID
+func isKeyID(value string) bool {
+	if len(value) != keyIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil && strings.ToLower(value) == value
+}
+
+// SetKeyCost sets the bcrypt cost of keys generated by the store
+// (defaults to DefaultKeyCost)
+func (s *FileStore) SetKeyCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %d: must be between %d and %d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyCost = cost
+	return nil
+}
+
+// generateKey generates a new API key, its key ID and its bcrypt hash at
+// the store's key cost
+func (s *FileStore) generateKey() (apiKey, keyID, hash string, err error) {
+	apiKey, keyID, err = GenerateAPIKey()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	s.mu.RLock()
+	cost := s.keyCost
+	s.mu.RUnlock()
+	if cost == 0 {
+		cost = DefaultKeyCost
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(apiKey), cost)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to hash API key: %w", err)
+	}
+	return apiKey, keyID, string(hashed), nil
+}
+
+// OrgInfo summarizes an organization of the auth config file
+type OrgInfo struct {
+	OrgID uuid.UUID `json:"org_id"`
+	Keys  int       `json:"keys"`
+}
+
+// Orgs lists the organizations, ordered by ID
+func (s *FileStore) Orgs() []OrgInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orgs := make([]OrgInfo, 0, len(s.credentials))
+	for orgID, keys := range s.credentials {
+		orgs = append(orgs, OrgInfo{OrgID: orgID, Keys: len(keys)})
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].OrgID.String() < orgs[j].OrgID.String()
+	})
+	return orgs
+}
+
+// Keys lists the organization's keys
+func (s *FileStore) Keys(orgID uuid.UUID) ([]KeyInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys, exists := s.credentials[orgID]
+	if !exists {
+		return nil, false
+	}
+
+	infos := make([]KeyInfo, 0, len(keys))
+	for _, key := range keys {
+		infos = append(infos, key.Info())
+	}
+	return infos, true
+}
+
+// KeyRotation is the result of a key rotation
+type KeyRotation struct {
+	OrgID string `json:"org_id"`
+
+	// APIKey is the new plaintext key; only its hash is stored
+	APIKey string `json:"api_key"`
+	KeyID  string `json:"key_id"`
+
+	// DeprecatedKeyIDs lists the keys revoked after DeprecatedAfter
+	DeprecatedKeyIDs []string  `json:"deprecated_key_ids"`
+	DeprecatedAfter  time.Time `json:"deprecated_after"`
+}
+
+// RotateKeys introduces a new key for the organization and deprecates the
+// key with the given ID (or all keys that are not yet deprecated when keyID
+// is empty) until deprecateAfter. Deprecated keys keep working during the
+// overlap window, so the new key can be rolled out and verified before the
+// old ones are revoked. When a single key is rotated, the new key inherits
+// its scopes, parent and label.
+func (s *FileStore) RotateKeys(orgID uuid.UUID, keyID string, deprecateAfter time.Time) (*KeyRotation, error) {
+	s.mu.RLock()
+	keys, exists := s.credentials[orgID]
+	found := keyID == ""
+	var rotated StoredKey
+	for _, key := range keys {
+		if key.ID() == keyID {
+			found = true
+			rotated = key
+		}
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrOrgNotFound, orgID)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+
+	newKey, newKeyID, newHash, err := s.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	newStoredKey := StoredKey{
+		Key:       newHash,
+		KeyID:     newKeyID,
+		Scopes:    rotated.Scopes,
+		Parent:    rotated.Parent,
+		Label:     rotated.Label,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	rotation := &KeyRotation{
+		OrgID:            orgID.String(),
+		APIKey:           newKey,
+		KeyID:            newStoredKey.ID(),
+		DeprecatedKeyIDs: []string{},
+		DeprecatedAfter:  deprecateAfter.UTC().Truncate(time.Second),
+	}
+
+	err = s.rewriteOrgKeys(orgID, func(key StoredKey) (StoredKey, bool) {
+		if keyID == "" && !key.Deprecated() || key.ID() == keyID {
+			key.DeprecatedAfter = rotation.DeprecatedAfter
+			rotation.DeprecatedKeyIDs = append(rotation.DeprecatedKeyIDs, key.ID())
+		}
+		return key, true
+	}, newStoredKey)
+	if err != nil {
+		return nil, err
+	}
+
+	security.Emit(security.Event{Name: "key.rotated", Severity: security.SeverityInfo, Message: "Rotated API keys"}.
+		WithOrg(orgID.String()).WithKey(rotation.KeyID).
+		With("deprecated", strings.Join(rotation.DeprecatedKeyIDs, ",")).
+		With("deprecated_after", rotation.DeprecatedAfter.Format(time.RFC3339)))
+
+	if err := s.LoadFromFile(); err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// IssuedKey is the result of issuing a sub-key
+type IssuedKey struct {
+	// APIKey is the new plaintext key; only its hash is stored
+	APIKey string `json:"api_key"`
+	KeyInfo
+}
+
+// IssueKey creates a sub-key for the parent's org with the given scopes,
+// optional label and optional expiry. The parent must hold ScopeKeysManage and can only grant
+// scopes it holds itself (see CanDelegate); ScopeKeysManage cannot be
+// delegated.
+func (s *FileStore) IssueKey(orgID uuid.UUID, parent StoredKey, scopes []string, label string, expiresAt time.Time) (*IssuedKey, error) {
+	if !parent.HasScope(ScopeKeysManage) {
+		return nil, fmt.Errorf("%w: issuing key lacks scope %s", ErrForbidden, ScopeKeysManage)
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyRequest, err)
+	}
+	for _, scope := range scopes {
+		if scope == ScopeKeysManage {
+			return nil, fmt.Errorf("%w: scope %s cannot be delegated", ErrForbidden, ScopeKeysManage)
+		}
+		if !parent.CanDelegate(scope) {
+			return nil, fmt.Errorf("%w: issuing key lacks scope %s", ErrForbidden, scope)
+		}
+	}
+	if err := ValidateLabel(label); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyRequest, err)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidKeyRequest)
+	}
+	if !parent.ExpiresAt.IsZero() && (expiresAt.IsZero() || expiresAt.After(parent.ExpiresAt)) {
+		// Sub-keys never outlive the key that issued them
+		expiresAt = parent.ExpiresAt
+	}
+
+	newKey, newKeyID, newHash, err := s.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	issued := StoredKey{
+		Key:       newHash,
+		KeyID:     newKeyID,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		Parent:    parent.ID(),
+		Label:     label,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	keep := func(key StoredKey) (StoredKey, bool) { return key, true }
+	if err := s.rewriteOrgKeys(orgID, keep, issued); err != nil {
+		return nil, err
+	}
+
+	security.Emit(security.Event{Name: "key.issued", Severity: security.SeverityInfo, Message: "Issued API key"}.
+		WithOrg(orgID.String()).WithKey(issued.ID()).
+		With("parent", issued.Parent).With("scopes", strings.Join(scopes, ",")))
+
+	if err := s.LoadFromFile(); err != nil {
+		return nil, err
+	}
+	return &IssuedKey{APIKey: newKey, KeyInfo: issued.Info()}, nil
+}
+
+// ProvisionedOrg is the result of provisioning an organization
+type ProvisionedOrg struct {
+	OrgID string `json:"org_id"`
+	IssuedKey
+}
+
+// ProvisionOrg adds an organization with a new key to the auth config file.
+// A random org ID is used when orgID is uuid.Nil; the key gets the given
+// scopes, or DefaultScopes when none are given.
+func (s *FileStore) ProvisionOrg(orgID uuid.UUID, scopes []string) (*ProvisionedOrg, error) {
+	if len(scopes) > 0 {
+		if err := ValidateScopes(scopes); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKeyRequest, err)
+		}
+	}
+	if orgID == uuid.Nil {
+		orgID = uuid.New()
+	}
+
+	newKey, newKeyID, newHash, err := s.generateKey()
+	if err != nil {
+		return nil, err
+	}
+	provisioned := StoredKey{Key: newHash, KeyID: newKeyID, Scopes: scopes, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+
+	err = s.rewriteFile(func(lines []string) ([]string, error) {
+		for _, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+				if sectionID, err := uuid.Parse(strings.TrimSpace(trimmed[1 : len(trimmed)-1])); err == nil && sectionID == orgID {
+					return nil, fmt.Errorf("%w: %s", ErrOrgExists, orgID)
+				}
+			}
+		}
+
+		// Keep a blank line between sections
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		return append(lines, "["+orgID.String()+"]", provisioned.String(), ""), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	security.Emit(security.Event{Name: "org.provisioned", Severity: security.SeverityInfo, Message: "Provisioned organization"}.
+		WithOrg(orgID.String()).WithKey(provisioned.ID()).With("scopes", strings.Join(scopes, ",")))
+
+	if err := s.LoadFromFile(); err != nil {
+		return nil, err
+	}
+	return &ProvisionedOrg{OrgID: orgID.String(), IssuedKey: IssuedKey{APIKey: newKey, KeyInfo: provisioned.Info()}}, nil
+}
+
+// RevokeSubKey removes a key issued through IssueKey from the org
+func (s *FileStore) RevokeSubKey(orgID uuid.UUID, keyID string) error {
+	s.mu.RLock()
+	var target *StoredKey
+	for _, key := range s.credentials[orgID] {
+		if key.ID() == keyID {
+			target = &key
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	if target.Parent == "" {
+		return fmt.Errorf("%w: key %s was not issued by another key", ErrForbidden, keyID)
+	}
+
+	err := s.rewriteOrgKeys(orgID, func(key StoredKey) (StoredKey, bool) {
+		return key, key.ID() != keyID
+	})
+	if err != nil {
+		return err
+	}
+
+	security.Emit(security.Event{Name: "key.revoked", Severity: security.SeverityInfo, Message: "Revoked API key"}.
+		WithOrg(orgID.String()).WithKey(keyID).With("parent", target.Parent))
+
+	return s.LoadFromFile()
+}
+
+// AddKey adds an operator-issued key to the organization with the given
+// scopes (DefaultScopes when none are given), optional label and optional
+// expiry. Unlike
+// IssueKey, any scope may be granted, including ScopeKeysManage.
+func (s *FileStore) AddKey(orgID uuid.UUID, scopes []string, label string, expiresAt time.Time) (*IssuedKey, error) {
+	if len(scopes) > 0 {
+		if err := ValidateScopes(scopes); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKeyRequest, err)
+		}
+	}
+	if err := ValidateLabel(label); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyRequest, err)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidKeyRequest)
+	}
+
+	newKey, newKeyID, newHash, err := s.generateKey()
+	if err != nil {
+		return nil, err
+	}
+	added := StoredKey{
+		Key:       newHash,
+		KeyID:     newKeyID,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		Label:     label,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	keep := func(key StoredKey) (StoredKey, bool) { return key, true }
+	if err := s.rewriteOrgKeys(orgID, keep, added); err != nil {
+		return nil, err
+	}
+
+	security.Emit(security.Event{Name: "key.added", Severity: security.SeverityInfo, Message: "Added API key"}.
+		WithOrg(orgID.String()).WithKey(added.ID()).With("scopes", strings.Join(scopes, ",")))
+
+	if err := s.LoadFromFile(); err != nil {
+		return nil, err
+	}
+	return &IssuedKey{APIKey: newKey, KeyInfo: added.Info()}, nil
+}
+
+// RevokeKey removes a key and the keys it issued from the organization and
+// returns the IDs of the removed keys. The organization's last key cannot
+// be revoked; rotate it instead.
+func (s *FileStore) RevokeKey(orgID uuid.UUID, keyID string) ([]string, error) {
+	s.mu.RLock()
+	keys, exists := s.credentials[orgID]
+	found, remaining := false, 0
+	for _, key := range keys {
+		switch {
+		case key.ID() == keyID:
+			found = true
+		case key.Parent != keyID:
+			remaining++
+		}
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrOrgNotFound, orgID)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	if remaining == 0 {
+		return nil, fmt.Errorf("%w: %s is the organization's last key", ErrLastKey, keyID)
+	}
+
+	revoked := []string{}
+	err := s.rewriteOrgKeys(orgID, func(key StoredKey) (StoredKey, bool) {
+		if key.ID() == keyID || key.Parent == keyID {
+			revoked = append(revoked, key.ID())
+			return key, false
+		}
+		return key, true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range revoked {
+		event := security.Event{Name: "key.revoked", Severity: security.SeverityInfo, Message: "Revoked API key"}.
+			WithOrg(orgID.String()).WithKey(id)
+		if id != keyID {
+			event = event.With("parent", keyID)
+		}
+		security.Emit(event)
+	}
+
+	if err := s.LoadFromFile(); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// RevokeExpiredKeys removes keys whose overlap window has ended or that have
+// expired from the auth config file and returns the number of revoked keys
+func (s *FileStore) RevokeExpiredKeys(now time.Time) (int, error) {
+	s.mu.RLock()
+	var orgs []uuid.UUID
+	for orgID, keys := range s.credentials {
+		for _, key := range keys {
+			if key.Expired(now) {
+				orgs = append(orgs, orgID)
+				break
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	revoked := 0
+	for _, orgID := range orgs {
+		err := s.rewriteOrgKeys(orgID, func(key StoredKey) (StoredKey, bool) {
+			if key.Expired(now) {
+				security.Emit(security.Event{Name: "key.expired", Severity: security.SeverityInfo, Message: "Revoked expired API key"}.
+					WithOrg(orgID.String()).WithKey(key.ID()))
+				revoked++
+				return key, false
+			}
+			return key, true
+		})
+		if err != nil {
+			return revoked, err
+		}
+	}
+
+	if revoked == 0 {
+		return 0, nil
+	}
+	return revoked, s.LoadFromFile()
+}
+
+// EnableAutoRevoke periodically revokes keys whose overlap window has ended
+// or that have expired until the store is closed
+func (s *FileStore) EnableAutoRevoke(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				if _, err := s.RevokeExpiredKeys(now); err != nil {
+					slog.Error("Failed to revoke expired API keys", "error", err)
+				}
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// rewriteOrgKeys applies update to each key line of an org section in the
+// auth config file, dropping keys for which it returns false, and adds the
+// given keys after the last key line. Comments and blank lines are kept.
+func (s *FileStore) rewriteOrgKeys(orgID uuid.UUID, update func(key StoredKey) (StoredKey, bool), add ...StoredKey) error {
+	return s.rewriteFile(func(lines []string) ([]string, error) {
+		var result []string
+		inOrg, found := false, false
+		insertAt := -1
+
+		for _, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+				sectionID, err := uuid.Parse(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+				inOrg = err == nil && sectionID == orgID
+				result = append(result, line)
+				if inOrg {
+					found = true
+					insertAt = len(result)
+				}
+				continue
+			}
+
+			if !inOrg || trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				result = append(result, line)
+				continue
+			}
+
+			key, err := ParseKeyLine(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			if updated, keep := update(key); keep {
+				result = append(result, updated.String())
+				insertAt = len(result)
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrOrgNotFound, orgID)
+		}
+
+		if len(add) > 0 {
+			added := make([]string, 0, len(add))
+			for _, key := range add {
+				added = append(added, key.String())
+			}
+			result = append(result[:insertAt], append(added, result[insertAt:]...)...)
+		}
+
+		return result, nil
+	})
+}
@@ -0,0 +1,540 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseKeyLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantKey    string
+		wantDeprec string
+		wantErr    bool
+	}{
+		{name: "plain hash", line: "$2a$12$abc", wantKey: "$2a$12$abc"},
+		{name: "legacy key with spaces", line: "legacy key value", wantKey: "legacy key value"},
+		{name: "deprecated RFC 3339", line: "$2a$12$abc deprecated_after=2025-06-01T12:00:00Z", wantKey: "$2a$12$abc", wantDeprec: "2025-06-01T12:00:00Z"},
+		{name: "deprecated date", line: "$2a$12$abc deprecated_after=2025-06-01", wantKey: "$2a$12$abc", wantDeprec: "2025-06-01T00:00:00Z"},
+		{name: "invalid date", line: "$2a$12$abc deprecated_after=soon", wantErr: true},
+		{name: "unknown attribute", line: "$2a$12$abc deprecated_after=2025-06-01 color=blue", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKeyLine(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if key.Key != tt.wantKey {
+				t.Errorf("Expected key %q, got %q", tt.wantKey, key.Key)
+			}
+			if tt.wantDeprec == "" && key.Deprecated() {
+				t.Errorf("Expected key not to be deprecated")
+			}
+			if tt.wantDeprec != "" && key.DeprecatedAfter.Format(time.RFC3339) != tt.wantDeprec {
+				t.Errorf("Expected deprecated_after %s, got %s", tt.wantDeprec, key.DeprecatedAfter.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestKeyLabelAndCreatedRoundTrip(t *testing.T) {
+	created := time.Date(2025, 5, 1, 9, 30, 0, 0, time.UTC)
+	key := StoredKey{Key: "$2a$12$abc", Label: "ci runner=eu", CreatedAt: created}
+
+	line := key.String()
+	if line != "$2a$12$abc label=ci%20runner=eu created=2025-05-01T09:30:00Z" {
+		t.Errorf("Unexpected key line %q", line)
+	}
+
+	parsed, err := ParseKeyLine(line)
+	if err != nil {
+		t.Fatalf("ParseKeyLine failed: %v", err)
+	}
+	if parsed.Label != key.Label || !parsed.CreatedAt.Equal(created) {
+		t.Errorf("Expected label and creation time to round-trip, got %+v", parsed)
+	}
+
+	info := parsed.Info()
+	if info.Label != key.Label || info.CreatedAt == nil || !info.CreatedAt.Equal(created) {
+		t.Errorf("Expected label and creation time in key info, got %+v", info)
+	}
+
+	if _, err := ParseKeyLine("$2a$12$abc label=" + strings.Repeat("x", maxLabelLength+1)); err == nil {
+		t.Error("Expected error for an overlong label")
+	}
+	if _, err := ParseKeyLine("$2a$12$abc label=bad%0Alabel"); err == nil {
+		t.Error("Expected error for a label with control characters")
+	}
+	if _, err := ParseKeyLine("$2a$12$abc created=yesterday"); err == nil {
+		t.Error("Expected error for an invalid creation time")
+	}
+}
+
+func TestFileStoreExpiredKeyLogged(t *testing.T) {
+	orgID := uuid.New()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("expired-key"), bcrypt.MinCost)
+	store, _ := newRotationStore(t, fmt.Sprintf("[%s]\n%s label=old-ci expires=2020-01-01T00:00:00Z\n", orgID, hash))
+
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if valid, err := store.ValidateCredentials(orgID, "expired-key"); err != nil || valid {
+		t.Fatalf("Expected expired key to be rejected, got %v, %v", valid, err)
+	}
+	if !strings.Contains(logs.String(), "Expired API key used") || !strings.Contains(logs.String(), "old-ci") {
+		t.Errorf("Expected the expired key to be logged with its own reason, got %q", logs.String())
+	}
+
+	logs.Reset()
+	if valid, _ := store.ValidateCredentials(orgID, "wrong-key"); valid {
+		t.Fatal("Expected wrong key to be rejected")
+	}
+	if strings.Contains(logs.String(), "Expired API key used") {
+		t.Errorf("Expected a wrong key not to be logged as expired, got %q", logs.String())
+	}
+}
+
+func TestGenerateAPIKeyPrefixed(t *testing.T) {
+	apiKey, keyID, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey failed: %v", err)
+	}
+	if !strings.HasPrefix(apiKey, APIKeyPrefix+keyID+"_") || len(keyID) != keyIDLength {
+		t.Errorf("Unexpected key %q with ID %q", apiKey, keyID)
+	}
+	if parsed, ok := ParseAPIKeyID(apiKey); !ok || parsed != keyID {
+		t.Errorf("ParseAPIKeyID(%q) = %q, %v", apiKey, parsed, ok)
+	}
+
+	for _, apiKey := range []string{"legacy-key", "etk_", "etk_3f9a1c04b2e7", "etk_3f9a1c04b2e7_", "etk_3F9A1C04B2E7_secret", "etk_3f9a1c04_secret"} {
+		if _, ok := ParseAPIKeyID(apiKey); ok {
+			t.Errorf("Expected %q not to carry a key ID", apiKey)
+		}
+	}
+}
+
+func TestKeyIDRoundTrip(t *testing.T) {
+	key := StoredKey{Key: "$2a$12$abc", KeyID: "3f9a1c04b2e7", Scopes: []string{ScopeStateRead}}
+	line := key.String()
+	if line != "$2a$12$abc id=3f9a1c04b2e7 scopes=state:read" {
+		t.Errorf("Unexpected key line %q", line)
+	}
+
+	parsed, err := ParseKeyLine(line)
+	if err != nil {
+		t.Fatalf("ParseKeyLine failed: %v", err)
+	}
+	if parsed.KeyID != key.KeyID || parsed.ID() != key.KeyID {
+		t.Errorf("Expected the key ID to round-trip and identify the key, got %+v", parsed)
+	}
+
+	if _, err := ParseKeyLine("$2a$12$abc id=not-hex"); err == nil {
+		t.Error("Expected error for an invalid key ID")
+	}
+}
+
+func TestFileStorePrefixedKeyLookup(t *testing.T) {
+	orgID := uuid.New()
+	prefixedKey := "etk_3f9a1c04b2e7_secret"
+	prefixedHash, _ := bcrypt.GenerateFromPassword([]byte(prefixedKey), bcrypt.MinCost)
+	legacyHash, _ := bcrypt.GenerateFromPassword([]byte("legacy-key"), bcrypt.MinCost)
+	// The hash of a prefixed key stored under another ID is never compared
+	misfiledKey := "etk_0000000000aa_secret"
+	misfiledHash, _ := bcrypt.GenerateFromPassword([]byte(misfiledKey), bcrypt.MinCost)
+
+	content := fmt.Sprintf("[%s]\n%s id=3f9a1c04b2e7\n%s\n%s id=0000000000bb\n", orgID, prefixedHash, legacyHash, misfiledHash)
+	store, _ := newRotationStore(t, content)
+
+	key, valid, err := store.AuthenticateKey(orgID, prefixedKey)
+	if err != nil || !valid || key.ID() != "3f9a1c04b2e7" {
+		t.Errorf("Expected prefixed key to authenticate as 3f9a1c04b2e7, got %v, %v, %v", key.ID(), valid, err)
+	}
+	if valid, _ := store.ValidateCredentials(orgID, "legacy-key"); !valid {
+		t.Error("Expected legacy key to authenticate")
+	}
+	if valid, _ := store.ValidateCredentials(orgID, misfiledKey); valid {
+		t.Error("Expected key to be rejected when no stored key has its ID")
+	}
+
+	// Keys generated by the store are prefixed and stored with their ID
+	added, err := store.AddKey(orgID, nil, "", time.Time{})
+	if err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+	if keyID, ok := ParseAPIKeyID(added.APIKey); !ok || keyID != added.ID {
+		t.Errorf("Expected a prefixed key with ID %s, got %q", added.ID, added.APIKey)
+	}
+	if valid, _ := store.ValidateCredentials(orgID, added.APIKey); !valid {
+		t.Error("Expected added key to authenticate")
+	}
+}
+
+func TestFileStorePreHashedPrefixedKey(t *testing.T) {
+	orgID := uuid.New()
+	prefixedKey := "etk_3f9a1c04b2e7_secret"
+	// Hashed elsewhere and stored without its ID
+	prefixedHash, _ := bcrypt.GenerateFromPassword([]byte(prefixedKey), bcrypt.MinCost)
+	legacyHash, _ := bcrypt.GenerateFromPassword([]byte("legacy-key"), bcrypt.MinCost)
+
+	content := fmt.Sprintf("[%s]\n%s\n%s label=migrated\n", orgID, legacyHash, prefixedHash)
+	store, tmpFile := newRotationStore(t, content)
+
+	key, valid, err := store.AuthenticateKey(orgID, prefixedKey)
+	if err != nil || !valid || key.ID() != "3f9a1c04b2e7" {
+		t.Fatalf("Expected the pre-hashed prefixed key to authenticate as 3f9a1c04b2e7, got %v, %v, %v", key.ID(), valid, err)
+	}
+	if valid, _ := store.ValidateCredentials(orgID, "etk_3f9a1c04b2e7_wrong"); valid {
+		t.Error("Expected a wrong secret to be rejected")
+	}
+	store.Close()
+
+	// The ID is recorded, so the key is only compared with its own hash
+	data, _ := os.ReadFile(tmpFile)
+	if !strings.Contains(string(data), string(prefixedHash)+" id=3f9a1c04b2e7 label=migrated") {
+		t.Errorf("Expected the key ID to be recorded, got:\n%s", data)
+	}
+	reloaded, _ := newRotationStore(t, string(data))
+	defer reloaded.Close()
+	key, valid, err = reloaded.AuthenticateKey(orgID, prefixedKey)
+	if err != nil || !valid || key.KeyID != "3f9a1c04b2e7" {
+		t.Errorf("Expected the recorded ID to be loaded, got %+v, %v, %v", key, valid, err)
+	}
+	if valid, _ := reloaded.ValidateCredentials(orgID, "legacy-key"); !valid {
+		t.Error("Expected the legacy key to keep working")
+	}
+}
+
+// newRotationStore writes an auth config and loads it with cheap hashing
+func newRotationStore(t *testing.T, content string) (*FileStore, string) {
+	t.Helper()
+
+	tmpFile := filepath.Join(t.TempDir(), "auth.cfg")
+	if err := os.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := LoadFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to load store: %v", err)
+	}
+	if err := store.SetKeyCost(bcrypt.MinCost); err != nil {
+		t.Fatalf("SetKeyCost failed: %v", err)
+	}
+
+	return store, tmpFile
+}
+
+func TestFileStoreRotateKeys(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	otherOrgID := uuid.MustParse("22222222-3333-4444-5555-666666666666")
+	content := fmt.Sprintf("# ops team\n[%s]\n# CI key\nold-key\n\n[%s]\nother-key\n", orgID, otherOrgID)
+	store, tmpFile := newRotationStore(t, content)
+
+	deprecateAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	rotation, err := store.RotateKeys(orgID, "", deprecateAfter)
+	if err != nil {
+		t.Fatalf("RotateKeys failed: %v", err)
+	}
+	if len(rotation.DeprecatedKeyIDs) != 1 {
+		t.Errorf("Expected 1 deprecated key, got %v", rotation.DeprecatedKeyIDs)
+	}
+
+	// Both keys work during the overlap window
+	for _, key := range []string{"old-key", rotation.APIKey} {
+		if valid, err := store.ValidateCredentials(orgID, key); err != nil || !valid {
+			t.Errorf("Expected %q to validate during overlap, got valid=%v err=%v", key, valid, err)
+		}
+	}
+
+	updated, _ := os.ReadFile(tmpFile)
+	want := "old-key deprecated_after=" + deprecateAfter.UTC().Format(time.RFC3339) + "\n$2a$04$"
+	if !strings.Contains(string(updated), want) {
+		t.Errorf("Expected deprecated old key followed by new hash, got:\n%s", updated)
+	}
+	for _, kept := range []string{"# ops team", "# CI key", fmt.Sprintf("[%s]\nother-key", otherOrgID)} {
+		if !strings.Contains(string(updated), kept) {
+			t.Errorf("Expected %q to be preserved, got:\n%s", kept, updated)
+		}
+	}
+
+	keys, ok := store.Keys(orgID)
+	if !ok || len(keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %v", keys)
+	}
+	if keys[0].DeprecatedAfter == nil || keys[1].DeprecatedAfter != nil || keys[1].ID != rotation.KeyID {
+		t.Errorf("Unexpected key list: %+v", keys)
+	}
+}
+
+func TestFileStoreProvisionOrg(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "config", "auth.cfg")
+	created, err := CreateAuthConfig(tmpFile)
+	if err != nil || !created {
+		t.Fatalf("CreateAuthConfig failed: created=%v err=%v", created, err)
+	}
+	if created, err := CreateAuthConfig(tmpFile); err != nil || created {
+		t.Errorf("Expected an existing file to be kept, got created=%v err=%v", created, err)
+	}
+	if info, err := os.Stat(tmpFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	store, err := LoadFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to load empty store: %v", err)
+	}
+	store.SetKeyCost(bcrypt.MinCost)
+
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	provisioned, err := store.ProvisionOrg(orgID, nil)
+	if err != nil {
+		t.Fatalf("ProvisionOrg failed: %v", err)
+	}
+	if valid, err := store.ValidateCredentials(orgID, provisioned.APIKey); err != nil || !valid {
+		t.Errorf("Expected the provisioned key to validate, got valid=%v err=%v", valid, err)
+	}
+
+	generated, err := store.ProvisionOrg(uuid.Nil, []string{ScopeDataWrite})
+	if err != nil {
+		t.Fatalf("ProvisionOrg with a generated ID failed: %v", err)
+	}
+	generatedID := uuid.MustParse(generated.OrgID)
+	if keys, ok := store.Keys(generatedID); !ok || len(keys) != 1 || strings.Join(keys[0].Scopes, ",") != ScopeDataWrite {
+		t.Errorf("Expected one data:write key, got %+v", keys)
+	}
+
+	if _, err := store.ProvisionOrg(orgID, nil); !errors.Is(err, ErrOrgExists) {
+		t.Errorf("Expected ErrOrgExists, got %v", err)
+	}
+	if _, err := store.ProvisionOrg(uuid.Nil, []string{"bogus"}); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("Expected ErrInvalidKeyRequest, got %v", err)
+	}
+
+	// The file stays loadable with both sections
+	reloaded, err := LoadFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	for _, id := range []uuid.UUID{orgID, generatedID} {
+		if _, ok := reloaded.Keys(id); !ok {
+			t.Errorf("Expected org %s after reload", id)
+		}
+	}
+}
+
+func TestFileStoreRotateSingleKey(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	store, _ := newRotationStore(t, fmt.Sprintf("[%s]\nkey-a\nkey-b\n", orgID))
+
+	keys, _ := store.Keys(orgID)
+	rotation, err := store.RotateKeys(orgID, keys[1].ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RotateKeys failed: %v", err)
+	}
+	if len(rotation.DeprecatedKeyIDs) != 1 || rotation.DeprecatedKeyIDs[0] != keys[1].ID {
+		t.Errorf("Expected only key-b to be deprecated, got %v", rotation.DeprecatedKeyIDs)
+	}
+
+	if _, err := store.RotateKeys(orgID, "does-not-exist", time.Now()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := store.RotateKeys(uuid.New(), "", time.Now()); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("Expected ErrOrgNotFound, got %v", err)
+	}
+}
+
+func TestFileStoreDeprecatedKeyRevocation(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	content := fmt.Sprintf("[%s]\nexpired-key deprecated_after=2020-01-01\ncurrent-key\n", orgID)
+	store, tmpFile := newRotationStore(t, content)
+
+	if valid, _ := store.ValidateCredentials(orgID, "expired-key"); valid {
+		t.Error("Expected key past its overlap window to be rejected")
+	}
+	if valid, _ := store.ValidateCredentials(orgID, "current-key"); !valid {
+		t.Error("Expected current key to validate")
+	}
+
+	revoked, err := store.RevokeExpiredKeys(time.Now())
+	if err != nil {
+		t.Fatalf("RevokeExpiredKeys failed: %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("Expected 1 revoked key, got %d", revoked)
+	}
+
+	updated, _ := os.ReadFile(tmpFile)
+	if strings.Contains(string(updated), "expired-key") || !strings.Contains(string(updated), "current-key") {
+		t.Errorf("Expected only the expired key to be removed, got:\n%s", updated)
+	}
+
+	if revoked, _ := store.RevokeExpiredKeys(time.Now()); revoked != 0 {
+		t.Errorf("Expected nothing left to revoke, got %d", revoked)
+	}
+}
+
+func TestFileStoreIssueKey(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	content := fmt.Sprintf("[%s]\nadmin-key scopes=keys:manage,state:read,state:write\nplain-key\n", orgID)
+	store, tmpFile := newRotationStore(t, content)
+
+	admin, valid, err := store.AuthenticateKey(orgID, "admin-key")
+	if err != nil || !valid {
+		t.Fatalf("Expected admin key to authenticate, got valid=%v err=%v", valid, err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	issued, err := store.IssueKey(orgID, admin, []string{ScopeStateRead}, "", expiresAt)
+	if err != nil {
+		t.Fatalf("IssueKey failed: %v", err)
+	}
+	if issued.Parent != admin.ID() {
+		t.Errorf("Expected parent %s, got %s", admin.ID(), issued.Parent)
+	}
+
+	sub, valid, err := store.AuthenticateKey(orgID, issued.APIKey)
+	if err != nil || !valid {
+		t.Fatalf("Expected sub-key to authenticate, got valid=%v err=%v", valid, err)
+	}
+	if !sub.HasScope(ScopeStateRead) || sub.HasScope(ScopeStateWrite) {
+		t.Errorf("Expected sub-key limited to state:read, got %v", sub.Scopes)
+	}
+
+	updated, _ := os.ReadFile(tmpFile)
+	if !strings.Contains(string(updated), "scopes=state:read expires=") || !strings.Contains(string(updated), "parent="+admin.ID()) {
+		t.Errorf("Expected sub-key attributes in auth config, got:\n%s", updated)
+	}
+
+	// Escalation is rejected
+	tests := []struct {
+		name   string
+		parent StoredKey
+		scopes []string
+	}{
+		{name: "scope the parent lacks", parent: admin, scopes: []string{ScopeDataWrite}},
+		{name: "delegate keys:manage", parent: admin, scopes: []string{ScopeKeysManage}},
+		{name: "parent without keys:manage", parent: sub, scopes: []string{ScopeStateRead}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.IssueKey(orgID, tt.parent, tt.scopes, "", time.Time{}); !errors.Is(err, ErrForbidden) {
+				t.Errorf("Expected ErrForbidden, got %v", err)
+			}
+		})
+	}
+
+	if _, err := store.IssueKey(orgID, admin, []string{"state:everything"}, "", time.Time{}); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("Expected ErrInvalidKeyRequest for unknown scope, got %v", err)
+	}
+
+	// Only issued sub-keys can be revoked through the org API
+	plain, _, _ := store.AuthenticateKey(orgID, "plain-key")
+	if err := store.RevokeSubKey(orgID, plain.ID()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden revoking an operator key, got %v", err)
+	}
+	if err := store.RevokeSubKey(orgID, issued.ID); err != nil {
+		t.Fatalf("RevokeSubKey failed: %v", err)
+	}
+	if _, valid, _ := store.AuthenticateKey(orgID, issued.APIKey); valid {
+		t.Error("Expected revoked sub-key to be rejected")
+	}
+}
+
+func TestFileStoreAddAndRevokeKey(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	otherID := uuid.MustParse("22222222-3333-4444-5555-666666666666")
+	content := fmt.Sprintf("[%s]\nplain-key\n\n[%s]\nother-key\n", orgID, otherID)
+	store, tmpFile := newRotationStore(t, content)
+
+	added, err := store.AddKey(orgID, []string{ScopeKeysManage, ScopeStateRead}, "deploy bot", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+	if added.Label != "deploy bot" || added.CreatedAt == nil {
+		t.Errorf("Expected label and creation time on the added key, got %+v", added.KeyInfo)
+	}
+	manager, valid, err := store.AuthenticateKey(orgID, added.APIKey)
+	if err != nil || !valid || !manager.HasScope(ScopeKeysManage) || manager.ExpiresAt.IsZero() || manager.Label != "deploy bot" {
+		t.Fatalf("Expected the added key to authenticate with keys:manage, an expiry and its label, got %+v, valid=%v, err=%v", manager, valid, err)
+	}
+	updated, _ := os.ReadFile(tmpFile)
+	if strings.Contains(string(updated), added.APIKey) || !strings.Contains(string(updated), "$2a$") {
+		t.Errorf("Expected only the bcrypt hash of the added key in auth config, got:\n%s", updated)
+	}
+
+	if _, err := store.AddKey(uuid.New(), nil, "", time.Time{}); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("Expected ErrOrgNotFound, got %v", err)
+	}
+	if _, err := store.AddKey(orgID, []string{"bogus"}, "", time.Time{}); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("Expected ErrInvalidKeyRequest for an unknown scope, got %v", err)
+	}
+	if _, err := store.AddKey(orgID, nil, "bad\tlabel", time.Time{}); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("Expected ErrInvalidKeyRequest for an invalid label, got %v", err)
+	}
+	if _, err := store.AddKey(orgID, nil, "", time.Now().Add(-time.Hour)); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("Expected ErrInvalidKeyRequest for a past expiry, got %v", err)
+	}
+
+	// Revoking a key revokes the keys it issued
+	sub, err := store.IssueKey(orgID, manager, []string{ScopeStateRead}, "", time.Time{})
+	if err != nil {
+		t.Fatalf("IssueKey failed: %v", err)
+	}
+	revoked, err := store.RevokeKey(orgID, added.ID)
+	if err != nil {
+		t.Fatalf("RevokeKey failed: %v", err)
+	}
+	if strings.Join(revoked, ",") != added.ID+","+sub.ID {
+		t.Errorf("Expected %s and its sub-key %s to be revoked, got %v", added.ID, sub.ID, revoked)
+	}
+	for _, key := range []string{added.APIKey, sub.APIKey} {
+		if valid, _ := store.ValidateCredentials(orgID, key); valid {
+			t.Error("Expected revoked keys to be rejected")
+		}
+	}
+
+	plain, _, _ := store.AuthenticateKey(orgID, "plain-key")
+	if _, err := store.RevokeKey(orgID, plain.ID()); !errors.Is(err, ErrLastKey) {
+		t.Errorf("Expected ErrLastKey, got %v", err)
+	}
+	if _, err := store.RevokeKey(orgID, "does-not-exist"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	orgs := store.Orgs()
+	if len(orgs) != 2 || orgs[0].OrgID != orgID || orgs[0].Keys != 1 || orgs[1].OrgID != otherID {
+		t.Errorf("Unexpected organizations %+v", orgs)
+	}
+}
+
+func TestFileStoreExpiredKey(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	content := fmt.Sprintf("[%s]\nold-sub scopes=state:read expires=2020-01-01 parent=abc\n", orgID)
+	store, _ := newRotationStore(t, content)
+
+	if valid, _ := store.ValidateCredentials(orgID, "old-sub"); valid {
+		t.Error("Expected expired key to be rejected")
+	}
+	if revoked, _ := store.RevokeExpiredKeys(time.Now()); revoked != 1 {
+		t.Errorf("Expected expired key to be revoked, got %d", revoked)
+	}
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+// FailureTracker counts failed credential validations per organization and
+// client IP and locks a pair out after threshold failures within window, so
+// API keys cannot be guessed at the rate the server answers. Locked out
+// requests are rejected before their key is compared. Each consecutive
+// lockout of a pair lasts twice as long as the previous one, up to
+// maxLockout; a successful authentication resets the pair. Pairs rather
+// than organizations are locked out, so a client cannot lock others out of
+// their organization. At most maxTracked pairs are tracked; the least
+// recently seen is evicted when full, so failures spread over many IPs or
+// guessed organizations cannot grow memory without bound.
+type FailureTracker struct {
+	threshold  int
+	window     time.Duration
+	lockout    time.Duration
+	maxLockout time.Duration
+	maxTracked int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // org ID and client IP -> failures
+	lru     *list.List
+
+	lockouts   atomic.Int64
+	rejections atomic.Int64
+	stop       chan struct{}
+}
+
+// failureEntry is the failure record of an organization and client IP
+type failureEntry struct {
+	key         string
+	failures    int       // Failures since windowStart
+	windowStart time.Time // First failure counted
+	strikes     int       // Consecutive lockouts
+	lockedUntil time.Time
+}
+
+// NewFailureTracker creates a tracker locking a pair out for lockout after
+// threshold failures within window, doubling up to maxLockout, keeping at
+// most maxTracked pairs, and starts removing idle pairs
+func NewFailureTracker(threshold int, window, lockout, maxLockout time.Duration, maxTracked int) *FailureTracker {
+	if maxLockout < lockout {
+		maxLockout = lockout
+	}
+	if maxTracked < 1 {
+		maxTracked = 1
+	}
+	t := &FailureTracker{
+		threshold:  threshold,
+		window:     window,
+		lockout:    lockout,
+		maxLockout: maxLockout,
+		maxTracked: maxTracked,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		stop:       make(chan struct{}),
+	}
+	go t.cleanupRoutine()
+	return t
+}
+
+// cleanupRoutine removes pairs that are neither locked out nor counting
+// failures, to bound the map
+func (t *FailureTracker) cleanupRoutine() {
+	ticker := time.NewTicker(t.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.cleanup()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// cleanup removes idle pairs. Pairs that were never locked out are removed
+// once their window has passed; strikes are kept while the longest lockout
+// could still follow, so waiting out a lockout does not reset its growth.
+func (t *FailureTracker) cleanup() {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, elem := range t.entries {
+		entry := elem.Value.(*failureEntry)
+		if entry.strikes == 0 && now.Sub(entry.windowStart) > t.window {
+			t.remove(elem)
+			continue
+		}
+		last := entry.lockedUntil
+		if end := entry.windowStart.Add(t.window); end.After(last) {
+			last = end
+		}
+		if now.Sub(last) > t.maxLockout {
+			t.remove(elem)
+		}
+	}
+}
+
+// remove stops tracking a pair. The caller must hold t.mu.
+func (t *FailureTracker) remove(elem *list.Element) {
+	t.lru.Remove(elem)
+	delete(t.entries, elem.Value.(*failureEntry).key)
+}
+
+// Stop stops the cleanup goroutine
+func (t *FailureTracker) Stop() {
+	close(t.stop)
+}
+
+// Stats returns the number of tracked pairs, the pairs locked out now, the
+// lockouts triggered and the requests rejected while locked out
+func (t *FailureTracker) Stats() (tracked, locked int, lockouts, rejections int64) {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, elem := range t.entries {
+		if now.Before(elem.Value.(*failureEntry).lockedUntil) {
+			locked++
+		}
+	}
+	return len(t.entries), locked, t.lockouts.Load(), t.rejections.Load()
+}
+
+// failureKey identifies an organization and client IP
+func failureKey(orgID uuid.UUID, ip string) string {
+	return orgID.String() + "/" + ip
+}
+
+// LockedOut returns how much longer the pair is locked out, or zero
+func (t *FailureTracker) LockedOut(orgID uuid.UUID, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[failureKey(orgID, ip)]
+	if !ok {
+		return 0
+	}
+	if remaining := elem.Value.(*failureEntry).lockedUntil.Sub(t.now()); remaining > 0 {
+		// Pairs still trying are the last evicted
+		t.lru.MoveToFront(elem)
+		return remaining
+	}
+	return 0
+}
+
+// Failure records a failed validation of the pair. It returns the lockout
+// duration if this failure locked the pair out, or zero.
+func (t *FailureTracker) Failure(orgID uuid.UUID, ip string) time.Duration {
+	now := t.now()
+	key := failureKey(orgID, ip)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entry *failureEntry
+	if elem, ok := t.entries[key]; ok {
+		t.lru.MoveToFront(elem)
+		entry = elem.Value.(*failureEntry)
+	} else {
+		for t.lru.Len() >= t.maxTracked {
+			t.remove(t.lru.Back())
+		}
+		entry = &failureEntry{key: key}
+		t.entries[key] = t.lru.PushFront(entry)
+	}
+	if entry.failures == 0 || now.Sub(entry.windowStart) > t.window {
+		entry.failures = 0
+		entry.windowStart = now
+	}
+	entry.failures++
+	if entry.failures < t.threshold {
+		return 0
+	}
+
+	duration := t.lockout
+	for i := 0; i < entry.strikes && duration < t.maxLockout; i++ {
+		duration *= 2
+	}
+	duration = min(duration, t.maxLockout)
+	entry.strikes++
+	entry.failures = 0
+	entry.lockedUntil = now.Add(duration)
+	t.lockouts.Add(1)
+	return duration
+}
+
+// Success records a successful authentication of the pair, forgetting its
+// failures and lockouts
+func (t *FailureTracker) Success(orgID uuid.UUID, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if elem, ok := t.entries[failureKey(orgID, ip)]; ok {
+		t.remove(elem)
+	}
+}
+
+// failureTrackerKey is the context key of the tracker and client IP of a
+// request, so Middleware can record the outcome of its validation
+const failureTrackerKey contextKey = "failure-tracker"
+
+// trackedClient is a request's tracker and client IP
+type trackedClient struct {
+	tracker *FailureTracker
+	ip      string
+}
+
+// Middleware rejects requests of locked out pairs with 429 before their key
+// is compared, and has Middleware record the outcome of the others. It must
+// run after RealIP, so clients behind trusted proxies are told apart, and
+// before Middleware.
+func (t *FailureTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := uuid.Parse(r.Header.Get("X-Org-ID"))
+		if err != nil {
+			// Rejected by Middleware without a key comparison
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip = host
+		}
+
+		if remaining := t.LockedOut(orgID, ip); remaining > 0 {
+			t.rejections.Add(1)
+			security.Emit(security.Request(r, "auth.locked_out", security.SeverityWarning, "Rejected request of locked out client").
+				WithOrg(orgID.String()).With("retry_after", remaining.Round(time.Second).String()))
+			w.Header().Set("Retry-After", strconv.Itoa(int((remaining+time.Second-1)/time.Second)))
+			http.Error(w, "Too many failed authentication attempts. Please try again later.", http.StatusTooManyRequests)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), failureTrackerKey, &trackedClient{tracker: t, ip: ip})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recordAuthFailure records a failed validation with the request's tracker,
+// if any, and logs the lockout it triggers
+func recordAuthFailure(r *http.Request, orgID uuid.UUID) {
+	client, ok := r.Context().Value(failureTrackerKey).(*trackedClient)
+	if !ok {
+		return
+	}
+	if duration := client.tracker.Failure(orgID, client.ip); duration > 0 {
+		security.Emit(security.Request(r, "auth.lockout", security.SeverityCritical, "Locked out client after repeated failed authentication").
+			WithOrg(orgID.String()).With("duration", duration.String()))
+	}
+}
+
+// recordAuthSuccess resets the request's pair with its tracker, if any
+func recordAuthSuccess(r *http.Request, orgID uuid.UUID) {
+	if client, ok := r.Context().Value(failureTrackerKey).(*trackedClient); ok {
+		client.tracker.Success(orgID, client.ip)
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFailureTrackerLockout(t *testing.T) {
+	tr := NewFailureTracker(3, time.Minute, time.Minute, 3*time.Minute, 100)
+	defer tr.Stop()
+	now := time.Now()
+	tr.now = func() time.Time { return now }
+
+	orgID := uuid.New()
+	const ip = "10.0.0.1"
+
+	// Failures spread beyond the window do not add up
+	tr.Failure(orgID, ip)
+	tr.Failure(orgID, ip)
+	now = now.Add(2 * time.Minute)
+	if d := tr.Failure(orgID, ip); d != 0 {
+		t.Fatalf("Expected no lockout after a window reset, got %v", d)
+	}
+	tr.Failure(orgID, ip)
+	if d := tr.Failure(orgID, ip); d != time.Minute {
+		t.Fatalf("Expected a 1m lockout at the threshold, got %v", d)
+	}
+	if remaining := tr.LockedOut(orgID, ip); remaining != time.Minute {
+		t.Errorf("Expected 1m remaining, got %v", remaining)
+	}
+	if tr.LockedOut(orgID, "10.0.0.2") != 0 || tr.LockedOut(uuid.New(), ip) != 0 {
+		t.Error("Lockouts should only apply to the org and IP pair")
+	}
+
+	// Consecutive lockouts double up to the maximum
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		now = now.Add(10 * time.Minute)
+		if tr.LockedOut(orgID, ip) != 0 {
+			t.Fatal("Expected the lockout to have expired")
+		}
+		var d time.Duration
+		for i := 0; i < 3; i++ {
+			d = tr.Failure(orgID, ip)
+		}
+		if d != want {
+			t.Errorf("Expected a %v lockout, got %v", want, d)
+		}
+	}
+
+	tracked, locked, lockouts, _ := tr.Stats()
+	if tracked != 1 || locked != 1 || lockouts != 4 {
+		t.Errorf("Unexpected stats: tracked %d, locked %d, lockouts %d", tracked, locked, lockouts)
+	}
+
+	// Success resets the pair, and idle pairs are removed
+	tr.Success(orgID, ip)
+	if tr.LockedOut(orgID, ip) != 0 {
+		t.Error("Expected success to clear the lockout")
+	}
+	tr.Failure(orgID, ip)
+	now = now.Add(time.Hour)
+	tr.cleanup()
+	if tracked, _, _, _ := tr.Stats(); tracked != 0 {
+		t.Errorf("Expected idle pairs to be removed, got %d", tracked)
+	}
+}
+
+func TestFailureTrackerBounded(t *testing.T) {
+	tr := NewFailureTracker(3, time.Minute, time.Minute, time.Hour, 3)
+	defer tr.Stop()
+	now := time.Now()
+	tr.now = func() time.Time { return now }
+
+	lockedOrg, orgID := uuid.New(), uuid.New()
+	for i := 0; i < 3; i++ {
+		tr.Failure(lockedOrg, "10.0.0.1")
+	}
+
+	// Failures from many IPs evict the least recently seen pairs, but a
+	// locked out pair that keeps trying stays tracked
+	for i := 0; i < 10; i++ {
+		tr.Failure(orgID, fmt.Sprintf("10.0.1.%d", i))
+		if tr.LockedOut(lockedOrg, "10.0.0.1") == 0 {
+			t.Fatalf("Expected the active lockout to survive eviction after %d pairs", i+1)
+		}
+	}
+	if tracked, _, _, _ := tr.Stats(); tracked != 3 {
+		t.Errorf("Expected at most 3 tracked pairs, got %d", tracked)
+	}
+
+	// Pairs never locked out are swept once their window has passed
+	now = now.Add(2 * time.Minute)
+	tr.cleanup()
+	if tracked, locked, _, _ := tr.Stats(); tracked != 1 || locked != 0 {
+		t.Errorf("Expected only the pair with strikes to remain, got %d tracked, %d locked", tracked, locked)
+	}
+}
+
+func TestFailureTrackerMiddleware(t *testing.T) {
+	store := NewInMemoryStore()
+	orgID := uuid.New()
+	store.AddCredentials(orgID, "correct-key")
+
+	tr := NewFailureTracker(2, time.Minute, time.Minute, time.Hour, 100)
+	defer tr.Stop()
+	handler := tr.Middleware(Middleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	request := func(key, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Org-ID", orgID.String())
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A success resets the failures counted so far
+	request("wrong-key", "10.0.0.1:1000")
+	if rec := request("correct-key", "10.0.0.1:1001"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	request("wrong-key", "10.0.0.1:1002")
+	if rec := request("correct-key", "10.0.0.1:1003"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 below the threshold, got %d", rec.Code)
+	}
+
+	request("wrong-key", "10.0.0.1:1004")
+	if rec := request("wrong-key", "10.0.0.1:1005"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the failure that locks out to get 401, got %d", rec.Code)
+	}
+
+	// Even the correct key is rejected while locked out, from any port
+	rec := request("correct-key", "10.0.0.1:1006")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 while locked out, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// Other clients are not affected
+	if rec := request("correct-key", "10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another IP to authenticate, got %d", rec.Code)
+	}
+	if _, _, _, rejections := tr.Stats(); rejections != 1 {
+		t.Errorf("Expected 1 rejection, got %d", rejections)
+	}
+}
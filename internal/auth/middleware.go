@@ -2,19 +2,18 @@ package auth
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"strings"
 
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/eterrain/tf-backend-service/internal/security"
 	"github.com/google/uuid"
 )
 
+// contextKey is the type of the context keys owned by this package; the
+// organization is recorded with reqctx
 type contextKey string
 
-const (
-	OrgIDContextKey contextKey = "orgid"
-)
-
 // Credentials represents the authentication credentials
 type Credentials struct {
 	OrgID  uuid.UUID
@@ -26,6 +25,12 @@ type CredentialStore interface {
 	ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error)
 }
 
+// KeyAuthenticator is implemented by stores that can report which key
+// matched, so its scopes can be enforced
+type KeyAuthenticator interface {
+	AuthenticateKey(orgID uuid.UUID, apiKey string) (StoredKey, bool, error)
+}
+
 // Middleware creates an authentication middleware that validates orgid and apikey
 func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -33,8 +38,7 @@ func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 			// Extract orgid from header
 			orgIDStr := r.Header.Get("X-Org-ID")
 			if orgIDStr == "" {
-				log.Printf("SECURITY: Missing X-Org-ID header - IP: %s, Path: %s, UserAgent: %s",
-					r.RemoteAddr, r.URL.Path, r.UserAgent())
+				security.Emit(security.Request(r, "auth.missing_org_id", security.SeverityWarning, "Missing X-Org-ID header"))
 				http.Error(w, "Missing X-Org-ID header", http.StatusUnauthorized)
 				return
 			}
@@ -42,8 +46,8 @@ func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 			// Parse orgid as UUID
 			orgID, err := uuid.Parse(orgIDStr)
 			if err != nil {
-				log.Printf("SECURITY: Invalid X-Org-ID format '%s' - IP: %s, Path: %s",
-					orgIDStr, r.RemoteAddr, r.URL.Path)
+				security.Emit(security.Request(r, "auth.invalid_org_id", security.SeverityWarning, "Invalid X-Org-ID format").
+					With("x_org_id", orgIDStr))
 				http.Error(w, "Invalid X-Org-ID format: must be a valid UUID", http.StatusUnauthorized)
 				return
 			}
@@ -51,17 +55,28 @@ func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 			// Extract apikey from header
 			apiKey := r.Header.Get("X-API-Key")
 			if apiKey == "" {
-				log.Printf("SECURITY: Missing X-API-Key header - OrgID: %s, IP: %s, Path: %s",
-					orgID, r.RemoteAddr, r.URL.Path)
+				security.Emit(security.Request(r, "auth.missing_api_key", security.SeverityWarning, "Missing X-API-Key header").
+					WithOrg(orgID.String()))
 				http.Error(w, "Missing X-API-Key header", http.StatusUnauthorized)
 				return
 			}
 
 			// Validate credentials
-			valid, err := store.ValidateCredentials(orgID, apiKey)
+			var key StoredKey
+			var valid, hasKey, grace bool
+			graceStore, graceful := store.(*GraceStore)
+			if graceful {
+				key, valid, grace, err = graceStore.Authenticate(orgID, apiKey)
+				hasKey = true
+			} else if authenticator, ok := store.(KeyAuthenticator); ok {
+				key, valid, err = authenticator.AuthenticateKey(orgID, apiKey)
+				hasKey = true
+			} else {
+				valid, err = store.ValidateCredentials(orgID, apiKey)
+			}
 			if err != nil {
-				log.Printf("SECURITY: Credential validation error - OrgID: %s, IP: %s, Error: %v",
-					orgID, r.RemoteAddr, err)
+				security.Emit(security.Request(r, "auth.error", security.SeverityCritical, "Credential validation error").
+					WithOrg(orgID.String()).With("error", err))
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
@@ -72,18 +87,39 @@ func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 				if len(apiKey) > 8 {
 					apiKeyPrefix = apiKey[:8] + "..."
 				}
-				log.Printf("SECURITY: Failed authentication - OrgID: %s, APIKeyPrefix: %s, IP: %s, Path: %s, UserAgent: %s",
-					orgID, apiKeyPrefix, r.RemoteAddr, r.URL.Path, r.UserAgent())
+				security.Emit(security.Request(r, "auth.failure", security.SeverityWarning, "Failed authentication").
+					WithOrg(orgID.String()).With("api_key_prefix", apiKeyPrefix))
+				recordAuthFailure(r, orgID)
 				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 				return
 			}
 
+			// Credentials accepted from the grace cache are logged loudly, and
+			// may only read while the backend is degraded
+			if grace {
+				security.Emit(security.Request(r, "auth.grace", security.SeverityCritical, "Accepted cached credentials while the auth backend is degraded").
+					WithOrg(orgID.String()).WithKey(key.ID()))
+				if !graceStore.graceReadAllowed(r) {
+					w.Header().Set("Retry-After", "60")
+					http.Error(w, "Authentication backend degraded: only read requests are accepted", http.StatusServiceUnavailable)
+					return
+				}
+			}
+
 			// Log successful authentication
-			log.Printf("SECURITY: Successful authentication - OrgID: %s, IP: %s, Method: %s, Path: %s",
-				orgID, r.RemoteAddr, r.Method, r.URL.Path)
+			event := security.Request(r, "auth.success", security.SeverityInfo, "Successful authentication").
+				WithOrg(orgID.String())
+			if hasKey {
+				event = event.WithKey(key.ID())
+			}
+			security.Emit(event)
+			recordAuthSuccess(r, orgID)
 
-			// Store orgID in context for use by handlers
-			ctx := context.WithValue(r.Context(), OrgIDContextKey, orgID)
+			// Store orgID (and the matched key) in context for use by handlers
+			ctx := reqctx.WithOrgID(r.Context(), orgID)
+			if hasKey {
+				ctx = context.WithValue(ctx, KeyContextKey, key)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -91,8 +127,7 @@ func Middleware(store CredentialStore) func(http.Handler) http.Handler {
 
 // GetOrgIDFromContext retrieves the orgID from the request context
 func GetOrgIDFromContext(ctx context.Context) (uuid.UUID, bool) {
-	orgID, ok := ctx.Value(OrgIDContextKey).(uuid.UUID)
-	return orgID, ok
+	return reqctx.OrgID(ctx)
 }
 
 // ExtractBearerToken extracts a bearer token from the Authorization header
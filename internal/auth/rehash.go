@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// EnableRehash turns on rehash-on-use: after a successful validation, keys
+// stored in plaintext or hashed with a bcrypt cost below targetCost are
+// rehashed at targetCost and written back to the auth config file, so the
+// file converges to the configured cost without forcing key rotation.
+func (s *FileStore) EnableRehash(targetCost int) error {
+	if targetCost < bcrypt.MinCost || targetCost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %d: must be between %d and %d", targetCost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	s.rehashMu.Lock()
+	defer s.rehashMu.Unlock()
+
+	s.rehashCost = targetCost
+	if s.rehashPending == nil {
+		s.rehashPending = make(map[string]bool)
+	}
+
+	return nil
+}
+
+// isBcryptHash reports whether the stored key is a bcrypt hash
+func isBcryptHash(storedKey string) bool {
+	return strings.HasPrefix(storedKey, "$2a$") || strings.HasPrefix(storedKey, "$2b$") || strings.HasPrefix(storedKey, "$2y$")
+}
+
+// needsRehash reports whether a stored key is below the configured target cost
+func (s *FileStore) needsRehash(storedKey string) bool {
+	s.rehashMu.Lock()
+	targetCost := s.rehashCost
+	s.rehashMu.Unlock()
+
+	if targetCost == 0 {
+		return false
+	}
+
+	if !isBcryptHash(storedKey) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(storedKey))
+	if err != nil {
+		return false
+	}
+	return cost < targetCost
+}
+
+// scheduleRehash rehashes a validated key in the background so the request
+// that triggered it does not pay the cost of the new bcrypt hash
+func (s *FileStore) scheduleRehash(orgID uuid.UUID, storedKey, apiKey string) {
+	s.rehashMu.Lock()
+	if s.rehashPending[storedKey] {
+		s.rehashMu.Unlock()
+		return
+	}
+	s.rehashPending[storedKey] = true
+	targetCost := s.rehashCost
+	s.rehashMu.Unlock()
+
+	s.rehashWG.Add(1)
+	go func() {
+		defer s.rehashWG.Done()
+		defer func() {
+			s.rehashMu.Lock()
+			delete(s.rehashPending, storedKey)
+			s.rehashMu.Unlock()
+		}()
+
+		if err := s.rehash(orgID, storedKey, apiKey, targetCost); err != nil {
+			slog.Error("Failed to rehash API key", "org_id", orgID, "error", err)
+			return
+		}
+		slog.Info("Rehashed API key", "org_id", orgID, "cost", targetCost)
+	}()
+}
+
+// rehash replaces storedKey with a new hash of apiKey in the file and in memory
+func (s *FileStore) rehash(orgID uuid.UUID, storedKey, apiKey string, targetCost int) error {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(apiKey), targetCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash API key: %w", err)
+	}
+
+	if err := s.replaceKeyInFile(orgID, storedKey, string(newHash)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range s.credentials[orgID] {
+		if key.Key == storedKey {
+			s.credentials[orgID][i].Key = string(newHash)
+			break
+		}
+	}
+
+	return nil
+}
+
+// replaceKeyInFile rewrites the stored key line of the given org in the auth config file
+func (s *FileStore) replaceKeyInFile(orgID uuid.UUID, oldKey, newKey string) error {
+	return s.rewriteFile(func(lines []string) ([]string, error) {
+		inOrg := false
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+				sectionID, err := uuid.Parse(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+				inOrg = err == nil && sectionID == orgID
+				continue
+			}
+			if inOrg && storedKeyField(trimmed) == oldKey {
+				lines[i] = strings.Replace(line, oldKey, newKey, 1)
+				return lines, nil
+			}
+		}
+		return nil, fmt.Errorf("key not found in %s", s.filePath)
+	})
+}
+
+// rewriteFile applies transform to the lines of the auth config file and
+// atomically replaces the file with the result
+func (s *FileStore) rewriteFile(transform func(lines []string) ([]string, error)) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	content, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read auth config file: %w", err)
+	}
+
+	info, err := os.Stat(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat auth config file: %w", err)
+	}
+
+	lines, err := transform(strings.Split(string(content), "\n"))
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.filePath), ".auth.cfg-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary auth config file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.WriteString(strings.Join(lines, "\n")); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temporary auth config file: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync temporary auth config file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary auth config file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set auth config file permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to replace auth config file: %w", err)
+	}
+
+	// The rename replaced the watched inode, so watch the new file
+	if s.watcher != nil {
+		if err := s.watcher.Add(s.filePath); err != nil {
+			slog.Error("Failed to re-watch credentials file after update", "file", s.filePath, "error", err)
+		}
+	}
+
+	return nil
+}
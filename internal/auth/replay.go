@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+const (
+	// TimestampHeader carries the time a request was created, in Unix seconds
+	TimestampHeader = "X-Request-Timestamp"
+
+	// NonceHeader carries a value unique to each request of an organization
+	NonceHeader = "X-Request-Nonce"
+)
+
+// Nonces must be long enough to be unique and short enough to cache cheaply
+const (
+	minNonceLength = 16
+	maxNonceLength = 128
+)
+
+var (
+	ErrMissingNonce   = errors.New("missing " + TimestampHeader + " or " + NonceHeader + " header")
+	ErrInvalidNonce   = errors.New("invalid " + NonceHeader + " header (16-128 characters of A-Z, a-z, 0-9, '-' and '_')")
+	ErrInvalidTime    = errors.New("invalid " + TimestampHeader + " header (Unix seconds)")
+	ErrStaleTimestamp = errors.New("request timestamp outside the accepted window")
+	ErrReplayedNonce  = errors.New("request nonce already used")
+)
+
+// ReplayGuard rejects state-changing requests whose timestamp is outside a
+// window around server time or whose nonce was already used by the same
+// organization within that window. Nonces are only remembered for the
+// window, after which the timestamp check rejects the request anyway.
+type ReplayGuard struct {
+	window time.Duration
+	seen   map[string]time.Time // org ID and nonce -> expiry
+	mu     sync.Mutex
+	stop   chan struct{}
+}
+
+// NewReplayGuard creates a replay guard accepting timestamps up to window
+// away from server time and starts removing expired nonces
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	g := &ReplayGuard{
+		window: window,
+		seen:   make(map[string]time.Time),
+		stop:   make(chan struct{}),
+	}
+	go g.cleanupRoutine()
+	return g
+}
+
+// cleanupRoutine removes expired nonces to bound the cache
+func (g *ReplayGuard) cleanupRoutine() {
+	ticker := time.NewTicker(g.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			g.mu.Lock()
+			for key, expiresAt := range g.seen {
+				if now.After(expiresAt) {
+					delete(g.seen, key)
+				}
+			}
+			g.mu.Unlock()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the cleanup goroutine
+func (g *ReplayGuard) Stop() {
+	close(g.stop)
+}
+
+// Size returns the number of nonces remembered until they expire
+func (g *ReplayGuard) Size() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.seen)
+}
+
+// Check validates the timestamp and records the nonce for the organization
+func (g *ReplayGuard) Check(orgID uuid.UUID, timestamp time.Time, nonce string, now time.Time) error {
+	if !validNonce(nonce) {
+		return ErrInvalidNonce
+	}
+	if timestamp.Before(now.Add(-g.window)) || timestamp.After(now.Add(g.window)) {
+		return ErrStaleTimestamp
+	}
+
+	key := orgID.String() + "/" + nonce
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if expiresAt, exists := g.seen[key]; exists && !now.After(expiresAt) {
+		return ErrReplayedNonce
+	}
+	g.seen[key] = timestamp.Add(g.window)
+	return nil
+}
+
+// validNonce checks the nonce's length and characters
+func validNonce(nonce string) bool {
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return false
+	}
+	for _, c := range nonce {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware requires POST, PUT, PATCH and DELETE requests, and the LOCK and
+// UNLOCK requests of Terraform's http backend, to carry a fresh timestamp and
+// an unused nonce. It must run after Middleware so nonces are
+// tracked per organization and unauthenticated clients cannot fill the cache.
+func (g *ReplayGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, "LOCK", "UNLOCK":
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		orgID, ok := GetOrgIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		rawTimestamp := r.Header.Get(TimestampHeader)
+		nonce := r.Header.Get(NonceHeader)
+		if rawTimestamp == "" || nonce == "" {
+			http.Error(w, ErrMissingNonce.Error(), http.StatusBadRequest)
+			return
+		}
+		seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+		if err != nil {
+			http.Error(w, ErrInvalidTime.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := g.Check(orgID, time.Unix(seconds, 0), nonce, time.Now()); err != nil {
+			status := http.StatusUnauthorized
+			if err == ErrInvalidNonce {
+				status = http.StatusBadRequest
+			}
+			security.Emit(security.Request(r, "auth.replay_rejected", security.SeverityWarning, "Rejected request replay check").
+				WithOrg(orgID.String()).With("error", err))
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
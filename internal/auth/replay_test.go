@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReplayGuardCheck(t *testing.T) {
+	g := NewReplayGuard(time.Minute)
+	defer g.Stop()
+
+	orgID := uuid.New()
+	now := time.Now()
+	nonce := "0123456789abcdef"
+
+	if err := g.Check(orgID, now, nonce, now); err != nil {
+		t.Fatalf("Expected fresh request to pass, got %v", err)
+	}
+	if err := g.Check(orgID, now, nonce, now.Add(time.Second)); err != ErrReplayedNonce {
+		t.Errorf("Expected ErrReplayedNonce, got %v", err)
+	}
+	if err := g.Check(uuid.New(), now, nonce, now); err != nil {
+		t.Errorf("Nonces should be tracked per org, got %v", err)
+	}
+
+	// Once the nonce is forgotten, the timestamp is outside the window
+	if err := g.Check(orgID, now, nonce, now.Add(2*time.Minute)); err != ErrStaleTimestamp {
+		t.Errorf("Expected ErrStaleTimestamp, got %v", err)
+	}
+	if err := g.Check(orgID, now.Add(2*time.Minute), "fedcba9876543210", now); err != ErrStaleTimestamp {
+		t.Errorf("Expected ErrStaleTimestamp for a future timestamp, got %v", err)
+	}
+
+	for _, invalid := range []string{"short", "0123456789abcdef!", string(make([]byte, 129))} {
+		if err := g.Check(orgID, now, invalid, now); err != ErrInvalidNonce {
+			t.Errorf("%q: expected ErrInvalidNonce, got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// Key scopes
+const (
+	ScopeStateRead  = "state:read"
+	ScopeStateWrite = "state:write"
+	ScopeDataRead   = "data:read"
+	ScopeDataWrite  = "data:write"
+
+	// ScopeKeysManage allows issuing sub-keys for the key's own org. It is
+	// never granted by default and cannot be delegated.
+	ScopeKeysManage = "keys:manage"
+
+	// ScopeStateAdmin allows force-unlocking states locked by other clients.
+	// It is never granted by default.
+	ScopeStateAdmin = "state:admin"
+)
+
+// State path scopes restrict state access to state names matching a glob
+// pattern, e.g. "state:rw:team-a-*". A key with such scopes passes the
+// route-level state scope checks; StateHandler checks each state name.
+const (
+	stateReadPrefix      = "state:read:"
+	stateWritePrefix     = "state:write:"
+	stateReadWritePrefix = "state:rw:"
+)
+
+// AllScopes lists every known scope apart from state path scopes
+var AllScopes = []string{ScopeStateRead, ScopeStateWrite, ScopeDataRead, ScopeDataWrite, ScopeKeysManage, ScopeStateAdmin}
+
+// DefaultScopes are granted to keys without a scopes attribute
+var DefaultScopes = []string{ScopeStateRead, ScopeStateWrite, ScopeDataRead, ScopeDataWrite}
+
+const (
+	KeyContextKey contextKey = "apikey"
+)
+
+// ValidateScopes checks that every scope is known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if slices.Contains(AllScopes, scope) {
+			continue
+		}
+		if _, _, pattern, ok := parseStateScope(scope); ok {
+			if err := validateStatePattern(pattern); err != nil {
+				return fmt.Errorf("invalid scope %q: %v", scope, err)
+			}
+			continue
+		}
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	return nil
+}
+
+// parseStateScope splits a state path scope into the access it grants and
+// its state name pattern
+func parseStateScope(scope string) (read, write bool, pattern string, ok bool) {
+	if pattern, ok = strings.CutPrefix(scope, stateReadWritePrefix); ok {
+		return true, true, pattern, true
+	}
+	if pattern, ok = strings.CutPrefix(scope, stateReadPrefix); ok {
+		return true, false, pattern, true
+	}
+	if pattern, ok = strings.CutPrefix(scope, stateWritePrefix); ok {
+		return false, true, pattern, true
+	}
+	return false, false, "", false
+}
+
+// validateStatePattern checks that a state name pattern is a valid glob
+// that can be stored in auth.cfg
+func validateStatePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty state name pattern")
+	}
+	if strings.ContainsAny(pattern, ", \t/") {
+		return fmt.Errorf("state name pattern cannot contain commas, slashes or whitespace")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid state name pattern: %v", err)
+	}
+	return nil
+}
+
+// EffectiveScopes returns the key's scopes, or DefaultScopes if it has none
+func (k StoredKey) EffectiveScopes() []string {
+	if len(k.Scopes) == 0 {
+		return DefaultScopes
+	}
+	return k.Scopes
+}
+
+// HasScope reports whether the key grants the given scope. State path
+// scopes grant ScopeStateRead or ScopeStateWrite for some states, so use
+// CanAccessState to check a state name.
+func (k StoredKey) HasScope(scope string) bool {
+	scopes := k.EffectiveScopes()
+	if slices.Contains(scopes, scope) {
+		return true
+	}
+	if scope != ScopeStateRead && scope != ScopeStateWrite {
+		return false
+	}
+	for _, granted := range scopes {
+		if read, write, _, ok := parseStateScope(granted); ok {
+			if (scope == ScopeStateRead && read) || (scope == ScopeStateWrite && write) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanAccessState reports whether the key may read, or with write set
+// modify, the named state
+func (k StoredKey) CanAccessState(name string, write bool) bool {
+	scopes := k.EffectiveScopes()
+	unrestricted := ScopeStateRead
+	if write {
+		unrestricted = ScopeStateWrite
+	}
+	if slices.Contains(scopes, unrestricted) {
+		return true
+	}
+	for _, granted := range scopes {
+		read, canWrite, pattern, ok := parseStateScope(granted)
+		if !ok || (write && !canWrite) || (!write && !read) {
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CanDelegate reports whether the key may grant the scope to a sub-key.
+// Unrestricted scopes require the same scope; a state path scope requires
+// unrestricted state access or a scope with the same pattern granting at
+// least the same access.
+func (k StoredKey) CanDelegate(scope string) bool {
+	scopes := k.EffectiveScopes()
+	if slices.Contains(scopes, scope) {
+		return true
+	}
+	read, write, pattern, ok := parseStateScope(scope)
+	if !ok {
+		return false
+	}
+	covered := func(needed bool, unrestricted string, patterned func(r, w bool) bool) bool {
+		if !needed || slices.Contains(scopes, unrestricted) {
+			return true
+		}
+		for _, granted := range scopes {
+			if r, w, p, ok := parseStateScope(granted); ok && p == pattern && patterned(r, w) {
+				return true
+			}
+		}
+		return false
+	}
+	return covered(read, ScopeStateRead, func(r, _ bool) bool { return r }) &&
+		covered(write, ScopeStateWrite, func(_, w bool) bool { return w })
+}
+
+// GetKeyFromContext retrieves the authenticated key from the request
+// context. It is only set for stores that implement KeyAuthenticator.
+func GetKeyFromContext(ctx context.Context) (StoredKey, bool) {
+	key, ok := ctx.Value(KeyContextKey).(StoredKey)
+	return key, ok
+}
+
+// RequireScope creates a middleware that rejects requests whose key does
+// not grant the given scope. Keys from stores without scope support are
+// treated as having DefaultScopes. It must run after Middleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := GetKeyFromContext(r.Context())
+			if !ok {
+				key = StoredKey{}
+			}
+
+			if !key.HasScope(scope) {
+				orgID, _ := GetOrgIDFromContext(r.Context())
+				event := security.Request(r, "auth.missing_scope", security.SeverityWarning, "Missing scope").
+					WithOrg(orgID.String()).With("scope", scope)
+				if ok {
+					event = event.WithKey(key.ID())
+				}
+				security.Emit(event)
+				http.Error(w, fmt.Sprintf("API key lacks required scope %s", scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireReadWriteScope requires readScope for GET and HEAD requests and
+// writeScope for all other methods
+func RequireReadWriteScope(readScope, writeScope string) func(http.Handler) http.Handler {
+	read := RequireScope(readScope)
+	write := RequireScope(writeScope)
+	return func(next http.Handler) http.Handler {
+		readHandler := read(next)
+		writeHandler := write(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				readHandler.ServeHTTP(w, r)
+				return
+			}
+			writeHandler.ServeHTTP(w, r)
+		})
+	}
+}
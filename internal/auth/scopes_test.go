@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequireScope(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	tmpFile := filepath.Join(t.TempDir(), "auth.cfg")
+	content := fmt.Sprintf("[%s]\nfull-key\nread-key scopes=state:read\n", orgID)
+	if err := os.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	store, err := LoadFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to load store: %v", err)
+	}
+
+	memoryStore := NewInMemoryStore()
+	memoryOrgID := uuid.New()
+	memoryStore.AddCredentials(memoryOrgID, "memory-key")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := Middleware(NewChainStore(store, memoryStore))(RequireReadWriteScope(ScopeStateRead, ScopeStateWrite)(ok))
+
+	tests := []struct {
+		name   string
+		orgID  uuid.UUID
+		key    string
+		method string
+		want   int
+	}{
+		{name: "default scopes read", orgID: orgID, key: "full-key", method: http.MethodGet, want: http.StatusOK},
+		{name: "default scopes write", orgID: orgID, key: "full-key", method: http.MethodPost, want: http.StatusOK},
+		{name: "read-only key read", orgID: orgID, key: "read-key", method: http.MethodGet, want: http.StatusOK},
+		{name: "read-only key write", orgID: orgID, key: "read-key", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "store without scopes", orgID: memoryOrgID, key: "memory-key", method: http.MethodPost, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/state/prod", nil)
+			req.Header.Set("X-Org-ID", tt.orgID.String())
+			req.Header.Set("X-API-Key", tt.key)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	// keys:manage is never a default scope
+	manage := Middleware(store)(RequireScope(ScopeKeysManage)(ok))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/keys", nil)
+	req.Header.Set("X-Org-ID", orgID.String())
+	req.Header.Set("X-API-Key", "full-key")
+	rec := httptest.NewRecorder()
+	manage.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without keys:manage, got %d", rec.Code)
+	}
+
+	// Neither is state:admin
+	if (StoredKey{}).HasScope(ScopeStateAdmin) || !(StoredKey{Scopes: []string{ScopeStateAdmin}}).HasScope(ScopeStateAdmin) {
+		t.Error("Expected state:admin to be granted only explicitly")
+	}
+	if err := ValidateScopes([]string{ScopeStateAdmin, ScopeStateWrite}); err != nil {
+		t.Errorf("Expected state:admin to be a valid scope, got %v", err)
+	}
+}
+
+func TestStatePathScopes(t *testing.T) {
+	if err := ValidateScopes([]string{"state:rw:team-a-*", "state:read:shared", "data:read"}); err != nil {
+		t.Errorf("Expected state path scopes to be valid, got %v", err)
+	}
+	for _, invalid := range []string{"state:rw:", "state:rw:[", "state:read:a,b", "state:write:a/b", "state:admin:x"} {
+		if err := ValidateScopes([]string{invalid}); err == nil {
+			t.Errorf("%s: expected validation error", invalid)
+		}
+	}
+
+	key := StoredKey{Key: "team-a", Scopes: []string{"state:rw:team-a-*", "state:read:shared-*"}}
+	if !key.HasScope(ScopeStateRead) || !key.HasScope(ScopeStateWrite) || key.HasScope(ScopeDataRead) {
+		t.Errorf("Unexpected route-level scopes for %v", key.Scopes)
+	}
+
+	tests := []struct {
+		state string
+		write bool
+		want  bool
+	}{
+		{"team-a-network", false, true},
+		{"team-a-network", true, true},
+		{"shared-dns", false, true},
+		{"shared-dns", true, false},
+		{"team-b-network", false, false},
+		{"team-b-network", true, false},
+	}
+	for _, tt := range tests {
+		if got := key.CanAccessState(tt.state, tt.write); got != tt.want {
+			t.Errorf("CanAccessState(%s, write=%v) = %v, want %v", tt.state, tt.write, got, tt.want)
+		}
+	}
+	if !(StoredKey{}).CanAccessState("anything", true) {
+		t.Error("Expected keys with default scopes to access every state")
+	}
+
+	// Delegation never widens access
+	delegation := []struct {
+		parent []string
+		scope  string
+		want   bool
+	}{
+		{[]string{"state:rw:team-a-*"}, "state:read:team-a-*", true},
+		{[]string{"state:rw:team-a-*"}, "state:rw:team-a-*", true},
+		{[]string{"state:read:team-a-*"}, "state:rw:team-a-*", false},
+		{[]string{"state:rw:team-a-*"}, "state:read:*", false},
+		{[]string{"state:rw:team-a-*"}, ScopeStateRead, false},
+		{[]string{ScopeStateRead, ScopeStateWrite}, "state:rw:team-b-*", true},
+		{[]string{ScopeStateRead}, "state:rw:team-b-*", false},
+	}
+	for _, tt := range delegation {
+		parent := StoredKey{Key: "parent", Scopes: tt.parent}
+		if got := parent.CanDelegate(tt.scope); got != tt.want {
+			t.Errorf("%v delegating %s: got %v, want %v", tt.parent, tt.scope, got, tt.want)
+		}
+	}
+}
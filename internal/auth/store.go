@@ -3,13 +3,19 @@ package auth
 import (
 	"bufio"
 	"crypto/subtle"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/security"
 	"github.com/fsnotify/fsnotify"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -61,6 +67,52 @@ func (s *InMemoryStore) RemoveCredentials(orgID uuid.UUID) {
 	delete(s.credentials, orgID)
 }
 
+// ChainStore validates credentials against several stores in order
+type ChainStore struct {
+	stores []CredentialStore
+}
+
+// NewChainStore creates a credential store that accepts credentials valid in any of the given stores
+func NewChainStore(stores ...CredentialStore) *ChainStore {
+	return &ChainStore{
+		stores: stores,
+	}
+}
+
+// AuthenticateKey returns the key from the first chained store that accepts
+// the credentials; stores without key support yield a key with default scopes
+func (s *ChainStore) AuthenticateKey(orgID uuid.UUID, apiKey string) (StoredKey, bool, error) {
+	for _, store := range s.stores {
+		if authenticator, ok := store.(KeyAuthenticator); ok {
+			key, valid, err := authenticator.AuthenticateKey(orgID, apiKey)
+			if err != nil || valid {
+				return key, valid, err
+			}
+			continue
+		}
+
+		valid, err := store.ValidateCredentials(orgID, apiKey)
+		if err != nil || valid {
+			return StoredKey{}, valid, err
+		}
+	}
+	return StoredKey{}, false, nil
+}
+
+// ValidateCredentials returns true as soon as one of the chained stores accepts the credentials
+func (s *ChainStore) ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error) {
+	for _, store := range s.stores {
+		valid, err := store.ValidateCredentials(orgID, apiKey)
+		if err != nil {
+			return false, err
+		}
+		if valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // FileStore provides a file-based implementation of CredentialStore
 // It reads credentials from a configuration file with the following format:
 //
@@ -69,24 +121,80 @@ func (s *InMemoryStore) RemoveCredentials(orgID uuid.UUID) {
 // $2a$12$hashedAPIKey2...
 //
 // [22222222-3333-4444-5555-666666666666]
-// $2a$12$hashedAPIKey3...
+// $2a$12$hashedAPIKey3... deprecated_after=2025-06-01T00:00:00Z
 //
-// API keys are stored as bcrypt hashes for security.
+// API keys are stored as bcrypt hashes for security. Keys being rotated out
+// carry a deprecated_after attribute and are rejected once it has passed.
 // The file is monitored for changes and automatically reloaded.
 type FileStore struct {
 	mu          sync.RWMutex
-	credentials map[uuid.UUID][]string // orgID -> list of hashed API keys
+	credentials map[uuid.UUID][]StoredKey // orgID -> list of hashed API keys
 	filePath    string
-	watcher     *fsnotify.Watcher
 	stopChan    chan struct{}
+	closeOnce   sync.Once
+
+	// File watcher supervision (see superviseWatcher)
+	watcherMu       sync.Mutex
+	watcher         *fsnotify.Watcher
+	watcherDone     chan struct{}
+	watcherStatus   WatcherStatus
+	onWatcherChange func(status WatcherStatus)
+
+	// Rehash-on-use settings (see EnableRehash)
+	rehashMu      sync.Mutex
+	rehashCost    int
+	rehashPending map[string]bool
+	rehashWG      sync.WaitGroup
+
+	// Successful verifications, cleared on reload (see verifyCache)
+	verified verifyCache
+
+	// fileMu serializes write-back to the auth config file
+	fileMu sync.Mutex
+
+	// onReloadError is called when an automatic reload fails
+	onReloadError func(err error)
+
+	// keyCost is the bcrypt cost of keys generated by RotateKeys
+	keyCost int
+
+	// Automatic reloads by the file watcher, for runtime stats
+	reloads        atomic.Int64
+	reloadFailures atomic.Int64
+
+	// Automatic reloads failed since the last successful one
+	consecutiveReloadFailures atomic.Int64
+
+	// Optional fault injection for resilience tests
+	faults *faults.Injector
+
+	// Optional observer of bcrypt comparison times, e.g. for metrics
+	observeCompare func(duration time.Duration)
+}
+
+// Backoff between attempts to restart a failed file watcher; it doubles
+// after each failed attempt up to the maximum
+var (
+	watcherRestartMinBackoff = time.Second
+	watcherRestartMaxBackoff = time.Minute
+)
+
+// WatcherStatus reports the health of the auth config file watcher. While
+// it is unhealthy, changes to the file are not picked up.
+type WatcherStatus struct {
+	Healthy   bool      `json:"healthy"`
+	Restarts  int64     `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"` // When Healthy last changed
 }
 
 // NewFileStore creates a new file-based credential store with automatic file watching
 func NewFileStore(filePath string) (*FileStore, error) {
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    filePath,
 		stopChan:    make(chan struct{}),
+		watcherDone: make(chan struct{}),
 	}
 
 	// Load initial credentials
@@ -94,77 +202,315 @@ func NewFileStore(filePath string) (*FileStore, error) {
 		return nil, fmt.Errorf("failed to load credentials from file: %w", err)
 	}
 
-	// Set up file watcher
+	watcher, err := store.startWatcher()
+	if err != nil {
+		return nil, err
+	}
+	store.watcher = watcher
+	store.watcherStatus = WatcherStatus{Healthy: true, Since: time.Now().UTC()}
+
+	// Watch for file changes in background, restarting the watcher if it fails
+	go store.superviseWatcher(watcher)
+
+	slog.Info("File watcher started, credentials will auto-reload on changes", "file", filePath)
+
+	return store, nil
+}
+
+// watchedPaths returns the auth config file path and, if it is a symlink,
+// the path of its target
+func (s *FileStore) watchedPaths() []string {
+	paths := []string{filepath.Clean(s.filePath)}
+	if target, err := filepath.EvalSymlinks(s.filePath); err == nil && target != paths[0] {
+		paths = append(paths, target)
+	}
+	return paths
+}
+
+// startWatcher creates a watcher for the directories of the auth config
+// file. Watching the directories rather than the file keeps changes visible
+// when the file is replaced through a rename, as the key write-back does.
+func (s *FileStore) startWatcher() (*fsnotify.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file watcher: %w", err)
 	}
-	store.watcher = watcher
+	for _, path := range s.watchedPaths() {
+		if _, err := os.Stat(path); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch auth config file: %w", err)
+		}
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch auth config file: %w", err)
+		}
+	}
+	return watcher, nil
+}
 
-	// Add file to watcher
-	if err := watcher.Add(filePath); err != nil {
-		watcher.Close()
-		return nil, fmt.Errorf("failed to watch auth config file: %w", err)
+// CreateAuthConfig creates an empty auth config file readable only by the
+// owner, so a server can start without organizations and have them
+// provisioned through the admin API. It reports whether the file was
+// created; an existing file is left unchanged.
+func CreateAuthConfig(filePath string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return false, fmt.Errorf("failed to create auth config directory: %w", err)
 	}
 
-	// Start watching for file changes in background
-	go store.watchFile()
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create auth config file: %w", err)
+	}
+	defer file.Close()
 
-	log.Printf("File watcher started for %s - credentials will auto-reload on changes", filePath)
+	if _, err := file.WriteString("# Organizations and bcrypt-hashed API keys, see README\n"); err != nil {
+		return false, fmt.Errorf("failed to write auth config file: %w", err)
+	}
+	return true, nil
+}
+
+// LoadFileStore creates a file-based credential store without watching the
+// file, for tools that edit the auth config file (e.g. key rotation)
+func LoadFileStore(filePath string) (*FileStore, error) {
+	store := &FileStore{
+		credentials: make(map[uuid.UUID][]StoredKey),
+		filePath:    filePath,
+		stopChan:    make(chan struct{}),
+	}
+
+	if err := store.LoadFromFile(); err != nil {
+		return nil, fmt.Errorf("failed to load credentials from file: %w", err)
+	}
 
 	return store, nil
 }
 
-// watchFile monitors the auth config file for changes and reloads credentials
-func (s *FileStore) watchFile() {
+// SetReloadErrorHandler registers a function called when an automatic reload
+// of the auth config file fails, e.g. to alert operators
+func (s *FileStore) SetReloadErrorHandler(handler func(err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReloadError = handler
+}
+
+// SetWatcherHandler registers a function called when the file watcher fails
+// and when it has been restarted, e.g. to alert operators
+func (s *FileStore) SetWatcherHandler(handler func(status WatcherStatus)) {
+	s.watcherMu.Lock()
+	defer s.watcherMu.Unlock()
+	s.onWatcherChange = handler
+}
+
+// WatcherStatus returns the health of the file watcher. It reports false
+// for stores that do not watch the file (see LoadFileStore).
+func (s *FileStore) WatcherStatus() (WatcherStatus, bool) {
+	s.watcherMu.Lock()
+	defer s.watcherMu.Unlock()
+	return s.watcherStatus, s.watcherDone != nil
+}
+
+// setWatcherStatus records a watcher failure (err != nil) or restart and
+// calls the watcher handler when the health changed
+func (s *FileStore) setWatcherStatus(watcher *fsnotify.Watcher, err error) {
+	s.watcherMu.Lock()
+	s.watcher = watcher
+	status := s.watcherStatus
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.Restarts++
+	}
+	changed := status.Healthy != (err == nil)
+	if changed {
+		status.Healthy = err == nil
+		status.Since = time.Now().UTC()
+	}
+	s.watcherStatus = status
+	handler := s.onWatcherChange
+	s.watcherMu.Unlock()
+
+	if changed && handler != nil {
+		handler(status)
+	}
+}
+
+// superviseWatcher runs the file watcher until the store is closed. When
+// the watcher fails, e.g. because the file was replaced, it is restarted
+// with backoff and the credentials are reloaded, since changes made while
+// it was down were missed.
+func (s *FileStore) superviseWatcher(watcher *fsnotify.Watcher) {
+	defer close(s.watcherDone)
+
+	backoff := watcherRestartMinBackoff
+	for {
+		started := time.Now()
+		err := s.watchFile(watcher)
+		if err == nil {
+			return
+		}
+		watcher.Close()
+		slog.Error("File watcher failed, credentials will not auto-reload until it restarts", "file", s.filePath, "error", err)
+		s.setWatcherStatus(nil, err)
+
+		// A watcher that ran for a while failed for a new reason
+		if time.Since(started) > watcherRestartMaxBackoff {
+			backoff = watcherRestartMinBackoff
+		}
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-s.stopChan:
+				return
+			}
+			backoff = min(backoff*2, watcherRestartMaxBackoff)
+
+			if watcher, err = s.startWatcher(); err == nil {
+				break
+			}
+			slog.Error("Failed to restart file watcher", "file", s.filePath, "error", err)
+			s.setWatcherStatus(nil, err)
+		}
+
+		slog.Info("File watcher restarted", "file", s.filePath)
+		s.autoReload()
+		s.setWatcherStatus(watcher, nil)
+	}
+}
+
+// watchFile monitors the auth config file for changes and reloads
+// credentials. It returns nil when the store is closed and an error when
+// the watcher can no longer deliver changes.
+func (s *FileStore) watchFile(watcher *fsnotify.Watcher) error {
 	// Debounce timer to avoid reloading multiple times for rapid changes
 	var debounceTimer *time.Timer
 	debounceDuration := 500 * time.Millisecond
+	defer func() {
+		if debounceTimer != nil {
+			debounceTimer.Stop()
+		}
+	}()
+	scheduleReload := func() {
+		// Reset debounce timer
+		if debounceTimer != nil {
+			debounceTimer.Stop()
+		}
+		debounceTimer = time.AfterFunc(debounceDuration, s.autoReload)
+	}
+
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range s.watchedPaths() {
+		files[path] = true
+		dirs[filepath.Dir(path)] = true
+	}
 
 	for {
 		select {
-		case event, ok := <-s.watcher.Events:
+		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return errors.New("watcher event channel closed")
 			}
+			name := filepath.Clean(event.Name)
 
-			// Only reload on write or create events
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-				// Reset debounce timer
-				if debounceTimer != nil {
-					debounceTimer.Stop()
-				}
+			// Removing a watched directory removes its watch
+			if dirs[name] && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				return fmt.Errorf("watched directory %s was removed or renamed", name)
+			}
 
-				debounceTimer = time.AfterFunc(debounceDuration, func() {
-					log.Printf("Detected change in %s, reloading credentials...", s.filePath)
-					if err := s.Reload(); err != nil {
-						log.Printf("ERROR: Failed to reload credentials: %v", err)
-					} else {
-						log.Println("Credentials reloaded successfully")
-					}
-				})
+			// Only reload on write or create events of the file
+			if files[name] && (event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create) {
+				scheduleReload()
 			}
 
-		case err, ok := <-s.watcher.Errors:
+		case err, ok := <-watcher.Errors:
 			if !ok {
-				return
+				return errors.New("watcher error channel closed")
+			}
+			// Events were dropped because they were not read fast enough;
+			// reload in case a change was among them
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				slog.Warn("File watcher dropped events, reloading credentials", "file", s.filePath)
+				scheduleReload()
+				continue
 			}
-			log.Printf("File watcher error: %v", err)
+			return err
 
 		case <-s.stopChan:
-			if debounceTimer != nil {
-				debounceTimer.Stop()
-			}
-			return
+			return nil
 		}
 	}
 }
 
-// Close stops the file watcher and cleans up resources
+// autoReload reloads the credentials after a change detected by the file
+// watcher, reporting failures to the reload error handler
+func (s *FileStore) autoReload() {
+	slog.Info("Detected change, reloading credentials", "file", s.filePath)
+	s.reloads.Add(1)
+	if err := s.Reload(); err != nil {
+		s.reloadFailures.Add(1)
+		s.consecutiveReloadFailures.Add(1)
+		slog.Error("Failed to reload credentials", "file", s.filePath, "error", err)
+		s.mu.RLock()
+		onReloadError := s.onReloadError
+		s.mu.RUnlock()
+		if onReloadError != nil {
+			onReloadError(err)
+		}
+	} else {
+		s.consecutiveReloadFailures.Store(0)
+		slog.Info("Credentials reloaded successfully", "file", s.filePath)
+	}
+}
+
+// ConsecutiveReloadFailures returns the number of automatic reloads that
+// failed since the last successful one. A failed reload may leave the
+// store with only part of the credentials.
+func (s *FileStore) ConsecutiveReloadFailures() int64 {
+	return s.consecutiveReloadFailures.Load()
+}
+
+// SetFaultInjector injects faults into credential validation (the auth
+// layer) for resilience tests. It must be called before the store is used.
+func (s *FileStore) SetFaultInjector(injector *faults.Injector) {
+	s.faults = injector
+}
+
+// SetCompareObserver registers a function called with the duration of every
+// bcrypt comparison of an API key. It must be called before the store is used.
+func (s *FileStore) SetCompareObserver(observe func(duration time.Duration)) {
+	s.observeCompare = observe
+}
+
+// Stats returns the number of organizations and of automatic reloads and
+// failed reloads since the store was created
+func (s *FileStore) Stats() (orgs int, reloads, reloadFailures int64) {
+	s.mu.RLock()
+	orgs = len(s.credentials)
+	s.mu.RUnlock()
+	return orgs, s.reloads.Load(), s.reloadFailures.Load()
+}
+
+// Close stops the file watcher and cleans up resources; later calls do
+// nothing
 func (s *FileStore) Close() error {
-	close(s.stopChan)
-	if s.watcher != nil {
-		return s.watcher.Close()
+	// Let in-flight rehashes finish writing back to the file
+	s.rehashWG.Wait()
+
+	s.closeOnce.Do(func() { close(s.stopChan) })
+	if s.watcherDone == nil {
+		return nil
+	}
+	<-s.watcherDone
+
+	s.watcherMu.Lock()
+	watcher := s.watcher
+	s.watcher = nil
+	s.watcherMu.Unlock()
+	if watcher != nil {
+		return watcher.Close()
 	}
 	return nil
 }
@@ -174,8 +520,9 @@ func (s *FileStore) LoadFromFile() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Clear existing credentials
-	s.credentials = make(map[uuid.UUID][]string)
+	// Clear existing credentials and the verifications made against them
+	s.credentials = make(map[uuid.UUID][]StoredKey)
+	s.verified.clear()
 
 	file, err := os.Open(s.filePath)
 	if err != nil {
@@ -208,17 +555,18 @@ func (s *FileStore) LoadFromFile() error {
 			hasCurrentOrg = true
 			// Initialize the key list for this org if it doesn't exist
 			if _, exists := s.credentials[currentOrgID]; !exists {
-				s.credentials[currentOrgID] = []string{}
+				s.credentials[currentOrgID] = []StoredKey{}
 			}
 			continue
 		}
 
 		// If we have a current org, this line is an API key
 		if hasCurrentOrg {
-			apiKey := line
-			if apiKey != "" {
-				s.credentials[currentOrgID] = append(s.credentials[currentOrgID], apiKey)
+			key, err := ParseKeyLine(line)
+			if err != nil {
+				return fmt.Errorf("invalid API key on line %d: %w", lineNum, err)
 			}
+			s.credentials[currentOrgID] = append(s.credentials[currentOrgID], key)
 		} else {
 			return fmt.Errorf("API key on line %d appears before any org ID declaration", lineNum)
 		}
@@ -232,41 +580,209 @@ func (s *FileStore) LoadFromFile() error {
 }
 
 // ValidateCredentials checks if the provided credentials are valid
-// Uses bcrypt comparison for hashed keys (which includes constant-time comparison internally)
 func (s *FileStore) ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error) {
+	_, valid, err := s.AuthenticateKey(orgID, apiKey)
+	return valid, err
+}
+
+// AuthenticateKey validates the credentials and returns the matching key
+// Uses bcrypt comparison for hashed keys (which includes constant-time comparison internally)
+func (s *FileStore) AuthenticateKey(orgID uuid.UUID, apiKey string) (StoredKey, bool, error) {
+	if err := s.faults.Inject(faults.LayerAuth, orgID); err != nil {
+		return StoredKey{}, false, err
+	}
+
 	s.mu.RLock()
-	hashedKeys := s.credentials[orgID]
+	storedKeys := s.credentials[orgID]
 	s.mu.RUnlock()
 
-	if len(hashedKeys) == 0 {
-		return false, nil
+	if len(storedKeys) == 0 {
+		return StoredKey{}, false, nil
+	}
+
+	now := time.Now()
+	cacheKey := verifyCacheKey(orgID, apiKey)
+	if storedKey, ok := s.cachedKey(cacheKey, storedKeys, now); ok {
+		logDeprecatedUse(orgID, storedKey)
+		return storedKey, true, nil
 	}
 
+	var expired []StoredKey
+
+	// A prefixed key is only compared with the stored key of its ID, so a
+	// request costs at most one bcrypt comparison however many keys the org
+	// has. Legacy keys without an ID are compared with every legacy key. So
+	// are prefixed keys whose ID is not stored, as a prefixed key hashed
+	// elsewhere may have been stored without it; its ID is recorded when it
+	// matches.
+	keyID, _ := ParseAPIKeyID(apiKey)
+	adoptID := keyID != "" && !slices.ContainsFunc(storedKeys, func(key StoredKey) bool { return key.KeyID == keyID })
+
 	// Check if the provided API key matches any of the hashed keys for this org
-	for _, hashedKey := range hashedKeys {
+	for _, storedKey := range storedKeys {
+		if storedKey.KeyID != keyID && !(adoptID && storedKey.KeyID == "") {
+			continue
+		}
+		// Keys past their rotation overlap window or expiry are revoked
+		if storedKey.Expired(now) {
+			expired = append(expired, storedKey)
+			continue
+		}
+		hashedKey := storedKey.Key
+
 		// Check if this is a bcrypt hash (starts with $2a$, $2b$, or $2y$)
-		if strings.HasPrefix(hashedKey, "$2a$") || strings.HasPrefix(hashedKey, "$2b$") || strings.HasPrefix(hashedKey, "$2y$") {
+		if isBcryptHash(hashedKey) {
 			// Use bcrypt comparison for hashed keys
-			err := bcrypt.CompareHashAndPassword([]byte(hashedKey), []byte(apiKey))
+			err := s.compareHash(hashedKey, apiKey)
 			if err == nil {
-				return true, nil
+				if adoptID {
+					storedKey = s.recordKeyID(orgID, storedKey, keyID)
+				}
+				s.verified.store(cacheKey, hashedKey)
+				logDeprecatedUse(orgID, storedKey)
+				if s.needsRehash(hashedKey) {
+					s.scheduleRehash(orgID, hashedKey, apiKey)
+				}
+				return storedKey, true, nil
 			}
 			// If error is not "mismatch", return the error
 			if err != bcrypt.ErrMismatchedHashAndPassword {
-				return false, fmt.Errorf("bcrypt comparison failed: %w", err)
+				return StoredKey{}, false, fmt.Errorf("bcrypt comparison failed: %w", err)
 			}
 		} else {
 			// Fallback to constant-time comparison for plain-text keys (backward compatibility)
 			if subtle.ConstantTimeCompare([]byte(hashedKey), []byte(apiKey)) == 1 {
-				return true, nil
+				if adoptID {
+					storedKey = s.recordKeyID(orgID, storedKey, keyID)
+				}
+				s.verified.store(cacheKey, hashedKey)
+				logDeprecatedUse(orgID, storedKey)
+				if s.needsRehash(hashedKey) {
+					s.scheduleRehash(orgID, hashedKey, apiKey)
+				}
+				return storedKey, true, nil
 			}
 		}
 	}
 
-	return false, nil
+	// Expired keys are only compared once no active key matched, so they
+	// cost nothing for valid requests but are logged with their own reason
+	for _, storedKey := range expired {
+		if s.keyMatches(storedKey.Key, apiKey) {
+			logExpiredUse(orgID, storedKey)
+			break
+		}
+	}
+
+	return StoredKey{}, false, nil
+}
+
+// recordKeyID records the ID of a prefixed key that was stored without it,
+// in memory and, in the background, in the auth config file, so the key's
+// later requests are only compared with its own hash
+func (s *FileStore) recordKeyID(orgID uuid.UUID, key StoredKey, keyID string) StoredKey {
+	recorded := false
+	s.mu.Lock()
+	for i, stored := range s.credentials[orgID] {
+		if stored.Key == key.Key && stored.KeyID == "" {
+			s.credentials[orgID][i].KeyID = keyID
+			recorded = true
+			break
+		}
+	}
+	s.mu.Unlock()
+	key.KeyID = keyID
+	if !recorded {
+		// Recorded by a concurrent request
+		return key
+	}
+
+	s.rehashWG.Add(1)
+	go func() {
+		defer s.rehashWG.Done()
+		if err := s.addKeyIDInFile(orgID, key.Key, keyID); err != nil {
+			slog.Error("Failed to record API key ID", "org_id", orgID, "key_id", keyID, "error", err)
+			return
+		}
+		slog.Info("Recorded ID of API key stored without it", "org_id", orgID, "key_id", keyID)
+	}()
+	return key
+}
+
+// addKeyIDInFile adds the id attribute to the line of a stored key of the
+// given org in the auth config file
+func (s *FileStore) addKeyIDInFile(orgID uuid.UUID, storedKey, keyID string) error {
+	return s.rewriteFile(func(lines []string) ([]string, error) {
+		inOrg := false
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+				sectionID, err := uuid.Parse(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+				inOrg = err == nil && sectionID == orgID
+				continue
+			}
+			if !inOrg {
+				continue
+			}
+			key, err := ParseKeyLine(trimmed)
+			if err != nil || key.Key != storedKey || key.KeyID != "" || strings.ContainsAny(key.Key, " \t") {
+				continue
+			}
+			key.KeyID = keyID
+			lines[i] = key.String()
+			return lines, nil
+		}
+		return nil, fmt.Errorf("key not found in %s", s.filePath)
+	})
+}
+
+// keyMatches reports whether apiKey matches a stored bcrypt hash or legacy
+// plaintext key
+func (s *FileStore) keyMatches(storedKey, apiKey string) bool {
+	if isBcryptHash(storedKey) {
+		return s.compareHash(storedKey, apiKey) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(storedKey), []byte(apiKey)) == 1
+}
+
+// compareHash compares apiKey with a bcrypt hash, reporting the duration to
+// the compare observer
+func (s *FileStore) compareHash(hash, apiKey string) error {
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(apiKey))
+	if s.observeCompare != nil {
+		s.observeCompare(time.Since(start))
+	}
+	return err
 }
 
 // Reload reloads credentials from the file
 func (s *FileStore) Reload() error {
 	return s.LoadFromFile()
 }
+
+// logExpiredUse logs use of a key past its expiry or rotation overlap
+// window, so rejected clients can be told apart from wrong keys
+func logExpiredUse(orgID uuid.UUID, key StoredKey) {
+	event := security.Event{Name: "auth.key_expired", Severity: security.SeverityWarning, Message: "Expired API key used"}.
+		WithOrg(orgID.String()).WithKey(key.ID())
+	if key.Deprecated() {
+		event = event.With("deprecated_after", key.DeprecatedAfter.Format(time.RFC3339))
+	}
+	if !key.ExpiresAt.IsZero() {
+		event = event.With("expires", key.ExpiresAt.Format(time.RFC3339))
+	}
+	if key.Label != "" {
+		event = event.With("label", key.Label)
+	}
+	security.Emit(event)
+}
+
+// logDeprecatedUse logs use of a key in its rotation overlap window so
+// operators can verify clients have switched before the key is revoked
+func logDeprecatedUse(orgID uuid.UUID, key StoredKey) {
+	if key.Deprecated() {
+		security.Emit(security.Event{Name: "key.deprecated_use", Severity: security.SeverityWarning, Message: "Deprecated API key used"}.
+			WithOrg(orgID.String()).WithKey(key.ID()).With("deprecated_after", key.DeprecatedAfter.Format(time.RFC3339)))
+	}
+}
@@ -175,7 +175,7 @@ $2a$12$hash1`,
 			}
 
 			store := &FileStore{
-				credentials: make(map[uuid.UUID][]string),
+				credentials: make(map[uuid.UUID][]StoredKey),
 				filePath:    tmpFile,
 			}
 
@@ -204,7 +204,7 @@ $2a$12$hash1`,
 
 func TestFileStoreLoadFromFileNotFound(t *testing.T) {
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    "/nonexistent/file.cfg",
 	}
 
@@ -235,7 +235,7 @@ func TestFileStoreValidateCredentialsBcrypt(t *testing.T) {
 
 	// Create store
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    tmpFile,
 	}
 
@@ -291,7 +291,7 @@ func TestFileStoreValidateCredentialsMultipleKeys(t *testing.T) {
 	}
 
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    tmpFile,
 	}
 
@@ -334,7 +334,7 @@ func TestFileStoreValidateCredentialsPlaintext(t *testing.T) {
 	}
 
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    tmpFile,
 	}
 
@@ -407,7 +407,7 @@ func TestFileStoreReload(t *testing.T) {
 	}
 
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    tmpFile,
 	}
 
@@ -555,6 +555,106 @@ func TestFileStoreWatchFileDebounce(t *testing.T) {
 	}
 }
 
+func TestFileStoreWatchFileReplacedByRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "auth.cfg")
+
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	if err := os.WriteFile(tmpFile, []byte(fmt.Sprintf("[%s]\nkey1\n", orgID)), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Replace the file twice the way the key write-back does; a watch on
+	// the file itself would be lost after the first replacement
+	for _, key := range []string{"key2", "key3"} {
+		tmpPath := filepath.Join(tmpDir, ".auth.cfg-tmp")
+		if err := os.WriteFile(tmpPath, []byte(fmt.Sprintf("[%s]\n%s\n", orgID, key)), 0644); err != nil {
+			t.Fatalf("Failed to write replacement file: %v", err)
+		}
+		if err := os.Rename(tmpPath, tmpFile); err != nil {
+			t.Fatalf("Failed to replace file: %v", err)
+		}
+		time.Sleep(1 * time.Second)
+
+		if valid, _ := store.ValidateCredentials(orgID, key); !valid {
+			t.Errorf("%s should be valid after the file was replaced", key)
+		}
+	}
+
+	if status, ok := store.WatcherStatus(); !ok || !status.Healthy || status.Restarts != 0 {
+		t.Errorf("Expected a healthy watcher without restarts, got %+v", status)
+	}
+}
+
+func TestFileStoreWatcherRestart(t *testing.T) {
+	defer func(min time.Duration) { watcherRestartMinBackoff = min }(watcherRestartMinBackoff)
+	watcherRestartMinBackoff = 50 * time.Millisecond
+
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	movedDir := filepath.Join(tmpDir, "moved")
+	if err := os.Mkdir(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	tmpFile := filepath.Join(configDir, "auth.cfg")
+
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	if err := os.WriteFile(tmpFile, []byte(fmt.Sprintf("[%s]\nkey1\n", orgID)), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	changes := make(chan WatcherStatus, 10)
+	store.SetWatcherHandler(func(status WatcherStatus) { changes <- status })
+
+	waitForChange := func() WatcherStatus {
+		t.Helper()
+		select {
+		case status := <-changes:
+			return status
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a watcher status change")
+			return WatcherStatus{}
+		}
+	}
+
+	// Moving the watched directory away kills the watcher
+	if err := os.Rename(configDir, movedDir); err != nil {
+		t.Fatalf("Failed to move config directory: %v", err)
+	}
+	if status := waitForChange(); status.Healthy || status.LastError == "" {
+		t.Fatalf("Expected an unhealthy watcher with an error, got %+v", status)
+	}
+
+	// Change the file while the watcher is down and move it back
+	movedFile := filepath.Join(movedDir, "auth.cfg")
+	if err := os.WriteFile(movedFile, []byte(fmt.Sprintf("[%s]\nkey2\n", orgID)), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Rename(movedDir, configDir); err != nil {
+		t.Fatalf("Failed to restore config directory: %v", err)
+	}
+	if status := waitForChange(); !status.Healthy || status.Restarts != 1 {
+		t.Fatalf("Expected a restarted watcher, got %+v", status)
+	}
+
+	// The change missed while the watcher was down is picked up
+	if valid, _ := store.ValidateCredentials(orgID, "key2"); !valid {
+		t.Error("Key changed while the watcher was down should be valid after the restart")
+	}
+}
+
 func TestFileStoreConcurrentValidation(t *testing.T) {
 	tmpDir := t.TempDir()
 	tmpFile := filepath.Join(tmpDir, "auth.cfg")
@@ -600,6 +700,140 @@ func TestFileStoreConcurrentValidation(t *testing.T) {
 	}
 }
 
+// waitForFileContent polls the file until check passes or the deadline expires
+func waitForFileContent(t *testing.T, path string, check func(content string) bool) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, err := os.ReadFile(path)
+		if err == nil && check(string(content)) {
+			return string(content)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for expected content in %s, last content:\n%s", path, content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestFileStoreRehashPlaintextOnUse(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "auth.cfg")
+
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	content := fmt.Sprintf("# comment is preserved\n[%s]\nplain-key\n", orgID)
+	if err := os.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.EnableRehash(bcrypt.MinCost); err != nil {
+		t.Fatalf("EnableRehash failed: %v", err)
+	}
+
+	valid, err := store.ValidateCredentials(orgID, "plain-key")
+	if err != nil || !valid {
+		t.Fatalf("Expected plaintext key to validate, got valid=%v err=%v", valid, err)
+	}
+
+	updated := waitForFileContent(t, tmpFile, func(content string) bool {
+		return !strings.Contains(content, "plain-key")
+	})
+
+	if !strings.Contains(updated, "# comment is preserved") {
+		t.Error("Expected comments to be preserved on write-back")
+	}
+	if !strings.Contains(updated, "$2a$04$") {
+		t.Errorf("Expected key rehashed at cost 4, got:\n%s", updated)
+	}
+
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to stat auth file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file permissions to be preserved, got %v", info.Mode().Perm())
+	}
+
+	// The key must keep working with the new hash
+	valid, err = store.ValidateCredentials(orgID, "plain-key")
+	if err != nil || !valid {
+		t.Errorf("Expected key to validate after rehash, got valid=%v err=%v", valid, err)
+	}
+}
+
+func TestFileStoreRehashLowerCost(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "auth.cfg")
+
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	otherOrgID := uuid.MustParse("22222222-3333-4444-5555-666666666666")
+	lowCostHash, _ := bcrypt.GenerateFromPassword([]byte("upgrade-me"), bcrypt.MinCost)
+	otherHash, _ := bcrypt.GenerateFromPassword([]byte("other-key"), bcrypt.MinCost)
+	content := fmt.Sprintf("[%s]\n%s\n\n[%s]\n%s\n", orgID, lowCostHash, otherOrgID, otherHash)
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.EnableRehash(bcrypt.MinCost + 1); err != nil {
+		t.Fatalf("EnableRehash failed: %v", err)
+	}
+
+	if valid, _ := store.ValidateCredentials(orgID, "upgrade-me"); !valid {
+		t.Fatal("Expected low-cost key to validate")
+	}
+
+	updated := waitForFileContent(t, tmpFile, func(content string) bool {
+		return !strings.Contains(content, string(lowCostHash))
+	})
+
+	if !strings.Contains(updated, "$2a$05$") {
+		t.Errorf("Expected key rehashed at cost 5, got:\n%s", updated)
+	}
+	if !strings.Contains(updated, string(otherHash)) {
+		t.Error("Keys that were not used must not be rehashed")
+	}
+}
+
+func TestFileStoreRehashDisabledByDefault(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpFile := filepath.Join(tmpDir, "auth.cfg")
+
+	content := "[11111111-2222-3333-4444-555555555555]\nplain-key\n"
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	store.ValidateCredentials(uuid.MustParse("11111111-2222-3333-4444-555555555555"), "plain-key")
+	store.Close()
+
+	data, _ := os.ReadFile(tmpFile)
+	if string(data) != content {
+		t.Errorf("Auth file must not change without EnableRehash, got:\n%s", data)
+	}
+
+	if err := store.EnableRehash(99); err == nil {
+		t.Error("Expected error for out-of-range bcrypt cost")
+	}
+}
+
 func BenchmarkFileStoreValidateCredentialsBcrypt(b *testing.B) {
 	tmpDir := b.TempDir()
 	tmpFile := filepath.Join(tmpDir, "auth.cfg")
@@ -657,7 +891,7 @@ func BenchmarkFileStoreLoadFromFile(b *testing.B) {
 	os.WriteFile(tmpFile, []byte(content.String()), 0644)
 
 	store := &FileStore{
-		credentials: make(map[uuid.UUID][]string),
+		credentials: make(map[uuid.UUID][]StoredKey),
 		filePath:    tmpFile,
 	}
 
@@ -666,3 +900,63 @@ func BenchmarkFileStoreLoadFromFile(b *testing.B) {
 		store.LoadFromFile()
 	}
 }
+
+func TestFileStoreVerifyCache(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "auth.cfg")
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	apiKey := "my-secret-key"
+
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(apiKey), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash API key: %v", err)
+	}
+	content := fmt.Sprintf("[%s]\n%s\n", orgID, hashedBytes)
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store := &FileStore{credentials: make(map[uuid.UUID][]StoredKey), filePath: tmpFile}
+	if err := store.LoadFromFile(); err != nil {
+		t.Fatalf("Failed to load file: %v", err)
+	}
+	compares := 0
+	store.SetCompareObserver(func(time.Duration) { compares++ })
+
+	if valid, _ := store.ValidateCredentials(orgID, "wrong-key"); valid {
+		t.Fatal("Expected wrong key to be invalid")
+	}
+	if store.verified.len() != 0 {
+		t.Error("Expected failed verifications not to be cached")
+	}
+
+	for i := 0; i < 2; i++ {
+		key, valid, err := store.AuthenticateKey(orgID, apiKey)
+		if err != nil || !valid || key.Key != string(hashedBytes) {
+			t.Fatalf("Attempt %d: expected valid key, got %v, %v", i, valid, err)
+		}
+	}
+	if store.verified.len() != 1 {
+		t.Errorf("Expected one cached verification, got %d", store.verified.len())
+	}
+	if compares != 2 {
+		t.Errorf("Expected the cached verification to skip bcrypt, got %d comparisons", compares)
+	}
+	if valid, _ := store.ValidateCredentials(uuid.New(), apiKey); valid {
+		t.Error("Expected cached key to be invalid for another org")
+	}
+
+	// A cached verification does not outlive the key's expiry
+	store.mu.Lock()
+	store.credentials[orgID][0].ExpiresAt = time.Now().Add(-time.Minute)
+	store.mu.Unlock()
+	if valid, _ := store.ValidateCredentials(orgID, apiKey); valid {
+		t.Error("Expected expired key to be rejected despite the cache")
+	}
+
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if store.verified.len() != 0 {
+		t.Error("Expected reload to clear the cache")
+	}
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+// tokenIssuer is the iss claim of session tokens
+const tokenIssuer = "tf-backend-service"
+
+// tokenHeader is the encoded JOSE header of every session token (HS256)
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// MinTokenSecretLength is the minimum length of a session token secret
+const MinTokenSecretLength = 32
+
+var (
+	ErrInvalidToken = errors.New("invalid session token")
+	ErrTokenExpired = errors.New("session token expired")
+)
+
+// SessionClaims are the claims of a session token
+type SessionClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"` // Organization ID
+	KeyID     string   `json:"key_id,omitempty"`
+	Scopes    []string `json:"scopes"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+}
+
+// KeyLookup is implemented by stores that can find a key by its ID without
+// comparing hashes, so session tokens can be checked against revocation
+type KeyLookup interface {
+	// LookupKey returns the organization's key with the given ID if it
+	// exists and has not expired
+	LookupKey(orgID uuid.UUID, keyID string) (StoredKey, bool)
+}
+
+// TokenIssuer issues and verifies short-lived session tokens (HS256 JWTs)
+// that stand in for an API key, so the bcrypt comparison happens once per
+// session instead of on every request
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewTokenIssuer creates a token issuer. An empty secret generates a random
+// one, so tokens do not survive restarts and are only valid on this instance.
+func NewTokenIssuer(secret []byte, ttl time.Duration) (*TokenIssuer, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, MinTokenSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate token secret: %w", err)
+		}
+	}
+	if len(secret) < MinTokenSecretLength {
+		return nil, fmt.Errorf("session token secret too short: %d bytes (minimum %d)", len(secret), MinTokenSecretLength)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid session token TTL: %v (must be positive)", ttl)
+	}
+	return &TokenIssuer{secret: secret, ttl: ttl}, nil
+}
+
+// TTL returns the lifetime of issued tokens
+func (t *TokenIssuer) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue creates a token for the organization and the key it authenticated
+// with. Tokens never outlive the key.
+func (t *TokenIssuer) Issue(orgID uuid.UUID, key StoredKey, hasKey bool) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(t.ttl)
+
+	claims := SessionClaims{
+		Issuer:   tokenIssuer,
+		Subject:  orgID.String(),
+		Scopes:   DefaultScopes,
+		IssuedAt: now.Unix(),
+		ID:       uuid.New().String(),
+	}
+	if hasKey {
+		claims.KeyID = key.ID()
+		claims.Scopes = key.EffectiveScopes()
+		if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(expiresAt) {
+			expiresAt = key.ExpiresAt.UTC()
+		}
+		if key.Deprecated() && key.DeprecatedAfter.Before(expiresAt) {
+			expiresAt = key.DeprecatedAfter.UTC()
+		}
+	}
+	claims.ExpiresAt = expiresAt.Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to encode token claims: %w", err)
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + t.sign(unsigned), time.Unix(claims.ExpiresAt, 0).UTC(), nil
+}
+
+// Verify checks the token's signature and expiry and returns its claims
+func (t *TokenIssuer) Verify(token string) (SessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return SessionClaims{}, ErrInvalidToken
+	}
+
+	// Only HS256 tokens issued by this service are accepted, so the header
+	// must match exactly (this also rejects "alg":"none")
+	if parts[0] != tokenHeader {
+		return SessionClaims{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return SessionClaims{}, ErrInvalidToken
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(t.sign(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, expected) {
+		return SessionClaims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return SessionClaims{}, ErrInvalidToken
+	}
+	var claims SessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return SessionClaims{}, ErrInvalidToken
+	}
+	if claims.Issuer != tokenIssuer {
+		return SessionClaims{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return SessionClaims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// sign returns the encoded HMAC-SHA256 signature of the unsigned token
+func (t *TokenIssuer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SessionMiddleware authenticates requests with a session token in the
+// Authorization header ("Bearer <token>"), and falls back to Middleware
+// (X-Org-ID and X-API-Key) for requests without one. If the store
+// implements KeyLookup, the token's key must still exist, so revoked and
+// rotated keys end their sessions immediately and scope changes apply.
+func SessionMiddleware(tokens *TokenIssuer, store CredentialStore) func(http.Handler) http.Handler {
+	keyAuth := Middleware(store)
+	return func(next http.Handler) http.Handler {
+		fallback := keyAuth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ExtractBearerToken(r)
+			if token == "" {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := tokens.Verify(token)
+			if err != nil {
+				security.Emit(security.Request(r, "auth.token_rejected", security.SeverityWarning, "Rejected session token").
+					With("error", err))
+				http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+				return
+			}
+
+			orgID, err := uuid.Parse(claims.Subject)
+			if err != nil {
+				http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+				return
+			}
+
+			// A mismatching X-Org-ID points at a misconfigured client
+			if header := r.Header.Get("X-Org-ID"); header != "" && header != orgID.String() {
+				security.Emit(security.Request(r, "auth.token_org_mismatch", security.SeverityWarning, "Session token used for another org").
+					WithOrg(orgID.String()).With("x_org_id", header))
+				http.Error(w, "Session token does not match X-Org-ID", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := reqctx.WithOrgID(r.Context(), orgID)
+			if lookup, ok := store.(KeyLookup); ok && claims.KeyID != "" {
+				key, found := lookup.LookupKey(orgID, claims.KeyID)
+				if !found {
+					security.Emit(security.Request(r, "auth.token_revoked_key", security.SeverityWarning, "Session token for revoked key").
+						WithOrg(orgID.String()).WithKey(claims.KeyID))
+					http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+					return
+				}
+				ctx = context.WithValue(ctx, KeyContextKey, key)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// LookupKey returns the organization's key with the given ID
+func (s *FileStore) LookupKey(orgID uuid.UUID, keyID string) (StoredKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range s.credentials[orgID] {
+		if key.ID() == keyID && !key.Expired(now) {
+			return key, true
+		}
+	}
+	return StoredKey{}, false
+}
+
+// LookupKey returns the key from the first chained store that has it
+func (s *ChainStore) LookupKey(orgID uuid.UUID, keyID string) (StoredKey, bool) {
+	for _, store := range s.stores {
+		if lookup, ok := store.(KeyLookup); ok {
+			if key, found := lookup.LookupKey(orgID, keyID); found {
+				return key, true
+			}
+		}
+	}
+	return StoredKey{}, false
+}
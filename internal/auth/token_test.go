@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var testTokenSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestTokenIssueAndVerify(t *testing.T) {
+	tokens, err := NewTokenIssuer(testTokenSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("NewTokenIssuer failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	key := StoredKey{Key: "read-key", Scopes: []string{ScopeStateRead}}
+	token, expiresAt, err := tokens.Issue(orgID, key, true)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("Unexpected expiry %v", expiresAt)
+	}
+
+	claims, err := tokens.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.Subject != orgID.String() || claims.KeyID != key.ID() {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != ScopeStateRead {
+		t.Errorf("Expected key scopes, got %v", claims.Scopes)
+	}
+
+	// Tokens never outlive their key
+	key.ExpiresAt = time.Now().Add(10 * time.Second)
+	_, expiresAt, _ = tokens.Issue(orgID, key, true)
+	if expiresAt.After(key.ExpiresAt) {
+		t.Errorf("Token expires at %v after its key %v", expiresAt, key.ExpiresAt)
+	}
+}
+
+func TestTokenVerifyRejects(t *testing.T) {
+	tokens, _ := NewTokenIssuer(testTokenSecret, time.Minute)
+	token, _, _ := tokens.Issue(uuid.New(), StoredKey{}, false)
+	parts := strings.Split(token, ".")
+
+	other, _ := NewTokenIssuer([]byte(strings.Repeat("x", MinTokenSecretLength)), time.Minute)
+	foreign, _, _ := other.Issue(uuid.New(), StoredKey{}, false)
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := map[string]string{
+		"empty":            "",
+		"malformed":        "not-a-token",
+		"tampered payload": parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"tf-backend-service","sub":"x","exp":9999999999}`)) + "." + parts[2],
+		"alg none":         none + "." + parts[1] + ".",
+		"other secret":     foreign,
+	}
+	for name, token := range tests {
+		if _, err := tokens.Verify(token); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	expired := &TokenIssuer{secret: testTokenSecret, ttl: -time.Second}
+	token, _, _ = expired.Issue(uuid.New(), StoredKey{}, false)
+	if _, err := tokens.Verify(token); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	if _, err := NewTokenIssuer([]byte("short"), time.Minute); err == nil {
+		t.Error("Expected error for a short secret")
+	}
+	if _, err := NewTokenIssuer(nil, 0); err == nil {
+		t.Error("Expected error for a zero TTL")
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	orgID := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	tmpFile := filepath.Join(t.TempDir(), "auth.cfg")
+	content := fmt.Sprintf("[%s]\nsession-key\n", orgID)
+	if err := os.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	store, err := LoadFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to load store: %v", err)
+	}
+
+	tokens, _ := NewTokenIssuer(nil, time.Minute)
+	key, _, _ := store.AuthenticateKey(orgID, "session-key")
+	token, _, _ := tokens.Issue(orgID, key, true)
+
+	h := SessionMiddleware(tokens, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := GetOrgIDFromContext(r.Context()); !ok || id != orgID {
+			t.Errorf("Expected org %s in context, got %s", orgID, id)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/state/prod", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "bearer token", headers: map[string]string{"Authorization": "Bearer " + token}, want: http.StatusOK},
+		{name: "token with matching org", headers: map[string]string{"Authorization": "Bearer " + token, "X-Org-ID": orgID.String()}, want: http.StatusOK},
+		{name: "token with other org", headers: map[string]string{"Authorization": "Bearer " + token, "X-Org-ID": uuid.New().String()}, want: http.StatusUnauthorized},
+		{name: "invalid token", headers: map[string]string{"Authorization": "Bearer " + token + "x"}, want: http.StatusUnauthorized},
+		{name: "api key fallback", headers: map[string]string{"X-Org-ID": orgID.String(), "X-API-Key": "session-key"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.headers); got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	// Removing the key ends its sessions
+	if err := os.WriteFile(tmpFile, []byte(fmt.Sprintf("[%s]\nother-key\n", orgID)), 0600); err != nil {
+		t.Fatalf("Failed to rewrite test file: %v", err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := serve(map[string]string{"Authorization": "Bearer " + token}); got != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a revoked key, got %d", got)
+	}
+}
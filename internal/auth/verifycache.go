@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// verifyCache remembers successful key verifications so that repeated
+// requests with the same key skip bcrypt, which costs 50-200ms at cost 12.
+// Entries are keyed by SHA-256(orgID || apiKey), so plaintext keys are not
+// kept in memory, and map to the stored key that matched. A hit is only
+// trusted while that stored key is still loaded and not expired, so revoked,
+// rotated and rehashed keys fall back to bcrypt. The cache is cleared on
+// every reload of the auth config file.
+//
+// Only successful verifications are cached: wrong keys always pay the full
+// bcrypt cost and cannot grow the cache.
+type verifyCache struct {
+	mu      sync.RWMutex
+	entries map[[sha256.Size]byte]string // cache key -> matching stored key
+}
+
+// verifyCacheKey returns the cache key of a credential pair
+func verifyCacheKey(orgID uuid.UUID, apiKey string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(orgID[:])
+	h.Write([]byte(apiKey))
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// lookup returns the stored key a credential pair was verified against
+func (c *verifyCache) lookup(key [sha256.Size]byte) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	storedKey, ok := c.entries[key]
+	return storedKey, ok
+}
+
+// store records a successful verification
+func (c *verifyCache) store(key [sha256.Size]byte, storedKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]string)
+	}
+	c.entries[key] = storedKey
+}
+
+// clear forgets every verification
+func (c *verifyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// len returns the number of cached verifications
+func (c *verifyCache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// cachedKey returns the key among storedKeys that a cached verification
+// points to, if it is still valid
+func (s *FileStore) cachedKey(cacheKey [sha256.Size]byte, storedKeys []StoredKey, now time.Time) (StoredKey, bool) {
+	hash, ok := s.verified.lookup(cacheKey)
+	if !ok {
+		return StoredKey{}, false
+	}
+	for _, storedKey := range storedKeys {
+		if storedKey.Key == hash && !storedKey.Expired(now) {
+			return storedKey, true
+		}
+	}
+	return StoredKey{}, false
+}
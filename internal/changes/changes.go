@@ -0,0 +1,294 @@
+package changes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Change types
+const (
+	// TypeInsert records a row appended to the organization's data
+	TypeInsert = "insert"
+
+	// TypeDelete records a deleted state
+	TypeDelete = "delete"
+
+	// TypeStateVersion records a new version of a state
+	TypeStateVersion = "state_version"
+)
+
+// Resources a change applies to
+const (
+	ResourceData  = "data"
+	ResourceState = "state"
+)
+
+// ErrExpired is returned when changes after the requested sequence number
+// were already dropped from the log
+var ErrExpired = errors.New("changes expired")
+
+// Change is an entry in an organization's change log
+type Change struct {
+	// Seq increases by one with every change of the organization
+	Seq       int64     `json:"seq"`
+	Type      string    `json:"type"`
+	Resource  string    `json:"resource"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Inserted rows
+	UploadID string                 `json:"upload_id,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+
+	// State versions and deletes
+	StateName string `json:"state_name,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+
+	// Client that wrote a state version, e.g. "terraform/1.9.0"
+	Client string `json:"client,omitempty"`
+}
+
+// entry is a change as persisted in the log file
+type entry struct {
+	OrgID uuid.UUID `json:"org_id"`
+	Change
+}
+
+// orgLog holds the retained changes of an organization
+type orgLog struct {
+	last    int64 // Sequence number of the last change ever recorded
+	changes []Change
+}
+
+// Log is an append-only change log of every organization, persisted as
+// JSON lines. Only the newest changes of each organization are retained;
+// the file is compacted once it holds more dropped changes than retained
+// ones.
+type Log struct {
+	mu        sync.Mutex
+	filePath  string
+	file      *os.File
+	orgs      map[uuid.UUID]*orgLog
+	retention int // Changes retained per organization
+	retained  int // Changes retained across organizations
+	dropped   int // Changes in the file that are no longer retained
+}
+
+// Open loads the change log from filePath, creating it if it does not
+// exist, and retains up to retention changes per organization
+func Open(filePath string, retention int) (*Log, error) {
+	if retention < 1 {
+		return nil, fmt.Errorf("invalid change log retention: %d (must be at least 1)", retention)
+	}
+
+	l := &Log{
+		filePath:  filePath,
+		orgs:      make(map[uuid.UUID]*orgLog),
+		retention: retention,
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	if l.dropped > 0 {
+		if err := l.compact(); err != nil {
+			return nil, err
+		}
+	} else if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load replays the persisted changes
+func (l *Log) load() error {
+	file, err := os.Open(l.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open change log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("failed to parse change log %s line %d: %w", l.filePath, line, err)
+		}
+		l.append(e.OrgID, e.Change)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read change log: %w", err)
+	}
+	return nil
+}
+
+// openFile opens the log file for appending
+func (l *Log) openFile() error {
+	if err := os.MkdirAll(filepath.Dir(l.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create change log directory: %w", err)
+	}
+	file, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open change log: %w", err)
+	}
+	l.file = file
+	return nil
+}
+
+// append retains the change in memory, dropping the organization's oldest
+// change beyond the retention. Must be called with l.mu held.
+func (l *Log) append(orgID uuid.UUID, change Change) {
+	org, exists := l.orgs[orgID]
+	if !exists {
+		org = &orgLog{}
+		l.orgs[orgID] = org
+	}
+	org.last = change.Seq
+	org.changes = append(org.changes, change)
+	l.retained++
+
+	if len(org.changes) > l.retention {
+		org.changes = org.changes[1:]
+		l.retained--
+		l.dropped++
+	}
+}
+
+// Record assigns the change the organization's next sequence number and
+// the current time, and appends it to the log
+func (l *Log) Record(orgID uuid.UUID, change Change) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("change log is closed")
+	}
+
+	var last int64
+	if org, exists := l.orgs[orgID]; exists {
+		last = org.last
+	}
+	change.Seq = last + 1
+	change.Timestamp = time.Now().UTC()
+
+	line, err := json.Marshal(entry{OrgID: orgID, Change: change})
+	if err != nil {
+		return fmt.Errorf("failed to marshal change: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write change log: %w", err)
+	}
+	l.append(orgID, change)
+
+	// The change is already persisted, so a failed compaction is retried
+	// with the next change
+	if l.dropped > l.retained {
+		if err := l.compact(); err != nil {
+			slog.Error("Failed to compact change log", "error", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the file with only the retained changes. The file is
+// replaced atomically so a crash never leaves a partial log. Must be called
+// with l.mu held.
+func (l *Log) compact() error {
+	if err := os.MkdirAll(filepath.Dir(l.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create change log directory: %w", err)
+	}
+
+	tmpPath := l.filePath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create change log: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for orgID, org := range l.orgs {
+		for _, change := range org.changes {
+			if err := encoder.Encode(entry{OrgID: orgID, Change: change}); err != nil {
+				tmp.Close()
+				return fmt.Errorf("failed to write change log: %w", err)
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write change log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write change log: %w", err)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	renameErr := os.Rename(tmpPath, l.filePath)
+	if err := l.openFile(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to replace change log: %w", renameErr)
+	}
+	l.dropped = 0
+	return nil
+}
+
+// Since returns up to limit of the organization's changes with a sequence
+// number greater than since, and the sequence number of its last change.
+// It returns ErrExpired if some of those changes are no longer retained.
+func (l *Log) Since(orgID uuid.UUID, since int64, limit int) ([]Change, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	org, exists := l.orgs[orgID]
+	if !exists || since >= org.last {
+		var last int64
+		if exists {
+			last = org.last
+		}
+		return []Change{}, last, nil
+	}
+
+	// Retained changes have consecutive sequence numbers ending at last
+	first := org.changes[0].Seq
+	if since < first-1 {
+		return nil, org.last, ErrExpired
+	}
+	start := int(since - first + 1)
+	end := min(start+limit, len(org.changes))
+
+	changes := make([]Change, end-start)
+	copy(changes, org.changes[start:end])
+	return changes, org.last, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
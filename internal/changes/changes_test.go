@@ -0,0 +1,95 @@
+package changes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestLogSince(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "changes.jsonl"), 100)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer l.Close()
+
+	orgA, orgB := uuid.New(), uuid.New()
+	for i := 0; i < 3; i++ {
+		if err := l.Record(orgA, Change{Type: TypeInsert, Resource: ResourceData, Data: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	l.Record(orgB, Change{Type: TypeStateVersion, Resource: ResourceState, StateName: "prod", Version: 1})
+
+	changes, latest, err := l.Since(orgA, 1, 10)
+	if err != nil {
+		t.Fatalf("Since failed: %v", err)
+	}
+	if latest != 3 || len(changes) != 2 || changes[0].Seq != 2 || changes[1].Seq != 3 {
+		t.Errorf("Expected changes 2 and 3 of 3, got %+v (latest %d)", changes, latest)
+	}
+
+	// Sequence numbers are per organization
+	changes, latest, _ = l.Since(orgB, 0, 10)
+	if latest != 1 || len(changes) != 1 || changes[0].StateName != "prod" {
+		t.Errorf("Expected the state change of orgB, got %+v (latest %d)", changes, latest)
+	}
+
+	changes, _, _ = l.Since(orgA, 0, 2)
+	if len(changes) != 2 || changes[1].Seq != 2 {
+		t.Errorf("Expected the limit to apply, got %+v", changes)
+	}
+
+	changes, latest, err = l.Since(uuid.New(), 0, 10)
+	if err != nil || latest != 0 || len(changes) != 0 {
+		t.Errorf("Expected no changes for an unknown org, got %+v, %d, %v", changes, latest, err)
+	}
+}
+
+func TestLogRetentionAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	l, err := Open(path, 2)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	for i := 0; i < 5; i++ {
+		l.Record(orgID, Change{Type: TypeInsert, Resource: ResourceData})
+	}
+
+	if _, _, err := l.Since(orgID, 2, 10); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired for dropped changes, got %v", err)
+	}
+	changes, _, err := l.Since(orgID, 3, 10)
+	if err != nil || len(changes) != 2 || changes[0].Seq != 4 {
+		t.Errorf("Expected retained changes 4 and 5, got %+v, %v", changes, err)
+	}
+	l.Close()
+
+	// Dropped changes are compacted away
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 4 {
+		t.Errorf("Expected the file to be compacted, got %d lines", lines)
+	}
+
+	// Sequence numbers continue after a restart
+	l, err = Open(path, 2)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.Close()
+	l.Record(orgID, Change{Type: TypeDelete, Resource: ResourceState, StateName: "prod"})
+
+	changes, latest, err := l.Since(orgID, 4, 10)
+	if err != nil || latest != 6 || len(changes) != 2 || changes[1].Type != TypeDelete {
+		t.Errorf("Expected changes 5 and 6 after reload, got %+v (latest %d), %v", changes, latest, err)
+	}
+}
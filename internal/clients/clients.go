@@ -0,0 +1,214 @@
+// Package clients identifies the client software and version behind a
+// request and records which versions upload data and write state, so
+// breaking changes can wait until old clients are gone.
+package clients
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VersionHeader carries the client software and version as "name/version",
+// e.g. "terraform-provider-eterrain/1.4.2"
+const VersionHeader = "X-Client-Version"
+
+// providerVersionHeader is the older header carrying only the provider
+// version
+const providerVersionHeader = "X-Provider-Version"
+
+// Client names used when a request does not name its client
+const (
+	NameProvider = "terraform-provider"
+	NameUnknown  = "unknown"
+)
+
+// providerPrefix starts the User-Agent product of Terraform providers
+const providerPrefix = "terraform-provider-"
+
+// maxClients bounds the distinct clients tracked; further clients are
+// counted under Other
+const maxClients = 1000
+
+// Other collects the clients beyond maxClients
+var Other = Client{Name: "other"}
+
+// tokenPattern matches client names and versions
+var tokenPattern = regexp.MustCompile(`^[a-zA-Z0-9_.+-]{1,64}$`)
+
+// Client is the client software and version of a request
+type Client struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// String returns the client as "name/version", or the name alone without a
+// version
+func (c Client) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + "/" + c.Version
+}
+
+// Parse identifies the client of a request from, in order of precedence,
+// the X-Client-Version header, the X-Provider-Version header of older
+// providers, and the User-Agent, preferring a terraform-provider-* product
+// over Terraform itself (as in state writes by the http backend) and over
+// any other product. Malformed values are ignored.
+func Parse(r *http.Request) Client {
+	if client, ok := parseProduct(r.Header.Get(VersionHeader)); ok {
+		return client
+	}
+
+	agent := userAgentClient(r.UserAgent())
+	if version := r.Header.Get(providerVersionHeader); tokenPattern.MatchString(version) {
+		if !strings.HasPrefix(agent.Name, providerPrefix) {
+			agent.Name = NameProvider
+		}
+		agent.Version = version
+	}
+	return agent
+}
+
+// userAgentClient picks the most specific product of a User-Agent
+func userAgentClient(userAgent string) Client {
+	var terraform, first *Client
+	for _, field := range strings.Fields(userAgent) {
+		client, ok := parseProduct(field)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(client.Name, providerPrefix):
+			return client
+		case client.Name == "terraform" && terraform == nil:
+			terraform = &client
+		case first == nil:
+			first = &client
+		}
+	}
+	switch {
+	case terraform != nil:
+		return *terraform
+	case first != nil:
+		return *first
+	}
+	return Client{Name: NameUnknown}
+}
+
+// parseProduct parses a "name/version" product token; names are compared
+// case-insensitively and stored in lower case
+func parseProduct(product string) (Client, bool) {
+	name, version, found := strings.Cut(strings.TrimSpace(product), "/")
+	if !found || !tokenPattern.MatchString(name) || !tokenPattern.MatchString(version) {
+		return Client{}, false
+	}
+	return Client{Name: strings.ToLower(name), Version: version}, true
+}
+
+// Operations recorded by the tracker
+const (
+	OpUpload     = "upload"
+	OpStateWrite = "state_write"
+)
+
+// Usage reports the use of a client version since the server started
+type Usage struct {
+	Client
+	Uploads     int64     `json:"uploads"`
+	StateWrites int64     `json:"state_writes"`
+	Orgs        int       `json:"orgs"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// clientUsage counts the use of a client version
+type clientUsage struct {
+	Usage
+	orgs map[uuid.UUID]struct{}
+}
+
+// Tracker counts uploads and state writes per client version
+type Tracker struct {
+	mu      sync.Mutex
+	clients map[Client]*clientUsage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		clients: make(map[Client]*clientUsage),
+		now:     time.Now,
+	}
+}
+
+// Record counts an operation (OpUpload or OpStateWrite) of a client on
+// behalf of an organization
+func (t *Tracker) Record(orgID uuid.UUID, client Client, op string) {
+	now := t.now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.clients[client]
+	if !ok {
+		if len(t.clients) >= maxClients {
+			client = Other
+			usage, ok = t.clients[client]
+		}
+		if !ok {
+			usage = &clientUsage{Usage: Usage{Client: client, FirstSeen: now}, orgs: make(map[uuid.UUID]struct{})}
+			t.clients[client] = usage
+		}
+	}
+
+	switch op {
+	case OpUpload:
+		usage.Uploads++
+	case OpStateWrite:
+		usage.StateWrites++
+	}
+	usage.orgs[orgID] = struct{}{}
+	usage.Orgs = len(usage.orgs)
+	usage.LastSeen = now
+}
+
+// Report returns the usage of every client version, or only of the named
+// client when name is not empty, ordered by name and most recent use first
+func (t *Tracker) Report(name string) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := make([]Usage, 0, len(t.clients))
+	for client, usage := range t.clients {
+		if name == "" || client.Name == strings.ToLower(name) {
+			report = append(report, usage.Usage)
+		}
+	}
+	slices.SortFunc(report, func(a, b Usage) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Version, b.Version)
+	})
+	return report
+}
+
+// Stats returns the number of distinct client versions tracked
+func (t *Tracker) Stats() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients)
+}
@@ -0,0 +1,82 @@
+package clients
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Client
+	}{
+		{"client version header", map[string]string{VersionHeader: "Terraform-Provider-Eterrain/1.4.2", "User-Agent": "Terraform/1.9.0"},
+			Client{Name: "terraform-provider-eterrain", Version: "1.4.2"}},
+		{"provider in user agent", map[string]string{"User-Agent": "Terraform/1.9.0 (+https://www.terraform.io) Terraform-Plugin-SDK/2.33.0 terraform-provider-eterrain/1.3.0"},
+			Client{Name: "terraform-provider-eterrain", Version: "1.3.0"}},
+		{"terraform http backend", map[string]string{"User-Agent": "Go-http-client/1.1 Terraform/1.9.0"},
+			Client{Name: "terraform", Version: "1.9.0"}},
+		{"other client", map[string]string{"User-Agent": "curl/8.5.0"},
+			Client{Name: "curl", Version: "8.5.0"}},
+		{"provider version header", map[string]string{"X-Provider-Version": "1.2.0", "User-Agent": "Go-http-client/1.1"},
+			Client{Name: NameProvider, Version: "1.2.0"}},
+		{"malformed client version header", map[string]string{VersionHeader: "eterrain 1.0", "User-Agent": "curl/8.5.0"},
+			Client{Name: "curl", Version: "8.5.0"}},
+		{"no client", nil, Client{Name: NameUnknown}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/upload", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := Parse(r); got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return clock }
+
+	orgA, orgB := uuid.New(), uuid.New()
+	old := Client{Name: "terraform-provider-eterrain", Version: "1.0.0"}
+	current := Client{Name: "terraform-provider-eterrain", Version: "1.4.2"}
+
+	tracker.Record(orgA, old, OpUpload)
+	clock = clock.Add(time.Hour)
+	tracker.Record(orgA, current, OpUpload)
+	tracker.Record(orgB, current, OpUpload)
+	tracker.Record(orgB, current, OpStateWrite)
+	tracker.Record(orgB, Client{Name: "terraform", Version: "1.9.0"}, OpStateWrite)
+
+	report := tracker.Report("Terraform-Provider-Eterrain")
+	if len(report) != 2 || report[0].Client != current || report[1].Client != old {
+		t.Fatalf("Expected the provider versions, most recent first, got %+v", report)
+	}
+	if report[0].Uploads != 2 || report[0].StateWrites != 1 || report[0].Orgs != 2 {
+		t.Errorf("Unexpected usage of the current version %+v", report[0])
+	}
+	if len(tracker.Report("")) != 3 {
+		t.Errorf("Expected 3 client versions, got %d", len(tracker.Report("")))
+	}
+
+	// Clients beyond the limit are counted together
+	for i := 0; i < maxClients; i++ {
+		tracker.Record(orgA, Client{Name: "bot", Version: uuid.New().String()[:8]}, OpUpload)
+	}
+	if n := tracker.Stats(); n != maxClients+1 {
+		t.Errorf("Expected %d tracked clients, got %d", maxClients+1, n)
+	}
+	if others := tracker.Report(Other.Name); len(others) != 1 || others[0].Uploads != 3 {
+		t.Errorf("Expected 3 uploads counted as other, got %+v", others)
+	}
+}
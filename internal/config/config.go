@@ -2,10 +2,24 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/deprecation"
+	"github.com/eterrain/tf-backend-service/internal/durability"
+	"github.com/eterrain/tf-backend-service/internal/features"
+	"github.com/eterrain/tf-backend-service/internal/fsperm"
+	"github.com/eterrain/tf-backend-service/internal/geoip"
+	"github.com/eterrain/tf-backend-service/internal/logging"
+	"github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/outbound"
+	"github.com/eterrain/tf-backend-service/internal/public"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/storage"
 	"gopkg.in/ini.v1"
 )
 
@@ -15,21 +29,288 @@ type Config struct {
 	Host string
 	Port int
 
+	// TrustedProxies is a comma-separated list of proxy CIDRs whose
+	// X-Forwarded-For and X-Real-IP headers are honored (none when empty)
+	TrustedProxies string
+
+	// PublicEndpoints is a comma-separated list of the endpoints served
+	// without authentication (all when empty, none with "none"; see
+	// public.Parse)
+	PublicEndpoints string
+
 	// Storage configuration
 	StorageType string // "memory", "csv", "mysql", "dual", etc.
 	StoragePath string // Path for file-based storage
+	CSVLayout   string // "json" (data column) or "wide" (one column per attribute)
+
+	// MaxStateSize limits Terraform state uploads in bytes
+	MaxStateSize int64
+
+	// StateLockTTL lets another client take over a state lock that has seen
+	// no heartbeat for longer (0 = locks never expire)
+	StateLockTTL time.Duration
+
+	// Concurrent writes of the same state
+	StateWriteQueue        int           // Writes that may wait for a write in progress
+	StateWriteQueueTimeout time.Duration // How long a write waits
+
+	// Memory storage snapshots
+	SnapshotFile     string        // File states and locks are persisted to (empty disables snapshots)
+	SnapshotInterval time.Duration // Time between snapshots
+
+	// CSV dialect of stored files and exports
+	CSVDelimiter string // comma, semicolon, tab or pipe
+	CSVQuote     string // "minimal" or "all"
+	CSVBOM       bool   // Write a UTF-8 byte order mark at the start of files
+
+	// CSVEncryptionKeyFile holds the base64 master key encrypting CSV data
+	// files at rest (plaintext files when empty)
+	CSVEncryptionKeyFile string
+
+	// When CSV and state files are synced to disk
+	WriteDurability   string        // none, flush, fsync-per-write or fsync-interval
+	WriteSyncInterval time.Duration // Time between background syncs with fsync-interval
+
+	// Storage health probes
+	ProbeInterval         time.Duration // Time between probes (0 disables background probes)
+	ProbeTimeout          time.Duration // Probes running longer fail
+	ProbeFailureThreshold int           // Consecutive failed probes before /ready reports 503
+	ProbeLatencyThreshold time.Duration // Probes slower than this fail (0 = no latency check)
 
 	// Database configuration (for MySQL storage)
-	DBHost     string
-	DBPort     int
-	DBUser     string
-	DBPassword string
-	DBName     string
+	DBHost            string
+	DBPort            int
+	DBUser            string
+	DBPassword        string
+	DBName            string
+	DBConnMaxIdleTime time.Duration // Idle connections are closed after this long (0 = until their lifetime ends)
 
 	// Security
 	EnableTLS bool
 	CertFile  string
 	KeyFile   string
+
+	// PermissionCheck is "enforce", "warn" or "off" for the startup check of
+	// auth.cfg, data directory and TLS key permissions
+	PermissionCheck string
+
+	// API key hashing
+	BcryptCost  int  // Target bcrypt cost for API key hashes
+	RehashOnUse bool // Rehash plaintext/lower-cost keys on successful validation
+
+	// Session tokens exchanged for API keys at POST /api/v1/token
+	SessionTokensEnabled bool
+	SessionTokenSecret   string        // HMAC secret shared by all instances (random per process when empty)
+	SessionTokenTTL      time.Duration // Lifetime of issued tokens
+
+	// Signed download URLs for states and data exports at POST /api/v1/downloads
+	DownloadURLsEnabled bool
+	DownloadURLSecret   string        // HMAC secret shared by all instances (random per process when empty)
+	DownloadURLMaxTTL   time.Duration // Longest lifetime a URL can be requested with
+
+	// Anti-replay timestamp and nonce headers on state-changing requests
+	ReplayProtection bool
+	ReplayWindow     time.Duration // Accepted timestamp skew; nonces are remembered this long
+
+	// Lockout of organization and client IP pairs after repeated failed
+	// authentication
+	AuthLockoutThreshold   int           // Failures within the window that lock a pair out (disabled when 0)
+	AuthLockoutWindow      time.Duration // Period failures are counted in
+	AuthLockoutDuration    time.Duration // First lockout; each consecutive one doubles
+	AuthLockoutMaxDuration time.Duration // Longest lockout
+	AuthLockoutMaxTracked  int           // Org and client IP pairs tracked; the least recently seen are evicted beyond this
+
+	// Grace mode accepting recently validated credentials while the auth
+	// backend is degraded
+	AuthGracePeriod         time.Duration // How long validated credentials stay usable (disabled when 0)
+	AuthGraceReloadFailures int           // Consecutive failed auth.cfg reloads that count as degraded
+	AuthGraceReadOnly       bool          // Only accept GET and HEAD requests in grace mode
+
+	// Admin API
+	AdminAPIKey string // Shared secret for /admin routes (disabled when empty)
+
+	// AuthBootstrap creates an empty ./auth.cfg when it is missing, so orgs
+	// can be provisioned through the admin API
+	AuthBootstrap bool
+
+	// Billing / usage accounting
+	BillingEnabled   bool
+	BillingUsageFile string // JSON file where usage counters are persisted
+
+	// Self-test endpoint for provider acceptance tests
+	SelfTestEnabled       bool
+	SelfTestRunsPerMinute int // Self-test runs allowed per client IP
+
+	// Authorization policies evaluated by an OPA server
+	PolicyOPAURL       string        // OPA base URL (policies disabled when empty)
+	PolicyDecisionPath string        // OPA document queried for decisions
+	PolicyDir          string        // Directory of .rego files pushed to OPA on start (optional)
+	PolicyTimeout      time.Duration // Timeout of a policy evaluation
+	PolicyFailOpen     bool          // Allow requests when OPA is unavailable
+
+	// Prometheus metrics endpoint
+	MetricsEnabled bool
+	MetricsAddress string // Serve /metrics on this host:port instead of the API listener (empty = API listener)
+
+	// Load shedding under saturation
+	MaxInFlight    int           // Requests processed concurrently
+	MaxQueue       int           // Requests waiting for a free slot
+	QueueTimeout   time.Duration // How long a queued request waits before being shed
+	ShedInFlight   int           // In-flight requests at which low-priority traffic is shed
+	ShedQueueDepth int           // Queue depth at which low-priority traffic is shed
+	ShedRetryAfter time.Duration // Retry-After advertised to shed clients
+
+	// Request timeouts per route group
+	TimeoutDefault time.Duration // Health, self-test and metrics
+	TimeoutState   time.Duration // Terraform state and lock routes
+	TimeoutUpload  time.Duration // Data uploads
+	TimeoutExport  time.Duration // Data queries and billing exports
+	TimeoutAdmin   time.Duration // Other admin routes
+
+	// Soft quota warnings
+	QuotaWarningsEnabled bool
+	QuotaStorageLimit    int64         // Storage soft limit per org in bytes (0 = no storage warnings)
+	QuotaWarnRatio       float64       // Fraction of a quota at which warnings start
+	QuotaNotifyCooldown  time.Duration // Minimum time between events per org and quota
+
+	// Daily ingest caps per org, counting from midnight UTC (0 = no cap)
+	QuotaDailyIngestBytes int64
+	QuotaDailyIngestRows  int64
+	QuotaIngestFile       string // JSON file where the day's ingest counters are persisted
+
+	// Notification channels and per-event-type routing
+	NotifySlackWebhookURL string
+	NotifyWebhookURL      string
+	NotifySMTPHost        string
+	NotifySMTPPort        int
+	NotifySMTPUsername    string
+	NotifySMTPPassword    string
+	NotifySMTPFrom        string
+	NotifySMTPTo          string            // Comma-separated recipients
+	NotifyRoutes          map[string]string // Event type -> comma-separated channels (slack, email, webhook)
+
+	// Client-provided observation timestamps on uploads
+	MaxClockSkew      time.Duration // How far ahead of server time observed_at may be
+	MaxObservationAge time.Duration // How far in the past observed_at may be (0 = unlimited)
+
+	// Per-organization provider/category/resource type allowlists
+	TaxonomyFile string // JSON file where allowlists are persisted
+
+	// Per-organization attribute normalization rules
+	NormalizationFile string // JSON file where rules are persisted
+
+	// Transformation hooks run on accepted uploads before storage
+	UploadHooksFile   string        // JSON file listing the hooks (empty disables hooks)
+	UploadHookTimeout time.Duration // Timeout of hooks listed without one
+
+	// Change feed of uploads and state changes
+	ChangeLogEnabled   bool
+	ChangeLogFile      string // JSON lines file where changes are persisted
+	ChangeLogRetention int    // Changes retained per org
+
+	// State access audit log
+	AuditEnabled   bool
+	AuditDir       string        // Directory of the daily event files
+	AuditRetention time.Duration // How long daily event files are kept (0 = forever)
+	AuditDropDir   string        // Directory receiving per-org files of completed days (empty disables drops)
+
+	// Full sync streams for new consumers
+	FullSyncMaxConcurrent int           // Full syncs running at once across all orgs
+	FullSyncChunkInterval time.Duration // Pause between the chunks of a sync
+
+	// GeoIP country resolution and country lists
+	GeoIPDatabase       string // IP range CSV country database (GeoIP disabled when empty)
+	GeoIPAllowCountries string // Comma-separated countries allowed for all orgs (empty = all)
+	GeoIPDenyCountries  string // Comma-separated countries denied for all orgs
+	GeoIPAllowUnknown   bool   // Let addresses missing from the database pass allow lists
+	GeoIPListsFile      string // JSON file where per-org country lists are persisted
+
+	// Application log
+	LogLevel  string // "debug", "info", "warn" or "error"
+	LogFormat string // "text" or "json"
+	LogFile   string // File the log is appended to (empty = stderr)
+
+	// Security event stream
+	SecurityLogOutput     string // "app" (application log), "file" or "syslog"
+	SecurityLogFormat     string // "json" or "cef" for the file and syslog outputs
+	SecurityLogFile       string // File events are appended to with the file output
+	SecuritySyslogAddress string // udp://host:port or tcp://host:port (empty = local syslog)
+
+	// Rotation of file-based logs
+	LogRotateMaxSize    int64         // Rotate a log file before it grows beyond this many bytes (0 = no limit)
+	LogRotateInterval   time.Duration // Rotate at each multiple of this interval (0 = no time-based rotation)
+	LogRotateMaxBackups int           // Rotated files kept per log (0 = all)
+	LogRotateMaxAge     time.Duration // Rotated files older than this are removed (0 = keep)
+	LogRotateCompress   bool          // Gzip rotated files
+
+	// Request recording for debugging
+	RecordingEnabled    bool // Enable the recording admin routes
+	RecordingBufferSize int  // Exchanges kept across all recorded orgs
+	RecordingMaxBody    int  // Request and response bodies are truncated to this many bytes
+
+	// Per-organization rate limit: requests per minute, and the burst an
+	// idle organization can send at once (0 = the requests per minute)
+	RateLimitRequestsPerMinute int
+	RateLimitBurst             int
+
+	// Limits of data uploads and state requests apart from the other
+	// endpoints (0 requests per minute = share the limit above)
+	RateLimitUploadRequestsPerMinute int
+	RateLimitUploadBurst             int
+	RateLimitStateRequestsPerMinute  int
+	RateLimitStateBurst              int
+
+	// Per-client-IP limit of requests before authentication (0 = off)
+	RateLimitIPRequestsPerMinute int
+	RateLimitIPBurst             int
+
+	// RateLimitMaxBuckets bounds the per-organization rate limit buckets
+	// kept in memory; least recently used buckets are evicted beyond it
+	RateLimitMaxBuckets int
+
+	// RateLimitOverridesFile is the JSON file where per-organization rate
+	// limit overrides are persisted
+	RateLimitOverridesFile string
+
+	// Daily rollups of uploaded data
+	RollupEnabled   bool
+	RollupDir       string        // Directory with one rollup file per org
+	RollupInterval  time.Duration // Interval between builds of orgs with new data
+	RollupSumFields string        // Comma-separated numeric fields summed per day, provider and category
+
+	// Response cache of data queries, invalidated when an org uploads
+	QueryCacheEnabled  bool
+	QueryCacheMaxBytes int64         // Total size of cached responses
+	QueryCacheTTL      time.Duration // Maximum age of cached responses (0 = until invalidated)
+
+	// Quarantine of uploads that fail validation
+	QuarantineEnabled   bool
+	QuarantineDir       string        // Directory with the quarantined payloads
+	QuarantineMaxBytes  int64         // Total size of quarantined payloads; further rejections are discarded
+	QuarantineRetention time.Duration // How long entries are kept (0 = until re-ingested or discarded)
+
+	// Deprecations lists deprecated endpoints and query parameters,
+	// separated by commas (see deprecation.ParseRules)
+	Deprecations string
+
+	// Features is a comma-separated list of feature flags gating
+	// experimental endpoints; flags prefixed with "-" are disabled (see
+	// features.Parse)
+	Features string
+
+	// Proxy and trusted CAs of calls to external systems (webhooks, OPA)
+	OutboundProxy    string // "environment" (HTTP(S)_PROXY and NO_PROXY), "none" or a proxy URL
+	OutboundNoProxy  string // Comma-separated hosts, domains and CIDRs bypassing a proxy URL
+	OutboundCABundle string // PEM file of CAs trusted in addition to the system pool
+
+	// FaultInjectionEnabled allows latency and errors to be injected into
+	// the storage and auth layers for resilience tests (never in production)
+	FaultInjectionEnabled bool
+
+	// Config file the configuration was loaded from and its keys with a
+	// value, recorded for the effective configuration (see Effective)
+	file     string
+	fileKeys map[string]bool
 }
 
 // Load loads configuration from backend_service.cfg file
@@ -45,18 +326,210 @@ func Load() (*Config, error) {
 
 	// Fall back to environment variables if config file not found
 	config := &Config{
-		Host:        getEnv("HOST", "127.0.0.1"),
-		Port:        getEnvAsInt("PORT", 7777),
-		StorageType: getEnv("STORAGE_TYPE", "csv"),
-		StoragePath: getEnv("STORAGE_PATH", "./data"),
-		DBHost:      getEnv("DB_HOST", "localhost"),
-		DBPort:      getEnvAsInt("DB_PORT", 3306),
-		DBUser:      getEnv("DB_USER", ""),
-		DBPassword:  getEnv("DB_PASSWORD", ""),
-		DBName:      getEnv("DB_NAME", "data"),
-		EnableTLS:   getEnvAsBool("ENABLE_TLS", false),
-		CertFile:    getEnv("TLS_CERT_FILE", ""),
-		KeyFile:     getEnv("TLS_KEY_FILE", ""),
+		Host: getEnv("HOST", "127.0.0.1"),
+		Port: getEnvAsInt("PORT", 7777),
+
+		PublicEndpoints: getEnv("PUBLIC_ENDPOINTS", ""),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+		StorageType:    getEnv("STORAGE_TYPE", "csv"),
+		StoragePath:    getEnv("STORAGE_PATH", "./data"),
+		CSVLayout:      getEnv("CSV_LAYOUT", "json"),
+
+		DBHost:          getEnv("DB_HOST", "localhost"),
+		DBPort:          getEnvAsInt("DB_PORT", 3306),
+		DBUser:          getEnv("DB_USER", ""),
+		DBPassword:      getEnv("DB_PASSWORD", ""),
+		DBName:          getEnv("DB_NAME", "data"),
+		EnableTLS:       getEnvAsBool("ENABLE_TLS", false),
+		CertFile:        getEnv("TLS_CERT_FILE", ""),
+		KeyFile:         getEnv("TLS_KEY_FILE", ""),
+		PermissionCheck: getEnv("PERMISSION_CHECK", fsperm.ModeWarn),
+		BcryptCost:      getEnvAsInt("BCRYPT_COST", 12),
+		RehashOnUse:     getEnvAsBool("REHASH_ON_USE", false),
+		AdminAPIKey:     getEnv("ADMIN_API_KEY", ""),
+
+		DBConnMaxIdleTime: getEnvAsDuration("DB_CONN_MAX_IDLE_TIME", time.Minute),
+
+		AuthBootstrap: getEnvAsBool("AUTH_BOOTSTRAP", false),
+
+		SessionTokensEnabled: getEnvAsBool("SESSION_TOKENS_ENABLED", false),
+		SessionTokenSecret:   getEnv("SESSION_TOKEN_SECRET", ""),
+		SessionTokenTTL:      getEnvAsDuration("SESSION_TOKEN_TTL", 15*time.Minute),
+
+		DownloadURLsEnabled: getEnvAsBool("DOWNLOAD_URLS_ENABLED", false),
+		DownloadURLSecret:   getEnv("DOWNLOAD_URL_SECRET", ""),
+		DownloadURLMaxTTL:   getEnvAsDuration("DOWNLOAD_URL_MAX_TTL", time.Hour),
+
+		ReplayProtection: getEnvAsBool("REPLAY_PROTECTION_ENABLED", false),
+		ReplayWindow:     getEnvAsDuration("REPLAY_WINDOW", 5*time.Minute),
+
+		AuthLockoutThreshold:   getEnvAsInt("AUTH_LOCKOUT_THRESHOLD", 0),
+		AuthLockoutWindow:      getEnvAsDuration("AUTH_LOCKOUT_WINDOW", 5*time.Minute),
+		AuthLockoutDuration:    getEnvAsDuration("AUTH_LOCKOUT_DURATION", time.Minute),
+		AuthLockoutMaxDuration: getEnvAsDuration("AUTH_LOCKOUT_MAX_DURATION", time.Hour),
+		AuthLockoutMaxTracked:  getEnvAsInt("AUTH_LOCKOUT_MAX_TRACKED", 100000),
+
+		AuthGracePeriod:         getEnvAsDuration("AUTH_GRACE_PERIOD", 0),
+		AuthGraceReloadFailures: getEnvAsInt("AUTH_GRACE_RELOAD_FAILURES", 3),
+		AuthGraceReadOnly:       getEnvAsBool("AUTH_GRACE_READ_ONLY", true),
+
+		BillingEnabled:   getEnvAsBool("BILLING_ENABLED", false),
+		BillingUsageFile: getEnv("BILLING_USAGE_FILE", "./data/usage.json"),
+
+		SelfTestEnabled:       getEnvAsBool("SELFTEST_ENABLED", false),
+		SelfTestRunsPerMinute: getEnvAsInt("SELFTEST_RUNS_PER_MINUTE", 6),
+
+		PolicyOPAURL:       getEnv("POLICY_OPA_URL", ""),
+		PolicyDecisionPath: getEnv("POLICY_DECISION_PATH", "tfbackend/authz"),
+		PolicyDir:          getEnv("POLICY_DIR", ""),
+		PolicyTimeout:      getEnvAsDuration("POLICY_TIMEOUT", 2*time.Second),
+		PolicyFailOpen:     getEnvAsBool("POLICY_FAIL_OPEN", false),
+
+		MetricsEnabled: getEnvAsBool("METRICS_ENABLED", false),
+		MetricsAddress: getEnv("METRICS_ADDRESS", ""),
+
+		CSVDelimiter: getEnv("CSV_DELIMITER", "comma"),
+		CSVQuote:     getEnv("CSV_QUOTE", "minimal"),
+		CSVBOM:       getEnvAsBool("CSV_BOM", false),
+
+		CSVEncryptionKeyFile: getEnv("CSV_ENCRYPTION_KEY_FILE", ""),
+
+		WriteDurability:   getEnv("WRITE_DURABILITY", "flush"),
+		WriteSyncInterval: getEnvAsDuration("WRITE_SYNC_INTERVAL", time.Second),
+
+		MaxStateSize: getEnvAsInt64("STATE_MAX_SIZE", 10<<20),
+		StateLockTTL: getEnvAsDuration("STATE_LOCK_TTL", 0),
+
+		StateWriteQueue:        getEnvAsInt("STATE_WRITE_QUEUE", 4),
+		StateWriteQueueTimeout: getEnvAsDuration("STATE_WRITE_QUEUE_TIMEOUT", 10*time.Second),
+
+		SnapshotFile:     getEnv("MEMORY_SNAPSHOT_FILE", ""),
+		SnapshotInterval: getEnvAsDuration("MEMORY_SNAPSHOT_INTERVAL", 30*time.Second),
+
+		ProbeInterval:         getEnvAsDuration("STORAGE_PROBE_INTERVAL", 30*time.Second),
+		ProbeTimeout:          getEnvAsDuration("STORAGE_PROBE_TIMEOUT", 5*time.Second),
+		ProbeFailureThreshold: getEnvAsInt("STORAGE_PROBE_FAILURE_THRESHOLD", 3),
+		ProbeLatencyThreshold: getEnvAsDuration("STORAGE_PROBE_LATENCY_THRESHOLD", time.Second),
+
+		MaxInFlight:    getEnvAsInt("MAX_IN_FLIGHT", 100),
+		MaxQueue:       getEnvAsInt("MAX_QUEUE", 100),
+		QueueTimeout:   getEnvAsDuration("QUEUE_TIMEOUT", 10*time.Second),
+		ShedInFlight:   getEnvAsInt("SHED_IN_FLIGHT", 80),
+		ShedQueueDepth: getEnvAsInt("SHED_QUEUE_DEPTH", 1),
+		ShedRetryAfter: getEnvAsDuration("SHED_RETRY_AFTER", 5*time.Second),
+
+		TimeoutDefault: getEnvAsDuration("TIMEOUT_DEFAULT", 60*time.Second),
+		TimeoutState:   getEnvAsDuration("TIMEOUT_STATE", 60*time.Second),
+		TimeoutUpload:  getEnvAsDuration("TIMEOUT_UPLOAD", 60*time.Second),
+		TimeoutExport:  getEnvAsDuration("TIMEOUT_EXPORT", 5*time.Minute),
+		TimeoutAdmin:   getEnvAsDuration("TIMEOUT_ADMIN", 60*time.Second),
+
+		QuotaWarningsEnabled: getEnvAsBool("QUOTA_WARNINGS_ENABLED", false),
+		QuotaStorageLimit:    getEnvAsInt64("QUOTA_STORAGE_SOFT_LIMIT", 0),
+		QuotaWarnRatio:       getEnvAsFloat("QUOTA_WARN_RATIO", 0.8),
+		QuotaNotifyCooldown:  getEnvAsDuration("QUOTA_NOTIFY_COOLDOWN", time.Hour),
+
+		QuotaDailyIngestBytes: getEnvAsInt64("QUOTA_DAILY_INGEST_BYTES", 0),
+		QuotaDailyIngestRows:  getEnvAsInt64("QUOTA_DAILY_INGEST_ROWS", 0),
+		QuotaIngestFile:       getEnv("QUOTA_INGEST_FILE", "./data/ingest_quota.json"),
+
+		NotifySlackWebhookURL: getEnv("NOTIFY_SLACK_WEBHOOK_URL", ""),
+		NotifyWebhookURL:      getEnv("NOTIFY_WEBHOOK_URL", ""),
+		NotifySMTPHost:        getEnv("NOTIFY_SMTP_HOST", ""),
+		NotifySMTPPort:        getEnvAsInt("NOTIFY_SMTP_PORT", 587),
+		NotifySMTPUsername:    getEnv("NOTIFY_SMTP_USERNAME", ""),
+		NotifySMTPPassword:    getEnv("NOTIFY_SMTP_PASSWORD", ""),
+		NotifySMTPFrom:        getEnv("NOTIFY_SMTP_FROM", ""),
+		NotifySMTPTo:          getEnv("NOTIFY_SMTP_TO", ""),
+		NotifyRoutes: map[string]string{
+			"quota_warning":  getEnv("NOTIFY_QUOTA_WARNING", ""),
+			"reload_failure": getEnv("NOTIFY_RELOAD_FAILURE", ""),
+			"backup_result":  getEnv("NOTIFY_BACKUP_RESULT", ""),
+			"stale_lock":     getEnv("NOTIFY_STALE_LOCK", ""),
+			"watcher_health": getEnv("NOTIFY_WATCHER_HEALTH", ""),
+		},
+
+		MaxClockSkew:      getEnvAsDuration("UPLOAD_MAX_CLOCK_SKEW", 5*time.Minute),
+		MaxObservationAge: getEnvAsDuration("UPLOAD_MAX_OBSERVATION_AGE", 7*24*time.Hour),
+		TaxonomyFile:      getEnv("UPLOAD_TAXONOMY_FILE", "./data/taxonomy.json"),
+		NormalizationFile: getEnv("UPLOAD_NORMALIZATION_FILE", "./data/normalization.json"),
+		UploadHooksFile:   getEnv("UPLOAD_HOOKS_FILE", ""),
+		UploadHookTimeout: getEnvAsDuration("UPLOAD_HOOK_TIMEOUT", 5*time.Second),
+
+		ChangeLogEnabled:   getEnvAsBool("CHANGE_LOG_ENABLED", false),
+		ChangeLogFile:      getEnv("CHANGE_LOG_FILE", "./data/changes.jsonl"),
+		ChangeLogRetention: getEnvAsInt("CHANGE_LOG_RETENTION", 100000),
+
+		AuditEnabled:   getEnvAsBool("AUDIT_ENABLED", false),
+		AuditDir:       getEnv("AUDIT_DIR", "./data/audit"),
+		AuditRetention: getEnvAsDuration("AUDIT_RETENTION", 90*24*time.Hour),
+		AuditDropDir:   getEnv("AUDIT_DROP_DIR", ""),
+
+		FullSyncMaxConcurrent: getEnvAsInt("FULL_SYNC_MAX_CONCURRENT", 4),
+		FullSyncChunkInterval: getEnvAsDuration("FULL_SYNC_CHUNK_INTERVAL", 100*time.Millisecond),
+
+		GeoIPDatabase:       getEnv("GEOIP_DATABASE", ""),
+		GeoIPAllowCountries: getEnv("GEOIP_ALLOW_COUNTRIES", ""),
+		GeoIPDenyCountries:  getEnv("GEOIP_DENY_COUNTRIES", ""),
+		GeoIPAllowUnknown:   getEnvAsBool("GEOIP_ALLOW_UNKNOWN", true),
+		GeoIPListsFile:      getEnv("GEOIP_LISTS_FILE", "./data/geoip.json"),
+
+		LogLevel:  getEnv("LOG_LEVEL", "info"),
+		LogFormat: getEnv("LOG_FORMAT", logging.FormatText),
+		LogFile:   getEnv("LOG_FILE", ""),
+
+		SecurityLogOutput:     getEnv("SECURITY_LOG_OUTPUT", security.OutputApp),
+		SecurityLogFormat:     getEnv("SECURITY_LOG_FORMAT", security.FormatJSON),
+		SecurityLogFile:       getEnv("SECURITY_LOG_FILE", "./logs/security.log"),
+		SecuritySyslogAddress: getEnv("SECURITY_SYSLOG_ADDRESS", ""),
+
+		LogRotateMaxSize:    getEnvAsInt64("LOG_ROTATE_MAX_SIZE", 100<<20),
+		LogRotateInterval:   getEnvAsDuration("LOG_ROTATE_INTERVAL", 24*time.Hour),
+		LogRotateMaxBackups: getEnvAsInt("LOG_ROTATE_MAX_BACKUPS", 7),
+		LogRotateMaxAge:     getEnvAsDuration("LOG_ROTATE_MAX_AGE", 0),
+		LogRotateCompress:   getEnvAsBool("LOG_ROTATE_COMPRESS", true),
+
+		RecordingEnabled:    getEnvAsBool("RECORDING_ENABLED", false),
+		RecordingBufferSize: getEnvAsInt("RECORDING_BUFFER_SIZE", 200),
+		RecordingMaxBody:    getEnvAsInt("RECORDING_MAX_BODY", 4096),
+
+		RateLimitRequestsPerMinute: getEnvAsInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 60),
+		RateLimitBurst:             getEnvAsInt("RATE_LIMIT_BURST", 0),
+
+		RateLimitUploadRequestsPerMinute: getEnvAsInt("RATE_LIMIT_UPLOAD_REQUESTS_PER_MINUTE", 0),
+		RateLimitUploadBurst:             getEnvAsInt("RATE_LIMIT_UPLOAD_BURST", 0),
+		RateLimitStateRequestsPerMinute:  getEnvAsInt("RATE_LIMIT_STATE_REQUESTS_PER_MINUTE", 0),
+		RateLimitStateBurst:              getEnvAsInt("RATE_LIMIT_STATE_BURST", 0),
+		RateLimitIPRequestsPerMinute:     getEnvAsInt("RATE_LIMIT_IP_REQUESTS_PER_MINUTE", 600),
+		RateLimitIPBurst:                 getEnvAsInt("RATE_LIMIT_IP_BURST", 0),
+
+		RateLimitMaxBuckets:    getEnvAsInt("RATE_LIMIT_MAX_BUCKETS", 100000),
+		RateLimitOverridesFile: getEnv("RATE_LIMIT_OVERRIDES_FILE", "./data/ratelimit_overrides.json"),
+
+		RollupEnabled:   getEnvAsBool("ROLLUP_ENABLED", false),
+		RollupDir:       getEnv("ROLLUP_DIR", "./data/rollups"),
+		RollupInterval:  getEnvAsDuration("ROLLUP_INTERVAL", 15*time.Minute),
+		RollupSumFields: getEnv("ROLLUP_SUM_FIELDS", ""),
+
+		QueryCacheEnabled:  getEnvAsBool("QUERY_CACHE_ENABLED", false),
+		QueryCacheMaxBytes: getEnvAsInt64("QUERY_CACHE_MAX_BYTES", 64<<20),
+		QueryCacheTTL:      getEnvAsDuration("QUERY_CACHE_TTL", 5*time.Minute),
+
+		QuarantineEnabled:   getEnvAsBool("QUARANTINE_ENABLED", false),
+		QuarantineDir:       getEnv("QUARANTINE_DIR", "./data/quarantine"),
+		QuarantineMaxBytes:  getEnvAsInt64("QUARANTINE_MAX_BYTES", 256<<20),
+		QuarantineRetention: getEnvAsDuration("QUARANTINE_RETENTION", 30*24*time.Hour),
+
+		Deprecations: getEnv("DEPRECATIONS", ""),
+
+		Features: getEnv("FEATURES", ""),
+
+		OutboundProxy:    getEnv("OUTBOUND_PROXY", outbound.ProxyEnvironment),
+		OutboundNoProxy:  getEnv("OUTBOUND_NO_PROXY", ""),
+		OutboundCABundle: getEnv("OUTBOUND_CA_BUNDLE", ""),
+
+		FaultInjectionEnabled: getEnvAsBool("FAULT_INJECTION_ENABLED", false),
 	}
 
 	// Validate configuration
@@ -81,23 +554,248 @@ func LoadFromFile(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load config file %s: %w", absPath, err)
 	}
 
+	// Record the keys set in the file before defaults are filled in
+	keys := fileKeys(cfg)
+
 	// Parse server configuration
 	serverSection := cfg.Section("server")
 	config := &Config{
+		file:     absPath,
+		fileKeys: keys,
+
 		Host: serverSection.Key("hostname").MustString("127.0.0.1"),
 		Port: serverSection.Key("port").MustInt(7777),
+
+		TrustedProxies:  serverSection.Key("trusted_proxies").String(),
+		PublicEndpoints: serverSection.Key("public_endpoints").String(),
 	}
 
 	// Parse storage configuration
 	storageSection := cfg.Section("storage")
 	config.StorageType = storageSection.Key("type").MustString("csv")
 	config.StoragePath = storageSection.Key("path").MustString("./data")
+	config.CSVLayout = storageSection.Key("csv_layout").MustString("json")
+	config.CSVDelimiter = storageSection.Key("csv_delimiter").MustString("comma")
+	config.CSVQuote = storageSection.Key("csv_quote").MustString("minimal")
+	config.CSVBOM = storageSection.Key("csv_bom").MustBool(false)
+	config.CSVEncryptionKeyFile = storageSection.Key("csv_encryption_key_file").MustString("")
+	config.WriteDurability = storageSection.Key("write_durability").MustString("flush")
+	config.WriteSyncInterval = storageSection.Key("write_sync_interval").MustDuration(time.Second)
+	config.MaxStateSize = storageSection.Key("max_state_size").MustInt64(10 << 20)
+	config.StateLockTTL = storageSection.Key("state_lock_ttl").MustDuration(0)
+	config.StateWriteQueue = storageSection.Key("state_write_queue").MustInt(4)
+	config.StateWriteQueueTimeout = storageSection.Key("state_write_queue_timeout").MustDuration(10 * time.Second)
+	config.SnapshotFile = storageSection.Key("snapshot_file").MustString("")
+	config.SnapshotInterval = storageSection.Key("snapshot_interval").MustDuration(30 * time.Second)
+	config.ProbeInterval = storageSection.Key("probe_interval").MustDuration(30 * time.Second)
+	config.ProbeTimeout = storageSection.Key("probe_timeout").MustDuration(5 * time.Second)
+	config.ProbeFailureThreshold = storageSection.Key("probe_failure_threshold").MustInt(3)
+	config.ProbeLatencyThreshold = storageSection.Key("probe_latency_threshold").MustDuration(time.Second)
 
 	// Parse security configuration
 	securitySection := cfg.Section("security")
 	config.EnableTLS = securitySection.Key("enable_tls").MustBool(false)
 	config.CertFile = securitySection.Key("cert_file").String()
 	config.KeyFile = securitySection.Key("key_file").String()
+	config.PermissionCheck = securitySection.Key("permission_check").MustString(fsperm.ModeWarn)
+	config.BcryptCost = securitySection.Key("bcrypt_cost").MustInt(12)
+	config.RehashOnUse = securitySection.Key("rehash_on_use").MustBool(false)
+	config.SessionTokensEnabled = securitySection.Key("session_tokens").MustBool(false)
+	config.SessionTokenSecret = securitySection.Key("session_token_secret").String()
+	config.SessionTokenTTL = securitySection.Key("session_token_ttl").MustDuration(15 * time.Minute)
+	config.DownloadURLsEnabled = securitySection.Key("download_urls").MustBool(false)
+	config.DownloadURLSecret = securitySection.Key("download_url_secret").String()
+	config.DownloadURLMaxTTL = securitySection.Key("download_url_max_ttl").MustDuration(time.Hour)
+	config.ReplayProtection = securitySection.Key("replay_protection").MustBool(false)
+	config.ReplayWindow = securitySection.Key("replay_window").MustDuration(5 * time.Minute)
+	config.AuthLockoutThreshold = securitySection.Key("auth_lockout_threshold").MustInt(0)
+	config.AuthLockoutWindow = securitySection.Key("auth_lockout_window").MustDuration(5 * time.Minute)
+	config.AuthLockoutDuration = securitySection.Key("auth_lockout_duration").MustDuration(time.Minute)
+	config.AuthLockoutMaxDuration = securitySection.Key("auth_lockout_max_duration").MustDuration(time.Hour)
+	config.AuthLockoutMaxTracked = securitySection.Key("auth_lockout_max_tracked").MustInt(100000)
+	config.AuthGracePeriod = securitySection.Key("auth_grace_period").MustDuration(0)
+	config.AuthGraceReloadFailures = securitySection.Key("auth_grace_reload_failures").MustInt(3)
+	config.AuthGraceReadOnly = securitySection.Key("auth_grace_read_only").MustBool(true)
+
+	// Parse admin configuration
+	adminSection := cfg.Section("admin")
+	config.AdminAPIKey = adminSection.Key("api_key").String()
+	config.AuthBootstrap = adminSection.Key("auth_bootstrap").MustBool(false)
+
+	// Parse billing configuration
+	billingSection := cfg.Section("billing")
+	config.BillingEnabled = billingSection.Key("enabled").MustBool(false)
+	config.BillingUsageFile = billingSection.Key("usage_file").MustString("./data/usage.json")
+
+	// Parse self-test configuration
+	selfTestSection := cfg.Section("selftest")
+	config.SelfTestEnabled = selfTestSection.Key("enabled").MustBool(false)
+	config.SelfTestRunsPerMinute = selfTestSection.Key("runs_per_minute").MustInt(6)
+
+	// Parse authorization policy configuration
+	policySection := cfg.Section("policy")
+	config.PolicyOPAURL = policySection.Key("opa_url").MustString("")
+	config.PolicyDecisionPath = policySection.Key("decision_path").MustString("tfbackend/authz")
+	config.PolicyDir = policySection.Key("policy_dir").MustString("")
+	config.PolicyTimeout = policySection.Key("timeout").MustDuration(2 * time.Second)
+	config.PolicyFailOpen = policySection.Key("fail_open").MustBool(false)
+
+	// Parse metrics configuration
+	metricsSection := cfg.Section("metrics")
+	config.MetricsEnabled = metricsSection.Key("enabled").MustBool(false)
+	config.MetricsAddress = metricsSection.Key("address").MustString("")
+
+	// Parse load shedding configuration
+	loadShedSection := cfg.Section("loadshed")
+	config.MaxInFlight = loadShedSection.Key("max_in_flight").MustInt(100)
+	config.MaxQueue = loadShedSection.Key("max_queue").MustInt(100)
+	config.QueueTimeout = loadShedSection.Key("queue_timeout").MustDuration(10 * time.Second)
+	config.ShedInFlight = loadShedSection.Key("shed_in_flight").MustInt(80)
+	config.ShedQueueDepth = loadShedSection.Key("shed_queue_depth").MustInt(1)
+	config.ShedRetryAfter = loadShedSection.Key("retry_after").MustDuration(5 * time.Second)
+
+	// Parse timeout configuration
+	timeoutsSection := cfg.Section("timeouts")
+	config.TimeoutDefault = timeoutsSection.Key("default").MustDuration(60 * time.Second)
+	config.TimeoutState = timeoutsSection.Key("state").MustDuration(60 * time.Second)
+	config.TimeoutUpload = timeoutsSection.Key("upload").MustDuration(60 * time.Second)
+	config.TimeoutExport = timeoutsSection.Key("export").MustDuration(5 * time.Minute)
+	config.TimeoutAdmin = timeoutsSection.Key("admin").MustDuration(60 * time.Second)
+
+	// Parse quota configuration
+	quotaSection := cfg.Section("quota")
+	config.QuotaWarningsEnabled = quotaSection.Key("warnings_enabled").MustBool(false)
+	config.QuotaStorageLimit = quotaSection.Key("storage_soft_limit").MustInt64(0)
+	config.QuotaWarnRatio = quotaSection.Key("warn_ratio").MustFloat64(0.8)
+	config.QuotaNotifyCooldown = quotaSection.Key("notify_cooldown").MustDuration(time.Hour)
+	config.QuotaDailyIngestBytes = quotaSection.Key("daily_ingest_bytes").MustInt64(0)
+	config.QuotaDailyIngestRows = quotaSection.Key("daily_ingest_rows").MustInt64(0)
+	config.QuotaIngestFile = quotaSection.Key("ingest_file").MustString("./data/ingest_quota.json")
+
+	// Parse notification configuration
+	notifySection := cfg.Section("notify")
+	config.NotifySlackWebhookURL = notifySection.Key("slack_webhook_url").String()
+	config.NotifyWebhookURL = notifySection.Key("webhook_url").String()
+	config.NotifySMTPHost = notifySection.Key("smtp_host").String()
+	config.NotifySMTPPort = notifySection.Key("smtp_port").MustInt(587)
+	config.NotifySMTPUsername = notifySection.Key("smtp_username").String()
+	config.NotifySMTPPassword = notifySection.Key("smtp_password").String()
+	config.NotifySMTPFrom = notifySection.Key("smtp_from").String()
+	config.NotifySMTPTo = notifySection.Key("smtp_to").String()
+	config.NotifyRoutes = make(map[string]string)
+	for _, eventType := range []string{"quota_warning", "reload_failure", "backup_result", "stale_lock", "watcher_health"} {
+		config.NotifyRoutes[eventType] = notifySection.Key(eventType).String()
+	}
+
+	// Parse upload configuration
+	uploadSection := cfg.Section("upload")
+	config.MaxClockSkew = uploadSection.Key("max_clock_skew").MustDuration(5 * time.Minute)
+	config.MaxObservationAge = uploadSection.Key("max_observation_age").MustDuration(7 * 24 * time.Hour)
+	config.TaxonomyFile = uploadSection.Key("taxonomy_file").MustString("./data/taxonomy.json")
+	config.NormalizationFile = uploadSection.Key("normalization_file").MustString("./data/normalization.json")
+	config.UploadHooksFile = uploadSection.Key("hooks_file").MustString("")
+	config.UploadHookTimeout = uploadSection.Key("hook_timeout").MustDuration(5 * time.Second)
+
+	// Parse change feed configuration
+	changesSection := cfg.Section("changes")
+	config.ChangeLogEnabled = changesSection.Key("enabled").MustBool(false)
+	config.ChangeLogFile = changesSection.Key("file").MustString("./data/changes.jsonl")
+	config.ChangeLogRetention = changesSection.Key("retention").MustInt(100000)
+
+	// Parse audit configuration
+	auditSection := cfg.Section("audit")
+	config.AuditEnabled = auditSection.Key("enabled").MustBool(false)
+	config.AuditDir = auditSection.Key("dir").MustString("./data/audit")
+	config.AuditRetention = auditSection.Key("retention").MustDuration(90 * 24 * time.Hour)
+	config.AuditDropDir = auditSection.Key("drop_dir").MustString("")
+
+	// Parse full sync configuration
+	syncSection := cfg.Section("sync")
+	config.FullSyncMaxConcurrent = syncSection.Key("max_concurrent").MustInt(4)
+	config.FullSyncChunkInterval = syncSection.Key("chunk_interval").MustDuration(100 * time.Millisecond)
+
+	// Parse GeoIP configuration
+	geoipSection := cfg.Section("geoip")
+	config.GeoIPDatabase = geoipSection.Key("database").String()
+	config.GeoIPAllowCountries = geoipSection.Key("allow_countries").String()
+	config.GeoIPDenyCountries = geoipSection.Key("deny_countries").String()
+	config.GeoIPAllowUnknown = geoipSection.Key("allow_unknown").MustBool(true)
+	config.GeoIPListsFile = geoipSection.Key("lists_file").MustString("./data/geoip.json")
+
+	// Parse application log configuration
+	loggingSection := cfg.Section("logging")
+	config.LogLevel = loggingSection.Key("level").MustString("info")
+	config.LogFormat = loggingSection.Key("format").MustString(logging.FormatText)
+	config.LogFile = loggingSection.Key("file").String()
+
+	// Parse security event stream configuration
+	securityLogSection := cfg.Section("security_log")
+	config.SecurityLogOutput = securityLogSection.Key("output").MustString(security.OutputApp)
+	config.SecurityLogFormat = securityLogSection.Key("format").MustString(security.FormatJSON)
+	config.SecurityLogFile = securityLogSection.Key("file").MustString("./logs/security.log")
+	config.SecuritySyslogAddress = securityLogSection.Key("syslog_address").String()
+
+	// Parse log rotation configuration
+	logRotationSection := cfg.Section("log_rotation")
+	config.LogRotateMaxSize = logRotationSection.Key("max_size").MustInt64(100 << 20)
+	config.LogRotateInterval = logRotationSection.Key("interval").MustDuration(24 * time.Hour)
+	config.LogRotateMaxBackups = logRotationSection.Key("max_backups").MustInt(7)
+	config.LogRotateMaxAge = logRotationSection.Key("max_age").MustDuration(0)
+	config.LogRotateCompress = logRotationSection.Key("compress").MustBool(true)
+
+	// Parse request recording configuration
+	recordingSection := cfg.Section("recording")
+	config.RecordingEnabled = recordingSection.Key("enabled").MustBool(false)
+	config.RecordingBufferSize = recordingSection.Key("buffer_size").MustInt(200)
+	config.RecordingMaxBody = recordingSection.Key("max_body").MustInt(4096)
+
+	// Parse rate limit configuration
+	rateLimitSection := cfg.Section("rate_limit")
+	config.RateLimitRequestsPerMinute = rateLimitSection.Key("requests_per_minute").MustInt(60)
+	config.RateLimitBurst = rateLimitSection.Key("burst").MustInt(0)
+	config.RateLimitUploadRequestsPerMinute = rateLimitSection.Key("upload_requests_per_minute").MustInt(0)
+	config.RateLimitUploadBurst = rateLimitSection.Key("upload_burst").MustInt(0)
+	config.RateLimitStateRequestsPerMinute = rateLimitSection.Key("state_requests_per_minute").MustInt(0)
+	config.RateLimitStateBurst = rateLimitSection.Key("state_burst").MustInt(0)
+	config.RateLimitIPRequestsPerMinute = rateLimitSection.Key("ip_requests_per_minute").MustInt(600)
+	config.RateLimitIPBurst = rateLimitSection.Key("ip_burst").MustInt(0)
+	config.RateLimitMaxBuckets = rateLimitSection.Key("max_buckets").MustInt(100000)
+	config.RateLimitOverridesFile = rateLimitSection.Key("overrides_file").MustString("./data/ratelimit_overrides.json")
+
+	// Parse rollup configuration
+	rollupSection := cfg.Section("rollup")
+	config.RollupEnabled = rollupSection.Key("enabled").MustBool(false)
+	config.RollupDir = rollupSection.Key("dir").MustString("./data/rollups")
+	config.RollupInterval = rollupSection.Key("interval").MustDuration(15 * time.Minute)
+	config.RollupSumFields = rollupSection.Key("sum_fields").String()
+
+	// Parse query cache configuration
+	queryCacheSection := cfg.Section("query_cache")
+	config.QueryCacheEnabled = queryCacheSection.Key("enabled").MustBool(false)
+	config.QueryCacheMaxBytes = queryCacheSection.Key("max_bytes").MustInt64(64 << 20)
+	config.QueryCacheTTL = queryCacheSection.Key("ttl").MustDuration(5 * time.Minute)
+
+	// Parse quarantine configuration
+	quarantineSection := cfg.Section("quarantine")
+	config.QuarantineEnabled = quarantineSection.Key("enabled").MustBool(false)
+	config.QuarantineDir = quarantineSection.Key("dir").MustString("./data/quarantine")
+	config.QuarantineMaxBytes = quarantineSection.Key("max_bytes").MustInt64(256 << 20)
+	config.QuarantineRetention = quarantineSection.Key("retention").MustDuration(30 * 24 * time.Hour)
+
+	// Parse deprecation configuration
+	config.Deprecations = cfg.Section("deprecation").Key("rules").String()
+
+	// Parse feature flag configuration
+	config.Features = cfg.Section("features").Key("flags").String()
+
+	// Parse outbound HTTP configuration
+	outboundSection := cfg.Section("outbound")
+	config.OutboundProxy = outboundSection.Key("proxy").MustString(outbound.ProxyEnvironment)
+	config.OutboundNoProxy = outboundSection.Key("no_proxy").String()
+	config.OutboundCABundle = outboundSection.Key("ca_bundle").String()
+
+	// Parse fault injection configuration
+	config.FaultInjectionEnabled = cfg.Section("faults").Key("enabled").MustBool(false)
 
 	// Validate configuration
 	if err := config.Validate(); err != nil {
@@ -122,6 +820,315 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.MetricsAddress != "" {
+		if !c.MetricsEnabled {
+			return fmt.Errorf("invalid metrics address: %s (metrics are not enabled)", c.MetricsAddress)
+		}
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return fmt.Errorf("invalid metrics address: %s (must be host:port)", c.MetricsAddress)
+		}
+		if c.MetricsAddress == c.Address() {
+			return fmt.Errorf("invalid metrics address: %s (must differ from the API address)", c.MetricsAddress)
+		}
+	}
+
+	if c.BcryptCost < 4 || c.BcryptCost > 31 {
+		return fmt.Errorf("invalid bcrypt cost: %d (must be between 4 and 31)", c.BcryptCost)
+	}
+
+	if c.ProbeInterval < 0 || c.ProbeLatencyThreshold < 0 {
+		return fmt.Errorf("invalid storage probe interval or latency threshold (must not be negative)")
+	}
+
+	if c.ProbeTimeout <= 0 {
+		return fmt.Errorf("invalid storage probe timeout: %v (must be positive)", c.ProbeTimeout)
+	}
+
+	if c.ProbeFailureThreshold < 1 {
+		return fmt.Errorf("invalid storage probe failure threshold: %d (must be at least 1)", c.ProbeFailureThreshold)
+	}
+
+	if c.DBConnMaxIdleTime < 0 {
+		return fmt.Errorf("invalid database connection max idle time: %v (must not be negative)", c.DBConnMaxIdleTime)
+	}
+
+	if c.MaxStateSize < 1 {
+		return fmt.Errorf("invalid max state size: %d (must be positive)", c.MaxStateSize)
+	}
+	if c.StateLockTTL < 0 {
+		return fmt.Errorf("invalid state lock TTL: %v (must not be negative)", c.StateLockTTL)
+	}
+	if c.StateWriteQueue < 0 {
+		return fmt.Errorf("invalid state write queue: %d (must not be negative)", c.StateWriteQueue)
+	}
+	if c.StateWriteQueueTimeout <= 0 {
+		return fmt.Errorf("invalid state write queue timeout: %v (must be positive)", c.StateWriteQueueTimeout)
+	}
+
+	if c.SessionTokensEnabled {
+		if c.SessionTokenTTL <= 0 {
+			return fmt.Errorf("invalid session token TTL: %v (must be positive)", c.SessionTokenTTL)
+		}
+		if c.SessionTokenSecret != "" && len(c.SessionTokenSecret) < 32 {
+			return fmt.Errorf("session token secret too short: %d bytes (minimum 32)", len(c.SessionTokenSecret))
+		}
+	}
+
+	if c.DownloadURLsEnabled {
+		if c.DownloadURLMaxTTL <= 0 {
+			return fmt.Errorf("invalid download URL max TTL: %v (must be positive)", c.DownloadURLMaxTTL)
+		}
+		if c.DownloadURLSecret != "" && len(c.DownloadURLSecret) < 32 {
+			return fmt.Errorf("download URL secret too short: %d bytes (minimum 32)", len(c.DownloadURLSecret))
+		}
+	}
+
+	if c.AuthBootstrap && c.AdminAPIKey == "" {
+		return fmt.Errorf("auth bootstrap enabled but ADMIN_API_KEY not set (organizations could not be provisioned)")
+	}
+
+	if c.ReplayProtection && c.ReplayWindow <= 0 {
+		return fmt.Errorf("invalid replay window: %v (must be positive)", c.ReplayWindow)
+	}
+
+	if c.AuthLockoutThreshold < 0 {
+		return fmt.Errorf("invalid auth lockout threshold: %d (must not be negative)", c.AuthLockoutThreshold)
+	}
+	if c.AuthLockoutThreshold > 0 {
+		if c.AuthLockoutWindow <= 0 {
+			return fmt.Errorf("invalid auth lockout window: %v (must be positive)", c.AuthLockoutWindow)
+		}
+		if c.AuthLockoutDuration <= 0 {
+			return fmt.Errorf("invalid auth lockout duration: %v (must be positive)", c.AuthLockoutDuration)
+		}
+		if c.AuthLockoutMaxDuration < c.AuthLockoutDuration {
+			return fmt.Errorf("invalid auth lockout max duration: %v (must be at least the lockout duration %v)", c.AuthLockoutMaxDuration, c.AuthLockoutDuration)
+		}
+		if c.AuthLockoutMaxTracked < 1 {
+			return fmt.Errorf("invalid auth lockout max tracked: %d (must be at least 1)", c.AuthLockoutMaxTracked)
+		}
+	}
+
+	if c.AuthGracePeriod < 0 {
+		return fmt.Errorf("invalid auth grace period: %v (must not be negative)", c.AuthGracePeriod)
+	}
+	if c.AuthGracePeriod > 0 && c.AuthGraceReloadFailures < 1 {
+		return fmt.Errorf("invalid auth grace reload failures: %d (must be at least 1)", c.AuthGraceReloadFailures)
+	}
+
+	if c.PolicyOPAURL != "" && c.PolicyTimeout <= 0 {
+		return fmt.Errorf("invalid policy timeout: %v (must be positive)", c.PolicyTimeout)
+	}
+
+	if c.SnapshotFile != "" && c.SnapshotInterval <= 0 {
+		return fmt.Errorf("invalid memory snapshot interval: %v (must be positive)", c.SnapshotInterval)
+	}
+
+	if _, err := csvfmt.Parse(c.CSVDelimiter, c.CSVQuote, c.CSVBOM); err != nil {
+		return fmt.Errorf("invalid CSV format: %w", err)
+	}
+
+	if c.CSVEncryptionKeyFile != "" && c.StorageType != "csv" && c.StorageType != "dual" {
+		return fmt.Errorf("CSV encryption requires csv or dual storage, not %s", c.StorageType)
+	}
+
+	level, err := durability.ParseLevel(c.WriteDurability)
+	if err != nil {
+		return err
+	}
+	if level == durability.FsyncInterval && c.WriteSyncInterval <= 0 {
+		return fmt.Errorf("invalid write sync interval: %v (must be positive with fsync-interval)", c.WriteSyncInterval)
+	}
+
+	if c.MaxInFlight < 1 {
+		return fmt.Errorf("invalid max in-flight requests: %d (must be at least 1)", c.MaxInFlight)
+	}
+
+	if c.MaxQueue < 0 {
+		return fmt.Errorf("invalid max queue: %d (must not be negative)", c.MaxQueue)
+	}
+
+	for name, timeout := range map[string]time.Duration{
+		"default": c.TimeoutDefault,
+		"state":   c.TimeoutState,
+		"upload":  c.TimeoutUpload,
+		"export":  c.TimeoutExport,
+		"admin":   c.TimeoutAdmin,
+	} {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid %s timeout: %v (must be positive)", name, timeout)
+		}
+	}
+
+	if c.QuotaWarnRatio <= 0 || c.QuotaWarnRatio >= 1 {
+		return fmt.Errorf("invalid quota warn ratio: %v (must be between 0 and 1)", c.QuotaWarnRatio)
+	}
+
+	if c.QuotaStorageLimit < 0 {
+		return fmt.Errorf("invalid storage soft limit: %d (must not be negative)", c.QuotaStorageLimit)
+	}
+
+	if c.QuotaDailyIngestBytes < 0 || c.QuotaDailyIngestRows < 0 {
+		return fmt.Errorf("invalid daily ingest caps: %d bytes, %d rows (must not be negative)", c.QuotaDailyIngestBytes, c.QuotaDailyIngestRows)
+	}
+
+	if c.MaxClockSkew < 0 {
+		return fmt.Errorf("invalid max clock skew: %v (must not be negative)", c.MaxClockSkew)
+	}
+
+	if c.MaxObservationAge < 0 {
+		return fmt.Errorf("invalid max observation age: %v (must not be negative)", c.MaxObservationAge)
+	}
+
+	if c.UploadHooksFile != "" && c.UploadHookTimeout <= 0 {
+		return fmt.Errorf("invalid upload hook timeout: %v (must be positive)", c.UploadHookTimeout)
+	}
+
+	if c.ChangeLogEnabled && c.ChangeLogRetention < 1 {
+		return fmt.Errorf("invalid change log retention: %d (must be at least 1)", c.ChangeLogRetention)
+	}
+
+	if c.AuditEnabled && c.AuditDir == "" {
+		return fmt.Errorf("audit directory is required when the audit log is enabled")
+	}
+
+	if c.AuditRetention < 0 {
+		return fmt.Errorf("invalid audit retention: %v (must not be negative)", c.AuditRetention)
+	}
+
+	if c.FullSyncMaxConcurrent < 1 {
+		return fmt.Errorf("invalid max concurrent full syncs: %d (must be at least 1)", c.FullSyncMaxConcurrent)
+	}
+
+	if c.FullSyncChunkInterval <= 0 {
+		return fmt.Errorf("invalid full sync chunk interval: %v (must be positive)", c.FullSyncChunkInterval)
+	}
+
+	if _, err := middleware.ParseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+
+	publicEndpoints, err := public.Parse(c.PublicEndpoints)
+	if err != nil {
+		return err
+	}
+	if c.MetricsEnabled && c.MetricsAddress == "" && !publicEndpoints.Enabled(public.Metrics) {
+		return fmt.Errorf("metrics enabled but not served: set METRICS_ADDRESS or add metrics to PUBLIC_ENDPOINTS")
+	}
+
+	if c.GeoIPDatabase == "" && (c.GeoIPAllowCountries != "" || c.GeoIPDenyCountries != "") {
+		return fmt.Errorf("country lists set but GEOIP_DATABASE not set (countries could not be resolved)")
+	}
+	if _, err := geoip.ParseCountries(c.GeoIPAllowCountries); err != nil {
+		return fmt.Errorf("invalid allowed countries: %w", err)
+	}
+	if _, err := geoip.ParseCountries(c.GeoIPDenyCountries); err != nil {
+		return fmt.Errorf("invalid denied countries: %w", err)
+	}
+
+	switch c.PermissionCheck {
+	case fsperm.ModeEnforce, fsperm.ModeWarn, fsperm.ModeOff:
+	default:
+		return fmt.Errorf("invalid permission check: %s (must be enforce, warn or off)", c.PermissionCheck)
+	}
+
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogFormat != "" && c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJSON {
+		return fmt.Errorf("invalid log format: %s (must be text or json)", c.LogFormat)
+	}
+
+	switch c.SecurityLogOutput {
+	case security.OutputApp, security.OutputSyslog:
+	case security.OutputFile:
+		if c.SecurityLogFile == "" {
+			return fmt.Errorf("security log output is file but no security log file is set")
+		}
+	default:
+		return fmt.Errorf("invalid security log output: %s (must be app, file or syslog)", c.SecurityLogOutput)
+	}
+
+	if c.SecurityLogFormat != security.FormatJSON && c.SecurityLogFormat != security.FormatCEF {
+		return fmt.Errorf("invalid security log format: %s (must be json or cef)", c.SecurityLogFormat)
+	}
+
+	if c.LogRotateMaxSize < 0 || c.LogRotateInterval < 0 || c.LogRotateMaxBackups < 0 || c.LogRotateMaxAge < 0 {
+		return fmt.Errorf("log rotation limits must not be negative")
+	}
+
+	if c.RateLimitRequestsPerMinute < 1 {
+		return fmt.Errorf("rate limit requests per minute must be at least 1")
+	}
+	if c.RateLimitBurst < 0 || c.RateLimitUploadBurst < 0 || c.RateLimitStateBurst < 0 || c.RateLimitIPBurst < 0 {
+		return fmt.Errorf("rate limit burst must not be negative")
+	}
+	if c.RateLimitUploadRequestsPerMinute < 0 || c.RateLimitStateRequestsPerMinute < 0 || c.RateLimitIPRequestsPerMinute < 0 {
+		return fmt.Errorf("rate limit requests per minute must not be negative")
+	}
+
+	if c.SelfTestEnabled && c.SelfTestRunsPerMinute < 1 {
+		return fmt.Errorf("self-test runs per minute must be at least 1")
+	}
+
+	if c.RateLimitMaxBuckets < 1 {
+		return fmt.Errorf("rate limit max buckets must be at least 1")
+	}
+
+	if c.RollupEnabled {
+		if c.RollupInterval <= 0 {
+			return fmt.Errorf("rollup interval must be positive")
+		}
+		if _, err := storage.ParseFields(c.RollupSumFields); err != nil {
+			return fmt.Errorf("invalid rollup sum fields: %w", err)
+		}
+	}
+
+	if c.QueryCacheEnabled {
+		if c.QueryCacheMaxBytes < 1 {
+			return fmt.Errorf("query cache max bytes must be at least 1")
+		}
+		if c.QueryCacheTTL < 0 {
+			return fmt.Errorf("query cache TTL must not be negative")
+		}
+	}
+
+	if c.QuarantineEnabled {
+		if c.QuarantineDir == "" {
+			return fmt.Errorf("quarantine directory is required when quarantine is enabled")
+		}
+		if c.QuarantineMaxBytes < 1 {
+			return fmt.Errorf("quarantine max bytes must be at least 1")
+		}
+		if c.QuarantineRetention < 0 {
+			return fmt.Errorf("quarantine retention must not be negative")
+		}
+	}
+
+	if _, err := deprecation.ParseRules(c.Deprecations); err != nil {
+		return fmt.Errorf("invalid deprecations: %w", err)
+	}
+
+	if _, err := features.Parse(c.Features); err != nil {
+		return err
+	}
+
+	if _, err := outbound.ParseProxy(c.OutboundProxy); err != nil {
+		return err
+	}
+
+	if c.RecordingEnabled {
+		if c.AdminAPIKey == "" {
+			return fmt.Errorf("request recording requires ADMIN_API_KEY to read recordings")
+		}
+		if c.RecordingBufferSize < 1 {
+			return fmt.Errorf("recording buffer size must be at least 1")
+		}
+		if c.RecordingMaxBody < 0 {
+			return fmt.Errorf("recording max body must not be negative")
+		}
+	}
+
 	return nil
 }
 
@@ -169,3 +1176,42 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return value
 }
+
+// getEnvAsDuration retrieves an environment variable as a duration or returns a default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsInt64 retrieves an environment variable as a 64-bit integer or returns a default value
+func getEnvAsInt64(key string, defaultValue int64) int64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsFloat retrieves an environment variable as a float or returns a default value
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+
+	"gopkg.in/ini.v1"
+)
+
+// Sources of a setting's effective value
+const (
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceFlag    = "flag"
+)
+
+// redacted replaces the values of secret settings
+const redacted = "[REDACTED]"
+
+// Setting is the effective value of a setting and where it came from
+type Setting struct {
+	Name   string `json:"name"`          // Config field, e.g. MaxStateSize, or -flag
+	Env    string `json:"env,omitempty"` // Environment variable
+	Key    string `json:"key,omitempty"` // section.key in the config file
+	Value  string `json:"value"`
+	Source string `json:"source"` // default, env, file or flag
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// setting maps a Config field to the environment variable and config file
+// key it is read from. Fields read from neither have empty names.
+type setting struct {
+	field  string
+	mapKey string // Entry of a map field
+	env    string
+	key    string
+	secret bool // Redacted in the effective configuration
+}
+
+// settings lists every Config field in declaration order; a test checks
+// that new fields are added here
+var settings = []setting{
+	{field: "Host", env: "HOST", key: "server.hostname"},
+	{field: "Port", env: "PORT", key: "server.port"},
+	{field: "TrustedProxies", env: "TRUSTED_PROXIES", key: "server.trusted_proxies"},
+	{field: "PublicEndpoints", env: "PUBLIC_ENDPOINTS", key: "server.public_endpoints"},
+	{field: "StorageType", env: "STORAGE_TYPE", key: "storage.type"},
+	{field: "StoragePath", env: "STORAGE_PATH", key: "storage.path"},
+	{field: "CSVLayout", env: "CSV_LAYOUT", key: "storage.csv_layout"},
+	{field: "MaxStateSize", env: "STATE_MAX_SIZE", key: "storage.max_state_size"},
+	{field: "StateLockTTL", env: "STATE_LOCK_TTL", key: "storage.state_lock_ttl"},
+	{field: "StateWriteQueue", env: "STATE_WRITE_QUEUE", key: "storage.state_write_queue"},
+	{field: "StateWriteQueueTimeout", env: "STATE_WRITE_QUEUE_TIMEOUT", key: "storage.state_write_queue_timeout"},
+	{field: "SnapshotFile", env: "MEMORY_SNAPSHOT_FILE", key: "storage.snapshot_file"},
+	{field: "SnapshotInterval", env: "MEMORY_SNAPSHOT_INTERVAL", key: "storage.snapshot_interval"},
+	{field: "CSVDelimiter", env: "CSV_DELIMITER", key: "storage.csv_delimiter"},
+	{field: "CSVQuote", env: "CSV_QUOTE", key: "storage.csv_quote"},
+	{field: "CSVBOM", env: "CSV_BOM", key: "storage.csv_bom"},
+	{field: "CSVEncryptionKeyFile", env: "CSV_ENCRYPTION_KEY_FILE", key: "storage.csv_encryption_key_file"},
+	{field: "WriteDurability", env: "WRITE_DURABILITY", key: "storage.write_durability"},
+	{field: "WriteSyncInterval", env: "WRITE_SYNC_INTERVAL", key: "storage.write_sync_interval"},
+	{field: "ProbeInterval", env: "STORAGE_PROBE_INTERVAL", key: "storage.probe_interval"},
+	{field: "ProbeTimeout", env: "STORAGE_PROBE_TIMEOUT", key: "storage.probe_timeout"},
+	{field: "ProbeFailureThreshold", env: "STORAGE_PROBE_FAILURE_THRESHOLD", key: "storage.probe_failure_threshold"},
+	{field: "ProbeLatencyThreshold", env: "STORAGE_PROBE_LATENCY_THRESHOLD", key: "storage.probe_latency_threshold"},
+	{field: "DBHost", env: "DB_HOST"},
+	{field: "DBPort", env: "DB_PORT"},
+	{field: "DBUser", env: "DB_USER"},
+	{field: "DBPassword", env: "DB_PASSWORD", secret: true},
+	{field: "DBName", env: "DB_NAME"},
+	{field: "DBConnMaxIdleTime", env: "DB_CONN_MAX_IDLE_TIME"},
+	{field: "EnableTLS", env: "ENABLE_TLS", key: "security.enable_tls"},
+	{field: "CertFile", env: "TLS_CERT_FILE", key: "security.cert_file"},
+	{field: "KeyFile", env: "TLS_KEY_FILE", key: "security.key_file"},
+	{field: "PermissionCheck", env: "PERMISSION_CHECK", key: "security.permission_check"},
+	{field: "BcryptCost", env: "BCRYPT_COST", key: "security.bcrypt_cost"},
+	{field: "RehashOnUse", env: "REHASH_ON_USE", key: "security.rehash_on_use"},
+	{field: "SessionTokensEnabled", env: "SESSION_TOKENS_ENABLED", key: "security.session_tokens"},
+	{field: "SessionTokenSecret", env: "SESSION_TOKEN_SECRET", key: "security.session_token_secret", secret: true},
+	{field: "SessionTokenTTL", env: "SESSION_TOKEN_TTL", key: "security.session_token_ttl"},
+	{field: "DownloadURLsEnabled", env: "DOWNLOAD_URLS_ENABLED", key: "security.download_urls"},
+	{field: "DownloadURLSecret", env: "DOWNLOAD_URL_SECRET", key: "security.download_url_secret", secret: true},
+	{field: "DownloadURLMaxTTL", env: "DOWNLOAD_URL_MAX_TTL", key: "security.download_url_max_ttl"},
+	{field: "ReplayProtection", env: "REPLAY_PROTECTION_ENABLED", key: "security.replay_protection"},
+	{field: "ReplayWindow", env: "REPLAY_WINDOW", key: "security.replay_window"},
+	{field: "AuthLockoutThreshold", env: "AUTH_LOCKOUT_THRESHOLD", key: "security.auth_lockout_threshold"},
+	{field: "AuthLockoutWindow", env: "AUTH_LOCKOUT_WINDOW", key: "security.auth_lockout_window"},
+	{field: "AuthLockoutDuration", env: "AUTH_LOCKOUT_DURATION", key: "security.auth_lockout_duration"},
+	{field: "AuthLockoutMaxDuration", env: "AUTH_LOCKOUT_MAX_DURATION", key: "security.auth_lockout_max_duration"},
+	{field: "AuthLockoutMaxTracked", env: "AUTH_LOCKOUT_MAX_TRACKED", key: "security.auth_lockout_max_tracked"},
+	{field: "AuthGracePeriod", env: "AUTH_GRACE_PERIOD", key: "security.auth_grace_period"},
+	{field: "AuthGraceReloadFailures", env: "AUTH_GRACE_RELOAD_FAILURES", key: "security.auth_grace_reload_failures"},
+	{field: "AuthGraceReadOnly", env: "AUTH_GRACE_READ_ONLY", key: "security.auth_grace_read_only"},
+	{field: "AdminAPIKey", env: "ADMIN_API_KEY", key: "admin.api_key", secret: true},
+	{field: "AuthBootstrap", env: "AUTH_BOOTSTRAP", key: "admin.auth_bootstrap"},
+	{field: "BillingEnabled", env: "BILLING_ENABLED", key: "billing.enabled"},
+	{field: "BillingUsageFile", env: "BILLING_USAGE_FILE", key: "billing.usage_file"},
+	{field: "SelfTestEnabled", env: "SELFTEST_ENABLED", key: "selftest.enabled"},
+	{field: "SelfTestRunsPerMinute", env: "SELFTEST_RUNS_PER_MINUTE", key: "selftest.runs_per_minute"},
+	{field: "PolicyOPAURL", env: "POLICY_OPA_URL", key: "policy.opa_url"},
+	{field: "PolicyDecisionPath", env: "POLICY_DECISION_PATH", key: "policy.decision_path"},
+	{field: "PolicyDir", env: "POLICY_DIR", key: "policy.policy_dir"},
+	{field: "PolicyTimeout", env: "POLICY_TIMEOUT", key: "policy.timeout"},
+	{field: "PolicyFailOpen", env: "POLICY_FAIL_OPEN", key: "policy.fail_open"},
+	{field: "MetricsEnabled", env: "METRICS_ENABLED", key: "metrics.enabled"},
+	{field: "MetricsAddress", env: "METRICS_ADDRESS", key: "metrics.address"},
+	{field: "MaxInFlight", env: "MAX_IN_FLIGHT", key: "loadshed.max_in_flight"},
+	{field: "MaxQueue", env: "MAX_QUEUE", key: "loadshed.max_queue"},
+	{field: "QueueTimeout", env: "QUEUE_TIMEOUT", key: "loadshed.queue_timeout"},
+	{field: "ShedInFlight", env: "SHED_IN_FLIGHT", key: "loadshed.shed_in_flight"},
+	{field: "ShedQueueDepth", env: "SHED_QUEUE_DEPTH", key: "loadshed.shed_queue_depth"},
+	{field: "ShedRetryAfter", env: "SHED_RETRY_AFTER", key: "loadshed.retry_after"},
+	{field: "TimeoutDefault", env: "TIMEOUT_DEFAULT", key: "timeouts.default"},
+	{field: "TimeoutState", env: "TIMEOUT_STATE", key: "timeouts.state"},
+	{field: "TimeoutUpload", env: "TIMEOUT_UPLOAD", key: "timeouts.upload"},
+	{field: "TimeoutExport", env: "TIMEOUT_EXPORT", key: "timeouts.export"},
+	{field: "TimeoutAdmin", env: "TIMEOUT_ADMIN", key: "timeouts.admin"},
+	{field: "QuotaWarningsEnabled", env: "QUOTA_WARNINGS_ENABLED", key: "quota.warnings_enabled"},
+	{field: "QuotaStorageLimit", env: "QUOTA_STORAGE_SOFT_LIMIT", key: "quota.storage_soft_limit"},
+	{field: "QuotaWarnRatio", env: "QUOTA_WARN_RATIO", key: "quota.warn_ratio"},
+	{field: "QuotaNotifyCooldown", env: "QUOTA_NOTIFY_COOLDOWN", key: "quota.notify_cooldown"},
+	{field: "QuotaDailyIngestBytes", env: "QUOTA_DAILY_INGEST_BYTES", key: "quota.daily_ingest_bytes"},
+	{field: "QuotaDailyIngestRows", env: "QUOTA_DAILY_INGEST_ROWS", key: "quota.daily_ingest_rows"},
+	{field: "QuotaIngestFile", env: "QUOTA_INGEST_FILE", key: "quota.ingest_file"},
+	{field: "NotifySlackWebhookURL", env: "NOTIFY_SLACK_WEBHOOK_URL", key: "notify.slack_webhook_url", secret: true},
+	{field: "NotifyWebhookURL", env: "NOTIFY_WEBHOOK_URL", key: "notify.webhook_url", secret: true},
+	{field: "NotifySMTPHost", env: "NOTIFY_SMTP_HOST", key: "notify.smtp_host"},
+	{field: "NotifySMTPPort", env: "NOTIFY_SMTP_PORT", key: "notify.smtp_port"},
+	{field: "NotifySMTPUsername", env: "NOTIFY_SMTP_USERNAME", key: "notify.smtp_username"},
+	{field: "NotifySMTPPassword", env: "NOTIFY_SMTP_PASSWORD", key: "notify.smtp_password", secret: true},
+	{field: "NotifySMTPFrom", env: "NOTIFY_SMTP_FROM", key: "notify.smtp_from"},
+	{field: "NotifySMTPTo", env: "NOTIFY_SMTP_TO", key: "notify.smtp_to"},
+	{field: "NotifyRoutes", mapKey: "quota_warning", env: "NOTIFY_QUOTA_WARNING", key: "notify.quota_warning"},
+	{field: "NotifyRoutes", mapKey: "reload_failure", env: "NOTIFY_RELOAD_FAILURE", key: "notify.reload_failure"},
+	{field: "NotifyRoutes", mapKey: "backup_result", env: "NOTIFY_BACKUP_RESULT", key: "notify.backup_result"},
+	{field: "NotifyRoutes", mapKey: "stale_lock", env: "NOTIFY_STALE_LOCK", key: "notify.stale_lock"},
+	{field: "NotifyRoutes", mapKey: "watcher_health", env: "NOTIFY_WATCHER_HEALTH", key: "notify.watcher_health"},
+	{field: "MaxClockSkew", env: "UPLOAD_MAX_CLOCK_SKEW", key: "upload.max_clock_skew"},
+	{field: "MaxObservationAge", env: "UPLOAD_MAX_OBSERVATION_AGE", key: "upload.max_observation_age"},
+	{field: "TaxonomyFile", env: "UPLOAD_TAXONOMY_FILE", key: "upload.taxonomy_file"},
+	{field: "NormalizationFile", env: "UPLOAD_NORMALIZATION_FILE", key: "upload.normalization_file"},
+	{field: "UploadHooksFile", env: "UPLOAD_HOOKS_FILE", key: "upload.hooks_file"},
+	{field: "UploadHookTimeout", env: "UPLOAD_HOOK_TIMEOUT", key: "upload.hook_timeout"},
+	{field: "ChangeLogEnabled", env: "CHANGE_LOG_ENABLED", key: "changes.enabled"},
+	{field: "ChangeLogFile", env: "CHANGE_LOG_FILE", key: "changes.file"},
+	{field: "ChangeLogRetention", env: "CHANGE_LOG_RETENTION", key: "changes.retention"},
+	{field: "AuditEnabled", env: "AUDIT_ENABLED", key: "audit.enabled"},
+	{field: "AuditDir", env: "AUDIT_DIR", key: "audit.dir"},
+	{field: "AuditRetention", env: "AUDIT_RETENTION", key: "audit.retention"},
+	{field: "AuditDropDir", env: "AUDIT_DROP_DIR", key: "audit.drop_dir"},
+	{field: "FullSyncMaxConcurrent", env: "FULL_SYNC_MAX_CONCURRENT", key: "sync.max_concurrent"},
+	{field: "FullSyncChunkInterval", env: "FULL_SYNC_CHUNK_INTERVAL", key: "sync.chunk_interval"},
+	{field: "GeoIPDatabase", env: "GEOIP_DATABASE", key: "geoip.database"},
+	{field: "GeoIPAllowCountries", env: "GEOIP_ALLOW_COUNTRIES", key: "geoip.allow_countries"},
+	{field: "GeoIPDenyCountries", env: "GEOIP_DENY_COUNTRIES", key: "geoip.deny_countries"},
+	{field: "GeoIPAllowUnknown", env: "GEOIP_ALLOW_UNKNOWN", key: "geoip.allow_unknown"},
+	{field: "GeoIPListsFile", env: "GEOIP_LISTS_FILE", key: "geoip.lists_file"},
+	{field: "LogLevel", env: "LOG_LEVEL", key: "logging.level"},
+	{field: "LogFormat", env: "LOG_FORMAT", key: "logging.format"},
+	{field: "LogFile", env: "LOG_FILE", key: "logging.file"},
+	{field: "SecurityLogOutput", env: "SECURITY_LOG_OUTPUT", key: "security_log.output"},
+	{field: "SecurityLogFormat", env: "SECURITY_LOG_FORMAT", key: "security_log.format"},
+	{field: "SecurityLogFile", env: "SECURITY_LOG_FILE", key: "security_log.file"},
+	{field: "SecuritySyslogAddress", env: "SECURITY_SYSLOG_ADDRESS", key: "security_log.syslog_address"},
+	{field: "LogRotateMaxSize", env: "LOG_ROTATE_MAX_SIZE", key: "log_rotation.max_size"},
+	{field: "LogRotateInterval", env: "LOG_ROTATE_INTERVAL", key: "log_rotation.interval"},
+	{field: "LogRotateMaxBackups", env: "LOG_ROTATE_MAX_BACKUPS", key: "log_rotation.max_backups"},
+	{field: "LogRotateMaxAge", env: "LOG_ROTATE_MAX_AGE", key: "log_rotation.max_age"},
+	{field: "LogRotateCompress", env: "LOG_ROTATE_COMPRESS", key: "log_rotation.compress"},
+	{field: "RecordingEnabled", env: "RECORDING_ENABLED", key: "recording.enabled"},
+	{field: "RecordingBufferSize", env: "RECORDING_BUFFER_SIZE", key: "recording.buffer_size"},
+	{field: "RecordingMaxBody", env: "RECORDING_MAX_BODY", key: "recording.max_body"},
+	{field: "RateLimitRequestsPerMinute", env: "RATE_LIMIT_REQUESTS_PER_MINUTE", key: "rate_limit.requests_per_minute"},
+	{field: "RateLimitBurst", env: "RATE_LIMIT_BURST", key: "rate_limit.burst"},
+	{field: "RateLimitUploadRequestsPerMinute", env: "RATE_LIMIT_UPLOAD_REQUESTS_PER_MINUTE", key: "rate_limit.upload_requests_per_minute"},
+	{field: "RateLimitUploadBurst", env: "RATE_LIMIT_UPLOAD_BURST", key: "rate_limit.upload_burst"},
+	{field: "RateLimitStateRequestsPerMinute", env: "RATE_LIMIT_STATE_REQUESTS_PER_MINUTE", key: "rate_limit.state_requests_per_minute"},
+	{field: "RateLimitStateBurst", env: "RATE_LIMIT_STATE_BURST", key: "rate_limit.state_burst"},
+	{field: "RateLimitIPRequestsPerMinute", env: "RATE_LIMIT_IP_REQUESTS_PER_MINUTE", key: "rate_limit.ip_requests_per_minute"},
+	{field: "RateLimitIPBurst", env: "RATE_LIMIT_IP_BURST", key: "rate_limit.ip_burst"},
+	{field: "RateLimitMaxBuckets", env: "RATE_LIMIT_MAX_BUCKETS", key: "rate_limit.max_buckets"},
+	{field: "RateLimitOverridesFile", env: "RATE_LIMIT_OVERRIDES_FILE", key: "rate_limit.overrides_file"},
+	{field: "RollupEnabled", env: "ROLLUP_ENABLED", key: "rollup.enabled"},
+	{field: "RollupDir", env: "ROLLUP_DIR", key: "rollup.dir"},
+	{field: "RollupInterval", env: "ROLLUP_INTERVAL", key: "rollup.interval"},
+	{field: "RollupSumFields", env: "ROLLUP_SUM_FIELDS", key: "rollup.sum_fields"},
+	{field: "QueryCacheEnabled", env: "QUERY_CACHE_ENABLED", key: "query_cache.enabled"},
+	{field: "QueryCacheMaxBytes", env: "QUERY_CACHE_MAX_BYTES", key: "query_cache.max_bytes"},
+	{field: "QueryCacheTTL", env: "QUERY_CACHE_TTL", key: "query_cache.ttl"},
+	{field: "QuarantineEnabled", env: "QUARANTINE_ENABLED", key: "quarantine.enabled"},
+	{field: "QuarantineDir", env: "QUARANTINE_DIR", key: "quarantine.dir"},
+	{field: "QuarantineMaxBytes", env: "QUARANTINE_MAX_BYTES", key: "quarantine.max_bytes"},
+	{field: "QuarantineRetention", env: "QUARANTINE_RETENTION", key: "quarantine.retention"},
+	{field: "Deprecations", env: "DEPRECATIONS", key: "deprecation.rules"},
+	{field: "Features", env: "FEATURES", key: "features.flags"},
+	{field: "OutboundProxy", env: "OUTBOUND_PROXY", key: "outbound.proxy"},
+	{field: "OutboundNoProxy", env: "OUTBOUND_NO_PROXY", key: "outbound.no_proxy"},
+	{field: "OutboundCABundle", env: "OUTBOUND_CA_BUNDLE", key: "outbound.ca_bundle"},
+	{field: "FaultInjectionEnabled", env: "FAULT_INJECTION_ENABLED", key: "faults.enabled"},
+}
+
+// fileKeys returns the section.key names with a value in a config file
+func fileKeys(file *ini.File) map[string]bool {
+	keys := make(map[string]bool)
+	for _, section := range file.Sections() {
+		for _, key := range section.Keys() {
+			if key.Value() != "" {
+				keys[section.Name()+"."+key.Name()] = true
+			}
+		}
+	}
+	return keys
+}
+
+// File returns the config file the configuration was loaded from, or ""
+// when it was read from the environment
+func (c *Config) File() string {
+	return c.file
+}
+
+// Effective returns every setting with its effective value and source.
+// Secrets are redacted and so are passwords in URLs.
+func (c *Config) Effective() []Setting {
+	v := reflect.ValueOf(c).Elem()
+	effective := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		name := s.field
+		field := v.FieldByName(s.field)
+		if s.mapKey != "" {
+			name += "." + s.mapKey
+			field = field.MapIndex(reflect.ValueOf(s.mapKey))
+		}
+
+		value := ""
+		if field.IsValid() {
+			value = fmt.Sprint(field.Interface())
+		}
+		if s.secret && value != "" {
+			value = redacted
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+
+		source := SourceDefault
+		if c.file != "" {
+			if s.key != "" && c.fileKeys[s.key] {
+				source = SourceFile
+			}
+		} else if s.env != "" && os.Getenv(s.env) != "" {
+			source = SourceEnv
+		}
+
+		effective = append(effective, Setting{
+			Name:   name,
+			Env:    s.env,
+			Key:    s.key,
+			Value:  value,
+			Source: source,
+			Secret: s.secret,
+		})
+	}
+	return effective
+}
+
+// FlagSettings returns the command-line flags of a flag set as settings;
+// flags left at their default have the default source
+func FlagSettings(flags *flag.FlagSet) []Setting {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var effective []Setting
+	flags.VisitAll(func(f *flag.Flag) {
+		source := SourceDefault
+		if set[f.Name] {
+			source = SourceFlag
+		}
+		effective = append(effective, Setting{Name: "-" + f.Name, Value: f.Value.String(), Source: source})
+	})
+	return effective
+}
@@ -0,0 +1,281 @@
+// Package csvcrypt encrypts files at rest with AES-256-GCM in a format that
+// supports appending and streaming decryption. A file starts with Magic
+// and holds a sequence of independently sealed chunks:
+//
+//	uint32 ciphertext length | 12-byte nonce | ciphertext and tag
+//
+// Each chunk is authenticated together with caller-supplied associated data
+// (such as the owner of the file) and its offset in the file, so chunks
+// cannot be moved within or between files without detection. Data keys are
+// stored wrapped (encrypted) by a master key.
+package csvcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Magic starts every encrypted file
+const Magic = "TFBSENC1"
+
+// KeySize is the size of master and data keys in bytes (AES-256)
+const KeySize = 32
+
+// maxChunkSize bounds the plaintext sealed in one chunk, so readers never
+// buffer more than this much of a file
+const maxChunkSize = 64 << 10
+
+// chunkHeaderSize is the size of the length prefix and nonce of a chunk
+const chunkHeaderSize = 4 + 12
+
+// ErrCorrupt is returned for encrypted data that fails authentication
+// because it is damaged, was modified, or belongs to another owner or key
+var ErrCorrupt = errors.New("encrypted data is corrupt or was modified")
+
+// GenerateKey returns a new random key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// ParseKey decodes a base64-encoded key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads a base64-encoded key from a file
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// newAEAD creates an AES-GCM cipher with the key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap encrypts a data key with the master key, bound to the associated
+// data. The result holds the nonce and the sealed key.
+func Wrap(master, key, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, key, aad), nil
+}
+
+// Unwrap decrypts a data key wrapped with Wrap
+func Unwrap(master, wrapped, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], aad)
+	if err != nil {
+		// Most likely another master key
+		return nil, fmt.Errorf("failed to unwrap data key: %w", ErrCorrupt)
+	}
+	return key, nil
+}
+
+// IsEncrypted reports whether the start of a file is Magic
+func IsEncrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(Magic))
+}
+
+// chunkAAD binds a chunk to the associated data and its offset
+func chunkAAD(aad []byte, offset int64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, aad...), uint64(offset))
+}
+
+// Writer encrypts what is written to it into chunks. Written data is
+// buffered until a chunk is full or the writer is closed.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	aad    []byte
+	offset int64 // Offset of the next chunk in the file
+	buf    []byte
+	err    error
+}
+
+// NewWriter starts a new encrypted file on w, writing Magic
+func NewWriter(w io.Writer, key, aad []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, aad: aad, offset: int64(len(Magic))}, nil
+}
+
+// NewAppendWriter appends chunks to an encrypted file of size bytes, which
+// w must write to the end of
+func NewAppendWriter(w io.Writer, key, aad []byte, size int64) (*Writer, error) {
+	if size < int64(len(Magic)) {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, aad: aad, offset: size}, nil
+}
+
+// Write buffers p, sealing every full chunk
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= maxChunkSize {
+		if err := w.seal(w.buf[:maxChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[maxChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the buffered data. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if err := w.seal(w.buf); err != nil {
+			return err
+		}
+		w.buf = nil
+	}
+	w.err = errors.New("csvcrypt: writer is closed")
+	return nil
+}
+
+// seal writes one chunk with a single write, so a failed write leaves at
+// most one torn chunk at the end of the file
+func (w *Writer) seal(plaintext []byte) error {
+	chunk := make([]byte, chunkHeaderSize, chunkHeaderSize+len(plaintext)+w.aead.Overhead())
+	nonce := chunk[4:chunkHeaderSize]
+	if _, err := rand.Read(nonce); err != nil {
+		w.err = fmt.Errorf("failed to generate nonce: %w", err)
+		return w.err
+	}
+	chunk = w.aead.Seal(chunk, nonce, plaintext, chunkAAD(w.aad, w.offset))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(chunk)-chunkHeaderSize))
+
+	if _, err := w.w.Write(chunk); err != nil {
+		w.err = err
+		return err
+	}
+	w.offset += int64(len(chunk))
+	return nil
+}
+
+// Reader decrypts an encrypted file chunk by chunk
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	aad    []byte
+	offset int64 // Offset of the next chunk in the file
+	plain  []byte
+	err    error
+}
+
+// NewReader starts reading an encrypted file from r, checking Magic
+func NewReader(r io.Reader, key, aad []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || !IsEncrypted(magic) {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+	return &Reader{r: br, aead: aead, aad: aad, offset: int64(len(Magic))}, nil
+}
+
+// Read returns decrypted data. A chunk that fails authentication, or a
+// truncated chunk, returns ErrCorrupt.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk into r.plain
+func (r *Reader) next() error {
+	header := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("truncated chunk at offset %d: %w", r.offset, ErrCorrupt)
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < uint32(r.aead.Overhead()) || size > maxChunkSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("invalid chunk at offset %d: %w", r.offset, ErrCorrupt)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return fmt.Errorf("truncated chunk at offset %d: %w", r.offset, ErrCorrupt)
+	}
+
+	plain, err := r.aead.Open(sealed[:0], header[4:], sealed, chunkAAD(r.aad, r.offset))
+	if err != nil {
+		return fmt.Errorf("chunk at offset %d: %w", r.offset, ErrCorrupt)
+	}
+	r.offset += int64(chunkHeaderSize) + int64(size)
+	r.plain = plain
+	return nil
+}
@@ -0,0 +1,141 @@
+package csvcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func mustKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return key
+}
+
+// decrypt reads a whole encrypted file
+func decrypt(file, key, aad []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(file), key, aad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestWriteAppendRead(t *testing.T) {
+	key, aad := mustKey(t), []byte("org-1")
+
+	var file bytes.Buffer
+	w, err := NewWriter(&file, key, aad)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	w.Write([]byte("timestamp,org_id\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !IsEncrypted(file.Bytes()) {
+		t.Fatal("Expected the file to start with the magic")
+	}
+
+	// Large appends span several chunks
+	large := strings.Repeat("x", 3*maxChunkSize+17) + "\n"
+	for _, row := range []string{"a,1\n", large, "b,2\n"} {
+		w, err := NewAppendWriter(&file, key, aad, int64(file.Len()))
+		if err != nil {
+			t.Fatalf("NewAppendWriter failed: %v", err)
+		}
+		w.Write([]byte(row))
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	got, err := decrypt(file.Bytes(), key, aad)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if want := "timestamp,org_id\na,1\n" + large + "b,2\n"; string(got) != want {
+		t.Errorf("Got %d bytes, want %d", len(got), len(want))
+	}
+	if bytes.Contains(file.Bytes(), []byte("timestamp")) {
+		t.Error("Expected no plaintext in the file")
+	}
+}
+
+func TestReadDetectsTampering(t *testing.T) {
+	key, aad := mustKey(t), []byte("org-1")
+
+	var file bytes.Buffer
+	w, _ := NewWriter(&file, key, aad)
+	w.Write([]byte("first\n"))
+	w.Close()
+	first := file.Len()
+	w, _ = NewAppendWriter(&file, key, aad, int64(file.Len()))
+	w.Write([]byte("second\n"))
+	w.Close()
+
+	tests := []struct {
+		name string
+		file []byte
+		key  []byte
+		aad  []byte
+	}{
+		{"flipped bit", func() []byte {
+			b := bytes.Clone(file.Bytes())
+			b[len(b)-1] ^= 1
+			return b
+		}(), key, aad},
+		{"truncated", file.Bytes()[:file.Len()-3], key, aad},
+		{"reordered chunks", append(append([]byte(Magic), file.Bytes()[first:]...), file.Bytes()[len(Magic):first]...), key, aad},
+		{"other owner", file.Bytes(), key, []byte("org-2")},
+		{"other key", file.Bytes(), mustKey(t), aad},
+	}
+	for _, tt := range tests {
+		if _, err := decrypt(tt.file, tt.key, tt.aad); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", tt.name, err)
+		}
+	}
+
+	if _, err := decrypt([]byte("timestamp,org_id\n"), key, aad); err == nil {
+		t.Error("Expected plaintext files to be rejected")
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	master, key := mustKey(t), mustKey(t)
+	wrapped, err := Wrap(master, key, []byte("org-1"))
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+
+	got, err := Unwrap(master, wrapped, []byte("org-1"))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Unwrap returned %x, %v", got, err)
+	}
+	if _, err := Unwrap(mustKey(t), wrapped, []byte("org-1")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt with another master key, got %v", err)
+	}
+	if _, err := Unwrap(master, wrapped, []byte("org-2")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for another owner, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := mustKey(t)
+	got, err := ParseKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("ParseKey returned %x, %v", got, err)
+	}
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString(key[:16])); err == nil {
+		t.Error("Expected short keys to be rejected")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("Expected invalid base64 to be rejected")
+	}
+}
@@ -0,0 +1,182 @@
+// Package csvfmt configures the CSV dialect of stored files and exports:
+// delimiter, quoting and UTF-8 byte order mark.
+package csvfmt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Quote modes
+const (
+	// QuoteMinimal quotes only fields that need it (default)
+	QuoteMinimal = "minimal"
+
+	// QuoteAll quotes every field
+	QuoteAll = "all"
+)
+
+// bom is the UTF-8 byte order mark expected by some spreadsheet tools
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// sniffDelimiters are the delimiters recognized when reading a file
+const sniffDelimiters = ",;\t|"
+
+// Format is a CSV dialect
+type Format struct {
+	Delimiter rune
+	QuoteAll  bool
+	BOM       bool
+}
+
+// Default is the RFC 4180 dialect: comma-delimited, minimal quoting, no BOM
+var Default = Format{Delimiter: ','}
+
+// Parse builds a format from configuration values
+func Parse(delimiter, quote string, bom bool) (Format, error) {
+	d, err := ParseDelimiter(delimiter)
+	if err != nil {
+		return Format{}, err
+	}
+	quoteAll, err := ParseQuote(quote)
+	if err != nil {
+		return Format{}, err
+	}
+	return Format{Delimiter: d, QuoteAll: quoteAll, BOM: bom}, nil
+}
+
+// ParseDelimiter accepts a delimiter character or its name (comma,
+// semicolon, tab, pipe). Names are needed in INI files, where ";" starts a
+// comment.
+func ParseDelimiter(value string) (rune, error) {
+	switch strings.ToLower(value) {
+	case "", ",", "comma":
+		return ',', nil
+	case ";", "semicolon":
+		return ';', nil
+	case "\t", "tab":
+		return '\t', nil
+	case "|", "pipe":
+		return '|', nil
+	}
+	return 0, fmt.Errorf("unsupported CSV delimiter %q (supported: comma, semicolon, tab, pipe)", value)
+}
+
+// ParseQuote accepts "minimal" (or empty) and "all" and reports whether
+// every field is quoted
+func ParseQuote(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", QuoteMinimal:
+		return false, nil
+	case QuoteAll:
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported CSV quote mode %q (supported: %s, %s)", value, QuoteMinimal, QuoteAll)
+}
+
+// Writer writes records in a format. The BOM, if enabled, is written
+// before the first record.
+type Writer struct {
+	format  Format
+	w       *bufio.Writer
+	csv     *csv.Writer
+	started bool
+	err     error
+}
+
+// NewWriter creates a writer for a new file or stream
+func (f Format) NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	cw.Comma = f.Delimiter
+	return &Writer{format: f, w: bw, csv: cw}
+}
+
+// NewAppendWriter creates a writer that appends to an existing file. The BOM
+// is never written, and delimiter is used so appended rows match the file.
+func (f Format) NewAppendWriter(w io.Writer, delimiter rune) *Writer {
+	f.BOM = false
+	f.Delimiter = delimiter
+	return f.NewWriter(w)
+}
+
+// Write writes a single record
+func (w *Writer) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if !w.started {
+		w.started = true
+		if w.format.BOM {
+			if _, err := w.w.Write(bom); err != nil {
+				w.err = err
+				return err
+			}
+		}
+	}
+
+	if !w.format.QuoteAll {
+		w.err = w.csv.Write(record)
+		return w.err
+	}
+
+	// encoding/csv only quotes when needed, so quoted records are written
+	// directly (flushing first to keep the order)
+	w.csv.Flush()
+	for i, field := range record {
+		if i > 0 {
+			w.w.WriteRune(w.format.Delimiter)
+		}
+		w.w.WriteByte('"')
+		w.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		w.w.WriteByte('"')
+	}
+	_, w.err = w.w.WriteString("\n")
+	return w.err
+}
+
+// Flush writes any buffered data to the underlying writer
+func (w *Writer) Flush() {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Error reports any error from a previous Write or Flush
+func (w *Writer) Error() error {
+	return w.err
+}
+
+// NewReader creates a CSV reader that skips a leading BOM and detects the
+// delimiter from the first line, so files written in any supported dialect
+// can be read. It returns the detected delimiter.
+func NewReader(r io.Reader) (*csv.Reader, rune) {
+	br := bufio.NewReader(r)
+	if prefix, err := br.Peek(len(bom)); err == nil && bytes.Equal(prefix, bom) {
+		br.Discard(len(bom))
+	}
+
+	// The BOM check filled the buffer; the first delimiter of a header
+	// written by this service is always within it
+	delimiter := ','
+	line, _ := br.Peek(br.Buffered())
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if i := bytes.IndexAny(line, sniffDelimiters); i >= 0 {
+		delimiter = rune(line[i])
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = delimiter
+	return reader, delimiter
+}
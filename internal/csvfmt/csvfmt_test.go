@@ -0,0 +1,97 @@
+package csvfmt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	format, err := Parse("semicolon", "all", true)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if format != (Format{Delimiter: ';', QuoteAll: true, BOM: true}) {
+		t.Errorf("Unexpected format: %+v", format)
+	}
+
+	format, err = Parse("", "", false)
+	if err != nil {
+		t.Fatalf("Parse of empty values failed: %v", err)
+	}
+	if format != Default {
+		t.Errorf("Expected default format, got %+v", format)
+	}
+
+	if _, err := Parse("colon", "minimal", false); err == nil {
+		t.Error("Expected error for unsupported delimiter")
+	}
+	if _, err := Parse("comma", "some", false); err == nil {
+		t.Error("Expected error for unsupported quote mode")
+	}
+}
+
+func TestWriterSemicolonBOMQuoteAll(t *testing.T) {
+	var buf bytes.Buffer
+	writer := Format{Delimiter: ';', QuoteAll: true, BOM: true}.NewWriter(&buf)
+	writer.Write([]string{"name", "note"})
+	writer.Write([]string{"web-1", `say "hi"; bye`})
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	expected := "\xEF\xBB\xBF\"name\";\"note\"\n\"web-1\";\"say \"\"hi\"\"; bye\"\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestWriterMinimalQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	writer := Format{Delimiter: '\t'}.NewWriter(&buf)
+	writer.Write([]string{"a", "b c", "d\te"})
+	writer.Flush()
+
+	if buf.String() != "a\tb c\t\"d\te\"\n" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+func TestAppendWriterSkipsBOM(t *testing.T) {
+	var buf bytes.Buffer
+	writer := Format{Delimiter: ';', BOM: true}.NewAppendWriter(&buf, ',')
+	writer.Write([]string{"a", "b"})
+	writer.Flush()
+
+	if buf.String() != "a,b\n" {
+		t.Errorf("Expected appended row in file delimiter without BOM, got %q", buf.String())
+	}
+}
+
+func TestReaderDetectsDialect(t *testing.T) {
+	for _, format := range []Format{
+		Default,
+		{Delimiter: ';', BOM: true},
+		{Delimiter: '\t', QuoteAll: true},
+		{Delimiter: '|', QuoteAll: true, BOM: true},
+	} {
+		var buf bytes.Buffer
+		writer := format.NewWriter(&buf)
+		writer.Write([]string{"name", "data"})
+		writer.Write([]string{"web-1", `{"a":1,"b":"x;y"}`})
+		writer.Flush()
+
+		reader, delimiter := NewReader(strings.NewReader(buf.String()))
+		if delimiter != format.Delimiter {
+			t.Errorf("%+v: expected delimiter %q, got %q", format, format.Delimiter, delimiter)
+		}
+		records, err := reader.ReadAll()
+		if err != nil {
+			t.Fatalf("%+v: read failed: %v", format, err)
+		}
+		if len(records) != 2 || records[0][0] != "name" || records[1][1] != `{"a":1,"b":"x;y"}` {
+			t.Errorf("%+v: unexpected records %q", format, records)
+		}
+	}
+}
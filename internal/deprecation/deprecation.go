@@ -0,0 +1,342 @@
+// Package deprecation marks API endpoints and query parameters as
+// deprecated, announces their retirement with Deprecation and Sunset headers
+// (RFC 9745, RFC 8594), and records which organizations still use them.
+package deprecation
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// Response headers
+const (
+	DeprecationHeader = "Deprecation"
+	SunsetHeader      = "Sunset"
+	LinkHeader        = "Link"
+)
+
+// logInterval is the minimum time between log lines for the same
+// organization and rule
+const logInterval = time.Hour
+
+// Rule marks an endpoint, or one of its query parameters, deprecated
+type Rule struct {
+	// Method is the HTTP method, or "*" for all methods
+	Method string
+
+	// Pattern is the route path; a {param} segment matches any single
+	// segment and a trailing "*" matches the rest of the path
+	Pattern string
+
+	// Param is the deprecated query parameter; empty deprecates the endpoint
+	Param string
+
+	// Deprecated is when the rule took effect (zero if not stated)
+	Deprecated time.Time
+
+	// Sunset is when the endpoint or parameter will stop working (zero if
+	// not scheduled)
+	Sunset time.Time
+
+	// Link points to migration documentation (optional)
+	Link string
+}
+
+// String returns the rule as "METHOD /path" or "METHOD /path?param"
+func (r Rule) String() string {
+	s := r.Method + " " + r.Pattern
+	if r.Param != "" {
+		s += "?" + r.Param
+	}
+	return s
+}
+
+// ParseRules parses comma-separated rules of the form
+//
+//	METHOD /path[?param] [deprecated=DATE] [sunset=DATE] [link=URL]
+//
+// where dates are YYYY-MM-DD or RFC3339. An empty spec has no rules.
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("deprecation %q: expected a method and a path", strings.TrimSpace(entry))
+		}
+
+		rule := Rule{Method: strings.ToUpper(fields[0])}
+		if rule.Method != "*" && !isToken(rule.Method) {
+			return nil, fmt.Errorf("deprecation %q: invalid method %q", strings.TrimSpace(entry), fields[0])
+		}
+		rule.Pattern, rule.Param, _ = strings.Cut(fields[1], "?")
+		if !strings.HasPrefix(rule.Pattern, "/") {
+			return nil, fmt.Errorf("deprecation %q: path must start with /", strings.TrimSpace(entry))
+		}
+		if i := strings.Index(rule.Pattern, "*"); i >= 0 && i != len(rule.Pattern)-1 {
+			return nil, fmt.Errorf("deprecation %q: * is only allowed at the end of the path", strings.TrimSpace(entry))
+		}
+		rule.Pattern = strings.TrimSuffix(rule.Pattern, "/")
+
+		for _, option := range fields[2:] {
+			key, value, ok := strings.Cut(option, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("deprecation %q: expected key=value, got %q", strings.TrimSpace(entry), option)
+			}
+			var err error
+			switch key {
+			case "deprecated":
+				rule.Deprecated, err = parseDate(value)
+			case "sunset":
+				rule.Sunset, err = parseDate(value)
+			case "link":
+				if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+					err = fmt.Errorf("link must be an http(s) URL")
+				}
+				rule.Link = value
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("deprecation %q: %v", strings.TrimSpace(entry), err)
+			}
+		}
+		if !rule.Deprecated.IsZero() && !rule.Sunset.IsZero() && rule.Sunset.Before(rule.Deprecated) {
+			return nil, fmt.Errorf("deprecation %q: sunset is before deprecation", strings.TrimSpace(entry))
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseDate parses a YYYY-MM-DD date (midnight UTC) or an RFC3339 time
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC3339", value)
+	}
+	return t.UTC(), nil
+}
+
+// isToken reports whether s is a non-empty upper-case HTTP method
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the rule applies to the request
+func (r Rule) matches(req *http.Request) bool {
+	if r.Method != "*" && r.Method != req.Method {
+		return false
+	}
+	if r.Param != "" && !req.URL.Query().Has(r.Param) {
+		return false
+	}
+	return matchPath(r.Pattern, strings.TrimSuffix(req.URL.Path, "/"))
+}
+
+// matchPath matches a path against a route pattern segment by segment
+func matchPath(pattern, path string) bool {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	for i, segment := range patternSegments {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(pathSegments)
+}
+
+// OrgUsage is an organization's use of a deprecated endpoint or parameter
+type OrgUsage struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	Requests  int64     `json:"requests"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// RuleUsage reports the organizations that used a rule's endpoint or
+// parameter since the server started, most recent first
+type RuleUsage struct {
+	Rule       string     `json:"rule"`
+	Deprecated *time.Time `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+	Link       string     `json:"link,omitempty"`
+	Orgs       []OrgUsage `json:"orgs"`
+}
+
+// usageKey identifies an organization's use of a rule
+type usageKey struct {
+	rule  int
+	orgID uuid.UUID
+}
+
+// orgUsage counts an organization's use of a rule
+type orgUsage struct {
+	OrgUsage
+	lastLogged time.Time
+}
+
+// Tracker announces deprecations on matching responses and records their
+// use per organization
+type Tracker struct {
+	rules []Rule
+
+	mu    sync.Mutex
+	usage map[usageKey]*orgUsage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewTracker creates a tracker for the rules
+func NewTracker(rules []Rule) *Tracker {
+	return &Tracker{
+		rules: rules,
+		usage: make(map[usageKey]*orgUsage),
+		now:   time.Now,
+	}
+}
+
+// Middleware adds Deprecation, Sunset and Link headers to responses of
+// deprecated endpoints and parameters, and records the use by the
+// authenticated organization. It must run after authentication.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var matched []int
+		for i, rule := range t.rules {
+			if rule.matches(r) {
+				matched = append(matched, i)
+			}
+		}
+		if len(matched) > 0 {
+			t.announce(w, matched)
+			if orgID, ok := reqctx.OrgID(r.Context()); ok {
+				t.record(r, orgID, matched)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// announce sets the deprecation headers of the matched rules. With several
+// rules, the earliest deprecation and sunset are announced.
+func (t *Tracker) announce(w http.ResponseWriter, matched []int) {
+	var deprecated, sunset time.Time
+	for _, i := range matched {
+		rule := t.rules[i]
+		if !rule.Deprecated.IsZero() && (deprecated.IsZero() || rule.Deprecated.Before(deprecated)) {
+			deprecated = rule.Deprecated
+		}
+		if !rule.Sunset.IsZero() && (sunset.IsZero() || rule.Sunset.Before(sunset)) {
+			sunset = rule.Sunset
+		}
+		if rule.Link != "" {
+			w.Header().Add(LinkHeader, fmt.Sprintf("<%s>; rel=\"deprecation\"", rule.Link))
+		}
+	}
+
+	if deprecated.IsZero() {
+		w.Header().Set(DeprecationHeader, "true")
+	} else {
+		w.Header().Set(DeprecationHeader, "@"+strconv.FormatInt(deprecated.Unix(), 10))
+	}
+	if !sunset.IsZero() {
+		w.Header().Set(SunsetHeader, sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+// record counts the use of the matched rules by the organization, logging
+// it at most once per logInterval per organization and rule
+func (t *Tracker) record(r *http.Request, orgID uuid.UUID, matched []int) {
+	now := t.now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, i := range matched {
+		key := usageKey{rule: i, orgID: orgID}
+		usage, ok := t.usage[key]
+		if !ok {
+			usage = &orgUsage{OrgUsage: OrgUsage{OrgID: orgID, FirstSeen: now}}
+			t.usage[key] = usage
+		}
+		usage.Requests++
+		usage.LastSeen = now
+
+		if now.Sub(usage.lastLogged) >= logInterval {
+			usage.lastLogged = now
+			rule := t.rules[i]
+			sunset := "none"
+			if !rule.Sunset.IsZero() {
+				sunset = rule.Sunset.Format(time.DateOnly)
+			}
+			slog.WarnContext(r.Context(), "Deprecated API used", "org_id", orgID, "rule", rule.String(),
+				"sunset", sunset, "requests", usage.Requests, "ip", r.RemoteAddr)
+		}
+	}
+}
+
+// Report returns the usage of every rule, including rules no organization
+// used
+func (t *Tracker) Report() []RuleUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := make([]RuleUsage, len(t.rules))
+	for i, rule := range t.rules {
+		report[i] = RuleUsage{Rule: rule.String(), Link: rule.Link, Orgs: []OrgUsage{}}
+		if !rule.Deprecated.IsZero() {
+			deprecated := rule.Deprecated
+			report[i].Deprecated = &deprecated
+		}
+		if !rule.Sunset.IsZero() {
+			sunset := rule.Sunset
+			report[i].Sunset = &sunset
+		}
+	}
+	for key, usage := range t.usage {
+		report[key.rule].Orgs = append(report[key.rule].Orgs, usage.OrgUsage)
+	}
+	for _, rule := range report {
+		slices.SortFunc(rule.Orgs, func(a, b OrgUsage) int {
+			return b.LastSeen.Compare(a.LastSeen)
+		})
+	}
+	return report
+}
@@ -0,0 +1,110 @@
+package deprecation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("POST /api/v1/upload deprecated=2026-01-01 sunset=2027-01-01 link=https://docs.example.com/v2, get /api/v1/data?fields ,")
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %+v", rules)
+	}
+	if rules[0].String() != "POST /api/v1/upload" || rules[0].Sunset != time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC) || rules[0].Link != "https://docs.example.com/v2" {
+		t.Errorf("Unexpected first rule %+v", rules[0])
+	}
+	if rules[1].String() != "GET /api/v1/data?fields" || !rules[1].Sunset.IsZero() {
+		t.Errorf("Unexpected second rule %+v", rules[1])
+	}
+
+	for _, spec := range []string{
+		"/api/v1/upload",
+		"POST api/v1/upload",
+		"POST /api/*/upload",
+		"P0ST /api/v1/upload",
+		"POST /api/v1/upload sunset=soon",
+		"POST /api/v1/upload retire=2027-01-01",
+		"POST /api/v1/upload link=docs",
+		"POST /api/v1/upload deprecated=2027-01-01 sunset=2026-01-01",
+	} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestMiddlewareAnnouncesAndRecords(t *testing.T) {
+	rules, err := ParseRules("POST /api/v1/upload deprecated=2026-01-01 sunset=2027-01-01 link=https://docs.example.com/v2, * /api/v1/state/{name}/lock-history, GET /api/v1/data?fields sunset=2026-12-01")
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	tracker := NewTracker(rules)
+	clock := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	orgID, otherOrgID := uuid.New(), uuid.New()
+	serve := func(orgID uuid.UUID, method, target string) http.Header {
+		req := httptest.NewRequest(method, target, nil)
+		if orgID != uuid.Nil {
+			req = req.WithContext(reqctx.WithOrgID(req.Context(), orgID))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	header := serve(orgID, http.MethodPost, "/api/v1/upload")
+	if header.Get(DeprecationHeader) != "@1767225600" || header.Get(SunsetHeader) != "Fri, 01 Jan 2027 00:00:00 GMT" ||
+		header.Get(LinkHeader) != `<https://docs.example.com/v2>; rel="deprecation"` {
+		t.Errorf("Unexpected deprecation headers %v", header)
+	}
+	serve(orgID, http.MethodPost, "/api/v1/upload/")
+	serve(otherOrgID, http.MethodPost, "/api/v1/upload")
+
+	if header := serve(orgID, http.MethodDelete, "/api/v1/state/prod/lock-history"); header.Get(DeprecationHeader) != "true" || header.Get(SunsetHeader) != "" {
+		t.Errorf("Expected an undated deprecation, got %v", header)
+	}
+	if header := serve(orgID, http.MethodGet, "/api/v1/data?fields=cpu"); header.Get(SunsetHeader) != "Tue, 01 Dec 2026 00:00:00 GMT" {
+		t.Errorf("Expected the parameter sunset, got %v", header)
+	}
+	for _, target := range []string{"/api/v1/data", "/api/v1/upload/csv", "/api/v1/state/prod/lock", "/api/v1/state//lock-history"} {
+		if header := serve(orgID, http.MethodGet, target); header.Get(DeprecationHeader) != "" {
+			t.Errorf("Expected %s not to be deprecated, got %v", target, header)
+		}
+	}
+	if header := serve(orgID, http.MethodGet, "/api/v1/upload"); header.Get(DeprecationHeader) != "" {
+		t.Errorf("Expected only POST to be deprecated, got %v", header)
+	}
+
+	// Unauthenticated requests are announced but not attributed
+	if header := serve(uuid.Nil, http.MethodPost, "/api/v1/upload"); header.Get(DeprecationHeader) == "" {
+		t.Error("Expected unauthenticated requests to be announced")
+	}
+
+	report := tracker.Report()
+	if len(report) != 3 {
+		t.Fatalf("Expected a report for each rule, got %+v", report)
+	}
+	upload := report[0]
+	if upload.Rule != "POST /api/v1/upload" || upload.Sunset == nil || len(upload.Orgs) != 2 {
+		t.Fatalf("Unexpected upload usage %+v", upload)
+	}
+	if upload.Orgs[0].OrgID != otherOrgID || upload.Orgs[1].OrgID != orgID || upload.Orgs[1].Requests != 2 {
+		t.Errorf("Expected orgs by last use with request counts, got %+v", upload.Orgs)
+	}
+	if len(report[1].Orgs) != 1 || len(report[2].Orgs) != 1 {
+		t.Errorf("Unexpected usage %+v", report)
+	}
+}
@@ -0,0 +1,175 @@
+// Package download signs short-lived download URLs for state snapshots and
+// data exports, so they can be handed to auditors and downstream jobs
+// without the organization's API key.
+//
+// A download token carries a grant: the organization, the key that issued
+// it, the resource and its expiry, signed with HMAC-SHA256. One-time tokens
+// are remembered until they expire, so they can only be redeemed once.
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Downloadable resources
+const (
+	ResourceState = "state" // The current state of a workspace
+	ResourceData  = "data"  // An export of the organization's data (GET /api/v1/data)
+)
+
+// MinSecretLength is the minimum length of a signing secret
+const MinSecretLength = 32
+
+// DefaultTTL is the lifetime of URLs requested without one
+const DefaultTTL = 15 * time.Minute
+
+// signingDomain separates download signatures from other HMACs made with
+// the same secret
+const signingDomain = "download."
+
+var (
+	ErrInvalidToken = errors.New("invalid download token")
+	ErrExpired      = errors.New("download token expired")
+	ErrUsed         = errors.New("download token already used")
+)
+
+// Grant is what a download token allows
+type Grant struct {
+	OrgID     string `json:"org"`
+	KeyID     string `json:"key,omitempty"`
+	Resource  string `json:"res"`
+	Name      string `json:"name,omitempty"` // State name
+	Query     string `json:"q,omitempty"`    // Raw query of data exports
+	OneTime   bool   `json:"once,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+// Signer signs and redeems download tokens. It is safe for concurrent use.
+type Signer struct {
+	secret []byte
+	maxTTL time.Duration
+
+	// One-time token IDs redeemed, until their expiry
+	mu   sync.Mutex
+	used map[string]time.Time
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// NewSigner creates a signer for tokens valid up to maxTTL. An empty secret
+// generates a random one, so tokens do not survive restarts and are only
+// valid on this instance.
+func NewSigner(secret []byte, maxTTL time.Duration) (*Signer, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, MinSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate download secret: %w", err)
+		}
+	}
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("download secret too short: %d bytes (minimum %d)", len(secret), MinSecretLength)
+	}
+	if maxTTL <= 0 {
+		return nil, fmt.Errorf("invalid download URL max TTL: %v (must be positive)", maxTTL)
+	}
+	return &Signer{secret: secret, maxTTL: maxTTL, used: make(map[string]time.Time), now: time.Now}, nil
+}
+
+// MaxTTL returns the longest lifetime of a token
+func (s *Signer) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// Sign creates a token for the grant, valid for ttl (at most MaxTTL). It
+// fills in the grant's ID and expiry.
+func (s *Signer) Sign(grant Grant, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > s.maxTTL {
+		return "", time.Time{}, fmt.Errorf("invalid TTL %v (must be positive and at most %v)", ttl, s.maxTTL)
+	}
+
+	expiresAt := s.now().UTC().Add(ttl).Truncate(time.Second)
+	grant.ExpiresAt = expiresAt.Unix()
+	grant.ID = uuid.NewString()
+
+	payload, err := json.Marshal(grant)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to encode download grant: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), expiresAt, nil
+}
+
+// Verify checks the token's signature and expiry and returns its grant
+func (s *Signer) Verify(token string) (Grant, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Grant{}, ErrInvalidToken
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return Grant{}, ErrInvalidToken
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(s.sign(encoded))
+	if !hmac.Equal(got, expected) {
+		return Grant{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Grant{}, ErrInvalidToken
+	}
+	var grant Grant
+	if err := json.Unmarshal(payload, &grant); err != nil || grant.ID == "" {
+		return Grant{}, ErrInvalidToken
+	}
+	if s.now().Unix() >= grant.ExpiresAt {
+		return Grant{}, ErrExpired
+	}
+	return grant, nil
+}
+
+// Redeem verifies the token and, for one-time tokens, marks it used. A
+// one-time token is only redeemed once per process.
+func (s *Signer) Redeem(token string) (Grant, error) {
+	grant, err := s.Verify(token)
+	if err != nil || !grant.OneTime {
+		return grant, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if _, ok := s.used[grant.ID]; ok {
+		return Grant{}, ErrUsed
+	}
+	for id, expiresAt := range s.used {
+		if !now.Before(expiresAt) {
+			delete(s.used, id)
+		}
+	}
+	s.used[grant.ID] = time.Unix(grant.ExpiresAt, 0)
+	return grant, nil
+}
+
+// sign returns the encoded HMAC-SHA256 signature of an encoded grant
+func (s *Signer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(signingDomain + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerSignAndVerify(t *testing.T) {
+	signer, err := NewSigner(nil, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	grant := Grant{OrgID: "11111111-2222-3333-4444-555555555555", KeyID: "3f9a1c2b7d4e", Resource: ResourceState, Name: "prod"}
+	token, expiresAt, err := signer.Sign(grant, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if until := time.Until(expiresAt); until <= 9*time.Minute || until > 10*time.Minute {
+		t.Errorf("Unexpected expiry %v", expiresAt)
+	}
+
+	verified, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if verified.OrgID != grant.OrgID || verified.Name != "prod" || verified.KeyID != grant.KeyID || verified.ID == "" {
+		t.Errorf("Unexpected grant %+v", verified)
+	}
+
+	// Tampered payloads and tokens of other secrets are rejected
+	payload, signature, _ := strings.Cut(token, ".")
+	tampered := payload[:len(payload)-2] + "AA." + signature
+	if _, err := signer.Verify(tampered); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token for a tampered payload, got %v", err)
+	}
+	other, _ := NewSigner(nil, time.Hour)
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token for another secret, got %v", err)
+	}
+
+	signer.now = func() time.Time { return expiresAt }
+	if _, err := signer.Verify(token); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected expired token, got %v", err)
+	}
+}
+
+func TestSignerTTLBounds(t *testing.T) {
+	signer, _ := NewSigner(nil, time.Hour)
+	for _, ttl := range []time.Duration{0, -time.Minute, 2 * time.Hour} {
+		if _, _, err := signer.Sign(Grant{Resource: ResourceData}, ttl); err == nil {
+			t.Errorf("Expected TTL %v to be rejected", ttl)
+		}
+	}
+
+	if _, err := NewSigner([]byte("short"), time.Hour); err == nil {
+		t.Error("Expected a short secret to be rejected")
+	}
+	if _, err := NewSigner(nil, 0); err == nil {
+		t.Error("Expected a zero max TTL to be rejected")
+	}
+}
+
+func TestSignerRedeemOneTime(t *testing.T) {
+	signer, _ := NewSigner(nil, time.Hour)
+
+	reusable, _, _ := signer.Sign(Grant{Resource: ResourceData}, time.Minute)
+	oneTime, _, _ := signer.Sign(Grant{Resource: ResourceData, OneTime: true}, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := signer.Redeem(reusable); err != nil {
+			t.Errorf("Redeem %d of a reusable token failed: %v", i, err)
+		}
+	}
+	if _, err := signer.Redeem(oneTime); err != nil {
+		t.Fatalf("First redeem of a one-time token failed: %v", err)
+	}
+	if _, err := signer.Redeem(oneTime); !errors.Is(err, ErrUsed) {
+		t.Errorf("Expected a used token to be rejected, got %v", err)
+	}
+
+	// Used tokens are forgotten once they expire
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	another, _, _ := signer.Sign(Grant{Resource: ResourceData, OneTime: true}, time.Minute)
+	if _, err := signer.Redeem(another); err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	if len(signer.used) != 1 {
+		t.Errorf("Expected expired used tokens to be pruned, %d remain", len(signer.used))
+	}
+}
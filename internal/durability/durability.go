@@ -0,0 +1,258 @@
+// Package durability decides when file writes of the file-based backends
+// are synced to disk, so operators choose between throughput and
+// crash-safety explicitly:
+//
+//	none             nothing is synced, not even lock files
+//	flush            writes reach the OS before they are acknowledged but
+//	                 are not synced; a power loss can lose recent writes
+//	fsync-per-write  every write is synced before it is acknowledged
+//	fsync-interval   writes are synced in the background every interval;
+//	                 a power loss loses at most one interval
+//
+// Except with none, files whose loss is worse than a lost write, like
+// state locks, are always synced before they are acknowledged.
+package durability
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is a durability level
+type Level string
+
+// Durability levels
+const (
+	None          Level = "none"
+	Flush         Level = "flush"
+	FsyncPerWrite Level = "fsync-per-write"
+	FsyncInterval Level = "fsync-interval"
+)
+
+// ParseLevel parses a durability level
+func ParseLevel(s string) (Level, error) {
+	switch level := Level(s); level {
+	case None, Flush, FsyncPerWrite, FsyncInterval:
+		return level, nil
+	}
+	return "", fmt.Errorf("unknown durability level %q (supported: none, flush, fsync-per-write, fsync-interval)", s)
+}
+
+// Policy syncs written files according to a level. A nil Policy behaves
+// like Flush.
+type Policy struct {
+	level    Level
+	interval time.Duration
+
+	mu    sync.Mutex
+	dirty map[string]bool // Paths written since the last background sync
+
+	syncs    atomic.Int64
+	failures atomic.Int64
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New creates a policy. With FsyncInterval, written files are synced every
+// interval until Close.
+func New(level Level, interval time.Duration) (*Policy, error) {
+	if _, err := ParseLevel(string(level)); err != nil {
+		return nil, err
+	}
+	if level == FsyncInterval && interval <= 0 {
+		return nil, fmt.Errorf("durability level %s requires a positive sync interval", level)
+	}
+
+	p := &Policy{
+		level:    level,
+		interval: interval,
+		dirty:    make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+	if level == FsyncInterval {
+		p.wg.Add(1)
+		go p.syncRoutine()
+	}
+	return p, nil
+}
+
+// Level returns the durability level
+func (p *Policy) Level() Level {
+	if p == nil {
+		return Flush
+	}
+	return p.level
+}
+
+// Sync makes a completed write to file durable as the level requires. It is
+// called before the file is closed. path is where the file is found by the
+// background sync: its final path if it is renamed.
+func (p *Policy) Sync(file *os.File, path string) error {
+	switch p.Level() {
+	case FsyncPerWrite:
+		return p.sync(file)
+	case FsyncInterval:
+		p.markDirty(path)
+	}
+	return nil
+}
+
+// SyncCritical syncs file unless the level is None. It is used for files
+// that must survive a crash once acknowledged, like state locks.
+func (p *Policy) SyncCritical(file *os.File) error {
+	if p.Level() == None {
+		return nil
+	}
+	return p.sync(file)
+}
+
+// SyncDir makes the creation, rename or removal of a file in dir durable as
+// the level requires
+func (p *Policy) SyncDir(dir string) error {
+	switch p.Level() {
+	case FsyncPerWrite:
+		return p.syncPath(dir)
+	case FsyncInterval:
+		p.markDirty(dir)
+	}
+	return nil
+}
+
+// SyncDirCritical syncs dir unless the level is None. It makes the creation
+// or removal of a critical file durable.
+func (p *Policy) SyncDirCritical(dir string) error {
+	if p.Level() == None {
+		return nil
+	}
+	return p.syncPath(dir)
+}
+
+// sync syncs an open file, counting the sync
+func (p *Policy) sync(file *os.File) error {
+	err := file.Sync()
+	p.count(err)
+	if err != nil {
+		return fmt.Errorf("failed to sync %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+// syncPath opens and syncs a file or directory. Syncing a file through a
+// new descriptor writes back everything written through other descriptors.
+func (p *Policy) syncPath(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Removed or renamed since it was written; nothing to sync
+		return nil
+	}
+	if err != nil {
+		p.count(err)
+		return fmt.Errorf("failed to open %s for sync: %w", path, err)
+	}
+	defer file.Close()
+	return p.sync(file)
+}
+
+// count records the outcome of a sync
+func (p *Policy) count(err error) {
+	if p == nil {
+		return
+	}
+	if err != nil {
+		p.failures.Add(1)
+		return
+	}
+	p.syncs.Add(1)
+}
+
+// markDirty remembers a path for the next background sync
+func (p *Policy) markDirty(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dirty[path] = true
+}
+
+// syncRoutine syncs the written files every interval until Close
+func (p *Policy) syncRoutine() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.SyncPending()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// SyncPending syncs the files written since the last background sync.
+// Files are synced before directories, so a synced rename never points at
+// unsynced data. Paths that fail to sync are retried on the next call.
+func (p *Policy) SyncPending() error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	paths := make([]string, 0, len(p.dirty))
+	for path := range p.dirty {
+		paths = append(paths, path)
+	}
+	p.dirty = make(map[string]bool)
+	p.mu.Unlock()
+
+	// Directories sort before their files; sync them last
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	var firstErr error
+	for _, path := range paths {
+		if err := p.syncPath(path); err != nil {
+			slog.Error("Background sync failed", "path", path, "error", err)
+			p.markDirty(path)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the number of syncs and failed syncs, and the number of
+// paths waiting for the background sync
+func (p *Policy) Stats() (syncs, failures int64, pending int) {
+	if p == nil {
+		return 0, 0, 0
+	}
+
+	p.mu.Lock()
+	pending = len(p.dirty)
+	p.mu.Unlock()
+	return p.syncs.Load(), p.failures.Load(), pending
+}
+
+// Close stops the background sync and syncs the pending files once more.
+// Further calls do nothing.
+func (p *Policy) Close() error {
+	if p == nil || p.level != FsyncInterval {
+		return nil
+	}
+
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		err = p.SyncPending()
+	})
+	return err
+}
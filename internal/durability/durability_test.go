@@ -0,0 +1,101 @@
+package durability
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile creates a file in dir and returns it open
+func writeFile(t *testing.T, dir string) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(dir, "data.csv"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+	file.WriteString("row\n")
+	return file
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"none", "flush", "fsync-per-write", "fsync-interval"} {
+		if level, err := ParseLevel(s); err != nil || string(level) != s {
+			t.Errorf("ParseLevel(%q) = %q, %v", s, level, err)
+		}
+	}
+	if _, err := ParseLevel("always"); err == nil {
+		t.Error("Expected unknown levels to be rejected")
+	}
+	if _, err := New(FsyncInterval, 0); err == nil {
+		t.Error("Expected fsync-interval without an interval to be rejected")
+	}
+}
+
+func TestPolicyLevels(t *testing.T) {
+	tests := []struct {
+		level         Level
+		syncs         int64 // After Sync, SyncCritical and SyncDir
+		criticalSyncs int64 // After SyncCritical alone
+	}{
+		{None, 0, 0},
+		{Flush, 1, 1},
+		{FsyncPerWrite, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.level), func(t *testing.T) {
+			dir := t.TempDir()
+			file := writeFile(t, dir)
+			p, err := New(tt.level, 0)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+
+			p.SyncCritical(file)
+			if syncs, _, _ := p.Stats(); syncs != tt.criticalSyncs {
+				t.Errorf("Expected %d syncs of critical files, got %d", tt.criticalSyncs, syncs)
+			}
+			p.Sync(file, file.Name())
+			p.SyncDir(dir)
+			if syncs, failures, pending := p.Stats(); syncs != tt.syncs || failures != 0 || pending != 0 {
+				t.Errorf("Expected %d syncs, got %d syncs, %d failures, %d pending", tt.syncs, syncs, failures, pending)
+			}
+		})
+	}
+
+	// A nil policy flushes
+	var p *Policy
+	if p.Level() != Flush || p.Sync(nil, "") != nil || p.SyncDir("") != nil {
+		t.Error("Expected a nil policy to behave like flush")
+	}
+}
+
+func TestPolicyInterval(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir)
+	p, err := New(FsyncInterval, time.Hour)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.Sync(file, file.Name())
+	}
+	p.SyncDir(dir)
+	p.Sync(file, filepath.Join(dir, "renamed-away.csv"))
+	if syncs, _, pending := p.Stats(); syncs != 0 || pending != 3 {
+		t.Fatalf("Expected 3 pending paths and no syncs yet, got %d pending and %d syncs", pending, syncs)
+	}
+
+	// Each path is synced once; paths that no longer exist are skipped
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if syncs, failures, pending := p.Stats(); syncs != 2 || failures != 0 || pending != 0 {
+		t.Errorf("Expected 2 syncs on close, got %d syncs, %d failures, %d pending", syncs, failures, pending)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Expected a second Close to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package faults injects latency and errors into the storage and auth
+// layers for resilience testing, e.g. of dual storage fallbacks and client
+// retries. Faults are set globally through the admin API or per request
+// through the X-Fault-Inject header, and only when fault injection is
+// enabled.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Layers faults can be injected into
+const (
+	LayerAuth  = "auth"          // Credential validation in auth.cfg
+	LayerState = "storage.state" // Terraform state storage
+	LayerCSV   = "storage.csv"   // CSV data storage, also within dual storage
+	LayerMySQL = "storage.mysql" // MySQL data storage, also within dual storage
+)
+
+// Layers lists the valid layers
+var Layers = []string{LayerAuth, LayerState, LayerCSV, LayerMySQL}
+
+// Header requests faults for the organization of a single request, e.g.
+// "storage.mysql=error, auth=latency:200ms+error:0.5"
+const Header = "X-Fault-Inject"
+
+// maxLatency bounds injected latency so a typo cannot hang requests
+const maxLatency = time.Minute
+
+// ErrInjected is wrapped by every injected error
+var ErrInjected = errors.New("injected fault")
+
+// Fault is the latency and error rate injected into a layer
+type Fault struct {
+	Latency   time.Duration
+	ErrorRate float64 // Fraction of calls that fail, 0 to 1
+}
+
+// Rule injects a fault into a layer for one organization, or for all
+// organizations when OrgID is nil
+type Rule struct {
+	Layer string
+	OrgID uuid.UUID
+	Fault
+}
+
+// Validate checks the layer and fault bounds
+func (r Rule) Validate() error {
+	if !validLayer(r.Layer) {
+		return fmt.Errorf("invalid layer %q (must be one of %s)", r.Layer, strings.Join(Layers, ", "))
+	}
+	if r.Latency < 0 || r.Latency > maxLatency {
+		return fmt.Errorf("latency must be between 0 and %v", maxLatency)
+	}
+	if r.ErrorRate < 0 || r.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// validLayer reports whether layer is one of Layers
+func validLayer(layer string) bool {
+	for _, l := range Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// Injector decides which faults to inject. A nil injector injects nothing,
+// so layers can call Inject unconditionally.
+type Injector struct {
+	mu       sync.Mutex
+	rules    []Rule                          // Set through the admin API
+	requests map[uuid.UUID]map[uint64][]Rule // Per-request rules by org ID and request
+	nextID   uint64
+
+	sleep  func(time.Duration)
+	random func() float64
+}
+
+// NewInjector creates an injector without rules
+func NewInjector() *Injector {
+	return &Injector{
+		requests: make(map[uuid.UUID]map[uint64][]Rule),
+		sleep:    time.Sleep,
+		random:   rand.Float64,
+	}
+}
+
+// SetRules replaces the global rules
+func (inj *Injector) SetRules(rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.rules = append([]Rule(nil), rules...)
+	return nil
+}
+
+// Rules returns the global rules
+func (inj *Injector) Rules() []Rule {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return append([]Rule{}, inj.rules...)
+}
+
+// Inject applies the fault matching a layer and organization: it sleeps for
+// the latency and then fails with the error rate. Per-request rules take
+// precedence over global rules, and organization rules over rules for all
+// organizations.
+func (inj *Injector) Inject(layer string, orgID uuid.UUID) error {
+	if inj == nil {
+		return nil
+	}
+
+	fault, ok := inj.match(layer, orgID)
+	if !ok {
+		return nil
+	}
+	if fault.Latency > 0 {
+		inj.sleep(fault.Latency)
+	}
+	if fault.ErrorRate > 0 && inj.random() < fault.ErrorRate {
+		return fmt.Errorf("%w in %s", ErrInjected, layer)
+	}
+	return nil
+}
+
+// match finds the fault for a layer and organization
+func (inj *Injector) match(layer string, orgID uuid.UUID) (Fault, bool) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	for _, rules := range inj.requests[orgID] {
+		for _, rule := range rules {
+			if rule.Layer == layer {
+				return rule.Fault, true
+			}
+		}
+	}
+
+	var global *Rule
+	for i, rule := range inj.rules {
+		if rule.Layer != layer {
+			continue
+		}
+		if rule.OrgID == orgID {
+			return rule.Fault, true
+		}
+		if rule.OrgID == uuid.Nil && global == nil {
+			global = &inj.rules[i]
+		}
+	}
+	if global != nil {
+		return global.Fault, true
+	}
+	return Fault{}, false
+}
+
+// ParseHeader parses the X-Fault-Inject header: comma-separated
+// layer=spec pairs, where spec joins "latency:<duration>", "error" and
+// "error:<rate>" with "+"
+func ParseHeader(value string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		layer, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q (must be layer=spec)", part)
+		}
+		rule := Rule{Layer: strings.TrimSpace(layer)}
+		for _, item := range strings.Split(spec, "+") {
+			kind, arg, _ := strings.Cut(strings.TrimSpace(item), ":")
+			switch kind {
+			case "latency":
+				latency, err := time.ParseDuration(arg)
+				if err != nil {
+					return nil, fmt.Errorf("invalid latency %q", arg)
+				}
+				rule.Latency = latency
+			case "error":
+				rule.ErrorRate = 1
+				if arg != "" {
+					rate, err := strconv.ParseFloat(arg, 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid error rate %q", arg)
+					}
+					rule.ErrorRate = rate
+				}
+			default:
+				return nil, fmt.Errorf("invalid fault %q (must be latency:<duration> or error[:<rate>])", item)
+			}
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Middleware applies the X-Fault-Inject header of a request to the
+// organization named by its X-Org-ID header while the request runs. Storage
+// and auth calls carry no request context, so concurrent requests of the
+// same organization see the faults too; use a dedicated organization for
+// fault tests.
+func (inj *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(Header)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		orgID, err := uuid.Parse(r.Header.Get("X-Org-ID"))
+		if err != nil {
+			http.Error(w, "X-Fault-Inject requires a valid X-Org-ID header", http.StatusBadRequest)
+			return
+		}
+		rules, err := ParseHeader(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid X-Fault-Inject header: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		id := inj.addRequest(orgID, rules)
+		defer inj.removeRequest(orgID, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addRequest registers the rules of a request
+func (inj *Injector) addRequest(orgID uuid.UUID, rules []Rule) uint64 {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	inj.nextID++
+	if inj.requests[orgID] == nil {
+		inj.requests[orgID] = make(map[uint64][]Rule)
+	}
+	inj.requests[orgID][inj.nextID] = rules
+	return inj.nextID
+}
+
+// removeRequest drops the rules of a finished request
+func (inj *Injector) removeRequest(orgID uuid.UUID, id uint64) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	delete(inj.requests[orgID], id)
+	if len(inj.requests[orgID]) == 0 {
+		delete(inj.requests, orgID)
+	}
+}
@@ -0,0 +1,94 @@
+package faults
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseHeader(t *testing.T) {
+	rules, err := ParseHeader("storage.mysql=error, auth=latency:200ms+error:0.25")
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %+v", rules)
+	}
+	if rules[0].Layer != LayerMySQL || rules[0].ErrorRate != 1 {
+		t.Errorf("Unexpected first rule %+v", rules[0])
+	}
+	if rules[1].Layer != LayerAuth || rules[1].Latency != 200*time.Millisecond || rules[1].ErrorRate != 0.25 {
+		t.Errorf("Unexpected second rule %+v", rules[1])
+	}
+
+	for _, value := range []string{"storage.disk=error", "auth", "auth=slow", "auth=error:2", "auth=latency:1h"} {
+		if _, err := ParseHeader(value); err == nil {
+			t.Errorf("Expected error parsing %q", value)
+		}
+	}
+}
+
+func TestInjectMatchesRules(t *testing.T) {
+	orgID, other := uuid.New(), uuid.New()
+	inj := NewInjector()
+	var slept time.Duration
+	inj.sleep = func(d time.Duration) { slept += d }
+	inj.random = func() float64 { return 0.5 }
+
+	err := inj.SetRules([]Rule{
+		{Layer: LayerCSV, Fault: Fault{ErrorRate: 0.4}},
+		{Layer: LayerCSV, OrgID: orgID, Fault: Fault{ErrorRate: 0.6, Latency: time.Second}},
+	})
+	if err != nil {
+		t.Fatalf("SetRules failed: %v", err)
+	}
+
+	if err := inj.Inject(LayerCSV, orgID); !errors.Is(err, ErrInjected) || slept != time.Second {
+		t.Errorf("Expected the org rule to delay and fail, got %v after %v", err, slept)
+	}
+	if err := inj.Inject(LayerCSV, other); err != nil {
+		t.Errorf("Expected the global rule's rate to let the call pass, got %v", err)
+	}
+	if err := inj.Inject(LayerMySQL, orgID); err != nil {
+		t.Errorf("Expected no fault in a layer without rules, got %v", err)
+	}
+
+	var nilInjector *Injector
+	if err := nilInjector.Inject(LayerCSV, orgID); err != nil {
+		t.Errorf("Expected a nil injector to inject nothing, got %v", err)
+	}
+}
+
+func TestMiddlewareScopesHeaderToRequest(t *testing.T) {
+	orgID := uuid.New()
+	inj := NewInjector()
+
+	var during error
+	handler := inj.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = inj.Inject(LayerAuth, orgID)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+	r.Header.Set("X-Org-ID", orgID.String())
+	r.Header.Set(Header, "auth=error")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if !errors.Is(during, ErrInjected) {
+		t.Errorf("Expected the header fault during the request, got %v", during)
+	}
+	if err := inj.Inject(LayerAuth, orgID); err != nil {
+		t.Errorf("Expected the header fault to end with the request, got %v", err)
+	}
+
+	r.Header.Set(Header, "auth=explode")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid header, got %d", w.Code)
+	}
+}
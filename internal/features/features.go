@@ -0,0 +1,98 @@
+// Package features gates experimental endpoints behind feature flags, so
+// they can ship before their API is final. Flags are read from the
+// configuration once and decide which routes the router registers; a
+// disabled endpoint does not exist rather than answering with an error.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature flags
+const (
+	UploadV2 = "upload_v2" // POST /api/v2/upload
+)
+
+// Flag describes a feature flag
+type Flag struct {
+	Name        string
+	Description string
+	Default     bool // Whether the flag is on when not configured
+}
+
+// Flags lists every known feature flag. Flags whose endpoints were
+// released before flags existed default to on, so upgrades keep them.
+var Flags = []Flag{
+	{Name: UploadV2, Description: "Version 2 of the upload API (POST /api/v2/upload)", Default: true},
+}
+
+// Set is the state of every feature flag. The zero value has every flag
+// at its default.
+type Set struct {
+	overrides map[string]bool
+}
+
+// Parse parses a comma-separated list of flags to enable; flags prefixed
+// with "-" are disabled. Unknown flags are an error so typos are caught at
+// startup.
+func Parse(value string) (Set, error) {
+	set := Set{overrides: make(map[string]bool)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, disabled := strings.CutPrefix(entry, "-")
+		if lookup(name) == nil {
+			return Set{}, fmt.Errorf("unknown feature flag %q (known: %s)", name, strings.Join(names(), ", "))
+		}
+		set.overrides[name] = !disabled
+	}
+	return set, nil
+}
+
+// Enabled reports whether a flag is on
+func (s Set) Enabled(name string) bool {
+	if enabled, ok := s.overrides[name]; ok {
+		return enabled
+	}
+	if flag := lookup(name); flag != nil {
+		return flag.Default
+	}
+	return false
+}
+
+// List returns the names of the enabled flags, sorted
+func (s Set) List() []string {
+	enabled := []string{}
+	for _, flag := range Flags {
+		if s.Enabled(flag.Name) {
+			enabled = append(enabled, flag.Name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// lookup returns the known flag with the given name, or nil
+func lookup(name string) *Flag {
+	for i := range Flags {
+		if Flags[i].Name == name {
+			return &Flags[i]
+		}
+	}
+	return nil
+}
+
+// names returns the names of all known flags
+func names() []string {
+	all := make([]string, 0, len(Flags))
+	for _, flag := range Flags {
+		all = append(all, flag.Name)
+	}
+	sort.Strings(all)
+	return all
+}
@@ -0,0 +1,54 @@
+package features
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZeroSetUsesDefaults(t *testing.T) {
+	var set Set
+	if !set.Enabled(UploadV2) {
+		t.Error("Expected upload_v2 to be on by default")
+	}
+	if set.Enabled("unknown") {
+		t.Error("Expected unknown flags to be off")
+	}
+	if !reflect.DeepEqual(set.List(), []string{UploadV2}) {
+		t.Errorf("Unexpected enabled flags %v", set.List())
+	}
+}
+
+func TestParse(t *testing.T) {
+	set, err := Parse(" -upload_v2 , ")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if set.Enabled(UploadV2) {
+		t.Error("Expected upload_v2 to be disabled")
+	}
+	if len(set.List()) != 0 {
+		t.Errorf("Expected no enabled flags, got %v", set.List())
+	}
+
+	set, err = Parse("upload_v2")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !set.Enabled(UploadV2) {
+		t.Error("Expected upload_v2 to be enabled")
+	}
+
+	set, err = Parse("")
+	if err != nil || !set.Enabled(UploadV2) {
+		t.Errorf("Expected defaults for an empty list, got %v, %v", set.List(), err)
+	}
+}
+
+func TestParseUnknownFlag(t *testing.T) {
+	if _, err := Parse("upload_v3"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+	if _, err := Parse("-upload_v3"); err == nil {
+		t.Error("Expected error for unknown disabled flag")
+	}
+}
@@ -0,0 +1,102 @@
+// Package fsperm checks that credentials, TLS keys and data directories are
+// not exposed to other local users by their file permissions.
+package fsperm
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Modes of the startup permission check
+const (
+	ModeEnforce = "enforce" // Refuse to start on insecure permissions
+	ModeWarn    = "warn"    // Log insecure permissions and start anyway
+	ModeOff     = "off"     // Skip the check
+)
+
+// Rule forbids permission bits on a path. Missing paths pass, since
+// features that are not configured have nothing to protect.
+type Rule struct {
+	Path      string
+	Forbidden os.FileMode // Permission bits the path must not have
+	Reason    string      // What the forbidden bits expose, e.g. "world-readable"
+}
+
+// AuthConfig forbids any access by other users to the credentials file
+func AuthConfig(path string) Rule {
+	return Rule{Path: path, Forbidden: 0007, Reason: "accessible by other users"}
+}
+
+// DataDir forbids others from writing to the data directory, where they
+// could replace stored records
+func DataDir(path string) Rule {
+	return Rule{Path: path, Forbidden: 0022, Reason: "writable by group or other users"}
+}
+
+// PrivateKey requires a private key to be 0600 or stricter
+func PrivateKey(path string) Rule {
+	return Rule{Path: path, Forbidden: 0177, Reason: "not 0600"}
+}
+
+// Problem is a path whose permissions break a rule
+type Problem struct {
+	Rule
+	Mode os.FileMode // Current permission bits
+}
+
+// String describes the problem and how to fix it by hand
+func (p Problem) String() string {
+	return fmt.Sprintf("%s has mode %04o and is %s (chmod %04o %s)", p.Path, p.Mode, p.Reason, p.Fixed(), p.Path)
+}
+
+// Fixed returns the mode with the forbidden bits removed
+func (p Problem) Fixed() os.FileMode {
+	return p.Mode &^ p.Forbidden
+}
+
+// Check returns the rules the paths currently break. Permission bits do
+// not apply on Windows, where nothing is checked.
+func Check(rules []Rule) ([]Problem, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+
+	var problems []Problem
+	for _, rule := range rules {
+		if rule.Path == "" {
+			continue
+		}
+		info, err := os.Stat(rule.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check permissions of %s: %w", rule.Path, err)
+		}
+		if mode := info.Mode().Perm(); mode&rule.Forbidden != 0 {
+			problems = append(problems, Problem{Rule: rule, Mode: mode})
+		}
+	}
+	return problems, nil
+}
+
+// Fix removes the forbidden bits of every problem
+func Fix(problems []Problem) error {
+	for _, p := range problems {
+		if err := os.Chmod(p.Path, p.Fixed()); err != nil {
+			return fmt.Errorf("failed to fix permissions of %s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
+
+// Error summarizes problems as a single error
+func Error(problems []Problem) error {
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = p.String()
+	}
+	return fmt.Errorf("insecure file permissions:\n  %s", strings.Join(lines, "\n  "))
+}
@@ -0,0 +1,63 @@
+package fsperm
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCheckAndFix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Permission bits do not apply on Windows")
+	}
+
+	dir := t.TempDir()
+	authConfig := filepath.Join(dir, "auth.cfg")
+	dataDir := filepath.Join(dir, "data")
+	keyFile := filepath.Join(dir, "server.key")
+	for path, mode := range map[string]os.FileMode{authConfig: 0644, keyFile: 0640} {
+		if err := os.WriteFile(path, []byte("secret"), mode); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(path, mode)
+	}
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(dataDir, 0775)
+
+	rules := []Rule{
+		AuthConfig(authConfig),
+		DataDir(dataDir),
+		PrivateKey(keyFile),
+		PrivateKey(filepath.Join(dir, "missing.key")),
+	}
+	problems, err := Check(rules)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(problems) != 3 {
+		t.Fatalf("Expected 3 problems, got %v", problems)
+	}
+	if Error(problems) == nil {
+		t.Error("Expected a summary error")
+	}
+
+	if err := Fix(problems); err != nil {
+		t.Fatalf("Fix failed: %v", err)
+	}
+	for path, want := range map[string]os.FileMode{authConfig: 0640, dataDir: 0755, keyFile: 0600} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("Expected %s to have mode %04o after fix, got %04o", path, want, info.Mode().Perm())
+		}
+	}
+
+	if problems, err := Check(rules); err != nil || len(problems) != 0 {
+		t.Errorf("Expected no problems after fix, got %v (%v)", problems, err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package geoip resolves client IPs to countries with a local database and
+// enforces country allow and deny lists, globally and per organization.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ipRange maps the addresses from start to end to a country
+type ipRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+}
+
+// DB is an in-memory country database
+type DB struct {
+	ranges []ipRange // Sorted by start address, IPv4 before IPv6
+}
+
+// Open loads a country database in IP range CSV format: every row holds
+// the first and last address of a range and an ISO 3166-1 alpha-2 country
+// code, followed by optional columns. Addresses are either in text form
+// (DB-IP lite) or decimal integers (IP2Location LITE).
+func Open(filePath string) (*DB, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer file.Close()
+
+	db, err := Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GeoIP database %s: %w", filePath, err)
+	}
+	return db, nil
+}
+
+// Load reads a country database in IP range CSV format
+func Load(r io.Reader) (*DB, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected start, end and country columns", line)
+		}
+
+		// Unassigned ranges carry "-" instead of a country
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if country == "" || country == "-" {
+			continue
+		}
+		if !ValidCountry(country) {
+			return nil, fmt.Errorf("line %d: invalid country code %q", line, record[2])
+		}
+
+		start, err := parseAddr(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		end, err := parseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if start.BitLen() != end.BitLen() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, start, end)
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, country: country})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+// parseAddr parses an address in text or decimal integer form. IPv4-mapped
+// IPv6 addresses are converted to IPv4.
+func parseAddr(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, ".:") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid address %q", value)
+		}
+		return addr.Unmap(), nil
+	}
+
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return netip.Addr{}, fmt.Errorf("invalid address %q", value)
+	}
+	if n.BitLen() <= 32 {
+		var b [4]byte
+		return netip.AddrFrom4([4]byte(n.FillBytes(b[:]))), nil
+	}
+	var b [16]byte
+	return netip.AddrFrom16([16]byte(n.FillBytes(b[:]))).Unmap(), nil
+}
+
+// Len returns the number of ranges in the database
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
+
+// Country returns the country code of a client address (with or without a
+// port), or false if the address is not in the database
+func (db *DB) Country(remoteAddr string) (string, bool) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	// The last range starting at or before the address is the only one
+	// that can contain it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return "", false
+	}
+	r := db.ranges[i]
+	if r.start.BitLen() != addr.BitLen() || r.end.Less(addr) {
+		return "", false
+	}
+	return r.country, true
+}
+
+// ValidCountry checks that code is an upper-case ISO 3166-1 alpha-2 code
+func ValidCountry(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package geoip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+const testDB = `1.0.0.0,1.0.0.255,AU
+"16777472","16778239","CN","China"
+10.0.0.0,10.255.255.255,-
+2001:db8::,2001:db8::ffff,DE
+81.2.69.0,81.2.69.255,gb
+`
+
+func loadTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Load(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return db
+}
+
+func TestDBCountry(t *testing.T) {
+	db := loadTestDB(t)
+	if db.Len() != 4 {
+		t.Fatalf("Expected 4 ranges (unassigned skipped), got %d", db.Len())
+	}
+
+	tests := []struct {
+		addr    string
+		country string
+		known   bool
+	}{
+		{"1.0.0.1:4000", "AU", true},
+		{"1.0.1.10", "CN", true}, // 16777472 = 1.0.1.0
+		{"1.0.3.255", "CN", true},
+		{"1.0.4.0", "", false},
+		{"10.1.2.3", "", false},
+		{"[2001:db8::1]:443", "DE", true},
+		{"::ffff:81.2.69.160", "GB", true},
+		{"2001:db9::1", "", false},
+		{"not-an-ip", "", false},
+	}
+	for _, tt := range tests {
+		country, known := db.Country(tt.addr)
+		if country != tt.country || known != tt.known {
+			t.Errorf("Country(%q) = %q, %v; want %q, %v", tt.addr, country, known, tt.country, tt.known)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidRows(t *testing.T) {
+	for _, data := range []string{
+		"1.0.0.0,1.0.0.255\n",
+		"1.0.0.0,1.0.0.255,USA\n",
+		"1.0.0.255,1.0.0.0,US\n",
+		"1.0.0.0,2001:db8::,US\n",
+		"x,1.0.0.255,US\n",
+	} {
+		if _, err := Load(strings.NewReader(data)); err == nil {
+			t.Errorf("Expected error loading %q", data)
+		}
+	}
+}
+
+func TestListsAllows(t *testing.T) {
+	deny := Lists{Deny: []string{"CN"}}
+	allow := Lists{Allow: []string{"US", "DE"}, Deny: []string{"DE"}}
+
+	tests := []struct {
+		lists        Lists
+		country      string
+		known        bool
+		allowUnknown bool
+		want         bool
+	}{
+		{deny, "CN", true, false, false},
+		{deny, "US", true, false, true},
+		{deny, "", false, false, true},
+		{allow, "US", true, false, true},
+		{allow, "DE", true, false, false}, // Deny wins
+		{allow, "FR", true, false, false},
+		{allow, "", false, false, false},
+		{allow, "", false, true, true},
+	}
+	for _, tt := range tests {
+		if got := tt.lists.Allows(tt.country, tt.known, tt.allowUnknown); got != tt.want {
+			t.Errorf("%+v.Allows(%q, %v, %v) = %v, want %v", tt.lists, tt.country, tt.known, tt.allowUnknown, got, tt.want)
+		}
+	}
+}
+
+func TestStorePersistsLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.json")
+	orgID := uuid.New()
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	lists, err := store.Set(orgID, Lists{Deny: []string{"ru", "CN", "RU"}})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if strings.Join(lists.Deny, ",") != "CN,RU" {
+		t.Errorf("Expected normalized deny list CN,RU, got %v", lists.Deny)
+	}
+	if _, err := store.Set(orgID, Lists{Allow: []string{"USA"}}); err == nil {
+		t.Error("Expected error for an invalid country code")
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if got, ok := reloaded.Get(orgID); !ok || strings.Join(got.Deny, ",") != "CN,RU" {
+		t.Errorf("Expected persisted lists, got %+v (%v)", got, ok)
+	}
+}
+
+func TestEnforcerMiddleware(t *testing.T) {
+	orgID := uuid.New()
+	store, _ := NewStore("")
+	store.Set(orgID, Lists{Deny: []string{"AU"}})
+	enforcer := NewEnforcer(loadTestDB(t), Lists{Deny: []string{"CN"}}, store, true)
+
+	var seen string
+	handler := enforcer.Middleware(enforcer.OrgMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = CountryFromContext(r.Context())
+	})))
+
+	tests := []struct {
+		addr string
+		org  uuid.UUID
+		want int
+	}{
+		{"1.0.1.1:1000", uuid.New(), http.StatusForbidden}, // Global deny
+		{"1.0.0.1:1000", uuid.New(), http.StatusOK},
+		{"1.0.0.1:1000", orgID, http.StatusForbidden}, // Org deny
+		{"81.2.69.1:1000", orgID, http.StatusOK},
+		{"10.0.0.1:1000", orgID, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		r.RemoteAddr = tt.addr
+		r = r.WithContext(reqctx.WithOrgID(r.Context(), tt.org))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Request from %s: expected %d, got %d", tt.addr, tt.want, w.Code)
+		}
+	}
+	if seen != "" {
+		t.Errorf("Expected no country for an unknown address, got %q", seen)
+	}
+}
@@ -0,0 +1,199 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Lists restricts the countries requests may come from. An empty allow
+// list allows every country that is not denied.
+type Lists struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// ParseCountries splits a comma-separated list of country codes
+func ParseCountries(s string) ([]string, error) {
+	var countries []string
+	for _, part := range strings.Split(s, ",") {
+		code := strings.ToUpper(strings.TrimSpace(part))
+		if code == "" {
+			continue
+		}
+		if !ValidCountry(code) {
+			return nil, fmt.Errorf("invalid country code %q (must be ISO 3166-1 alpha-2)", part)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+// Validate checks every country code of both lists
+func (l Lists) Validate() error {
+	_, err := l.normalize()
+	return err
+}
+
+// normalize upper-cases, validates, sorts and deduplicates both lists
+func (l Lists) normalize() (Lists, error) {
+	allow, err := ParseCountries(strings.Join(l.Allow, ","))
+	if err != nil {
+		return Lists{}, err
+	}
+	deny, err := ParseCountries(strings.Join(l.Deny, ","))
+	if err != nil {
+		return Lists{}, err
+	}
+	return Lists{Allow: sortedUnique(allow), Deny: sortedUnique(deny)}, nil
+}
+
+// sortedUnique returns a sorted copy of values without duplicates
+func sortedUnique(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(out, value) {
+			out = append(out, value)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Allows reports whether requests from country pass the lists. Requests
+// whose country is unknown only pass an allow list if allowUnknown is set.
+func (l Lists) Allows(country string, known, allowUnknown bool) bool {
+	if !known {
+		return len(l.Allow) == 0 || allowUnknown
+	}
+	if slices.Contains(l.Deny, country) {
+		return false
+	}
+	return len(l.Allow) == 0 || slices.Contains(l.Allow, country)
+}
+
+// Store keeps the country lists of all organizations and persists them to
+// a JSON file on every change
+type Store struct {
+	mu       sync.RWMutex
+	orgs     map[uuid.UUID]Lists
+	filePath string
+}
+
+// NewStore creates a store backed by the given file. Existing lists are
+// loaded from the file if it exists. An empty path keeps the lists in
+// memory only.
+func NewStore(filePath string) (*Store, error) {
+	s := &Store{
+		orgs:     make(map[uuid.UUID]Lists),
+		filePath: filePath,
+	}
+
+	if filePath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP lists file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(data, &s.orgs); err != nil {
+		return nil, fmt.Errorf("failed to parse GeoIP lists file %s: %w", filePath, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the country lists of an organization
+func (s *Store) Get(orgID uuid.UUID) (Lists, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, exists := s.orgs[orgID]
+	return l, exists
+}
+
+// Set replaces the country lists of an organization
+func (s *Store) Set(orgID uuid.UUID, l Lists) (Lists, error) {
+	l, err := l.normalize()
+	if err != nil {
+		return Lists{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	s.orgs[orgID] = l
+	if err := s.save(); err != nil {
+		if existed {
+			s.orgs[orgID] = previous
+		} else {
+			delete(s.orgs, orgID)
+		}
+		return Lists{}, err
+	}
+	return l, nil
+}
+
+// Delete removes the country lists of an organization. It reports whether
+// lists existed.
+func (s *Store) Delete(orgID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	if !existed {
+		return false, nil
+	}
+
+	delete(s.orgs, orgID)
+	if err := s.save(); err != nil {
+		s.orgs[orgID] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes all lists to disk. The file is replaced atomically so a
+// crash never leaves a partial file. The caller must hold the write lock.
+func (s *Store) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.orgs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal GeoIP lists: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create GeoIP lists directory: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write GeoIP lists file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to replace GeoIP lists file: %w", err)
+	}
+
+	return nil
+}
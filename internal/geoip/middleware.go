@@ -0,0 +1,95 @@
+package geoip
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// contextKey is the context key of the resolved country
+type contextKey struct{}
+
+// CountryFromContext returns the country resolved for the request, or
+// false if it is unknown
+func CountryFromContext(ctx context.Context) (string, bool) {
+	country, ok := ctx.Value(contextKey{}).(string)
+	return country, ok
+}
+
+// Enforcer resolves request countries and enforces the global and
+// per-organization country lists
+type Enforcer struct {
+	db           *DB
+	global       Lists
+	orgs         *Store
+	allowUnknown bool
+}
+
+// NewEnforcer creates an enforcer. orgs may be nil when only the global
+// lists apply. With allowUnknown, requests from addresses missing from the
+// database pass allow lists.
+func NewEnforcer(db *DB, global Lists, orgs *Store, allowUnknown bool) *Enforcer {
+	return &Enforcer{
+		db:           db,
+		global:       global,
+		orgs:         orgs,
+		allowUnknown: allowUnknown,
+	}
+}
+
+// Store returns the per-organization lists, or nil
+func (e *Enforcer) Store() *Store {
+	return e.orgs
+}
+
+// Middleware resolves the request country, records it for security events
+// and rejects requests denied by the global lists. It must run after the
+// client IP is resolved.
+func (e *Enforcer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country, known := e.db.Country(r.RemoteAddr)
+		if known {
+			ctx := context.WithValue(r.Context(), contextKey{}, country)
+			r = r.WithContext(security.WithCountry(ctx, country))
+		}
+
+		if !e.global.Allows(country, known, e.allowUnknown) {
+			deny(w, r, "", "global")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OrgMiddleware rejects requests denied by the organization's lists. It
+// must run after authentication and Middleware.
+func (e *Enforcer) OrgMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, ok := auth.GetOrgIDFromContext(r.Context())
+		if !ok || e.orgs == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lists, exists := e.orgs.Get(orgID)
+		if exists {
+			country, known := CountryFromContext(r.Context())
+			if !lists.Allows(country, known, e.allowUnknown) {
+				deny(w, r, orgID.String(), "org")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deny records a security event and rejects the request
+func deny(w http.ResponseWriter, r *http.Request, orgID, scope string) {
+	security.Emit(security.Request(r, "geoip.denied", security.SeverityWarning, "Request denied by country lists").
+		WithOrg(orgID).With("lists", scope))
+	http.Error(w, "Access from your location is not allowed", http.StatusForbidden)
+}
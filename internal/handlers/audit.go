@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/audit"
+)
+
+// defaultAuditExportRange applies when an export request sets no ?from
+const defaultAuditExportRange = 24 * time.Hour
+
+// AuditHandler exports the state access audit log
+type AuditHandler struct {
+	log *audit.Log
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(auditLog *audit.Log) *AuditHandler {
+	return &AuditHandler{
+		log: auditLog,
+	}
+}
+
+// ExportState handles GET requests for the state access events of an
+// organization between ?from and ?to (RFC 3339; default the last 24 hours
+// up to now), in the layout of CloudTrail log files
+func (h *AuditHandler) ExportState(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid to %q: must be an RFC 3339 timestamp", value), http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-defaultAuditExportRange)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid from %q: must be an RFC 3339 timestamp", value), http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	events, err := h.log.Export(orgID, from, to)
+	if errors.Is(err, audit.ErrInvalidRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export audit events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(audit.Export{Records: events})
+}
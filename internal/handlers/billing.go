@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/usage"
+)
+
+// BillingHandler exposes usage accounting data for chargeback
+type BillingHandler struct {
+	meter     *usage.Meter
+	csvFormat csvfmt.Format
+}
+
+// NewBillingHandler creates a new billing handler
+func NewBillingHandler(meter *usage.Meter) *BillingHandler {
+	return &BillingHandler{
+		meter:     meter,
+		csvFormat: csvfmt.Default,
+	}
+}
+
+// SetCSVFormat sets the default CSV dialect of exports
+func (h *BillingHandler) SetCSVFormat(format csvfmt.Format) {
+	h.csvFormat = format
+}
+
+// csvFormatParams applies the delimiter, quote and bom query parameters to
+// the default export dialect
+func csvFormatParams(r *http.Request, format csvfmt.Format) (csvfmt.Format, error) {
+	query := r.URL.Query()
+	if value := query.Get("delimiter"); value != "" {
+		delimiter, err := csvfmt.ParseDelimiter(value)
+		if err != nil {
+			return format, err
+		}
+		format.Delimiter = delimiter
+	}
+	if value := query.Get("quote"); value != "" {
+		quoteAll, err := csvfmt.ParseQuote(value)
+		if err != nil {
+			return format, err
+		}
+		format.QuoteAll = quoteAll
+	}
+	if value := query.Get("bom"); value != "" {
+		bom, err := strconv.ParseBool(value)
+		if err != nil {
+			return format, fmt.Errorf("invalid bom %q: must be true or false", value)
+		}
+		format.BOM = bom
+	}
+	return format, nil
+}
+
+// monthParam returns the requested billing month, defaulting to the current UTC month
+func monthParam(r *http.Request) string {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	return month
+}
+
+// GetBilling handles GET requests for the monthly usage report
+func (h *BillingHandler) GetBilling(w http.ResponseWriter, r *http.Request) {
+	month := monthParam(r)
+
+	reports, err := h.meter.MonthlyReport(month)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month": month,
+		"count": len(reports),
+		"orgs":  reports,
+	})
+}
+
+// ExportBilling handles GET requests for the monthly usage report as CSV.
+// The delimiter, quote and bom query parameters override the configured
+// dialect for this export.
+func (h *BillingHandler) ExportBilling(w http.ResponseWriter, r *http.Request) {
+	month := monthParam(r)
+
+	format, err := csvFormatParams(r, h.csvFormat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reports, err := h.meter.MonthlyReport(month)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"billing-%s.csv\"", month))
+	w.WriteHeader(http.StatusOK)
+	if err := usage.WriteCSV(w, reports, format); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write billing export", "month", month, "error", err)
+	}
+}
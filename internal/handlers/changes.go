@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/google/uuid"
+)
+
+// Page size limits of change feed requests
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// ChangeRecorder records changes to an organization's data and states for
+// the change feed
+type ChangeRecorder interface {
+	Record(orgID uuid.UUID, change changes.Change) error
+}
+
+// recordChange records a change, logging failures; the change itself was
+// already stored, so the request still succeeds
+func recordChange(recorder ChangeRecorder, orgID uuid.UUID, change changes.Change) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Record(orgID, change); err != nil {
+		slog.Error("Failed to record change", "org_id", orgID, "type", change.Type, "error", err)
+	}
+}
+
+// ChangeHandler serves the organization change feed
+type ChangeHandler struct {
+	changes *changes.Log
+}
+
+// NewChangeHandler creates a new change feed handler
+func NewChangeHandler(changeLog *changes.Log) *ChangeHandler {
+	return &ChangeHandler{
+		changes: changeLog,
+	}
+}
+
+// GetChanges handles GET requests for the organization's changes after
+// sequence number ?since, up to ?limit. State changes the key cannot read
+// are omitted but still advance next_since.
+func (h *ChangeHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	var since int64
+	if value := query.Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil || since < 0 {
+			http.Error(w, "invalid since: must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultChangesLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxChangesLimit {
+			http.Error(w, fmt.Sprintf("invalid limit: must be between 1 and %d", maxChangesLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, latest, err := h.changes.Since(orgID, since, limit)
+	if errors.Is(err, changes.ErrExpired) {
+		http.Error(w, fmt.Sprintf("Changes after %d are no longer retained. Resync with /api/v1/data/full-sync", since), http.StatusGone)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read changes", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to read changes", http.StatusInternalServerError)
+		return
+	}
+
+	next := since
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Seq
+	}
+
+	key, _ := auth.GetKeyFromContext(r.Context())
+	visible := entries[:0]
+	for _, change := range entries {
+		if change.Resource == changes.ResourceState && !key.CanAccessState(change.StateName, false) {
+			continue
+		}
+		visible = append(visible, change)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":     orgID.String(),
+		"count":      len(visible),
+		"changes":    visible,
+		"next_since": next,
+		"latest_seq": latest,
+		"has_more":   next < latest,
+	})
+}
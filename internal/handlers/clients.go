@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/clients"
+)
+
+// ClientHandler reports which client versions upload data and write state
+type ClientHandler struct {
+	tracker *clients.Tracker
+}
+
+// NewClientHandler creates a new client version handler
+func NewClientHandler(tracker *clients.Tracker) *ClientHandler {
+	return &ClientHandler{
+		tracker: tracker,
+	}
+}
+
+// GetClients handles GET requests for the uploads and state writes of each
+// client version since the server started, optionally of one client
+// (?name=)
+func (h *ClientHandler) GetClients(w http.ResponseWriter, r *http.Request) {
+	report := h.tracker.Report(r.URL.Query().Get("name"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(report),
+		"clients": report,
+	})
+}
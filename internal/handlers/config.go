@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/config"
+)
+
+// ConfigHandler reports the effective configuration of the instance
+type ConfigHandler struct {
+	file     string
+	settings []config.Setting
+}
+
+// NewConfigHandler creates a new config handler for the settings resolved
+// at startup; file is the config file they were loaded from, if any
+func NewConfigHandler(file string, settings []config.Setting) *ConfigHandler {
+	return &ConfigHandler{
+		file:     file,
+		settings: settings,
+	}
+}
+
+// ConfigResponse is the effective configuration
+type ConfigResponse struct {
+	File     string           `json:"file,omitempty"` // Empty when read from the environment
+	Settings []config.Setting `json:"settings"`
+}
+
+// GetConfig handles GET requests for every setting with its effective
+// value (secrets redacted) and whether it came from the config file, the
+// environment, a command-line flag or the default
+func (h *ConfigHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ConfigResponse{File: h.file, Settings: h.settings})
+}
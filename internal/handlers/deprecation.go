@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/deprecation"
+)
+
+// DeprecationHandler reports which organizations still use deprecated APIs
+type DeprecationHandler struct {
+	tracker *deprecation.Tracker
+}
+
+// NewDeprecationHandler creates a new deprecation usage handler
+func NewDeprecationHandler(tracker *deprecation.Tracker) *DeprecationHandler {
+	return &DeprecationHandler{
+		tracker: tracker,
+	}
+}
+
+// GetUsage handles GET requests for the organizations that used each
+// deprecated endpoint or parameter since the server started
+func (h *DeprecationHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deprecations": h.tracker.Report(),
+	})
+}
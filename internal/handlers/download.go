@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/download"
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// downloadPath is where download tokens are redeemed
+const downloadPath = "/api/v1/downloads/"
+
+// downloadResource serves a downloadable resource
+type downloadResource struct {
+	scope   string // Scope the issuing key needs
+	pattern string // Route pattern the download is served as
+	handler http.Handler
+}
+
+// DownloadHandler issues and serves signed download URLs
+type DownloadHandler struct {
+	signer      *download.Signer
+	credentials auth.CredentialStore
+	resources   map[string]downloadResource
+}
+
+// NewDownloadHandler creates a new download handler. If the credential
+// store implements auth.KeyLookup, a URL stops working once the key that
+// issued it is revoked.
+func NewDownloadHandler(signer *download.Signer, credentials auth.CredentialStore) *DownloadHandler {
+	return &DownloadHandler{
+		signer:      signer,
+		credentials: credentials,
+		resources:   make(map[string]downloadResource),
+	}
+}
+
+// SetResource makes a resource downloadable. The handler serves downloads
+// as a request to pattern (e.g. /api/v1/state/{name}) by the issuing key,
+// so the key's scopes are checked again on every download.
+func (h *DownloadHandler) SetResource(resource, scope, pattern string, handler http.Handler) {
+	h.resources[resource] = downloadResource{scope: scope, pattern: pattern, handler: handler}
+}
+
+// DownloadRequest is the body of a download URL request
+type DownloadRequest struct {
+	Resource string `json:"resource"`           // "state" or "data"
+	Name     string `json:"name,omitempty"`     // State name
+	Query    string `json:"query,omitempty"`    // Query of data exports, e.g. "fields=type,name"
+	TTL      string `json:"ttl,omitempty"`      // Lifetime, e.g. "1h" (default 15m)
+	OneTime  bool   `json:"one_time,omitempty"` // Only allow a single download
+}
+
+// DownloadResponse is an issued download URL
+type DownloadResponse struct {
+	URL       string    `json:"url"` // Relative to the server's base URL
+	ExpiresAt time.Time `json:"expires_at"`
+	OneTime   bool      `json:"one_time"`
+}
+
+// CreateURL handles POST requests for a signed URL downloading a state or a
+// data export without credentials
+func (h *DownloadHandler) CreateURL(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Chained stores without key support authenticate with an empty key
+	key, hasKey := auth.GetKeyFromContext(r.Context())
+	hasKey = hasKey && key.Key != ""
+
+	var req DownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode download request: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	resource, ok := h.resources[req.Resource]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid resource %q", req.Resource), http.StatusBadRequest)
+		return
+	}
+
+	ttl := download.DefaultTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid ttl %q: %v", req.TTL, err), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+	if ttl <= 0 || ttl > h.signer.MaxTTL() {
+		http.Error(w, fmt.Sprintf("Invalid ttl %v: must be positive and at most %v", ttl, h.signer.MaxTTL()), http.StatusBadRequest)
+		return
+	}
+
+	// A key can only share what it can read itself
+	if !key.HasScope(resource.scope) {
+		http.Error(w, fmt.Sprintf("API key lacks required scope %s", resource.scope), http.StatusForbidden)
+		return
+	}
+
+	grant := download.Grant{OrgID: orgID.String(), Resource: req.Resource, OneTime: req.OneTime}
+	if hasKey {
+		grant.KeyID = key.ID()
+	}
+	switch req.Resource {
+	case download.ResourceState:
+		if err := validation.ValidateStateName(req.Name); err != nil {
+			http.Error(w, "Invalid state name", http.StatusBadRequest)
+			return
+		}
+		if !authorizeState(w, r, orgID, req.Name, false) {
+			return
+		}
+		grant.Name = req.Name
+	case download.ResourceData:
+		if _, err := url.ParseQuery(req.Query); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		grant.Query = req.Query
+	}
+
+	token, expiresAt, err := h.signer.Sign(grant, ttl)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to sign download URL", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to issue download URL", http.StatusInternalServerError)
+		return
+	}
+
+	event := security.Request(r, "download.url_issued", security.SeverityInfo, "Download URL issued").
+		WithOrg(orgID.String()).With("resource", req.Resource).With("expires_at", expiresAt.Format(time.RFC3339))
+	if grant.Name != "" {
+		event = event.With("state", grant.Name)
+	}
+	if hasKey {
+		event = event.WithKey(grant.KeyID)
+	}
+	security.Emit(event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(DownloadResponse{
+		URL:       downloadPath + token,
+		ExpiresAt: expiresAt,
+		OneTime:   req.OneTime,
+	})
+}
+
+// Download handles GET requests redeeming a download token. It needs no
+// credentials: the resource is served as if the issuing key requested it.
+func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
+	grant, err := h.signer.Redeem(chi.URLParam(r, "token"))
+	if err != nil {
+		security.Emit(security.Request(r, "download.rejected", security.SeverityWarning, "Rejected download token").
+			With("error", err))
+		if errors.Is(err, download.ErrExpired) || errors.Is(err, download.ErrUsed) {
+			http.Error(w, "Download URL expired or already used", http.StatusGone)
+			return
+		}
+		http.Error(w, "Invalid download URL", http.StatusForbidden)
+		return
+	}
+
+	orgID, err := uuid.Parse(grant.OrgID)
+	resource, ok := h.resources[grant.Resource]
+	if err != nil || !ok {
+		http.Error(w, "Invalid download URL", http.StatusForbidden)
+		return
+	}
+
+	ctx := reqctx.WithOrgID(r.Context(), orgID)
+	if lookup, ok := h.credentials.(auth.KeyLookup); ok && grant.KeyID != "" {
+		key, found := lookup.LookupKey(orgID, grant.KeyID)
+		if !found {
+			security.Emit(security.Request(r, "download.revoked_key", security.SeverityWarning, "Download URL of revoked key").
+				WithOrg(orgID.String()).WithKey(grant.KeyID))
+			http.Error(w, "Download URL was issued by a revoked key", http.StatusForbidden)
+			return
+		}
+		ctx = context.WithValue(ctx, auth.KeyContextKey, key)
+	}
+
+	// Route the download to the resource's handler with its parameters
+	rctx := chi.NewRouteContext()
+	rctx.RoutePatterns = []string{resource.pattern}
+	if grant.Name != "" {
+		rctx.URLParams.Add("name", grant.Name)
+	}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+
+	target := r.Clone(ctx)
+	target.URL.RawQuery = grant.Query
+
+	security.Emit(security.Request(r, "download.redeemed", security.SeverityInfo, "Download URL redeemed").
+		WithOrg(orgID.String()).WithKey(grant.KeyID).With("resource", grant.Resource))
+
+	w.Header().Set("Cache-Control", "no-store")
+	if grant.Resource == download.ResourceState {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", grant.Name+".tfstate"))
+	}
+	resource.handler.ServeHTTP(w, target)
+}
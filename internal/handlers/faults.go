@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+// FaultHandler manages the global fault injection rules
+type FaultHandler struct {
+	injector *faults.Injector
+}
+
+// NewFaultHandler creates a new fault injection handler
+func NewFaultHandler(injector *faults.Injector) *FaultHandler {
+	return &FaultHandler{
+		injector: injector,
+	}
+}
+
+// FaultRule is a fault injection rule in requests and responses
+type FaultRule struct {
+	Layer     string  `json:"layer"`
+	OrgID     string  `json:"org_id,omitempty"`  // Empty applies to all organizations
+	Latency   string  `json:"latency,omitempty"` // Duration, e.g. "200ms"
+	ErrorRate float64 `json:"error_rate,omitempty"`
+}
+
+// FaultRulesRequest is the body of PUT /admin/v1/faults
+type FaultRulesRequest struct {
+	Rules []FaultRule `json:"rules"`
+}
+
+// writeFaultRules writes the current global rules
+func (h *FaultHandler) writeFaultRules(w http.ResponseWriter) {
+	rules := []FaultRule{}
+	for _, rule := range h.injector.Rules() {
+		out := FaultRule{Layer: rule.Layer, ErrorRate: rule.ErrorRate}
+		if rule.OrgID != uuid.Nil {
+			out.OrgID = rule.OrgID.String()
+		}
+		if rule.Latency > 0 {
+			out.Latency = rule.Latency.String()
+		}
+		rules = append(rules, out)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"layers": faults.Layers,
+		"rules":  rules,
+	})
+}
+
+// GetRules handles GET requests for the global fault injection rules
+func (h *FaultHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	h.writeFaultRules(w)
+}
+
+// PutRules handles PUT requests that replace the global fault injection
+// rules
+func (h *FaultHandler) PutRules(w http.ResponseWriter, r *http.Request) {
+	var req FaultRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode fault rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	rules := make([]faults.Rule, 0, len(req.Rules))
+	for _, in := range req.Rules {
+		rule := faults.Rule{Layer: in.Layer, Fault: faults.Fault{ErrorRate: in.ErrorRate}}
+		if in.OrgID != "" {
+			orgID, err := uuid.Parse(in.OrgID)
+			if err != nil {
+				http.Error(w, "Invalid org ID: must be a valid UUID", http.StatusBadRequest)
+				return
+			}
+			rule.OrgID = orgID
+		}
+		if in.Latency != "" {
+			latency, err := time.ParseDuration(in.Latency)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid latency '%s': %v", in.Latency, err), http.StatusBadRequest)
+				return
+			}
+			rule.Latency = latency
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := h.injector.SetRules(rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	security.Emit(security.Request(r, "faults.updated", security.SeverityWarning, "Fault injection rules updated").
+		With("rules", len(rules)))
+	h.writeFaultRules(w)
+}
+
+// DeleteRules handles DELETE requests that remove all global fault
+// injection rules
+func (h *FaultHandler) DeleteRules(w http.ResponseWriter, r *http.Request) {
+	h.injector.SetRules(nil)
+	security.Emit(security.Request(r, "faults.cleared", security.SeverityInfo, "Fault injection rules cleared"))
+	w.WriteHeader(http.StatusNoContent)
+}
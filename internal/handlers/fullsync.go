@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Default full sync limits
+const (
+	DefaultFullSyncConcurrency = 4
+	DefaultFullSyncInterval    = 100 * time.Millisecond
+)
+
+// syncToken resumes a full sync after the last row of a chunk. The cutoff
+// is the time the sync started; rows ingested later are left to paged
+// queries so the sync ends at a fixed point.
+type syncToken struct {
+	cutoff time.Time
+	after  *storage.DataCursor
+}
+
+// String encodes the token for clients
+func (t syncToken) String() string {
+	raw := strconv.FormatInt(t.cutoff.UnixNano(), 10)
+	if t.after != nil {
+		raw += ":" + strconv.FormatInt(t.after.Timestamp.UnixNano(), 10) + ":" + strconv.FormatInt(t.after.RowID, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseSyncToken decodes a token returned by a previous chunk
+func parseSyncToken(s string) (syncToken, error) {
+	invalid := fmt.Errorf("invalid sync token")
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return syncToken{}, invalid
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return syncToken{}, invalid
+	}
+	values := make([]int64, len(parts))
+	for i, part := range parts {
+		if values[i], err = strconv.ParseInt(part, 10, 64); err != nil || values[i] < 0 {
+			return syncToken{}, invalid
+		}
+	}
+
+	token := syncToken{cutoff: time.Unix(0, values[0]).UTC()}
+	if len(values) == 3 {
+		token.after = &storage.DataCursor{Timestamp: time.Unix(0, values[1]).UTC(), RowID: values[2]}
+	}
+	return token, nil
+}
+
+// syncChunk is one line of a full sync stream
+type syncChunk struct {
+	Chunk int                  `json:"chunk"`
+	Count int                  `json:"count"`
+	Data  []storage.DataUpload `json:"data"`
+
+	// SyncToken resumes the sync after this chunk
+	SyncToken string `json:"sync_token,omitempty"`
+
+	// Complete marks the last chunk; Cursor then continues with the rows
+	// ingested since the sync started as ?after= of paged data queries
+	Complete bool   `json:"complete"`
+	Cursor   string `json:"cursor,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// fullSyncLimiter allows one full sync per organization and a limited
+// number across the server, and paces the chunks of each sync
+type fullSyncLimiter struct {
+	mu            sync.Mutex
+	running       map[uuid.UUID]bool
+	maxConcurrent int
+	interval      time.Duration
+}
+
+func newFullSyncLimiter() *fullSyncLimiter {
+	return &fullSyncLimiter{
+		running:       make(map[uuid.UUID]bool),
+		maxConcurrent: DefaultFullSyncConcurrency,
+		interval:      DefaultFullSyncInterval,
+	}
+}
+
+// acquire reserves a sync slot for the organization, returning a message
+// for the client if none is available
+func (l *fullSyncLimiter) acquire(orgID uuid.UUID) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.running[orgID] {
+		return "A full sync is already running for this organization", false
+	}
+	if len(l.running) >= l.maxConcurrent {
+		return "Too many full syncs running. Please try again later.", false
+	}
+	l.running[orgID] = true
+	return "", true
+}
+
+// release frees the organization's sync slot
+func (l *fullSyncLimiter) release(orgID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.running, orgID)
+}
+
+// SetFullSyncLimits sets the number of full syncs that may run at once
+// across all organizations and the pause between the chunks of a sync
+func (h *UploadHandler) SetFullSyncLimits(maxConcurrent int, interval time.Duration) {
+	h.syncs.mu.Lock()
+	defer h.syncs.mu.Unlock()
+	h.syncs.maxConcurrent = maxConcurrent
+	h.syncs.interval = interval
+}
+
+// FullSync handles GET requests streaming all of the organization's data
+// as newline-delimited JSON chunks of ?chunk_size rows in cursor order.
+// Every chunk carries a sync token; a sync that is interrupted resumes
+// with ?sync_token=<token> from the last chunk received.
+func (h *UploadHandler) FullSync(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chunkSize := storage.MaxPageSize
+	if value := r.URL.Query().Get("chunk_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > storage.MaxPageSize {
+			http.Error(w, fmt.Sprintf("invalid chunk_size: must be between 1 and %d", storage.MaxPageSize), http.StatusBadRequest)
+			return
+		}
+		chunkSize = size
+	}
+
+	token := syncToken{cutoff: time.Now().UTC()}
+	if value := r.URL.Query().Get("sync_token"); value != "" {
+		var err error
+		if token, err = parseSyncToken(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if message, ok := h.syncs.acquire(orgID); !ok {
+		slog.WarnContext(r.Context(), "Full sync rejected", "org_id", orgID, "reason", message, "ip", r.RemoteAddr)
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, message, http.StatusTooManyRequests)
+		return
+	}
+	defer h.syncs.release(orgID)
+
+	h.syncs.mu.Lock()
+	interval := h.syncs.interval
+	h.syncs.mu.Unlock()
+
+	slog.InfoContext(r.Context(), "Full sync started", "org_id", orgID, "resumed", token.after != nil, "ip", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	controller := http.NewResponseController(w)
+	rows := 0
+	for chunk := 1; ; chunk++ {
+		// The client resumes from the last chunk if the request times out
+		if r.Context().Err() != nil {
+			slog.InfoContext(r.Context(), "Full sync interrupted", "org_id", orgID, "rows", rows)
+			return
+		}
+
+		page, err := storage.PageOrgData(h.dataStorage, orgID, storage.PageQuery{After: token.after, Limit: chunkSize})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Full sync failed", "org_id", orgID, "error", err)
+			encoder.Encode(syncChunk{Chunk: chunk, Data: []storage.DataUpload{}, Error: "Failed to retrieve data"})
+			return
+		}
+
+		// Rows ingested after the sync started end it
+		uploads := page.Uploads
+		complete := page.Next == nil
+		for i, upload := range uploads {
+			if upload.Timestamp.After(token.cutoff) {
+				uploads = uploads[:i]
+				complete = true
+				break
+			}
+		}
+		rows += len(uploads)
+
+		line := syncChunk{Chunk: chunk, Count: len(uploads), Data: uploads, Complete: complete}
+		if uploads == nil {
+			line.Data = []storage.DataUpload{}
+		}
+		if complete {
+			// Every row up to the cutoff was sent, so the rows after it
+			// continue where the sync ends
+			line.Cursor = storage.DataCursor{Timestamp: token.cutoff, RowID: math.MaxInt64}.String()
+		} else {
+			token.after = page.Next
+			line.SyncToken = token.String()
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			slog.InfoContext(r.Context(), "Full sync aborted by client", "org_id", orgID, "rows", rows)
+			return
+		}
+		// Not supported by all writers (e.g. test recorders), so errors are ignored
+		_ = controller.Flush()
+
+		if complete {
+			slog.InfoContext(r.Context(), "Full sync completed", "org_id", orgID, "rows", rows, "ip", r.RemoteAddr)
+			return
+		}
+
+		// Pace chunks so a sync does not starve normal queries
+		select {
+		case <-r.Context().Done():
+		case <-time.After(interval):
+		}
+	}
+}
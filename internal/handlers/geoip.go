@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/geoip"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+// GeoIPHandler manages the per-organization country allow and deny lists
+type GeoIPHandler struct {
+	store *geoip.Store
+}
+
+// NewGeoIPHandler creates a new country lists handler
+func NewGeoIPHandler(store *geoip.Store) *GeoIPHandler {
+	return &GeoIPHandler{
+		store: store,
+	}
+}
+
+// writeGeoIPLists writes a country lists response; restricted is false for
+// organizations without lists
+func writeGeoIPLists(w http.ResponseWriter, orgID uuid.UUID, lists geoip.Lists, restricted bool) {
+	if lists.Allow == nil {
+		lists.Allow = []string{}
+	}
+	if lists.Deny == nil {
+		lists.Deny = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":     orgID.String(),
+		"restricted": restricted,
+		"countries":  lists,
+	})
+}
+
+// GetLists handles GET requests for an organization's country lists
+func (h *GeoIPHandler) GetLists(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	lists, exists := h.store.Get(orgID)
+	writeGeoIPLists(w, orgID, lists, exists)
+}
+
+// PutLists handles PUT requests that replace an organization's country
+// lists
+func (h *GeoIPHandler) PutLists(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req geoip.Lists
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode country lists: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lists, err := h.store.Set(orgID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save country lists", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to save country lists", http.StatusInternalServerError)
+		return
+	}
+
+	security.Emit(security.Request(r, "geoip.lists_updated", security.SeverityInfo, "Country lists updated").
+		WithOrg(orgID.String()).With("allow", strings.Join(lists.Allow, ",")).With("deny", strings.Join(lists.Deny, ",")))
+	writeGeoIPLists(w, orgID, lists, true)
+}
+
+// DeleteLists handles DELETE requests that remove an organization's
+// country lists, leaving only the global lists
+func (h *GeoIPHandler) DeleteLists(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	existed, err := h.store.Delete(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete country lists", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to delete country lists", http.StatusInternalServerError)
+		return
+	}
+	if !existed {
+		http.Error(w, "Country lists not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
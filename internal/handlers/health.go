@@ -3,6 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/health"
 )
 
 // HealthResponse represents the health check response
@@ -10,11 +13,39 @@ type HealthResponse struct {
 	Status  string `json:"status"`
 	Version string `json:"version"`
 	Service string `json:"service"`
+
+	// CredentialWatcher reports the auth config file watcher; the status is
+	// "degraded" while it is down and credential changes are not picked up
+	CredentialWatcher *auth.WatcherStatus `json:"credential_watcher,omitempty"`
+}
+
+// AuthHealthResponse is the health check response for authenticated callers
+type AuthHealthResponse struct {
+	HealthResponse
+	OrgID string `json:"org_id"`
+}
+
+// ReadinessResponse represents the readiness check response
+type ReadinessResponse struct {
+	Status   string          `json:"status"`
+	Backends []health.Status `json:"backends"`
+}
+
+// VersionResponse represents the version response
+type VersionResponse struct {
+	Version string `json:"version"`
+	Service string `json:"service"`
+
+	// Features lists the enabled feature flags
+	Features []string `json:"features"`
 }
 
 // HealthHandler handles health check requests
 type HealthHandler struct {
-	version string
+	version     string
+	features    []string
+	monitor     *health.Monitor
+	credentials *auth.FileStore
 }
 
 // NewHealthHandler creates a new health handler
@@ -24,13 +55,105 @@ func NewHealthHandler(version string) *HealthHandler {
 	}
 }
 
-// Check handles GET requests for health checks
-func (h *HealthHandler) Check(w http.ResponseWriter, r *http.Request) {
+// SetStorageMonitor makes readiness depend on the storage probes
+func (h *HealthHandler) SetStorageMonitor(monitor *health.Monitor) {
+	h.monitor = monitor
+}
+
+// SetCredentialWatcher makes health checks report the credential store's
+// file watcher
+func (h *HealthHandler) SetCredentialWatcher(store *auth.FileStore) {
+	h.credentials = store
+}
+
+// SetFeatures sets the enabled feature flags reported by the version
+// endpoint
+func (h *HealthHandler) SetFeatures(features []string) {
+	h.features = features
+}
+
+// healthResponse returns the health of the service
+func (h *HealthHandler) healthResponse() HealthResponse {
 	response := HealthResponse{
 		Status:  "healthy",
 		Version: h.version,
 		Service: "terraform-backend-service",
 	}
+	if h.credentials != nil {
+		if status, ok := h.credentials.WatcherStatus(); ok {
+			response.CredentialWatcher = &status
+			if !status.Healthy {
+				response.Status = "degraded"
+			}
+		}
+	}
+	return response
+}
+
+// Ready handles GET requests for readiness checks. It returns 503 while a
+// storage backend fails its probes, so load balancers stop routing traffic
+// before request failures pile up.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{
+		Status:   "ready",
+		Backends: []health.Status{},
+	}
+	status := http.StatusOK
+
+	if h.monitor != nil {
+		response.Backends = h.monitor.Statuses()
+		if !h.monitor.Ready() {
+			response.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Check handles GET requests for health checks. A degraded service still
+// returns 200, since restarting it is not required to recover.
+func (h *HealthHandler) Check(w http.ResponseWriter, r *http.Request) {
+	response := h.healthResponse()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Version handles GET requests for the service version and the enabled
+// feature flags, so clients can detect experimental endpoints
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	features := h.features
+	if features == nil {
+		features = []string{}
+	}
+	response := VersionResponse{
+		Version:  h.version,
+		Service:  "terraform-backend-service",
+		Features: features,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CheckAuthenticated handles GET requests for authenticated health checks,
+// confirming end-to-end that the caller's credentials are accepted
+func (h *HealthHandler) CheckAuthenticated(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response := AuthHealthResponse{
+		HealthResponse: h.healthResponse(),
+		OrgID:          orgID.String(),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// DefaultRotationOverlap is how long old keys keep working after a rotation
+// when no overlap is requested
+const DefaultRotationOverlap = 7 * 24 * time.Hour
+
+// KeyHandler exposes API key listing and rotation to operators
+type KeyHandler struct {
+	store *auth.FileStore
+}
+
+// NewKeyHandler creates a new key handler
+func NewKeyHandler(store *auth.FileStore) *KeyHandler {
+	return &KeyHandler{
+		store: store,
+	}
+}
+
+// RotateKeysRequest is the body of a key rotation request
+type RotateKeysRequest struct {
+	// KeyID selects the key to rotate; all active keys are rotated when empty
+	KeyID string `json:"key_id,omitempty"`
+
+	// Overlap is how long the old keys keep working, e.g. "72h"
+	Overlap string `json:"overlap,omitempty"`
+
+	// DeprecatedAfter sets the end of the overlap window explicitly (RFC 3339)
+	DeprecatedAfter *time.Time `json:"deprecated_after,omitempty"`
+}
+
+// orgIDParam parses the orgID URL parameter
+func orgIDParam(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		http.Error(w, "Invalid org ID: must be a valid UUID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
+
+// ListKeys handles GET requests for an organization's keys
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	keys, exists := h.store.Keys(orgID)
+	if !exists {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id": orgID,
+		"count":  len(keys),
+		"keys":   keys,
+	})
+}
+
+// RotateKeys handles POST requests that introduce a new key and deprecate
+// the old ones after an overlap window
+func (h *KeyHandler) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req RotateKeysRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to decode rotation request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	deprecateAfter := time.Now().Add(DefaultRotationOverlap)
+	switch {
+	case req.DeprecatedAfter != nil && req.Overlap != "":
+		http.Error(w, "Specify either overlap or deprecated_after, not both", http.StatusBadRequest)
+		return
+	case req.DeprecatedAfter != nil:
+		deprecateAfter = *req.DeprecatedAfter
+	case req.Overlap != "":
+		overlap, err := time.ParseDuration(req.Overlap)
+		if err != nil || overlap < 0 {
+			http.Error(w, "Invalid overlap: must be a non-negative duration such as 72h", http.StatusBadRequest)
+			return
+		}
+		deprecateAfter = time.Now().Add(overlap)
+	}
+
+	rotation, err := h.store.RotateKeys(orgID, req.KeyID, deprecateAfter)
+	if err != nil {
+		if errors.Is(err, auth.ErrOrgNotFound) || errors.Is(err, auth.ErrKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to rotate keys", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to rotate keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rotation)
+}
+
+// ProvisionOrgRequest is the body of an organization provisioning request
+type ProvisionOrgRequest struct {
+	// OrgID is the new organization's ID; a random ID is used when empty
+	OrgID string `json:"org_id,omitempty"`
+
+	// Scopes restricts the organization's first key; it gets the default
+	// scopes when empty
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ProvisionOrg handles POST requests that add an organization with a new
+// key, returned once in plaintext
+func (h *KeyHandler) ProvisionOrg(w http.ResponseWriter, r *http.Request) {
+	var req ProvisionOrgRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to decode provisioning request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	orgID := uuid.Nil
+	if req.OrgID != "" {
+		var err error
+		if orgID, err = uuid.Parse(req.OrgID); err != nil || orgID == uuid.Nil {
+			http.Error(w, "Invalid org ID: must be a valid UUID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	provisioned, err := h.store.ProvisionOrg(orgID, req.Scopes)
+	if err != nil {
+		if errors.Is(err, auth.ErrOrgExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidKeyRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to provision organization", "error", err)
+		http.Error(w, "Failed to provision organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(provisioned)
+}
+
+// ListOrgs handles GET requests for the organizations of the auth config
+// file
+func (h *KeyHandler) ListOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs := h.store.Orgs()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": len(orgs),
+		"orgs":  orgs,
+	})
+}
+
+// AddKey handles POST requests that add an operator-issued key to an
+// organization, returned once in plaintext
+func (h *KeyHandler) AddKey(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req IssueKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to decode key request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	expiresAt, ok := req.expiry(w)
+	if !ok {
+		return
+	}
+
+	added, err := h.store.AddKey(orgID, req.Scopes, req.Label, expiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrOrgNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidKeyRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to add key", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to add key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(added)
+}
+
+// RevokeOrgKey handles DELETE requests that revoke any key of an
+// organization, together with the keys it issued
+func (h *KeyHandler) RevokeOrgKey(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.store.RevokeKey(orgID, chi.URLParam(r, "keyID"))
+	if err != nil {
+		if errors.Is(err, auth.ErrOrgNotFound) || errors.Is(err, auth.ErrKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrLastKey) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to revoke key", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":          orgID,
+		"revoked_key_ids": revoked,
+	})
+}
+
+// IssueKeyRequest is the body of a sub-key issuance request
+type IssueKeyRequest struct {
+	Scopes []string `json:"scopes"`
+
+	// Label describes the key, e.g. the client it is issued to
+	Label string `json:"label,omitempty"`
+
+	// ExpiresIn is the key lifetime, e.g. "720h"
+	ExpiresIn string `json:"expires_in,omitempty"`
+
+	// ExpiresAt sets the expiry explicitly (RFC 3339)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// expiry returns the requested key expiry (zero for none), writing 400 and
+// returning false if it is invalid
+func (req IssueKeyRequest) expiry(w http.ResponseWriter) (time.Time, bool) {
+	switch {
+	case req.ExpiresAt != nil && req.ExpiresIn != "":
+		http.Error(w, "Specify either expires_in or expires_at, not both", http.StatusBadRequest)
+		return time.Time{}, false
+	case req.ExpiresAt != nil:
+		return *req.ExpiresAt, true
+	case req.ExpiresIn != "":
+		lifetime, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || lifetime <= 0 {
+			http.Error(w, "Invalid expires_in: must be a positive duration such as 720h", http.StatusBadRequest)
+			return time.Time{}, false
+		}
+		return time.Now().Add(lifetime), true
+	}
+	return time.Time{}, true
+}
+
+// ListOrgKeys handles GET requests for the caller's own org keys
+func (h *KeyHandler) ListOrgKeys(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, _ := h.store.Keys(orgID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id": orgID,
+		"count":  len(keys),
+		"keys":   keys,
+	})
+}
+
+// IssueKey handles POST requests that create a scoped sub-key for the
+// caller's own org, recorded with the calling key as its parent
+func (h *KeyHandler) IssueKey(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parent, ok := auth.GetKeyFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Key issuance is not supported for this credential", http.StatusForbidden)
+		return
+	}
+
+	var req IssueKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode key request: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	expiresAt, ok := req.expiry(w)
+	if !ok {
+		return
+	}
+
+	issued, err := h.store.IssueKey(orgID, parent, req.Scopes, req.Label, expiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidKeyRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to issue key", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issued)
+}
+
+// RevokeKey handles DELETE requests that revoke a sub-key of the caller's org
+func (h *KeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.store.RevokeSubKey(orgID, chi.URLParam(r, "keyID")); err != nil {
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to revoke key", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+)
+
+// NormalizationHandler manages the per-organization attribute normalization
+// rules applied to uploads
+type NormalizationHandler struct {
+	store *normalize.Store
+}
+
+// NewNormalizationHandler creates a new normalization handler
+func NewNormalizationHandler(store *normalize.Store) *NormalizationHandler {
+	return &NormalizationHandler{
+		store: store,
+	}
+}
+
+// GetRules handles GET requests for an organization's normalization rules
+func (h *NormalizationHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	rules, _ := h.store.Get(orgID)
+	if rules == nil {
+		rules = normalize.Rules{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id": orgID.String(),
+		"rules":  rules,
+	})
+}
+
+// PutRules handles PUT requests that replace an organization's rules. The
+// body maps attribute keys to rules.
+func (h *NormalizationHandler) PutRules(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var rules normalize.Rules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode normalization rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	for key, rule := range rules {
+		if err := validation.ValidateAttributeKey(key); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid attribute key '%s': %v", key, err), http.StatusBadRequest)
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid rule for '%s': %v", key, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.store.Set(orgID, rules); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save normalization rules", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to save normalization rules", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Normalization rules updated", "org_id", orgID, "attributes", len(rules))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id": orgID.String(),
+		"rules":  rules,
+	})
+}
+
+// DeleteRules handles DELETE requests that remove an organization's rules
+func (h *NormalizationHandler) DeleteRules(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	existed, err := h.store.Delete(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete normalization rules", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to delete normalization rules", http.StatusInternalServerError)
+		return
+	}
+	if !existed {
+		http.Error(w, "Normalization rules not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
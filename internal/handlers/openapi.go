@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	tfbackend "github.com/eterrain/tf-backend-service"
+)
+
+// OpenAPISpec handles GET requests for the OpenAPI spec embedded in the
+// binary, so clients can discover the API of the running version
+func OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	spec, err := tfbackend.Files.ReadFile(tfbackend.OpenAPIFile)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read embedded OpenAPI spec", "error", err)
+		http.Error(w, "OpenAPI spec not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
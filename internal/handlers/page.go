@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+)
+
+// pageParams are the pagination query parameters: limit, and after or
+// before holding a cursor from a previous page
+type pageParams struct {
+	limit  int
+	after  string
+	before string
+}
+
+// parsePageParams reads the pagination parameters and reports whether any
+// of them is set
+func parsePageParams(r *http.Request) (pageParams, bool, error) {
+	query := r.URL.Query()
+	params := pageParams{
+		limit:  storage.DefaultPageSize,
+		after:  query.Get("after"),
+		before: query.Get("before"),
+	}
+	paged := query.Has("limit") || params.after != "" || params.before != ""
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > storage.MaxPageSize {
+			return pageParams{}, false, fmt.Errorf("invalid limit: must be between 1 and %d", storage.MaxPageSize)
+		}
+		params.limit = limit
+	}
+	if params.after != "" && params.before != "" {
+		return pageParams{}, false, fmt.Errorf("after and before cannot be combined")
+	}
+	return params, paged, nil
+}
+
+// dataPageQuery converts the parameters to a storage page query
+func (p pageParams) dataPageQuery() (storage.PageQuery, error) {
+	query := storage.PageQuery{Limit: p.limit}
+	if p.after != "" {
+		cursor, err := storage.ParseDataCursor(p.after)
+		if err != nil {
+			return storage.PageQuery{}, err
+		}
+		query.After = &cursor
+	}
+	if p.before != "" {
+		cursor, err := storage.ParseDataCursor(p.before)
+		if err != nil {
+			return storage.PageQuery{}, err
+		}
+		query.Before = &cursor
+	}
+	return query, nil
+}
+
+// cursorValue returns the encoded cursor, or nil at the end of the data
+func cursorValue(cursor *storage.DataCursor) interface{} {
+	if cursor == nil {
+		return nil
+	}
+	return cursor.String()
+}
+
+// pageStates selects a page of states ordered by name; the cursor of a
+// state is its encoded name
+func pageStates(states []storage.StateSummary, params pageParams) ([]storage.StateSummary, interface{}, interface{}, error) {
+	decode := func(cursor string) (string, error) {
+		name, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(name) == 0 {
+			return "", storage.ErrInvalidCursor
+		}
+		return string(name), nil
+	}
+
+	start, end := 0, len(states)
+	switch {
+	case params.after != "":
+		after, err := decode(params.after)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		start = sort.Search(len(states), func(i int) bool { return states[i].Name > after })
+		end = min(start+params.limit, len(states))
+	case params.before != "":
+		before, err := decode(params.before)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		end = sort.Search(len(states), func(i int) bool { return states[i].Name >= before })
+		start = max(end-params.limit, 0)
+	default:
+		end = min(params.limit, len(states))
+	}
+
+	var next, prev interface{}
+	if start > 0 && end > start {
+		prev = base64.RawURLEncoding.EncodeToString([]byte(states[start].Name))
+	}
+	if end < len(states) && end > start {
+		next = base64.RawURLEncoding.EncodeToString([]byte(states[end-1].Name))
+	}
+	return states[start:end], next, prev, nil
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// qualitySampleLimit caps the example rows reported per issue
+const qualitySampleLimit = 10
+
+// qualityMinGroupSize is the number of rows a resource type needs before
+// attribute-count outliers are reported for it
+const qualityMinGroupSize = 5
+
+// rowKeys are the keys every upload row carries besides its attributes
+var rowKeys = map[string]bool{
+	"provider":      true,
+	"category":      true,
+	"resource_type": true,
+	"report_name":   true,
+	"resource_name": true,
+	"observed_at":   true,
+}
+
+// QualitySample identifies a stored row with a quality issue
+type QualitySample struct {
+	Timestamp    time.Time `json:"timestamp"`
+	ResourceType string    `json:"resource_type,omitempty"`
+	ResourceName string    `json:"resource_name,omitempty"`
+	UploadID     string    `json:"upload_id,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+// QualityIssue counts the rows with one kind of issue
+type QualityIssue struct {
+	Count   int             `json:"count"`
+	Samples []QualitySample `json:"samples"`
+}
+
+// add counts a row and keeps it as a sample while below the limit
+func (q *QualityIssue) add(upload storage.DataUpload, detail string) {
+	q.Count++
+	if len(q.Samples) >= qualitySampleLimit {
+		return
+	}
+
+	sample := QualitySample{Timestamp: upload.Timestamp, Detail: detail}
+	sample.ResourceType, _ = upload.Data["resource_type"].(string)
+	sample.ResourceName, _ = upload.Data["resource_name"].(string)
+	if upload.Lineage != nil {
+		sample.UploadID = upload.Lineage.UploadID
+	}
+	q.Samples = append(q.Samples, sample)
+}
+
+// QualityReport summarizes data quality problems in an organization's feed
+type QualityReport struct {
+	OrgID                  string       `json:"org_id"`
+	TotalRows              int          `json:"total_rows"`
+	MissingResourceNames   QualityIssue `json:"missing_resource_names"`
+	UnparseableTimestamps  QualityIssue `json:"unparseable_timestamps"`
+	AttributeCountOutliers QualityIssue `json:"attribute_count_outliers"`
+
+	// RejectedLines counts stored lines skipped as malformed; it is omitted
+	// for backends that cannot report them
+	RejectedLines *storage.RejectedLines `json:"rejected_lines,omitempty"`
+}
+
+// attributeCount counts the instance attributes of a stored row
+func attributeCount(data map[string]interface{}) int {
+	count := 0
+	for key := range data {
+		if !rowKeys[key] && !strings.HasPrefix(key, "_") {
+			count++
+		}
+	}
+	return count
+}
+
+// buildQualityReport checks stored rows for missing resource names,
+// unparseable timestamps and attribute-count outliers. A row is an outlier
+// when its attribute count is more than double or less than half the
+// median of its resource type.
+func buildQualityReport(orgID uuid.UUID, uploads []storage.DataUpload) *QualityReport {
+	report := &QualityReport{
+		OrgID:                  orgID.String(),
+		TotalRows:              len(uploads),
+		MissingResourceNames:   QualityIssue{Samples: []QualitySample{}},
+		UnparseableTimestamps:  QualityIssue{Samples: []QualitySample{}},
+		AttributeCountOutliers: QualityIssue{Samples: []QualitySample{}},
+	}
+
+	counts := make(map[string][]int)
+	for _, upload := range uploads {
+		if name, _ := upload.Data["resource_name"].(string); strings.TrimSpace(name) == "" {
+			report.MissingResourceNames.add(upload, "")
+		}
+
+		if upload.Timestamp.IsZero() {
+			report.UnparseableTimestamps.add(upload, "missing ingestion timestamp")
+		} else if value, ok := upload.Data["observed_at"]; ok {
+			observedAt, _ := value.(string)
+			if _, err := time.Parse(time.RFC3339Nano, observedAt); err != nil {
+				report.UnparseableTimestamps.add(upload, fmt.Sprintf("observed_at %q", value))
+			}
+		}
+
+		resourceType, _ := upload.Data["resource_type"].(string)
+		counts[resourceType] = append(counts[resourceType], attributeCount(upload.Data))
+	}
+
+	medians := make(map[string]int, len(counts))
+	for resourceType, values := range counts {
+		if len(values) < qualityMinGroupSize {
+			continue
+		}
+		sorted := append([]int(nil), values...)
+		sort.Ints(sorted)
+		medians[resourceType] = sorted[len(sorted)/2]
+	}
+
+	for _, upload := range uploads {
+		resourceType, _ := upload.Data["resource_type"].(string)
+		median, ok := medians[resourceType]
+		if !ok {
+			continue
+		}
+		count := attributeCount(upload.Data)
+		if count > 2*median || 2*count < median {
+			report.AttributeCountOutliers.add(upload, fmt.Sprintf("%d attributes, median %d for %s", count, median, resourceType))
+		}
+	}
+
+	return report
+}
+
+// GetDataQuality handles GET requests for a data quality report of the
+// organization's stored rows, so org admins can find and fix bad feeds
+func (h *UploadHandler) GetDataQuality(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	uploads, err := h.dataStorage.GetOrgData(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve data", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to retrieve data", http.StatusInternalServerError)
+		return
+	}
+
+	report := buildQualityReport(orgID, uploads)
+
+	if checker, ok := h.dataStorage.(storage.IntegrityChecker); ok {
+		rejected, err := checker.CheckIntegrity(orgID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to check data integrity", "org_id", orgID, "error", err)
+			http.Error(w, "Failed to check data integrity", http.StatusInternalServerError)
+			return
+		}
+		report.RejectedLines = &rejected
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
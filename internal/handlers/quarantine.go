@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// QuarantineIDHeader identifies the quarantine entry of a rejected upload
+const QuarantineIDHeader = "X-Quarantine-ID"
+
+// reingestKey marks requests that re-ingest a quarantined payload, whose
+// failures update the entry instead of quarantining the payload again
+type reingestKey struct{}
+
+// SetQuarantine keeps payloads that fail validation for operator review
+// instead of discarding them
+func (h *UploadHandler) SetQuarantine(store *quarantine.Store) {
+	h.quarantine = store
+}
+
+// quarantineUpload keeps a payload that failed validation and tells the
+// client its quarantine ID. Dry runs are not quarantined.
+func (h *UploadHandler) quarantineUpload(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, body []byte, uploadErr *uploadError, dryRun bool) {
+	if h.quarantine == nil || dryRun || r.Context().Value(reingestKey{}) != nil {
+		return
+	}
+
+	entry, err := h.quarantine.Add(quarantine.Entry{
+		OrgID:           orgID,
+		Endpoint:        r.URL.Path,
+		Query:           r.URL.RawQuery,
+		ContentType:     r.Header.Get("Content-Type"),
+		ProviderVersion: r.Header.Get(ProviderVersionHeader),
+		SourceIP:        sourceIP(r),
+		Reason:          uploadErr.message,
+	}, body)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected upload discarded, not quarantined", "org_id", orgID, "error", err)
+		return
+	}
+
+	w.Header().Set(QuarantineIDHeader, entry.ID)
+	slog.InfoContext(r.Context(), "Rejected upload quarantined", "org_id", orgID, "quarantine_id", entry.ID,
+		"rejections", entry.Rejections, "reason", entry.Reason, "ip", r.RemoteAddr)
+}
+
+// QuarantineHandler lets operators review quarantined uploads and
+// re-ingest or discard them
+type QuarantineHandler struct {
+	store   *quarantine.Store
+	uploads *UploadHandler
+}
+
+// NewQuarantineHandler creates a new quarantine handler that re-ingests
+// payloads through the upload handler
+func NewQuarantineHandler(store *quarantine.Store, uploads *UploadHandler) *QuarantineHandler {
+	return &QuarantineHandler{
+		store:   store,
+		uploads: uploads,
+	}
+}
+
+// entryParam returns the quarantine entry named in the URL, writing an
+// error response if there is none
+func (h *QuarantineHandler) entryParam(w http.ResponseWriter, r *http.Request) (quarantine.Entry, bool) {
+	id := chi.URLParam(r, "entryID")
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invalid quarantine ID: must be a valid UUID", http.StatusBadRequest)
+		return quarantine.Entry{}, false
+	}
+	entry, ok := h.store.Get(id)
+	if !ok {
+		http.Error(w, "Quarantine entry not found", http.StatusNotFound)
+		return quarantine.Entry{}, false
+	}
+	return entry, true
+}
+
+// ListEntries handles GET requests for the quarantined uploads, optionally
+// of one organization (?org_id=), most recently rejected first
+func (h *QuarantineHandler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	orgID := uuid.Nil
+	if value := r.URL.Query().Get("org_id"); value != "" {
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			http.Error(w, "Invalid org_id: must be a valid UUID", http.StatusBadRequest)
+			return
+		}
+		orgID = parsed
+	}
+
+	entries := h.store.List(orgID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(entries),
+		"entries": entries,
+	})
+}
+
+// GetEntry handles GET requests for a quarantine entry
+func (h *QuarantineHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryParam(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// GetPayload handles GET requests for the payload of a quarantine entry,
+// exactly as the client sent it
+func (h *QuarantineHandler) GetPayload(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryParam(w, r)
+	if !ok {
+		return
+	}
+
+	payload, err := h.store.Payload(entry.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read quarantined payload", "quarantine_id", entry.ID, "error", err)
+		http.Error(w, "Failed to read quarantined payload", http.StatusInternalServerError)
+		return
+	}
+
+	// Payloads are untrusted client data and never rendered
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+}
+
+// Reingest handles POST requests that upload a quarantined payload again
+// for its organization, e.g. after its allowlist was extended. A non-empty
+// request body replaces the payload with a corrected one. The entry is
+// removed once the upload is stored; if validation fails again, the entry
+// records the attempt and 422 is returned. With ?dry_run=true the payload
+// is only validated.
+func (h *QuarantineHandler) Reingest(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryParam(w, r)
+	if !ok {
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 10<<20)) // 10MB limit, as for uploads
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	corrected := len(payload) > 0
+	if !corrected {
+		if payload, err = h.store.Payload(entry.ID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read quarantined payload", "quarantine_id", entry.ID, "error", err)
+			http.Error(w, "Failed to read quarantined payload", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	query, err := url.ParseQuery(entry.Query)
+	if err != nil {
+		query = url.Values{}
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	query.Del("dry_run")
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	// The upload runs as the organization that sent the payload
+	ctx := context.WithValue(reqctx.WithOrgID(r.Context(), entry.OrgID), reingestKey{}, entry.ID)
+	req := httptest.NewRequest(http.MethodPost, entry.Endpoint+"?"+query.Encode(), bytes.NewReader(payload)).WithContext(ctx)
+	req.RemoteAddr = r.RemoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	if entry.ProviderVersion != "" {
+		req.Header.Set(ProviderVersionHeader, entry.ProviderVersion)
+	}
+
+	rec := httptest.NewRecorder()
+	if strings.HasPrefix(entry.Endpoint, "/api/v2/") {
+		h.uploads.UploadDataV2(rec, req)
+	} else {
+		h.uploads.UploadData(rec, req)
+	}
+
+	var upload interface{}
+	json.Unmarshal(rec.Body.Bytes(), &upload)
+
+	switch {
+	case rec.Code == http.StatusOK && dryRun:
+		writeReingest(w, http.StatusOK, "valid", entry, upload, "")
+
+	case rec.Code == http.StatusOK:
+		if _, err := h.store.Delete(entry.ID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to remove re-ingested quarantine entry", "quarantine_id", entry.ID, "error", err)
+		}
+		security.Emit(security.Request(r, "quarantine.reingested", security.SeverityInfo, "Quarantined upload re-ingested").
+			WithOrg(entry.OrgID.String()).With("quarantine_id", entry.ID).With("corrected", corrected))
+		writeReingest(w, http.StatusOK, "reingested", entry, upload, "")
+
+	case rec.Code == http.StatusBadRequest:
+		reason := strings.TrimSpace(rec.Body.String())
+		if !dryRun {
+			if updated, err := h.store.RecordAttempt(entry.ID, reason); err == nil {
+				entry = updated
+			}
+		}
+		writeReingest(w, http.StatusUnprocessableEntity, "rejected", entry, nil, reason)
+
+	default:
+		http.Error(w, strings.TrimSpace(rec.Body.String()), rec.Code)
+	}
+}
+
+// writeReingest writes the outcome of a re-ingestion
+func writeReingest(w http.ResponseWriter, status int, outcome string, entry quarantine.Entry, upload interface{}, reason string) {
+	response := map[string]interface{}{
+		"status": outcome,
+		"entry":  entry,
+	}
+	if upload != nil {
+		response["upload"] = upload
+	}
+	if reason != "" {
+		response["error"] = reason
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteEntry handles DELETE requests that discard a quarantined upload
+func (h *QuarantineHandler) DeleteEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryParam(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.store.Delete(entry.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to discard quarantine entry", "quarantine_id", entry.ID, "error", err)
+		http.Error(w, "Failed to discard quarantine entry", http.StatusInternalServerError)
+		return
+	}
+	security.Emit(security.Request(r, "quarantine.discarded", security.SeverityInfo, "Quarantined upload discarded").
+		WithOrg(entry.OrgID.String()).With("quarantine_id", entry.ID))
+	w.WriteHeader(http.StatusNoContent)
+}
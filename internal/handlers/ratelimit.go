@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/ratelimit"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/google/uuid"
+)
+
+// RateLimitHandler manages per-organization rate limit overrides
+type RateLimitHandler struct {
+	store *ratelimit.Store
+}
+
+// NewRateLimitHandler creates a new rate limit override handler
+func NewRateLimitHandler(store *ratelimit.Store) *RateLimitHandler {
+	return &RateLimitHandler{
+		store: store,
+	}
+}
+
+// rateLimitOverride is an override with the organization and whether it
+// currently applies
+type rateLimitOverride struct {
+	OrgID uuid.UUID `json:"org_id"`
+	ratelimit.Override
+	Active bool `json:"active"`
+}
+
+// ListOverrides handles GET requests for the overrides of all
+// organizations, including expired ones
+func (h *RateLimitHandler) ListOverrides(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	overrides := make([]rateLimitOverride, 0)
+	for orgID, o := range h.store.All() {
+		overrides = append(overrides, rateLimitOverride{OrgID: orgID, Override: o, Active: o.Active(now)})
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].OrgID.String() < overrides[j].OrgID.String()
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"overrides": overrides,
+	})
+}
+
+// GetOverride handles GET requests for an organization's override
+func (h *RateLimitHandler) GetOverride(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	o, exists := h.store.Get(orgID)
+	if !exists {
+		http.Error(w, "Rate limit override not found", http.StatusNotFound)
+		return
+	}
+	writeRateLimitOverride(w, orgID, o)
+}
+
+// PutOverride handles PUT requests that replace an organization's override
+func (h *RateLimitHandler) PutOverride(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req ratelimit.Override
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode override: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := req.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid override: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		http.Error(w, "Invalid override: until must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.Set(orgID, req); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save rate limit override", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to save rate limit override", http.StatusInternalServerError)
+		return
+	}
+
+	until := "none"
+	if req.Until != nil {
+		until = req.Until.UTC().Format(time.RFC3339)
+	}
+	security.Emit(security.Request(r, "ratelimit.override_set", security.SeverityWarning, "Rate limit override set").
+		WithOrg(orgID.String()).With("exempt", req.Exempt).With("multiplier", req.Multiplier).
+		With("until", until).With("reason", req.Reason))
+	writeRateLimitOverride(w, orgID, req)
+}
+
+// DeleteOverride handles DELETE requests that restore the global limit
+// for an organization
+func (h *RateLimitHandler) DeleteOverride(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	existed, err := h.store.Delete(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete rate limit override", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to delete rate limit override", http.StatusInternalServerError)
+		return
+	}
+	if !existed {
+		http.Error(w, "Rate limit override not found", http.StatusNotFound)
+		return
+	}
+
+	security.Emit(security.Request(r, "ratelimit.override_deleted", security.SeverityInfo, "Rate limit override deleted").
+		WithOrg(orgID.String()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRateLimitOverride writes an override response
+func writeRateLimitOverride(w http.ResponseWriter, orgID uuid.UUID, o ratelimit.Override) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rateLimitOverride{OrgID: orgID, Override: o, Active: o.Active(time.Now())})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/recorder"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultRecordingDuration applies when a recording request sets none
+	defaultRecordingDuration = time.Hour
+
+	// maxRecordingDuration bounds how long traffic of an organization is
+	// kept recorded, so a forgotten recording ends by itself
+	maxRecordingDuration = 24 * time.Hour
+)
+
+// RecordingHandler starts, stops and reads request recordings of
+// organizations for debugging
+type RecordingHandler struct {
+	recorder *recorder.Recorder
+}
+
+// NewRecordingHandler creates a new recording handler
+func NewRecordingHandler(rec *recorder.Recorder) *RecordingHandler {
+	return &RecordingHandler{
+		recorder: rec,
+	}
+}
+
+// StartRecordingRequest is the body of PUT /admin/v1/orgs/{orgID}/recording
+type StartRecordingRequest struct {
+	State    string `json:"state,omitempty"`    // Only record requests for this state
+	Duration string `json:"duration,omitempty"` // How long to record (default 1h, at most 24h)
+}
+
+// writeRecording writes the recording status and exchanges of an
+// organization
+func (h *RecordingHandler) writeRecording(w http.ResponseWriter, orgID uuid.UUID) {
+	response := map[string]interface{}{
+		"org_id":    orgID.String(),
+		"recording": false,
+		"exchanges": h.recorder.Exchanges(orgID),
+	}
+	if target, ok := h.recorder.Target(orgID); ok {
+		response["recording"] = true
+		response["target"] = target
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetRecording handles GET requests for an organization's recorded
+// exchanges
+func (h *RecordingHandler) GetRecording(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+	h.writeRecording(w, orgID)
+}
+
+// StartRecording handles PUT requests that start recording an
+// organization's requests
+func (h *RecordingHandler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req StartRecordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Failed to decode recording request: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if req.State != "" {
+		if err := validation.ValidateStateName(req.State); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid state: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	duration := defaultRecordingDuration
+	if req.Duration != "" {
+		parsed, err := time.ParseDuration(req.Duration)
+		if err != nil || parsed <= 0 || parsed > maxRecordingDuration {
+			http.Error(w, fmt.Sprintf("Invalid duration '%s': must be positive and at most %v", req.Duration, maxRecordingDuration), http.StatusBadRequest)
+			return
+		}
+		duration = parsed
+	}
+
+	target := recorder.Target{State: req.State, Until: time.Now().Add(duration).UTC()}
+	h.recorder.Start(orgID, target)
+
+	// Recordings hold request bodies, so starting one is auditable
+	security.Emit(security.Request(r, "recording.started", security.SeverityWarning, "Request recording started").
+		WithOrg(orgID.String()).With("state", req.State).With("until", target.Until.Format(time.RFC3339)))
+	h.writeRecording(w, orgID)
+}
+
+// StopRecording handles DELETE requests that stop recording an
+// organization and discard its exchanges
+func (h *RecordingHandler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.recorder.Stop(orgID) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	security.Emit(security.Request(r, "recording.stopped", security.SeverityInfo, "Request recording stopped").
+		WithOrg(orgID.String()))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/runtimestats"
+)
+
+// RuntimeHandler reports process resource usage and subsystem counters
+type RuntimeHandler struct {
+	registry *runtimestats.Registry
+}
+
+// NewRuntimeHandler creates a new runtime stats handler
+func NewRuntimeHandler(registry *runtimestats.Registry) *RuntimeHandler {
+	return &RuntimeHandler{
+		registry: registry,
+	}
+}
+
+// GetRuntime handles GET requests for heap, GC, goroutine and file
+// descriptor stats and the counters of each subsystem
+func (h *RuntimeHandler) GetRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.registry.Snapshot())
+}
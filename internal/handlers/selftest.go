@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// SelfTestStep reports the outcome of a single self-test round trip
+type SelfTestStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // "passed", "failed" or "skipped"
+	HTTPStatus int    `json:"http_status,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SelfTestResult is the response body of the self-test endpoint
+type SelfTestResult struct {
+	Status     string         `json:"status"`
+	OrgID      string         `json:"org_id"`
+	DurationMs int64          `json:"duration_ms"`
+	Steps      []SelfTestStep `json:"steps"`
+}
+
+// SelfTestHandler runs end-to-end round trips against the service using an
+// ephemeral organization, so acceptance tests need no pre-seeded credentials.
+// Whatever a run stores is removed when it ends, whether or not it passed.
+type SelfTestHandler struct {
+	credentials  *auth.InMemoryStore
+	dataStorage  storage.DataStorage
+	stateStorage storage.Storage
+	target       http.Handler
+}
+
+// NewSelfTestHandler creates a new self-test handler. Ephemeral credentials are
+// provisioned into the given in-memory store, which must be consulted by the
+// authentication middleware. dataStorage and stateStorage may be nil when
+// their APIs are disabled.
+func NewSelfTestHandler(credentials *auth.InMemoryStore, dataStorage storage.DataStorage, stateStorage storage.Storage) *SelfTestHandler {
+	return &SelfTestHandler{
+		credentials:  credentials,
+		dataStorage:  dataStorage,
+		stateStorage: stateStorage,
+	}
+}
+
+// SetTarget sets the router that self-test requests are dispatched to
+func (h *SelfTestHandler) SetTarget(target http.Handler) {
+	h.target = target
+}
+
+// Run handles POST requests that execute the self-test
+func (h *SelfTestHandler) Run(w http.ResponseWriter, r *http.Request) {
+	if h.target == nil {
+		http.Error(w, "Self-test is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	apiKey, err := randomSelfTestKey()
+	if err != nil {
+		http.Error(w, "Failed to provision self-test credentials", http.StatusInternalServerError)
+		return
+	}
+
+	orgID := uuid.New()
+	h.credentials.AddCredentials(orgID, apiKey)
+	defer h.credentials.RemoveCredentials(orgID)
+
+	slog.InfoContext(r.Context(), "Starting self-test", "org_id", orgID, "ip", r.RemoteAddr)
+
+	run := &selfTestRun{
+		handler:    h,
+		orgID:      orgID,
+		apiKey:     apiKey,
+		remoteAddr: r.RemoteAddr,
+		stateName:  "selftest-" + orgID.String()[:8],
+	}
+	defer run.cleanup(r.Context())
+
+	start := time.Now()
+	steps := run.execute()
+
+	result := SelfTestResult{
+		Status:     "passed",
+		OrgID:      orgID.String(),
+		DurationMs: time.Since(start).Milliseconds(),
+		Steps:      steps,
+	}
+	for _, step := range steps {
+		if step.Status == "failed" {
+			result.Status = "failed"
+			break
+		}
+	}
+
+	slog.InfoContext(r.Context(), "Self-test finished", "org_id", orgID, "status", result.Status, "duration_ms", result.DurationMs)
+
+	status := http.StatusOK
+	if result.Status != "passed" {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// selfTestRun holds the state of a single self-test execution
+type selfTestRun struct {
+	handler    *SelfTestHandler
+	orgID      uuid.UUID
+	apiKey     string
+	remoteAddr string
+	stateName  string
+}
+
+// execute runs all round trips, skipping those whose backend is not configured
+func (run *selfTestRun) execute() []SelfTestStep {
+	var steps []SelfTestStep
+	lockBody := fmt.Sprintf(`{"ID":"selftest-%s","Operation":"OperationTypeApply","Who":"selftest"}`, run.orgID)
+
+	if run.handler.dataStorage != nil {
+		upload := `{"provider":"selftest","category":"selftest","resource_type":"selftest_resource","instances":[{"attributes":{"name":"selftest-instance"}}]}`
+		steps = append(steps,
+			run.request("upload", http.MethodPost, "/api/v1/upload", upload, http.StatusOK),
+			run.queryData(),
+			run.deleteData(),
+		)
+	} else {
+		steps = append(steps, skipped("upload"), skipped("query"), skipped("delete_data"))
+	}
+
+	if run.handler.stateStorage != nil {
+		statePath := "/api/v1/state/" + run.stateName
+		steps = append(steps,
+			run.request("state_put", http.MethodPost, statePath, `{"version":4,"serial":1}`, http.StatusOK),
+			run.request("state_get", http.MethodGet, statePath, "", http.StatusOK),
+			run.request("state_lock", http.MethodPost, statePath+"/lock", lockBody, http.StatusOK),
+			run.request("state_unlock", http.MethodDelete, statePath+"/lock", lockBody, http.StatusOK),
+			run.request("state_delete", http.MethodDelete, statePath, "", http.StatusOK),
+		)
+	} else {
+		steps = append(steps, skipped("state_put"), skipped("state_get"), skipped("state_lock"),
+			skipped("state_unlock"), skipped("state_delete"))
+	}
+
+	return steps
+}
+
+// cleanup removes the data and state of the run, which failed steps may
+// have left behind
+func (run *selfTestRun) cleanup(ctx context.Context) {
+	if data := run.handler.dataStorage; data != nil {
+		if err := data.DeleteOrgData(run.orgID); err != nil {
+			slog.WarnContext(ctx, "Failed to remove self-test data", "org_id", run.orgID, "error", err)
+		}
+	}
+	if states := run.handler.stateStorage; states != nil {
+		if _, err := states.ForceUnlockState(run.orgID, run.stateName); err != nil && !errors.Is(err, storage.ErrNotLocked) {
+			slog.WarnContext(ctx, "Failed to unlock self-test state", "org_id", run.orgID, "state", run.stateName, "error", err)
+		}
+		if err := states.DeleteState(run.orgID, run.stateName); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "Failed to remove self-test state", "org_id", run.orgID, "state", run.stateName, "error", err)
+		}
+	}
+}
+
+// request dispatches an authenticated request through the router
+func (run *selfTestRun) request(name, method, path, body string, wantStatus int) SelfTestStep {
+	step, _ := run.do(name, method, path, body, wantStatus)
+	return step
+}
+
+// do dispatches an authenticated request and returns the recorded response.
+// Requests carry a fresh timestamp and nonce, so they pass the replay guard.
+func (run *selfTestRun) do(name, method, path, body string, wantStatus int) (SelfTestStep, *httptest.ResponseRecorder) {
+	start := time.Now()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.RemoteAddr = run.remoteAddr
+	req.Header.Set("X-Org-ID", run.orgID.String())
+	req.Header.Set("X-API-Key", run.apiKey)
+	req.Header.Set(auth.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(auth.NonceHeader, uuid.NewString())
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+	run.handler.target.ServeHTTP(rec, req)
+
+	step := SelfTestStep{
+		Name:       name,
+		Status:     "passed",
+		HTTPStatus: rec.Code,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if rec.Code != wantStatus {
+		step.Status = "failed"
+		step.Error = fmt.Sprintf("expected status %d, got %d", wantStatus, rec.Code)
+	}
+
+	return step, rec
+}
+
+// queryData fetches the org data and verifies the uploaded row is returned
+func (run *selfTestRun) queryData() SelfTestStep {
+	step, rec := run.do("query", http.MethodGet, "/api/v1/data", "", http.StatusOK)
+	if step.Status != "passed" {
+		return step
+	}
+
+	var response struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		step.Status = "failed"
+		step.Error = fmt.Sprintf("invalid response body: %v", err)
+	} else if response.Count < 1 {
+		step.Status = "failed"
+		step.Error = "uploaded data was not returned by query"
+	}
+
+	return step
+}
+
+// deleteData removes the ephemeral org's data from storage
+func (run *selfTestRun) deleteData() SelfTestStep {
+	start := time.Now()
+	step := SelfTestStep{Name: "delete_data", Status: "passed"}
+
+	if err := run.handler.dataStorage.DeleteOrgData(run.orgID); err != nil {
+		step.Status = "failed"
+		step.Error = err.Error()
+	}
+
+	step.DurationMs = time.Since(start).Milliseconds()
+	return step
+}
+
+// skipped returns a step marked as skipped because its backend is not configured
+func skipped(name string) SelfTestStep {
+	return SelfTestStep{Name: name, Status: "skipped"}
+}
+
+// randomSelfTestKey generates a random API key for the ephemeral org
+func randomSelfTestKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
@@ -1,31 +1,83 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/statequeue"
 	"github.com/eterrain/tf-backend-service/internal/storage"
 	"github.com/eterrain/tf-backend-service/internal/validation"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+// Methods of Terraform's http backend for locking and unlocking a state at
+// its URL
+const (
+	MethodLock   = "LOCK"
+	MethodUnlock = "UNLOCK"
+)
+
+// lockIDParam is the query parameter by which Terraform's http backend sends
+// the ID of the lock it holds with state updates. Other clients may send the
+// LockIDHeader instead.
+const lockIDParam = "ID"
+
+// LockIDHeader carries the ID of the lock held by the client with state
+// updates
+const LockIDHeader = "X-Lock-ID"
+
 // StateHandler handles Terraform state operations
 type StateHandler struct {
 	storage storage.Storage
+	usage   UsageRecorder
+	changes ChangeRecorder
+	clients *clients.Tracker
+	history *storage.LockHistory
+	writes  *statequeue.Queue
 }
 
 // NewStateHandler creates a new state handler
-func NewStateHandler(storage storage.Storage) *StateHandler {
+func NewStateHandler(store storage.Storage) *StateHandler {
 	return &StateHandler{
-		storage: storage,
+		storage: store,
+		history: storage.NewLockHistory(0),
 	}
 }
 
+// SetUsageRecorder enables usage accounting of stored state bytes
+func (h *StateHandler) SetUsageRecorder(usage UsageRecorder) {
+	h.usage = usage
+}
+
+// SetChangeRecorder enables change feed entries for state versions and
+// deletes
+func (h *StateHandler) SetChangeRecorder(recorder ChangeRecorder) {
+	h.changes = recorder
+}
+
+// SetClientTracker records the client version of every state write
+func (h *StateHandler) SetClientTracker(tracker *clients.Tracker) {
+	h.clients = tracker
+}
+
+// SetWriteQueue serializes concurrent writes of the same state, rejecting
+// writes that waited while another write of the state succeeded
+func (h *StateHandler) SetWriteQueue(queue *statequeue.Queue) {
+	h.writes = queue
+}
+
 // GetState handles GET requests for state retrieval
 func (h *StateHandler) GetState(w http.ResponseWriter, r *http.Request) {
 	orgID, ok := auth.GetOrgIDFromContext(r.Context())
@@ -37,7 +89,11 @@ func (h *StateHandler) GetState(w http.ResponseWriter, r *http.Request) {
 	stateName := chi.URLParam(r, "name")
 	if err := validation.ValidateStateName(stateName); err != nil {
 		http.Error(w, "Invalid state name", http.StatusBadRequest)
-		log.Printf("SECURITY: Invalid state name from org %s: %v", orgID, err)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, false) {
 		return
 	}
 
@@ -56,7 +112,133 @@ func (h *StateHandler) GetState(w http.ResponseWriter, r *http.Request) {
 	w.Write(state.Data)
 }
 
-// PutState handles POST/PUT requests for state updates
+// ListStates handles GET requests listing the organization's states
+// (workspaces) by name in pages of ?limit, continuing ?after or ?before a
+// cursor from a previous page. States the key cannot read are not listed.
+func (h *StateHandler) ListStates(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lister, ok := h.storage.(storage.StateLister)
+	if !ok {
+		http.Error(w, "State listing is not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	params, _, err := parsePageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	states, err := lister.ListStates(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list states", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to list states", http.StatusInternalServerError)
+		return
+	}
+
+	// Filter before paging so restricted keys still get full pages
+	key, _ := auth.GetKeyFromContext(r.Context())
+	readable := states[:0]
+	for _, state := range states {
+		if key.CanAccessState(state.Name, false) {
+			readable = append(readable, state)
+		}
+	}
+
+	page, next, prev, err := pageStates(readable, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":      orgID.String(),
+		"count":       len(page),
+		"states":      page,
+		"next_cursor": next,
+		"prev_cursor": prev,
+	})
+}
+
+// workspaceSummary is a workspace under a prefix and its state
+type workspaceSummary struct {
+	Workspace string `json:"workspace"`
+	storage.StateSummary
+}
+
+// ListWorkspaces handles GET requests listing the workspaces under a
+// workspace prefix, like `terraform workspace list`: the state
+// "{prefix}:{workspace}" is the workspace, and the state named just
+// "{prefix}" is the default workspace. States the key cannot read are not
+// listed.
+func (h *StateHandler) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := chi.URLParam(r, "prefix")
+	if err := validation.ValidateStatePrefix(prefix); err != nil {
+		http.Error(w, "Invalid workspace prefix", http.StatusBadRequest)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid workspace prefix").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	prefix = strings.TrimSuffix(prefix, validation.WorkspaceSeparator)
+
+	lister, ok := h.storage.(storage.StateLister)
+	if !ok {
+		http.Error(w, "State listing is not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	states, err := lister.ListStates(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list states", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to list states", http.StatusInternalServerError)
+		return
+	}
+
+	key, _ := auth.GetKeyFromContext(r.Context())
+	workspaces := []workspaceSummary{}
+	for _, state := range states {
+		workspace, found := strings.CutPrefix(state.Name, prefix+validation.WorkspaceSeparator)
+		if state.Name == prefix {
+			workspace, found = defaultWorkspace, true
+		}
+		if found && key.CanAccessState(state.Name, false) {
+			workspaces = append(workspaces, workspaceSummary{Workspace: workspace, StateSummary: state})
+		}
+	}
+
+	// The default workspace comes first, like in the Terraform CLI
+	sort.SliceStable(workspaces, func(i, j int) bool {
+		return workspaces[i].Name == prefix && workspaces[j].Name != prefix
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":     orgID.String(),
+		"prefix":     prefix,
+		"count":      len(workspaces),
+		"workspaces": workspaces,
+	})
+}
+
+// defaultWorkspace is the name of the workspace stored under the bare prefix
+const defaultWorkspace = "default"
+
+// PutState handles POST/PUT requests for state updates. A locked state is
+// only written by the holder of the lock, identified by ?ID= or LockIDHeader.
 func (h *StateHandler) PutState(w http.ResponseWriter, r *http.Request) {
 	orgID, ok := auth.GetOrgIDFromContext(r.Context())
 	if !ok {
@@ -67,33 +249,234 @@ func (h *StateHandler) PutState(w http.ResponseWriter, r *http.Request) {
 	stateName := chi.URLParam(r, "name")
 	if err := validation.ValidateStateName(stateName); err != nil {
 		http.Error(w, "Invalid state name", http.StatusBadRequest)
-		log.Printf("SECURITY: Invalid state name from org %s: %v", orgID, err)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
 		return
 	}
-
-	// Read state data from request body
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+	if !authorizeState(w, r, orgID, stateName, true) {
 		return
 	}
+	lockID := r.URL.Query().Get(lockIDParam)
+	if lockID == "" {
+		lockID = r.Header.Get(LockIDHeader)
+	}
+
 	defer r.Body.Close()
 
-	// Validate that the data is valid JSON
-	if !json.Valid(data) {
-		http.Error(w, "Invalid JSON state data", http.StatusBadRequest)
-		return
+	// One write of the state at a time, even from clients that do not lock
+	release := func(bool) {}
+	if h.writes != nil {
+		var err error
+		release, err = h.writes.Acquire(r.Context(), orgID, stateName)
+		if err != nil {
+			rejectStateWrite(w, r, orgID, stateName, err)
+			return
+		}
+		defer release(false)
 	}
 
-	// Store the state
-	if err := h.storage.PutState(orgID, stateName, data); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to store state: %v", err), http.StatusInternalServerError)
+	// Backends that support it receive the state while it is validated, so
+	// large states are not buffered in full. The lock is checked as the
+	// state is stored, so a lock taken while the write waited is respected.
+	var size int64
+	var err error
+	if streamer, ok := h.storage.(storage.StateStreamer); ok {
+		size, err = h.putStateStream(streamer, orgID, stateName, lockID, r.Body)
+	} else {
+		size, err = h.putStateBuffered(orgID, stateName, lockID, r.Body)
+	}
+	release(err == nil)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		var lockErr *storage.LockHeldError
+		switch {
+		case errors.As(err, &lockErr):
+			slog.InfoContext(r.Context(), "Write of locked state rejected", "org_id", orgID, "state", stateName,
+				"lock_id", lockErr.Lock.ID, "holder", lockErr.Lock.Who, "request_lock_id", lockID, "ip", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusLocked)
+			json.NewEncoder(w).Encode(lockErr.Lock)
+		case errors.Is(err, storage.ErrNotLocked):
+			http.Error(w, "State is not locked", http.StatusConflict)
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, fmt.Sprintf("State too large: maximum %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errInvalidState):
+			http.Error(w, "Invalid JSON state data", http.StatusBadRequest)
+		case errors.Is(err, errReadBody):
+			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to store state: %v", err), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	if h.usage != nil {
+		h.usage.AddBytesStored(orgID, size)
+	}
+
+	client := clients.Parse(r)
+	if h.clients != nil {
+		h.clients.Record(orgID, client, clients.OpStateWrite)
+	}
+
+	if h.changes != nil {
+		change := changes.Change{Type: changes.TypeStateVersion, Resource: changes.ResourceState, StateName: stateName, Client: client.String()}
+		if state, err := h.storage.GetState(orgID, stateName); err == nil {
+			change.Version = state.Version
+		}
+		recordChange(h.changes, orgID, change)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// checkWriteLock checks that lockID may write a state of a backend that
+// cannot check it atomically with the write, returning the errors of
+// storage.LockCheckedWriter. Writes are serialized by the write queue, so
+// only clients that lock without writing can race the check.
+func (h *StateHandler) checkWriteLock(orgID uuid.UUID, stateName, lockID string) error {
+	lock, err := h.storage.GetLock(orgID, stateName)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		if lockID != "" {
+			return storage.ErrNotLocked
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read lock: %w", err)
+	case lock.ID != lockID:
+		return &storage.LockHeldError{Lock: *lock}
+	}
+	return nil
+}
+
+// rejectStateWrite answers a state write that the write queue did not
+// admit
+func rejectStateWrite(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, stateName string, err error) {
+	switch {
+	case errors.Is(err, statequeue.ErrConflict):
+		slog.InfoContext(r.Context(), "Concurrent state write rejected", "org_id", orgID, "state", stateName, "ip", r.RemoteAddr)
+		http.Error(w, "State was changed by a concurrent write; read it again before writing", http.StatusConflict)
+	case errors.Is(err, statequeue.ErrQueueFull), errors.Is(err, statequeue.ErrTimeout):
+		slog.WarnContext(r.Context(), "State write rejected", "org_id", orgID, "state", stateName, "error", err, "ip", r.RemoteAddr)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many concurrent writes of the state; use state locking", http.StatusTooManyRequests)
+	default:
+		// The client went away while waiting
+	}
+}
+
+// authorizeState rejects requests whose key is restricted to other state
+// names by state path scopes, writing 403 and returning false
+func authorizeState(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, stateName string, write bool) bool {
+	// Keys from stores without scope support get DefaultScopes
+	key, hasKey := auth.GetKeyFromContext(r.Context())
+	if key.CanAccessState(stateName, write) {
+		return true
+	}
+
+	access := "read"
+	if write {
+		access = "write"
+	}
+	event := security.Request(r, "state.access_denied", security.SeverityWarning, "State access denied by key scopes").
+		WithOrg(orgID.String()).With("state", stateName).With("access", access)
+	if hasKey {
+		event = event.WithKey(key.ID())
+	}
+	security.Emit(event)
+	http.Error(w, fmt.Sprintf("API key lacks %s access to state %s", access, stateName), http.StatusForbidden)
+	return false
+}
+
+var (
+	// errReadBody marks failures to read the request body
+	errReadBody = errors.New("failed to read request body")
+
+	// errInvalidState marks a request body that is not valid JSON
+	errInvalidState = errors.New("invalid JSON state data")
+)
+
+// bodyReader wraps request body read errors with errReadBody, so they can be
+// told apart from JSON syntax errors and storage errors
+type bodyReader struct {
+	r io.Reader
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", errReadBody, err)
+	}
+	return n, err
+}
+
+// putStateStream validates the body token by token while writing it to the
+// backend, and only commits the state if the body is complete, valid JSON
+func (h *StateHandler) putStateStream(streamer storage.StateStreamer, orgID uuid.UUID, stateName, lockID string, body io.Reader) (int64, error) {
+	writer, err := streamer.NewStateWriter(orgID, stateName, lockID)
+	if err != nil {
+		return 0, err
+	}
+
+	counter := &countingWriter{w: writer}
+	if err := validation.ValidateJSONStream(io.TeeReader(bodyReader{body}, counter)); err != nil {
+		writer.Abort()
+		if counter.err != nil {
+			return 0, counter.err
+		}
+		if errors.Is(err, errReadBody) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: %w", errInvalidState, err)
+	}
+
+	if err := writer.Commit(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// putStateBuffered reads the whole body, validates it and stores it if
+// lockID may write it
+func (h *StateHandler) putStateBuffered(orgID uuid.UUID, stateName, lockID string, body io.Reader) (int64, error) {
+	data, err := io.ReadAll(bodyReader{body})
+	if err != nil {
+		return 0, err
+	}
+
+	if !json.Valid(data) {
+		return 0, errInvalidState
+	}
+
+	if checked, ok := h.storage.(storage.LockCheckedWriter); ok {
+		err = checked.PutStateLocked(orgID, stateName, data, lockID)
+	} else if err = h.checkWriteLock(orgID, stateName, lockID); err == nil {
+		err = h.storage.PutState(orgID, stateName, data)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// countingWriter counts the bytes written to a state writer and keeps its
+// first error, so storage failures are not reported as invalid JSON
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
 // DeleteState handles DELETE requests for state removal
 func (h *StateHandler) DeleteState(w http.ResponseWriter, r *http.Request) {
 	orgID, ok := auth.GetOrgIDFromContext(r.Context())
@@ -105,7 +488,11 @@ func (h *StateHandler) DeleteState(w http.ResponseWriter, r *http.Request) {
 	stateName := chi.URLParam(r, "name")
 	if err := validation.ValidateStateName(stateName); err != nil {
 		http.Error(w, "Invalid state name", http.StatusBadRequest)
-		log.Printf("SECURITY: Invalid state name from org %s: %v", orgID, err)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, true) {
 		return
 	}
 
@@ -123,6 +510,8 @@ func (h *StateHandler) DeleteState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordChange(h.changes, orgID, changes.Change{Type: changes.TypeDelete, Resource: changes.ResourceState, StateName: stateName})
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -137,7 +526,11 @@ func (h *StateHandler) LockState(w http.ResponseWriter, r *http.Request) {
 	stateName := chi.URLParam(r, "name")
 	if err := validation.ValidateStateName(stateName); err != nil {
 		http.Error(w, "Invalid state name", http.StatusBadRequest)
-		log.Printf("SECURITY: Invalid state name from org %s: %v", orgID, err)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, true) {
 		return
 	}
 
@@ -168,6 +561,16 @@ func (h *StateHandler) LockState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.history.Record(orgID, stateName, storage.LockEvent{
+		Action:    storage.LockActionAcquire,
+		LockID:    lockInfo.ID,
+		Who:       lockInfo.Who,
+		Operation: lockInfo.Operation,
+		SourceIP:  sourceIP(r),
+	})
+	slog.InfoContext(r.Context(), "Lock acquired", "org_id", orgID, "state", stateName,
+		"lock_id", lockInfo.ID, "who", lockInfo.Who, "ip", r.RemoteAddr)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -182,7 +585,99 @@ func (h *StateHandler) UnlockState(w http.ResponseWriter, r *http.Request) {
 	stateName := chi.URLParam(r, "name")
 	if err := validation.ValidateStateName(stateName); err != nil {
 		http.Error(w, "Invalid state name", http.StatusBadRequest)
-		log.Printf("SECURITY: Invalid state name from org %s: %v", orgID, err)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, true) {
+		return
+	}
+
+	// Read lock info from request body to get lock ID
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// terraform force-unlock sends no lock info because it does not hold the
+	// lock, so it needs the scope of the force-unlock endpoint
+	if len(bytes.TrimSpace(body)) == 0 {
+		auth.RequireScope(auth.ScopeStateAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.forceUnlockState(w, r, orgID, stateName)
+		})).ServeHTTP(w, r)
+		return
+	}
+
+	var lockInfo storage.LockInfo
+	if err := json.Unmarshal(body, &lockInfo); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode lock info: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Look up the holder before unlocking so the history records who held it
+	currentLock, _ := h.storage.GetLock(orgID, stateName)
+
+	// Unlock the state
+	err = h.storage.UnlockState(orgID, stateName, lockInfo.ID)
+	if err != nil {
+		var lockErr *storage.LockHeldError
+		switch {
+		case errors.Is(err, storage.ErrNotLocked):
+			http.Error(w, "State is not locked", http.StatusConflict)
+		case errors.As(err, &lockErr):
+			// Return current lock info, as for a contended lock
+			slog.InfoContext(r.Context(), "Unlock with another lock ID rejected", "org_id", orgID, "state", stateName,
+				"lock_id", lockErr.Lock.ID, "request_lock_id", lockInfo.ID, "ip", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusLocked)
+			json.NewEncoder(w).Encode(lockErr.Lock)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to unlock state: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	event := storage.LockEvent{
+		Action:   storage.LockActionRelease,
+		LockID:   lockInfo.ID,
+		SourceIP: sourceIP(r),
+	}
+	if currentLock != nil {
+		event.Who = currentLock.Who
+		event.Operation = currentLock.Operation
+	}
+	h.history.Record(orgID, stateName, event)
+	slog.InfoContext(r.Context(), "Lock released", "org_id", orgID, "state", stateName,
+		"lock_id", lockInfo.ID, "ip", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RefreshLock handles heartbeat requests by which the lock holder extends
+// its lease during long operations
+func (h *StateHandler) RefreshLock(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	refresher, ok := h.storage.(storage.LockRefresher)
+	if !ok {
+		http.Error(w, "Lock refresh is not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	stateName := chi.URLParam(r, "name")
+	if err := validation.ValidateStateName(stateName); err != nil {
+		http.Error(w, "Invalid state name", http.StatusBadRequest)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, true) {
 		return
 	}
 
@@ -194,16 +689,127 @@ func (h *StateHandler) UnlockState(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// Unlock the state
-	err := h.storage.UnlockState(orgID, stateName, lockInfo.ID)
+	if lockInfo.ID == "" {
+		http.Error(w, "Lock ID is required", http.StatusBadRequest)
+		return
+	}
+
+	refreshedAt, err := refresher.RefreshLock(orgID, stateName, lockInfo.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotLocked):
+			http.Error(w, "State is not locked", http.StatusConflict)
+		case errors.Is(err, storage.ErrLockMismatch):
+			// Return current lock info, as for a contended lock
+			currentLock, _ := h.storage.GetLock(orgID, stateName)
+			if currentLock == nil {
+				http.Error(w, "State is locked", http.StatusLocked)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusLocked)
+			json.NewEncoder(w).Encode(currentLock)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to refresh lock: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":         stateName,
+		"lock_id":      lockInfo.ID,
+		"refreshed_at": refreshedAt.UTC(),
+	})
+}
+
+// ForceUnlockState handles DELETE requests removing a lock without its
+// lock ID, for locks left behind by clients that died
+func (h *StateHandler) ForceUnlockState(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stateName := chi.URLParam(r, "name")
+	if err := validation.ValidateStateName(stateName); err != nil {
+		http.Error(w, "Invalid state name", http.StatusBadRequest)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, true) {
+		return
+	}
+
+	h.forceUnlockState(w, r, orgID, stateName)
+}
+
+// forceUnlockState removes the lock regardless of its holder, recording
+// which key forced it
+func (h *StateHandler) forceUnlockState(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, stateName string) {
+	lock, err := h.storage.ForceUnlockState(orgID, stateName)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotLocked) {
 			http.Error(w, "State is not locked", http.StatusConflict)
 			return
 		}
-		http.Error(w, fmt.Sprintf("Failed to unlock state: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to force-unlock state: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	forcedBy := ""
+	if key, ok := auth.GetKeyFromContext(r.Context()); ok {
+		forcedBy = key.ID()
+	}
+	h.history.Record(orgID, stateName, storage.LockEvent{
+		Action:    storage.LockActionForceUnlock,
+		LockID:    lock.ID,
+		Who:       lock.Who,
+		Operation: lock.Operation,
+		SourceIP:  sourceIP(r),
+		ForcedBy:  forcedBy,
+	})
+	event := security.Request(r, "state.force_unlocked", security.SeverityWarning, "State lock force-unlocked").
+		WithOrg(orgID.String()).With("state", stateName).With("lock_id", lock.ID).With("holder", lock.Who)
+	if forcedBy != "" {
+		event = event.WithKey(forcedBy)
+	}
+	security.Emit(event)
+	slog.InfoContext(r.Context(), "Lock force-unlocked", "org_id", orgID, "state", stateName,
+		"lock_id", lock.ID, "holder", lock.Who, "forced_by", forcedBy, "ip", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetLockHistory handles GET requests for the lock history of a state
+func (h *StateHandler) GetLockHistory(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stateName := chi.URLParam(r, "name")
+	if err := validation.ValidateStateName(stateName); err != nil {
+		http.Error(w, "Invalid state name", http.StatusBadRequest)
+		security.Emit(security.Request(r, "validation.state_name", security.SeverityWarning, "Invalid state name").
+			WithOrg(orgID.String()).With("error", err))
+		return
+	}
+	if !authorizeState(w, r, orgID, stateName, false) {
+		return
+	}
+
+	events := h.history.Events(orgID, stateName)
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":   stateName,
+		"count":  len(events),
+		"events": events,
+	})
 }
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// State operations of TransferState, given after the state name as in
+// POST /admin/v1/orgs/{orgID}/state/{name}:rename
+const (
+	stateOpCopy   = "copy"
+	stateOpRename = "rename"
+	stateOpMove   = "move"
+)
+
+// stateTransferRequest is the body of a copy, rename or move
+type stateTransferRequest struct {
+	To        string    `json:"to,omitempty"`        // Destination state name (default: the same name)
+	ToOrgID   uuid.UUID `json:"to_org_id"`           // Destination organization (default: the same organization)
+	Overwrite bool      `json:"overwrite,omitempty"` // Replace an existing destination state
+}
+
+// TransferState handles admin POST requests that copy, rename or move a
+// state, named by the {stateOp} URL parameter "{name}:{operation}". A copy
+// keeps the source; a rename (within the organization) and a move (to
+// another organization) delete it. Locked states cannot be renamed, moved
+// or overwritten, and an existing destination is only replaced with
+// "overwrite": true.
+func (h *StateHandler) TransferState(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// State names may contain colons, so the operation follows the last one
+	param := chi.URLParam(r, "stateOp")
+	sep := strings.LastIndex(param, ":")
+	if sep < 0 {
+		http.Error(w, "Missing operation: use {name}:copy, {name}:rename or {name}:move", http.StatusNotFound)
+		return
+	}
+	name, op := param[:sep], param[sep+1:]
+	if op != stateOpCopy && op != stateOpRename && op != stateOpMove {
+		http.Error(w, fmt.Sprintf("Unknown state operation %q: use copy, rename or move", op), http.StatusNotFound)
+		return
+	}
+	if err := validation.ValidateStateName(name); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid state name: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var req stateTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Failed to decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	toOrgID, toName := req.ToOrgID, req.To
+	if toOrgID == uuid.Nil {
+		toOrgID = orgID
+	}
+	if toName == "" {
+		toName = name
+	}
+	switch {
+	case op == stateOpRename && req.To == "":
+		http.Error(w, "Invalid request: to is required for a rename", http.StatusBadRequest)
+		return
+	case op == stateOpRename && toOrgID != orgID:
+		http.Error(w, "Invalid request: use move to transfer a state to another organization", http.StatusBadRequest)
+		return
+	case op == stateOpMove && toOrgID == orgID:
+		http.Error(w, "Invalid request: to_org_id of another organization is required for a move", http.StatusBadRequest)
+		return
+	case toOrgID == orgID && toName == name:
+		http.Error(w, "Invalid request: the destination is the source state", http.StatusBadRequest)
+		return
+	}
+	if err := validation.ValidateStateName(toName); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid destination state name: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.storage.GetState(orgID, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "State not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to retrieve state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// A locked source may be mid-apply; copying it is still a consistent read
+	if op != stateOpCopy && h.locked(orgID, name) {
+		http.Error(w, "State is locked", http.StatusLocked)
+		return
+	}
+	if h.locked(toOrgID, toName) {
+		http.Error(w, "Destination state is locked", http.StatusLocked)
+		return
+	}
+	_, err = h.storage.GetState(toOrgID, toName)
+	destExists := err == nil
+	switch {
+	case destExists && !req.Overwrite:
+		http.Error(w, "Destination state already exists: set overwrite to replace it", http.StatusConflict)
+		return
+	case err != nil && !errors.Is(err, storage.ErrNotFound):
+		http.Error(w, fmt.Sprintf("Failed to retrieve destination state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.PutState(toOrgID, toName, state.Data); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store state: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if op != stateOpCopy {
+		if err := h.storage.DeleteState(orgID, name); err != nil {
+			// Undo the copy so the state does not exist twice
+			if !destExists {
+				if undoErr := h.storage.DeleteState(toOrgID, toName); undoErr != nil {
+					slog.ErrorContext(r.Context(), "Failed to undo state copy", "org_id", orgID, "state", name,
+						"to_org_id", toOrgID, "to_state", toName, "error", undoErr)
+				}
+			}
+			if errors.Is(err, storage.ErrAlreadyLocked) {
+				http.Error(w, "State is locked", http.StatusLocked)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to delete source state: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.usage != nil {
+		h.usage.AddBytesStored(toOrgID, int64(len(state.Data)))
+	}
+	version := int64(0)
+	if stored, err := h.storage.GetState(toOrgID, toName); err == nil {
+		version = stored.Version
+	}
+	recordChange(h.changes, toOrgID, changes.Change{Type: changes.TypeStateVersion, Resource: changes.ResourceState, StateName: toName, Version: version})
+	if op != stateOpCopy {
+		recordChange(h.changes, orgID, changes.Change{Type: changes.TypeDelete, Resource: changes.ResourceState, StateName: name})
+	}
+
+	event := map[string]string{stateOpCopy: "state.copied", stateOpRename: "state.renamed", stateOpMove: "state.moved"}[op]
+	security.Emit(security.Request(r, event, security.SeverityWarning, "State "+op+" by operator").
+		WithOrg(orgID.String()).With("state", name).With("to_org_id", toOrgID.String()).With("to", toName).
+		With("overwrote", destExists))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"operation": op,
+		"from":      map[string]interface{}{"org_id": orgID, "name": name},
+		"to":        map[string]interface{}{"org_id": toOrgID, "name": toName, "version": version},
+		"overwrote": destExists,
+		"size":      len(state.Data),
+	})
+}
+
+// DeleteStates handles admin DELETE requests that delete all states of an
+// organization whose name starts with ?prefix=. Locked states are skipped
+// and reported. With ?dry_run=true the states are only listed.
+func (h *StateHandler) DeleteStates(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "Missing prefix parameter", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	lister, ok := h.storage.(storage.StateLister)
+	if !ok {
+		http.Error(w, "State listing is not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+	states, err := lister.ListStates(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list states", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to list states", http.StatusInternalServerError)
+		return
+	}
+
+	deleted := make([]string, 0)
+	locked := make([]string, 0)
+	failed := make(map[string]string)
+	for _, state := range states {
+		if !strings.HasPrefix(state.Name, prefix) {
+			continue
+		}
+		if state.Locked {
+			locked = append(locked, state.Name)
+			continue
+		}
+		if dryRun {
+			deleted = append(deleted, state.Name)
+			continue
+		}
+
+		err := h.storage.DeleteState(orgID, state.Name)
+		switch {
+		case err == nil:
+			deleted = append(deleted, state.Name)
+			recordChange(h.changes, orgID, changes.Change{Type: changes.TypeDelete, Resource: changes.ResourceState, StateName: state.Name})
+		case errors.Is(err, storage.ErrAlreadyLocked):
+			locked = append(locked, state.Name)
+		case errors.Is(err, storage.ErrNotFound):
+			// Deleted concurrently
+		default:
+			slog.ErrorContext(r.Context(), "Failed to delete state", "org_id", orgID, "state", state.Name, "error", err)
+			failed[state.Name] = err.Error()
+		}
+	}
+
+	if !dryRun {
+		security.Emit(security.Request(r, "state.bulk_deleted", security.SeverityWarning, "States deleted by prefix by operator").
+			WithOrg(orgID.String()).With("prefix", prefix).With("deleted", len(deleted)).
+			With("locked", len(locked)).With("failed", len(failed)))
+	}
+
+	status := http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":  orgID.String(),
+		"prefix":  prefix,
+		"dry_run": dryRun,
+		"deleted": deleted,
+		"locked":  locked,
+		"failed":  failed,
+	})
+}
+
+// locked reports whether a state is locked
+func (h *StateHandler) locked(orgID uuid.UUID, name string) bool {
+	lock, err := h.storage.GetLock(orgID, name)
+	return err == nil && lock != nil
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/rollup"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+)
+
+// Summary sources: daily rollups, which may lag behind the raw data, or
+// the raw rows
+const (
+	summarySourceRollup = "rollup"
+	summarySourceRaw    = "raw"
+)
+
+// GetDataSummary handles GET requests for row counts and numeric
+// aggregates of an organization's data, e.g.
+// ?aggregate=sum:cost_monthly&group_by=provider for a cost rollup per
+// provider without exporting the rows. Queries the daily rollups can
+// answer are served from them unless ?source=raw is given.
+func (h *UploadHandler) GetDataSummary(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var query storage.SummaryQuery
+	if list := r.URL.Query().Get("group_by"); list != "" {
+		var err error
+		if query.GroupBy, err = storage.ParseGroupBy(list); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid group_by: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if list := r.URL.Query().Get("aggregate"); list != "" {
+		var err error
+		if query.Aggregations, err = storage.ParseAggregations(list); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid aggregate: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	source := r.URL.Query().Get("source")
+	if source != "" && source != summarySourceRollup && source != summarySourceRaw {
+		http.Error(w, fmt.Sprintf("Invalid source %q: must be rollup or raw", source), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{"org_id": orgID.String()}
+
+	var groups []storage.SummaryGroup
+	if h.rollups != nil && source != summarySourceRaw {
+		if orgRollup, ok := h.rollups.Get(orgID); ok {
+			if groups, ok = orgRollup.Summarize(query); ok {
+				response["source"] = summarySourceRollup
+				response["fresh_through"] = orgRollup.Through
+				response["built_at"] = orgRollup.BuiltAt
+			}
+		}
+	}
+	if groups == nil {
+		if source == summarySourceRollup {
+			http.Error(w, "Summary is not available from rollups", http.StatusConflict)
+			return
+		}
+
+		var err error
+		if groups, err = storage.SummarizeOrgData(h.dataStorage, orgID, query); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to summarize data", "org_id", orgID, "error", err)
+			http.Error(w, "Failed to summarize data", http.StatusInternalServerError)
+			return
+		}
+		response["source"] = summarySourceRaw
+	}
+
+	var total int64
+	for _, group := range groups {
+		total += group.Count
+	}
+	groupBy := query.GroupBy
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+	aggregations := make([]string, 0, len(query.Aggregations))
+	for _, aggregation := range query.Aggregations {
+		aggregations = append(aggregations, aggregation.Name())
+	}
+
+	response["count"] = total
+	response["group_by"] = groupBy
+	response["aggregations"] = aggregations
+	response["groups"] = groups
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetDataRollups handles GET requests for the daily rollup buckets of an
+// organization, optionally limited to ?from and ?to dates (YYYY-MM-DD)
+func (h *UploadHandler) GetDataRollups(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(rollup.DayFormat, date); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date %q: must be YYYY-MM-DD", date), http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"org_id":        orgID.String(),
+		"fresh_through": nil,
+		"built_at":      nil,
+		"sum_fields":    []string{},
+		"buckets":       []rollup.Bucket{},
+	}
+	// Organizations are rolled up in the background, so a new one has no
+	// rollup until the next build
+	if orgRollup, ok := h.rollups.Get(orgID); ok {
+		response["fresh_through"] = orgRollup.Through
+		response["built_at"] = orgRollup.BuiltAt
+		response["sum_fields"] = orgRollup.SumFields
+		response["buckets"] = orgRollup.Days(from, to)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/google/uuid"
+)
+
+// TaxonomyHandler manages the per-organization provider, category and
+// resource type allowlists enforced on uploads
+type TaxonomyHandler struct {
+	store *taxonomy.Store
+}
+
+// NewTaxonomyHandler creates a new taxonomy handler
+func NewTaxonomyHandler(store *taxonomy.Store) *TaxonomyHandler {
+	return &TaxonomyHandler{
+		store: store,
+	}
+}
+
+// writeTaxonomy writes an allowlist response; restricted is false for
+// organizations without an allowlist
+func writeTaxonomy(w http.ResponseWriter, orgID uuid.UUID, t taxonomy.Taxonomy, restricted bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"org_id":     orgID.String(),
+		"restricted": restricted,
+		"taxonomy":   t,
+	})
+}
+
+// GetTaxonomy handles GET requests for an organization's allowlist
+func (h *TaxonomyHandler) GetTaxonomy(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	t, exists := h.store.Get(orgID)
+	writeTaxonomy(w, orgID, t, exists)
+}
+
+// PutTaxonomy handles PUT requests that replace an organization's allowlist
+func (h *TaxonomyHandler) PutTaxonomy(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req taxonomy.Taxonomy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode taxonomy: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Allowed values must themselves be valid upload values
+	for _, provider := range req.Providers {
+		if err := validation.ValidateProvider(provider); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid provider '%s': %v", provider, err), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, category := range req.Categories {
+		if err := validation.ValidateCategory(category); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid category '%s': %v", category, err), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, resourceType := range req.ResourceTypes {
+		if err := validation.ValidateResourceType(resourceType); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid resource_type '%s': %v", resourceType, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	t, err := h.store.Set(orgID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save taxonomy", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to save taxonomy", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Taxonomy updated", "org_id", orgID, "providers", len(t.Providers),
+		"categories", len(t.Categories), "resource_types", len(t.ResourceTypes))
+	writeTaxonomy(w, orgID, t, true)
+}
+
+// DeleteTaxonomy handles DELETE requests that remove an organization's
+// allowlist, leaving its uploads unrestricted
+func (h *TaxonomyHandler) DeleteTaxonomy(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	existed, err := h.store.Delete(orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete taxonomy", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to delete taxonomy", http.StatusInternalServerError)
+		return
+	}
+	if !existed {
+		http.Error(w, "Taxonomy not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetOrgTaxonomy handles GET requests for the caller's own allowlist so
+// providers can discover the accepted values
+func (h *TaxonomyHandler) GetOrgTaxonomy(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	t, exists := h.store.Get(orgID)
+	writeTaxonomy(w, orgID, t, exists)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// TokenHandler exchanges API keys for short-lived session tokens
+type TokenHandler struct {
+	tokens *auth.TokenIssuer
+}
+
+// NewTokenHandler creates a new token handler
+func NewTokenHandler(tokens *auth.TokenIssuer) *TokenHandler {
+	return &TokenHandler{
+		tokens: tokens,
+	}
+}
+
+// TokenResponse is the result of a token exchange
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresIn int64     `json:"expires_in"` // Seconds
+	ExpiresAt time.Time `json:"expires_at"`
+	Scopes    []string  `json:"scopes"`
+}
+
+// IssueToken handles POST requests exchanging the authenticated org and API
+// key for a session token. It must run behind auth.Middleware, never behind
+// auth.SessionMiddleware, so sessions cannot be extended without the key.
+func (h *TokenHandler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Chained stores without key support authenticate with an empty key
+	key, hasKey := auth.GetKeyFromContext(r.Context())
+	hasKey = hasKey && key.Key != ""
+
+	token, expiresAt, err := h.tokens.Issue(orgID, key, hasKey)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to issue session token", "org_id", orgID, "error", err)
+		http.Error(w, "Failed to issue session token", http.StatusInternalServerError)
+		return
+	}
+
+	scopes := auth.DefaultScopes
+	if hasKey {
+		scopes = key.EffectiveScopes()
+	}
+
+	security.Emit(security.Request(r, "auth.token_issued", security.SeverityInfo, "Session token issued").
+		WithOrg(orgID.String()).With("expires_at", expiresAt.Format(time.RFC3339)))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresIn: int64(time.Until(expiresAt).Round(time.Second).Seconds()),
+		ExpiresAt: expiresAt,
+		Scopes:    scopes,
+	})
+}
@@ -2,43 +2,182 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
+	"github.com/eterrain/tf-backend-service/internal/ingesthook"
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/querycache"
+	"github.com/eterrain/tf-backend-service/internal/quota"
+	"github.com/eterrain/tf-backend-service/internal/rollup"
+	"github.com/eterrain/tf-backend-service/internal/security"
 	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
 	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
 )
 
+// UploadSchemaVersion is the version of the row layout produced by uploads,
+// recorded in the lineage of every stored row
+const UploadSchemaVersion = 1
+
+// ProviderVersionHeader carries the Terraform provider version of the client
+const ProviderVersionHeader = "X-Provider-Version"
+
+// UsageRecorder records bytes written to storage for usage accounting
+type UsageRecorder interface {
+	AddBytesStored(orgID uuid.UUID, n int64)
+}
+
+// StorageErrorRecorder counts writes that failed with a storage error
+type StorageErrorRecorder interface {
+	ObserveStorageWriteError(operation string)
+}
+
 // UploadHandler handles data upload operations from Terraform provider
 type UploadHandler struct {
 	dataStorage storage.DataStorage
+	usage       UsageRecorder
+	changes     ChangeRecorder
+	taxonomy    *taxonomy.Store
+	normalizer  *normalize.Store
+	rollups     *rollup.Builder
+	queryCache  *querycache.Cache
+	quarantine  *quarantine.Store
+	clients     *clients.Tracker
+	hooks       *ingesthook.Runner
+	ingestCaps  *quota.IngestCaps
+	writeErrors StorageErrorRecorder
+	uploads     *uploadTracker
+	syncs       *fullSyncLimiter
+
+	// Accepted window for client-provided observation timestamps
+	maxClockSkew      time.Duration
+	maxObservationAge time.Duration
 }
 
 // NewUploadHandler creates a new upload handler
 func NewUploadHandler(dataStorage storage.DataStorage) *UploadHandler {
 	return &UploadHandler{
-		dataStorage: dataStorage,
+		dataStorage:       dataStorage,
+		uploads:           newUploadTracker(),
+		syncs:             newFullSyncLimiter(),
+		maxClockSkew:      5 * time.Minute,
+		maxObservationAge: 7 * 24 * time.Hour,
 	}
 }
 
+// SetTimestampBounds configures how far client observation timestamps may be
+// ahead of server time (maxSkew) or in the past (maxAge, 0 for unlimited)
+func (h *UploadHandler) SetTimestampBounds(maxSkew, maxAge time.Duration) {
+	h.maxClockSkew = maxSkew
+	h.maxObservationAge = maxAge
+}
+
+// SetUsageRecorder enables usage accounting of stored bytes
+func (h *UploadHandler) SetUsageRecorder(usage UsageRecorder) {
+	h.usage = usage
+}
+
+// SetChangeRecorder enables change feed entries for stored rows
+func (h *UploadHandler) SetChangeRecorder(recorder ChangeRecorder) {
+	h.changes = recorder
+}
+
+// SetTaxonomy enables enforcement of per-organization provider, category
+// and resource type allowlists
+func (h *UploadHandler) SetTaxonomy(store *taxonomy.Store) {
+	h.taxonomy = store
+}
+
+// SetNormalizer enables per-organization normalization of attribute values
+// before storage
+func (h *UploadHandler) SetNormalizer(store *normalize.Store) {
+	h.normalizer = store
+}
+
+// SetRollups serves summaries from daily rollups where possible and
+// schedules an organization's rollup for rebuilding when it uploads
+func (h *UploadHandler) SetRollups(builder *rollup.Builder) {
+	h.rollups = builder
+}
+
+// SetQueryCache drops an organization's cached query responses when it
+// uploads
+func (h *UploadHandler) SetQueryCache(cache *querycache.Cache) {
+	h.queryCache = cache
+}
+
+// SetHooks runs transformation hooks on accepted uploads before their rows
+// are stored
+func (h *UploadHandler) SetHooks(runner *ingesthook.Runner) {
+	h.hooks = runner
+}
+
+// SetIngestCaps enforces daily per-organization caps on uploaded bytes and
+// rows
+func (h *UploadHandler) SetIngestCaps(caps *quota.IngestCaps) {
+	h.ingestCaps = caps
+}
+
+// SetStorageErrorRecorder counts rows that failed to be stored
+func (h *UploadHandler) SetStorageErrorRecorder(recorder StorageErrorRecorder) {
+	h.writeErrors = recorder
+}
+
 // InstanceUpload represents a single instance within a resource
 type InstanceUpload struct {
 	Attributes map[string]interface{} `json:"attributes"`
+	ObservedAt string                 `json:"observed_at,omitempty"` // Overrides the upload-level observation time
 }
 
-// ResourceUpload represents the hierarchical structure for resource uploads
+// ResourceUpload represents the hierarchical structure for resource uploads.
+// It is the v1 request body, and v2 and CSV uploads are converted to it, so
+// every upload format is validated and flattened into rows the same way.
 type ResourceUpload struct {
 	Provider     string           `json:"provider"`
 	Category     string           `json:"category"`
 	ResourceType string           `json:"resource_type"`
-	Name         string           `json:"name,omitempty"` // Optional name for the report/upload
+	Name         string           `json:"name,omitempty"`        // Optional name for the report/upload
+	ObservedAt   string           `json:"observed_at,omitempty"` // Optional client observation time (RFC3339)
 	Instances    []InstanceUpload `json:"instances"`
+
+	// Set from v2 envelopes only
+	idempotencyKey  string
+	envelopeVersion int
+}
+
+// uploadError is a validation failure reported to the client
+type uploadError struct {
+	status  int
+	message string
+}
+
+// Error implements the error interface
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// badUpload creates a 400 Bad Request upload error
+func badUpload(format string, args ...interface{}) *uploadError {
+	return &uploadError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
 }
 
-// UploadData handles POST requests for data uploads from Terraform provider
+// UploadData handles POST requests for data uploads from Terraform provider.
+// With ?dry_run=true the payload is fully validated and the rows that would
+// be stored are returned without persisting anything.
 func (h *UploadHandler) UploadData(w http.ResponseWriter, r *http.Request) {
 	orgID, ok := auth.GetOrgIDFromContext(r.Context())
 	if !ok {
@@ -46,90 +185,376 @@ func (h *UploadHandler) UploadData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	progress, uploadErr := h.uploads.start(orgID, r, dryRun)
+	if uploadErr != nil {
+		http.Error(w, uploadErr.message, uploadErr.status)
+		return
+	}
+
 	// Read request body with size limit (already limited by middleware, but double-check)
 	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, 10<<20)) // 10MB limit
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		failUpload(w, progress, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
+	if uploadErr := progress.verifyChecksum(r, orgID); uploadErr != nil {
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	upload, rows, uploadErr := h.prepareUpload(orgID, r, progress, bodyBytes)
+	if uploadErr != nil {
+		h.quarantineUpload(w, r, orgID, bodyBytes, uploadErr, dryRun)
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	h.storeUpload(w, r, orgID, progress, upload, rows, dryRun)
+}
+
+// storeUpload persists the prepared rows (or reports them for a dry run)
+// and writes the response with the upload's accounting headers. With
+// ?dedupe=true, content the organization stored within the upload status
+// retention is acknowledged without being stored again.
+func (h *UploadHandler) storeUpload(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, progress *trackedUpload, upload *ResourceUpload, rows []map[string]interface{}, dryRun bool) {
+	// A v2 upload repeating the idempotency key of a stored upload is a
+	// retry, whatever its content
+	if !dryRun && upload.idempotencyKey != "" {
+		original, uploadErr := h.uploads.claimKey(orgID, upload.idempotencyKey, progress)
+		if uploadErr != nil {
+			failUpload(w, progress, uploadErr.status, uploadErr.message)
+			return
+		}
+		if original != nil {
+			writeDuplicate(w, r, orgID, progress, original, upload, "An upload with this idempotency key was already stored, nothing was stored")
+			return
+		}
+	}
+
+	if !dryRun && r.URL.Query().Get("dedupe") == "true" {
+		if original, found := h.uploads.findStored(orgID, progress.digest(), progress); found {
+			writeDuplicate(w, r, orgID, progress, original, upload, "Identical content was already stored, nothing was stored")
+			return
+		}
+	}
+
+	// Hooks see accepted uploads only; dry runs return the rows they produce
+	if h.hooks != nil {
+		transformed, uploadErr := h.runHooks(r, orgID, progress, upload, rows)
+		if uploadErr != nil {
+			failUpload(w, progress, uploadErr.status, uploadErr.message)
+			return
+		}
+		rows = transformed
+	}
+
+	// Counted once the final rows are known; dry runs and duplicates are free
+	if !dryRun && h.ingestCaps != nil {
+		if err := h.ingestCaps.Admit(orgID, progress.snapshot().BytesReceived, int64(len(rows))); err != nil {
+			var capErr *quota.CapExceededError
+			if errors.As(err, &capErr) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(capErr.ResetAt).Seconds()))))
+				w.Header().Set(quota.ResetHeader, capErr.ResetAt.Format(time.RFC3339))
+			}
+			failUpload(w, progress, http.StatusTooManyRequests, err.Error())
+			return
+		}
+	}
+
+	progress.storing(len(rows))
+
+	if dryRun {
+		progress.finish("")
+		progress.writeHeaders(w)
+
+		slog.InfoContext(r.Context(), "Dry-run upload", "org_id", orgID, "provider", upload.Provider, "category", upload.Category,
+			"resource_type", upload.ResourceType, "instances", len(upload.Instances), "ip", r.RemoteAddr)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		response := map[string]interface{}{
+			"status":          "dry_run",
+			"message":         fmt.Sprintf("Validation passed, %d instance(s) would be stored", len(rows)),
+			"org_id":          orgID.String(),
+			"instances_count": len(rows),
+			"rows":            rows,
+		}
+		upload.describeEnvelope(response)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Rows stored before a failure must be rolled up too
+	if h.rollups != nil {
+		h.rollups.MarkDirty(orgID)
+	}
+
+	// Store each instance separately
+	uploadID := progress.id()
+	for _, data := range rows {
+		// Append data to storage (CSV, MySQL, or both)
+		if err := h.dataStorage.AppendData(orgID, data); err != nil {
+			if h.writeErrors != nil {
+				h.writeErrors.ObserveStorageWriteError("append")
+			}
+			h.invalidateQueries(orgID)
+			failUpload(w, progress, http.StatusInternalServerError, fmt.Sprintf("Failed to store data: %v", err))
+			return
+		}
+		progress.stored()
+		recordChange(h.changes, orgID, changes.Change{Type: changes.TypeInsert, Resource: changes.ResourceData, UploadID: uploadID, Data: data})
+
+		if h.usage != nil {
+			if encoded, err := json.Marshal(data); err == nil {
+				h.usage.AddBytesStored(orgID, int64(len(encoded)))
+			}
+		}
+	}
+
+	// Before the response, so the client reads its own upload
+	h.invalidateQueries(orgID)
+	progress.finish("")
+	stats := progress.snapshot()
+
+	client := clients.Parse(r)
+	if h.clients != nil {
+		h.clients.Record(orgID, client, clients.OpUpload)
+	}
+
+	// Log successful upload
+	attrs := []any{
+		"org_id", orgID, "provider", upload.Provider, "category", upload.Category, "resource_type", upload.ResourceType,
+		"instances", len(upload.Instances), "bytes", stats.BytesReceived, "duration_ms", stats.DurationMs,
+		"upload_id", stats.UploadID, "client", client.String(), "ip", r.RemoteAddr,
+	}
+	if upload.Name != "" {
+		attrs = append(attrs, "report_name", upload.Name)
+	}
+	slog.InfoContext(r.Context(), "Successful upload", attrs...)
+
+	// Return success response
+	response := map[string]interface{}{
+		"status":          "success",
+		"message":         fmt.Sprintf("Successfully uploaded %d instance(s)", len(upload.Instances)),
+		"org_id":          orgID.String(),
+		"instances_count": len(upload.Instances),
+		"upload_id":       stats.UploadID,
+		"content_sha256":  stats.ContentSHA256,
+	}
+
+	// Include report name in response if provided
+	if upload.Name != "" {
+		response["report_name"] = upload.Name
+	}
+	upload.describeEnvelope(response)
+
+	progress.writeHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// runHooks passes the prepared rows through the upload hooks. A hook that
+// refuses the upload fails it with 422, and a failed hook whose policy
+// rejects uploads fails it with 503.
+func (h *UploadHandler) runHooks(r *http.Request, orgID uuid.UUID, progress *trackedUpload, upload *ResourceUpload, rows []map[string]interface{}) ([]map[string]interface{}, *uploadError) {
+	transformed, err := h.hooks.Run(r.Context(), ingesthook.Upload{
+		OrgID:        orgID,
+		UploadID:     progress.id(),
+		Provider:     upload.Provider,
+		Category:     upload.Category,
+		ResourceType: upload.ResourceType,
+		Name:         upload.Name,
+		Rows:         rows,
+	})
+	if err == nil {
+		return transformed, nil
+	}
+
+	var rejected *ingesthook.RejectError
+	if errors.As(err, &rejected) {
+		return nil, &uploadError{status: http.StatusUnprocessableEntity, message: fmt.Sprintf("Upload rejected by hook %s: %s", rejected.Hook, rejected.Reason)}
+	}
+	var failed *ingesthook.FailedError
+	if errors.As(err, &failed) {
+		return nil, &uploadError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("Upload hook %s failed, nothing was stored", failed.Hook)}
+	}
+	return nil, &uploadError{status: http.StatusInternalServerError, message: fmt.Sprintf("Upload hooks failed: %v", err)}
+}
+
+// SetClientTracker records the client version of every stored upload
+func (h *UploadHandler) SetClientTracker(tracker *clients.Tracker) {
+	h.clients = tracker
+}
+
+// invalidateQueries drops the organization's cached query responses after
+// rows were stored
+func (h *UploadHandler) invalidateQueries(orgID uuid.UUID) {
+	if h.queryCache != nil {
+		h.queryCache.Invalidate(orgID)
+	}
+}
+
+// writeDuplicate marks the upload as a duplicate of original and
+// acknowledges it without storing anything
+func writeDuplicate(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, progress *trackedUpload, original *trackedUpload, upload *ResourceUpload, message string) {
+	progress.duplicate(original)
+	stats := progress.snapshot()
+
+	slog.InfoContext(r.Context(), "Duplicate upload skipped", "org_id", orgID, "upload_id", stats.UploadID,
+		"duplicate_of", stats.DuplicateOf, "sha256", stats.ContentSHA256, "ip", r.RemoteAddr)
+
+	response := map[string]interface{}{
+		"status":         UploadDuplicate,
+		"message":        message,
+		"org_id":         orgID.String(),
+		"upload_id":      stats.UploadID,
+		"duplicate_of":   stats.DuplicateOf,
+		"content_sha256": stats.ContentSHA256,
+	}
+	upload.describeEnvelope(response)
+
+	progress.writeHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// describeEnvelope adds the schema version and idempotency key of a v2
+// upload to its response
+func (u *ResourceUpload) describeEnvelope(response map[string]interface{}) {
+	if u.envelopeVersion != 0 {
+		response["schema_version"] = u.envelopeVersion
+	}
+	if u.idempotencyKey != "" {
+		response["idempotency_key"] = u.idempotencyKey
+	}
+}
+
+// prepareUpload validates the request body and flattens every instance into
+// the row that would be appended to storage. Nothing is persisted, so all
+// instances are validated before any of them is stored.
+func (h *UploadHandler) prepareUpload(orgID uuid.UUID, r *http.Request, progress *trackedUpload, bodyBytes []byte) (*ResourceUpload, []map[string]interface{}, *uploadError) {
 	// Validate JSON size and format
 	if err := validation.ValidateJSONString(bodyBytes, 10<<20); err != nil {
-		log.Printf("SECURITY: Invalid JSON data from org %s - IP: %s, Error: %v", orgID, r.RemoteAddr, err)
-		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-		return
+		security.Emit(security.Request(r, "validation.invalid_json", security.SeverityWarning, "Invalid JSON data").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, nil, badUpload("Invalid JSON data")
 	}
 
 	// Parse JSON data from request body
 	var upload ResourceUpload
 	if err := json.Unmarshal(bodyBytes, &upload); err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
-		return
+		return nil, nil, badUpload("Failed to decode request body")
 	}
 
 	// Validate JSON depth (max 10 levels deep)
 	if err := validation.ValidateJSONDepth(upload, 10); err != nil {
-		log.Printf("SECURITY: JSON depth violation from org %s - IP: %s, Error: %v", orgID, r.RemoteAddr, err)
-		http.Error(w, "JSON structure too deeply nested", http.StatusBadRequest)
-		return
+		security.Emit(security.Request(r, "validation.json_depth", security.SeverityWarning, "JSON depth violation").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, nil, badUpload("JSON structure too deeply nested")
 	}
 
 	// Validate JSON complexity (max 1000 total elements)
 	if err := validation.ValidateJSONComplexity(upload, 1000); err != nil {
-		log.Printf("SECURITY: JSON complexity violation from org %s - IP: %s, Error: %v", orgID, r.RemoteAddr, err)
-		http.Error(w, "JSON structure too complex", http.StatusBadRequest)
-		return
+		security.Emit(security.Request(r, "validation.json_complexity", security.SeverityWarning, "JSON complexity violation").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, nil, badUpload("JSON structure too complex")
+	}
+
+	rows, uploadErr := h.prepareRows(orgID, r, progress, &upload)
+	if uploadErr != nil {
+		return nil, nil, uploadErr
 	}
+	return &upload, rows, nil
+}
 
+// prepareRows validates a decoded upload and flattens every instance into
+// the row that would be appended to storage, recording the upload ID and
+// content digest in the lineage
+func (h *UploadHandler) prepareRows(orgID uuid.UUID, r *http.Request, progress *trackedUpload, upload *ResourceUpload) ([]map[string]interface{}, *uploadError) {
 	// Validate required fields with specific validators
 	if err := validation.ValidateProvider(upload.Provider); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid provider: %v", err), http.StatusBadRequest)
-		return
+		return nil, badUpload("Invalid provider: %v", err)
 	}
 
 	if err := validation.ValidateCategory(upload.Category); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid category: %v", err), http.StatusBadRequest)
-		return
+		return nil, badUpload("Invalid category: %v", err)
 	}
 
 	if err := validation.ValidateResourceType(upload.ResourceType); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid resource_type: %v", err), http.StatusBadRequest)
-		return
+		return nil, badUpload("Invalid resource_type: %v", err)
+	}
+
+	// Enforce the organization's allowlist to stop fragmentation such as
+	// "aws" vs "AWS" vs "amazon"
+	if h.taxonomy != nil {
+		if err := h.taxonomy.Check(orgID, upload.Provider, upload.Category, upload.ResourceType); err != nil {
+			return nil, badUpload("%v", err)
+		}
 	}
 
 	// Validate instances array
 	if len(upload.Instances) == 0 {
-		http.Error(w, "At least one instance is required in the instances array", http.StatusBadRequest)
-		return
+		return nil, badUpload("At least one instance is required in the instances array")
 	}
 
 	// Limit number of instances to prevent resource exhaustion
 	if len(upload.Instances) > 100 {
-		http.Error(w, "Too many instances: maximum 100 instances per request", http.StatusBadRequest)
-		return
+		return nil, badUpload("Too many instances: maximum 100 instances per request")
+	}
+
+	// Validate the upload-level observation timestamp once
+	now := time.Now().UTC()
+	var observedAt time.Time
+	if upload.ObservedAt != "" {
+		parsed, err := validation.NormalizeTimestamp(upload.ObservedAt, now, h.maxClockSkew, h.maxObservationAge)
+		if err != nil {
+			return nil, badUpload("Invalid observed_at: %v", err)
+		}
+		observedAt = parsed
+	}
+
+	// Lineage is shared by every row of this upload
+	lineage := storage.Lineage{
+		UploadID:        progress.id(),
+		ContentSHA256:   progress.digest(),
+		RequestID:       middleware.GetReqID(r.Context()),
+		SourceIP:        sourceIP(r),
+		ProviderVersion: r.Header.Get(ProviderVersionHeader),
+		SchemaVersion:   UploadSchemaVersion,
+		Client:          clients.Parse(r).String(),
+	}
+	if err := validation.ValidateProviderVersion(lineage.ProviderVersion); err != nil {
+		return nil, badUpload("Invalid %s header: %v", ProviderVersionHeader, err)
 	}
 
-	// Process each instance and store separately
+	rows := make([]map[string]interface{}, 0, len(upload.Instances))
 	for idx, instance := range upload.Instances {
 		// Limit number of attributes per instance
 		if len(instance.Attributes) > 100 {
-			http.Error(w, fmt.Sprintf("Instance %d has too many attributes: maximum 100 attributes per instance", idx), http.StatusBadRequest)
-			return
+			return nil, badUpload("Instance %d has too many attributes: maximum 100 attributes per instance", idx)
 		}
 
 		// Validate all attributes before processing
 		for k, v := range instance.Attributes {
 			if err := validation.ValidateAttributeKey(k); err != nil {
-				http.Error(w, fmt.Sprintf("Invalid attribute key '%s' in instance %d: %v", k, idx, err), http.StatusBadRequest)
-				return
+				return nil, badUpload("Invalid attribute key '%s' in instance %d: %v", k, idx, err)
 			}
 			if err := validation.ValidateAttributeValue(v); err != nil {
-				http.Error(w, fmt.Sprintf("Invalid attribute value for '%s' in instance %d: %v", k, idx, err), http.StatusBadRequest)
-				return
+				return nil, badUpload("Invalid attribute value for '%s' in instance %d: %v", k, idx, err)
 			}
 		}
 
+		// Clean values with the org's rules so reports see canonical values
+		if h.normalizer != nil {
+			h.normalizer.Apply(orgID, instance.Attributes)
+		}
+
 		// Convert to flat map for CSV storage
 		data := map[string]interface{}{
 			"provider":      upload.Provider,
@@ -158,40 +583,41 @@ func (h *UploadHandler) UploadData(w http.ResponseWriter, r *http.Request) {
 			data[k] = v
 		}
 
-		// Append data to storage (CSV, MySQL, or both)
-		if err := h.dataStorage.AppendData(orgID, data); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to store data: %v", err), http.StatusInternalServerError)
-			return
+		// Record the client observation time normalized to RFC3339 UTC;
+		// the storage timestamp remains the server ingestion time
+		instanceObservedAt := observedAt
+		if instance.ObservedAt != "" {
+			parsed, err := validation.NormalizeTimestamp(instance.ObservedAt, now, h.maxClockSkew, h.maxObservationAge)
+			if err != nil {
+				return nil, badUpload("Invalid observed_at in instance %d: %v", idx, err)
+			}
+			instanceObservedAt = parsed
+		}
+		if !instanceObservedAt.IsZero() {
+			data["observed_at"] = instanceObservedAt.Format(time.RFC3339Nano)
 		}
-	}
 
-	// Log successful upload
-	logMsg := fmt.Sprintf("DATA: Successful upload - OrgID: %s, Provider: %s, Category: %s, ResourceType: %s, Instances: %d, IP: %s",
-		orgID, upload.Provider, upload.Category, upload.ResourceType, len(upload.Instances), r.RemoteAddr)
-	if upload.Name != "" {
-		logMsg += fmt.Sprintf(", ReportName: %s", upload.Name)
-	}
-	log.Print(logMsg)
+		// Server-generated lineage is applied last so clients cannot spoof it
+		lineage.Apply(data)
 
-	// Return success response
-	response := map[string]interface{}{
-		"status":          "success",
-		"message":         fmt.Sprintf("Successfully uploaded %d instance(s)", len(upload.Instances)),
-		"org_id":          orgID.String(),
-		"instances_count": len(upload.Instances),
+		rows = append(rows, data)
 	}
 
-	// Include report name in response if provided
-	if upload.Name != "" {
-		response["report_name"] = upload.Name
-	}
+	return rows, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+// sourceIP returns the client IP of the request without the port
+func sourceIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
 }
 
-// GetOrgData handles GET requests to retrieve all data for an organization
+// GetOrgData handles GET requests to retrieve all data for an organization.
+// With ?fields=resource_name,tags.env each row's data only contains the
+// listed fields. With ?limit, ?after or ?before the data is returned in
+// pages with next and previous cursors.
 func (h *UploadHandler) GetOrgData(w http.ResponseWriter, r *http.Request) {
 	orgID, ok := auth.GetOrgIDFromContext(r.Context())
 	if !ok {
@@ -199,23 +625,64 @@ func (h *UploadHandler) GetOrgData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var fields []string
+	if list := r.URL.Query().Get("fields"); list != "" {
+		var err error
+		if fields, err = storage.ParseFields(list); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fields: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	params, paged, err := parsePageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Retrieve data from storage (CSV, MySQL, or both)
-	uploads, err := h.dataStorage.GetOrgData(orgID)
+	var uploads []storage.DataUpload
+	var page storage.DataPage
+	switch {
+	case paged:
+		query, cursorErr := params.dataPageQuery()
+		if cursorErr != nil {
+			http.Error(w, cursorErr.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err = storage.PageOrgData(h.dataStorage, orgID, query)
+		uploads = page.Uploads
+		if fields != nil {
+			for i := range uploads {
+				uploads[i].Data = storage.ProjectFields(uploads[i].Data, fields)
+			}
+		}
+	case fields != nil:
+		uploads, err = storage.SelectOrgDataFields(h.dataStorage, orgID, fields)
+	default:
+		uploads, err = h.dataStorage.GetOrgData(orgID)
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to retrieve data for org %s - Error: %v", orgID, err)
+		slog.ErrorContext(r.Context(), "Failed to retrieve data", "org_id", orgID, "error", err)
 		http.Error(w, "Failed to retrieve data", http.StatusInternalServerError)
 		return
 	}
 
 	// Log data retrieval
-	log.Printf("DATA: Data retrieval - OrgID: %s, RecordCount: %d, IP: %s", orgID, len(uploads), r.RemoteAddr)
+	slog.InfoContext(r.Context(), "Data retrieval", "org_id", orgID, "records", len(uploads), "ip", r.RemoteAddr)
 
-	// Return data as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"org_id": orgID.String(),
 		"count":  len(uploads),
 		"data":   uploads,
-	})
+	}
+	if paged {
+		response["next_cursor"] = cursorValue(page.Next)
+		response["prev_cursor"] = cursorValue(page.Prev)
+	}
+
+	// Return data as JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
 }
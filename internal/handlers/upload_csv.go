@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+)
+
+// csvObservedAtTarget maps a CSV column to the instance observation time
+// instead of an attribute
+const csvObservedAtTarget = "observed_at"
+
+// UploadCSV handles POST requests that upload a CSV file for legacy tooling.
+// The request is multipart/form-data with the CSV in the "file" part, or
+// application/x-www-form-urlencoded with the CSV in the "csv" field. Form
+// fields provider, category, resource_type, name and observed_at match the
+// JSON upload, and the optional "mapping" field is a JSON object mapping CSV
+// columns to attribute keys. Each data row becomes one instance and goes
+// through the same validation pipeline as JSON uploads.
+func (h *UploadHandler) UploadCSV(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	progress, uploadErr := h.uploads.start(orgID, r, dryRun)
+	if uploadErr != nil {
+		http.Error(w, uploadErr.message, uploadErr.status)
+		return
+	}
+
+	source, err := csvFormSource(r)
+	if err != nil {
+		failUpload(w, progress, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer source.Close()
+
+	if uploadErr := progress.verifyChecksum(r, orgID); uploadErr != nil {
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	var mapping map[string]string
+	if value := r.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			failUpload(w, progress, http.StatusBadRequest, "Invalid mapping: must be a JSON object of CSV column to attribute key")
+			return
+		}
+	}
+
+	instances, uploadErr := parseCSVInstances(source, mapping)
+	if uploadErr != nil {
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	upload := &ResourceUpload{
+		Provider:     r.FormValue("provider"),
+		Category:     r.FormValue("category"),
+		ResourceType: r.FormValue("resource_type"),
+		Name:         r.FormValue("name"),
+		ObservedAt:   r.FormValue("observed_at"),
+		Instances:    instances,
+	}
+
+	rows, uploadErr := h.prepareRows(orgID, r, progress, upload)
+	if uploadErr != nil {
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	h.storeUpload(w, r, orgID, progress, upload, rows, dryRun)
+}
+
+// csvFormSource returns the CSV content of a multipart or urlencoded form
+func csvFormSource(r *http.Request) (io.ReadCloser, error) {
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, errors.New("Failed to parse form")
+	}
+
+	if errors.Is(err, http.ErrNotMultipart) {
+		if err := r.ParseForm(); err != nil {
+			return nil, errors.New("Failed to parse form")
+		}
+	} else if file, _, err := r.FormFile("file"); err == nil {
+		return file, nil
+	}
+
+	content := r.FormValue("csv")
+	if content == "" {
+		return nil, errors.New("Missing CSV: send a \"file\" part or a \"csv\" form field")
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+// parseCSVInstances converts CSV rows into instances. The first row is the
+// header. Without a mapping every column becomes an attribute named after
+// its header; with a mapping only the mapped columns are imported. Empty
+// cells are skipped. A leading BOM is ignored and the delimiter is detected
+// from the header.
+func parseCSVInstances(source io.Reader, mapping map[string]string) ([]InstanceUpload, *uploadError) {
+	reader, _ := csvfmt.NewReader(source)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, badUpload("CSV is empty: a header row is required")
+	}
+	if err != nil {
+		return nil, badUpload("Invalid CSV header: %v", err)
+	}
+
+	// Resolve the attribute key of each column ("" skips the column)
+	targets := make([]string, len(header))
+	columns := make(map[string]bool, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if columns[column] {
+			return nil, badUpload("Duplicate CSV column '%s'", column)
+		}
+		columns[column] = true
+
+		if mapping == nil {
+			targets[i] = column
+		} else {
+			targets[i] = mapping[column]
+		}
+	}
+	for column := range mapping {
+		if !columns[column] {
+			return nil, badUpload("Mapping references unknown CSV column '%s'", column)
+		}
+	}
+
+	var instances []InstanceUpload
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, badUpload("Invalid CSV: %v", err)
+		}
+
+		instance := InstanceUpload{Attributes: make(map[string]interface{})}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if targets[i] == "" || value == "" {
+				continue
+			}
+			if targets[i] == csvObservedAtTarget {
+				instance.ObservedAt = value
+				continue
+			}
+			instance.Attributes[targets[i]] = value
+		}
+
+		// Skip blank rows
+		if len(instance.Attributes) == 0 && instance.ObservedAt == "" {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// UploadIDHeader names an upload. Clients may set it to poll the
+	// upload's progress while it is processed; otherwise an ID is generated.
+	UploadIDHeader = "X-Upload-ID"
+
+	// IngestedBytesHeader reports the request body bytes read
+	IngestedBytesHeader = "X-Ingested-Bytes"
+
+	// IngestedInstancesHeader reports the instances stored (or, for dry
+	// runs, validated)
+	IngestedInstancesHeader = "X-Ingested-Instances"
+
+	// ProcessingDurationHeader reports the time spent on the upload in
+	// milliseconds
+	ProcessingDurationHeader = "X-Processing-Duration-Ms"
+
+	// ContentSHA256Header carries the hex SHA-256 digest of the request
+	// body. Clients may set it to detect corruption in transit; the
+	// response always reports the digest of the body received.
+	ContentSHA256Header = "X-Content-SHA256"
+)
+
+// Upload statuses
+const (
+	UploadReceiving = "receiving"
+	UploadStoring   = "storing"
+	UploadCompleted = "completed"
+	UploadFailed    = "failed"
+
+	// UploadDuplicate marks an upload skipped by ?dedupe=true because the
+	// organization stored the same content recently
+	UploadDuplicate = "duplicate"
+)
+
+// Finished uploads are reported for uploadRetention; at most maxTrackedUploads
+// are kept, dropping the oldest finished ones first
+const (
+	uploadRetention   = time.Hour
+	maxTrackedUploads = 10000
+	maxUploadIDLength = 64
+)
+
+// UploadProgress reports the progress of an upload
+type UploadProgress struct {
+	UploadID        string     `json:"upload_id"`
+	Status          string     `json:"status"`
+	DryRun          bool       `json:"dry_run,omitempty"`
+	BytesReceived   int64      `json:"bytes_received"`
+	ContentSHA256   string     `json:"content_sha256,omitempty"`
+	IdempotencyKey  string     `json:"idempotency_key,omitempty"`
+	DuplicateOf     string     `json:"duplicate_of,omitempty"`
+	InstancesTotal  int        `json:"instances_total"`
+	InstancesStored int        `json:"instances_stored"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	DurationMs      int64      `json:"duration_ms"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// trackedUpload is an upload in progress or recently finished
+type trackedUpload struct {
+	body *countingBody
+
+	mu       sync.Mutex
+	progress UploadProgress
+}
+
+// countingBody counts and hashes the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n    atomic.Int64
+	hash hash.Hash
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+// uploadTracker keeps the progress of recent uploads per organization
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*trackedUpload // org ID and upload ID -> upload
+}
+
+func newUploadTracker() *uploadTracker {
+	return &uploadTracker{uploads: make(map[string]*trackedUpload)}
+}
+
+// start tracks a new upload, counting the bytes read from the request body.
+// It fails if the client-provided upload ID is invalid or still in use.
+func (t *uploadTracker) start(orgID uuid.UUID, r *http.Request, dryRun bool) (*trackedUpload, *uploadError) {
+	uploadID := r.Header.Get(UploadIDHeader)
+	if uploadID == "" {
+		uploadID = uuid.New().String()
+	} else if !validUploadID(uploadID) {
+		return nil, badUpload("Invalid %s header (1-%d characters of A-Z, a-z, 0-9, '-' and '_')", UploadIDHeader, maxUploadIDLength)
+	}
+	if digest := r.Header.Get(ContentSHA256Header); digest != "" && !validDigest(digest) {
+		return nil, badUpload("Invalid %s header (64 hexadecimal characters)", ContentSHA256Header)
+	}
+
+	body := &countingBody{ReadCloser: r.Body, hash: sha256.New()}
+	r.Body = body
+	upload := &trackedUpload{
+		body: body,
+		progress: UploadProgress{
+			UploadID:  uploadID,
+			Status:    UploadReceiving,
+			DryRun:    dryRun,
+			StartedAt: time.Now().UTC(),
+		},
+	}
+
+	key := orgID.String() + "/" + uploadID
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if existing, ok := t.uploads[key]; ok && !existing.finished() {
+		return nil, &uploadError{status: http.StatusConflict, message: fmt.Sprintf("Upload %s is still in progress", uploadID)}
+	}
+	t.evict(time.Now())
+	t.uploads[key] = upload
+	return upload, nil
+}
+
+// evict drops expired uploads and, above maxTrackedUploads, the oldest
+// finished ones. Must be called with t.mu held.
+func (t *uploadTracker) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, upload := range t.uploads {
+		upload.mu.Lock()
+		finishedAt := upload.progress.FinishedAt
+		upload.mu.Unlock()
+		if finishedAt == nil {
+			continue
+		}
+		if now.Sub(*finishedAt) > uploadRetention {
+			delete(t.uploads, key)
+			continue
+		}
+		if oldestKey == "" || finishedAt.Before(oldest) {
+			oldestKey, oldest = key, *finishedAt
+		}
+	}
+	if len(t.uploads) >= maxTrackedUploads && oldestKey != "" {
+		delete(t.uploads, oldestKey)
+	}
+}
+
+// get returns the organization's upload
+func (t *uploadTracker) get(orgID uuid.UUID, uploadID string) (*trackedUpload, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	upload, ok := t.uploads[orgID.String()+"/"+uploadID]
+	return upload, ok
+}
+
+// findStored returns a completed upload of the organization, other than
+// except, that stored content with the given digest
+func (t *uploadTracker) findStored(orgID uuid.UUID, digest string, except *trackedUpload) (*trackedUpload, bool) {
+	prefix := orgID.String() + "/"
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, upload := range t.uploads {
+		if upload == except || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		upload.mu.Lock()
+		progress := upload.progress
+		upload.mu.Unlock()
+		if progress.Status == UploadCompleted && !progress.DryRun && progress.ContentSHA256 == digest {
+			return upload, true
+		}
+	}
+	return nil, false
+}
+
+// claimKey records the idempotency key of the upload. It returns the
+// completed upload of the organization that stored under the same key, if
+// any, and fails while another upload with the key is in progress. Failed
+// uploads do not hold their key, so they can be retried.
+func (t *uploadTracker) claimKey(orgID uuid.UUID, key string, upload *trackedUpload) (*trackedUpload, *uploadError) {
+	prefix := orgID.String() + "/"
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for trackedKey, other := range t.uploads {
+		if other == upload || !strings.HasPrefix(trackedKey, prefix) {
+			continue
+		}
+		other.mu.Lock()
+		progress := other.progress
+		other.mu.Unlock()
+		if progress.IdempotencyKey != key || progress.DryRun {
+			continue
+		}
+		switch progress.Status {
+		case UploadCompleted:
+			return other, nil
+		case UploadReceiving, UploadStoring:
+			return nil, &uploadError{status: http.StatusConflict, message: fmt.Sprintf("Upload with idempotency key %s is still in progress", key)}
+		}
+	}
+
+	upload.mu.Lock()
+	upload.progress.IdempotencyKey = key
+	upload.mu.Unlock()
+	return nil, nil
+}
+
+// validDigest checks that a client digest is a hex SHA-256
+func validDigest(digest string) bool {
+	if len(digest) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// validUploadID checks the length and characters of a client upload ID
+func validUploadID(id string) bool {
+	if len(id) == 0 || len(id) > maxUploadIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// id returns the upload ID, which is also recorded in the lineage of the
+// upload's rows
+func (u *trackedUpload) id() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress.UploadID
+}
+
+// verifyChecksum reads the rest of the request body and records its
+// digest, failing if it does not match the client's X-Content-SHA256
+func (u *trackedUpload) verifyChecksum(r *http.Request, orgID uuid.UUID) *uploadError {
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		return badUpload("Failed to read request body")
+	}
+	digest := hex.EncodeToString(u.body.hash.Sum(nil))
+
+	u.mu.Lock()
+	u.progress.ContentSHA256 = digest
+	u.mu.Unlock()
+
+	if expected := r.Header.Get(ContentSHA256Header); expected != "" && !strings.EqualFold(expected, digest) {
+		security.Emit(security.Request(r, "upload.checksum_mismatch", security.SeverityWarning, "Upload checksum mismatch").
+			WithOrg(orgID.String()).With("upload_id", u.id()).
+			With("expected", strings.ToLower(expected)).With("received", digest))
+		return badUpload("%s mismatch: the request body was modified in transit or the digest is wrong", ContentSHA256Header)
+	}
+	return nil
+}
+
+// digest returns the SHA-256 digest of the request body, once verified
+func (u *trackedUpload) digest() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress.ContentSHA256
+}
+
+// duplicate marks the upload as skipped because original stored the same
+// content
+func (u *trackedUpload) duplicate(original *trackedUpload) {
+	originalID := original.id()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now().UTC()
+	u.progress.FinishedAt = &now
+	u.progress.Status = UploadDuplicate
+	u.progress.DuplicateOf = originalID
+}
+
+// storing marks the upload as validated with total instances to store
+func (u *trackedUpload) storing(total int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.progress.Status = UploadStoring
+	u.progress.InstancesTotal = total
+}
+
+// stored counts an instance written to storage
+func (u *trackedUpload) stored() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.progress.InstancesStored++
+}
+
+// finish marks the upload completed, or failed with message
+func (u *trackedUpload) finish(message string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now().UTC()
+	u.progress.FinishedAt = &now
+	u.progress.Status = UploadCompleted
+	if message != "" {
+		u.progress.Status = UploadFailed
+		u.progress.Error = message
+	}
+}
+
+// finished reports whether the upload completed or failed
+func (u *trackedUpload) finished() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress.FinishedAt != nil
+}
+
+// snapshot returns the current progress
+func (u *trackedUpload) snapshot() UploadProgress {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	progress := u.progress
+	progress.BytesReceived = u.body.n.Load()
+	end := time.Now()
+	if progress.FinishedAt != nil {
+		end = *progress.FinishedAt
+	}
+	progress.DurationMs = end.Sub(progress.StartedAt).Milliseconds()
+	return progress
+}
+
+// writeHeaders sets the upload ID and accounting headers on the response
+func (u *trackedUpload) writeHeaders(w http.ResponseWriter) {
+	progress := u.snapshot()
+	instances := progress.InstancesStored
+	if progress.DryRun {
+		instances = progress.InstancesTotal
+	}
+	w.Header().Set(UploadIDHeader, progress.UploadID)
+	w.Header().Set(IngestedBytesHeader, strconv.FormatInt(progress.BytesReceived, 10))
+	w.Header().Set(IngestedInstancesHeader, strconv.Itoa(instances))
+	w.Header().Set(ProcessingDurationHeader, strconv.FormatInt(progress.DurationMs, 10))
+	if progress.ContentSHA256 != "" {
+		w.Header().Set(ContentSHA256Header, progress.ContentSHA256)
+	}
+}
+
+// failUpload marks the upload failed and writes the error response
+func failUpload(w http.ResponseWriter, upload *trackedUpload, status int, message string) {
+	upload.finish(message)
+	upload.writeHeaders(w)
+	http.Error(w, message, status)
+}
+
+// GetUploadStatus handles GET requests for the progress of an upload of the
+// organization, identified by its X-Upload-ID
+func (h *UploadHandler) GetUploadStatus(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload, ok := h.uploads.get(orgID, chi.URLParam(r, "uploadID"))
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(upload.snapshot())
+}
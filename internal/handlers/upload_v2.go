@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/google/uuid"
+)
+
+// UploadEnvelopeVersion is the schema version of /api/v2/upload request
+// bodies. Clients must state it, so a future envelope is never misread.
+const UploadEnvelopeVersion = 2
+
+// Attribute types of v2 uploads
+const (
+	AttributeString    = "string"
+	AttributeNumber    = "number"
+	AttributeBool      = "bool"
+	AttributeTimestamp = "timestamp"
+	AttributeJSON      = "json"
+)
+
+// TypedAttribute is a v2 attribute value with its declared type
+type TypedAttribute struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// InstanceUploadV2 is a single instance of a v2 upload
+type InstanceUploadV2 struct {
+	Attributes map[string]TypedAttribute `json:"attributes"`
+	ObservedAt string                    `json:"observed_at,omitempty"` // Overrides the envelope observation time
+}
+
+// UploadEnvelopeV2 is the request body of /api/v2/upload. Unlike v1, the
+// body states its schema version, attribute values carry their type, and
+// the idempotency key travels with the payload instead of in a header.
+type UploadEnvelopeV2 struct {
+	SchemaVersion  int                `json:"schema_version"`
+	IdempotencyKey string             `json:"idempotency_key,omitempty"`
+	Provider       string             `json:"provider"`
+	Category       string             `json:"category"`
+	ResourceType   string             `json:"resource_type"`
+	Name           string             `json:"name,omitempty"`
+	ObservedAt     string             `json:"observed_at,omitempty"` // Client observation time (RFC3339)
+	Instances      []InstanceUploadV2 `json:"instances"`
+}
+
+// UploadDataV2 handles POST requests for v2 data uploads. The envelope is
+// converted to the same upload as v1 requests, so both go through one
+// validation pipeline and produce the same rows. ?dry_run and ?dedupe work
+// as in v1.
+func (h *UploadHandler) UploadDataV2(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := auth.GetOrgIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	progress, uploadErr := h.uploads.start(orgID, r, dryRun)
+	if uploadErr != nil {
+		http.Error(w, uploadErr.message, uploadErr.status)
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, 10<<20)) // 10MB limit
+	if err != nil {
+		failUpload(w, progress, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if uploadErr := progress.verifyChecksum(r, orgID); uploadErr != nil {
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	upload, uploadErr := decodeUploadV2(orgID, r, bodyBytes)
+	if uploadErr != nil {
+		h.quarantineUpload(w, r, orgID, bodyBytes, uploadErr, dryRun)
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	rows, uploadErr := h.prepareRows(orgID, r, progress, upload)
+	if uploadErr != nil {
+		h.quarantineUpload(w, r, orgID, bodyBytes, uploadErr, dryRun)
+		failUpload(w, progress, uploadErr.status, uploadErr.message)
+		return
+	}
+
+	h.storeUpload(w, r, orgID, progress, upload, rows, dryRun)
+}
+
+// decodeUploadV2 validates a v2 envelope and converts it to the upload
+// shared with v1, with typed attribute values decoded to plain values
+func decodeUploadV2(orgID uuid.UUID, r *http.Request, bodyBytes []byte) (*ResourceUpload, *uploadError) {
+	if err := validation.ValidateJSONString(bodyBytes, 10<<20); err != nil {
+		security.Emit(security.Request(r, "validation.invalid_json", security.SeverityWarning, "Invalid JSON data").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, badUpload("Invalid JSON data")
+	}
+
+	var envelope UploadEnvelopeV2
+	if err := json.Unmarshal(bodyBytes, &envelope); err != nil {
+		return nil, badUpload("Failed to decode request body")
+	}
+
+	if envelope.SchemaVersion != UploadEnvelopeVersion {
+		return nil, badUpload("Unsupported schema_version %d: this endpoint accepts schema_version %d", envelope.SchemaVersion, UploadEnvelopeVersion)
+	}
+	if envelope.IdempotencyKey != "" && !validUploadID(envelope.IdempotencyKey) {
+		return nil, badUpload("Invalid idempotency_key (1-%d characters of A-Z, a-z, 0-9, '-' and '_')", maxUploadIDLength)
+	}
+
+	upload := &ResourceUpload{
+		Provider:        envelope.Provider,
+		Category:        envelope.Category,
+		ResourceType:    envelope.ResourceType,
+		Name:            envelope.Name,
+		ObservedAt:      envelope.ObservedAt,
+		Instances:       make([]InstanceUpload, 0, len(envelope.Instances)),
+		idempotencyKey:  envelope.IdempotencyKey,
+		envelopeVersion: envelope.SchemaVersion,
+	}
+	for idx, instance := range envelope.Instances {
+		// Attributes are decoded in key order so errors are deterministic
+		keys := make([]string, 0, len(instance.Attributes))
+		for k := range instance.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		attributes := make(map[string]interface{}, len(instance.Attributes))
+		for _, k := range keys {
+			value, err := instance.Attributes[k].decode()
+			if err != nil {
+				return nil, badUpload("Invalid attribute '%s' in instance %d: %v", k, idx, err)
+			}
+			attributes[k] = value
+		}
+		upload.Instances = append(upload.Instances, InstanceUpload{Attributes: attributes, ObservedAt: instance.ObservedAt})
+	}
+
+	// The same structure limits as v1 apply to the decoded values
+	if err := validation.ValidateJSONDepth(upload, 10); err != nil {
+		security.Emit(security.Request(r, "validation.json_depth", security.SeverityWarning, "JSON depth violation").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, badUpload("JSON structure too deeply nested")
+	}
+	if err := validation.ValidateJSONComplexity(upload, 1000); err != nil {
+		security.Emit(security.Request(r, "validation.json_complexity", security.SeverityWarning, "JSON complexity violation").
+			WithOrg(orgID.String()).With("error", err))
+		return nil, badUpload("JSON structure too complex")
+	}
+
+	return upload, nil
+}
+
+// decode checks that the value matches the declared type and returns it as
+// a v1 attribute value would be decoded. Timestamps are normalized to
+// RFC3339 in UTC.
+func (a TypedAttribute) decode() (interface{}, error) {
+	if len(a.Value) == 0 {
+		return nil, fmt.Errorf("value is required")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(a.Value, &value); err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err)
+	}
+
+	switch a.Type {
+	case AttributeString:
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("expected a string value")
+		}
+	case AttributeNumber:
+		if _, ok := value.(float64); !ok {
+			return nil, fmt.Errorf("expected a number value")
+		}
+	case AttributeBool:
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("expected a bool value")
+		}
+	case AttributeTimestamp:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an RFC3339 timestamp string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC3339 timestamp: %v", err)
+		}
+		value = t.UTC().Format(time.RFC3339Nano)
+	case AttributeJSON:
+		if value == nil {
+			return nil, fmt.Errorf("expected a JSON value other than null")
+		}
+	case "":
+		return nil, fmt.Errorf("type is required (%s, %s, %s, %s or %s)", AttributeString, AttributeNumber, AttributeBool, AttributeTimestamp, AttributeJSON)
+	default:
+		return nil, fmt.Errorf("unknown type %q (%s, %s, %s, %s or %s)", a.Type, AttributeString, AttributeNumber, AttributeBool, AttributeTimestamp, AttributeJSON)
+	}
+	return value, nil
+}
@@ -0,0 +1,227 @@
+// Package health probes the storage backends periodically so degraded disks
+// or databases are detected before request failures pile up.
+package health
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+)
+
+// Observer receives the result of every probe, e.g. to export metrics
+type Observer interface {
+	ObserveStorageProbe(backend string, duration time.Duration, err error)
+}
+
+// Config configures the storage probes
+type Config struct {
+	// Interval is the time between probes of each backend
+	Interval time.Duration
+
+	// Timeout fails a probe that has not finished in time
+	Timeout time.Duration
+
+	// FailureThreshold is the number of consecutive failed probes after
+	// which a backend is reported as not ready (default 1)
+	FailureThreshold int
+
+	// LatencyThreshold fails probes slower than this, so a degraded but
+	// working backend is reported (0 disables)
+	LatencyThreshold time.Duration
+}
+
+// Status is the latest probe result of a backend
+type Status struct {
+	Backend             string    `json:"backend"`
+	Healthy             bool      `json:"healthy"`
+	LatencyMS           float64   `json:"latency_ms"`
+	LastChecked         time.Time `json:"last_checked"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// Monitor probes storage backends and tracks their health
+type Monitor struct {
+	config   Config
+	observer Observer
+	probers  map[string]storage.Prober
+
+	mu       sync.Mutex
+	statuses map[string]*Status
+	running  map[string]bool // probes that are still running after a timeout
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewMonitor creates a monitor; observer may be nil
+func NewMonitor(config Config, observer Observer) *Monitor {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 1
+	}
+	return &Monitor{
+		config:   config,
+		observer: observer,
+		probers:  make(map[string]storage.Prober),
+		statuses: make(map[string]*Status),
+		running:  make(map[string]bool),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Add registers a backend to probe. It must be called before Start.
+func (m *Monitor) Add(backend string, prober storage.Prober) {
+	m.probers[backend] = prober
+}
+
+// Start probes every backend once, so readiness is known immediately, and
+// then keeps probing in the background until Stop is called
+func (m *Monitor) Start() {
+	m.ProbeAll()
+
+	if m.config.Interval <= 0 {
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.ProbeAll()
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background probes
+func (m *Monitor) Stop() {
+	close(m.stopChan)
+	m.wg.Wait()
+}
+
+// ProbeAll probes every backend concurrently and waits for the results
+func (m *Monitor) ProbeAll() {
+	var wg sync.WaitGroup
+	for backend, prober := range m.probers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.probe(backend, prober)
+		}()
+	}
+	wg.Wait()
+}
+
+// probe runs a single probe with the configured timeout and records the result
+func (m *Monitor) probe(backend string, prober storage.Prober) {
+	m.mu.Lock()
+	if m.running[backend] {
+		m.mu.Unlock()
+		m.record(backend, 0, fmt.Errorf("previous probe is still running"))
+		return
+	}
+	m.running[backend] = true
+	m.mu.Unlock()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		err := prober.Probe()
+		m.mu.Lock()
+		m.running[backend] = false
+		m.mu.Unlock()
+		done <- err
+	}()
+
+	var err error
+	if m.config.Timeout > 0 {
+		select {
+		case err = <-done:
+		case <-time.After(m.config.Timeout):
+			err = fmt.Errorf("probe timed out after %v", m.config.Timeout)
+		}
+	} else {
+		err = <-done
+	}
+
+	duration := time.Since(start)
+	if err == nil && m.config.LatencyThreshold > 0 && duration > m.config.LatencyThreshold {
+		err = fmt.Errorf("probe took %v, above the %v latency threshold", duration.Round(time.Millisecond), m.config.LatencyThreshold)
+	}
+	m.record(backend, duration, err)
+}
+
+// record updates the status of a backend and notifies the observer
+func (m *Monitor) record(backend string, duration time.Duration, err error) {
+	if m.observer != nil {
+		m.observer.ObserveStorageProbe(backend, duration, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, exists := m.statuses[backend]
+	if !exists {
+		status = &Status{Backend: backend}
+		m.statuses[backend] = status
+	}
+
+	wasHealthy := !exists || status.Healthy
+	status.LatencyMS = float64(duration.Microseconds()) / 1000
+	status.LastChecked = time.Now().UTC()
+	if err != nil {
+		status.ConsecutiveFailures++
+		status.LastError = err.Error()
+	} else {
+		status.ConsecutiveFailures = 0
+		status.LastError = ""
+	}
+	status.Healthy = status.ConsecutiveFailures < m.config.FailureThreshold
+
+	switch {
+	case wasHealthy && !status.Healthy:
+		slog.Error("Storage backend is unhealthy", "backend", backend, "failed_probes", status.ConsecutiveFailures, "error", err)
+	case !wasHealthy && status.Healthy:
+		slog.Info("Storage backend recovered", "backend", backend)
+	}
+}
+
+// Statuses returns the latest status of every probed backend
+func (m *Monitor) Statuses() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]Status, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Backend < statuses[j].Backend
+	})
+	return statuses
+}
+
+// Ready reports whether every backend is healthy
+func (m *Monitor) Ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, status := range m.statuses {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
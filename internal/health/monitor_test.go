@@ -0,0 +1,95 @@
+package health
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProber returns queued errors and can be slowed down
+type fakeProber struct {
+	mu    sync.Mutex
+	errs  []error
+	delay time.Duration
+}
+
+func (p *fakeProber) Probe() error {
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+// recordingObserver records probe results
+type recordingObserver struct {
+	mu      sync.Mutex
+	results []error
+}
+
+func (o *recordingObserver) ObserveStorageProbe(backend string, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.results = append(o.results, err)
+}
+
+func TestMonitorFailureThreshold(t *testing.T) {
+	diskErr := errors.New("disk full")
+	prober := &fakeProber{errs: []error{diskErr, diskErr}}
+	observer := &recordingObserver{}
+	monitor := NewMonitor(Config{Timeout: time.Second, FailureThreshold: 2}, observer)
+	monitor.Add("csv", prober)
+
+	monitor.ProbeAll()
+	if !monitor.Ready() {
+		t.Error("A single failed probe must not fail readiness below the threshold")
+	}
+
+	monitor.ProbeAll()
+	if monitor.Ready() {
+		t.Error("Expected not ready after reaching the failure threshold")
+	}
+	statuses := monitor.Statuses()
+	if len(statuses) != 1 || statuses[0].ConsecutiveFailures != 2 || statuses[0].LastError != "disk full" {
+		t.Errorf("Unexpected status: %+v", statuses)
+	}
+
+	monitor.ProbeAll()
+	if !monitor.Ready() {
+		t.Error("Expected ready again after a successful probe")
+	}
+	if len(observer.results) != 3 || observer.results[2] != nil {
+		t.Errorf("Observer did not receive every probe result: %v", observer.results)
+	}
+}
+
+func TestMonitorTimeoutAndLatency(t *testing.T) {
+	monitor := NewMonitor(Config{Timeout: 20 * time.Millisecond}, nil)
+	monitor.Add("mysql", &fakeProber{delay: 200 * time.Millisecond})
+
+	monitor.ProbeAll()
+	statuses := monitor.Statuses()
+	if monitor.Ready() || !strings.Contains(statuses[0].LastError, "timed out") {
+		t.Errorf("Expected a timed out probe to fail readiness: %+v", statuses)
+	}
+
+	// A hung probe is not started twice
+	monitor.ProbeAll()
+	if statuses := monitor.Statuses(); !strings.Contains(statuses[0].LastError, "still running") {
+		t.Errorf("Expected the hung probe to be reported: %+v", statuses)
+	}
+
+	monitor = NewMonitor(Config{Timeout: time.Second, LatencyThreshold: 5 * time.Millisecond}, nil)
+	monitor.Add("csv", &fakeProber{delay: 20 * time.Millisecond})
+	monitor.ProbeAll()
+	if statuses := monitor.Statuses(); monitor.Ready() || !strings.Contains(statuses[0].LastError, "latency threshold") {
+		t.Errorf("Expected a slow probe to fail readiness: %+v", statuses)
+	}
+}
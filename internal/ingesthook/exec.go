@@ -0,0 +1,108 @@
+package ingesthook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Output limits of exec hooks
+const (
+	maxExecOutput = 10 << 20 // Rows returned on stdout
+	maxExecStderr = 4 << 10  // Stderr included in errors
+)
+
+// ExecHook runs an external program per upload. The program reads the
+// upload as JSON (see Upload) on stdin and writes {"rows": [...]} with the
+// transformed rows to stdout, or {"reject": "<reason>"} to refuse the
+// upload. A non-zero exit status fails the hook, with the end of stderr as
+// the error. The program is killed when the hook times out.
+//
+// Plugins compiled to WebAssembly run as exec hooks through a WASM runtime,
+// e.g. ["wasmtime", "run", "/opt/hooks/enrich.wasm"].
+type ExecHook struct {
+	name    string
+	command []string
+}
+
+// NewExecHook creates a hook running command, the program followed by its
+// arguments
+func NewExecHook(name string, command []string) *ExecHook {
+	return &ExecHook{name: name, command: command}
+}
+
+// Name implements Hook
+func (h *ExecHook) Name() string {
+	return h.name
+}
+
+// execResponse is the output of an exec hook
+type execResponse struct {
+	Rows   []map[string]interface{} `json:"rows"`
+	Reject string                   `json:"reject,omitempty"`
+}
+
+// Transform implements Hook
+func (h *ExecHook) Transform(ctx context.Context, upload *Upload) error {
+	input, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload: %w", err)
+	}
+
+	stdout := &cappedBuffer{max: maxExecOutput}
+	stderr := &cappedBuffer{max: maxExecStderr}
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second // Don't wait for children holding the pipes
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	if stdout.truncated {
+		return fmt.Errorf("output exceeds %d bytes", maxExecOutput)
+	}
+
+	var response execResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return fmt.Errorf("invalid output: %w", err)
+	}
+	if response.Reject != "" {
+		return Reject(response.Reject)
+	}
+	if response.Rows == nil {
+		return errors.New("output has no rows")
+	}
+	upload.Rows = response.Rows
+	return nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write implements io.Writer, never failing so the program is not stopped
+// by a broken pipe
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
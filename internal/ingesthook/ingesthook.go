@@ -0,0 +1,368 @@
+// Package ingesthook runs transformation hooks on accepted uploads before
+// they are stored, e.g. to enrich every row with a cost center looked up by
+// account ID. Hooks are compiled into custom builds and registered with
+// Register, or run as external programs (see ExecHook). Each hook runs with
+// a timeout, and its failure policy decides whether a failing hook rejects
+// the upload or is skipped.
+package ingesthook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/validation"
+	"github.com/google/uuid"
+)
+
+// DefaultTimeout is the timeout of hooks configured without one
+const DefaultTimeout = 5 * time.Second
+
+// Policy decides what happens to an upload when a hook fails or times out
+type Policy string
+
+const (
+	// PolicyReject fails the upload; nothing is stored
+	PolicyReject Policy = "reject"
+
+	// PolicySkip stores the rows as they were before the hook
+	PolicySkip Policy = "skip"
+)
+
+// ParsePolicy parses a failure policy; an empty string is PolicyReject
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case "", PolicyReject:
+		return PolicyReject, nil
+	case PolicySkip:
+		return PolicySkip, nil
+	}
+	return "", fmt.Errorf("invalid failure policy %q: must be %q or %q", s, PolicyReject, PolicySkip)
+}
+
+// Identity keys of a row, which hooks cannot change: they were checked
+// against the organization's taxonomy before the hooks ran
+var identityKeys = []string{"provider", "category", "resource_type"}
+
+// Upload is an accepted upload handed to the hooks. Rows are the flat rows
+// that will be stored, one per instance.
+type Upload struct {
+	OrgID        uuid.UUID                `json:"org_id"`
+	UploadID     string                   `json:"upload_id"`
+	Provider     string                   `json:"provider"`
+	Category     string                   `json:"category"`
+	ResourceType string                   `json:"resource_type"`
+	Name         string                   `json:"name,omitempty"`
+	Rows         []map[string]interface{} `json:"rows"`
+}
+
+// Hook transforms an accepted upload before it is stored. Transform may
+// add, change and remove attributes of upload.Rows, or replace the rows,
+// but must keep their number and order. Keys starting with an underscore
+// (server metadata such as lineage) and the provider, category and
+// resource_type keys are restored after every hook. Transform should
+// return once ctx is done; its result is discarded then.
+//
+// A hook refuses an upload by returning an error created with Reject,
+// whatever its failure policy.
+type Hook interface {
+	Name() string
+	Transform(ctx context.Context, upload *Upload) error
+}
+
+// RejectError is returned by a hook that refuses an upload
+type RejectError struct {
+	Hook   string
+	Reason string
+}
+
+// Error implements the error interface
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("upload rejected by hook %s: %s", e.Hook, e.Reason)
+}
+
+// Reject creates the error by which a hook refuses an upload
+func Reject(reason string) error {
+	return &RejectError{Reason: reason}
+}
+
+// FailedError is returned by Run when a hook with PolicyReject failed
+type FailedError struct {
+	Hook string
+	Err  error
+}
+
+// Error implements the error interface
+func (e *FailedError) Error() string {
+	return fmt.Sprintf("upload hook %s failed: %v", e.Hook, e.Err)
+}
+
+// Unwrap returns the hook's error
+func (e *FailedError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Hook)
+)
+
+// Register makes a compiled-in hook available to hooks files under its
+// name. It is meant to be called from init functions of custom builds and
+// panics if the name is taken.
+func Register(hook Hook) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[hook.Name()]; ok {
+		panic(fmt.Sprintf("ingesthook: hook %q registered twice", hook.Name()))
+	}
+	registry[hook.Name()] = hook
+}
+
+// registered returns the compiled-in hook with the name
+func registered(name string) (Hook, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	hook, ok := registry[name]
+	return hook, ok
+}
+
+// configuredHook is a hook with its timeout and failure policy
+type configuredHook struct {
+	hook      Hook
+	timeout   time.Duration
+	onFailure Policy
+}
+
+// Runner runs the configured hooks on uploads, in order
+type Runner struct {
+	hooks []configuredHook
+
+	runs      atomic.Int64
+	failures  atomic.Int64
+	timeouts  atomic.Int64
+	rejected  atomic.Int64
+	skipped   atomic.Int64
+	durations atomic.Int64 // Total time spent in hooks, in milliseconds
+}
+
+// NewRunner creates a runner without hooks
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+// Add appends a hook. A non-positive timeout uses DefaultTimeout.
+func (r *Runner) Add(hook Hook, timeout time.Duration, onFailure Policy) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	r.hooks = append(r.hooks, configuredHook{hook: hook, timeout: timeout, onFailure: onFailure})
+}
+
+// Names returns the names of the hooks in the order they run
+func (r *Runner) Names() []string {
+	names := make([]string, len(r.hooks))
+	for i, h := range r.hooks {
+		names[i] = h.hook.Name()
+	}
+	return names
+}
+
+// Run passes the upload through every hook and returns the rows to store.
+// It fails with a *RejectError if a hook refused the upload, and with a
+// *FailedError if a hook with PolicyReject failed or timed out.
+func (r *Runner) Run(ctx context.Context, upload Upload) ([]map[string]interface{}, error) {
+	rows := upload.Rows
+	for _, h := range r.hooks {
+		out, err := r.runHook(ctx, h, upload, rows)
+		if err == nil {
+			rows = out
+			continue
+		}
+
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			r.rejected.Add(1)
+			rejectErr.Hook = h.hook.Name()
+			slog.Info("Upload rejected by hook", "hook", h.hook.Name(), "org_id", upload.OrgID,
+				"upload_id", upload.UploadID, "reason", rejectErr.Reason)
+			return nil, rejectErr
+		}
+
+		r.failures.Add(1)
+		if errors.Is(err, context.DeadlineExceeded) {
+			r.timeouts.Add(1)
+		}
+		slog.Warn("Upload hook failed", "hook", h.hook.Name(), "org_id", upload.OrgID,
+			"upload_id", upload.UploadID, "on_failure", h.onFailure, "error", err)
+		if h.onFailure != PolicySkip {
+			return nil, &FailedError{Hook: h.hook.Name(), Err: err}
+		}
+		r.skipped.Add(1)
+	}
+	return rows, nil
+}
+
+// runHook runs one hook on a copy of the rows, so a failed or timed out hook
+// leaves them untouched, and checks its output
+func (r *Runner) runHook(ctx context.Context, h configuredHook, upload Upload, rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	r.runs.Add(1)
+	start := time.Now()
+	defer func() { r.durations.Add(time.Since(start).Milliseconds()) }()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	upload.Rows = copyRows(rows)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("hook panicked: %v", p)
+			}
+		}()
+		done <- h.hook.Transform(ctx, &upload)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, fmt.Errorf("hook did not finish: %w", ctx.Err())
+	}
+
+	if err := checkRows(rows, upload.Rows); err != nil {
+		return nil, err
+	}
+	return upload.Rows, nil
+}
+
+// copyRows copies the rows so the changes of a hook that fails are not
+// stored. Nested values are shared.
+func copyRows(rows []map[string]interface{}) []map[string]interface{} {
+	copied := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		copied[i] = make(map[string]interface{}, len(row))
+		for k, v := range row {
+			copied[i][k] = v
+		}
+	}
+	return copied
+}
+
+// checkRows validates the rows returned by a hook like uploaded attributes
+// and restores the keys hooks cannot change
+func checkRows(before, after []map[string]interface{}) error {
+	if len(after) != len(before) {
+		return fmt.Errorf("hook returned %d rows for %d", len(after), len(before))
+	}
+	for i, row := range after {
+		if row == nil {
+			return fmt.Errorf("hook returned no data for row %d", i)
+		}
+		for k, v := range row {
+			if strings.HasPrefix(k, "_") {
+				delete(row, k)
+				continue
+			}
+			if err := validation.ValidateAttributeKey(k); err != nil {
+				return fmt.Errorf("invalid attribute key %q in row %d: %w", k, i, err)
+			}
+			if err := validation.ValidateAttributeValue(v); err != nil {
+				return fmt.Errorf("invalid attribute value for %q in row %d: %w", k, i, err)
+			}
+		}
+		for k, v := range before[i] {
+			if strings.HasPrefix(k, "_") {
+				row[k] = v
+			}
+		}
+		for _, k := range identityKeys {
+			if v, ok := before[i][k]; ok {
+				row[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of hook runs, failures (of which timeouts),
+// uploads rejected by hooks, failures skipped by PolicySkip, and the total
+// time spent in hooks in milliseconds
+func (r *Runner) Stats() map[string]int64 {
+	return map[string]int64{
+		"hooks":       int64(len(r.hooks)),
+		"runs":        r.runs.Load(),
+		"failures":    r.failures.Load(),
+		"timeouts":    r.timeouts.Load(),
+		"rejected":    r.rejected.Load(),
+		"skipped":     r.skipped.Load(),
+		"duration_ms": r.durations.Load(),
+	}
+}
+
+// Spec configures one hook in a hooks file. A spec with a command runs it
+// as an ExecHook; otherwise it names a hook registered with Register.
+type Spec struct {
+	Name      string   `json:"name"`
+	Command   []string `json:"command,omitempty"`
+	Timeout   string   `json:"timeout,omitempty"`    // Go duration, e.g. "2s"
+	OnFailure string   `json:"on_failure,omitempty"` // "reject" (default) or "skip"
+}
+
+// LoadFile creates a runner with the hooks listed in a JSON hooks file, a
+// list of specs. Hooks without a timeout use defaultTimeout.
+func LoadFile(path string, defaultTimeout time.Duration) (*Runner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks file: %w", err)
+	}
+
+	var specs []Spec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks file %s: %w", path, err)
+	}
+
+	runner := NewRunner()
+	seen := make(map[string]bool)
+	for i, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("hook %d has no name", i)
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("hook %q is listed twice", spec.Name)
+		}
+		seen[spec.Name] = true
+
+		timeout := defaultTimeout
+		if spec.Timeout != "" {
+			if timeout, err = time.ParseDuration(spec.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("hook %q has an invalid timeout %q", spec.Name, spec.Timeout)
+			}
+		}
+		onFailure, err := ParsePolicy(spec.OnFailure)
+		if err != nil {
+			return nil, fmt.Errorf("hook %q: %w", spec.Name, err)
+		}
+
+		var hook Hook
+		if len(spec.Command) > 0 {
+			hook = NewExecHook(spec.Name, spec.Command)
+		} else if hook, _ = registered(spec.Name); hook == nil {
+			return nil, fmt.Errorf("hook %q has no command and is not compiled in", spec.Name)
+		}
+		runner.Add(hook, timeout, onFailure)
+	}
+	return runner, nil
+}
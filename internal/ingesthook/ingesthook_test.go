@@ -0,0 +1,215 @@
+package ingesthook
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// funcHook is a hook backed by a function
+type funcHook struct {
+	name      string
+	transform func(ctx context.Context, upload *Upload) error
+}
+
+func (h funcHook) Name() string { return h.name }
+
+func (h funcHook) Transform(ctx context.Context, upload *Upload) error {
+	return h.transform(ctx, upload)
+}
+
+// testUpload returns an upload with one row
+func testUpload() Upload {
+	return Upload{
+		OrgID:        uuid.New(),
+		UploadID:     "upload-1",
+		Provider:     "aws",
+		Category:     "compute",
+		ResourceType: "instance",
+		Rows: []map[string]interface{}{
+			{"provider": "aws", "category": "compute", "resource_type": "instance", "account": "123", "_upload_id": "upload-1"},
+		},
+	}
+}
+
+// enrich adds a cost center and tries to change keys hooks cannot change
+var enrich = funcHook{name: "enrich", transform: func(ctx context.Context, upload *Upload) error {
+	for _, row := range upload.Rows {
+		row["cost_center"] = "cc-" + row["account"].(string)
+		row["provider"] = "gcp"
+		row["_upload_id"] = "spoofed"
+		row["_extra"] = true
+	}
+	return nil
+}}
+
+// failing modifies the rows, then fails
+var failing = funcHook{name: "failing", transform: func(ctx context.Context, upload *Upload) error {
+	upload.Rows[0]["account"] = "changed"
+	return errors.New("lookup unavailable")
+}}
+
+// slow ignores its context
+var slow = funcHook{name: "slow", transform: func(ctx context.Context, upload *Upload) error {
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}}
+
+func TestRunnerTransforms(t *testing.T) {
+	runner := NewRunner()
+	runner.Add(enrich, 0, PolicyReject)
+
+	upload := testUpload()
+	rows, err := runner.Run(context.Background(), upload)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	row := rows[0]
+	if row["cost_center"] != "cc-123" || row["provider"] != "aws" || row["_upload_id"] != "upload-1" {
+		t.Errorf("Expected an enriched row with protected keys restored, got %v", row)
+	}
+	if _, ok := row["_extra"]; ok {
+		t.Error("Expected reserved keys added by a hook to be dropped")
+	}
+	if _, ok := upload.Rows[0]["cost_center"]; ok {
+		t.Error("Expected the input rows to be left untouched")
+	}
+}
+
+func TestRunnerFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		hook     Hook
+		timeouts int64
+	}{
+		{"error", failing, 0},
+		{"timeout", slow, 1},
+		{"panic", funcHook{name: "panic", transform: func(ctx context.Context, upload *Upload) error { panic("boom") }}, 0},
+		{"bad rows", funcHook{name: "bad", transform: func(ctx context.Context, upload *Upload) error {
+			upload.Rows = append(upload.Rows, map[string]interface{}{})
+			return nil
+		}}, 0},
+		{"bad key", funcHook{name: "bad", transform: func(ctx context.Context, upload *Upload) error {
+			upload.Rows[0]["bad key!"] = 1
+			return nil
+		}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, onFailure := range []Policy{PolicyReject, PolicySkip} {
+				runner := NewRunner()
+				runner.Add(tt.hook, 50*time.Millisecond, onFailure)
+				runner.Add(enrich, 0, PolicyReject)
+
+				rows, err := runner.Run(context.Background(), testUpload())
+				stats := runner.Stats()
+				if stats["failures"] != 1 || stats["timeouts"] != tt.timeouts {
+					t.Errorf("%s: expected 1 failure and %d timeouts, got %v", onFailure, tt.timeouts, stats)
+				}
+				if onFailure == PolicyReject {
+					var failed *FailedError
+					if !errors.As(err, &failed) || failed.Hook != tt.hook.Name() {
+						t.Errorf("Expected a FailedError of %s, got %v", tt.hook.Name(), err)
+					}
+					continue
+				}
+				if err != nil || rows[0]["account"] != "123" || rows[0]["cost_center"] != "cc-123" {
+					t.Errorf("Expected the failed hook to be skipped, got %v, %v", rows, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunnerReject(t *testing.T) {
+	runner := NewRunner()
+	runner.Add(funcHook{name: "policy", transform: func(ctx context.Context, upload *Upload) error {
+		return Reject("missing owner tag")
+	}}, 0, PolicySkip)
+
+	_, err := runner.Run(context.Background(), testUpload())
+	var rejected *RejectError
+	if !errors.As(err, &rejected) || rejected.Hook != "policy" || rejected.Reason != "missing owner tag" {
+		t.Fatalf("Expected the upload to be rejected whatever the policy, got %v", err)
+	}
+	if stats := runner.Stats(); stats["rejected"] != 1 || stats["failures"] != 0 {
+		t.Errorf("Expected 1 rejection and no failures, got %v", stats)
+	}
+}
+
+func TestExecHook(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		check  func(rows []map[string]interface{}, err error) bool
+	}{
+		{"rows", `cat >/dev/null; echo '{"rows":[{"provider":"aws","cost_center":"cc-9"}]}'`,
+			func(rows []map[string]interface{}, err error) bool {
+				return err == nil && rows[0]["cost_center"] == "cc-9"
+			}},
+		{"reject", `echo '{"reject":"unknown account"}'`,
+			func(rows []map[string]interface{}, err error) bool {
+				var rejected *RejectError
+				return errors.As(err, &rejected) && rejected.Reason == "unknown account"
+			}},
+		{"exit status", `echo 'lookup table missing' >&2; exit 3`,
+			func(rows []map[string]interface{}, err error) bool {
+				return err != nil && strings.Contains(err.Error(), "lookup table missing")
+			}},
+		{"no rows", `echo '{}'`,
+			func(rows []map[string]interface{}, err error) bool { return err != nil }},
+		{"timeout", `sleep 5`,
+			func(rows []map[string]interface{}, err error) bool { return errors.Is(err, context.DeadlineExceeded) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewRunner()
+			runner.Add(NewExecHook("exec", []string{"sh", "-c", tt.script}), time.Second, PolicyReject)
+			rows, err := runner.Run(context.Background(), testUpload())
+			if !tt.check(rows, err) {
+				t.Errorf("Unexpected result %v, %v", rows, err)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	Register(funcHook{name: "test-enrich", transform: enrich.transform})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hooks.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "test-enrich"},
+		{"name": "external", "command": ["sh", "-c", "exit 1"], "timeout": "2s", "on_failure": "skip"}
+	]`), 0600)
+
+	runner, err := LoadFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if names := runner.Names(); len(names) != 2 || names[0] != "test-enrich" || names[1] != "external" {
+		t.Errorf("Expected hooks in file order, got %v", names)
+	}
+	if runner.hooks[0].timeout != time.Second || runner.hooks[1].timeout != 2*time.Second || runner.hooks[1].onFailure != PolicySkip {
+		t.Errorf("Unexpected hook settings %+v", runner.hooks)
+	}
+
+	for _, bad := range []string{
+		`[{"name": "missing"}]`,
+		`[{"command": ["true"]}]`,
+		`[{"name": "a", "command": ["true"]}, {"name": "a", "command": ["true"]}]`,
+		`[{"name": "a", "command": ["true"], "timeout": "soon"}]`,
+		`[{"name": "a", "command": ["true"], "on_failure": "retry"}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if _, err := LoadFile(path, time.Second); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
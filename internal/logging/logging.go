@@ -0,0 +1,177 @@
+// Package logging configures the structured application log. Records are
+// written with log/slog as text or JSON, and records logged with a request
+// context carry its request_id, org_id and route, so a request can be
+// followed across middleware, handlers and storage.
+//
+// Lines still written with the standard log package go to the same
+// handler; an ERROR: or WARNING: prefix sets their level.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/logrotate"
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Formats of the application log
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config configures the application log
+type Config struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level string
+
+	// Format is text (key=value pairs) or json (one object per line)
+	Format string
+
+	// File is appended to instead of stderr when set
+	File string
+
+	// Rotation controls when File is rotated
+	Rotation logrotate.Config
+}
+
+// ParseLevel parses a level name
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %s (must be debug, info, warn or error)", value)
+}
+
+// New creates a logger writing to w
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s (must be text or json)", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Configure makes the configured logger the default of log/slog and of
+// the standard log package. The returned closer releases the log file.
+func Configure(cfg Config) (io.Closer, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if cfg.File != "" {
+		file, err := logrotate.Open(cfg.File, 0640, cfg.Rotation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w, closer = file, file
+	}
+
+	logger, err := New(w, level, cfg.Format)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{logger})
+	return closer, nil
+}
+
+// contextHandler adds the request_id, org_id and route of the record's
+// context, unless the record sets them itself
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	set := make(map[string]bool)
+	record.Attrs(func(attr slog.Attr) bool {
+		set[attr.Key] = true
+		return true
+	})
+
+	var attrs []slog.Attr
+	if id := middleware.GetReqID(ctx); id != "" && !set["request_id"] {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if orgID, ok := reqctx.OrgID(ctx); ok && !set["org_id"] {
+		attrs = append(attrs, slog.String("org_id", orgID.String()))
+	}
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" && !set["route"] {
+		attrs = append(attrs, slog.String("route", rctx.RoutePattern()))
+	}
+	if len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// stdWriter logs lines of the standard log package, taking their level
+// from an ERROR: or WARNING: prefix
+type stdWriter struct {
+	logger *slog.Logger
+}
+
+// Write implements io.Writer
+func (w stdWriter) Write(p []byte) (int, error) {
+	message := string(bytes.TrimRight(p, "\n"))
+
+	level := slog.LevelInfo
+	if rest, ok := strings.CutPrefix(message, "ERROR: "); ok {
+		level, message = slog.LevelError, rest
+	} else if rest, ok := strings.CutPrefix(message, "WARNING: "); ok {
+		level, message = slog.LevelWarn, rest
+	}
+	w.logger.Log(context.Background(), level, message)
+	return len(p), nil
+}
+
+// nopCloser is the closer of the stderr log
+type nopCloser struct{}
+
+// Close implements io.Closer
+func (nopCloser) Close() error { return nil }
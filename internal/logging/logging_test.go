@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, FormatJSON)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "host/abc-000001")
+	ctx = reqctx.WithOrgID(ctx, orgID)
+	logger.InfoContext(ctx, "Lock acquired", "state", "prod")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to decode %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Lock acquired" || record["level"] != "INFO" || record["state"] != "prod" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if record["request_id"] != "host/abc-000001" || record["org_id"] != orgID.String() {
+		t.Errorf("Expected the context fields, got %v", record)
+	}
+
+	// Fields of the record win over the context
+	buf.Reset()
+	other := uuid.New()
+	logger.InfoContext(ctx, "Failed to add key", "org_id", other)
+	if strings.Count(buf.String(), `"org_id"`) != 1 || !strings.Contains(buf.String(), other.String()) {
+		t.Errorf("Expected only the record's org_id, got %s", buf.String())
+	}
+}
+
+func TestLevelsAndFormats(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelWarn, FormatText)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "rows", 3)
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "level=WARN msg=shown rows=3") {
+		t.Errorf("Unexpected text log %q", buf.String())
+	}
+
+	if _, err := New(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("Expected error for an unknown format")
+	}
+	for value, want := range map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		if level, err := ParseLevel(value); err != nil || level != want {
+			t.Errorf("ParseLevel(%q) = %v, %v", value, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error for an unknown level")
+	}
+}
+
+func TestStdWriterLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, slog.LevelInfo, FormatText)
+	w := stdWriter{logger}
+
+	w.Write([]byte("ERROR: Failed to open storage: boom\n"))
+	w.Write([]byte("WARNING: TLS disabled\n"))
+	w.Write([]byte("Server will listen on :7777\n"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`level=ERROR msg="Failed to open storage: boom"`,
+		`level=WARN msg="TLS disabled"`,
+		`level=INFO msg="Server will listen on :7777"`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %q", len(want), buf.String())
+	}
+	for i := range want {
+		if !strings.Contains(lines[i], want[i]) {
+			t.Errorf("Line %d: expected %q in %q", i, want[i], lines[i])
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package logrotate writes log files that rotate by size and time, with
+// compression and retention of rotated files, for hosts without logrotate.
+package logrotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files; it sorts chronologically and has
+// no characters that are invalid in file names
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Config controls when a log file is rotated and how long rotated files
+// are kept. Zero values disable the corresponding limit.
+type Config struct {
+	MaxSize    int64         // Rotate before the file grows beyond this many bytes
+	Interval   time.Duration // Rotate at each multiple of the interval (UTC), e.g. 24h rotates at midnight
+	MaxBackups int           // Number of rotated files kept
+	MaxAge     time.Duration // Rotated files older than this are removed
+	Compress   bool          // Gzip rotated files
+}
+
+// Writer is an append-only log file that rotates itself. It is safe for
+// concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	path   string
+	mode   os.FileMode
+	cfg    Config
+	file   *os.File
+	size   int64
+	period time.Time // Start of the interval the file belongs to
+
+	// cleanup compresses and prunes rotated files in the background; it is
+	// serialized so two cleanups never touch the same file
+	cleanupMu sync.Mutex
+	cleanupWG sync.WaitGroup
+
+	now func() time.Time
+}
+
+// Open opens or creates the log file at path with the given mode. An
+// existing file from an earlier interval is rotated right away.
+func Open(path string, mode os.FileMode, cfg Config) (*Writer, error) {
+	w := &Writer{path: path, mode: mode, cfg: cfg, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if info, err := w.file.Stat(); err == nil && info.Size() > 0 && info.ModTime().Before(w.period) {
+		if err := w.rotate(); err != nil {
+			w.file.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// open opens the log file for appending
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0750); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, w.mode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	w.period = w.periodStart()
+	return nil
+}
+
+// periodStart returns the start of the current rotation interval
+func (w *Writer) periodStart() time.Time {
+	if w.cfg.Interval <= 0 {
+		return time.Time{}
+	}
+	return w.now().UTC().Truncate(w.cfg.Interval)
+}
+
+// Write appends p to the log file, rotating it first when p would exceed
+// the size limit or the interval has passed. A single write larger than
+// the size limit goes into a file of its own.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	due := w.cfg.Interval > 0 && w.periodStart().After(w.period)
+	full := w.cfg.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.cfg.MaxSize
+	if due || full {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it with a timestamp suffix and
+// starts a new one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// rotate does the work of Rotate; the caller must hold mu
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	w.file = nil
+
+	backup := w.path + "." + w.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(w.path, backup); err != nil {
+		// Keep logging to the current file rather than losing events
+		if openErr := w.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.cleanupWG.Add(1)
+	go func() {
+		defer w.cleanupWG.Done()
+		w.cleanup()
+	}()
+	return nil
+}
+
+// cleanup compresses rotated files and removes those beyond the retention
+// limits. Failures are logged since no caller is waiting for them.
+func (w *Writer) cleanup() {
+	w.cleanupMu.Lock()
+	defer w.cleanupMu.Unlock()
+
+	backups, err := w.backups()
+	if err != nil {
+		slog.Error("Failed to list rotated logs", "file", w.path, "error", err)
+		return
+	}
+
+	cutoff := time.Time{}
+	if w.cfg.MaxAge > 0 {
+		cutoff = w.now().Add(-w.cfg.MaxAge)
+	}
+	for i, b := range backups {
+		// Backups are sorted newest first
+		expired := (w.cfg.MaxBackups > 0 && i >= w.cfg.MaxBackups) || (!cutoff.IsZero() && b.rotated.Before(cutoff))
+		if expired {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				slog.Error("Failed to remove rotated log", "file", b.path, "error", err)
+			}
+			continue
+		}
+		if w.cfg.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compress(b.path, w.mode); err != nil {
+				slog.Error("Failed to compress rotated log", "file", b.path, "error", err)
+			}
+		}
+	}
+}
+
+// backup is a rotated log file
+type backup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups returns the rotated files of the log, newest first
+func (w *Writer) backups() ([]backup, error) {
+	entries, err := os.ReadDir(filepath.Dir(w.path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(w.path) + "."
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		rotated, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			// Not a rotated file, e.g. a partial compression
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(filepath.Dir(w.path), name), rotated: rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotated.After(backups[j].rotated)
+	})
+	return backups, nil
+}
+
+// compress gzips a rotated file and removes the original. The archive is
+// written under a temporary name first so a crash never leaves a
+// truncated .gz behind.
+func compress(path string, mode os.FileMode) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the log file and waits for background compression
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.cleanupWG.Wait()
+	return err
+}
@@ -0,0 +1,120 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "security.log")
+
+	w, err := Open(path, 0640, Config{MaxSize: 10, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "fourth\n" {
+		t.Errorf("Expected the current file to hold the last line, got %q", current)
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatalf("Listing backups failed: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %v", backups)
+	}
+	if !strings.HasSuffix(backups[0].path, ".gz") {
+		t.Fatalf("Expected compressed backups, got %s", backups[0].path)
+	}
+	file, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Backup is not gzip: %v", err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "third\n" {
+		t.Errorf("Expected the newest backup to hold the third line, got %q", data)
+	}
+}
+
+func TestWriterRotatesByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(path, yesterday, yesterday)
+
+	// A file left from an earlier interval is rotated on open
+	w, err := Open(path, 0640, Config{Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer w.Close()
+	if backups, _ := w.backups(); len(backups) != 1 {
+		t.Fatalf("Expected the old file to be rotated on open, got %v", backups)
+	}
+
+	w.Write([]byte("now\n"))
+	later := time.Now().Add(time.Hour)
+	w.now = func() time.Time { return later }
+	w.Write([]byte("later\n"))
+
+	if backups, _ := w.backups(); len(backups) != 2 {
+		t.Errorf("Expected rotation at the next interval, got %v", backups)
+	}
+	if current, _ := os.ReadFile(path); string(current) != "later\n" {
+		t.Errorf("Expected the current file to start with the new interval, got %q", current)
+	}
+}
+
+func TestCleanupRemovesExpiredBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "security.log")
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	old := path + "." + now.Add(-72*time.Hour).Format(backupTimeFormat) + ".gz"
+	recent := path + "." + now.Add(-time.Hour).Format(backupTimeFormat) + ".gz"
+	unrelated := path + ".bak"
+	for _, p := range []string{old, recent, unrelated} {
+		os.WriteFile(p, nil, 0640)
+	}
+
+	w := &Writer{path: path, mode: 0640, cfg: Config{MaxAge: 48 * time.Hour}, now: func() time.Time { return now }}
+	w.cleanup()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expected the expired backup to be removed")
+	}
+	for _, p := range []string{recent, unrelated} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("Expected %s to be kept: %v", p, err)
+		}
+	}
+}
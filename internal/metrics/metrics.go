@@ -0,0 +1,474 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// pendingLockTTL bounds how long a contended lock is tracked while waiting
+// for the client to acquire it
+const pendingLockTTL = time.Hour
+
+// heldLockTTL bounds how long an acquired lock is tracked waiting for release
+const heldLockTTL = 7 * 24 * time.Hour
+
+// maxAuthFailureOrgs bounds the org_id label values of auth failures, so
+// requests with random org IDs cannot grow the series without limit. Later
+// orgs are counted as "other".
+const maxAuthFailureOrgs = 1000
+
+// httpMethods are the method label values; other methods are "other"
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true, "LOCK": true, "UNLOCK": true,
+}
+
+// Metrics holds the Prometheus collectors for state backend SLOs. Each
+// instance uses its own registry so several routers can coexist in tests.
+type Metrics struct {
+	registry *prometheus.Registry
+	backend  string
+
+	stateDuration  *prometheus.HistogramVec
+	stateRequests  *prometheus.CounterVec
+	lockContention *prometheus.CounterVec
+	lockWait       *prometheus.HistogramVec
+	lockHeld       *prometheus.HistogramVec
+
+	storageProbeDuration *prometheus.HistogramVec
+	storageProbes        *prometheus.CounterVec
+	storageUp            *prometheus.GaugeVec
+	storageWriteErrors   *prometheus.CounterVec
+
+	httpDuration   *prometheus.HistogramVec
+	httpRequests   *prometheus.CounterVec
+	authFailures   *prometheus.CounterVec
+	keyCompareTime prometheus.Histogram
+
+	// Orgs with an auth failure series (see maxAuthFailureOrgs)
+	authFailureOrgs map[string]bool
+
+	// First contended lock attempt per org/state, used to measure lock wait,
+	// and acquisition time of held locks, used to measure lock hold time
+	mu           sync.Mutex
+	pendingLocks map[string]time.Time
+	heldLocks    map[string]time.Time
+}
+
+// New creates the metrics collectors labeled with the given storage backend
+func New(backend string) *Metrics {
+	m := &Metrics{
+		registry:        prometheus.NewRegistry(),
+		backend:         backend,
+		pendingLocks:    make(map[string]time.Time),
+		heldLocks:       make(map[string]time.Time),
+		authFailureOrgs: make(map[string]bool),
+		stateDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tfbackend_state_request_duration_seconds",
+			Help:    "Latency of Terraform state backend requests.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"operation", "backend"}),
+		stateRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_state_requests_total",
+			Help: "Terraform state backend requests by operation and status code.",
+		}, []string{"operation", "backend", "code"}),
+		lockContention: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_state_lock_contention_total",
+			Help: "Lock attempts rejected because the state was already locked.",
+		}, []string{"backend"}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tfbackend_state_lock_wait_seconds",
+			Help:    "Time from the first contended lock attempt until the lock was acquired.",
+			Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"backend"}),
+		lockHeld: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tfbackend_state_lock_held_seconds",
+			Help:    "Time a state lock was held until it was released or force-unlocked.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 2400, 3600},
+		}, []string{"backend"}),
+		storageProbeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tfbackend_storage_probe_duration_seconds",
+			Help:    "Latency of storage health probes (sentinel write, read and delete).",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"backend"}),
+		storageProbes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_storage_probes_total",
+			Help: "Storage health probes by result (success or failure).",
+		}, []string{"backend", "result"}),
+		storageUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "tfbackend_storage_probe_up",
+			Help: "Whether the last storage health probe succeeded (1) or failed (0).",
+		}, []string{"backend"}),
+		storageWriteErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_storage_write_errors_total",
+			Help: "State and data writes that failed with a storage error, by operation.",
+		}, []string{"operation", "backend"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tfbackend_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route and method.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"route", "method"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tfbackend_auth_failures_total",
+			Help: "Requests rejected with 401 Unauthorized, by the X-Org-ID they claimed.",
+		}, []string{"org_id"}),
+		keyCompareTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tfbackend_auth_bcrypt_duration_seconds",
+			Help:    "Time spent comparing an API key with a bcrypt hash.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.stateDuration,
+		m.stateRequests,
+		m.lockContention,
+		m.lockWait,
+		m.lockHeld,
+		m.storageProbeDuration,
+		m.storageProbes,
+		m.storageUp,
+		m.storageWriteErrors,
+		m.httpDuration,
+		m.httpRequests,
+		m.authFailures,
+		m.keyCompareTime,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// RateLimiterStats is implemented by rate limiters that report their bucket
+// count, evictions and rejected requests
+type RateLimiterStats interface {
+	Stats() (buckets int, evictions, rejections int64)
+}
+
+// ObserveRateLimiter exports the bucket count, evictions and rejections of
+// the rate limiter, to spot org-ID churn before it forces evictions
+func (m *Metrics) ObserveRateLimiter(limiter RateLimiterStats) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tfbackend_ratelimit_buckets",
+			Help: "Organizations with a rate limit bucket.",
+		}, func() float64 {
+			buckets, _, _ := limiter.Stats()
+			return float64(buckets)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tfbackend_ratelimit_evictions_total",
+			Help: "Rate limit buckets evicted because the bucket limit was reached.",
+		}, func() float64 {
+			_, evictions, _ := limiter.Stats()
+			return float64(evictions)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tfbackend_ratelimit_rejections_total",
+			Help: "Requests rejected with 429 because the org exceeded its rate limit.",
+		}, func() float64 {
+			_, _, rejections := limiter.Stats()
+			return float64(rejections)
+		}),
+	)
+}
+
+// AuthLockoutStats is implemented by trackers of failed authentication that
+// report their tracked and locked out clients, lockouts and rejections
+type AuthLockoutStats interface {
+	Stats() (tracked, locked int, lockouts, rejections int64)
+}
+
+// ObserveAuthLockout exports the lockouts of clients after repeated failed
+// authentication, to spot credential guessing
+func (m *Metrics) ObserveAuthLockout(tracker AuthLockoutStats) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tfbackend_auth_lockouts_active",
+			Help: "Organization and client IP pairs locked out after repeated failed authentication.",
+		}, func() float64 {
+			_, locked, _, _ := tracker.Stats()
+			return float64(locked)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tfbackend_auth_lockouts_total",
+			Help: "Lockouts triggered by repeated failed authentication.",
+		}, func() float64 {
+			_, _, lockouts, _ := tracker.Stats()
+			return float64(lockouts)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tfbackend_auth_lockout_rejections_total",
+			Help: "Requests rejected with 429 because their client was locked out.",
+		}, func() float64 {
+			_, _, _, rejections := tracker.Stats()
+			return float64(rejections)
+		}),
+	)
+}
+
+// DBPoolStats is implemented by storage backends that report the stats of
+// their database connection pool
+type DBPoolStats interface {
+	PoolStats() sql.DBStats
+}
+
+// ObserveDBPool exports the connection pool stats of a database, to spot
+// connection leaks and undersized pools before requests fail
+func (m *Metrics) ObserveDBPool(pool DBPoolStats) {
+	gauge := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, func() float64 {
+			return value(pool.PoolStats())
+		})
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return value(pool.PoolStats())
+		})
+	}
+
+	m.registry.MustRegister(
+		gauge("tfbackend_db_max_open_connections", "Maximum open database connections.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }),
+		gauge("tfbackend_db_open_connections", "Open database connections, in use or idle.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }),
+		gauge("tfbackend_db_in_use_connections", "Database connections in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }),
+		gauge("tfbackend_db_idle_connections", "Idle database connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }),
+		counter("tfbackend_db_wait_count_total", "Requests that waited for a database connection.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }),
+		counter("tfbackend_db_wait_duration_seconds_total", "Time spent waiting for database connections.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }),
+		counter("tfbackend_db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }),
+		counter("tfbackend_db_max_idle_time_closed_total", "Connections closed because they were idle too long.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }),
+		counter("tfbackend_db_max_lifetime_closed_total", "Connections closed because they reached their maximum lifetime.",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }),
+	)
+}
+
+// Registry returns the registry holding all collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ListenerHandler serves GET /metrics alone, for a listener separate from
+// the API
+func (m *Metrics) ListenerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+	return mux
+}
+
+// ObserveKeyCompare records the time of a bcrypt comparison of an API key
+func (m *Metrics) ObserveKeyCompare(duration time.Duration) {
+	m.keyCompareTime.Observe(duration.Seconds())
+}
+
+// ObserveStorageWriteError counts a write that failed with a storage error
+func (m *Metrics) ObserveStorageWriteError(operation string) {
+	m.storageWriteErrors.WithLabelValues(operation, m.backend).Inc()
+}
+
+// HTTPMiddleware records latency and status codes of all requests by route
+// pattern, and 401 responses by the org they claimed. It must wrap the
+// routes so the route pattern is resolved when the handler returns.
+func (m *Metrics) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		method := r.Method
+		if !httpMethods[method] {
+			method = "other"
+		}
+
+		m.httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		m.httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+
+		if status == http.StatusUnauthorized {
+			m.authFailures.WithLabelValues(m.authFailureOrg(r)).Inc()
+		}
+	})
+}
+
+// authFailureOrg returns the org_id label of an auth failure: the claimed
+// org ID, "unknown" without a valid one, or "other" past maxAuthFailureOrgs
+func (m *Metrics) authFailureOrg(r *http.Request) string {
+	orgID, err := uuid.Parse(r.Header.Get("X-Org-ID"))
+	if err != nil {
+		return "unknown"
+	}
+	label := orgID.String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.authFailureOrgs[label] {
+		if len(m.authFailureOrgs) >= maxAuthFailureOrgs {
+			return "other"
+		}
+		m.authFailureOrgs[label] = true
+	}
+	return label
+}
+
+// ObserveStorageProbe records the latency and result of a storage health probe
+func (m *Metrics) ObserveStorageProbe(backend string, duration time.Duration, err error) {
+	m.storageProbeDuration.WithLabelValues(backend).Observe(duration.Seconds())
+	if err != nil {
+		m.storageProbes.WithLabelValues(backend, "failure").Inc()
+		m.storageUp.WithLabelValues(backend).Set(0)
+		return
+	}
+	m.storageProbes.WithLabelValues(backend, "success").Inc()
+	m.storageUp.WithLabelValues(backend).Set(1)
+}
+
+// StateMiddleware records latency, status codes and lock contention of the
+// state routes. It must run inside the routes so URL parameters are resolved.
+func (m *Metrics) StateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		operation := stateOperation(r)
+		m.stateDuration.WithLabelValues(operation, m.backend).Observe(time.Since(start).Seconds())
+		m.stateRequests.WithLabelValues(operation, m.backend, strconv.Itoa(status)).Inc()
+
+		// Writes fail with 500 only when the storage backend failed
+		if status == http.StatusInternalServerError && operation != "get" && operation != "other" {
+			m.ObserveStorageWriteError(operation)
+		}
+
+		switch operation {
+		case "lock":
+			m.observeLock(r, status)
+		case "unlock":
+			m.observeUnlock(r, status)
+		}
+	})
+}
+
+// observeLock tracks contended lock attempts until the lock is acquired
+func (m *Metrics) observeLock(r *http.Request, status int) {
+	orgID, _ := auth.GetOrgIDFromContext(r.Context())
+	key := orgID.String() + "/" + chi.URLParam(r, "name")
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case status == http.StatusLocked:
+		m.lockContention.WithLabelValues(m.backend).Inc()
+		if _, ok := m.pendingLocks[key]; !ok {
+			m.pruneLocks(m.pendingLocks, pendingLockTTL, now)
+			m.pendingLocks[key] = now
+		}
+	case status < 300:
+		if firstAttempt, ok := m.pendingLocks[key]; ok {
+			m.lockWait.WithLabelValues(m.backend).Observe(now.Sub(firstAttempt).Seconds())
+			delete(m.pendingLocks, key)
+		}
+		m.pruneLocks(m.heldLocks, heldLockTTL, now)
+		m.heldLocks[key] = now
+	}
+}
+
+// observeUnlock records how long a released lock was held
+func (m *Metrics) observeUnlock(r *http.Request, status int) {
+	if status >= 300 {
+		return
+	}
+
+	orgID, _ := auth.GetOrgIDFromContext(r.Context())
+	key := orgID.String() + "/" + chi.URLParam(r, "name")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if acquiredAt, ok := m.heldLocks[key]; ok {
+		m.lockHeld.WithLabelValues(m.backend).Observe(time.Since(acquiredAt).Seconds())
+		delete(m.heldLocks, key)
+	}
+}
+
+// pruneLocks drops tracked locks older than ttl, such as contended locks that
+// were never acquired. Must be called with m.mu held.
+func (m *Metrics) pruneLocks(locks map[string]time.Time, ttl time.Duration, now time.Time) {
+	for key, since := range locks {
+		if now.Sub(since) > ttl {
+			delete(locks, key)
+		}
+	}
+}
+
+// stateOperation maps a state request to its operation label
+func stateOperation(r *http.Request) string {
+	isLock, isRefresh, isForceUnlock := false, false, false
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		isLock = strings.HasSuffix(rctx.RoutePattern(), "/lock")
+		isRefresh = strings.HasSuffix(rctx.RoutePattern(), "/lock/refresh")
+		isForceUnlock = strings.HasSuffix(rctx.RoutePattern(), "/force-unlock")
+	}
+
+	switch {
+	case isRefresh:
+		return "lock_refresh"
+	case isLock && r.Method == http.MethodPost, r.Method == "LOCK":
+		return "lock"
+	case (isLock || isForceUnlock) && r.Method == http.MethodDelete, r.Method == "UNLOCK":
+		return "unlock"
+	case r.Method == http.MethodGet:
+		return "get"
+	case r.Method == http.MethodPost:
+		return "put"
+	case r.Method == http.MethodDelete:
+		return "delete"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// bodyLimitKey stores the unlimited request body in the request context
+type bodyLimitKey struct{}
+
+// BodyLimit limits request bodies to limit bytes; reads past it fail with
+// *http.MaxBytesError. Applied again on a route, it replaces the limit set
+// further up the chain, so large-body routes can raise the global limit.
+func BodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := r.Context().Value(bodyLimitKey{}).(io.ReadCloser)
+			if !ok {
+				body = r.Body
+				r = r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, body))
+			}
+			r.Body = http.MaxBytesReader(w, body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitOverride(t *testing.T) {
+	var readErr error
+	var read int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		read, readErr = len(data), err
+	})
+
+	body := strings.Repeat("x", 100)
+
+	// The global limit applies
+	BodyLimit(10)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(readErr, &maxBytesErr) || maxBytesErr.Limit != 10 {
+		t.Errorf("Expected MaxBytesError with limit 10, got %v", readErr)
+	}
+
+	// A route limit replaces the global limit, in both directions
+	BodyLimit(10)(BodyLimit(1000)(handler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if readErr != nil || read != 100 {
+		t.Errorf("Expected raised limit to read 100 bytes, got %d (err=%v)", read, readErr)
+	}
+
+	BodyLimit(1000)(BodyLimit(50)(handler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if !errors.As(readErr, &maxBytesErr) || maxBytesErr.Limit != 50 {
+		t.Errorf("Expected MaxBytesError with limit 50, got %v", readErr)
+	}
+}
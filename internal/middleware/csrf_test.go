@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// A safe request issues the token cookie
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/ui", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for GET, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookieName || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected a SameSite=Strict %s cookie, got %+v", CSRFCookieName, cookies)
+	}
+	token := cookies[0].Value
+
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		origin string
+		want   int
+	}{
+		{"matching token", token, token, "", http.StatusOK},
+		{"same origin", token, token, "http://example.com", http.StatusOK},
+		{"cross origin", token, token, "http://evil.example", http.StatusForbidden},
+		{"missing header", token, "", "", http.StatusForbidden},
+		{"mismatched header", token, "other", "", http.StatusForbidden},
+		{"missing cookie", "", token, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/ui/states/prod/unlock", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eterrain/tf-backend-service/internal/security"
+)
+
+// IPRateLimiter limits the requests of each client IP before they are
+// authenticated, so a client cannot make the server validate credentials
+// (a bcrypt comparison each) as fast as it can send requests. Buckets are
+// bounded in number; the least recently used is evicted when full.
+type IPRateLimiter struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	maxBuckets int
+	maxTokens  float64
+	refillRate float64
+	rejections atomic.Int64
+}
+
+// ipRateLimitEntry is a client IP's bucket in the LRU list
+type ipRateLimitEntry struct {
+	ip     string
+	bucket *TokenBucket
+}
+
+// NewIPRateLimiter creates a per-IP rate limiter allowing requestsPerMinute
+// with bursts of up to burst requests (0 = requestsPerMinute), keeping at
+// most maxBuckets client IPs
+func NewIPRateLimiter(requestsPerMinute, burst float64, maxBuckets int) *IPRateLimiter {
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	if maxBuckets < 1 {
+		maxBuckets = 1
+	}
+	return &IPRateLimiter{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxBuckets: maxBuckets,
+		maxTokens:  burst,
+		refillRate: requestsPerMinute / 60.0,
+	}
+}
+
+// bucket gets or creates the token bucket of a client IP
+func (l *IPRateLimiter) bucket(ip string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entries[ip]; ok {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*ipRateLimitEntry).bucket
+	}
+	for l.lru.Len() >= l.maxBuckets {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.entries, oldest.Value.(*ipRateLimitEntry).ip)
+	}
+	entry := &ipRateLimitEntry{ip: ip, bucket: NewTokenBucket(l.maxTokens, l.refillRate)}
+	l.entries[ip] = l.lru.PushFront(entry)
+	return entry.bucket
+}
+
+// Take consumes a token of the client IP for a request
+func (l *IPRateLimiter) Take(ip string) RateLimitResult {
+	allowed, remaining, retryAfter := l.bucket(ip).take(0)
+	return RateLimitResult{Allowed: allowed, Limit: l.refillRate * 60, Remaining: remaining, RetryAfter: retryAfter}
+}
+
+// Stats returns the number of client IPs with a bucket and of requests
+// rejected by the middleware
+func (l *IPRateLimiter) Stats() (buckets int, rejections int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len(), l.rejections.Load()
+}
+
+// IPRateLimitMiddleware rejects requests of client IPs over their limit
+// with 429 before they reach authentication. It must run after RealIP, so
+// clients behind trusted proxies are told apart.
+func IPRateLimitMiddleware(limiter *IPRateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				ip = host
+			}
+
+			result := limiter.Take(ip)
+			if !result.Allowed {
+				limiter.rejections.Add(1)
+				security.Emit(security.Request(r, "ratelimit.ip_exceeded", security.SeverityWarning, "IP rate limit exceeded"))
+				setRateLimitHeaders(w, result)
+				http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPRateLimitMiddleware(t *testing.T) {
+	limiter := NewIPRateLimiter(60, 2, 1)
+	handler := IPRateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The port does not tell clients apart
+	for _, addr := range []string{"192.0.2.1:1000", "192.0.2.1:1001"} {
+		if rec := request(addr); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 within the burst, got %d", rec.Code)
+		}
+	}
+	rec := request("192.0.2.1:1002")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 after the burst, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-RateLimit-Remaining") != "0" || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Unexpected headers %v", rec.Header())
+	}
+
+	// Another IP evicts the only bucket and starts with a full one
+	if rec := request("198.51.100.7:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another IP to have its own bucket, got %d", rec.Code)
+	}
+	if buckets, rejections := limiter.Stats(); buckets != 1 || rejections != 1 {
+		t.Errorf("Expected 1 bucket and 1 rejection, got %d and %d", buckets, rejections)
+	}
+}
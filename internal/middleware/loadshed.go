@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority classifies requests for load shedding
+type Priority int
+
+const (
+	// PriorityLow requests (data queries, exports) are shed first under load
+	PriorityLow Priority = iota
+
+	// PriorityNormal requests wait in the queue for a free slot
+	PriorityNormal
+
+	// PriorityCritical requests (state lock/unlock) are always admitted so
+	// running Terraform applies can finish and release their locks
+	PriorityCritical
+)
+
+// String returns the priority name used in logs
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// LoadShedConfig configures the load shedder
+type LoadShedConfig struct {
+	// MaxInFlight is the number of requests processed concurrently
+	MaxInFlight int
+
+	// MaxQueue is the number of requests that may wait for a free slot
+	MaxQueue int
+
+	// QueueTimeout is how long a queued request waits before being shed
+	QueueTimeout time.Duration
+
+	// ShedInFlight sheds low-priority requests at or above this many in-flight requests
+	ShedInFlight int
+
+	// ShedQueueDepth sheds low-priority requests at or above this queue depth
+	ShedQueueDepth int
+
+	// RetryAfter is advertised to shed clients
+	RetryAfter time.Duration
+}
+
+// DefaultLoadShedConfig returns the default load shedding thresholds
+func DefaultLoadShedConfig() LoadShedConfig {
+	return LoadShedConfig{
+		MaxInFlight:    100,
+		MaxQueue:       100,
+		QueueTimeout:   10 * time.Second,
+		ShedInFlight:   80,
+		ShedQueueDepth: 1,
+		RetryAfter:     5 * time.Second,
+	}
+}
+
+// LoadShedder limits concurrent requests and sheds low-priority traffic
+// when the server is saturated
+type LoadShedder struct {
+	config   LoadShedConfig
+	classify func(*http.Request) Priority
+	slots    chan struct{}
+
+	mu         sync.Mutex
+	inFlight   int
+	queueDepth int
+}
+
+// NewLoadShedder creates a load shedder. classify assigns each request a
+// priority; when nil all requests are PriorityNormal.
+func NewLoadShedder(config LoadShedConfig, classify func(*http.Request) Priority) *LoadShedder {
+	defaults := DefaultLoadShedConfig()
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = defaults.MaxInFlight
+	}
+	if config.MaxQueue < 0 {
+		config.MaxQueue = 0
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = defaults.QueueTimeout
+	}
+	if config.ShedInFlight <= 0 || config.ShedInFlight > config.MaxInFlight {
+		config.ShedInFlight = config.MaxInFlight
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = defaults.RetryAfter
+	}
+	if classify == nil {
+		classify = func(*http.Request) Priority { return PriorityNormal }
+	}
+
+	return &LoadShedder{
+		config:   config,
+		classify: classify,
+		slots:    make(chan struct{}, config.MaxInFlight),
+	}
+}
+
+// Stats returns the current number of in-flight and queued requests
+func (ls *LoadShedder) Stats() (inFlight, queueDepth int) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.inFlight, ls.queueDepth
+}
+
+// Middleware admits, queues or sheds requests according to their priority
+func (ls *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := ls.classify(r)
+
+		// Critical requests bypass the concurrency limit entirely
+		if priority == PriorityCritical {
+			ls.mu.Lock()
+			ls.inFlight++
+			ls.mu.Unlock()
+			defer ls.release(false)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if reason := ls.enqueue(priority); reason != "" {
+			ls.shed(w, r, priority, reason)
+			return
+		}
+
+		timer := time.NewTimer(ls.config.QueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case ls.slots <- struct{}{}:
+			ls.mu.Lock()
+			ls.queueDepth--
+			ls.inFlight++
+			ls.mu.Unlock()
+			defer ls.release(true)
+		case <-timer.C:
+			ls.dequeue()
+			ls.shed(w, r, priority, "queue timeout")
+			return
+		case <-r.Context().Done():
+			ls.dequeue()
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enqueue adds a request to the queue, or returns the reason it must be shed
+func (ls *LoadShedder) enqueue(priority Priority) string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if priority == PriorityLow {
+		if ls.inFlight >= ls.config.ShedInFlight {
+			return "in-flight threshold reached"
+		}
+		if ls.config.ShedQueueDepth > 0 && ls.queueDepth >= ls.config.ShedQueueDepth {
+			return "queue depth threshold reached"
+		}
+	}
+
+	// Requests only queue when no slot is free
+	if ls.inFlight >= ls.config.MaxInFlight && ls.queueDepth >= ls.config.MaxQueue {
+		return "queue full"
+	}
+
+	ls.queueDepth++
+	return ""
+}
+
+// dequeue removes a request that left the queue without a slot
+func (ls *LoadShedder) dequeue() {
+	ls.mu.Lock()
+	ls.queueDepth--
+	ls.mu.Unlock()
+}
+
+// release marks a request as finished, freeing its slot if it held one
+func (ls *LoadShedder) release(holdsSlot bool) {
+	if holdsSlot {
+		<-ls.slots
+	}
+	ls.mu.Lock()
+	ls.inFlight--
+	ls.mu.Unlock()
+}
+
+// shed rejects a request with 503 and a Retry-After hint
+func (ls *LoadShedder) shed(w http.ResponseWriter, r *http.Request, priority Priority, reason string) {
+	inFlight, queueDepth := ls.Stats()
+	slog.WarnContext(r.Context(), "Shedding request", "priority", priority.String(), "method", r.Method,
+		"path", r.URL.Path, "reason", reason, "in_flight", inFlight, "queued", queueDepth, "ip", r.RemoteAddr)
+
+	retryAfter := int((ls.config.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Server is overloaded. Please try again later.", http.StatusServiceUnavailable)
+}
+
+// PriorityHeader lets clients tag requests as "batch" or "interactive"
+const PriorityHeader = "X-Priority"
+
+// IsBatchRequest reports whether the client tagged the request as batch traffic
+func IsBatchRequest(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get(PriorityHeader)), "batch")
+}
+
+// ClassifyRequest assigns the default priorities: state lock/unlock is
+// critical, data queries, full syncs, billing exports and requests tagged
+// X-Priority: batch are low, everything else normal
+func ClassifyRequest(r *http.Request) Priority {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	if rest, ok := strings.CutPrefix(path, "/api/v1/state/"); ok {
+		name, suffix, _ := strings.Cut(rest, "/")
+		if name != "" && suffix == "lock" && (r.Method == http.MethodPost || r.Method == http.MethodDelete) {
+			return PriorityCritical
+		}
+		// Terraform's http backend locks with LOCK and UNLOCK on the state URL
+		if name != "" && suffix == "" && (r.Method == "LOCK" || r.Method == "UNLOCK") {
+			return PriorityCritical
+		}
+	}
+
+	// Batch traffic is preemptable so it never delays interactive runs
+	if IsBatchRequest(r) {
+		return PriorityLow
+	}
+
+	if r.Method == http.MethodGet && (path == "/api/v1/data" || path == "/api/v1/data/full-sync" || path == "/admin/v1/billing/export") {
+		return PriorityLow
+	}
+
+	return PriorityNormal
+}
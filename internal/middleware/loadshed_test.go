@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadShedderPriorities(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/upload" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	shedder := NewLoadShedder(LoadShedConfig{
+		MaxInFlight:  1,
+		MaxQueue:     1,
+		QueueTimeout: 50 * time.Millisecond,
+		RetryAfter:   3 * time.Second,
+	}, ClassifyRequest)
+	h := shedder.Middleware(handler)
+
+	// Occupy the only slot with a normal request
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/upload", nil))
+		close(done)
+	}()
+	<-started
+
+	// Low-priority queries are shed immediately with Retry-After
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/data", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for data query under load, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Expected Retry-After 3, got %q", got)
+	}
+
+	// State locks are always admitted
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/state/prod/lock", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for state lock under load, got %d", rec.Code)
+	}
+
+	// Normal requests queue and are shed after the queue timeout
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/state/prod", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after queue timeout, got %d", rec.Code)
+	}
+
+	close(release)
+	<-done
+
+	if inFlight, queued := shedder.Stats(); inFlight != 0 || queued != 0 {
+		t.Errorf("Expected no in-flight or queued requests, got %d/%d", inFlight, queued)
+	}
+
+	// Once idle, low-priority requests are admitted again
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/data", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for data query when idle, got %d", rec.Code)
+	}
+}
+
+func TestClassifyRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   Priority
+	}{
+		{http.MethodPost, "/api/v1/state/prod/lock", PriorityCritical},
+		{http.MethodDelete, "/api/v1/state/prod/lock", PriorityCritical},
+		{http.MethodPost, "/api/v1/state/lock", PriorityNormal},
+		{"LOCK", "/api/v1/state/prod", PriorityCritical},
+		{"UNLOCK", "/api/v1/state/prod/", PriorityCritical},
+		{"LOCK", "/api/v1/state/prod/lock", PriorityNormal},
+		{http.MethodGet, "/api/v1/data", PriorityLow},
+		{http.MethodGet, "/api/v1/data/full-sync", PriorityLow},
+		{http.MethodGet, "/admin/v1/billing/export", PriorityLow},
+		{http.MethodPost, "/api/v1/upload", PriorityNormal},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyRequest(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestBatchRequestsPreemptable(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", nil)
+	req.Header.Set(PriorityHeader, "batch")
+	if got := ClassifyRequest(req); got != PriorityLow {
+		t.Errorf("Expected batch upload to be low priority, got %s", got)
+	}
+
+	// Batch never demotes state locks
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/state/prod/lock", nil)
+	req.Header.Set(PriorityHeader, "batch")
+	if got := ClassifyRequest(req); got != PriorityCritical {
+		t.Errorf("Expected batch state lock to stay critical, got %s", got)
+	}
+
+	// Batch requests leave the reserved share of the bucket to interactive ones
+	limiter := NewPerOrgRateLimiter(4)
+	defer limiter.Stop()
+	orgID := uuid.New()
+
+	batchAllowed := 0
+	for i := 0; i < 4; i++ {
+		if limiter.AllowBatch(orgID) {
+			batchAllowed++
+		}
+	}
+	if batchAllowed != 3 {
+		t.Errorf("Expected 3 batch requests within a limit of 4 with 25%% reserve, got %d", batchAllowed)
+	}
+	if !limiter.Allow(orgID) {
+		t.Error("Expected interactive request to use the reserved token")
+	}
+}
@@ -1,11 +1,18 @@
 package middleware
 
 import (
-	"log"
+	"container/list"
+	"hash/maphash"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/eterrain/tf-backend-service/internal/security"
 	"github.com/google/uuid"
 )
 
@@ -30,9 +37,73 @@ func NewTokenBucket(maxTokens, refillRate float64) *TokenBucket {
 
 // Allow checks if a request is allowed and consumes a token if so
 func (tb *TokenBucket) Allow() bool {
+	return tb.AllowAbove(0)
+}
+
+// AllowAbove consumes a token only if at least reserve tokens remain
+// afterwards, keeping headroom for higher-priority requests
+func (tb *TokenBucket) AllowAbove(reserve float64) bool {
+	allowed, _, _ := tb.take(reserve)
+	return allowed
+}
+
+// take consumes a token if at least reserve tokens remain afterwards. It
+// returns the tokens left to requests with the same reserve and, when the
+// token is refused, the time until one is available to them.
+func (tb *TokenBucket) take(reserve float64) (allowed bool, remaining float64, retryAfter time.Duration) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
+	tb.refill()
+
+	// Check if we have tokens available
+	if tb.tokens >= 1.0+reserve {
+		tb.tokens -= 1.0
+		return true, tb.tokens - reserve, 0
+	}
+
+	missing := 1.0 + reserve - tb.tokens
+	return false, math.Max(0, tb.tokens-reserve), time.Duration(missing / tb.refillRate * float64(time.Second))
+}
+
+// Remaining returns the number of tokens currently available
+func (tb *TokenBucket) Remaining() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	return tb.tokens
+}
+
+// Limit returns the bucket's capacity
+func (tb *TokenBucket) Limit() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.maxTokens
+}
+
+// setLimit changes the bucket's capacity and refill rate. Tokens gained or
+// lost by the change are applied at once, so a raised limit takes effect
+// immediately.
+func (tb *TokenBucket) setLimit(maxTokens, refillRate float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens += maxTokens - tb.maxTokens
+	if tb.tokens < 0 {
+		tb.tokens = 0
+	}
+	if tb.tokens > maxTokens {
+		tb.tokens = maxTokens
+	}
+	tb.maxTokens = maxTokens
+	tb.refillRate = refillRate
+}
+
+// refill adds tokens based on the time elapsed since the last refill.
+// Must be called with tb.mu held.
+func (tb *TokenBucket) refill() {
 	now := time.Now()
 	elapsed := now.Sub(tb.lastRefillTime).Seconds()
 
@@ -42,25 +113,84 @@ func (tb *TokenBucket) Allow() bool {
 		tb.tokens = tb.maxTokens
 	}
 	tb.lastRefillTime = now
+}
 
-	// Check if we have tokens available
-	if tb.tokens >= 1.0 {
-		tb.tokens -= 1.0
-		return true
-	}
+// rateLimitShards is the number of independently locked bucket maps, so
+// requests of different organizations rarely contend on one lock
+const rateLimitShards = 16
+
+// DefaultMaxRateLimitBuckets bounds the buckets kept across all
+// organizations unless SetMaxBuckets changes it
+const DefaultMaxRateLimitBuckets = 100000
+
+// RateLimitOverrides adjusts the rate limit of individual organizations
+type RateLimitOverrides interface {
+	// Lookup returns the factor applied to the organization's limit at the
+	// given time, and whether the organization is exempt
+	Lookup(orgID uuid.UUID, now time.Time) (multiplier float64, exempt bool)
+}
+
+// rateLimitEntry is an organization's bucket in a shard's LRU list
+type rateLimitEntry struct {
+	orgID      uuid.UUID
+	bucket     *TokenBucket
+	multiplier float64 // override the bucket was sized for
+	lastUsed   time.Time
+}
 
-	return false
+// rateLimitShard holds the buckets of the organizations hashed to it, most
+// recently used first
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]*list.Element
+	lru     *list.List
 }
 
-// PerOrgRateLimiter implements per-organization rate limiting
+// PerOrgRateLimiter implements per-organization rate limiting. Buckets are
+// spread over sharded maps and bounded in number: when a shard is full, its
+// least recently used bucket is evicted, so churn of org IDs cannot grow
+// memory without bound. An evicted organization starts again with a full
+// bucket.
 type PerOrgRateLimiter struct {
-	buckets        map[uuid.UUID]*TokenBucket
-	mu             sync.RWMutex
-	maxTokens      float64
-	refillRate     float64
-	cleanupTicker  *time.Ticker
-	stopCleanup    chan struct{}
-	maxIdleTime    time.Duration
+	shards        [rateLimitShards]rateLimitShard
+	seed          maphash.Seed
+	maxPerShard   atomic.Int64
+	evictions     atomic.Int64
+	rejections    atomic.Int64
+	maxTokens     float64
+	refillRate    float64
+	cleanupTicker *time.Ticker
+	stopCleanup   chan struct{}
+	maxIdleTime   time.Duration
+	batchReserve  float64 // fraction of each bucket reserved for interactive requests
+	overrides     RateLimitOverrides
+
+	// Endpoint tiers with their own limits; requests of other endpoints
+	// use this limiter's buckets
+	tiers map[string]*PerOrgRateLimiter
+}
+
+// Endpoint tiers that can be limited apart from the other endpoints
+const (
+	TierDefault = "default"
+	TierUpload  = "upload" // Data uploads
+	TierState   = "state"  // State backend requests
+)
+
+// EndpointTier classifies an API request by the resource it addresses
+// (/api/<version>/<resource>/...) for rate limiting
+func EndpointTier(r *http.Request) string {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 4)
+	if len(parts) < 3 || parts[0] != "api" {
+		return TierDefault
+	}
+	switch parts[2] {
+	case "upload":
+		return TierUpload
+	case "state", "state-prefixes":
+		return TierState
+	}
+	return TierDefault
 }
 
 // NewPerOrgRateLimiter creates a new per-organization rate limiter
@@ -69,12 +199,18 @@ func NewPerOrgRateLimiter(maxRequestsPerMinute float64) *PerOrgRateLimiter {
 	refillRate := maxRequestsPerMinute / 60.0 // convert to per-second rate
 
 	limiter := &PerOrgRateLimiter{
-		buckets:     make(map[uuid.UUID]*TokenBucket),
-		maxTokens:   maxRequestsPerMinute,
-		refillRate:  refillRate,
-		stopCleanup: make(chan struct{}),
-		maxIdleTime: 10 * time.Minute,
+		seed:         maphash.MakeSeed(),
+		maxTokens:    maxRequestsPerMinute,
+		refillRate:   refillRate,
+		stopCleanup:  make(chan struct{}),
+		maxIdleTime:  10 * time.Minute,
+		batchReserve: 0.25,
 	}
+	for i := range limiter.shards {
+		limiter.shards[i].entries = make(map[uuid.UUID]*list.Element)
+		limiter.shards[i].lru = list.New()
+	}
+	limiter.SetMaxBuckets(DefaultMaxRateLimitBuckets)
 
 	// Start cleanup goroutine to remove idle buckets
 	limiter.cleanupTicker = time.NewTicker(5 * time.Minute)
@@ -83,93 +219,287 @@ func NewPerOrgRateLimiter(maxRequestsPerMinute float64) *PerOrgRateLimiter {
 	return limiter
 }
 
+// SetTier gives the organizations' requests of an endpoint tier buckets of
+// their own, with the given rate and burst (0 = the requests per minute).
+// Overrides, the bucket bound and the batch reserve are shared with the
+// default tier.
+func (rl *PerOrgRateLimiter) SetTier(tier string, requestsPerMinute, burst float64) {
+	limiter := NewPerOrgRateLimiter(requestsPerMinute)
+	if burst > 0 {
+		limiter.SetBurst(burst)
+	}
+	limiter.maxPerShard.Store(rl.maxPerShard.Load())
+	limiter.batchReserve = rl.batchReserve
+	limiter.overrides = rl.overrides
+
+	if old := rl.tiers[tier]; old != nil {
+		old.Stop()
+	}
+	if rl.tiers == nil {
+		rl.tiers = make(map[string]*PerOrgRateLimiter)
+	}
+	rl.tiers[tier] = limiter
+}
+
+// Tier returns the limiter of an endpoint tier, which is this limiter for
+// tiers without limits of their own
+func (rl *PerOrgRateLimiter) Tier(tier string) *PerOrgRateLimiter {
+	if limiter, ok := rl.tiers[tier]; ok {
+		return limiter
+	}
+	return rl
+}
+
+// SetBurst sets how many requests an idle organization can send at once,
+// the capacity of its bucket; it defaults to the requests per minute. It
+// applies to buckets created afterwards, so it is set before use.
+func (rl *PerOrgRateLimiter) SetBurst(burst float64) {
+	rl.maxTokens = burst
+}
+
+// Burst returns the bucket capacity of organizations without an override
+func (rl *PerOrgRateLimiter) Burst() float64 {
+	return rl.maxTokens
+}
+
+// RequestsPerMinute returns the sustained rate of organizations without an
+// override
+func (rl *PerOrgRateLimiter) RequestsPerMinute() float64 {
+	return rl.refillRate * 60
+}
+
+// SetMaxBuckets bounds the number of buckets kept across all
+// organizations; it is rounded up to a multiple of the shard count
+func (rl *PerOrgRateLimiter) SetMaxBuckets(maxBuckets int) {
+	perShard := (maxBuckets + rateLimitShards - 1) / rateLimitShards
+	if perShard < 1 {
+		perShard = 1
+	}
+	rl.maxPerShard.Store(int64(perShard))
+	for _, limiter := range rl.tiers {
+		limiter.SetMaxBuckets(maxBuckets)
+	}
+}
+
+// SetOverrides makes the limiter consult per-organization overrides on
+// every request, so changes apply without a restart
+func (rl *PerOrgRateLimiter) SetOverrides(overrides RateLimitOverrides) {
+	rl.overrides = overrides
+	for _, limiter := range rl.tiers {
+		limiter.SetOverrides(overrides)
+	}
+}
+
 // cleanupRoutine removes idle rate limit buckets to prevent memory leaks
 func (rl *PerOrgRateLimiter) cleanupRoutine() {
 	for {
 		select {
 		case <-rl.cleanupTicker.C:
-			rl.mu.Lock()
-			now := time.Now()
-			for orgID, bucket := range rl.buckets {
-				// Remove buckets that haven't been used recently
-				if now.Sub(bucket.lastRefillTime) > rl.maxIdleTime {
-					delete(rl.buckets, orgID)
-				}
-			}
-			rl.mu.Unlock()
+			rl.removeIdle(time.Now())
 		case <-rl.stopCleanup:
 			return
 		}
 	}
 }
 
-// Stop stops the cleanup goroutine
+// removeIdle removes buckets unused for maxIdleTime, locking one shard at a
+// time. Each LRU list is walked from its least recently used end and only
+// as far as the idle buckets reach.
+func (rl *PerOrgRateLimiter) removeIdle(now time.Time) {
+	for i := range rl.shards {
+		shard := &rl.shards[i]
+		shard.mu.Lock()
+		for elem := shard.lru.Back(); elem != nil; elem = shard.lru.Back() {
+			entry := elem.Value.(*rateLimitEntry)
+			if now.Sub(entry.lastUsed) <= rl.maxIdleTime {
+				break
+			}
+			shard.lru.Remove(elem)
+			delete(shard.entries, entry.orgID)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// Stop stops the cleanup goroutines
 func (rl *PerOrgRateLimiter) Stop() {
 	rl.cleanupTicker.Stop()
 	close(rl.stopCleanup)
+	for _, limiter := range rl.tiers {
+		limiter.Stop()
+	}
+}
+
+// Size returns the number of token buckets of organizations across tiers
+func (rl *PerOrgRateLimiter) Size() int {
+	size := 0
+	for _, limiter := range rl.tiers {
+		size += limiter.Size()
+	}
+	for i := range rl.shards {
+		shard := &rl.shards[i]
+		shard.mu.Lock()
+		size += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return size
+}
+
+// Stats returns the number of buckets, of buckets evicted because their
+// shard was full, and of requests rejected by the middleware, across tiers
+func (rl *PerOrgRateLimiter) Stats() (buckets int, evictions, rejections int64) {
+	evictions, rejections = rl.evictions.Load(), rl.rejections.Load()
+	for _, limiter := range rl.tiers {
+		_, tierEvictions, tierRejections := limiter.Stats()
+		evictions += tierEvictions
+		rejections += tierRejections
+	}
+	return rl.Size(), evictions, rejections
 }
 
-// getBucket gets or creates a token bucket for an organization
+// getBucket gets or creates a token bucket for an organization, sized for
+// its override, and marks it as recently used. It returns nil for exempt
+// organizations.
 func (rl *PerOrgRateLimiter) getBucket(orgID uuid.UUID) *TokenBucket {
-	rl.mu.RLock()
-	bucket, exists := rl.buckets[orgID]
-	rl.mu.RUnlock()
+	shard := &rl.shards[maphash.Comparable(rl.seed, orgID)%rateLimitShards]
+	now := time.Now()
 
-	if exists {
-		return bucket
+	multiplier := 1.0
+	if rl.overrides != nil {
+		var exempt bool
+		if multiplier, exempt = rl.overrides.Lookup(orgID, now); exempt {
+			return nil
+		}
 	}
 
-	// Create new bucket
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if elem, exists := shard.entries[orgID]; exists {
+		entry := elem.Value.(*rateLimitEntry)
+		entry.lastUsed = now
+		shard.lru.MoveToFront(elem)
+		if entry.multiplier != multiplier {
+			entry.bucket.setLimit(rl.maxTokens*multiplier, rl.refillRate*multiplier)
+			entry.multiplier = multiplier
+		}
+		return entry.bucket
+	}
 
-	// Double-check after acquiring write lock
-	bucket, exists = rl.buckets[orgID]
-	if exists {
-		return bucket
+	// Make room by evicting the least recently used buckets
+	for int64(shard.lru.Len()) >= rl.maxPerShard.Load() {
+		oldest := shard.lru.Back()
+		shard.lru.Remove(oldest)
+		delete(shard.entries, oldest.Value.(*rateLimitEntry).orgID)
+		rl.evictions.Add(1)
 	}
 
-	bucket = NewTokenBucket(rl.maxTokens, rl.refillRate)
-	rl.buckets[orgID] = bucket
-	return bucket
+	entry := &rateLimitEntry{
+		orgID:      orgID,
+		bucket:     NewTokenBucket(rl.maxTokens*multiplier, rl.refillRate*multiplier),
+		multiplier: multiplier,
+		lastUsed:   now,
+	}
+	shard.entries[orgID] = shard.lru.PushFront(entry)
+	return entry.bucket
 }
 
 // Allow checks if a request from the given organization is allowed
 func (rl *PerOrgRateLimiter) Allow(orgID uuid.UUID) bool {
 	bucket := rl.getBucket(orgID)
-	return bucket.Allow()
+	return bucket == nil || bucket.Allow()
+}
+
+// RateLimitResult is the outcome of a request against its organization's
+// bucket, reported to the client in X-RateLimit headers
+type RateLimitResult struct {
+	Allowed    bool
+	Exempt     bool          // The organization is not limited
+	Limit      float64       // Requests per minute, including the override
+	Remaining  float64       // Requests the client can still make right now
+	RetryAfter time.Duration // Until a rejected request would be allowed
+}
+
+// Take consumes a token of the organization for a request; batch requests
+// cannot use the share reserved for interactive requests
+func (rl *PerOrgRateLimiter) Take(orgID uuid.UUID, batch bool) RateLimitResult {
+	bucket := rl.getBucket(orgID)
+	if bucket == nil {
+		return RateLimitResult{Allowed: true, Exempt: true}
+	}
+	reserve := 0.0
+	if batch {
+		reserve = bucket.Limit() * rl.batchReserve
+	}
+	allowed, remaining, retryAfter := bucket.take(reserve)
+
+	bucket.mu.Lock()
+	limit := bucket.refillRate * 60
+	bucket.mu.Unlock()
+	return RateLimitResult{Allowed: allowed, Limit: limit, Remaining: remaining, RetryAfter: retryAfter}
+}
+
+// Remaining returns the number of requests the organization can still make
+// right now and its bucket capacity, including its override. Exempt
+// organizations are reported with the unscaled limit as remaining.
+func (rl *PerOrgRateLimiter) Remaining(orgID uuid.UUID) (remaining, limit float64) {
+	bucket := rl.getBucket(orgID)
+	if bucket == nil {
+		return rl.maxTokens, rl.maxTokens
+	}
+	return bucket.Remaining(), bucket.Limit()
 }
 
-// OrgIDContextKey is the context key for storing org ID
-type contextKey string
+// AllowBatch checks if a batch request from the given organization is allowed.
+// Batch requests cannot use the share of the bucket reserved for interactive
+// requests, so nightly uploads never exhaust an org's interactive budget.
+func (rl *PerOrgRateLimiter) AllowBatch(orgID uuid.UUID) bool {
+	bucket := rl.getBucket(orgID)
+	if bucket == nil {
+		return true
+	}
+	return bucket.AllowAbove(bucket.Limit() * rl.batchReserve)
+}
 
-const OrgIDContextKey contextKey = "orgid"
+// SetBatchReserve sets the fraction (0-1) of each org's rate limit that batch
+// requests cannot consume
+func (rl *PerOrgRateLimiter) SetBatchReserve(fraction float64) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	rl.batchReserve = fraction
+}
 
-// RateLimitMiddleware creates a middleware that applies per-organization rate limiting
+// RateLimitMiddleware creates a middleware that applies per-organization
+// rate limiting, with the limits of the request's endpoint tier. Limited
+// responses carry the org's limit and remaining requests in X-RateLimit
+// headers.
 func RateLimitMiddleware(limiter *PerOrgRateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract org ID from context (set by auth middleware)
-			orgIDValue := r.Context().Value(OrgIDContextKey)
-			if orgIDValue == nil {
+			orgID, ok := reqctx.OrgID(r.Context())
+			if !ok {
 				// No org ID in context, skip rate limiting (shouldn't happen with auth)
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			orgID, ok := orgIDValue.(uuid.UUID)
-			if !ok {
-				// Invalid org ID type, skip rate limiting
+			// Check rate limit (batch requests leave headroom for interactive ones)
+			tier := EndpointTier(r)
+			result := limiter.Tier(tier).Take(orgID, IsBatchRequest(r))
+			if result.Exempt {
 				next.ServeHTTP(w, r)
 				return
 			}
-
-			// Check rate limit
-			if !limiter.Allow(orgID) {
-				log.Printf("SECURITY: Rate limit exceeded for org %s, IP: %s", orgID, r.RemoteAddr)
-				w.Header().Set("X-RateLimit-Limit", "60")
-				w.Header().Set("X-RateLimit-Remaining", "0")
-				w.Header().Set("Retry-After", "60")
+			setRateLimitHeaders(w, result)
+			if !result.Allowed {
+				limiter.rejections.Add(1)
+				security.Emit(security.Request(r, "ratelimit.exceeded", security.SeverityWarning, "Rate limit exceeded").
+					WithOrg(orgID.String()).With("tier", tier))
 				http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
 				return
 			}
@@ -178,3 +508,13 @@ func RateLimitMiddleware(limiter *PerOrgRateLimiter) func(http.Handler) http.Han
 		})
 	}
 }
+
+// setRateLimitHeaders reports the limit and the remaining requests, and
+// when to retry a rejected request
+func setRateLimitHeaders(w http.ResponseWriter, result RateLimitResult) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(math.Round(result.Limit))))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(result.Remaining)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(result.RetryAfter.Seconds())))))
+	}
+}
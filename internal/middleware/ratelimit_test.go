@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// orgsInShard returns n org IDs hashed to the same shard of the limiter
+func orgsInShard(rl *PerOrgRateLimiter, n int) []uuid.UUID {
+	target := maphash.Comparable(rl.seed, uuid.New()) % rateLimitShards
+	var orgs []uuid.UUID
+	for len(orgs) < n {
+		orgID := uuid.New()
+		if maphash.Comparable(rl.seed, orgID)%rateLimitShards == target {
+			orgs = append(orgs, orgID)
+		}
+	}
+	return orgs
+}
+
+func TestPerOrgRateLimiterBoundsBuckets(t *testing.T) {
+	rl := NewPerOrgRateLimiter(60)
+	defer rl.Stop()
+	rl.SetMaxBuckets(2 * rateLimitShards)
+
+	for i := 0; i < 1000; i++ {
+		rl.Allow(uuid.New())
+	}
+
+	buckets, evictions, _ := rl.Stats()
+	if buckets > 2*rateLimitShards {
+		t.Errorf("Expected at most %d buckets, got %d", 2*rateLimitShards, buckets)
+	}
+	if int64(buckets)+evictions != 1000 {
+		t.Errorf("Expected buckets + evictions = 1000, got %d + %d", buckets, evictions)
+	}
+}
+
+func TestPerOrgRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	rl := NewPerOrgRateLimiter(2)
+	defer rl.Stop()
+	rl.SetMaxBuckets(2 * rateLimitShards)
+
+	orgs := orgsInShard(rl, 3)
+	rl.Allow(orgs[0])
+	rl.Allow(orgs[0])
+	rl.Allow(orgs[1])
+	if rl.Allow(orgs[0]) {
+		t.Fatal("Expected the first org's bucket to be exhausted")
+	}
+
+	// Using the first org again makes the second one least recently used
+	rl.Allow(orgs[2])
+	if _, evictions, _ := rl.Stats(); evictions != 1 {
+		t.Fatalf("Expected 1 eviction, got %d", evictions)
+	}
+	if rl.Allow(orgs[0]) {
+		t.Error("Expected the recently used bucket to be kept")
+	}
+	if remaining, _ := rl.Remaining(orgs[1]); remaining < 1 {
+		t.Errorf("Expected the evicted org to start with a full bucket, got %v tokens", remaining)
+	}
+}
+
+func TestPerOrgRateLimiterRemovesIdleBuckets(t *testing.T) {
+	rl := NewPerOrgRateLimiter(60)
+	defer rl.Stop()
+
+	orgs := orgsInShard(rl, 2)
+	rl.Allow(orgs[0])
+	time.Sleep(10 * time.Millisecond)
+	rl.Allow(orgs[1])
+
+	rl.maxIdleTime = 5 * time.Millisecond
+	rl.removeIdle(time.Now())
+	if size := rl.Size(); size != 1 {
+		t.Errorf("Expected 1 bucket left, got %d", size)
+	}
+	if _, evictions, _ := rl.Stats(); evictions != 0 {
+		t.Errorf("Expected idle removal not to count as eviction, got %d", evictions)
+	}
+}
+
+// TestRateLimitMiddlewareKeysOnAuthenticatedOrg guards against the rate
+// limiter and the auth middleware using different context keys, which
+// silently disabled rate limiting
+func TestRateLimitMiddlewareKeysOnAuthenticatedOrg(t *testing.T) {
+	store := auth.NewInMemoryStore()
+	limitedOrg, otherOrg := uuid.New(), uuid.New()
+	store.AddCredentials(limitedOrg, "key-1")
+	store.AddCredentials(otherOrg, "key-2")
+
+	limiter := NewPerOrgRateLimiter(2)
+	defer limiter.Stop()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := auth.Middleware(store)(RateLimitMiddleware(limiter)(ok))
+
+	request := func(orgID uuid.UUID, apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req.Header.Set("X-Org-ID", orgID.String())
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request(limitedOrg, "key-1"); code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 within the limit, got %d", i+1, code)
+		}
+	}
+	if code := request(limitedOrg, "key-1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once the org exceeded its limit, got %d", code)
+	}
+	if code := request(otherOrg, "key-2"); code != http.StatusOK {
+		t.Errorf("Expected another org to keep its own bucket, got %d", code)
+	}
+	if _, _, rejections := limiter.Stats(); rejections != 1 {
+		t.Errorf("Expected 1 rejection, got %d", rejections)
+	}
+}
+
+func TestRateLimitBurstAndHeaders(t *testing.T) {
+	limiter := NewPerOrgRateLimiter(30)
+	limiter.SetBurst(3)
+	defer limiter.Stop()
+
+	orgID := uuid.New()
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req = req.WithContext(reqctx.WithOrgID(req.Context(), orgID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := request(); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 within the burst, got %d", i+1, rec.Code)
+		}
+	}
+	rec := request()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 after the burst, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "30" {
+		t.Errorf("Expected X-RateLimit-Limit 30, got %q", got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Expected Retry-After 2 at 30 requests per minute, got %q", got)
+	}
+}
+
+func TestRateLimitTiers(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v1/upload":             TierUpload,
+		"/api/v1/upload/csv":         TierUpload,
+		"/api/v2/upload":             TierUpload,
+		"/api/v1/state/prod":         TierState,
+		"/api/v1/state/prod/lock":    TierState,
+		"/api/v1/state-prefixes/env": TierState,
+		"/api/v1/data":               TierDefault,
+		"/api/v1/uploads/123":        TierDefault,
+		"/health":                    TierDefault,
+	} {
+		if got := EndpointTier(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("EndpointTier(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	limiter := NewPerOrgRateLimiter(2)
+	limiter.SetTier(TierState, 60, 3)
+	defer limiter.Stop()
+
+	orgID := uuid.New()
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(reqctx.WithOrgID(req.Context(), orgID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// State requests have a bucket of their own
+	for i, want := range []string{"2", "1", "0"} {
+		rec := request("/api/v1/state/prod")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != want {
+			t.Fatalf("State request %d: expected 200 with %s remaining, got %d with %q", i+1, want, rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+	if rec := request("/api/v1/state/prod"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Limit") != "60" {
+		t.Errorf("Expected 429 with the state limit, got %d with limit %q", rec.Code, rec.Header().Get("X-RateLimit-Limit"))
+	}
+	if rec := request("/api/v1/data"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Errorf("Expected the default tier to be unaffected, got %d with limit %q", rec.Code, rec.Header().Get("X-RateLimit-Limit"))
+	}
+	if buckets, _, rejections := limiter.Stats(); buckets != 2 || rejections != 1 {
+		t.Errorf("Expected 2 buckets and 1 rejection across tiers, got %d and %d", buckets, rejections)
+	}
+}
+
+// staticOverrides applies fixed overrides in tests
+type staticOverrides map[uuid.UUID]float64
+
+func (o staticOverrides) Lookup(orgID uuid.UUID, now time.Time) (float64, bool) {
+	multiplier, ok := o[orgID]
+	if !ok {
+		return 1, false
+	}
+	return multiplier, multiplier == 0
+}
+
+func TestPerOrgRateLimiterOverrides(t *testing.T) {
+	rl := NewPerOrgRateLimiter(2)
+	defer rl.Stop()
+
+	orgID, exemptOrgID := uuid.New(), uuid.New()
+	overrides := staticOverrides{exemptOrgID: 0}
+	rl.SetOverrides(overrides)
+
+	for i := 0; i < 10; i++ {
+		if !rl.Allow(exemptOrgID) || !rl.AllowBatch(exemptOrgID) {
+			t.Fatal("Expected the exempt org never to be limited")
+		}
+	}
+
+	rl.Allow(orgID)
+	rl.Allow(orgID)
+	if rl.Allow(orgID) {
+		t.Fatal("Expected the bucket to be exhausted")
+	}
+
+	// Raising the limit adds the difference to the bucket at once
+	overrides[orgID] = 2
+	if remaining, limit := rl.Remaining(orgID); limit != 4 || remaining < 2 || remaining > 2.1 {
+		t.Errorf("Expected 2 of 4 tokens after the raise, got %v of %v", remaining, limit)
+	}
+
+	// Lowering it again caps the bucket
+	delete(overrides, orgID)
+	if remaining, limit := rl.Remaining(orgID); limit != 2 || remaining > 0.1 {
+		t.Errorf("Expected an empty bucket of 2 after the override ends, got %v of %v", remaining, limit)
+	}
+}
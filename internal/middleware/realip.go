@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies lists the networks of proxies whose forwarding headers
+// are honored
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs and single
+// addresses
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q (must be an IP address or CIDR)", part)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q (must be an IP address or CIDR)", part)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr belongs to a trusted proxy
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP sets r.RemoteAddr to the client IP forwarded by a trusted proxy.
+// Forwarding headers of requests from other peers are ignored, so clients
+// cannot spoof the IP seen by security logs and rate limiting.
+// X-Forwarded-For is read from the right, skipping trusted proxies, since
+// only the entries appended by trusted proxies can be relied on; X-Real-IP
+// and True-Client-IP are used when it is missing.
+func RealIP(trusted TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(trusted) > 0 {
+				if ip, ok := forwardedIP(r, trusted); ok {
+					r.RemoteAddr = ip.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the client IP forwarded to a trusted peer
+func forwardedIP(r *http.Request, trusted TrustedProxies) (netip.Addr, bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trusted.Contains(peer) {
+		return netip.Addr{}, false
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Entries left of a malformed one cannot be trusted either
+				break
+			}
+			client = hop.Unmap()
+			if !trusted.Contains(client) {
+				break
+			}
+		}
+		return client, client != peer
+	}
+
+	for _, header := range []string{"X-Real-IP", "True-Client-IP"} {
+		if value := r.Header.Get(header); value != "" {
+			ip, err := netip.ParseAddr(strings.TrimSpace(value))
+			if err == nil {
+				return ip.Unmap(), true
+			}
+		}
+	}
+	return netip.Addr{}, false
+}
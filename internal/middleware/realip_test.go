@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies TrustedProxies
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"no trusted proxies", nil, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "10.0.0.1:1234"},
+		{"untrusted peer", trusted, "8.8.8.8:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "8.8.8.8:1234"},
+		{"trusted peer", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"spoofed prefix", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"single address", trusted, "192.168.1.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"malformed hop", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, junk, 10.0.0.2"}, "10.0.0.2"},
+		{"x-real-ip", trusted, "10.0.0.1:1234", map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4"},
+		{"true-client-ip", trusted, "10.0.0.1:1234", map[string]string{"True-Client-IP": "2001:db8::1"}, "2001:db8::1"},
+		{"no headers", trusted, "10.0.0.1:1234", nil, "10.0.0.1:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(tt.proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("Expected client IP %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1/"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(previous)
+
+	orgID := uuid.New()
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// As the authentication middleware does further down the chain
+		_ = reqctx.WithOrgID(r.Context(), orgID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/api/v1/state/prod", nil))
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to decode %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "request" || record["level"] != "ERROR" || record["method"] != "PUT" || record["path"] != "/api/v1/state/prod" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if record["status"] != float64(500) || record["bytes"] != float64(4) || record["org_id"] != orgID.String() {
+		t.Errorf("Expected status, size and org, got %v", record)
+	}
+	if _, ok := record["latency_ms"]; !ok {
+		t.Errorf("Expected latency, got %v", record)
+	}
+}
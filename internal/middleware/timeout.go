@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RouteTimeouts holds the request timeout of each route group
+type RouteTimeouts struct {
+	Default time.Duration // Health, self-test and metrics
+	State   time.Duration // Terraform state and lock routes
+	Upload  time.Duration // Data uploads
+	Export  time.Duration // Data queries and billing exports
+	Admin   time.Duration // Other admin routes
+}
+
+// DefaultRouteTimeouts returns the default timeout of each route group
+func DefaultRouteTimeouts() RouteTimeouts {
+	return RouteTimeouts{
+		Default: 60 * time.Second,
+		State:   60 * time.Second,
+		Upload:  60 * time.Second,
+		Export:  5 * time.Minute,
+		Admin:   60 * time.Second,
+	}
+}
+
+// WithDefaults fills unset timeouts with their defaults
+func (t RouteTimeouts) WithDefaults() RouteTimeouts {
+	defaults := DefaultRouteTimeouts()
+	if t.Default <= 0 {
+		t.Default = defaults.Default
+	}
+	if t.State <= 0 {
+		t.State = defaults.State
+	}
+	if t.Upload <= 0 {
+		t.Upload = defaults.Upload
+	}
+	if t.Export <= 0 {
+		t.Export = defaults.Export
+	}
+	if t.Admin <= 0 {
+		t.Admin = defaults.Admin
+	}
+	return t
+}
+
+// timeoutWriteGrace leaves time to write the 504 response after the deadline
+const timeoutWriteGrace = 5 * time.Second
+
+// Timeout cancels the request context after the given duration and responds
+// with a structured 504 including the request ID if the handler has not
+// written a response yet. It also moves the connection read/write deadlines
+// so routes may run longer than the server-wide timeouts.
+func Timeout(group string, timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Not supported by all writers (e.g. test recorders), so errors are ignored
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(start.Add(timeout))
+			_ = rc.SetWriteDeadline(start.Add(timeout + timeoutWriteGrace))
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: w}
+			defer func() {
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return
+				}
+
+				requestID := middleware.GetReqID(r.Context())
+				slog.ErrorContext(r.Context(), "Request timed out", "timeout", timeout, "group", group,
+					"method", r.Method, "path", r.URL.Path, "ip", r.RemoteAddr)
+
+				if tw.claimTimeout() {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusGatewayTimeout)
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"error":      "timeout",
+						"message":    "Request exceeded the " + timeout.String() + " timeout for " + group + " routes",
+						"route":      group,
+						"timeout":    timeout.String(),
+						"request_id": requestID,
+					})
+				}
+			}()
+
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		})
+	}
+}
+
+// timeoutWriter records whether the handler started a response, so the 504
+// is only written when nothing else has been
+type timeoutWriter struct {
+	http.ResponseWriter
+	mu          sync.Mutex
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	tw.wroteHeader = true
+	tw.mu.Unlock()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	tw.wroteHeader = true
+	tw.mu.Unlock()
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// claimTimeout reports whether the timeout response may still be written
+func (tw *timeoutWriter) claimTimeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader {
+		return false
+	}
+	tw.wroteHeader = true
+	return true
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestTimeoutStructuredResponse(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	h := middleware.RequestID(Timeout("export", 20*time.Millisecond)(slow))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/data", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d", rec.Code)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON body, got %q: %v", rec.Body.String(), err)
+	}
+	if body["route"] != "export" || body["timeout"] != "20ms" {
+		t.Errorf("Unexpected timeout body: %v", body)
+	}
+	if body["request_id"] == "" {
+		t.Error("Expected request ID in timeout body")
+	}
+}
+
+func TestTimeoutKeepsHandlerResponse(t *testing.T) {
+	h := Timeout("state", time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/state/prod", nil))
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected handler status 201, got %d", rec.Code)
+	}
+}
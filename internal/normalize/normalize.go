@@ -0,0 +1,251 @@
+// Package normalize cleans uploaded attribute values with per-organization
+// rules so reports don't have to reconcile "Running", "running" and "RUNNING".
+package normalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Case conversions
+const (
+	CaseLower = "lower"
+	CaseUpper = "upper"
+)
+
+// Rule describes how the values of one attribute key are normalized. The
+// steps run in order: trim, case, enum mapping, unit conversion. Trim, case
+// and enum mapping only apply to string values.
+type Rule struct {
+	// Trim removes leading and trailing whitespace
+	Trim bool `json:"trim,omitempty"`
+
+	// Case converts the value to "lower" or "upper" case
+	Case string `json:"case,omitempty"`
+
+	// Enum maps values (after trim and case) to canonical values,
+	// e.g. {"stopped": "off", "terminated": "off"}
+	Enum map[string]string `json:"enum,omitempty"`
+
+	// Units converts values such as "2 GB" to a number in the base unit by
+	// multiplying with the factor of the unit suffix, e.g. {"MB": 1, "GB": 1024}.
+	// Values without a known suffix are left unchanged.
+	Units map[string]float64 `json:"units,omitempty"`
+}
+
+// Validate checks that the rule is well-formed
+func (r Rule) Validate() error {
+	if r.Case != "" && r.Case != CaseLower && r.Case != CaseUpper {
+		return fmt.Errorf("invalid case %q: must be %q or %q", r.Case, CaseLower, CaseUpper)
+	}
+	for unit, factor := range r.Units {
+		if strings.TrimSpace(unit) == "" {
+			return fmt.Errorf("unit suffix must not be empty")
+		}
+		if factor == 0 {
+			return fmt.Errorf("unit %q has a zero factor", unit)
+		}
+	}
+	return nil
+}
+
+// Apply returns the normalized value
+func (r Rule) Apply(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+
+	if r.Trim {
+		s = strings.TrimSpace(s)
+	}
+	switch r.Case {
+	case CaseLower:
+		s = strings.ToLower(s)
+	case CaseUpper:
+		s = strings.ToUpper(s)
+	}
+	if mapped, ok := r.Enum[s]; ok {
+		s = mapped
+	}
+	if converted, ok := r.convertUnit(s); ok {
+		return converted
+	}
+	return s
+}
+
+// convertUnit parses "<number>[ ]<unit>" and returns the number in the base
+// unit. The longest matching suffix wins so "MiB" is not read as "B".
+func (r Rule) convertUnit(s string) (float64, bool) {
+	var suffix string
+	for unit := range r.Units {
+		if strings.HasSuffix(s, unit) && len(unit) > len(suffix) {
+			suffix = unit
+		}
+	}
+	if suffix == "" {
+		return 0, false
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, suffix)), 64)
+	if err != nil {
+		return 0, false
+	}
+	return number * r.Units[suffix], true
+}
+
+// Rules maps attribute keys to their normalization rule
+type Rules map[string]Rule
+
+// Apply normalizes the attributes in place. Keys without a rule are left
+// unchanged.
+func (rules Rules) Apply(attributes map[string]interface{}) {
+	for key, value := range attributes {
+		if rule, ok := rules[key]; ok {
+			attributes[key] = rule.Apply(value)
+		}
+	}
+}
+
+// Store keeps the normalization rules of all organizations and persists
+// them to a JSON file on every change
+type Store struct {
+	mu       sync.RWMutex
+	orgs     map[uuid.UUID]Rules
+	filePath string
+}
+
+// NewStore creates a rule store backed by the given file. Existing rules
+// are loaded from the file if it exists, so operators can also define
+// rules in the file before startup. An empty path keeps the rules in
+// memory only.
+func NewStore(filePath string) (*Store, error) {
+	s := &Store{
+		orgs:     make(map[uuid.UUID]Rules),
+		filePath: filePath,
+	}
+
+	if filePath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read normalization file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(data, &s.orgs); err != nil {
+		return nil, fmt.Errorf("failed to parse normalization file %s: %w", filePath, err)
+	}
+
+	for orgID, rules := range s.orgs {
+		for key, rule := range rules {
+			if err := rule.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid normalization rule for org %s, attribute %s: %w", orgID, key, err)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Get returns the rules of an organization
+func (s *Store) Get(orgID uuid.UUID) (Rules, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules, exists := s.orgs[orgID]
+	return rules, exists
+}
+
+// Set replaces the rules of an organization
+func (s *Store) Set(orgID uuid.UUID, rules Rules) error {
+	for key, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("attribute %s: %w", key, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	s.orgs[orgID] = rules
+	if err := s.save(); err != nil {
+		if existed {
+			s.orgs[orgID] = previous
+		} else {
+			delete(s.orgs, orgID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the rules of an organization. It reports whether rules
+// existed.
+func (s *Store) Delete(orgID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	if !existed {
+		return false, nil
+	}
+
+	delete(s.orgs, orgID)
+	if err := s.save(); err != nil {
+		s.orgs[orgID] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// Apply normalizes the attributes in place with the organization's rules
+func (s *Store) Apply(orgID uuid.UUID, attributes map[string]interface{}) {
+	if rules, exists := s.Get(orgID); exists {
+		rules.Apply(attributes)
+	}
+}
+
+// save writes all rules to disk. The file is replaced atomically so a crash
+// never leaves a partial file. The caller must hold the write lock.
+func (s *Store) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.orgs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal normalization rules: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create normalization directory: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write normalization file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to replace normalization file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package normalize
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRuleApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		value interface{}
+		want  interface{}
+	}{
+		{"trim and lowercase", Rule{Trim: true, Case: CaseLower}, "  RUNNING ", "running"},
+		{"uppercase", Rule{Case: CaseUpper}, "us-east-1", "US-EAST-1"},
+		{"enum after case", Rule{Case: CaseLower, Enum: map[string]string{"stopped": "off"}}, "Stopped", "off"},
+		{"unknown enum value kept", Rule{Enum: map[string]string{"stopped": "off"}}, "running", "running"},
+		{"unit conversion", Rule{Units: map[string]float64{"MB": 1, "GB": 1024}}, "2 GB", float64(2048)},
+		{"longest unit suffix", Rule{Units: map[string]float64{"B": 1, "KiB": 1024}}, "4KiB", float64(4096)},
+		{"unparseable unit value kept", Rule{Units: map[string]float64{"GB": 1024}}, "lots GB", "lots GB"},
+		{"non-string value kept", Rule{Trim: true, Case: CaseLower}, float64(3), float64(3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Apply(tt.value); got != tt.want {
+				t.Errorf("Apply(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	if err := (Rule{Case: "title"}).Validate(); err == nil {
+		t.Error("Expected error for unknown case")
+	}
+	if err := (Rule{Units: map[string]float64{"GB": 0}}).Validate(); err == nil {
+		t.Error("Expected error for zero unit factor")
+	}
+}
+
+func TestStoreApplyAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normalization.json")
+	orgID := uuid.New()
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if err := store.Set(orgID, Rules{"status": {Trim: true, Case: CaseLower}}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	attributes := map[string]interface{}{"status": " Running", "name": "Web-1"}
+	reloaded.Apply(orgID, attributes)
+	if attributes["status"] != "running" || attributes["name"] != "Web-1" {
+		t.Errorf("Unexpected normalized attributes: %v", attributes)
+	}
+
+	// Other orgs are not affected
+	attributes = map[string]interface{}{"status": "Running"}
+	reloaded.Apply(uuid.New(), attributes)
+	if attributes["status"] != "Running" {
+		t.Errorf("Rules leaked to another org: %v", attributes)
+	}
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SlackChannel posts events to a Slack incoming webhook
+type SlackChannel struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewSlackChannel creates a channel posting to the given Slack webhook URL.
+// A nil client uses a default client with a 10 second timeout.
+func NewSlackChannel(webhookURL string, client *http.Client) *SlackChannel {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &SlackChannel{
+		webhookURL: webhookURL,
+		client:     client,
+	}
+}
+
+// Send implements Channel
+func (c *SlackChannel) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, c.client, c.webhookURL, map[string]string{"text": event.Text()})
+}
+
+// WebhookChannel posts events as JSON to an HTTP endpoint
+type WebhookChannel struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookChannel creates a channel posting events to the given URL. A
+// nil client uses a default client with a 10 second timeout.
+func NewWebhookChannel(url string, client *http.Client) *WebhookChannel {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookChannel{
+		url:    url,
+		client: client,
+	}
+}
+
+// Send implements Channel
+func (c *WebhookChannel) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, c.client, c.url, event)
+}
+
+// postJSON posts the payload as JSON and checks for a successful status
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SMTPConfig configures the email channel
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // Authentication is skipped when empty
+	Password string
+	From     string
+	To       []string
+}
+
+// SMTPChannel sends events as plain-text email
+type SMTPChannel struct {
+	config SMTPConfig
+}
+
+// NewSMTPChannel creates an email channel
+func NewSMTPChannel(config SMTPConfig) (*SMTPChannel, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("SMTP sender address is required")
+	}
+	if len(config.To) == 0 {
+		return nil, fmt.Errorf("at least one SMTP recipient is required")
+	}
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	return &SMTPChannel{config: config}, nil
+}
+
+// Send implements Channel. net/smtp does not support contexts, so the
+// context is only checked before sending.
+func (c *SMTPChannel) Send(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if c.config.Username != "" {
+		auth = smtp.PlainAuth("", c.config.Username, c.config.Password, c.config.Host)
+	}
+
+	subject := fmt.Sprintf("[tf-backend-service] %s", event.Subject)
+	message := strings.Join([]string{
+		"From: " + c.config.From,
+		"To: " + strings.Join(c.config.To, ", "),
+		"Subject: " + sanitizeHeader(subject),
+		"Date: " + event.Time.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		event.Text(),
+	}, "\r\n")
+
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	if err := smtp.SendMail(addr, auth, c.config.From, c.config.To, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// sanitizeHeader prevents header injection through event subjects
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
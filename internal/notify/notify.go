@@ -0,0 +1,271 @@
+// Package notify delivers operational events (quota warnings, reload
+// failures, backup results, stale locks) to pluggable channels such as
+// email and Slack, routed per event type.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventQuotaWarning  = "quota_warning"
+	EventReloadFailure = "reload_failure"
+	EventBackupResult  = "backup_result"
+	EventStaleLock     = "stale_lock"
+	EventWatcherHealth = "watcher_health"
+)
+
+// EventTypes lists every event type that can be routed
+var EventTypes = []string{EventQuotaWarning, EventReloadFailure, EventBackupResult, EventStaleLock, EventWatcherHealth}
+
+// Severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// sendTimeout bounds the delivery of a single event to a single channel
+const sendTimeout = 30 * time.Second
+
+// queueSize is the number of events buffered for delivery
+const queueSize = 100
+
+// Event is an operational event delivered to notification channels
+type Event struct {
+	Type     string                 `json:"type"`
+	Severity string                 `json:"severity"`
+	Subject  string                 `json:"subject"`
+	Message  string                 `json:"message"`
+	OrgID    string                 `json:"org_id,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// Text renders the event as plain text for chat and email channels
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s\n%s", strings.ToUpper(e.Severity), e.Subject, e.Message)
+	if e.OrgID != "" {
+		fmt.Fprintf(&b, "\norg_id: %s", e.OrgID)
+	}
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s: %v", k, e.Fields[k])
+	}
+
+	fmt.Fprintf(&b, "\ntime: %s", e.Time.Format(time.RFC3339))
+	return b.String()
+}
+
+// Channel delivers events to an external system
+type Channel interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// Notifier accepts events for delivery
+type Notifier interface {
+	Notify(event Event)
+}
+
+// Dispatcher routes events to channels by event type and delivers them in
+// the background so callers are never blocked by slow channels
+type Dispatcher struct {
+	mu       sync.RWMutex
+	channels map[string]Channel
+	routes   map[string][]string
+
+	queue  chan Event
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher and starts its delivery worker
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{
+		channels: make(map[string]Channel),
+		routes:   make(map[string][]string),
+		queue:    make(chan Event, queueSize),
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d
+}
+
+// AddChannel registers a channel under the given name
+func (d *Dispatcher) AddChannel(name string, channel Channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[name] = channel
+}
+
+// Route delivers events of the given type to the named channels
+func (d *Dispatcher) Route(eventType string, channelNames ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, name := range channelNames {
+		if _, ok := d.channels[name]; !ok {
+			return fmt.Errorf("unknown notification channel %q for event %s", name, eventType)
+		}
+	}
+
+	d.routes[eventType] = append(d.routes[eventType], channelNames...)
+	return nil
+}
+
+// Routed reports whether any channel receives events of the given type
+func (d *Dispatcher) Routed(eventType string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.routes[eventType]) > 0
+}
+
+// Notify queues an event for delivery. Events without a route are dropped,
+// as are events that arrive while the queue is full.
+func (d *Dispatcher) Notify(event Event) {
+	if !d.Routed(event.Type) {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Severity == "" {
+		event.Severity = SeverityInfo
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	select {
+	case d.queue <- event:
+	default:
+		slog.Error("Notification queue full, dropping event", "type", event.Type, "subject", event.Subject)
+	}
+}
+
+// run delivers queued events until the dispatcher is closed
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	for event := range d.queue {
+		d.deliver(event)
+	}
+}
+
+// deliver sends an event to every channel routed for its type
+func (d *Dispatcher) deliver(event Event) {
+	d.mu.RLock()
+	names := d.routes[event.Type]
+	channels := make(map[string]Channel, len(names))
+	for _, name := range names {
+		channels[name] = d.channels[name]
+	}
+	d.mu.RUnlock()
+
+	for name, channel := range channels {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := channel.Send(ctx, event); err != nil {
+			slog.Error("Failed to deliver notification", "type", event.Type, "channel", name, "error", err)
+		}
+		cancel()
+	}
+}
+
+// Close stops accepting events and waits for queued events to be delivered
+func (d *Dispatcher) Close() error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+	return nil
+}
+
+// Channel names used in routes
+const (
+	ChannelSlack   = "slack"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Config configures the built-in channels and the per-event-type routes
+type Config struct {
+	SlackWebhookURL string // Enables the slack channel
+	WebhookURL      string // Enables the webhook channel
+	SMTP            SMTPConfig
+
+	// HTTPClient sends the requests of the slack and webhook channels
+	// (a default client when nil)
+	HTTPClient *http.Client
+
+	// Routes maps event types to channel names
+	Routes map[string][]string
+}
+
+// New creates a dispatcher with the configured channels and routes.
+// Channels without configuration are not registered, so routing an event
+// to them is an error.
+func New(config Config) (*Dispatcher, error) {
+	d := NewDispatcher()
+
+	if config.SlackWebhookURL != "" {
+		d.AddChannel(ChannelSlack, NewSlackChannel(config.SlackWebhookURL, config.HTTPClient))
+	}
+	if config.WebhookURL != "" {
+		d.AddChannel(ChannelWebhook, NewWebhookChannel(config.WebhookURL, config.HTTPClient))
+	}
+	if config.SMTP.Host != "" {
+		channel, err := NewSMTPChannel(config.SMTP)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("invalid email channel: %w", err)
+		}
+		d.AddChannel(ChannelEmail, channel)
+	}
+
+	for eventType, channels := range config.Routes {
+		if len(channels) == 0 {
+			continue
+		}
+		if err := d.Route(eventType, channels...); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// SplitList splits a comma-separated list, dropping empty entries
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
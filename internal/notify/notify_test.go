@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingChannel struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *recordingChannel) Send(ctx context.Context, event Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+	return nil
+}
+
+func (c *recordingChannel) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events)
+}
+
+func TestDispatcherRoutesByEventType(t *testing.T) {
+	slack := &recordingChannel{}
+	email := &recordingChannel{}
+
+	d := NewDispatcher()
+	d.AddChannel(ChannelSlack, slack)
+	d.AddChannel(ChannelEmail, email)
+	if err := d.Route(EventQuotaWarning, ChannelSlack); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if err := d.Route(EventReloadFailure, ChannelSlack, ChannelEmail); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	d.Notify(Event{Type: EventQuotaWarning, Subject: "quota"})
+	d.Notify(Event{Type: EventReloadFailure, Subject: "reload"})
+	d.Notify(Event{Type: EventBackupResult, Subject: "unrouted"})
+	d.Close()
+
+	if slack.count() != 2 {
+		t.Errorf("Expected 2 slack events, got %d", slack.count())
+	}
+	if email.count() != 1 {
+		t.Errorf("Expected 1 email event, got %d", email.count())
+	}
+	if email.events[0].Severity != SeverityInfo || email.events[0].Time.IsZero() {
+		t.Errorf("Expected severity and time defaults, got %+v", email.events[0])
+	}
+
+	// Events after Close are dropped
+	d.Notify(Event{Type: EventQuotaWarning})
+}
+
+func TestDispatcherUnknownChannel(t *testing.T) {
+	d := NewDispatcher()
+	defer d.Close()
+
+	if err := d.Route(EventStaleLock, ChannelEmail); err == nil {
+		t.Error("Expected error routing to an unconfigured channel")
+	}
+}
+
+func TestNewWebhookAndSlackChannels(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	d, err := New(Config{
+		SlackWebhookURL: server.URL + "/slack",
+		WebhookURL:      server.URL + "/webhook",
+		Routes: map[string][]string{
+			EventReloadFailure: SplitList("slack, webhook,"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	d.Notify(Event{
+		Type:     EventReloadFailure,
+		Severity: SeverityCritical,
+		Subject:  "Failed to reload ./auth.cfg",
+		Fields:   map[string]interface{}{"file": "./auth.cfg"},
+	})
+	d.Close()
+
+	text, _ := bodies["/slack"]["text"].(string)
+	if !strings.Contains(text, "[CRITICAL] Failed to reload ./auth.cfg") || !strings.Contains(text, "file: ./auth.cfg") {
+		t.Errorf("Unexpected slack text: %q", text)
+	}
+	if bodies["/webhook"]["type"] != EventReloadFailure {
+		t.Errorf("Unexpected webhook body: %v", bodies["/webhook"])
+	}
+}
+
+func TestNewRejectsUnconfiguredRoute(t *testing.T) {
+	_, err := New(Config{
+		Routes: map[string][]string{EventBackupResult: {ChannelSlack}},
+	})
+	if err == nil {
+		t.Error("Expected error for route to unconfigured channel")
+	}
+
+	_, err = New(Config{SMTP: SMTPConfig{Host: "smtp.example.com"}})
+	if err == nil {
+		t.Error("Expected error for incomplete SMTP config")
+	}
+}
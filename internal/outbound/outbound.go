@@ -0,0 +1,205 @@
+// Package outbound builds the HTTP clients of calls to external systems
+// (notification webhooks, the OPA policy server), so that every
+// integration goes through the same proxy and trusts the same CAs:
+//
+//	environment  proxies are taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+//	none         every request is sent directly, whatever the environment
+//	<URL>        every request goes through this proxy, except for hosts
+//	             matching the no-proxy list
+//
+// A CA bundle adds private CAs to the system pool, so servers with
+// certificates issued by a corporate CA are trusted without disabling
+// verification.
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Proxy modes
+const (
+	ProxyEnvironment = "environment"
+	ProxyNone        = "none"
+)
+
+// Config configures the proxy and trusted CAs of outbound calls
+type Config struct {
+	Proxy    string // "environment" (default), "none" or a proxy URL
+	NoProxy  string // Comma-separated hosts, domains and CIDRs bypassing a proxy URL
+	CABundle string // PEM file of CAs trusted in addition to the system pool
+}
+
+// Factory creates HTTP clients sharing one transport, so connections to
+// the same host are reused across integrations
+type Factory struct {
+	transport *http.Transport
+}
+
+// New creates a factory for the given configuration
+func New(config Config) (*Factory, error) {
+	proxy, err := proxyFunc(config.Proxy, config.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	if config.CABundle != "" {
+		pool, err := loadCABundle(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &Factory{transport: transport}, nil
+}
+
+// Client returns a client with the given overall request timeout
+// (0 = no timeout). A nil factory returns a client with the default
+// transport.
+func (f *Factory) Client(timeout time.Duration) *http.Client {
+	if f == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{Transport: f.transport, Timeout: timeout}
+}
+
+// Transport returns the shared transport, for clients whose timeouts are
+// managed by their owner
+func (f *Factory) Transport() http.RoundTripper {
+	if f == nil {
+		return http.DefaultTransport
+	}
+	return f.transport
+}
+
+// ParseProxy validates a proxy setting and returns its proxy URL, which is
+// nil for the environment and none modes
+func ParseProxy(proxy string) (*url.URL, error) {
+	switch proxy {
+	case "", ProxyEnvironment, ProxyNone:
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(proxy)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid outbound proxy %q (must be environment, none or a proxy URL)", proxy)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid outbound proxy %q (scheme must be http, https or socks5)", proxy)
+	}
+	return parsed, nil
+}
+
+// proxyFunc returns the transport proxy function of a proxy setting
+func proxyFunc(proxy, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	proxyURL, err := ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case proxy == ProxyNone:
+		return nil, nil
+	case proxyURL == nil:
+		return http.ProxyFromEnvironment, nil
+	}
+
+	bypass := parseNoProxy(noProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass.matches(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// noProxyList is a parsed list of hosts bypassing the proxy
+type noProxyList struct {
+	all     bool
+	domains []string // Matched exactly and as a suffix after a dot
+	nets    []*net.IPNet
+	ips     []net.IP
+}
+
+// parseNoProxy parses a NO_PROXY style list: "*", host names, domains
+// with or without a leading dot, IP addresses and CIDRs
+func parseNoProxy(value string) noProxyList {
+	var list noProxyList
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			list.all = true
+		default:
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				list.nets = append(list.nets, network)
+			} else if ip := net.ParseIP(entry); ip != nil {
+				list.ips = append(list.ips, ip)
+			} else {
+				list.domains = append(list.domains, strings.TrimPrefix(entry, "."))
+			}
+		}
+	}
+	return list
+}
+
+// matches reports whether requests to host bypass the proxy
+func (l noProxyList) matches(host string) bool {
+	if l.all {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	if ip := net.ParseIP(host); ip != nil {
+		for _, candidate := range l.ips {
+			if candidate.Equal(ip) {
+				return true
+			}
+		}
+		for _, network := range l.nets {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, domain := range l.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCABundle returns the system pool with the certificates of a PEM file
+// added
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
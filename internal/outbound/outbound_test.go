@@ -0,0 +1,148 @@
+package outbound
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseProxy(t *testing.T) {
+	for _, proxy := range []string{"", ProxyEnvironment, ProxyNone} {
+		if proxyURL, err := ParseProxy(proxy); err != nil || proxyURL != nil {
+			t.Errorf("ParseProxy(%q) = %v, %v; expected no URL", proxy, proxyURL, err)
+		}
+	}
+
+	proxyURL, err := ParseProxy("http://proxy.corp:3128")
+	if err != nil || proxyURL.Host != "proxy.corp:3128" {
+		t.Errorf("Unexpected proxy %v, %v", proxyURL, err)
+	}
+
+	for _, proxy := range []string{"proxy.corp:3128", "ftp://proxy.corp", "http://"} {
+		if _, err := ParseProxy(proxy); err == nil {
+			t.Errorf("Expected error for proxy %q", proxy)
+		}
+	}
+}
+
+func TestNoProxyMatches(t *testing.T) {
+	list := parseNoProxy(" .corp.example, localhost,10.0.0.0/8, 192.168.1.5 ,")
+
+	for host, expected := range map[string]bool{
+		"corp.example":       true,
+		"hooks.corp.example": true,
+		"CORP.EXAMPLE":       true,
+		"notcorp.example":    false,
+		"localhost":          true,
+		"10.1.2.3":           true,
+		"192.168.1.5":        true,
+		"192.168.1.6":        false,
+		"hooks.slack.com":    false,
+	} {
+		if list.matches(host) != expected {
+			t.Errorf("matches(%q) = %v, expected %v", host, !expected, expected)
+		}
+	}
+
+	if !parseNoProxy("*").matches("anything.example") {
+		t.Error("Expected * to match every host")
+	}
+}
+
+func TestFactoryProxyURL(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	factory, err := New(Config{Proxy: proxy.URL, NoProxy: "direct.example"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	resp, err := factory.Client(time.Second).Get("http://hooks.example/notify")
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(proxied) != 1 || proxied[0] != "http://hooks.example/notify" {
+		t.Errorf("Expected the request to go through the proxy, got %v", proxied)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://api.direct.example/", nil)
+	if proxyURL, _ := factory.transport.Proxy(req); proxyURL != nil {
+		t.Errorf("Expected no-proxy host to bypass the proxy, got %v", proxyURL)
+	}
+}
+
+func TestFactoryProxyNone(t *testing.T) {
+	factory, err := New(Config{Proxy: ProxyNone})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if factory.transport.Proxy != nil {
+		t.Error("Expected no proxy function with proxy none")
+	}
+}
+
+func TestFactoryCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// The test server's certificate is not trusted without the bundle
+	untrusted, err := New(Config{Proxy: ProxyNone})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if resp, err := untrusted.Client(time.Second).Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected certificate verification to fail without the CA bundle")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0644); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+
+	trusted, err := New(Config{Proxy: ProxyNone, CABundle: bundle})
+	if err != nil {
+		t.Fatalf("New with CA bundle failed: %v", err)
+	}
+	resp, err := trusted.Client(time.Second).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request with CA bundle failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestFactoryInvalidCABundle(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, []byte("not a certificate"), 0644)
+
+	if _, err := New(Config{CABundle: bundle}); err == nil {
+		t.Error("Expected error for a bundle without certificates")
+	}
+	if _, err := New(Config{CABundle: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("Expected error for a missing bundle")
+	}
+}
+
+func TestNilFactory(t *testing.T) {
+	var factory *Factory
+	if client := factory.Client(time.Second); client.Timeout != time.Second || client.Transport != nil {
+		t.Errorf("Unexpected client from nil factory: %+v", client)
+	}
+	if factory.Transport() != http.DefaultTransport {
+		t.Error("Expected default transport from nil factory")
+	}
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/security"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware evaluates every request against the policy and rejects denied
+// requests with 403. If the policy cannot be evaluated the request is
+// rejected with 503, or allowed when failOpen is set. It must run after
+// auth.Middleware.
+func Middleware(evaluator Evaluator, failOpen bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			input := NewInput(r)
+
+			decision, err := evaluator.Evaluate(r.Context(), input)
+			if err != nil {
+				if failOpen {
+					slog.ErrorContext(r.Context(), "Policy evaluation failed, allowing request (fail-open)",
+						"org_id", input.OrgID, "method", r.Method, "path", r.URL.Path, "error", err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				slog.ErrorContext(r.Context(), "Policy evaluation failed",
+					"org_id", input.OrgID, "method", r.Method, "path", r.URL.Path, "error", err)
+				http.Error(w, "Policy evaluation unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			if !decision.Allow {
+				security.Emit(security.Request(r, "policy.denied", security.SeverityWarning, "Denied by policy").
+					WithOrg(input.OrgID).With("reason", decision.Reason))
+				message := "Denied by policy"
+				if decision.Reason != "" {
+					message += ": " + decision.Reason
+				}
+				http.Error(w, message, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewInput describes an authenticated request for policy evaluation
+func NewInput(r *http.Request) Input {
+	input := Input{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		RemoteIP:      r.RemoteAddr,
+		RequestID:     middleware.GetReqID(r.Context()),
+		ContentLength: r.ContentLength,
+		ContentType:   r.Header.Get("Content-Type"),
+		Scopes:        auth.DefaultScopes,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		input.RemoteIP = host
+	}
+
+	if orgID, ok := auth.GetOrgIDFromContext(r.Context()); ok {
+		input.OrgID = orgID.String()
+	}
+	if key, ok := auth.GetKeyFromContext(r.Context()); ok {
+		input.KeyID = key.ID()
+		input.Scopes = key.EffectiveScopes()
+	}
+
+	input.Resource, input.StateName = classifyPath(r.URL.Path)
+	if input.Resource == "state" && (r.Method == "LOCK" || r.Method == "UNLOCK") {
+		// Terraform's http backend locks with custom methods on the state URL
+		input.Resource = "lock"
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		input.Action = "read"
+	case http.MethodDelete:
+		input.Action = "delete"
+	default:
+		input.Action = "write"
+	}
+	return input
+}
+
+// classifyPath returns the resource a request path refers to and, for state
+// and lock routes, the state name
+func classifyPath(path string) (string, string) {
+	path = strings.TrimSuffix(path, "/")
+
+	if rest, ok := strings.CutPrefix(path, "/api/v1/state/"); ok {
+		name, suffix, _ := strings.Cut(rest, "/")
+		if suffix == "lock" || suffix == "lock-history" || suffix == "force-unlock" {
+			return "lock", name
+		}
+		return "state", name
+	}
+
+	rest, ok := strings.CutPrefix(path, "/api/v1/")
+	if !ok {
+		rest, _ = strings.CutPrefix(path, "/api/v2/")
+	}
+	switch first, _, _ := strings.Cut(rest, "/"); first {
+	case "upload", "uploads", "data", "taxonomy", "changes":
+		return "data", ""
+	case "state", "state-prefixes":
+		return "state", ""
+	case "keys":
+		return "keys", ""
+	case "health":
+		return "health", ""
+	}
+	return "other", ""
+}
@@ -0,0 +1,180 @@
+// Package policy evaluates authenticated requests against organization
+// policies, e.g. "deny state deletes in prod workspaces". Policies are Rego
+// modules evaluated by an Open Policy Agent (OPA) server, which loads them
+// from a bundle server or from files pushed by this service on start.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDecisionPath is the OPA document queried for decisions
+const DefaultDecisionPath = "tfbackend/authz"
+
+// policyIDPrefix namespaces the policies pushed by this service in OPA
+const policyIDPrefix = "tfbackend/"
+
+// Input describes a request to a policy
+type Input struct {
+	OrgID     string   `json:"org_id"`
+	KeyID     string   `json:"key_id,omitempty"`
+	Scopes    []string `json:"scopes"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Resource  string   `json:"resource"` // state, lock, data, keys, health
+	Action    string   `json:"action"`   // read, write, delete
+	StateName string   `json:"state_name,omitempty"`
+	RemoteIP  string   `json:"remote_ip"`
+	RequestID string   `json:"request_id,omitempty"`
+
+	// Payload metadata; the body itself is never sent to the policy
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type,omitempty"`
+}
+
+// Decision is the result of a policy evaluation
+type Decision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Evaluator decides whether a request is allowed
+type Evaluator interface {
+	Evaluate(ctx context.Context, input Input) (Decision, error)
+}
+
+// OPAClient evaluates policies with the OPA REST API
+type OPAClient struct {
+	url          string
+	decisionPath string
+	client       *http.Client
+}
+
+// NewOPAClient creates a client for the OPA server at baseURL, querying the
+// decision document at decisionPath (e.g. "tfbackend/authz")
+func NewOPAClient(baseURL, decisionPath string, timeout time.Duration) (*OPAClient, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid OPA URL %q: must be an http(s) URL", baseURL)
+	}
+	if decisionPath == "" {
+		decisionPath = DefaultDecisionPath
+	}
+	return &OPAClient{
+		url:          strings.TrimSuffix(baseURL, "/"),
+		decisionPath: strings.Trim(strings.ReplaceAll(decisionPath, ".", "/"), "/"),
+		client:       &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// SetTransport sends OPA requests through the given transport, e.g. one
+// with the outbound proxy and CA settings
+func (c *OPAClient) SetTransport(transport http.RoundTripper) {
+	c.client.Transport = transport
+}
+
+// Evaluate queries the decision document with the request as input. The
+// document may be a boolean or an object with allow and reason fields. An
+// undefined document denies the request.
+func (c *OPAClient) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	body, err := json.Marshal(map[string]Input{"input": input})
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to encode policy input: %w", err)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/data/"+c.decisionPath, "application/json", bytes.NewReader(body), &response); err != nil {
+		return Decision{}, err
+	}
+
+	return parseDecision(response.Result)
+}
+
+// parseDecision accepts a boolean or an {allow, reason} object
+func parseDecision(result json.RawMessage) (Decision, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return Decision{Reason: "no policy decision (undefined document)"}, nil
+	}
+
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		return Decision{Allow: allow}, nil
+	}
+
+	var decision struct {
+		Allow  *bool  `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(result, &decision); err != nil {
+		return Decision{}, fmt.Errorf("unexpected policy decision %s: expected a boolean or an object with allow", result)
+	}
+	if decision.Allow == nil {
+		return Decision{Reason: "no policy decision (allow is undefined)"}, nil
+	}
+	return Decision{Allow: *decision.Allow, Reason: decision.Reason}, nil
+}
+
+// LoadPolicies pushes every .rego file in dir to OPA, replacing the versions
+// pushed before. It returns the number of policies loaded.
+func (c *OPAClient) LoadPolicies(ctx context.Context, dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rego"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list policies: %w", err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		module, err := os.ReadFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read policy: %w", err)
+		}
+
+		id := policyIDPrefix + strings.TrimSuffix(filepath.Base(path), ".rego")
+		if err := c.do(ctx, http.MethodPut, "/v1/policies/"+id, "text/plain", bytes.NewReader(module), nil); err != nil {
+			return 0, fmt.Errorf("failed to load policy %s: %w", filepath.Base(path), err)
+		}
+	}
+	return len(paths), nil
+}
+
+// do sends a request to OPA and decodes the JSON response into out
+func (c *OPAClient) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create OPA request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("OPA request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		// OPA reports compile and evaluation errors in the body
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OPA returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOPA serves decisions computed by decide and records pushed policies
+func fakeOPA(t *testing.T, decide func(Input) string) (*httptest.Server, map[string]string) {
+	t.Helper()
+	policies := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/data/tfbackend/authz":
+			var body struct {
+				Input Input `json:"input"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(decide(body.Input)))
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/policies/"):
+			module, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(module), "syntax error") {
+				http.Error(w, `{"code":"invalid_parameter","message":"error(s) occurred while compiling module(s)"}`, http.StatusBadRequest)
+				return
+			}
+			policies[strings.TrimPrefix(r.URL.Path, "/v1/policies/")] = string(module)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, policies
+}
+
+func TestOPAClientDecisions(t *testing.T) {
+	tests := []struct {
+		result string
+		allow  bool
+		reason string
+	}{
+		{`{"result":true}`, true, ""},
+		{`{"result":false}`, false, ""},
+		{`{"result":{"allow":false,"reason":"prod states cannot be deleted"}}`, false, "prod states cannot be deleted"},
+		{`{"result":{"allow":true}}`, true, ""},
+		{`{}`, false, "no policy decision (undefined document)"},
+		{`{"result":{"reason":"x"}}`, false, "no policy decision (allow is undefined)"},
+	}
+
+	for _, tc := range tests {
+		srv, _ := fakeOPA(t, func(Input) string { return tc.result })
+		client, err := NewOPAClient(srv.URL, "tfbackend.authz", time.Second)
+		if err != nil {
+			t.Fatalf("NewOPAClient failed: %v", err)
+		}
+
+		decision, err := client.Evaluate(context.Background(), Input{OrgID: "org"})
+		if err != nil {
+			t.Fatalf("%s: Evaluate failed: %v", tc.result, err)
+		}
+		if decision.Allow != tc.allow || decision.Reason != tc.reason {
+			t.Errorf("%s: unexpected decision %+v", tc.result, decision)
+		}
+	}
+
+	srv, _ := fakeOPA(t, func(Input) string { return `{"result":"yes"}` })
+	client, _ := NewOPAClient(srv.URL, "", time.Second)
+	if _, err := client.Evaluate(context.Background(), Input{}); err == nil {
+		t.Error("Expected error for a non-boolean decision")
+	}
+
+	if _, err := NewOPAClient("localhost:8181", "", time.Second); err == nil {
+		t.Error("Expected error for a URL without scheme")
+	}
+}
+
+func TestOPAClientLoadPolicies(t *testing.T) {
+	srv, policies := fakeOPA(t, func(Input) string { return `{"result":true}` })
+	client, _ := NewOPAClient(srv.URL, "", time.Second)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "prod.rego"), []byte("package tfbackend.authz\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a policy"), 0644)
+
+	loaded, err := client.LoadPolicies(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("LoadPolicies failed: %v", err)
+	}
+	if loaded != 1 || policies["tfbackend/prod"] != "package tfbackend.authz\n" {
+		t.Errorf("Unexpected loaded policies (%d): %v", loaded, policies)
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.rego"), []byte("syntax error"), 0644)
+	if _, err := client.LoadPolicies(context.Background(), dir); err == nil || !strings.Contains(err.Error(), "broken.rego") {
+		t.Errorf("Expected compile error naming the file, got %v", err)
+	}
+}
+
+// evaluatorFunc adapts a function to Evaluator
+type evaluatorFunc func(Input) (Decision, error)
+
+func (f evaluatorFunc) Evaluate(_ context.Context, input Input) (Decision, error) {
+	return f(input)
+}
+
+func TestMiddleware(t *testing.T) {
+	// Deny state deletes in prod workspaces
+	evaluator := evaluatorFunc(func(input Input) (Decision, error) {
+		if input.Resource == "state" && input.Action == "delete" && strings.HasPrefix(input.StateName, "prod") {
+			return Decision{Reason: "prod states cannot be deleted"}, nil
+		}
+		return Decision{Allow: true}, nil
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Middleware(evaluator, false)(next)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodDelete, "/api/v1/state/prod-network", http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/state/dev-network", http.StatusNoContent},
+		{http.MethodPost, "/api/v1/state/prod-network", http.StatusNoContent},
+		{http.MethodDelete, "/api/v1/state/prod-network/lock", http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/state/prod-network", nil))
+	if !strings.Contains(rec.Body.String(), "prod states cannot be deleted") {
+		t.Errorf("Expected denial reason in response, got %q", rec.Body.String())
+	}
+
+	// Unavailable policy server: fail closed by default, open on request
+	failing := evaluatorFunc(func(Input) (Decision, error) { return Decision{}, errors.New("connection refused") })
+	rec = httptest.NewRecorder()
+	Middleware(failing, false)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/data", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when failing closed, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	Middleware(failing, true)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/data", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected request to pass when failing open, got %d", rec.Code)
+	}
+}
+
+func TestNewInput(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/state/prod/lock", strings.NewReader(`{"ID":"x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "10.0.0.1:5555"
+
+	input := NewInput(r)
+	if input.Resource != "lock" || input.Action != "write" || input.StateName != "prod" {
+		t.Errorf("Unexpected classification: %+v", input)
+	}
+	if input.RemoteIP != "10.0.0.1" || input.ContentLength != 10 || input.ContentType != "application/json" {
+		t.Errorf("Unexpected request metadata: %+v", input)
+	}
+
+	for path, resource := range map[string]string{
+		"/api/v1/upload/csv": "data",
+		"/api/v1/data":       "data",
+		"/api/v1/keys/abc":   "keys",
+		"/api/v1/health":     "health",
+		"/api/v1/other":      "other",
+	} {
+		if got, _ := classifyPath(path); got != resource {
+			t.Errorf("%s: expected resource %s, got %s", path, resource, got)
+		}
+	}
+}
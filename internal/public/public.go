@@ -0,0 +1,137 @@
+// Package public lists the endpoints served without authentication. The set
+// is explicit, so the router tests can assert that every other route rejects
+// unauthenticated requests, and configurable, so deployments only expose the
+// endpoints their load balancers and scrapers need. Metrics are meant for an
+// internal listener and are only public on the API listener when listed.
+//
+// Routes that authenticate requests themselves, such as the self-test
+// (which provisions its own credentials) and signed download URLs, are
+// enabled by their own settings and are not part of this set.
+package public
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Public endpoints
+const (
+	Health  = "health"  // GET /health
+	Ready   = "ready"   // GET /ready
+	Version = "version" // GET /version
+	OpenAPI = "openapi" // GET /openapi.yaml
+	Metrics = "metrics" // GET /metrics, when metrics are enabled
+)
+
+// None is the value that hides every public endpoint
+const None = "none"
+
+// Endpoint describes a public endpoint
+type Endpoint struct {
+	Name   string
+	Method string
+	Path   string
+	OptIn  bool // Only public when listed
+}
+
+// Endpoints lists every endpoint that can be public. All of them but the
+// opt-in ones are public unless configured otherwise, so upgrades keep them.
+var Endpoints = []Endpoint{
+	{Name: Health, Method: "GET", Path: "/health"},
+	{Name: Ready, Method: "GET", Path: "/ready"},
+	{Name: Version, Method: "GET", Path: "/version"},
+	{Name: OpenAPI, Method: "GET", Path: "/openapi.yaml"},
+	{Name: Metrics, Method: "GET", Path: "/metrics", OptIn: true},
+}
+
+// Set is the endpoints served without authentication. The zero value has
+// every endpoint but the opt-in ones public.
+type Set struct {
+	enabled map[string]bool
+}
+
+// Parse parses a comma-separated list of the public endpoints; every
+// endpoint left out is not served. An empty value keeps the default
+// endpoints public and "none" hides all of them. Unknown names are an error
+// so typos are caught at startup.
+func Parse(value string) (Set, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Set{}, nil
+	}
+
+	set := Set{enabled: make(map[string]bool)}
+	if value == None {
+		return set, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		name := strings.TrimSpace(entry)
+		if name == "" {
+			continue
+		}
+		if !known(name) {
+			return Set{}, fmt.Errorf("unknown public endpoint %q (known: %s)", name, strings.Join(names(), ", "))
+		}
+		set.enabled[name] = true
+	}
+	return set, nil
+}
+
+// Enabled reports whether an endpoint is public
+func (s Set) Enabled(name string) bool {
+	if s.enabled == nil {
+		endpoint, ok := find(name)
+		return ok && !endpoint.OptIn
+	}
+	return s.enabled[name]
+}
+
+// With returns a copy of the set with the named endpoints public as well
+func (s Set) With(names ...string) Set {
+	set := Set{enabled: make(map[string]bool)}
+	for _, name := range s.List() {
+		set.enabled[name] = true
+	}
+	for _, name := range names {
+		if known(name) {
+			set.enabled[name] = true
+		}
+	}
+	return set
+}
+
+// List returns the names of the public endpoints, in the order of Endpoints
+func (s Set) List() []string {
+	list := []string{}
+	for _, endpoint := range Endpoints {
+		if s.Enabled(endpoint.Name) {
+			list = append(list, endpoint.Name)
+		}
+	}
+	return list
+}
+
+// find returns the endpoint called name
+func find(name string) (Endpoint, bool) {
+	for _, endpoint := range Endpoints {
+		if endpoint.Name == name {
+			return endpoint, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// known reports whether name is a known endpoint
+func known(name string) bool {
+	_, ok := find(name)
+	return ok
+}
+
+// names returns the names of all known endpoints
+func names() []string {
+	all := make([]string, 0, len(Endpoints))
+	for _, endpoint := range Endpoints {
+		all = append(all, endpoint.Name)
+	}
+	return all
+}
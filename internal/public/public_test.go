@@ -0,0 +1,56 @@
+package public
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZeroSetKeepsMetricsPrivate(t *testing.T) {
+	var set Set
+	if !reflect.DeepEqual(set.List(), []string{Health, Ready, Version, OpenAPI}) {
+		t.Errorf("Unexpected public endpoints %v", set.List())
+	}
+	if set.Enabled(Metrics) {
+		t.Error("Expected metrics not to be public unless listed")
+	}
+	if set.Enabled("unknown") {
+		t.Error("Expected unknown endpoints not to be public")
+	}
+
+	set = set.With(Metrics)
+	if !reflect.DeepEqual(set.List(), []string{Health, Ready, Version, OpenAPI, Metrics}) {
+		t.Errorf("Expected metrics to be added, got %v", set.List())
+	}
+}
+
+func TestParse(t *testing.T) {
+	set, err := Parse(" health , ready ")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !reflect.DeepEqual(set.List(), []string{Health, Ready}) {
+		t.Errorf("Unexpected public endpoints %v", set.List())
+	}
+	if set.Enabled(Metrics) {
+		t.Error("Expected metrics not to be public")
+	}
+
+	set, err = Parse(None)
+	if err != nil || len(set.List()) != 0 {
+		t.Errorf("Expected no public endpoints for none, got %v, %v", set.List(), err)
+	}
+
+	set, err = Parse("")
+	if err != nil || set.Enabled(Metrics) || len(set.List()) != len(Endpoints)-1 {
+		t.Errorf("Expected all endpoints but metrics for an empty list, got %v, %v", set.List(), err)
+	}
+
+	set, err = Parse("health,metrics")
+	if err != nil || !set.Enabled(Metrics) {
+		t.Errorf("Expected listed metrics to be public, got %v, %v", set.List(), err)
+	}
+
+	if _, err := Parse("health,healthz"); err == nil {
+		t.Error("Expected error for unknown endpoint")
+	}
+}
@@ -0,0 +1,326 @@
+// Package quarantine keeps upload payloads that failed validation, with the
+// reason, so operators can review them and re-ingest them after a fix
+// instead of losing the data.
+package quarantine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrFull is returned by Add when the payload would exceed Config.MaxBytes
+var ErrFull = errors.New("quarantine is full")
+
+// Config configures the quarantine
+type Config struct {
+	// Dir holds a metadata file and a payload file per entry
+	Dir string
+
+	// MaxBytes bounds the total size of quarantined payloads; further
+	// rejected payloads are discarded
+	MaxBytes int64
+
+	// Retention is how long entries are kept before they are pruned
+	// (0 = until re-ingested or discarded)
+	Retention time.Duration
+}
+
+// Entry describes a quarantined payload
+type Entry struct {
+	ID       string    `json:"id"`
+	OrgID    uuid.UUID `json:"org_id"`
+	Endpoint string    `json:"endpoint"`        // Path the payload was posted to
+	Query    string    `json:"query,omitempty"` // Raw query of the upload, e.g. dedupe=true
+
+	ContentType     string `json:"content_type,omitempty"`
+	ProviderVersion string `json:"provider_version,omitempty"`
+	SourceIP        string `json:"source_ip,omitempty"`
+
+	Reason string `json:"reason"` // Latest validation error
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// Rejections counts identical payloads rejected while the entry existed
+	Rejections     int       `json:"rejections"`
+	FirstRejection time.Time `json:"first_rejection"`
+	LastRejection  time.Time `json:"last_rejection"`
+
+	// Re-ingestion attempts that failed validation again
+	Attempts      int        `json:"attempts"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+// Store keeps quarantined payloads in a directory, with their metadata
+// indexed in memory
+type Store struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	bytes   int64
+}
+
+// Open creates the quarantine directory if needed and loads its entries,
+// pruning those past the retention
+func Open(cfg Config) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	s := &Store{
+		cfg:     cfg,
+		now:     time.Now,
+		entries: make(map[string]*Entry),
+	}
+
+	matches, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantine entries: %w", err)
+	}
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quarantine entry: %w", err)
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse quarantine entry %s: %w", match, err)
+		}
+		if _, err := uuid.Parse(entry.ID); err != nil || filepath.Base(match) != entry.ID+".json" {
+			continue
+		}
+		// An entry whose payload was lost is of no use
+		if _, err := os.Stat(s.payloadPath(entry.ID)); err != nil {
+			continue
+		}
+		s.entries[entry.ID] = &entry
+		s.bytes += int64(entry.Size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add quarantines a payload. A payload identical to one already quarantined
+// for the organization and endpoint updates that entry instead.
+func (s *Store) Add(entry Entry, payload []byte) (Entry, error) {
+	sum := sha256.Sum256(payload)
+	digest := hex.EncodeToString(sum[:])
+	now := s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.prune(); err != nil {
+		return Entry{}, err
+	}
+
+	for _, existing := range s.entries {
+		if existing.OrgID == entry.OrgID && existing.Endpoint == entry.Endpoint && existing.SHA256 == digest {
+			updated := *existing
+			updated.Reason = entry.Reason
+			updated.Rejections++
+			updated.LastRejection = now
+			if err := s.writeEntry(&updated); err != nil {
+				return Entry{}, err
+			}
+			*existing = updated
+			return updated, nil
+		}
+	}
+
+	if s.bytes+int64(len(payload)) > s.cfg.MaxBytes {
+		return Entry{}, ErrFull
+	}
+
+	entry.ID = uuid.New().String()
+	entry.Size = len(payload)
+	entry.SHA256 = digest
+	entry.Rejections = 1
+	entry.FirstRejection = now
+	entry.LastRejection = now
+	entry.Attempts = 0
+	entry.LastAttemptAt = nil
+
+	// The payload is written first so every metadata file has one
+	if err := writeFile(s.payloadPath(entry.ID), payload); err != nil {
+		return Entry{}, fmt.Errorf("failed to write quarantined payload: %w", err)
+	}
+	if err := s.writeEntry(&entry); err != nil {
+		os.Remove(s.payloadPath(entry.ID))
+		return Entry{}, err
+	}
+	s.entries[entry.ID] = &entry
+	s.bytes += int64(entry.Size)
+	return entry, nil
+}
+
+// List returns the entries of an organization, or of all organizations for
+// uuid.Nil, most recently rejected first
+func (s *Store) List(orgID uuid.UUID) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if orgID == uuid.Nil || entry.OrgID == orgID {
+			entries = append(entries, *entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if c := b.LastRejection.Compare(a.LastRejection); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return entries
+}
+
+// Get returns an entry
+func (s *Store) Get(id string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Payload returns the quarantined payload of an entry
+func (s *Store) Payload(id string) ([]byte, error) {
+	s.mu.Lock()
+	_, ok := s.entries[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("quarantine entry %s not found", id)
+	}
+
+	payload, err := os.ReadFile(s.payloadPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantined payload: %w", err)
+	}
+	return payload, nil
+}
+
+// RecordAttempt records a re-ingestion of the entry that failed again
+func (s *Store) RecordAttempt(id, reason string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.entries[id]
+	if !ok {
+		return Entry{}, fmt.Errorf("quarantine entry %s not found", id)
+	}
+	now := s.now().UTC()
+	updated := *existing
+	updated.Reason = reason
+	updated.Attempts++
+	updated.LastAttemptAt = &now
+	if err := s.writeEntry(&updated); err != nil {
+		return Entry{}, err
+	}
+	*existing = updated
+	return updated, nil
+}
+
+// Delete removes an entry after it was re-ingested or discarded. It
+// reports whether the entry existed.
+func (s *Store) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return false, nil
+	}
+	return true, s.remove(id)
+}
+
+// Stats returns the number of entries and the total size of their payloads
+func (s *Store) Stats() (entries int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.bytes
+}
+
+// prune removes entries last rejected before the retention. Must be called
+// with s.mu held.
+func (s *Store) prune() error {
+	if s.cfg.Retention <= 0 {
+		return nil
+	}
+	cutoff := s.now().Add(-s.cfg.Retention)
+	for id, entry := range s.entries {
+		if entry.LastRejection.Before(cutoff) {
+			if err := s.remove(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes an entry's files and index entry. Must be called with
+// s.mu held.
+func (s *Store) remove(id string) error {
+	// The metadata goes first so a partial removal leaves no entry behind
+	if err := os.Remove(s.entryPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove quarantine entry: %w", err)
+	}
+	if err := os.Remove(s.payloadPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove quarantined payload: %w", err)
+	}
+	s.bytes -= int64(s.entries[id].Size)
+	delete(s.entries, id)
+	return nil
+}
+
+// writeEntry persists an entry's metadata
+func (s *Store) writeEntry(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine entry: %w", err)
+	}
+	if err := writeFile(s.entryPath(entry.ID), data); err != nil {
+		return fmt.Errorf("failed to write quarantine entry: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) entryPath(id string) string {
+	return filepath.Join(s.cfg.Dir, id+".json")
+}
+
+func (s *Store) payloadPath(id string) string {
+	return filepath.Join(s.cfg.Dir, id+".payload")
+}
+
+// writeFile atomically replaces a file readable only by the service
+func writeFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package quarantine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(Config{Dir: dir, MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	entry, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload", Reason: "Invalid provider"}, []byte(`{"provider":""}`))
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := store.RecordAttempt(entry.ID, "Invalid provider again"); err != nil {
+		t.Fatalf("RecordAttempt failed: %v", err)
+	}
+
+	// A payload without metadata, e.g. from a crash during Add, is ignored
+	if err := os.WriteFile(filepath.Join(dir, uuid.New().String()+".payload"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(Config{Dir: dir, MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	loaded, ok := reopened.Get(entry.ID)
+	if !ok || loaded.OrgID != orgID || loaded.Attempts != 1 || loaded.Reason != "Invalid provider again" {
+		t.Fatalf("Unexpected entry after restart %+v", loaded)
+	}
+	if payload, err := reopened.Payload(entry.ID); err != nil || string(payload) != `{"provider":""}` {
+		t.Errorf("Expected the payload after restart, got %q (err=%v)", payload, err)
+	}
+	if entries, bytes := reopened.Stats(); entries != 1 || bytes != 15 {
+		t.Errorf("Expected 1 entry of 15 bytes, got %d of %d", entries, bytes)
+	}
+	if len(reopened.List(uuid.New())) != 0 || len(reopened.List(uuid.Nil)) != 1 {
+		t.Error("Expected List to filter by organization")
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	store, err := Open(Config{Dir: t.TempDir(), MaxBytes: 10, Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+
+	orgID := uuid.New()
+	first, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload"}, []byte("12345678"))
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload"}, []byte("abc")); !errors.Is(err, ErrFull) {
+		t.Errorf("Expected ErrFull beyond MaxBytes, got %v", err)
+	}
+
+	// Identical payloads do not take more space
+	clock = clock.Add(50 * time.Minute)
+	again, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload", Reason: "still invalid"}, []byte("12345678"))
+	if err != nil || again.ID != first.ID || again.Rejections != 2 {
+		t.Errorf("Expected the entry to be reused, got %+v (err=%v)", again, err)
+	}
+
+	// Entries expire an hour after their last rejection
+	clock = clock.Add(30 * time.Minute)
+	if _, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload"}, []byte("abc")); !errors.Is(err, ErrFull) {
+		t.Errorf("Expected the entry to be kept within the retention, got %v", err)
+	}
+	clock = clock.Add(time.Hour)
+	if _, err := store.Add(Entry{OrgID: orgID, Endpoint: "/api/v1/upload"}, []byte("abc")); err != nil {
+		t.Errorf("Expected the expired entry to be pruned, got %v", err)
+	}
+	if _, ok := store.Get(first.ID); ok {
+		t.Error("Expected the expired entry to be gone")
+	}
+}
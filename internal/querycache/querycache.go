@@ -0,0 +1,269 @@
+// Package querycache adds ETags to data query responses, so repeated
+// identical dashboard queries are answered with 304 Not Modified, and
+// optionally caches the responses per organization until its data changes.
+package querycache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// CacheHeader reports whether a response was served from the cache (HIT) or
+// computed and cached (MISS); it is omitted when the cache is disabled
+const CacheHeader = "X-Cache"
+
+// Config configures the response cache
+type Config struct {
+	// MaxBytes bounds the cached response bodies; the least recently used
+	// responses are evicted beyond it. Responses larger than an eighth of it
+	// are not cached.
+	MaxBytes int64
+
+	// TTL expires cached responses even if the data did not change through
+	// this server, e.g. when several servers share storage (0 = never)
+	TTL time.Duration
+}
+
+// entry is a cached response
+type entry struct {
+	key    string
+	orgID  uuid.UUID
+	header http.Header
+	body   []byte
+	etag   string
+	stored time.Time
+}
+
+// Cache holds successful query responses per organization. An organization's
+// responses are dropped when Invalidate reports a change to its data.
+type Cache struct {
+	cfg Config
+	now func() time.Time
+
+	mu          sync.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List // most recently used first
+	bytes       int64
+	generations map[uuid.UUID]uint64 // bumped on every invalidation
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// New creates an empty cache
+func New(cfg Config) *Cache {
+	return &Cache{
+		cfg:         cfg,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		generations: make(map[uuid.UUID]uint64),
+	}
+}
+
+// Invalidate drops the cached responses of the organization. Responses being
+// computed while it is called are not cached.
+func (c *Cache) Invalidate(orgID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[orgID]++
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*entry); e.orgID == orgID {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// Stats returns the number and size of cached responses, and the hits and
+// misses since the cache was created
+func (c *Cache) Stats() (entries int, bytes int64, hits, misses int64) {
+	c.mu.Lock()
+	entries, bytes = len(c.entries), c.bytes
+	c.mu.Unlock()
+	return entries, bytes, c.hits.Load(), c.misses.Load()
+}
+
+// get returns the unexpired cached response for key
+func (c *Cache) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if c.cfg.TTL > 0 && c.now().Sub(e.stored) > c.cfg.TTL {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e, true
+}
+
+// generation returns the organization's current generation
+func (c *Cache) generation(orgID uuid.UUID) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[orgID]
+}
+
+// put caches a response computed at the given generation, unless the
+// organization's data changed since
+func (c *Cache) put(e *entry, generation uint64) {
+	size := int64(len(e.body))
+	if size > c.cfg.MaxBytes/8 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[e.orgID] != generation {
+		return
+	}
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	for c.bytes+size > c.cfg.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	e.stored = c.now()
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += size
+}
+
+// remove drops a cached response. Must be called with c.mu held.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.body))
+}
+
+// responseBuffer captures a response so its ETag can be computed before it
+// is sent
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// ETag returns middleware that sets a strong ETag on successful GET
+// responses and answers requests whose If-None-Match matches it with 304
+// Not Modified. With a cache, responses are also served from and stored in
+// it, per organization and normalized URL. It must run after authentication
+// and authorization, and only on routes whose response depends on nothing
+// but the organization and the URL.
+func ETag(cache *Cache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			orgID, cacheable := reqctx.OrgID(r.Context())
+			cacheable = cacheable && cache != nil
+			key := orgID.String() + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+
+			var generation uint64
+			if cacheable {
+				if e, ok := cache.get(key); ok {
+					cache.hits.Add(1)
+					writeEntry(w, r, e, "HIT")
+					return
+				}
+				cache.misses.Add(1)
+				generation = cache.generation(orgID)
+			}
+
+			buf := &responseBuffer{header: make(http.Header)}
+			next.ServeHTTP(buf, r)
+
+			if buf.status != http.StatusOK {
+				for k, v := range buf.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(buf.status)
+				w.Write(buf.body.Bytes())
+				return
+			}
+
+			sum := sha256.Sum256(buf.body.Bytes())
+			e := &entry{
+				key:    key,
+				orgID:  orgID,
+				header: buf.header,
+				body:   buf.body.Bytes(),
+				etag:   `"` + hex.EncodeToString(sum[:16]) + `"`,
+			}
+			status := ""
+			if cacheable {
+				cache.put(e, generation)
+				status = "MISS"
+			}
+			writeEntry(w, r, e, status)
+		})
+	}
+}
+
+// writeEntry sends a response with its ETag, or 304 Not Modified if the
+// client has it
+func writeEntry(w http.ResponseWriter, r *http.Request, e *entry, cacheStatus string) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("ETag", e.etag)
+	// Clients may keep the response but must revalidate it before use
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if cacheStatus != "" {
+		w.Header().Set(CacheHeader, cacheStatus)
+	}
+
+	if matchesETag(r.Header.Get("If-None-Match"), e.etag) {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+}
+
+// matchesETag reports whether an If-None-Match header matches the ETag,
+// using the weak comparison RFC 9110 requires for If-None-Match
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package querycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+func TestETagWithoutCache(t *testing.T) {
+	calls := 0
+	handler := ETag(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":1}`))
+	}))
+
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req = req.WithContext(reqctx.WithOrgID(req.Context(), uuid.New()))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"count":1}` || etag == "" || rec.Header().Get(CacheHeader) != "" {
+		t.Fatalf("Unexpected response %d %v: %s", rec.Code, rec.Header(), rec.Body)
+	}
+	if rec := serve(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("Expected an empty 304, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := serve("*"); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected * to match, got %d", rec.Code)
+	}
+	if rec := serve(`"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("Expected a mismatched ETag to get the body, got %d", rec.Code)
+	}
+	if calls != 4 {
+		t.Errorf("Expected every request to reach the handler without a cache, got %d", calls)
+	}
+}
+
+func TestCacheInvalidationAndEviction(t *testing.T) {
+	cache := New(Config{MaxBytes: 80, TTL: time.Minute})
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return clock }
+
+	var during func()
+	handler := ETag(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if during != nil {
+			during()
+		}
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+
+	orgID, otherOrgID := uuid.New(), uuid.New()
+	serve := func(orgID uuid.UUID, target string) string {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(reqctx.WithOrgID(req.Context(), orgID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get(CacheHeader)
+	}
+
+	// Query parameters are normalized and organizations do not share entries
+	if serve(orgID, "/data?b=2&a=1") != "MISS" || serve(orgID, "/data?a=1&b=2") != "HIT" || serve(otherOrgID, "/data?a=1&b=2") != "MISS" {
+		t.Error("Expected normalized per-org cache keys")
+	}
+	if serve(orgID, "/data?fail=1") != "" || serve(orgID, "/data?fail=1") != "" {
+		t.Error("Expected errors not to be cached")
+	}
+
+	// Invalidation only drops the organization's entries
+	cache.Invalidate(orgID)
+	if serve(orgID, "/data?a=1&b=2") != "MISS" || serve(otherOrgID, "/data?a=1&b=2") != "HIT" {
+		t.Error("Expected invalidation to drop only the organization's entries")
+	}
+
+	// A response computed while the data changes is not cached
+	during = func() { cache.Invalidate(orgID) }
+	serve(orgID, "/data/summary")
+	during = nil
+	if serve(orgID, "/data/summary") != "MISS" {
+		t.Error("Expected a response computed during an invalidation not to be cached")
+	}
+
+	// Entries expire after the TTL
+	clock = clock.Add(2 * time.Minute)
+	if serve(orgID, "/data/summary") != "MISS" {
+		t.Error("Expected an expired entry to be recomputed")
+	}
+
+	// The least recently used entries are evicted beyond MaxBytes
+	for i := 0; i < 10; i++ {
+		serve(orgID, "/data?page="+string(rune('a'+i)))
+	}
+	entries, bytes, hits, _ := cache.Stats()
+	if entries != 8 || bytes != 80 {
+		t.Errorf("Expected 8 entries of 80 bytes, got %d of %d", entries, bytes)
+	}
+	if serve(orgID, "/data?page=a") != "MISS" || serve(orgID, "/data?page=j") != "HIT" {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if hits != 2 {
+		t.Errorf("Expected 2 hits, got %d", hits)
+	}
+}
+
+func TestCacheSkipsLargeResponses(t *testing.T) {
+	cache := New(Config{MaxBytes: 64})
+	handler := ETag(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 9)))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	req = req.WithContext(reqctx.WithOrgID(req.Context(), uuid.New()))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if entries, _, _, _ := cache.Stats(); entries != 0 {
+		t.Errorf("Expected a response over an eighth of MaxBytes not to be cached, got %d entries", entries)
+	}
+}
@@ -0,0 +1,253 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ResetHeader carries the time a daily ingest cap resets on rejected uploads
+const ResetHeader = "X-Quota-Reset"
+
+// Daily ingest quota names used in errors
+const (
+	QuotaIngestBytes = "ingest_bytes"
+	QuotaIngestRows  = "ingest_rows"
+)
+
+// IngestConfig configures the daily ingest caps per organization
+type IngestConfig struct {
+	// MaxBytes caps the upload body bytes an org can store per UTC day (0 = no cap)
+	MaxBytes int64
+
+	// MaxRows caps the rows an org can store per UTC day (0 = no cap)
+	MaxRows int64
+}
+
+// IngestUsage is what an organization uploaded on the current day
+type IngestUsage struct {
+	Bytes int64 `json:"bytes"`
+	Rows  int64 `json:"rows"`
+}
+
+// CapExceededError rejects an upload that would take an organization past
+// a daily ingest cap
+type CapExceededError struct {
+	Quota   string
+	Used    int64
+	Limit   int64
+	ResetAt time.Time
+}
+
+// Error implements the error interface
+func (e *CapExceededError) Error() string {
+	return fmt.Sprintf("Daily %s quota exceeded (used %d of %d); it resets at %s",
+		e.Quota, e.Used, e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+// ingestFile is the persisted form of the counters
+type ingestFile struct {
+	Day  string                     `json:"day"`
+	Orgs map[uuid.UUID]*IngestUsage `json:"orgs"`
+}
+
+// IngestCaps enforces daily caps on the bytes and rows each organization
+// uploads, so a provider stuck in a loop cannot grow storage without
+// bound. Counters reset at midnight UTC and are persisted to a JSON file,
+// so restarts do not hand out a fresh budget.
+type IngestCaps struct {
+	config   IngestConfig
+	filePath string
+
+	mu       sync.Mutex
+	day      string
+	orgs     map[uuid.UUID]*IngestUsage
+	rejected map[uuid.UUID]bool // Orgs already logged as capped today
+	dirty    bool
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// NewIngestCaps creates daily ingest caps whose counters are persisted to
+// filePath every flushInterval (0 = only on Close). Counters of the
+// current day are loaded from the file if it exists.
+func NewIngestCaps(config IngestConfig, filePath string, flushInterval time.Duration) (*IngestCaps, error) {
+	c := &IngestCaps{
+		config:   config,
+		filePath: filePath,
+		orgs:     make(map[uuid.UUID]*IngestUsage),
+		rejected: make(map[uuid.UUID]bool),
+		stopChan: make(chan struct{}),
+		now:      time.Now,
+	}
+	c.day = c.today()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	if flushInterval > 0 {
+		c.wg.Add(1)
+		go c.flushRoutine(flushInterval)
+	}
+
+	return c, nil
+}
+
+// today returns the current UTC day
+func (c *IngestCaps) today() string {
+	return c.now().UTC().Format("2006-01-02")
+}
+
+// load reads the persisted counters; counters of a past day are dropped
+func (c *IngestCaps) load() error {
+	data, err := os.ReadFile(c.filePath)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ingest counters: %w", err)
+	}
+
+	var persisted ingestFile
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return fmt.Errorf("failed to parse ingest counters %s: %w", c.filePath, err)
+	}
+	if persisted.Day == c.day && persisted.Orgs != nil {
+		c.orgs = persisted.Orgs
+	}
+	return nil
+}
+
+// rollover starts new counters when the UTC day has changed. The caller
+// must hold mu.
+func (c *IngestCaps) rollover() {
+	if day := c.today(); day != c.day {
+		c.day = day
+		c.orgs = make(map[uuid.UUID]*IngestUsage)
+		c.rejected = make(map[uuid.UUID]bool)
+		c.dirty = true
+	}
+}
+
+// ResetAt returns when the current day's counters reset
+func (c *IngestCaps) ResetAt() time.Time {
+	now := c.now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Admit counts an upload of the given size against the organization's
+// daily caps. An upload that would exceed a cap is not counted and gets a
+// *CapExceededError.
+func (c *IngestCaps) Admit(orgID uuid.UUID, bytes, rows int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	usage := c.orgs[orgID]
+	if usage == nil {
+		usage = &IngestUsage{}
+	}
+
+	var exceeded *CapExceededError
+	switch {
+	case c.config.MaxBytes > 0 && usage.Bytes+bytes > c.config.MaxBytes:
+		exceeded = &CapExceededError{Quota: QuotaIngestBytes, Used: usage.Bytes, Limit: c.config.MaxBytes}
+	case c.config.MaxRows > 0 && usage.Rows+rows > c.config.MaxRows:
+		exceeded = &CapExceededError{Quota: QuotaIngestRows, Used: usage.Rows, Limit: c.config.MaxRows}
+	}
+	if exceeded != nil {
+		exceeded.ResetAt = c.ResetAt()
+		// Runaway clients retry in a loop, so only the first rejection is logged
+		if !c.rejected[orgID] {
+			c.rejected[orgID] = true
+			slog.Warn("Daily ingest cap reached", "quota", exceeded.Quota, "org_id", orgID, "used", exceeded.Used, "limit", exceeded.Limit)
+		}
+		return exceeded
+	}
+
+	usage.Bytes += bytes
+	usage.Rows += rows
+	c.orgs[orgID] = usage
+	c.dirty = true
+	return nil
+}
+
+// Usage returns what the organization uploaded today
+func (c *IngestCaps) Usage(orgID uuid.UUID) IngestUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	if usage := c.orgs[orgID]; usage != nil {
+		return *usage
+	}
+	return IngestUsage{}
+}
+
+// flushRoutine periodically persists the counters until Close
+func (c *IngestCaps) flushRoutine(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				slog.Error("Failed to persist ingest counters", "error", err)
+			}
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// Flush writes the counters to disk if they changed since the last flush.
+// The file is replaced atomically so a crash never leaves a partial file.
+func (c *IngestCaps) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ingestFile{Day: c.day, Orgs: c.orgs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ingest counters: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create ingest counters directory: %w", err)
+	}
+
+	tmpPath := c.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write ingest counters: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.filePath); err != nil {
+		return fmt.Errorf("failed to replace ingest counters: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Close stops the background flush routine and persists pending counters
+func (c *IngestCaps) Close() error {
+	close(c.stopChan)
+	c.wg.Wait()
+	return c.Flush()
+}
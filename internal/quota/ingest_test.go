@@ -0,0 +1,105 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIngestCapsAdmit(t *testing.T) {
+	caps, err := NewIngestCaps(IngestConfig{MaxBytes: 1000, MaxRows: 10}, filepath.Join(t.TempDir(), "ingest.json"), 0)
+	if err != nil {
+		t.Fatalf("NewIngestCaps failed: %v", err)
+	}
+	now := time.Date(2025, 5, 1, 22, 30, 0, 0, time.UTC)
+	caps.now = func() time.Time { return now }
+	caps.day = caps.today()
+
+	orgID := uuid.New()
+	if err := caps.Admit(orgID, 600, 5); err != nil {
+		t.Fatalf("Expected first upload to be admitted: %v", err)
+	}
+
+	err = caps.Admit(orgID, 600, 1)
+	var capErr *CapExceededError
+	if !errors.As(err, &capErr) || capErr.Quota != QuotaIngestBytes || capErr.Used != 600 || capErr.Limit != 1000 {
+		t.Fatalf("Expected byte cap error, got %v", err)
+	}
+	if !capErr.ResetAt.Equal(time.Date(2025, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected reset time %v", capErr.ResetAt)
+	}
+
+	err = caps.Admit(orgID, 10, 6)
+	if !errors.As(err, &capErr) || capErr.Quota != QuotaIngestRows {
+		t.Fatalf("Expected row cap error, got %v", err)
+	}
+
+	// Rejected uploads are not counted, and other orgs have their own budget
+	if usage := caps.Usage(orgID); usage != (IngestUsage{Bytes: 600, Rows: 5}) {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+	if err := caps.Admit(uuid.New(), 1000, 10); err != nil {
+		t.Errorf("Expected another org to be admitted: %v", err)
+	}
+
+	// Counters reset at midnight UTC
+	now = now.Add(2 * time.Hour)
+	if err := caps.Admit(orgID, 1000, 10); err != nil {
+		t.Errorf("Expected upload to be admitted on the next day: %v", err)
+	}
+}
+
+func TestIngestCapsPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "ingest.json")
+	orgID := uuid.New()
+
+	caps, err := NewIngestCaps(IngestConfig{MaxRows: 10}, path, 0)
+	if err != nil {
+		t.Fatalf("NewIngestCaps failed: %v", err)
+	}
+	if err := caps.Admit(orgID, 100, 8); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if err := caps.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	restarted, err := NewIngestCaps(IngestConfig{MaxRows: 10}, path, 0)
+	if err != nil {
+		t.Fatalf("NewIngestCaps after restart failed: %v", err)
+	}
+	defer restarted.Close()
+	if usage := restarted.Usage(orgID); usage.Rows != 8 || usage.Bytes != 100 {
+		t.Errorf("Expected counters to survive a restart, got %+v", usage)
+	}
+	if err := restarted.Admit(orgID, 0, 3); err == nil {
+		t.Error("Expected persisted counters to count against the cap")
+	}
+}
+
+func TestIngestCapsDropsPastDay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.json")
+	orgID := uuid.New()
+
+	caps, err := NewIngestCaps(IngestConfig{MaxRows: 10}, path, 0)
+	if err != nil {
+		t.Fatalf("NewIngestCaps failed: %v", err)
+	}
+	caps.Admit(orgID, 100, 8)
+	caps.day = "2000-01-01"
+	if err := caps.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	restarted, err := NewIngestCaps(IngestConfig{MaxRows: 10}, path, 0)
+	if err != nil {
+		t.Fatalf("NewIngestCaps failed: %v", err)
+	}
+	defer restarted.Close()
+	if usage := restarted.Usage(orgID); usage.Rows != 0 {
+		t.Errorf("Expected counters of a past day to be dropped, got %+v", usage)
+	}
+}
@@ -0,0 +1,196 @@
+// Package quota warns organizations that are approaching their storage or
+// rate quota before hard failures start.
+package quota
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/notify"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// WarningHeader is added to responses once per quota that is close to its limit
+const WarningHeader = "X-Quota-Warning"
+
+// Quota names used in warnings and events
+const (
+	QuotaStorage = "storage"
+	QuotaRate    = "rate"
+)
+
+// sizeCacheTTL bounds how often an org's stored size is recomputed
+const sizeCacheTTL = 30 * time.Second
+
+// Config configures the soft quota thresholds
+type Config struct {
+	// StorageSoftLimit is the storage quota per org in bytes (0 disables storage warnings)
+	StorageSoftLimit int64
+
+	// WarnRatio is the fraction of a quota at which warnings start (default 0.8)
+	WarnRatio float64
+
+	// NotifyCooldown is the minimum time between events for the same org and quota
+	NotifyCooldown time.Duration
+}
+
+// Warning describes a quota that is close to its limit
+type Warning struct {
+	Quota string
+	Used  int64
+	Limit int64
+}
+
+// Percent returns the used share of the quota in percent
+func (w Warning) Percent() int {
+	if w.Limit <= 0 {
+		return 0
+	}
+	return int(w.Used * 100 / w.Limit)
+}
+
+// Header formats the warning as an X-Quota-Warning header value
+func (w Warning) Header() string {
+	return fmt.Sprintf("%s; used=%d; limit=%d; percent=%d", w.Quota, w.Used, w.Limit, w.Percent())
+}
+
+// cachedSize is a recently computed stored size
+type cachedSize struct {
+	size     int64
+	computed time.Time
+}
+
+// Checker computes quota warnings per organization
+type Checker struct {
+	config   Config
+	limiter  *custommw.PerOrgRateLimiter
+	sizer    storage.SizeReporter
+	notifier notify.Notifier
+
+	mu           sync.Mutex
+	sizes        map[uuid.UUID]cachedSize
+	lastNotified map[string]time.Time
+}
+
+// NewChecker creates a quota checker. limiter, sizer and notifier are
+// optional; the corresponding warnings or events are disabled when nil.
+func NewChecker(config Config, limiter *custommw.PerOrgRateLimiter, sizer storage.SizeReporter, notifier notify.Notifier) *Checker {
+	if config.WarnRatio <= 0 || config.WarnRatio >= 1 {
+		config.WarnRatio = 0.8
+	}
+	if config.NotifyCooldown <= 0 {
+		config.NotifyCooldown = time.Hour
+	}
+
+	return &Checker{
+		config:       config,
+		limiter:      limiter,
+		sizer:        sizer,
+		notifier:     notifier,
+		sizes:        make(map[uuid.UUID]cachedSize),
+		lastNotified: make(map[string]time.Time),
+	}
+}
+
+// Warnings returns the quotas the organization is close to exceeding
+func (c *Checker) Warnings(orgID uuid.UUID) []Warning {
+	var warnings []Warning
+
+	if c.sizer != nil && c.config.StorageSoftLimit > 0 {
+		size, err := c.orgDataSize(orgID)
+		if err != nil {
+			slog.Error("Failed to compute stored size", "org_id", orgID, "error", err)
+		} else if float64(size) >= float64(c.config.StorageSoftLimit)*c.config.WarnRatio {
+			warnings = append(warnings, Warning{Quota: QuotaStorage, Used: size, Limit: c.config.StorageSoftLimit})
+		}
+	}
+
+	if c.limiter != nil {
+		remaining, limit := c.limiter.Remaining(orgID)
+		used := limit - remaining
+		if limit > 0 && used >= limit*c.config.WarnRatio {
+			warnings = append(warnings, Warning{Quota: QuotaRate, Used: int64(used), Limit: int64(limit)})
+		}
+	}
+
+	return warnings
+}
+
+// orgDataSize returns the org's stored size, cached for a short time
+func (c *Checker) orgDataSize(orgID uuid.UUID) (int64, error) {
+	c.mu.Lock()
+	cached, ok := c.sizes[orgID]
+	c.mu.Unlock()
+	if ok && time.Since(cached.computed) < sizeCacheTTL {
+		return cached.size, nil
+	}
+
+	size, err := c.sizer.OrgDataSize(orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.sizes[orgID] = cachedSize{size: size, computed: time.Now()}
+	c.mu.Unlock()
+
+	return size, nil
+}
+
+// notify emits an event unless one was sent recently for the same org and quota
+func (c *Checker) notify(orgID uuid.UUID, warning Warning) {
+	if c.notifier == nil {
+		return
+	}
+
+	key := orgID.String() + "/" + warning.Quota
+	now := time.Now()
+
+	c.mu.Lock()
+	if last, ok := c.lastNotified[key]; ok && now.Sub(last) < c.config.NotifyCooldown {
+		c.mu.Unlock()
+		return
+	}
+	c.lastNotified[key] = now
+	c.mu.Unlock()
+
+	slog.Warn("Organization close to quota", "org_id", orgID, "quota", warning.Quota, "percent", warning.Percent(),
+		"used", warning.Used, "limit", warning.Limit)
+
+	c.notifier.Notify(notify.Event{
+		Type:     notify.EventQuotaWarning,
+		Severity: notify.SeverityWarning,
+		Subject:  fmt.Sprintf("Org %s at %d%% of %s quota", orgID, warning.Percent(), warning.Quota),
+		Message:  fmt.Sprintf("Organization %s has used %d of its %d %s quota.", orgID, warning.Used, warning.Limit, warning.Quota),
+		OrgID:    orgID.String(),
+		Fields: map[string]interface{}{
+			"quota":   warning.Quota,
+			"used":    warning.Used,
+			"limit":   warning.Limit,
+			"percent": warning.Percent(),
+		},
+		Time: now.UTC(),
+	})
+}
+
+// Middleware adds X-Quota-Warning headers for quotas that are close to their
+// limit and emits a notification event. It must run after authentication.
+func (c *Checker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, ok := auth.GetOrgIDFromContext(r.Context())
+		if ok {
+			for _, warning := range c.Warnings(orgID) {
+				w.Header().Add(WarningHeader, warning.Header())
+				c.notify(orgID, warning)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
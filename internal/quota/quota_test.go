@@ -0,0 +1,87 @@
+package quota
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/notify"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(event notify.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+}
+
+func TestCheckerStorageWarning(t *testing.T) {
+	orgID := uuid.New()
+	store := storage.NewMemoryDataStorage()
+	if err := store.AppendData(orgID, map[string]interface{}{"name": "web-1", "size": "large"}); err != nil {
+		t.Fatalf("Failed to append data: %v", err)
+	}
+	size, _ := store.OrgDataSize(orgID)
+
+	notifier := &recordingNotifier{}
+	checker := NewChecker(Config{StorageSoftLimit: size + 1}, nil, store, notifier)
+
+	credentials := auth.NewInMemoryStore()
+	credentials.AddCredentials(orgID, "test-key")
+	h := auth.Middleware(credentials)(checker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/data", nil)
+		req.Header.Set("X-Org-ID", orgID.String())
+		req.Header.Set("X-API-Key", "test-key")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		warning := rec.Header().Get(WarningHeader)
+		if warning == "" || warning[:len(QuotaStorage)] != QuotaStorage {
+			t.Fatalf("Expected storage quota warning header, got %q", warning)
+		}
+	}
+
+	// Events are rate limited per org and quota
+	if len(notifier.events) != 1 {
+		t.Fatalf("Expected 1 notification event, got %d", len(notifier.events))
+	}
+	if event := notifier.events[0]; event.Type != notify.EventQuotaWarning || event.OrgID != orgID.String() || event.Fields["used"] != size {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestCheckerRateWarning(t *testing.T) {
+	orgID := uuid.New()
+	limiter := custommw.NewPerOrgRateLimiter(10)
+	defer limiter.Stop()
+
+	checker := NewChecker(Config{}, limiter, nil, nil)
+	if warnings := checker.Warnings(orgID); len(warnings) != 0 {
+		t.Fatalf("Expected no warnings for idle org, got %v", warnings)
+	}
+
+	for i := 0; i < 9; i++ {
+		limiter.Allow(orgID)
+	}
+
+	warnings := checker.Warnings(orgID)
+	if len(warnings) != 1 || warnings[0].Quota != QuotaRate {
+		t.Fatalf("Expected rate quota warning, got %v", warnings)
+	}
+	if warnings[0].Limit != 10 || warnings[0].Percent() < 80 {
+		t.Errorf("Unexpected rate warning: %+v", warnings[0])
+	}
+}
@@ -0,0 +1,207 @@
+// Package ratelimit holds per-organization overrides of the request rate
+// limit, so migrations and bulk backfills can run without changing the
+// global limit.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxMultiplier bounds how far an override can raise an organization's limit
+const MaxMultiplier = 1000
+
+// Override changes the rate limit of an organization
+type Override struct {
+	// Exempt lifts the rate limit entirely
+	Exempt bool `json:"exempt,omitempty"`
+
+	// Multiplier scales the organization's limit, e.g. 10 for a backfill
+	// or 0.5 to throttle a misbehaving client
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// Until makes the override temporary; the global limit applies again
+	// afterwards
+	Until *time.Time `json:"until,omitempty"`
+
+	// Reason documents why the override exists
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate checks that the override sets exactly one of Exempt and
+// Multiplier, within bounds
+func (o Override) Validate() error {
+	switch {
+	case o.Exempt && o.Multiplier != 0:
+		return fmt.Errorf("set either exempt or multiplier, not both")
+	case !o.Exempt && o.Multiplier == 0:
+		return fmt.Errorf("exempt or multiplier is required")
+	case o.Multiplier < 0 || o.Multiplier > MaxMultiplier:
+		return fmt.Errorf("multiplier must be between 0 and %d", MaxMultiplier)
+	}
+	return nil
+}
+
+// Active reports whether the override applies at the given time
+func (o Override) Active(now time.Time) bool {
+	return o.Until == nil || now.Before(*o.Until)
+}
+
+// Store keeps the overrides of all organizations and persists them to a
+// JSON file on every change
+type Store struct {
+	mu        sync.RWMutex
+	overrides map[uuid.UUID]Override
+	filePath  string
+}
+
+// NewStore creates an override store backed by the given file. Existing
+// overrides are loaded from the file if it exists, so operators can also
+// manage them in the file before startup. An empty path keeps the
+// overrides in memory only.
+func NewStore(filePath string) (*Store, error) {
+	s := &Store{
+		overrides: make(map[uuid.UUID]Override),
+		filePath:  filePath,
+	}
+
+	if filePath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit overrides file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(data, &s.overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit overrides file %s: %w", filePath, err)
+	}
+	for orgID, override := range s.overrides {
+		if err := override.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit override for org %s: %w", orgID, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Get returns the override of an organization, including an expired one
+func (s *Store) Get(orgID uuid.UUID) (Override, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, exists := s.overrides[orgID]
+	return o, exists
+}
+
+// All returns the overrides of all organizations, including expired ones
+func (s *Store) All() map[uuid.UUID]Override {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make(map[uuid.UUID]Override, len(s.overrides))
+	for orgID, o := range s.overrides {
+		all[orgID] = o
+	}
+	return all
+}
+
+// Set replaces the override of an organization
+func (s *Store) Set(orgID uuid.UUID, o Override) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.overrides[orgID]
+	s.overrides[orgID] = o
+	if err := s.save(); err != nil {
+		if existed {
+			s.overrides[orgID] = previous
+		} else {
+			delete(s.overrides, orgID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the override of an organization, restoring the global
+// limit. It reports whether an override existed.
+func (s *Store) Delete(orgID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.overrides[orgID]
+	if !existed {
+		return false, nil
+	}
+
+	delete(s.overrides, orgID)
+	if err := s.save(); err != nil {
+		s.overrides[orgID] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// Lookup returns the factor applied to the organization's limit at the
+// given time, and whether the organization is exempt. Organizations
+// without an active override get a factor of 1.
+func (s *Store) Lookup(orgID uuid.UUID, now time.Time) (multiplier float64, exempt bool) {
+	s.mu.RLock()
+	o, exists := s.overrides[orgID]
+	s.mu.RUnlock()
+
+	if !exists || !o.Active(now) {
+		return 1, false
+	}
+	if o.Exempt {
+		return 1, true
+	}
+	return o.Multiplier, false
+}
+
+// save writes all overrides to disk. The file is replaced atomically so a
+// crash never leaves a partial file. The caller must hold the write lock.
+func (s *Store) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate limit overrides: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create rate limit overrides directory: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rate limit overrides file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to replace rate limit overrides file: %w", err)
+	}
+
+	return nil
+}
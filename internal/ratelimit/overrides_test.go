@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStorePersistsOverrides(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "overrides.json")
+	store, err := NewStore(filePath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	orgID, exemptOrgID := uuid.New(), uuid.New()
+	until := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.Set(orgID, Override{Multiplier: 10, Until: &until, Reason: "backfill"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set(exemptOrgID, Override{Exempt: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set(uuid.New(), Override{}); err == nil {
+		t.Error("Expected an override without exempt or multiplier to be rejected")
+	}
+
+	reloaded, err := NewStore(filePath)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if o, ok := reloaded.Get(orgID); !ok || o.Multiplier != 10 || o.Reason != "backfill" {
+		t.Errorf("Unexpected override after reload %+v", o)
+	}
+
+	before := until.Add(-time.Minute)
+	if multiplier, exempt := reloaded.Lookup(orgID, before); multiplier != 10 || exempt {
+		t.Errorf("Expected a multiplier of 10 before expiry, got %v (exempt %v)", multiplier, exempt)
+	}
+	if multiplier, exempt := reloaded.Lookup(orgID, until); multiplier != 1 || exempt {
+		t.Errorf("Expected the global limit after expiry, got %v (exempt %v)", multiplier, exempt)
+	}
+	if _, exempt := reloaded.Lookup(exemptOrgID, before); !exempt {
+		t.Error("Expected the org to be exempt")
+	}
+	if multiplier, exempt := reloaded.Lookup(uuid.New(), before); multiplier != 1 || exempt {
+		t.Errorf("Expected the global limit without an override, got %v (exempt %v)", multiplier, exempt)
+	}
+
+	if existed, err := reloaded.Delete(orgID); !existed || err != nil {
+		t.Errorf("Expected the override to be deleted, got %v (err=%v)", existed, err)
+	}
+	if len(reloaded.All()) != 1 {
+		t.Errorf("Expected 1 override left, got %v", reloaded.All())
+	}
+}
+
+func TestStoreRejectsInvalidFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "overrides.json")
+	if err := os.WriteFile(filePath, []byte(`{"`+uuid.New().String()+`":{"multiplier":5000}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(filePath); err == nil {
+		t.Error("Expected a multiplier above the maximum to be rejected")
+	}
+}
@@ -0,0 +1,275 @@
+// Package recorder keeps sanitized request/response pairs of selected
+// organizations in a ring buffer, so provider-reported issues can be
+// reproduced without packet captures.
+package recorder
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// Redacted replaces the values of sensitive headers
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaderParts marks headers whose values are redacted when their
+// lower-cased name contains one of them
+var sensitiveHeaderParts = []string{"auth", "cookie", "key", "token", "secret", "password", "signature"}
+
+// Target selects the requests of an organization that are recorded
+type Target struct {
+	// State limits recording to one Terraform state; empty records all
+	// requests of the organization
+	State string    `json:"state,omitempty"`
+	Until time.Time `json:"until"`
+}
+
+// Exchange is a recorded request and its response
+type Exchange struct {
+	Time                  time.Time   `json:"time"`
+	OrgID                 string      `json:"org_id"`
+	Method                string      `json:"method"`
+	Path                  string      `json:"path"`
+	Query                 string      `json:"query,omitempty"`
+	RequestHeaders        http.Header `json:"request_headers"`
+	RequestBody           string      `json:"request_body"`
+	RequestBodyTruncated  bool        `json:"request_body_truncated"`
+	Status                int         `json:"status"`
+	ResponseHeaders       http.Header `json:"response_headers"`
+	ResponseBody          string      `json:"response_body"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated"`
+	DurationMs            float64     `json:"duration_ms"`
+}
+
+// Recorder records the exchanges of targeted organizations into a ring
+// buffer shared by all organizations
+type Recorder struct {
+	mu      sync.Mutex
+	targets map[uuid.UUID]Target
+	buffer  []Exchange
+	next    int // Index the next exchange is written to
+	count   int // Exchanges in the buffer
+	maxBody int
+
+	now func() time.Time
+}
+
+// New creates a recorder keeping the last size exchanges, with bodies
+// truncated to maxBody bytes
+func New(size, maxBody int) *Recorder {
+	if size < 1 {
+		size = 1
+	}
+	return &Recorder{
+		targets: make(map[uuid.UUID]Target),
+		buffer:  make([]Exchange, size),
+		maxBody: maxBody,
+		now:     time.Now,
+	}
+}
+
+// Start records the requests of an organization matching target, replacing
+// any earlier target of the organization
+func (rec *Recorder) Start(orgID uuid.UUID, target Target) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.targets[orgID] = target
+}
+
+// Stop ends recording for an organization and discards its exchanges. It
+// reports whether the organization had a target or exchanges.
+func (rec *Recorder) Stop(orgID uuid.UUID) bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	_, existed := rec.targets[orgID]
+	delete(rec.targets, orgID)
+
+	// Compact the remaining exchanges, oldest first
+	kept := make([]Exchange, 0, rec.count)
+	for _, exchange := range rec.exchanges() {
+		if exchange.OrgID != orgID.String() {
+			kept = append(kept, exchange)
+		}
+	}
+	for i := range rec.buffer {
+		rec.buffer[i] = Exchange{}
+	}
+	copy(rec.buffer, kept)
+	existed = existed || len(kept) < rec.count
+	rec.count = len(kept)
+	rec.next = len(kept) % len(rec.buffer)
+	return existed
+}
+
+// Target returns the active target of an organization
+func (rec *Recorder) Target(orgID uuid.UUID) (Target, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.activeTarget(orgID)
+}
+
+// activeTarget returns the target of an organization unless it expired.
+// The caller must hold mu.
+func (rec *Recorder) activeTarget(orgID uuid.UUID) (Target, bool) {
+	target, ok := rec.targets[orgID]
+	if !ok {
+		return Target{}, false
+	}
+	if !target.Until.IsZero() && rec.now().After(target.Until) {
+		delete(rec.targets, orgID)
+		return Target{}, false
+	}
+	return target, true
+}
+
+// Exchanges returns the recorded exchanges of an organization, oldest
+// first
+func (rec *Recorder) Exchanges(orgID uuid.UUID) []Exchange {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	exchanges := []Exchange{}
+	for _, exchange := range rec.exchanges() {
+		if exchange.OrgID == orgID.String() {
+			exchanges = append(exchanges, exchange)
+		}
+	}
+	return exchanges
+}
+
+// exchanges returns the buffer contents oldest first. The caller must hold
+// mu.
+func (rec *Recorder) exchanges() []Exchange {
+	exchanges := make([]Exchange, 0, rec.count)
+	start := (rec.next - rec.count + len(rec.buffer)) % len(rec.buffer)
+	for i := 0; i < rec.count; i++ {
+		exchanges = append(exchanges, rec.buffer[(start+i)%len(rec.buffer)])
+	}
+	return exchanges
+}
+
+// add appends an exchange, overwriting the oldest when the buffer is full
+func (rec *Recorder) add(exchange Exchange) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.buffer[rec.next] = exchange
+	rec.next = (rec.next + 1) % len(rec.buffer)
+	if rec.count < len(rec.buffer) {
+		rec.count++
+	}
+}
+
+// Middleware records the requests of targeted organizations. It must run
+// after authentication so the organization is known.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, ok := auth.GetOrgIDFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec.mu.Lock()
+		target, recording := rec.activeTarget(orgID)
+		rec.mu.Unlock()
+		if !recording {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := rec.now()
+		requestBody := &limitedBuffer{limit: rec.maxBody}
+		if r.Body != nil {
+			r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, requestBody), Closer: r.Body}
+		}
+		responseBody := &limitedBuffer{limit: rec.maxBody}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(responseBody)
+
+		next.ServeHTTP(ww, r)
+
+		// The state name is only known once the route has been matched
+		if target.State != "" && chi.URLParam(r, "name") != target.State {
+			return
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		rec.add(Exchange{
+			Time:                  start.UTC(),
+			OrgID:                 orgID.String(),
+			Method:                r.Method,
+			Path:                  r.URL.Path,
+			Query:                 r.URL.RawQuery,
+			RequestHeaders:        sanitize(r.Header),
+			RequestBody:           requestBody.String(),
+			RequestBodyTruncated:  requestBody.truncated,
+			Status:                status,
+			ResponseHeaders:       sanitize(ww.Header()),
+			ResponseBody:          responseBody.String(),
+			ResponseBodyTruncated: responseBody.truncated,
+			DurationMs:            float64(rec.now().Sub(start)) / float64(time.Millisecond),
+		})
+	})
+}
+
+// sanitize copies headers, redacting the values of sensitive ones
+func sanitize(header http.Header) http.Header {
+	sanitized := make(http.Header, len(header))
+	for name, values := range header {
+		if sensitiveHeader(name) {
+			sanitized[name] = []string{Redacted}
+			continue
+		}
+		sanitized[name] = append([]string(nil), values...)
+	}
+	return sanitized
+}
+
+// sensitiveHeader reports whether a header may carry credentials
+func sensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer; it never fails so the tee'd stream is not
+// interrupted
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// teeReadCloser copies the request body as the handler reads it
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,109 @@
+package recorder
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/reqctx"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// newTestRouter serves state routes behind the recorder, with the org ID
+// set as the auth middleware would
+func newTestRouter(rec *Recorder, orgID uuid.UUID) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(reqctx.WithOrgID(req.Context(), orgID)))
+		})
+	})
+	r.Use(rec.Middleware)
+	r.Post("/state/{name}", func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("stored " + string(body)))
+	})
+	return r
+}
+
+func TestMiddlewareRecordsTargetedRequests(t *testing.T) {
+	orgID := uuid.New()
+	rec := New(10, 8)
+	rec.Start(orgID, Target{State: "prod", Until: time.Now().Add(time.Hour)})
+	router := newTestRouter(rec, orgID)
+
+	for _, name := range []string{"prod", "dev"} {
+		req := httptest.NewRequest(http.MethodPost, "/state/"+name+"?ID=1", strings.NewReader(`{"version":4,"serial":1}`))
+		req.Header.Set("X-API-Key", "secret-key")
+		req.Header.Set("Authorization", "Basic c2VjcmV0")
+		req.Header.Set("User-Agent", "terraform/1.9")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated || !strings.HasPrefix(w.Body.String(), "stored {") {
+			t.Fatalf("Recording changed the response: %d %q", w.Code, w.Body.String())
+		}
+	}
+
+	exchanges := rec.Exchanges(orgID)
+	if len(exchanges) != 1 {
+		t.Fatalf("Expected only the prod request to be recorded, got %d", len(exchanges))
+	}
+	e := exchanges[0]
+	if e.Path != "/state/prod" || e.Query != "ID=1" || e.Status != http.StatusCreated {
+		t.Errorf("Unexpected exchange %+v", e)
+	}
+	if e.RequestBody != `{"versio` || !e.RequestBodyTruncated || e.ResponseBody != "stored {" || !e.ResponseBodyTruncated {
+		t.Errorf("Expected bodies truncated to 8 bytes, got %q and %q", e.RequestBody, e.ResponseBody)
+	}
+	for _, header := range []string{"X-Api-Key", "Authorization"} {
+		if got := e.RequestHeaders.Get(header); got != Redacted {
+			t.Errorf("Expected %s to be redacted, got %q", header, got)
+		}
+	}
+	if e.RequestHeaders.Get("User-Agent") != "terraform/1.9" {
+		t.Error("Expected non-sensitive headers to be kept")
+	}
+	if e.ResponseHeaders.Get("Set-Cookie") != Redacted {
+		t.Error("Expected response cookies to be redacted")
+	}
+
+	if !rec.Stop(orgID) || len(rec.Exchanges(orgID)) != 0 {
+		t.Error("Expected Stop to discard the exchanges")
+	}
+}
+
+func TestRecorderRingBuffer(t *testing.T) {
+	orgA, orgB := uuid.New(), uuid.New()
+	rec := New(3, 64)
+	for i, orgID := range []uuid.UUID{orgA, orgB, orgA, orgA, orgB} {
+		rec.add(Exchange{OrgID: orgID.String(), Status: i})
+	}
+
+	got := rec.Exchanges(orgA)
+	if len(got) != 2 || got[0].Status != 2 || got[1].Status != 3 {
+		t.Errorf("Expected the two newest exchanges of org A oldest first, got %+v", got)
+	}
+
+	rec.Stop(orgA)
+	rec.add(Exchange{OrgID: orgB.String(), Status: 5})
+	got = rec.Exchanges(orgB)
+	if len(got) != 2 || got[0].Status != 4 || got[1].Status != 5 {
+		t.Errorf("Expected org B's exchanges to survive Stop of org A, got %+v", got)
+	}
+}
+
+func TestTargetExpires(t *testing.T) {
+	orgID := uuid.New()
+	rec := New(1, 0)
+	rec.Start(orgID, Target{Until: time.Now().Add(time.Minute)})
+	rec.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, ok := rec.Target(orgID); ok {
+		t.Error("Expected the target to expire")
+	}
+}
@@ -0,0 +1,60 @@
+// Package reqctx holds request-scoped values shared across packages. The
+// authentication middleware records the organization here and every later
+// middleware and handler reads it from the same context key.
+//
+// Middleware that runs before authentication, such as the request log,
+// installs a Request record that WithOrgID fills in, since it cannot see
+// the contexts created further down the chain.
+package reqctx
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// orgIDKey is the context key of the authenticated organization
+type orgIDKey struct{}
+
+// requestKey is the context key of the request record
+type requestKey struct{}
+
+// Request records values set while a request is handled, for middleware
+// that reads them after the handler returns
+type Request struct {
+	mu     sync.Mutex
+	orgID  uuid.UUID
+	hasOrg bool
+}
+
+// WithRequest returns a context with a new request record
+func WithRequest(ctx context.Context) (context.Context, *Request) {
+	req := &Request{}
+	return context.WithValue(ctx, requestKey{}, req), req
+}
+
+// OrgID returns the organization the request authenticated as, if any
+func (req *Request) OrgID() (uuid.UUID, bool) {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return req.orgID, req.hasOrg
+}
+
+// WithOrgID returns a context recording the authenticated organization,
+// also setting it on the request record if there is one
+func WithOrgID(ctx context.Context, orgID uuid.UUID) context.Context {
+	if req, ok := ctx.Value(requestKey{}).(*Request); ok {
+		req.mu.Lock()
+		req.orgID, req.hasOrg = orgID, true
+		req.mu.Unlock()
+	}
+	return context.WithValue(ctx, orgIDKey{}, orgID)
+}
+
+// OrgID returns the authenticated organization, or false if the request
+// has not been authenticated
+func OrgID(ctx context.Context) (uuid.UUID, bool) {
+	orgID, ok := ctx.Value(orgIDKey{}).(uuid.UUID)
+	return orgID, ok
+}
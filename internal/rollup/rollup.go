@@ -0,0 +1,398 @@
+// Package rollup maintains per-organization daily rollups of uploaded data:
+// row counts and sums of numeric fields by day, provider and category. The
+// rollups are built incrementally in the background and persisted to one
+// JSON file per organization, so summary queries and dashboards stay fast
+// as the raw data grows.
+package rollup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// DayFormat is the format of bucket dates
+const DayFormat = "2006-01-02"
+
+// Bucket aggregates the rows ingested on one UTC day with one provider and
+// category
+type Bucket struct {
+	Date     string `json:"date"`
+	Provider string `json:"provider"`
+	Category string `json:"category"`
+	Count    int64  `json:"count"`
+
+	// Sums and Counts hold the sum and the number of numeric values of each
+	// summed field
+	Sums   map[string]float64 `json:"sums"`
+	Counts map[string]int64   `json:"counts"`
+}
+
+// OrgRollup holds an organization's daily buckets. Through is the ingestion
+// time up to which rows are included; rows ingested later are not yet
+// rolled up.
+type OrgRollup struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	Through   time.Time `json:"through"`
+	BuiltAt   time.Time `json:"built_at"`
+	SumFields []string  `json:"sum_fields"`
+	Buckets   []Bucket  `json:"buckets"`
+}
+
+// Config configures a rollup builder
+type Config struct {
+	// Dir holds one rollup file per organization
+	Dir string
+
+	// Interval between background builds of organizations with new data
+	Interval time.Duration
+
+	// SumFields are the numeric fields summed per bucket
+	SumFields []string
+
+	// Settle is how far behind the current time rows are rolled up, so
+	// rows that are still being written are not skipped
+	Settle time.Duration
+}
+
+// orgLister is implemented by data storage backends that can list the
+// organizations with data
+type orgLister interface {
+	ListOrgs() ([]uuid.UUID, error)
+}
+
+// Builder maintains the rollups of all organizations
+type Builder struct {
+	store storage.DataStorage
+	cfg   Config
+	now   func() time.Time
+
+	mu      sync.RWMutex
+	rollups map[uuid.UUID]*OrgRollup
+	dirty   map[uuid.UUID]bool
+
+	onBuild func(orgID uuid.UUID)
+
+	buildMu  sync.Mutex // serializes builds
+	builds   atomic.Int64
+	failures atomic.Int64
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewBuilder creates a builder for the data in store and loads existing
+// rollups from cfg.Dir. Every loaded organization, and every organization
+// the store can list, is built on the first run so rows ingested while the
+// service was down are included. Rollups built with other sum fields are
+// rebuilt from scratch.
+func NewBuilder(store storage.DataStorage, cfg Config) (*Builder, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rollup directory: %w", err)
+	}
+
+	b := &Builder{
+		store:    store,
+		cfg:      cfg,
+		now:      time.Now,
+		rollups:  make(map[uuid.UUID]*OrgRollup),
+		dirty:    make(map[uuid.UUID]bool),
+		stopChan: make(chan struct{}),
+	}
+
+	matches, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rollup files: %w", err)
+	}
+	for _, match := range matches {
+		orgID, err := uuid.Parse(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rollup file: %w", err)
+		}
+		var rollup OrgRollup
+		if err := json.Unmarshal(data, &rollup); err != nil {
+			return nil, fmt.Errorf("failed to parse rollup file %s: %w", match, err)
+		}
+		if slices.Equal(rollup.SumFields, cfg.SumFields) {
+			b.rollups[orgID] = &rollup
+		}
+		b.dirty[orgID] = true
+	}
+
+	if lister, ok := store.(orgLister); ok {
+		orgIDs, err := lister.ListOrgs()
+		if err != nil {
+			return nil, err
+		}
+		for _, orgID := range orgIDs {
+			b.dirty[orgID] = true
+		}
+	}
+
+	return b, nil
+}
+
+// SetOnBuild registers fn to be called after an organization's rollup is
+// rebuilt, before Start is called
+func (b *Builder) SetOnBuild(fn func(orgID uuid.UUID)) {
+	b.onBuild = fn
+}
+
+// Start builds the rollups of organizations with new data now and then
+// every cfg.Interval until the builder is closed
+func (b *Builder) Start() {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(b.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			b.BuildDirty()
+			select {
+			case <-ticker.C:
+			case <-b.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background builds
+func (b *Builder) Close() {
+	close(b.stopChan)
+	b.wg.Wait()
+}
+
+// MarkDirty schedules the organization's rollup for the next build
+func (b *Builder) MarkDirty(orgID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dirty[orgID] = true
+}
+
+// BuildDirty builds the rollups of organizations with new data. An
+// organization whose build fails, or that received rows too recent to be
+// rolled up, stays scheduled.
+func (b *Builder) BuildDirty() {
+	b.mu.Lock()
+	orgIDs := make([]uuid.UUID, 0, len(b.dirty))
+	for orgID := range b.dirty {
+		orgIDs = append(orgIDs, orgID)
+	}
+	b.dirty = make(map[uuid.UUID]bool)
+	b.mu.Unlock()
+
+	for _, orgID := range orgIDs {
+		settled, err := b.Build(orgID)
+		if err != nil {
+			slog.Error("Failed to build rollup", "org_id", orgID, "error", err)
+		}
+		if err != nil || !settled {
+			b.MarkDirty(orgID)
+		}
+	}
+}
+
+// Build adds the organization's rows ingested since its last build to its
+// rollup and persists it. It reports whether all rows were rolled up, which
+// is false while rows are younger than cfg.Settle.
+func (b *Builder) Build(orgID uuid.UUID) (bool, error) {
+	b.buildMu.Lock()
+	defer b.buildMu.Unlock()
+
+	now := b.now().UTC()
+	cutoff := now.Add(-b.cfg.Settle).Truncate(time.Second)
+
+	rollup := OrgRollup{OrgID: orgID, SumFields: b.cfg.SumFields}
+	existing, exists := b.Get(orgID)
+	if exists {
+		rollup = existing
+	}
+
+	buckets := make(map[string]*Bucket, len(rollup.Buckets))
+	for i := range rollup.Buckets {
+		bucket := rollup.Buckets[i]
+		buckets[bucketKey(bucket.Date, bucket.Provider, bucket.Category)] = &bucket
+	}
+
+	settled := true
+	err := b.eachRowSince(orgID, rollup.Through, func(upload storage.DataUpload) bool {
+		timestamp := upload.Timestamp.UTC()
+		if timestamp.After(cutoff) {
+			settled = false
+			return false
+		}
+		b.add(buckets, upload)
+		return true
+	})
+	if err != nil {
+		b.failures.Add(1)
+		return false, err
+	}
+	// Organizations without rows, such as deleted self-test organizations,
+	// get no rollup file
+	if !exists && len(buckets) == 0 {
+		return settled, nil
+	}
+
+	rollup.Through = cutoff
+	rollup.BuiltAt = now
+	rollup.Buckets = make([]Bucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		rollup.Buckets = append(rollup.Buckets, *bucket)
+	}
+	slices.SortFunc(rollup.Buckets, func(a, c Bucket) int {
+		return strings.Compare(bucketKey(a.Date, a.Provider, a.Category), bucketKey(c.Date, c.Provider, c.Category))
+	})
+
+	if err := b.save(&rollup); err != nil {
+		b.failures.Add(1)
+		return false, err
+	}
+
+	b.mu.Lock()
+	b.rollups[orgID] = &rollup
+	b.mu.Unlock()
+	b.builds.Add(1)
+	if b.onBuild != nil {
+		b.onBuild(orgID)
+	}
+	return settled, nil
+}
+
+// eachRowSince calls fn for the organization's rows ingested after since,
+// in cursor order, until fn returns false. Backends that page read only
+// the new rows; others are read in full.
+func (b *Builder) eachRowSince(orgID uuid.UUID, since time.Time, fn func(storage.DataUpload) bool) error {
+	if _, ok := b.store.(storage.DataPager); !ok {
+		uploads, err := b.store.GetOrgData(orgID)
+		if err != nil {
+			return err
+		}
+		slices.SortStableFunc(uploads, func(a, c storage.DataUpload) int {
+			return a.Timestamp.Compare(c.Timestamp)
+		})
+		for _, upload := range uploads {
+			if upload.Timestamp.After(since) && !fn(upload) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	var after *storage.DataCursor
+	if !since.IsZero() {
+		after = &storage.DataCursor{Timestamp: since, RowID: math.MaxInt64}
+	}
+	for {
+		page, err := storage.PageOrgData(b.store, orgID, storage.PageQuery{After: after, Limit: storage.MaxPageSize})
+		if err != nil {
+			return err
+		}
+		for _, upload := range page.Uploads {
+			if !fn(upload) {
+				return nil
+			}
+		}
+		if page.Next == nil {
+			return nil
+		}
+		after = page.Next
+	}
+}
+
+// add counts a row in its bucket
+func (b *Builder) add(buckets map[string]*Bucket, upload storage.DataUpload) {
+	date := upload.Timestamp.UTC().Format(DayFormat)
+	provider, _ := upload.Data["provider"].(string)
+	category, _ := upload.Data["category"].(string)
+
+	key := bucketKey(date, provider, category)
+	bucket, ok := buckets[key]
+	if !ok {
+		bucket = &Bucket{
+			Date:     date,
+			Provider: provider,
+			Category: category,
+			Sums:     make(map[string]float64),
+			Counts:   make(map[string]int64),
+		}
+		buckets[key] = bucket
+	}
+
+	bucket.Count++
+	for _, field := range b.cfg.SumFields {
+		if v, ok := storage.NumericValue(upload.Data[field]); ok {
+			bucket.Sums[field] += v
+			bucket.Counts[field]++
+		}
+	}
+}
+
+// bucketKey identifies a bucket; the separator cannot occur in a date
+func bucketKey(date, provider, category string) string {
+	return date + "\x00" + provider + "\x00" + category
+}
+
+// save atomically writes an organization's rollup file
+func (b *Builder) save(rollup *OrgRollup) error {
+	data, err := json.Marshal(rollup)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollup: %w", err)
+	}
+
+	path := filepath.Join(b.cfg.Dir, rollup.OrgID.String()+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rollup file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace rollup file: %w", err)
+	}
+	return nil
+}
+
+// Get returns a copy of the organization's rollup, if it was built
+func (b *Builder) Get(orgID uuid.UUID) (OrgRollup, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rollup, ok := b.rollups[orgID]
+	if !ok {
+		return OrgRollup{}, false
+	}
+	copied := *rollup
+	copied.Buckets = make([]Bucket, len(rollup.Buckets))
+	for i, bucket := range rollup.Buckets {
+		bucket.Sums = maps.Clone(bucket.Sums)
+		bucket.Counts = maps.Clone(bucket.Counts)
+		copied.Buckets[i] = bucket
+	}
+	return copied, true
+}
+
+// Stats returns the number of organizations with rollups and of builds and
+// failed builds
+func (b *Builder) Stats() (orgs int, builds, failures int64) {
+	b.mu.RLock()
+	orgs = len(b.rollups)
+	b.mu.RUnlock()
+	return orgs, b.builds.Load(), b.failures.Load()
+}
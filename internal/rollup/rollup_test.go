@@ -0,0 +1,178 @@
+package rollup
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+// timedStore is a data store whose rows carry chosen ingestion times
+type timedStore struct {
+	uploads map[uuid.UUID][]storage.DataUpload
+	now     time.Time
+}
+
+func newTimedStore() *timedStore {
+	return &timedStore{uploads: make(map[uuid.UUID][]storage.DataUpload)}
+}
+
+func (s *timedStore) AppendData(orgID uuid.UUID, data map[string]interface{}) error {
+	s.uploads[orgID] = append(s.uploads[orgID], storage.DataUpload{Timestamp: s.now, OrgID: orgID, Data: data})
+	return nil
+}
+
+func (s *timedStore) GetOrgData(orgID uuid.UUID) ([]storage.DataUpload, error) {
+	return append([]storage.DataUpload(nil), s.uploads[orgID]...), nil
+}
+
+func (s *timedStore) DeleteOrgData(orgID uuid.UUID) error {
+	delete(s.uploads, orgID)
+	return nil
+}
+
+func TestBuildIsIncremental(t *testing.T) {
+	store := newTimedStore()
+	orgID := uuid.New()
+	day := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store.now = day
+	store.AppendData(orgID, map[string]interface{}{"provider": "aws", "category": "compute", "cost_monthly": 10.0})
+	store.AppendData(orgID, map[string]interface{}{"provider": "aws", "category": "compute", "cost_monthly": "n/a"})
+
+	b, err := NewBuilder(store, Config{Dir: t.TempDir(), Interval: time.Hour, SumFields: []string{"cost_monthly"}})
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	b.now = func() time.Time { return day.Add(time.Hour) }
+	if settled, err := b.Build(orgID); err != nil || !settled {
+		t.Fatalf("Build = %v, %v; want settled", settled, err)
+	}
+
+	store.now = day.Add(24 * time.Hour)
+	store.AppendData(orgID, map[string]interface{}{"provider": "gcp", "cost_monthly": 5.0})
+	b.now = func() time.Time { return day.Add(25 * time.Hour) }
+	if _, err := b.Build(orgID); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	rollup, ok := b.Get(orgID)
+	if !ok {
+		t.Fatal("Expected a rollup")
+	}
+	if len(rollup.Buckets) != 2 {
+		t.Fatalf("Expected 2 buckets, got %+v", rollup.Buckets)
+	}
+	first := rollup.Buckets[0]
+	if first.Date != day.Format(DayFormat) || first.Count != 2 || first.Sums["cost_monthly"] != 10 || first.Counts["cost_monthly"] != 1 {
+		t.Errorf("Rows rolled up more than once or miscounted: %+v", first)
+	}
+	if second := rollup.Buckets[1]; second.Provider != "gcp" || second.Category != "" || second.Count != 1 {
+		t.Errorf("Unexpected second bucket %+v", second)
+	}
+}
+
+func TestBuildLeavesUnsettledRows(t *testing.T) {
+	store := newTimedStore()
+	orgID := uuid.New()
+	store.now = time.Now().UTC()
+	store.AppendData(orgID, map[string]interface{}{"provider": "aws"})
+
+	b, err := NewBuilder(store, Config{Dir: t.TempDir(), Interval: time.Hour, Settle: time.Hour})
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	settled, err := b.Build(orgID)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if settled {
+		t.Error("Expected a row younger than the settle time to be left for a later build")
+	}
+	if rollup, _ := b.Get(orgID); len(rollup.Buckets) != 0 {
+		t.Errorf("Expected no buckets, got %+v", rollup.Buckets)
+	}
+}
+
+func TestRollupsPersist(t *testing.T) {
+	dir := t.TempDir()
+	store := newTimedStore()
+	orgID := uuid.New()
+	store.now = time.Now().UTC().Add(-time.Hour)
+	store.AppendData(orgID, map[string]interface{}{"provider": "aws", "cost_monthly": 3.0})
+
+	cfg := Config{Dir: dir, Interval: time.Hour, SumFields: []string{"cost_monthly"}}
+	b, err := NewBuilder(store, cfg)
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	b.MarkDirty(orgID)
+	b.BuildDirty()
+
+	reloaded, err := NewBuilder(store, cfg)
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	rollup, ok := reloaded.Get(orgID)
+	if !ok || len(rollup.Buckets) != 1 || rollup.Buckets[0].Sums["cost_monthly"] != 3 {
+		t.Errorf("Expected the persisted rollup, got %+v", rollup)
+	}
+
+	cfg.SumFields = []string{"cpu"}
+	changed, err := NewBuilder(store, cfg)
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	if _, ok := changed.Get(orgID); ok {
+		t.Error("Expected a rollup with other sum fields to be rebuilt")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	rollup := OrgRollup{
+		SumFields: []string{"cost_monthly"},
+		Buckets: []Bucket{
+			{Date: "2026-01-01", Provider: "aws", Category: "compute", Count: 2, Sums: map[string]float64{"cost_monthly": 10}, Counts: map[string]int64{"cost_monthly": 2}},
+			{Date: "2026-01-02", Provider: "aws", Category: "storage", Count: 1, Sums: map[string]float64{"cost_monthly": 5}, Counts: map[string]int64{"cost_monthly": 1}},
+			{Date: "2026-01-02", Provider: "", Category: "compute", Count: 1, Sums: map[string]float64{}, Counts: map[string]int64{}},
+		},
+	}
+
+	groups, ok := rollup.Summarize(storage.SummaryQuery{
+		GroupBy: []string{"provider"},
+		Aggregations: []storage.Aggregation{
+			{Func: storage.AggregateSum, Field: "cost_monthly"},
+			{Func: storage.AggregateAvg, Field: "cost_monthly"},
+		},
+	})
+	if !ok {
+		t.Fatal("Expected the rollup to answer the query")
+	}
+	got, _ := json.Marshal(groups)
+	want := `[` +
+		`{"key":{"provider":"aws"},"count":3,"values":{"avg:cost_monthly":5,"sum:cost_monthly":15}},` +
+		`{"key":{"provider":null},"count":1,"values":{"avg:cost_monthly":null,"sum:cost_monthly":0}}` +
+		`]`
+	if string(got) != want {
+		t.Errorf("Unexpected summary\n got: %s\nwant: %s", got, want)
+	}
+
+	for _, query := range []storage.SummaryQuery{
+		{GroupBy: []string{"region"}},
+		{Aggregations: []storage.Aggregation{{Func: storage.AggregateMax, Field: "cost_monthly"}}},
+		{Aggregations: []storage.Aggregation{{Func: storage.AggregateSum, Field: "cpu"}}},
+	} {
+		if _, ok := rollup.Summarize(query); ok {
+			t.Errorf("Expected %+v to need the raw data", query)
+		}
+	}
+
+	if days := rollup.Days("2026-01-02", ""); len(days) != 2 {
+		t.Errorf("Expected 2 buckets from 2026-01-02, got %+v", days)
+	}
+	if days := rollup.Days("", "2026-01-01"); len(days) != 1 {
+		t.Errorf("Expected 1 bucket through 2026-01-01, got %+v", days)
+	}
+}
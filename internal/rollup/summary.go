@@ -0,0 +1,111 @@
+package rollup
+
+import (
+	"slices"
+
+	"github.com/eterrain/tf-backend-service/internal/storage"
+)
+
+// Summarize answers a summary query from the rollup. It reports false for
+// queries the rollup cannot answer: grouping by fields other than provider
+// and category, or aggregations other than sum, count and avg of a summed
+// field.
+func (r OrgRollup) Summarize(query storage.SummaryQuery) ([]storage.SummaryGroup, bool) {
+	for _, field := range query.GroupBy {
+		if field != "provider" && field != "category" {
+			return nil, false
+		}
+	}
+	for _, aggregation := range query.Aggregations {
+		switch aggregation.Func {
+		case storage.AggregateSum, storage.AggregateCount, storage.AggregateAvg:
+		default:
+			return nil, false
+		}
+		if !slices.Contains(r.SumFields, aggregation.Field) {
+			return nil, false
+		}
+	}
+
+	type group struct {
+		key    map[string]interface{}
+		count  int64
+		sums   map[string]float64
+		counts map[string]int64
+	}
+
+	groups := make(map[[2]string]*group)
+	var order [][2]string
+	for _, bucket := range r.Buckets {
+		var id [2]string
+		key := make(map[string]interface{}, len(query.GroupBy))
+		for _, field := range query.GroupBy {
+			value := bucket.Provider
+			if field == "category" {
+				value = bucket.Category
+				id[1] = value
+			} else {
+				id[0] = value
+			}
+			// Rows without the field are grouped under null, as in raw summaries
+			if value == "" {
+				key[field] = nil
+			} else {
+				key[field] = value
+			}
+		}
+
+		g, ok := groups[id]
+		if !ok {
+			g = &group{key: key, sums: make(map[string]float64), counts: make(map[string]int64)}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.count += bucket.Count
+		for field, sum := range bucket.Sums {
+			g.sums[field] += sum
+		}
+		for field, count := range bucket.Counts {
+			g.counts[field] += count
+		}
+	}
+
+	result := make([]storage.SummaryGroup, 0, len(groups))
+	for _, id := range order {
+		g := groups[id]
+		values := make(map[string]*float64, len(query.Aggregations))
+		for _, aggregation := range query.Aggregations {
+			var v float64
+			switch aggregation.Func {
+			case storage.AggregateSum:
+				v = g.sums[aggregation.Field]
+			case storage.AggregateCount:
+				v = float64(g.counts[aggregation.Field])
+			case storage.AggregateAvg:
+				count := g.counts[aggregation.Field]
+				if count == 0 {
+					values[aggregation.Name()] = nil
+					continue
+				}
+				v = g.sums[aggregation.Field] / float64(count)
+			}
+			values[aggregation.Name()] = &v
+		}
+		result = append(result, storage.SummaryGroup{Key: g.key, Count: g.count, Values: values})
+	}
+
+	storage.SortSummaryGroups(result, query.GroupBy)
+	return result, true
+}
+
+// Days returns the buckets dated between from and to (inclusive, in
+// DayFormat); empty bounds are open
+func (r OrgRollup) Days(from, to string) []Bucket {
+	days := make([]Bucket, 0, len(r.Buckets))
+	for _, bucket := range r.Buckets {
+		if (from == "" || bucket.Date >= from) && (to == "" || bucket.Date <= to) {
+			days = append(days, bucket)
+		}
+	}
+	return days
+}
@@ -0,0 +1,136 @@
+// Package runtimestats reports process resource usage together with
+// counters registered by subsystems, so the process can be observed
+// without a sidecar.
+package runtimestats
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// recentPauses is the number of most recent GC pauses reported
+const recentPauses = 16
+
+// Counters returns the current counters of a subsystem
+type Counters func() map[string]int64
+
+// Registry collects the counters of subsystems
+type Registry struct {
+	mu         sync.RWMutex
+	started    time.Time
+	subsystems map[string]Counters
+}
+
+// NewRegistry creates an empty registry; uptime is measured from now
+func NewRegistry() *Registry {
+	return &Registry{
+		started:    time.Now(),
+		subsystems: make(map[string]Counters),
+	}
+}
+
+// Register adds the counters of a subsystem, replacing any registered
+// under the same name
+func (r *Registry) Register(name string, counters Counters) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subsystems[name] = counters
+}
+
+// HeapStats describes the Go heap
+type HeapStats struct {
+	AllocBytes uint64 `json:"alloc_bytes"`
+	InUseBytes uint64 `json:"in_use_bytes"`
+	SysBytes   uint64 `json:"sys_bytes"`
+	Objects    uint64 `json:"objects"`
+	TotalAlloc uint64 `json:"total_alloc_bytes"`
+}
+
+// GCStats describes garbage collection; recent pauses are newest first
+type GCStats struct {
+	Count          uint32    `json:"count"`
+	PauseTotalMs   float64   `json:"pause_total_ms"`
+	RecentPausesMs []float64 `json:"recent_pauses_ms"`
+	LastGC         time.Time `json:"last_gc,omitempty"`
+	CPUFraction    float64   `json:"cpu_fraction"`
+}
+
+// Snapshot is the runtime state of the process at one point in time
+type Snapshot struct {
+	UptimeSeconds float64                     `json:"uptime_seconds"`
+	GoVersion     string                      `json:"go_version"`
+	CPUs          int                         `json:"cpus"`
+	Goroutines    int                         `json:"goroutines"`
+	OpenFDs       int                         `json:"open_fds"` // -1 when unknown
+	Heap          HeapStats                   `json:"heap"`
+	GC            GCStats                     `json:"gc"`
+	Subsystems    map[string]map[string]int64 `json:"subsystems"`
+}
+
+// Snapshot reads the current runtime state and subsystem counters
+func (r *Registry) Snapshot() Snapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	snapshot := Snapshot{
+		UptimeSeconds: time.Since(r.started).Seconds(),
+		GoVersion:     runtime.Version(),
+		CPUs:          runtime.NumCPU(),
+		Goroutines:    runtime.NumGoroutine(),
+		OpenFDs:       openFDs(),
+		Heap: HeapStats{
+			AllocBytes: mem.HeapAlloc,
+			InUseBytes: mem.HeapInuse,
+			SysBytes:   mem.HeapSys,
+			Objects:    mem.HeapObjects,
+			TotalAlloc: mem.TotalAlloc,
+		},
+		GC: GCStats{
+			Count:          mem.NumGC,
+			PauseTotalMs:   durationMs(mem.PauseTotalNs),
+			RecentPausesMs: make([]float64, 0, recentPauses),
+			CPUFraction:    mem.GCCPUFraction,
+		},
+		Subsystems: make(map[string]map[string]int64),
+	}
+	if mem.LastGC > 0 {
+		snapshot.GC.LastGC = time.Unix(0, int64(mem.LastGC)).UTC()
+	}
+	// PauseNs is a circular buffer whose most recent entry is at
+	// (NumGC+255)%256
+	for i := uint32(0); i < recentPauses && i < mem.NumGC; i++ {
+		pause := mem.PauseNs[(mem.NumGC-1-i)%uint32(len(mem.PauseNs))]
+		snapshot.GC.RecentPausesMs = append(snapshot.GC.RecentPausesMs, durationMs(pause))
+	}
+
+	r.mu.RLock()
+	subsystems := make(map[string]Counters, len(r.subsystems))
+	for name, counters := range r.subsystems {
+		subsystems[name] = counters
+	}
+	r.mu.RUnlock()
+
+	// Counters run without the registry lock since they take their own
+	for name, counters := range subsystems {
+		snapshot.Subsystems[name] = counters()
+	}
+	return snapshot
+}
+
+// durationMs converts nanoseconds to milliseconds
+func durationMs(ns uint64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
+
+// openFDs counts the open file descriptors of the process, or returns -1
+// where /proc is not available
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// ReadDir itself holds one descriptor open while listing
+	return len(entries) - 1
+}
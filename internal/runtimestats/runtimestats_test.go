@@ -0,0 +1,36 @@
+package runtimestats
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("cache", func() map[string]int64 {
+		return map[string]int64{"entries": 3}
+	})
+	runtime.GC()
+
+	snapshot := registry.Snapshot()
+	if snapshot.Goroutines < 1 {
+		t.Errorf("Expected at least one goroutine, got %d", snapshot.Goroutines)
+	}
+	if snapshot.Heap.AllocBytes == 0 {
+		t.Error("Expected heap allocation to be reported")
+	}
+	if snapshot.GC.Count == 0 || len(snapshot.GC.RecentPausesMs) == 0 {
+		t.Errorf("Expected GC pauses after runtime.GC, got %+v", snapshot.GC)
+	}
+	if runtime.GOOS == "linux" && snapshot.OpenFDs < 3 {
+		t.Errorf("Expected stdin, stdout and stderr to be counted, got %d", snapshot.OpenFDs)
+	}
+	if snapshot.Subsystems["cache"]["entries"] != 3 {
+		t.Errorf("Expected subsystem counters, got %v", snapshot.Subsystems)
+	}
+
+	if _, err := json.Marshal(snapshot); err != nil {
+		t.Errorf("Snapshot does not encode: %v", err)
+	}
+}
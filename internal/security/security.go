@@ -0,0 +1,413 @@
+// Package security records security events (authentication failures, rate
+// limits, validation violations, key changes) on a dedicated stream with
+// structured fields, so they can be shipped to a SIEM instead of being
+// parsed out of the application log.
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/logrotate"
+)
+
+// Outputs the event stream can be written to
+const (
+	// OutputApp writes events to the application log as records with a
+	// security_event attribute
+	OutputApp = "app"
+
+	// OutputFile appends events to a dedicated file
+	OutputFile = "file"
+
+	// OutputSyslog sends events to a local or remote syslog daemon
+	OutputSyslog = "syslog"
+)
+
+// Formats of events written to a file or syslog
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+)
+
+// Severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Product identifies the service in CEF headers
+const (
+	cefVendor  = "eTerrain"
+	cefProduct = "tf-backend-service"
+)
+
+// Event is a security event. Name is a stable dotted identifier such as
+// auth.failure that SIEM rules can match on; Message is the human-readable
+// description.
+type Event struct {
+	Time      time.Time              `json:"time"`
+	Name      string                 `json:"event"`
+	Severity  string                 `json:"severity"`
+	Message   string                 `json:"message"`
+	OrgID     string                 `json:"org_id,omitempty"`
+	KeyID     string                 `json:"key_id,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Country   string                 `json:"country,omitempty"`
+	Method    string                 `json:"method,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// countryKey is the context key of the client country
+type countryKey struct{}
+
+// WithCountry returns a context recording the client country, which events
+// created with Request include
+func WithCountry(ctx context.Context, country string) context.Context {
+	return context.WithValue(ctx, countryKey{}, country)
+}
+
+// Request creates an event with the client address, country, method, path
+// and user agent of the request
+func Request(r *http.Request, name, severity, message string) Event {
+	country, _ := r.Context().Value(countryKey{}).(string)
+	return Event{
+		Name:      name,
+		Severity:  severity,
+		Message:   message,
+		IP:        r.RemoteAddr,
+		Country:   country,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// WithOrg returns the event with the organization it applies to
+func (e Event) WithOrg(orgID string) Event {
+	e.OrgID = orgID
+	return e
+}
+
+// WithKey returns the event with the API key it applies to
+func (e Event) WithKey(keyID string) Event {
+	e.KeyID = keyID
+	return e
+}
+
+// With returns the event with an extra field. Errors are recorded as their
+// message.
+func (e Event) With(key string, value interface{}) Event {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	fields := make(map[string]interface{}, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	e.Fields = fields
+	return e
+}
+
+// Text renders the event as an application log message
+func (e Event) Text() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+
+	sep := " - "
+	add := func(key string, value interface{}) {
+		fmt.Fprintf(&b, "%s%s: %v", sep, key, value)
+		sep = ", "
+	}
+	if e.OrgID != "" {
+		add("OrgID", e.OrgID)
+	}
+	if e.KeyID != "" {
+		add("KeyID", e.KeyID)
+	}
+	for _, key := range e.fieldKeys() {
+		add(key, e.Fields[key])
+	}
+	if e.IP != "" {
+		add("IP", e.IP)
+	}
+	if e.Country != "" {
+		add("Country", e.Country)
+	}
+	if e.Method != "" {
+		add("Method", e.Method)
+	}
+	if e.Path != "" {
+		add("Path", e.Path)
+	}
+	if e.UserAgent != "" {
+		add("UserAgent", e.UserAgent)
+	}
+	return b.String()
+}
+
+// CEF renders the event in ArcSight Common Event Format
+func (e Event) CEF(version string) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	extension := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+extension.Replace(value))
+		}
+	}
+	add("rt", strconv.FormatInt(e.Time.UnixMilli(), 10))
+	add("msg", e.Message)
+	add("src", host(e.IP))
+	add("requestMethod", e.Method)
+	add("request", e.Path)
+	add("requestClientApplication", e.UserAgent)
+	if e.OrgID != "" {
+		add("cs1Label", "orgId")
+		add("cs1", e.OrgID)
+	}
+	if e.KeyID != "" {
+		add("cs2Label", "keyId")
+		add("cs2", e.KeyID)
+	}
+	if e.Country != "" {
+		add("cs3Label", "srcCountry")
+		add("cs3", e.Country)
+	}
+	for _, key := range e.fieldKeys() {
+		add(key, fmt.Sprint(e.Fields[key]))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefVendor, cefProduct, header.Replace(version), header.Replace(e.Name),
+		header.Replace(e.Message), cefSeverity(e.Severity), strings.Join(ext, " "))
+}
+
+// logEvent writes the event to the application log at the level of its
+// severity, with its fields as attributes
+func logEvent(e Event) {
+	level := slog.LevelInfo
+	switch e.Severity {
+	case SeverityCritical:
+		level = slog.LevelError
+	case SeverityWarning:
+		level = slog.LevelWarn
+	}
+
+	attrs := []any{"security_event", e.Name, "severity", e.Severity}
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key, value)
+		}
+	}
+	add("org_id", e.OrgID)
+	add("key_id", e.KeyID)
+	for _, key := range e.fieldKeys() {
+		attrs = append(attrs, key, e.Fields[key])
+	}
+	add("ip", e.IP)
+	add("country", e.Country)
+	add("method", e.Method)
+	add("path", e.Path)
+	add("user_agent", e.UserAgent)
+	slog.Log(context.Background(), level, e.Message, attrs...)
+}
+
+// fieldKeys returns the keys of the extra fields in a stable order
+func (e Event) fieldKeys() []string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// host strips the port from a client address
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// cefSeverity maps a severity to the CEF 0-10 scale
+func cefSeverity(severity string) int {
+	switch severity {
+	case SeverityCritical:
+		return 9
+	case SeverityWarning:
+		return 6
+	default:
+		return 3
+	}
+}
+
+// Config selects where security events are written
+type Config struct {
+	Output string // app, file or syslog
+	Format string // json or cef (file and syslog outputs)
+
+	// File is the path events are appended to with the file output
+	File string
+
+	// Rotation controls rotation and retention of File
+	Rotation logrotate.Config
+
+	// SyslogAddress is the syslog daemon as network://host:port (e.g.
+	// udp://siem.internal:514), or empty for the local daemon
+	SyslogAddress string
+
+	// Version is reported in CEF headers
+	Version string
+}
+
+// stream is where Emit writes events
+type stream struct {
+	mu      sync.Mutex
+	output  string
+	format  string
+	version string
+	writer  io.Writer
+	syslog  *syslog.Writer
+	closer  io.Closer
+}
+
+var std = &stream{output: OutputApp}
+
+// Configure redirects security events to the configured output. The
+// returned closer releases the file or syslog connection; events emitted
+// afterwards go to the application log again.
+func Configure(cfg Config) (io.Closer, error) {
+	s := &stream{output: cfg.Output, format: cfg.Format, version: cfg.Version}
+	if s.format == "" {
+		s.format = FormatJSON
+	}
+	if s.format != FormatJSON && s.format != FormatCEF {
+		return nil, fmt.Errorf("invalid security log format: %s (must be json or cef)", cfg.Format)
+	}
+
+	switch cfg.Output {
+	case OutputApp, "":
+		s.output = OutputApp
+	case OutputFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("security log file is required for the file output")
+		}
+		file, err := logrotate.Open(cfg.File, 0640, cfg.Rotation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open security log: %w", err)
+		}
+		s.writer = file
+		s.closer = file
+	case OutputSyslog:
+		network, address := "", ""
+		if cfg.SyslogAddress != "" {
+			var ok bool
+			network, address, ok = strings.Cut(cfg.SyslogAddress, "://")
+			if !ok || (network != "udp" && network != "tcp") || address == "" {
+				return nil, fmt.Errorf("invalid syslog address: %s (must be udp://host:port or tcp://host:port)", cfg.SyslogAddress)
+			}
+		}
+		writer, err := syslog.Dial(network, address, syslog.LOG_AUTH|syslog.LOG_INFO, cefProduct)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		s.syslog = writer
+		s.closer = writer
+	default:
+		return nil, fmt.Errorf("invalid security log output: %s (must be app, file or syslog)", cfg.Output)
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.closer != nil {
+		std.closer.Close()
+	}
+	std.output, std.format, std.version = s.output, s.format, s.version
+	std.writer, std.syslog, std.closer = s.writer, s.syslog, s.closer
+	return std, nil
+}
+
+// Close implements io.Closer
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.closer != nil {
+		err = s.closer.Close()
+	}
+	s.output, s.writer, s.syslog, s.closer = OutputApp, nil, nil, nil
+	return err
+}
+
+// Emit records a security event, defaulting its time and severity
+func Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Severity == "" {
+		event.Severity = SeverityInfo
+	}
+	std.write(event)
+}
+
+// write formats the event for the configured output
+func (s *stream) write(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.output == OutputApp {
+		logEvent(event)
+		return
+	}
+
+	var line string
+	if s.format == FormatCEF {
+		line = event.CEF(s.version)
+	} else {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Failed to encode security event", "security_event", event.Name, "error", err)
+			return
+		}
+		line = string(encoded)
+	}
+
+	var err error
+	switch {
+	case s.syslog != nil:
+		switch event.Severity {
+		case SeverityCritical:
+			err = s.syslog.Crit(line)
+		case SeverityWarning:
+			err = s.syslog.Warning(line)
+		default:
+			err = s.syslog.Info(line)
+		}
+	case s.writer != nil:
+		_, err = io.WriteString(s.writer, line+"\n")
+	}
+	if err != nil {
+		// Never lose an event: fall back to the application log
+		slog.Error("Failed to write security event", "error", err)
+		logEvent(event)
+	}
+}
@@ -0,0 +1,112 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventText(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/v1/upload", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("User-Agent", "terraform/1.9")
+
+	event := Request(r, "auth.failure", SeverityWarning, "Failed authentication").
+		WithOrg("org-1").With("api_key_prefix", "abcd1234...")
+
+	want := "Failed authentication - OrgID: org-1, api_key_prefix: abcd1234..., IP: 10.0.0.1:5000, Method: POST, Path: /api/v1/upload, UserAgent: terraform/1.9"
+	if got := event.Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestEventWithCopiesFields(t *testing.T) {
+	base := Event{Name: "auth.failure"}.With("a", 1)
+	first := base.With("b", 2)
+	second := base.With("error", errors.New("boom"))
+
+	if len(base.Fields) != 1 || len(first.Fields) != 2 || len(second.Fields) != 2 {
+		t.Fatalf("Expected fields to be copied, got %v / %v / %v", base.Fields, first.Fields, second.Fields)
+	}
+	if second.Fields["error"] != "boom" {
+		t.Errorf("Expected error recorded as its message, got %#v", second.Fields["error"])
+	}
+}
+
+func TestEventCEF(t *testing.T) {
+	event := Event{
+		Time:     time.UnixMilli(1700000000000),
+		Name:     "policy.denied",
+		Severity: SeverityCritical,
+		Message:  "Denied | by policy",
+		OrgID:    "org-1",
+		IP:       "10.0.0.1:5000",
+	}.With("reason", "a=b\nc")
+
+	got := event.CEF("1.0.0")
+	want := `CEF:0|eTerrain|tf-backend-service|1.0.0|policy.denied|Denied \| by policy|9|` +
+		`rt=1700000000000 msg=Denied | by policy src=10.0.0.1 cs1Label=orgId cs1=org-1 reason=a\=b\nc`
+	if got != want {
+		t.Errorf("CEF() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestConfigureFileOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "security.log")
+	closer, err := Configure(Config{Output: OutputFile, Format: FormatJSON, File: path})
+	if err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	var appLog bytes.Buffer
+	log.SetOutput(&appLog)
+	defer log.SetOutput(os.Stderr)
+
+	Emit(Event{Name: "ratelimit.exceeded", Severity: SeverityWarning, Message: "Rate limit exceeded", OrgID: "org-1"})
+	if err := closer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if strings.Contains(appLog.String(), "security_event=") {
+		t.Errorf("Expected no security events in the application log, got %q", appLog.String())
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read security log: %v", err)
+	}
+	var event Event
+	if err := json.Unmarshal(bytes.TrimSpace(content), &event); err != nil {
+		t.Fatalf("Expected one JSON event, got %q: %v", content, err)
+	}
+	if event.Name != "ratelimit.exceeded" || event.OrgID != "org-1" || event.Time.IsZero() {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// Closing the stream sends events back to the application log
+	Emit(Event{Name: "auth.failure", Message: "Failed authentication"})
+	if !strings.Contains(appLog.String(), "Failed authentication security_event=auth.failure") {
+		t.Errorf("Expected event in the application log after close, got %q", appLog.String())
+	}
+}
+
+func TestConfigureRejectsInvalidOutput(t *testing.T) {
+	cases := []Config{
+		{Output: "kafka"},
+		{Output: OutputFile},
+		{Output: OutputApp, Format: "xml"},
+		{Output: OutputSyslog, SyslogAddress: "siem.internal:514"},
+	}
+	for _, cfg := range cases {
+		if _, err := Configure(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
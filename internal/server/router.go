@@ -0,0 +1,772 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/audit"
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/deprecation"
+	"github.com/eterrain/tf-backend-service/internal/download"
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/features"
+	"github.com/eterrain/tf-backend-service/internal/geoip"
+	"github.com/eterrain/tf-backend-service/internal/handlers"
+	"github.com/eterrain/tf-backend-service/internal/health"
+	"github.com/eterrain/tf-backend-service/internal/ingesthook"
+	"github.com/eterrain/tf-backend-service/internal/metrics"
+	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/policy"
+	"github.com/eterrain/tf-backend-service/internal/public"
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/querycache"
+	"github.com/eterrain/tf-backend-service/internal/quota"
+	"github.com/eterrain/tf-backend-service/internal/ratelimit"
+	"github.com/eterrain/tf-backend-service/internal/recorder"
+	"github.com/eterrain/tf-backend-service/internal/rollup"
+	"github.com/eterrain/tf-backend-service/internal/runtimestats"
+	"github.com/eterrain/tf-backend-service/internal/statequeue"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
+	"github.com/eterrain/tf-backend-service/internal/usage"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Options holds the dependencies used to build the HTTP router.
+// Optional dependencies disable their routes or middleware when nil.
+type Options struct {
+	// Version is reported by the health endpoint
+	Version string
+
+	// Credentials validates org ID and API key pairs (required)
+	Credentials auth.CredentialStore
+
+	// StateStorage enables the Terraform state API
+	StateStorage storage.Storage
+
+	// DataStorage enables the data upload API
+	DataStorage storage.DataStorage
+
+	// RateLimiter applies per-organization rate limiting (required)
+	RateLimiter *custommw.PerOrgRateLimiter
+
+	// IPRateLimiter limits the requests of each client IP before
+	// authentication
+	IPRateLimiter *custommw.IPRateLimiter
+
+	// UsageMeter enables usage accounting and billing routes
+	UsageMeter *usage.Meter
+
+	// SelfTestCredentials enables the self-test endpoint; it must also be
+	// consulted by Credentials so ephemeral orgs can authenticate
+	SelfTestCredentials *auth.InMemoryStore
+
+	// SelfTestLimiter limits the self-test runs of each client IP, as
+	// anonymous runs write uploads and states; nil allows
+	// defaultSelfTestRunsPerMinute
+	SelfTestLimiter *custommw.IPRateLimiter
+
+	// AdminAPIKey enables the /admin/v1 routes
+	AdminAPIKey string
+
+	// KeyStore enables the key listing and rotation admin routes and
+	// delegated sub-key issuance for keys with the keys:manage scope, and
+	// makes /health report its file watcher
+	KeyStore *auth.FileStore
+
+	// Metrics enables Prometheus instrumentation and the /metrics endpoint
+	Metrics *metrics.Metrics
+
+	// SeparateMetrics leaves /metrics off this router, for servers that
+	// serve it on a separate listener (see metrics.ListenerHandler)
+	SeparateMetrics bool
+
+	// Public is the endpoints served without authentication; the zero
+	// value serves all of them
+	Public public.Set
+
+	// LoadShedder limits concurrency and sheds low-priority traffic under
+	// load; a default shedder is used when nil
+	LoadShedder *custommw.LoadShedder
+
+	// QuotaChecker adds soft quota warning headers and events
+	QuotaChecker *quota.Checker
+
+	// IngestCaps rejects uploads past an organization's daily byte or row
+	// cap with 429
+	IngestCaps *quota.IngestCaps
+
+	// Timeouts sets the request timeout per route group; unset groups use
+	// their defaults
+	Timeouts custommw.RouteTimeouts
+
+	// MaxClockSkew and MaxObservationAge bound client-provided observation
+	// timestamps on uploads; zero values keep the handler defaults
+	MaxClockSkew      time.Duration
+	MaxObservationAge time.Duration
+
+	// Taxonomy enforces per-organization upload allowlists and enables the
+	// taxonomy routes
+	Taxonomy *taxonomy.Store
+
+	// Normalizer applies per-organization attribute normalization rules to
+	// uploads and enables the normalization admin routes
+	Normalizer *normalize.Store
+
+	// Rollups serves data summaries from daily rollups where possible and
+	// enables GET /api/v1/data/rollups
+	Rollups *rollup.Builder
+
+	// Changes records uploads and state changes and enables the change feed
+	Changes *changes.Log
+
+	// Audit records state reads, writes and locks and enables the audit
+	// export admin route
+	Audit *audit.Log
+
+	// Downloads enables signed download URLs for states and data exports
+	Downloads *download.Signer
+
+	// StorageMonitor makes GET /ready report the storage probe results
+	StorageMonitor *health.Monitor
+
+	// Tokens enables POST /api/v1/token and session token authentication
+	Tokens *auth.TokenIssuer
+
+	// ReplayGuard requires a fresh timestamp and unused nonce on
+	// state-changing API requests
+	ReplayGuard *auth.ReplayGuard
+
+	// AuthLockout locks out organization and client IP pairs after repeated
+	// failed authentication
+	AuthLockout *auth.FailureTracker
+
+	// Policy evaluates authenticated API requests against authorization
+	// policies; denied requests get 403
+	Policy policy.Evaluator
+
+	// PolicyFailOpen allows requests when the policy cannot be evaluated
+	PolicyFailOpen bool
+
+	// TrustedProxies are the proxies whose forwarding headers set the
+	// client IP; forwarding headers are ignored when empty
+	TrustedProxies custommw.TrustedProxies
+
+	// GeoIP resolves client countries for security events and enforces
+	// the country lists; its per-organization lists enable the GeoIP admin
+	// routes
+	GeoIP *geoip.Enforcer
+
+	// FullSyncConcurrency limits full syncs running at once across all
+	// organizations and FullSyncInterval paces their chunks; zero values
+	// keep the handler defaults
+	FullSyncConcurrency int
+	FullSyncInterval    time.Duration
+
+	// MaxStateSize limits state uploads in bytes; zero uses the 10MB limit
+	// of other requests
+	MaxStateSize int64
+
+	// CSVFormat is the default dialect of CSV exports; the zero value uses
+	// csvfmt.Default
+	CSVFormat csvfmt.Format
+
+	// Runtime enables GET /admin/v1/runtime; the router registers the load
+	// shedder counters with it
+	Runtime *runtimestats.Registry
+
+	// Config enables GET /admin/v1/config, reporting these settings as
+	// loaded from ConfigFile
+	Config     []config.Setting
+	ConfigFile string
+
+	// Recorder records sanitized requests of selected organizations and
+	// enables the recording admin routes
+	Recorder *recorder.Recorder
+
+	// Faults enables the X-Fault-Inject header on API requests and the
+	// fault injection admin routes; the injector must also be set on the
+	// storage and credential stores
+	Faults *faults.Injector
+
+	// Deprecations announces deprecated endpoints and parameters with
+	// Deprecation and Sunset headers, records their use per organization and
+	// enables the deprecation usage admin route
+	Deprecations *deprecation.Tracker
+
+	// QueryCache caches data query responses per organization until it
+	// uploads; data queries carry ETags either way. Invalidation on rollup
+	// builds must be registered with Rollups.SetOnBuild.
+	QueryCache *querycache.Cache
+
+	// RateLimitOverrides enables the rate limit override admin routes; the
+	// store must also be set on the RateLimiter
+	RateLimitOverrides *ratelimit.Store
+
+	// Quarantine keeps uploads that fail validation for operator review and
+	// enables the quarantine admin routes
+	Quarantine *quarantine.Store
+
+	// Clients records the client versions of uploads and state writes and
+	// enables the client version admin route
+	Clients *clients.Tracker
+
+	// StateWrites serializes concurrent writes of the same state
+	StateWrites *statequeue.Queue
+
+	// UploadHooks transform accepted uploads before they are stored
+	UploadHooks *ingesthook.Runner
+
+	// Features gates experimental endpoints and is reported by GET
+	// /version; the zero value keeps every flag at its default
+	Features features.Set
+}
+
+// chi rejects methods it does not know, so Terraform's lock methods are
+// registered once, before any router is built
+func init() {
+	chi.RegisterMethod(handlers.MethodLock)
+	chi.RegisterMethod(handlers.MethodUnlock)
+}
+
+// defaultMaxBodySize limits request bodies unless a route sets its own limit
+const defaultMaxBodySize = 10 << 20
+
+// Self-test runs allowed per client IP, and client IPs tracked, unless
+// Options.SelfTestLimiter is set
+const (
+	defaultSelfTestRunsPerMinute = 6
+	defaultSelfTestClients       = 10000
+)
+
+// NewRouter builds the chi router with the full middleware stack and all
+// routes enabled by the given options
+func NewRouter(opts Options) http.Handler {
+	// Initialize handlers
+	var stateHandler *handlers.StateHandler
+	var uploadHandler *handlers.UploadHandler
+
+	if opts.StateStorage != nil {
+		stateHandler = handlers.NewStateHandler(opts.StateStorage)
+		if opts.UsageMeter != nil {
+			stateHandler.SetUsageRecorder(opts.UsageMeter)
+		}
+		if opts.Changes != nil {
+			stateHandler.SetChangeRecorder(opts.Changes)
+		}
+		if opts.Clients != nil {
+			stateHandler.SetClientTracker(opts.Clients)
+		}
+		if opts.StateWrites != nil {
+			stateHandler.SetWriteQueue(opts.StateWrites)
+		}
+	}
+	if opts.DataStorage != nil {
+		uploadHandler = handlers.NewUploadHandler(opts.DataStorage)
+		if opts.UsageMeter != nil {
+			uploadHandler.SetUsageRecorder(opts.UsageMeter)
+		}
+		if opts.Changes != nil {
+			uploadHandler.SetChangeRecorder(opts.Changes)
+		}
+		if opts.MaxClockSkew > 0 || opts.MaxObservationAge > 0 {
+			uploadHandler.SetTimestampBounds(opts.MaxClockSkew, opts.MaxObservationAge)
+		}
+		if opts.Taxonomy != nil {
+			uploadHandler.SetTaxonomy(opts.Taxonomy)
+		}
+		if opts.Normalizer != nil {
+			uploadHandler.SetNormalizer(opts.Normalizer)
+		}
+		if opts.Rollups != nil {
+			uploadHandler.SetRollups(opts.Rollups)
+		}
+		if opts.QueryCache != nil {
+			uploadHandler.SetQueryCache(opts.QueryCache)
+		}
+		if opts.Quarantine != nil {
+			uploadHandler.SetQuarantine(opts.Quarantine)
+		}
+		if opts.Clients != nil {
+			uploadHandler.SetClientTracker(opts.Clients)
+		}
+		if opts.UploadHooks != nil {
+			uploadHandler.SetHooks(opts.UploadHooks)
+		}
+		if opts.IngestCaps != nil {
+			uploadHandler.SetIngestCaps(opts.IngestCaps)
+		}
+		if opts.Metrics != nil {
+			uploadHandler.SetStorageErrorRecorder(opts.Metrics)
+		}
+		if opts.FullSyncConcurrency > 0 || opts.FullSyncInterval > 0 {
+			concurrency, interval := opts.FullSyncConcurrency, opts.FullSyncInterval
+			if concurrency <= 0 {
+				concurrency = handlers.DefaultFullSyncConcurrency
+			}
+			if interval <= 0 {
+				interval = handlers.DefaultFullSyncInterval
+			}
+			uploadHandler.SetFullSyncLimits(concurrency, interval)
+		}
+	}
+	healthHandler := handlers.NewHealthHandler(opts.Version)
+	healthHandler.SetFeatures(opts.Features.List())
+	if opts.StorageMonitor != nil {
+		healthHandler.SetStorageMonitor(opts.StorageMonitor)
+	}
+	if opts.KeyStore != nil {
+		healthHandler.SetCredentialWatcher(opts.KeyStore)
+	}
+	timeouts := opts.Timeouts.WithDefaults()
+
+	maxStateSize := opts.MaxStateSize
+	if maxStateSize <= 0 {
+		maxStateSize = defaultMaxBodySize
+	}
+
+	// Setup router
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(middleware.RequestID)
+	r.Use(custommw.RealIP(opts.TrustedProxies))
+	if opts.GeoIP != nil {
+		r.Use(opts.GeoIP.Middleware)
+	}
+	r.Use(custommw.RequestLogger)
+	if opts.Metrics != nil {
+		r.Use(opts.Metrics.HTTPMiddleware)
+	}
+	r.Use(middleware.Recoverer)
+
+	// Security: Limit request body size to 10MB to prevent DoS attacks
+	r.Use(custommw.BodyLimit(defaultMaxBodySize))
+
+	// Security: Limit each client IP before authentication, so credential
+	// guessing cannot keep the server busy with bcrypt comparisons
+	if opts.IPRateLimiter != nil {
+		r.Use(custommw.IPRateLimitMiddleware(opts.IPRateLimiter))
+	}
+
+	// Security: Limit concurrent requests to prevent resource exhaustion,
+	// shedding low-priority traffic first and always admitting state locks
+	loadShedder := opts.LoadShedder
+	if loadShedder == nil {
+		loadShedder = custommw.NewLoadShedder(custommw.DefaultLoadShedConfig(), custommw.ClassifyRequest)
+	}
+	r.Use(loadShedder.Middleware)
+	if opts.Runtime != nil {
+		opts.Runtime.Register("loadshed", func() map[string]int64 {
+			inFlight, queueDepth := loadShedder.Stats()
+			return map[string]int64{"in_flight": int64(inFlight), "queue_depth": int64(queueDepth)}
+		})
+	}
+
+	// Timeouts are applied per route group so long exports and large state
+	// uploads are not cut off by a single global limit
+	defaultTimeout := custommw.Timeout("default", timeouts.Default)
+
+	// Only the configured public endpoints are served without
+	// authentication; every other route must be in an authenticated group
+	if opts.Public.Enabled(public.Health) {
+		r.With(defaultTimeout).Get("/health", healthHandler.Check)
+	}
+	if opts.Public.Enabled(public.Ready) {
+		r.With(defaultTimeout).Get("/ready", healthHandler.Ready)
+	}
+	if opts.Public.Enabled(public.Version) {
+		r.With(defaultTimeout).Get("/version", healthHandler.Version)
+	}
+
+	// OpenAPI spec of this build
+	if opts.Public.Enabled(public.OpenAPI) {
+		r.With(defaultTimeout).Get("/openapi.yaml", handlers.OpenAPISpec)
+	}
+
+	// Prometheus metrics endpoint, unless it has its own listener
+	if opts.Metrics != nil && !opts.SeparateMetrics && opts.Public.Enabled(public.Metrics) {
+		r.With(defaultTimeout).Method(http.MethodGet, "/metrics", opts.Metrics.Handler())
+	}
+
+	var selfTestHandler *handlers.SelfTestHandler
+	if opts.SelfTestCredentials != nil {
+		selfTestHandler = handlers.NewSelfTestHandler(opts.SelfTestCredentials, opts.DataStorage, opts.StateStorage)
+		selfTestHandler.SetTarget(r)
+		if opts.SelfTestLimiter == nil {
+			opts.SelfTestLimiter = custommw.NewIPRateLimiter(defaultSelfTestRunsPerMinute, 0, defaultSelfTestClients)
+		}
+	}
+
+	// authenticated applies the middleware stack of authenticated API routes,
+	// shared by all API versions
+	authenticated := func(r chi.Router) {
+		// Reject clients locked out after repeated failed authentication
+		// before their key is compared
+		if opts.AuthLockout != nil {
+			r.Use(opts.AuthLockout.Middleware)
+		}
+
+		// Apply authentication middleware, accepting session tokens when
+		// enabled
+		if opts.Tokens != nil {
+			r.Use(auth.SessionMiddleware(opts.Tokens, opts.Credentials))
+		} else {
+			r.Use(auth.Middleware(opts.Credentials))
+		}
+
+		// Announce deprecations and record which organizations still use
+		// deprecated APIs
+		if opts.Deprecations != nil {
+			r.Use(opts.Deprecations.Middleware)
+		}
+
+		// Record requests of organizations being debugged, including those
+		// rejected by the middleware below
+		if opts.Recorder != nil {
+			r.Use(opts.Recorder.Middleware)
+		}
+
+		// Enforce the organization's country lists
+		if opts.GeoIP != nil {
+			r.Use(opts.GeoIP.OrgMiddleware)
+		}
+
+		// Apply per-organization rate limiting (after auth so we have org ID)
+		r.Use(custommw.RateLimitMiddleware(opts.RateLimiter))
+
+		// Reject replayed state-changing requests
+		if opts.ReplayGuard != nil {
+			r.Use(opts.ReplayGuard.Middleware)
+		}
+
+		// Check organization policies (after rate limiting so denied clients
+		// cannot flood the policy server)
+		if opts.Policy != nil {
+			r.Use(policy.Middleware(opts.Policy, opts.PolicyFailOpen))
+		}
+
+		// Warn organizations approaching their storage or rate quota
+		if opts.QuotaChecker != nil {
+			r.Use(opts.QuotaChecker.Middleware)
+		}
+
+		// Meter per-organization usage for billing
+		if opts.UsageMeter != nil {
+			r.Use(usage.Middleware(opts.UsageMeter))
+		}
+	}
+
+	// Signed URLs serve states and data exports as the key that issued them,
+	// with the same scope checks and audit records as direct requests
+	var downloadHandler *handlers.DownloadHandler
+	if opts.Downloads != nil {
+		downloadHandler = handlers.NewDownloadHandler(opts.Downloads, opts.Credentials)
+		if stateHandler != nil {
+			var state http.Handler = auth.RequireScope(auth.ScopeStateRead)(http.HandlerFunc(stateHandler.GetState))
+			if opts.Audit != nil {
+				state = audit.Middleware(opts.Audit)(state)
+			}
+			downloadHandler.SetResource(download.ResourceState, auth.ScopeStateRead, "/api/v1/state/{name}", state)
+		}
+		if uploadHandler != nil {
+			data := auth.RequireScope(auth.ScopeDataRead)(http.HandlerFunc(uploadHandler.GetOrgData))
+			downloadHandler.SetResource(download.ResourceData, auth.ScopeDataRead, "/api/v1/data", data)
+		}
+	}
+
+	r.Route("/api/v1", func(r chi.Router) {
+		// Inject faults requested by resilience tests
+		if opts.Faults != nil {
+			r.Use(opts.Faults.Middleware)
+		}
+
+		// Self-test provisions its own credentials (no auth required)
+		if selfTestHandler != nil {
+			r.With(defaultTimeout, custommw.IPRateLimitMiddleware(opts.SelfTestLimiter)).Post("/selftest", selfTestHandler.Run)
+		}
+
+		// Download URLs carry their own signed grant (no auth required)
+		if downloadHandler != nil {
+			r.With(custommw.Timeout("export", timeouts.Export)).Get("/downloads/{token}", downloadHandler.Download)
+		}
+
+		// Session tokens are only issued for an API key, so a session cannot
+		// be extended without it
+		if opts.Tokens != nil {
+			tokenHandler := handlers.NewTokenHandler(opts.Tokens)
+			tokenAuth := []func(http.Handler) http.Handler{defaultTimeout}
+			if opts.AuthLockout != nil {
+				tokenAuth = append(tokenAuth, opts.AuthLockout.Middleware)
+			}
+			tokenAuth = append(tokenAuth, auth.Middleware(opts.Credentials), custommw.RateLimitMiddleware(opts.RateLimiter))
+			r.With(tokenAuth...).Post("/token", tokenHandler.IssueToken)
+		}
+
+		// Protected routes with authentication
+		r.Group(func(r chi.Router) {
+			authenticated(r)
+
+			// Authenticated health check for verifying distributed keys
+			r.With(defaultTimeout).Get("/health", healthHandler.CheckAuthenticated)
+
+			if downloadHandler != nil {
+				r.With(defaultTimeout).Post("/downloads", downloadHandler.CreateURL)
+			}
+
+			// Data upload endpoints (for Terraform provider)
+			if uploadHandler != nil {
+				r.With(custommw.Timeout("upload", timeouts.Upload), auth.RequireScope(auth.ScopeDataWrite)).Post("/upload", uploadHandler.UploadData)
+				r.With(custommw.Timeout("upload", timeouts.Upload), auth.RequireScope(auth.ScopeDataWrite)).Post("/upload/csv", uploadHandler.UploadCSV)
+				r.With(defaultTimeout, auth.RequireScope(auth.ScopeDataWrite)).Get("/uploads/{uploadID}", uploadHandler.GetUploadStatus)
+				// Repeated identical queries are answered with 304 Not
+				// Modified, or from the query cache
+				etag := querycache.ETag(opts.QueryCache)
+				r.With(custommw.Timeout("export", timeouts.Export), auth.RequireScope(auth.ScopeDataRead), etag).Get("/data", uploadHandler.GetOrgData)
+				r.With(custommw.Timeout("export", timeouts.Export), auth.RequireScope(auth.ScopeDataRead), etag).Get("/data/quality", uploadHandler.GetDataQuality)
+				r.With(custommw.Timeout("export", timeouts.Export), auth.RequireScope(auth.ScopeDataRead), etag).Get("/data/summary", uploadHandler.GetDataSummary)
+				if opts.Rollups != nil {
+					r.With(custommw.Timeout("export", timeouts.Export), auth.RequireScope(auth.ScopeDataRead), etag).Get("/data/rollups", uploadHandler.GetDataRollups)
+				}
+				r.With(custommw.Timeout("export", timeouts.Export), auth.RequireScope(auth.ScopeDataRead)).Get("/data/full-sync", uploadHandler.FullSync)
+
+				// Providers can discover the values their org accepts
+				if opts.Taxonomy != nil {
+					taxonomyHandler := handlers.NewTaxonomyHandler(opts.Taxonomy)
+					r.With(defaultTimeout, auth.RequireScope(auth.ScopeDataRead)).Get("/taxonomy", taxonomyHandler.GetOrgTaxonomy)
+				}
+			}
+
+			// Incremental sync for downstream consumers
+			if opts.Changes != nil {
+				changeHandler := handlers.NewChangeHandler(opts.Changes)
+				r.With(defaultTimeout, auth.RequireScope(auth.ScopeDataRead)).Get("/changes", changeHandler.GetChanges)
+			}
+
+			// Delegated sub-key issuance for org admin keys
+			if opts.KeyStore != nil {
+				keyHandler := handlers.NewKeyHandler(opts.KeyStore)
+				r.Route("/keys", func(r chi.Router) {
+					r.Use(custommw.Timeout("default", timeouts.Default))
+					r.Use(auth.RequireScope(auth.ScopeKeysManage))
+					r.Get("/", keyHandler.ListOrgKeys)
+					r.Post("/", keyHandler.IssueKey)
+					r.Delete("/{keyID}", keyHandler.RevokeKey)
+				})
+			}
+
+			// State management endpoints (if using memory storage)
+			if stateHandler != nil {
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("state", timeouts.State))
+					r.Use(auth.RequireReadWriteScope(auth.ScopeStateRead, auth.ScopeStateWrite))
+
+					// Record state latency and lock contention for SLOs
+					if opts.Metrics != nil {
+						r.Use(opts.Metrics.StateMiddleware)
+					}
+					if opts.Audit != nil {
+						r.Use(audit.Middleware(opts.Audit))
+					}
+
+					// Workspace listing
+					r.Get("/state", stateHandler.ListStates)
+					r.Get("/state-prefixes/{prefix}", stateHandler.ListWorkspaces)
+
+					// Terraform backend API endpoints. The http backend's
+					// default lock_method and unlock_method are LOCK and
+					// UNLOCK on the state URL.
+					r.Route("/state/{name}", func(r chi.Router) {
+						r.Get("/", stateHandler.GetState)
+						r.With(custommw.BodyLimit(maxStateSize)).Post("/", stateHandler.PutState)
+						r.With(custommw.BodyLimit(maxStateSize)).Put("/", stateHandler.PutState)
+						r.Delete("/", stateHandler.DeleteState)
+						r.MethodFunc(handlers.MethodLock, "/", stateHandler.LockState)
+						r.MethodFunc(handlers.MethodUnlock, "/", stateHandler.UnlockState)
+					})
+
+					// Lock endpoints
+					r.Post("/state/{name}/lock", stateHandler.LockState)
+					r.Delete("/state/{name}/lock", stateHandler.UnlockState)
+					r.With(auth.RequireScope(auth.ScopeStateAdmin)).Delete("/state/{name}/force-unlock", stateHandler.ForceUnlockState)
+					r.Post("/state/{name}/lock/refresh", stateHandler.RefreshLock)
+					r.Get("/state/{name}/lock-history", stateHandler.GetLockHistory)
+				})
+			}
+		})
+	})
+
+	// Version 2 of the upload API; v1 routes are unchanged
+	if uploadHandler != nil && opts.Features.Enabled(features.UploadV2) {
+		r.Route("/api/v2", func(r chi.Router) {
+			if opts.Faults != nil {
+				r.Use(opts.Faults.Middleware)
+			}
+
+			r.Group(func(r chi.Router) {
+				authenticated(r)
+				r.With(custommw.Timeout("upload", timeouts.Upload), auth.RequireScope(auth.ScopeDataWrite)).Post("/upload", uploadHandler.UploadDataV2)
+			})
+		})
+	}
+
+	// Organization and key management, served under /admin/v1 and under
+	// /api/v1/admin
+	var orgKeyRoutes func(r chi.Router)
+	if opts.KeyStore != nil {
+		keyHandler := handlers.NewKeyHandler(opts.KeyStore)
+		orgKeyRoutes = func(r chi.Router) {
+			r.Use(custommw.Timeout("admin", timeouts.Admin))
+			r.Get("/orgs", keyHandler.ListOrgs)
+			r.Post("/orgs", keyHandler.ProvisionOrg)
+			r.Get("/orgs/{orgID}/keys", keyHandler.ListKeys)
+			r.Post("/orgs/{orgID}/keys", keyHandler.AddKey)
+			r.Post("/orgs/{orgID}/keys/rotate", keyHandler.RotateKeys)
+			r.Delete("/orgs/{orgID}/keys/{keyID}", keyHandler.RevokeOrgKey)
+		}
+	}
+
+	// Operator-only admin routes (disabled unless an admin key is configured)
+	if opts.AdminAPIKey != "" {
+		r.Route("/admin/v1", func(r chi.Router) {
+			r.Use(auth.AdminMiddleware(opts.AdminAPIKey))
+
+			if opts.UsageMeter != nil {
+				billingHandler := handlers.NewBillingHandler(opts.UsageMeter)
+				if opts.CSVFormat.Delimiter != 0 {
+					billingHandler.SetCSVFormat(opts.CSVFormat)
+				}
+				r.With(custommw.Timeout("admin", timeouts.Admin)).Get("/billing", billingHandler.GetBilling)
+				r.With(custommw.Timeout("export", timeouts.Export)).Get("/billing/export", billingHandler.ExportBilling)
+			}
+
+			if opts.Deprecations != nil {
+				deprecationHandler := handlers.NewDeprecationHandler(opts.Deprecations)
+				r.With(custommw.Timeout("admin", timeouts.Admin)).Get("/deprecations", deprecationHandler.GetUsage)
+			}
+
+			if opts.Clients != nil {
+				clientHandler := handlers.NewClientHandler(opts.Clients)
+				r.With(custommw.Timeout("admin", timeouts.Admin)).Get("/clients", clientHandler.GetClients)
+			}
+
+			if opts.Runtime != nil {
+				runtimeHandler := handlers.NewRuntimeHandler(opts.Runtime)
+				r.With(custommw.Timeout("admin", timeouts.Admin)).Get("/runtime", runtimeHandler.GetRuntime)
+			}
+
+			if opts.Config != nil {
+				configHandler := handlers.NewConfigHandler(opts.ConfigFile, opts.Config)
+				r.With(custommw.Timeout("admin", timeouts.Admin)).Get("/config", configHandler.GetConfig)
+			}
+
+			if opts.KeyStore != nil {
+				r.Group(orgKeyRoutes)
+			}
+
+			if opts.Audit != nil {
+				auditHandler := handlers.NewAuditHandler(opts.Audit)
+				r.With(custommw.Timeout("export", timeouts.Export)).Get("/orgs/{orgID}/audit/state", auditHandler.ExportState)
+			}
+
+			if stateHandler != nil {
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Post("/orgs/{orgID}/state/{stateOp}", stateHandler.TransferState)
+					r.Delete("/orgs/{orgID}/states", stateHandler.DeleteStates)
+				})
+			}
+
+			if opts.Taxonomy != nil && opts.DataStorage != nil {
+				taxonomyHandler := handlers.NewTaxonomyHandler(opts.Taxonomy)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/orgs/{orgID}/taxonomy", taxonomyHandler.GetTaxonomy)
+					r.Put("/orgs/{orgID}/taxonomy", taxonomyHandler.PutTaxonomy)
+					r.Delete("/orgs/{orgID}/taxonomy", taxonomyHandler.DeleteTaxonomy)
+				})
+			}
+
+			if opts.GeoIP != nil && opts.GeoIP.Store() != nil {
+				geoIPHandler := handlers.NewGeoIPHandler(opts.GeoIP.Store())
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/orgs/{orgID}/geoip", geoIPHandler.GetLists)
+					r.Put("/orgs/{orgID}/geoip", geoIPHandler.PutLists)
+					r.Delete("/orgs/{orgID}/geoip", geoIPHandler.DeleteLists)
+				})
+			}
+
+			if opts.Faults != nil {
+				faultHandler := handlers.NewFaultHandler(opts.Faults)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/faults", faultHandler.GetRules)
+					r.Put("/faults", faultHandler.PutRules)
+					r.Delete("/faults", faultHandler.DeleteRules)
+				})
+			}
+
+			if opts.Recorder != nil {
+				recordingHandler := handlers.NewRecordingHandler(opts.Recorder)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/orgs/{orgID}/recording", recordingHandler.GetRecording)
+					r.Put("/orgs/{orgID}/recording", recordingHandler.StartRecording)
+					r.Delete("/orgs/{orgID}/recording", recordingHandler.StopRecording)
+				})
+			}
+
+			if opts.RateLimitOverrides != nil {
+				rateLimitHandler := handlers.NewRateLimitHandler(opts.RateLimitOverrides)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/ratelimit/overrides", rateLimitHandler.ListOverrides)
+					r.Get("/orgs/{orgID}/ratelimit", rateLimitHandler.GetOverride)
+					r.Put("/orgs/{orgID}/ratelimit", rateLimitHandler.PutOverride)
+					r.Delete("/orgs/{orgID}/ratelimit", rateLimitHandler.DeleteOverride)
+				})
+			}
+
+			if opts.Quarantine != nil && uploadHandler != nil {
+				quarantineHandler := handlers.NewQuarantineHandler(opts.Quarantine, uploadHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/quarantine", quarantineHandler.ListEntries)
+					r.Get("/quarantine/{entryID}", quarantineHandler.GetEntry)
+					r.Get("/quarantine/{entryID}/payload", quarantineHandler.GetPayload)
+					r.Delete("/quarantine/{entryID}", quarantineHandler.DeleteEntry)
+				})
+				r.With(custommw.Timeout("upload", timeouts.Upload)).Post("/quarantine/{entryID}/reingest", quarantineHandler.Reingest)
+			}
+
+			if opts.Normalizer != nil && opts.DataStorage != nil {
+				normalizationHandler := handlers.NewNormalizationHandler(opts.Normalizer)
+				r.Group(func(r chi.Router) {
+					r.Use(custommw.Timeout("admin", timeouts.Admin))
+					r.Get("/orgs/{orgID}/normalization", normalizationHandler.GetRules)
+					r.Put("/orgs/{orgID}/normalization", normalizationHandler.PutRules)
+					r.Delete("/orgs/{orgID}/normalization", normalizationHandler.DeleteRules)
+				})
+			}
+		})
+
+		// Alias of the organization and key management routes at the path
+		// they were first specified under. It takes the admin key, not org
+		// credentials, like every /admin/v1 route.
+		if opts.KeyStore != nil {
+			r.Route("/api/v1/admin", func(r chi.Router) {
+				r.Use(auth.AdminMiddleware(opts.AdminAPIKey))
+				orgKeyRoutes(r)
+			})
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/audit"
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
+	"github.com/eterrain/tf-backend-service/internal/config"
+	"github.com/eterrain/tf-backend-service/internal/deprecation"
+	"github.com/eterrain/tf-backend-service/internal/download"
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/metrics"
+	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/public"
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/querycache"
+	"github.com/eterrain/tf-backend-service/internal/ratelimit"
+	"github.com/eterrain/tf-backend-service/internal/recorder"
+	"github.com/eterrain/tf-backend-service/internal/runtimestats"
+	"github.com/eterrain/tf-backend-service/internal/statequeue"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
+	"github.com/eterrain/tf-backend-service/internal/usage"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// unauthenticatedRoutes are the routes that may answer requests without
+// credentials. Anything added here needs a security review.
+var unauthenticatedRoutes = map[string]bool{
+	"GET /health":                   true,
+	"GET /ready":                    true,
+	"GET /version":                  true,
+	"GET /openapi.yaml":             true,
+	"GET /metrics":                  true,
+	"POST /api/v1/selftest":         true, // Provisions its own ephemeral credentials
+	"GET /api/v1/downloads/{token}": true, // Authenticated by the signed token
+}
+
+// newTestRouter builds a router with every optional route enabled
+func newTestRouter(t *testing.T, publicEndpoints public.Set) http.Handler {
+	t.Helper()
+	dir := t.TempDir()
+
+	credentials := auth.NewInMemoryStore()
+	keysFile := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(keysFile, nil, 0600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	keyStore, err := auth.NewFileStore(keysFile)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	t.Cleanup(func() { keyStore.Close() })
+
+	rateLimiter := custommw.NewPerOrgRateLimiter(6000)
+	t.Cleanup(rateLimiter.Stop)
+	overrides, _ := ratelimit.NewStore("")
+	rateLimiter.SetOverrides(overrides)
+
+	usageMeter, err := usage.NewMeter(filepath.Join(dir, "usage.json"), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create usage meter: %v", err)
+	}
+	t.Cleanup(func() { usageMeter.Close() })
+
+	changeLog, err := changes.Open(filepath.Join(dir, "changes.jsonl"), 100)
+	if err != nil {
+		t.Fatalf("Failed to open change log: %v", err)
+	}
+	t.Cleanup(func() { changeLog.Close() })
+
+	auditLog, err := audit.Open(audit.Config{Dir: filepath.Join(dir, "audit")})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	quarantineStore, err := quarantine.Open(quarantine.Config{Dir: filepath.Join(dir, "quarantine"), MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to open quarantine: %v", err)
+	}
+
+	signer, _ := download.NewSigner(nil, time.Hour)
+	tokens, _ := auth.NewTokenIssuer(nil, time.Minute)
+	taxonomyStore, _ := taxonomy.NewStore("")
+	normalizer, _ := normalize.NewStore("")
+
+	return NewRouter(Options{
+		Version:             "test",
+		Credentials:         credentials,
+		StateStorage:        storage.NewMemoryStorage(),
+		DataStorage:         storage.NewMemoryDataStorage(),
+		RateLimiter:         rateLimiter,
+		UsageMeter:          usageMeter,
+		SelfTestCredentials: credentials,
+		AdminAPIKey:         "admin-key-for-router-tests",
+		KeyStore:            keyStore,
+		Metrics:             metrics.New("memory"),
+		Public:              publicEndpoints,
+		Taxonomy:            taxonomyStore,
+		Normalizer:          normalizer,
+		Changes:             changeLog,
+		Audit:               auditLog,
+		Downloads:           signer,
+		Tokens:              tokens,
+		Runtime:             runtimestats.NewRegistry(),
+		Config:              []config.Setting{{Name: "Port", Value: "7777", Source: config.SourceDefault}},
+		Recorder:            recorder.New(10, 1024),
+		Faults:              faults.NewInjector(),
+		Deprecations:        deprecation.NewTracker(nil),
+		QueryCache:          querycache.New(querycache.Config{MaxBytes: 1 << 20}),
+		RateLimitOverrides:  overrides,
+		Quarantine:          quarantineStore,
+		Clients:             clients.NewTracker(),
+		StateWrites:         statequeue.New(statequeue.DefaultConfig()),
+	})
+}
+
+// routeParam matches chi route parameters such as {name}
+var routeParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// routePath fills in a route pattern's parameters
+func routePath(pattern string) string {
+	pattern = strings.TrimSuffix(pattern, "/*")
+	return routeParam.ReplaceAllStringFunc(pattern, func(param string) string {
+		if param == "{orgID}" {
+			return "11111111-2222-3333-4444-555555555555"
+		}
+		return "test"
+	})
+}
+
+func TestRoutesRequireAuthentication(t *testing.T) {
+	router := newTestRouter(t, public.Set{}.With(public.Metrics))
+
+	routes := 0
+	err := chi.Walk(router.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes++
+		name := method + " " + route
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, routePath(route), strings.NewReader("{}")))
+
+		if unauthenticatedRoutes[name] {
+			if rec.Code == http.StatusUnauthorized {
+				t.Errorf("%s: expected to be served without authentication, got %d", name, rec.Code)
+			}
+			return nil
+		}
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 without credentials, got %d", name, rec.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if routes < 50 {
+		t.Errorf("Expected the router to have every optional route, found only %d", routes)
+	}
+}
+
+func TestPublicEndpointsRestricted(t *testing.T) {
+	publicEndpoints, err := public.Parse("health")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	router := newTestRouter(t, publicEndpoints)
+
+	expected := map[string]int{
+		"/health":       http.StatusOK,
+		"/ready":        http.StatusNotFound,
+		"/version":      http.StatusNotFound,
+		"/openapi.yaml": http.StatusNotFound,
+		"/metrics":      http.StatusNotFound,
+	}
+	for path, code := range expected {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Errorf("GET %s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+}
+
+func TestMetricsNotPublicByDefault(t *testing.T) {
+	get := func(router http.Handler) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Code
+	}
+	if code := get(newTestRouter(t, public.Set{})); code != http.StatusNotFound {
+		t.Errorf("Expected /metrics not to be served by default, got %d", code)
+	}
+	if code := get(newTestRouter(t, public.Set{}.With(public.Metrics))); code != http.StatusOK {
+		t.Errorf("Expected /metrics to be served when listed, got %d", code)
+	}
+
+	// Metrics on their own listener are never served by the API router
+	router := NewRouter(Options{
+		Version:         "test",
+		Credentials:     auth.NewInMemoryStore(),
+		DataStorage:     storage.NewMemoryDataStorage(),
+		Metrics:         metrics.New("memory"),
+		SeparateMetrics: true,
+		Public:          public.Set{}.With(public.Metrics),
+	})
+	if code := get(router); code != http.StatusNotFound {
+		t.Errorf("Expected /metrics not to be served with a metrics listener, got %d", code)
+	}
+}
+
+func TestConfigRouteRequiresAdminKey(t *testing.T) {
+	credentials := auth.NewInMemoryStore()
+	orgID := "11111111-2222-3333-4444-555555555555"
+	credentials.AddCredentials(uuid.MustParse(orgID), "org-key")
+	rateLimiter := custommw.NewPerOrgRateLimiter(6000)
+	t.Cleanup(rateLimiter.Stop)
+
+	router := NewRouter(Options{
+		Version:      "test",
+		Credentials:  credentials,
+		DataStorage:  storage.NewMemoryDataStorage(),
+		RateLimiter:  rateLimiter,
+		AdminAPIKey:  "admin-key-for-router-tests",
+		Config:       []config.Setting{{Name: "Port", Value: "7777", Source: config.SourceDefault}},
+		StateStorage: storage.NewMemoryStorage(),
+	})
+
+	tests := []struct {
+		path    string
+		headers map[string]string
+		codes   []int
+	}{
+		{"/api/v1/config", map[string]string{"X-Org-ID": orgID, "X-API-Key": "org-key"}, []int{http.StatusUnauthorized, http.StatusNotFound}},
+		{"/admin/v1/config", map[string]string{"X-Org-ID": orgID, "X-API-Key": "org-key"}, []int{http.StatusUnauthorized}},
+		{"/admin/v1/config", map[string]string{"X-Admin-Key": "admin-key-for-router-tests"}, []int{http.StatusOK}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if !slices.Contains(tt.codes, rec.Code) {
+			t.Errorf("GET %s with %v: expected one of %v, got %d", tt.path, tt.headers, tt.codes, rec.Code)
+		}
+	}
+}
+
+func TestOrgRoutesAlias(t *testing.T) {
+	router := newTestRouter(t, public.Set{})
+	orgID := "11111111-2222-3333-4444-555555555555"
+
+	do := func(method, path, adminKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if adminKey != "" {
+			req.Header.Set("X-Admin-Key", adminKey)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The alias takes the admin key, never org credentials
+	if rec := do(http.MethodGet, "/api/v1/admin/orgs", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin key, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/admin/orgs", "wrong-key", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong admin key, got %d", rec.Code)
+	}
+
+	// Orgs provisioned through the alias are managed under /admin/v1
+	rec := do(http.MethodPost, "/api/v1/admin/orgs", "admin-key-for-router-tests", `{"org_id":"`+orgID+`"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from the alias, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = do(http.MethodGet, "/admin/v1/orgs/"+orgID+"/keys", "admin-key-for-router-tests", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the org's keys under /admin/v1, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = do(http.MethodPost, "/api/v1/admin/orgs/"+orgID+"/keys", "admin-key-for-router-tests", "{}")
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected 201 adding a key through the alias, got %d: %s", rec.Code, rec.Body)
+	}
+}
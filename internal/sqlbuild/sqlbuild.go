@@ -0,0 +1,253 @@
+// Package sqlbuild builds SQL statements for the SQL storage backends with
+// quoted, validated identifiers and bound arguments, so table names are
+// never formatted into statements and query logic can be shared between
+// MySQL, Postgres and SQLite.
+package sqlbuild
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect renders identifiers and placeholders for a database
+type Dialect interface {
+	// Name identifies the dialect, e.g. "mysql"
+	Name() string
+
+	// QuoteIdent quotes a validated identifier
+	QuoteIdent(name string) string
+
+	// Placeholder returns the placeholder of the nth argument, from 1
+	Placeholder(n int) string
+}
+
+// Supported dialects
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                  { return "mysql" }
+func (mysqlDialect) QuoteIdent(name string) string { return "`" + name + "`" }
+func (mysqlDialect) Placeholder(int) string        { return "?" }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                  { return "postgres" }
+func (postgresDialect) QuoteIdent(name string) string { return `"` + name + `"` }
+func (postgresDialect) Placeholder(n int) string      { return "$" + strconv.Itoa(n) }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                  { return "sqlite" }
+func (sqliteDialect) QuoteIdent(name string) string { return `"` + name + `"` }
+func (sqliteDialect) Placeholder(int) string        { return "?" }
+
+// identPattern matches the identifiers accepted by every dialect without
+// escaping
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// ValidateIdent checks an identifier, or a qualified "schema.table" name
+func ValidateIdent(name string) error {
+	for _, part := range strings.Split(name, ".") {
+		if !identPattern.MatchString(part) {
+			return fmt.Errorf("invalid SQL identifier %q", name)
+		}
+	}
+	return nil
+}
+
+// Expr is a SQL fragment with ? placeholders for its arguments. Fragments
+// are code, never user input, and must not contain literal question marks.
+type Expr struct {
+	SQL  string
+	Args []interface{}
+}
+
+// E creates an expression
+func E(sql string, args ...interface{}) Expr {
+	return Expr{SQL: sql, Args: args}
+}
+
+// Query accumulates a statement and its arguments. The first error, e.g.
+// an invalid identifier, is returned by Build.
+type Query struct {
+	dialect Dialect
+	b       strings.Builder
+	args    []interface{}
+	err     error
+}
+
+// New starts a statement in the dialect
+func New(dialect Dialect) *Query {
+	return &Query{dialect: dialect}
+}
+
+// Raw appends SQL without arguments
+func (q *Query) Raw(sql string) *Query {
+	return q.Expr(Expr{SQL: sql})
+}
+
+// Expr appends an expression, rewriting its placeholders for the dialect
+func (q *Query) Expr(e Expr) *Query {
+	if q.err != nil {
+		return q
+	}
+	if n := strings.Count(e.SQL, "?"); n != len(e.Args) {
+		q.err = fmt.Errorf("SQL fragment %q has %d placeholders for %d arguments", e.SQL, n, len(e.Args))
+		return q
+	}
+
+	rest := e.SQL
+	for _, arg := range e.Args {
+		i := strings.IndexByte(rest, '?')
+		q.b.WriteString(rest[:i])
+		q.args = append(q.args, arg)
+		q.b.WriteString(q.dialect.Placeholder(len(q.args)))
+		rest = rest[i+1:]
+	}
+	q.b.WriteString(rest)
+	return q
+}
+
+// Ident appends a quoted identifier, or a qualified "schema.table" name
+func (q *Query) Ident(name string) *Query {
+	if q.err != nil {
+		return q
+	}
+	if err := ValidateIdent(name); err != nil {
+		q.err = err
+		return q
+	}
+
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if i > 0 {
+			q.b.WriteByte('.')
+		}
+		q.b.WriteString(q.dialect.QuoteIdent(part))
+	}
+	return q
+}
+
+// Idents appends a comma-separated list of quoted identifiers
+func (q *Query) Idents(names ...string) *Query {
+	for i, name := range names {
+		if i > 0 {
+			q.Raw(", ")
+		}
+		q.Ident(name)
+	}
+	return q
+}
+
+// Build returns the statement and its arguments
+func (q *Query) Build() (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	return q.b.String(), q.args, nil
+}
+
+// Order is an ORDER BY term
+type Order struct {
+	Expr string // Column or alias
+	Desc bool
+}
+
+// Select is a SELECT statement
+type Select struct {
+	Columns []Expr
+	From    string
+	Where   Expr     // Optional condition
+	GroupBy []string // Columns or aliases
+	OrderBy []Order
+	Limit   int // 0 for no limit
+}
+
+// Build returns the statement in the dialect and its arguments
+func (s Select) Build(dialect Dialect) (string, []interface{}, error) {
+	if len(s.Columns) == 0 {
+		return "", nil, fmt.Errorf("SELECT without columns")
+	}
+
+	q := New(dialect).Raw("SELECT ")
+	for i, column := range s.Columns {
+		if i > 0 {
+			q.Raw(", ")
+		}
+		q.Expr(column)
+	}
+	q.Raw(" FROM ").Ident(s.From)
+	if s.Where.SQL != "" {
+		q.Raw(" WHERE ").Expr(s.Where)
+	}
+	if len(s.GroupBy) > 0 {
+		q.Raw(" GROUP BY ").Idents(s.GroupBy...)
+	}
+	for i, order := range s.OrderBy {
+		if i == 0 {
+			q.Raw(" ORDER BY ")
+		} else {
+			q.Raw(", ")
+		}
+		q.Ident(order.Expr)
+		if order.Desc {
+			q.Raw(" DESC")
+		} else {
+			q.Raw(" ASC")
+		}
+	}
+	if s.Limit > 0 {
+		q.Raw(" LIMIT ").Expr(E("?", s.Limit))
+	}
+	return q.Build()
+}
+
+// Insert is an INSERT of one row
+type Insert struct {
+	Into    string
+	Columns []string
+	Values  []interface{}
+}
+
+// Build returns the statement in the dialect and its arguments
+func (ins Insert) Build(dialect Dialect) (string, []interface{}, error) {
+	if len(ins.Columns) == 0 || len(ins.Columns) != len(ins.Values) {
+		return "", nil, fmt.Errorf("INSERT with %d columns and %d values", len(ins.Columns), len(ins.Values))
+	}
+
+	q := New(dialect).Raw("INSERT INTO ").Ident(ins.Into).Raw(" (").Idents(ins.Columns...).Raw(") VALUES (")
+	for i, value := range ins.Values {
+		if i > 0 {
+			q.Raw(", ")
+		}
+		q.Expr(E("?", value))
+	}
+	return q.Raw(")").Build()
+}
+
+// Delete is a DELETE of the rows matching a condition
+type Delete struct {
+	From  string
+	Where Expr // Required, so a missing condition never deletes every row
+}
+
+// Build returns the statement in the dialect and its arguments
+func (del Delete) Build(dialect Dialect) (string, []interface{}, error) {
+	if del.Where.SQL == "" {
+		return "", nil, fmt.Errorf("DELETE without condition")
+	}
+	return New(dialect).Raw("DELETE FROM ").Ident(del.From).Raw(" WHERE ").Expr(del.Where).Build()
+}
+
+// DropTableIfExists returns a statement dropping a table if it exists
+func DropTableIfExists(dialect Dialect, table string) (string, error) {
+	sql, _, err := New(dialect).Raw("DROP TABLE IF EXISTS ").Ident(table).Build()
+	return sql, err
+}
@@ -0,0 +1,99 @@
+package sqlbuild
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectDialects(t *testing.T) {
+	sel := Select{
+		Columns: []Expr{E("id"), E("JSON_EXTRACT(data, ?) AS g0", "$.a")},
+		From:    "org_abc",
+		Where:   E("timestamp > ? OR id > ?", 1, 2),
+		GroupBy: []string{"g0"},
+		OrderBy: []Order{{Expr: "timestamp", Desc: true}, {Expr: "id"}},
+		Limit:   10,
+	}
+
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{MySQL, "SELECT id, JSON_EXTRACT(data, ?) AS g0 FROM `org_abc` WHERE timestamp > ? OR id > ? GROUP BY `g0` ORDER BY `timestamp` DESC, `id` ASC LIMIT ?"},
+		{Postgres, `SELECT id, JSON_EXTRACT(data, $1) AS g0 FROM "org_abc" WHERE timestamp > $2 OR id > $3 GROUP BY "g0" ORDER BY "timestamp" DESC, "id" ASC LIMIT $4`},
+		{SQLite, `SELECT id, JSON_EXTRACT(data, ?) AS g0 FROM "org_abc" WHERE timestamp > ? OR id > ? GROUP BY "g0" ORDER BY "timestamp" DESC, "id" ASC LIMIT ?`},
+	}
+	for _, tt := range tests {
+		got, args, err := sel.Build(tt.dialect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.dialect.Name(), err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.dialect.Name(), got, tt.want)
+		}
+		if want := []interface{}{"$.a", 1, 2, 10}; !reflect.DeepEqual(args, want) {
+			t.Errorf("%s: got args %v, want %v", tt.dialect.Name(), args, want)
+		}
+	}
+}
+
+func TestInsertAndDrop(t *testing.T) {
+	got, args, err := Insert{Into: "org_abc", Columns: []string{"timestamp", "data"}, Values: []interface{}{"t", "d"}}.Build(Postgres)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := `INSERT INTO "org_abc" ("timestamp", "data") VALUES ($1, $2)`; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if len(args) != 2 {
+		t.Errorf("Expected 2 args, got %v", args)
+	}
+
+	if _, _, err := (Insert{Into: "org_abc", Columns: []string{"a"}}).Build(MySQL); err == nil {
+		t.Error("Expected an error for mismatched columns and values")
+	}
+
+	del, args, err := Delete{From: "org_abc", Where: E("id = ?", "x")}.Build(Postgres)
+	if err != nil || del != `DELETE FROM "org_abc" WHERE id = $1` || len(args) != 1 {
+		t.Errorf("Got %q, %v, %v", del, args, err)
+	}
+	if _, _, err := (Delete{From: "org_abc"}).Build(MySQL); err == nil {
+		t.Error("Expected an error for a DELETE without condition")
+	}
+
+	drop, err := DropTableIfExists(MySQL, "org_abc")
+	if err != nil || drop != "DROP TABLE IF EXISTS `org_abc`" {
+		t.Errorf("Got %q, %v", drop, err)
+	}
+}
+
+func TestInvalidIdentifiers(t *testing.T) {
+	for _, name := range []string{"", "org-abc", "org_abc; DROP TABLE x", "`org`", "1org", "a..b", "schema."} {
+		if err := ValidateIdent(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+		if _, err := DropTableIfExists(MySQL, name); err == nil {
+			t.Errorf("Expected DROP of %q to fail", name)
+		}
+	}
+	if err := ValidateIdent("information_schema.tables"); err != nil {
+		t.Errorf("Expected qualified name to be accepted: %v", err)
+	}
+
+	sql, _, err := New(MySQL).Raw("SELECT * FROM ").Ident("bad name").Raw(" WHERE ").Expr(E("id = ?", 1)).Build()
+	if err == nil || sql != "" {
+		t.Errorf("Expected the first error from Build, got %q, %v", sql, err)
+	}
+}
+
+func TestPlaceholderMismatch(t *testing.T) {
+	if _, _, err := New(MySQL).Expr(E("a = ? AND b = ?", 1)).Build(); err == nil {
+		t.Error("Expected an error for too few arguments")
+	}
+	if _, _, err := New(MySQL).Expr(E("a = 1", 1)).Build(); err == nil {
+		t.Error("Expected an error for too many arguments")
+	}
+	if _, _, err := (Select{From: "t"}).Build(MySQL); err == nil {
+		t.Error("Expected an error for a SELECT without columns")
+	}
+}
@@ -0,0 +1,196 @@
+// Package statequeue serializes concurrent writes of the same Terraform
+// state, so clients that skip the locking protocol cannot interleave their
+// writes. Each state (workspace) has one write slot and a short queue of
+// writes waiting for it. A write that waited while another write of the
+// state succeeded is rejected as a conflict: it was based on a state that
+// is no longer current, and storing it would silently drop the other
+// write's changes.
+//
+// Writes are only serialized within one server process. Servers sharing a
+// state backend still rely on Terraform's locking.
+package statequeue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrConflict means another write of the state succeeded while the
+	// write waited for its turn
+	ErrConflict = errors.New("state was changed by a concurrent write")
+
+	// ErrQueueFull means too many writes of the state are waiting already
+	ErrQueueFull = errors.New("too many concurrent writes of the state")
+
+	// ErrTimeout means the write waited longer than the queue timeout
+	ErrTimeout = errors.New("timed out waiting for a concurrent write of the state")
+)
+
+// Config configures the write queue
+type Config struct {
+	// MaxQueue is the number of writes per state that may wait while
+	// another write is in progress; further writes are rejected (0 =
+	// concurrent writes are rejected right away)
+	MaxQueue int
+
+	// QueueTimeout is how long a write waits for its turn
+	QueueTimeout time.Duration
+}
+
+// DefaultConfig returns the default queue limits
+func DefaultConfig() Config {
+	return Config{
+		MaxQueue:     4,
+		QueueTimeout: 10 * time.Second,
+	}
+}
+
+// workspace is the write slot and queue of one state
+type workspace struct {
+	slot       chan struct{}
+	waiting    int
+	users      int    // Writes holding or waiting for the slot
+	generation uint64 // Bumped by every successful write
+}
+
+// Queue serializes writes per state
+type Queue struct {
+	config Config
+
+	mu         sync.Mutex
+	workspaces map[string]*workspace // key: "orgID:name"
+
+	conflicts atomic.Int64
+	rejected  atomic.Int64
+}
+
+// New creates a write queue. A negative MaxQueue or a non-positive
+// QueueTimeout uses the default.
+func New(config Config) *Queue {
+	defaults := DefaultConfig()
+	if config.MaxQueue < 0 {
+		config.MaxQueue = defaults.MaxQueue
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = defaults.QueueTimeout
+	}
+	return &Queue{
+		config:     config,
+		workspaces: make(map[string]*workspace),
+	}
+}
+
+// Acquire waits until the caller may write the state. The returned release
+// function must be called once the write is done, reporting whether it
+// succeeded. Acquire fails with ErrQueueFull, ErrTimeout, ErrConflict or
+// the context's error.
+func (q *Queue) Acquire(ctx context.Context, orgID uuid.UUID, name string) (release func(written bool), err error) {
+	key := orgID.String() + ":" + name
+
+	q.mu.Lock()
+	ws, ok := q.workspaces[key]
+	if !ok {
+		ws = &workspace{slot: make(chan struct{}, 1)}
+		q.workspaces[key] = ws
+	}
+
+	// Take the free slot without queueing
+	select {
+	case ws.slot <- struct{}{}:
+		ws.users++
+		q.mu.Unlock()
+		return q.releaser(key, ws), nil
+	default:
+	}
+
+	if ws.waiting >= q.config.MaxQueue {
+		q.mu.Unlock()
+		q.rejected.Add(1)
+		return nil, ErrQueueFull
+	}
+	ws.waiting++
+	ws.users++
+	generation := ws.generation
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.config.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case ws.slot <- struct{}{}:
+	case <-timer.C:
+		q.leave(key, ws, false)
+		q.rejected.Add(1)
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		q.leave(key, ws, false)
+		return nil, ctx.Err()
+	}
+
+	q.mu.Lock()
+	ws.waiting--
+	conflict := ws.generation != generation
+	q.mu.Unlock()
+
+	if conflict {
+		<-ws.slot
+		q.leave(key, ws, true)
+		q.conflicts.Add(1)
+		return nil, ErrConflict
+	}
+	return q.releaser(key, ws), nil
+}
+
+// releaser returns the function that gives up the slot after a write
+func (q *Queue) releaser(key string, ws *workspace) func(written bool) {
+	var once sync.Once
+	return func(written bool) {
+		once.Do(func() {
+			q.mu.Lock()
+			if written {
+				ws.generation++
+			}
+			q.mu.Unlock()
+
+			<-ws.slot
+			q.leave(key, ws, true)
+		})
+	}
+}
+
+// leave removes a write from the workspace, dropping the workspace once no
+// write holds or waits for it. dequeued is set if the write already left
+// the queue.
+func (q *Queue) leave(key string, ws *workspace, dequeued bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !dequeued {
+		ws.waiting--
+	}
+	ws.users--
+	if ws.users == 0 {
+		delete(q.workspaces, key)
+	}
+}
+
+// Stats returns the number of states being written and of waiting writes,
+// and the number of writes rejected as conflicts or for a full queue or
+// timeout
+func (q *Queue) Stats() (active, queued int, conflicts, rejected int64) {
+	q.mu.Lock()
+	for _, ws := range q.workspaces {
+		if len(ws.slot) > 0 {
+			active++
+		}
+		queued += ws.waiting
+	}
+	q.mu.Unlock()
+	return active, queued, q.conflicts.Load(), q.rejected.Load()
+}
@@ -0,0 +1,121 @@
+package statequeue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// acquireAsync starts an Acquire that waits in the queue and returns its result
+func acquireAsync(q *Queue, orgID uuid.UUID, name string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		release, err := q.Acquire(context.Background(), orgID, name)
+		if err == nil {
+			release(true)
+		}
+		result <- err
+	}()
+	return result
+}
+
+// waitQueued waits until n writes are queued
+func waitQueued(t *testing.T, q *Queue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, queued, _, _ := q.Stats(); queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d queued writes", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueConflicts(t *testing.T) {
+	q := New(DefaultConfig())
+	orgID := uuid.New()
+
+	// Sequential writes never conflict
+	for i := 0; i < 3; i++ {
+		release, err := q.Acquire(context.Background(), orgID, "prod")
+		if err != nil {
+			t.Fatalf("Acquire %d failed: %v", i, err)
+		}
+		release(true)
+	}
+
+	// A write that waited for a successful write conflicts
+	release, _ := q.Acquire(context.Background(), orgID, "prod")
+	waiting := acquireAsync(q, orgID, "prod")
+	waitQueued(t, q, 1)
+	if active, queued, _, _ := q.Stats(); active != 1 || queued != 1 {
+		t.Errorf("Expected 1 active and 1 queued write, got %d and %d", active, queued)
+	}
+	release(true)
+	if err := <-waiting; !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	// A write that waited for a failed write proceeds
+	release, _ = q.Acquire(context.Background(), orgID, "prod")
+	waiting = acquireAsync(q, orgID, "prod")
+	waitQueued(t, q, 1)
+	release(false)
+	if err := <-waiting; err != nil {
+		t.Errorf("Expected the write to proceed after a failed write, got %v", err)
+	}
+
+	// Other states are written concurrently
+	release, _ = q.Acquire(context.Background(), orgID, "prod")
+	other, err := q.Acquire(context.Background(), orgID, "staging")
+	if err != nil {
+		t.Fatalf("Expected another state to be writable, got %v", err)
+	}
+	other(true)
+	release(true)
+	release(true) // Releasing twice does nothing
+
+	active, queued, conflicts, _ := q.Stats()
+	if active != 0 || queued != 0 || conflicts != 1 || len(q.workspaces) != 0 {
+		t.Errorf("Expected an idle queue with 1 conflict, got %d active, %d queued, %d conflicts, %d workspaces",
+			active, queued, conflicts, len(q.workspaces))
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	q := New(Config{MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	orgID := uuid.New()
+
+	release, _ := q.Acquire(context.Background(), orgID, "prod")
+	waiting := acquireAsync(q, orgID, "prod")
+	waitQueued(t, q, 1)
+
+	if _, err := q.Acquire(context.Background(), orgID, "prod"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if err := <-waiting; !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.Acquire(ctx, orgID, "prod"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	release(true)
+	if _, _, _, rejected := q.Stats(); rejected != 2 {
+		t.Errorf("Expected 2 rejected writes, got %d", rejected)
+	}
+	if release, err := q.Acquire(context.Background(), orgID, "prod"); err != nil {
+		t.Errorf("Expected the state to be writable again, got %v", err)
+	} else {
+		release(true)
+	}
+}
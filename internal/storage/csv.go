@@ -1,22 +1,28 @@
 package storage
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/eterrain/tf-backend-service/internal/durability"
 	"github.com/google/uuid"
 )
 
 // CSVStorage implements CSV file-based storage for terraform data uploads
 type CSVStorage struct {
-	dataDir string
-	mu      sync.RWMutex
+	dataDir    string
+	layout     string // CSVLayoutJSON or CSVLayoutWide
+	format     csvfmt.Format
+	encryption *csvEncryption // Encrypts data files at rest (nil = plaintext)
+	durability *durability.Policy
+	mu         sync.RWMutex
 }
 
 // DataUpload represents a single data upload from Terraform provider
@@ -24,6 +30,7 @@ type DataUpload struct {
 	Timestamp  time.Time              `json:"timestamp"`
 	OrgID      uuid.UUID              `json:"org_id"`
 	ReportName string                 `json:"report_name"`
+	Lineage    *Lineage               `json:"lineage,omitempty"`
 	Data       map[string]interface{} `json:"data"`
 }
 
@@ -42,6 +49,8 @@ func NewCSVStorage(dataDir string) (*CSVStorage, error) {
 
 	return &CSVStorage{
 		dataDir: absDataDir,
+		layout:  CSVLayoutJSON,
+		format:  csvfmt.Default,
 	}, nil
 }
 
@@ -81,29 +90,67 @@ func (s *CSVStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) er
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.appendAt(orgID, time.Now().UTC(), data)
+}
+
+// ImportData appends rows to the organization's CSV file with the
+// timestamps they were uploaded at
+func (s *CSVStorage) ImportData(orgID uuid.UUID, uploads []DataUpload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, upload := range uploads {
+		if err := s.appendAt(orgID, upload.Timestamp.UTC(), importedData(upload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendAt appends a row with the given timestamp. Must be called with
+// s.mu held.
+func (s *CSVStorage) appendAt(orgID uuid.UUID, timestamp time.Time, data map[string]interface{}) error {
 	// Validate and sanitize file path
 	filePath, err := s.sanitizeFilePath(orgID)
 	if err != nil {
 		return fmt.Errorf("invalid org ID for file path: %w", err)
 	}
 
+	if s.layout == CSVLayoutWide {
+		return s.appendWide(filePath, orgID, timestamp, data)
+	}
+
 	// Check if file exists to determine if we need to write headers
 	fileExists := false
+	delimiter := s.format.Delimiter
 	if _, err := os.Stat(filePath); err == nil {
 		fileExists = true
+
+		// Never mix JSON rows into a file that was converted to wide layout
+		header, fileDelimiter, err := s.readHeader(filePath, orgID)
+		if err != nil {
+			return err
+		}
+		if header != nil && !isLegacyJSONHeader(header) {
+			return fmt.Errorf("CSV file for org %s uses wide layout, configure csv_layout = wide", orgID)
+		}
+		if header != nil {
+			delimiter = fileDelimiter
+		}
 	}
 
 	// Open file in append mode, create if doesn't exist
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := s.appendCSV(filePath, orgID)
 	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	timestamp := time.Now().UTC()
+	// Rows appended to an existing file keep its delimiter
+	writer := s.format.NewWriter(file)
+	if fileExists {
+		writer = s.format.NewAppendWriter(file, delimiter)
+	}
 
 	// Extract report_name from data if present
 	reportName := ""
@@ -137,6 +184,19 @@ func (s *CSVStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) er
 		return fmt.Errorf("failed to write CSV row: %w", err)
 	}
 
+	return closeCSV(writer, file)
+}
+
+// closeCSV flushes a writer and closes its file, which seals the written
+// rows of an encrypted file
+func closeCSV(writer *csvfmt.Writer, file io.Closer) error {
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close CSV file: %w", err)
+	}
 	return nil
 }
 
@@ -151,45 +211,29 @@ func (s *CSVStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
 		return nil, fmt.Errorf("invalid org ID for file path: %w", err)
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return []DataUpload{}, nil
-	}
-
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open CSV file: %w", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-
-	// Read all records
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV file: %w", err)
-	}
-
-	// Skip header and parse records
-	uploads := make([]DataUpload, 0, len(records)-1)
-	for i, record := range records {
-		if i == 0 {
-			// Skip header row
-			continue
-		}
+	return s.readAllUploads(filePath, orgID)
+}
 
+// parseJSONRecords converts JSON-layout records (without header) into uploads,
+// skipping malformed rows and counting them in rejected (which may be nil)
+func parseJSONRecords(records [][]string, rejected *RejectedLines) []DataUpload {
+	uploads := make([]DataUpload, 0, len(records))
+	for _, record := range records {
 		// Support both old format (3 columns) and new format (4 columns)
 		if len(record) < 3 {
+			rejected.add(RejectTooFewColumns)
 			continue
 		}
 
 		timestamp, err := time.Parse(time.RFC3339, record[0])
 		if err != nil {
+			rejected.add(RejectInvalidTimestamp)
 			continue
 		}
 
 		parsedOrgID, err := uuid.Parse(record[1])
 		if err != nil {
+			rejected.add(RejectInvalidOrgID)
 			continue
 		}
 
@@ -203,6 +247,7 @@ func (s *CSVStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
 
 		var data map[string]interface{}
 		if err := json.Unmarshal([]byte(record[dataIndex]), &data); err != nil {
+			rejected.add(RejectInvalidJSON)
 			continue
 		}
 
@@ -210,9 +255,61 @@ func (s *CSVStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
 			Timestamp:  timestamp,
 			OrgID:      parsedOrgID,
 			ReportName: reportName,
+			Lineage:    lineageFromData(data),
 			Data:       data,
 		})
 	}
 
-	return uploads, nil
+	return uploads
+}
+
+// DeleteOrgData removes the organization's CSV file
+func (s *CSVStorage) DeleteOrgData(orgID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate and sanitize file path
+	filePath, err := s.sanitizeFilePath(orgID)
+	if err != nil {
+		return fmt.Errorf("invalid org ID for file path: %w", err)
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove CSV file: %w", err)
+	}
+
+	if err := os.Remove(manifestPath(filePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove header manifest: %w", err)
+	}
+
+	// Without its data key, any copy of an encrypted file is unreadable
+	if err := os.Remove(keyPath(filePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove data key: %w", err)
+	}
+	if s.encryption != nil {
+		s.encryption.forget(orgID)
+	}
+
+	return s.durability.SyncDir(s.dataDir)
+}
+
+// OrgDataSize returns the size of the organization's CSV file in bytes
+func (s *CSVStorage) OrgDataSize(orgID uuid.UUID) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filePath, err := s.sanitizeFilePath(orgID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid org ID for file path: %w", err)
+	}
+
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat CSV file: %w", err)
+	}
+
+	return info.Size(), nil
 }
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/csvcrypt"
+	"github.com/eterrain/tf-backend-service/internal/durability"
+	"github.com/google/uuid"
+)
+
+// dataKeyFile is the per-org file holding the org's data key, wrapped by
+// the master key
+type dataKeyFile struct {
+	Algorithm  string    `json:"algorithm"`
+	WrappedKey string    `json:"wrapped_key"` // Base64 nonce and sealed key
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// dataKeyAlgorithm is the cipher of data files and wrapped keys
+const dataKeyAlgorithm = "AES-256-GCM"
+
+// csvEncryption encrypts CSV data files with per-org data keys wrapped by a
+// master key
+type csvEncryption struct {
+	master     []byte
+	durability *durability.Policy // Syncs new data keys
+
+	mu   sync.Mutex
+	keys map[uuid.UUID][]byte // Unwrapped data keys
+}
+
+// SetEncryption encrypts CSV data files at rest with AES-256-GCM, using a
+// data key per organization wrapped by masterKey. Plaintext files are
+// encrypted on their next write and can be read until then.
+func (s *CSVStorage) SetEncryption(masterKey []byte) error {
+	if len(masterKey) != csvcrypt.KeySize {
+		return fmt.Errorf("encryption key must be %d bytes, got %d", csvcrypt.KeySize, len(masterKey))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encryption = &csvEncryption{master: masterKey, durability: s.durability, keys: make(map[uuid.UUID][]byte)}
+	return nil
+}
+
+// keyPath returns the data key path for a CSV data file
+func keyPath(filePath string) string {
+	return strings.TrimSuffix(filePath, ".csv") + ".key"
+}
+
+// dataKey returns the organization's data key, creating it if create is set
+func (e *csvEncryption) dataKey(filePath string, orgID uuid.UUID, create bool) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.keys[orgID]; ok {
+		return key, nil
+	}
+
+	path := keyPath(filePath)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		return e.createDataKey(path, orgID)
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("data key of org %s not found", orgID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data key: %w", err)
+	}
+
+	var file dataKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse data key: %w", err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(file.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data key: %w", err)
+	}
+	key, err := csvcrypt.Unwrap(e.master, wrapped, orgID[:])
+	if err != nil {
+		return nil, fmt.Errorf("data key of org %s: %w", orgID, err)
+	}
+	e.keys[orgID] = key
+	return key, nil
+}
+
+// createDataKey generates and atomically stores a new wrapped data key.
+// Must be called with e.mu held.
+func (e *csvEncryption) createDataKey(path string, orgID uuid.UUID) ([]byte, error) {
+	key, err := csvcrypt.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := csvcrypt.Wrap(e.master, key, orgID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(dataKeyFile{
+		Algorithm:  dataKeyAlgorithm,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		CreatedAt:  time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data key: %w", err)
+	}
+
+	// A lost data key loses the data encrypted with it, so the key is synced
+	// before any data is
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write data key: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = e.durability.SyncCritical(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to write data key: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to replace data key: %w", err)
+	}
+	if err := e.durability.SyncDirCritical(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	e.keys[orgID] = key
+	return key, nil
+}
+
+// forget drops the cached data key of an organization
+func (e *csvEncryption) forget(orgID uuid.UUID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.keys, orgID)
+}
+
+// fileEncrypted reports whether a data file exists and is encrypted
+func fileEncrypted(filePath string) (exists, encrypted bool, err error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+
+	prefix := make([]byte, len(csvcrypt.Magic))
+	n, _ := io.ReadFull(file, prefix)
+	return true, csvcrypt.IsEncrypted(prefix[:n]), nil
+}
+
+// openCSV opens an organization's data file for reading, decrypting it if
+// it is encrypted. It returns an error satisfying os.IsNotExist if the file
+// does not exist.
+func (s *CSVStorage) openCSV(filePath string, orgID uuid.UUID) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, len(csvcrypt.Magic))
+	n, _ := io.ReadFull(file, prefix)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to rewind CSV file: %w", err)
+	}
+	if !csvcrypt.IsEncrypted(prefix[:n]) {
+		return file, nil
+	}
+
+	if s.encryption == nil {
+		file.Close()
+		return nil, fmt.Errorf("CSV file for org %s is encrypted, configure the encryption key", orgID)
+	}
+	key, err := s.encryption.dataKey(filePath, orgID, false)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := csvcrypt.NewReader(file, key, orgID[:])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return readCloser{Reader: reader, Closer: file}, nil
+}
+
+// readCloser reads from a decrypting reader and closes the file beneath
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// appendCSV opens an organization's data file for appending, creating it if
+// needed. With encryption, a plaintext file is encrypted first, and writes
+// are sealed when the returned writer is closed; a failed write is rolled
+// back so no torn chunk is left behind. Must be called with s.mu held.
+func (s *CSVStorage) appendCSV(filePath string, orgID uuid.UUID) (io.WriteCloser, error) {
+	exists, encrypted, err := fileEncrypted(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted && s.encryption == nil {
+		return nil, fmt.Errorf("CSV file for org %s is encrypted, configure the encryption key", orgID)
+	}
+	if exists && !encrypted && s.encryption != nil {
+		if err := s.encryptFile(filePath, orgID); err != nil {
+			return nil, err
+		}
+		encrypted = true
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	if !exists {
+		if err := s.durability.SyncDir(s.dataDir); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if s.encryption == nil {
+		return &durableFile{File: file, durability: s.durability, path: filePath}, nil
+	}
+
+	key, err := s.encryption.dataKey(filePath, orgID, true)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat CSV file: %w", err)
+	}
+
+	var writer *csvcrypt.Writer
+	if encrypted {
+		writer, err = csvcrypt.NewAppendWriter(file, key, orgID[:], info.Size())
+	} else {
+		writer, err = csvcrypt.NewWriter(file, key, orgID[:])
+	}
+	if err != nil {
+		file.Truncate(info.Size())
+		file.Close()
+		return nil, fmt.Errorf("failed to start encrypted CSV file: %w", err)
+	}
+	return &encryptedAppender{Writer: writer, file: file, size: info.Size(), durability: s.durability, path: filePath}, nil
+}
+
+// encryptedAppender seals appended data on close
+type encryptedAppender struct {
+	*csvcrypt.Writer
+	file *os.File
+	size int64 // File size before the append
+
+	durability *durability.Policy
+	path       string // Final path of the file
+	closed     bool
+}
+
+// Close seals the appended data, syncs it as the durability level requires
+// and closes the file, truncating the file to its previous size if sealing
+// or syncing fails
+func (a *encryptedAppender) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	err := a.Writer.Close()
+	if err == nil {
+		err = a.durability.Sync(a.file, a.path)
+	}
+	if err != nil {
+		a.file.Truncate(a.size)
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createCSV creates a file for a rewrite of an organization's data file,
+// encrypted if encryption is enabled. It is synced on close for the data
+// file it replaces. Must be called with s.mu held.
+func (s *CSVStorage) createCSV(path, filePath string, orgID uuid.UUID) (io.WriteCloser, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if s.encryption == nil {
+		return &durableFile{File: file, durability: s.durability, path: filePath}, nil
+	}
+
+	key, err := s.encryption.dataKey(filePath, orgID, true)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer, err := csvcrypt.NewWriter(file, key, orgID[:])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &encryptedAppender{Writer: writer, file: file, durability: s.durability, path: filePath}, nil
+}
+
+// encryptFile atomically replaces a plaintext data file with an encrypted
+// copy. Must be called with s.mu held.
+func (s *CSVStorage) encryptFile(filePath string, orgID uuid.UUID) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer src.Close()
+
+	tmpPath := filePath + ".tmp"
+	dst, err := s.createCSV(tmpPath, filePath, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypted CSV file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to encrypt CSV file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt CSV file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to replace CSV file: %w", err)
+	}
+	return s.durability.SyncDir(s.dataDir)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/csvcrypt"
+	"github.com/google/uuid"
+)
+
+// newEncryptedCSVStorage creates CSV storage encrypting with a new master key
+func newEncryptedCSVStorage(t *testing.T, dir, layout string) (*CSVStorage, []byte) {
+	t.Helper()
+	store, err := NewCSVStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.SetLayout(layout); err != nil {
+		t.Fatalf("SetLayout failed: %v", err)
+	}
+	master, _ := csvcrypt.GenerateKey()
+	if err := store.SetEncryption(master); err != nil {
+		t.Fatalf("SetEncryption failed: %v", err)
+	}
+	return store, master
+}
+
+func TestCSVEncryptionRoundTrip(t *testing.T) {
+	for _, layout := range []string{CSVLayoutJSON, CSVLayoutWide} {
+		t.Run(layout, func(t *testing.T) {
+			store, _ := newEncryptedCSVStorage(t, t.TempDir(), layout)
+			orgID := uuid.New()
+
+			store.AppendData(orgID, map[string]interface{}{"report_name": "r1", "secret": "s3cr3t"})
+			store.AppendData(orgID, map[string]interface{}{"report_name": "r2", "secret": "s3cr3t", "count": 2.0})
+
+			filePath := filepath.Join(store.dataDir, orgID.String()+".csv")
+			content, _ := os.ReadFile(filePath)
+			if !csvcrypt.IsEncrypted(content) || strings.Contains(string(content), "s3cr3t") {
+				t.Fatal("Expected the data file to be encrypted")
+			}
+			info, err := os.Stat(keyPath(filePath))
+			if err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("Expected a data key file with mode 0600, got %v, %v", info, err)
+			}
+
+			uploads, err := store.GetOrgData(orgID)
+			if err != nil {
+				t.Fatalf("GetOrgData failed: %v", err)
+			}
+			if len(uploads) != 2 || uploads[1].ReportName != "r2" || uploads[1].Data["secret"] != "s3cr3t" || uploads[1].Data["count"] != 2.0 {
+				t.Errorf("Unexpected uploads: %+v", uploads)
+			}
+		})
+	}
+}
+
+func TestCSVEncryptionMigratesPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	orgID := uuid.New()
+
+	plain, _ := NewCSVStorage(dir)
+	plain.AppendData(orgID, map[string]interface{}{"name": "before"})
+
+	store, master := newEncryptedCSVStorage(t, dir, CSVLayoutJSON)
+	if uploads, err := store.GetOrgData(orgID); err != nil || len(uploads) != 1 {
+		t.Fatalf("Expected plaintext files to stay readable, got %d uploads, %v", len(uploads), err)
+	}
+	if err := store.AppendData(orgID, map[string]interface{}{"name": "after"}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dir, orgID.String()+".csv"))
+	if !csvcrypt.IsEncrypted(content) || strings.Contains(string(content), "before") {
+		t.Error("Expected the plaintext file to be encrypted on write")
+	}
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil || len(uploads) != 2 || uploads[0].Data["name"] != "before" {
+		t.Errorf("Expected both rows after migration, got %+v, %v", uploads, err)
+	}
+
+	// Without the key, or with another key, encrypted files are unreadable
+	if _, err := plain.GetOrgData(orgID); err == nil {
+		t.Error("Expected reading without the encryption key to fail")
+	}
+	if err := plain.AppendData(orgID, map[string]interface{}{"name": "plain"}); err == nil {
+		t.Error("Expected appending plaintext rows to an encrypted file to fail")
+	}
+	other, _ := newEncryptedCSVStorage(t, dir, CSVLayoutJSON)
+	if _, err := other.GetOrgData(orgID); err == nil {
+		t.Error("Expected reading with another master key to fail")
+	}
+
+	// A new storage with the same master key reads the data
+	reopened, _ := NewCSVStorage(dir)
+	reopened.SetEncryption(master)
+	if uploads, err := reopened.GetOrgData(orgID); err != nil || len(uploads) != 2 {
+		t.Errorf("Expected 2 uploads after reopening, got %d, %v", len(uploads), err)
+	}
+}
+
+func TestCSVEncryptionTamperingAndDeletion(t *testing.T) {
+	store, _ := newEncryptedCSVStorage(t, t.TempDir(), CSVLayoutWide)
+	orgID := uuid.New()
+	store.AppendData(orgID, map[string]interface{}{"name": "a"})
+	store.AppendData(orgID, map[string]interface{}{"name": "b", "zone": "eu-1"})
+
+	filePath := filepath.Join(store.dataDir, orgID.String()+".csv")
+	content, _ := os.ReadFile(filePath)
+	tampered := append([]byte{}, content...)
+	tampered[len(tampered)-1] ^= 1
+	os.WriteFile(filePath, tampered, 0644)
+	if _, err := store.GetOrgData(orgID); err == nil {
+		t.Error("Expected a modified file to fail authentication")
+	}
+	os.WriteFile(filePath, content, 0644)
+
+	if err := store.DeleteOrgData(orgID); err != nil {
+		t.Fatalf("DeleteOrgData failed: %v", err)
+	}
+	if _, err := os.Stat(keyPath(filePath)); !os.IsNotExist(err) {
+		t.Error("Expected the data key to be removed with the data")
+	}
+
+	// The org gets a new data key for new data
+	if err := store.AppendData(orgID, map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	if uploads, err := store.GetOrgData(orgID); err != nil || len(uploads) != 1 {
+		t.Errorf("Expected 1 upload after deletion, got %d, %v", len(uploads), err)
+	}
+}
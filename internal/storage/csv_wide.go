@@ -0,0 +1,569 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/google/uuid"
+)
+
+const (
+	// CSVLayoutJSON stores each row's data as a single JSON column
+	CSVLayoutJSON = "json"
+
+	// CSVLayoutWide flattens each attribute into its own column
+	CSVLayoutWide = "wide"
+)
+
+// wideFixedColumns are the leading columns of every wide-layout CSV file
+var wideFixedColumns = []string{"timestamp", "org_id", "report_name"}
+
+// wideTypesColumn is a reserved column holding the JSON types of a row's
+// attributes that are not plain strings, so numbers, booleans, nulls and
+// nested values survive flattening. It is added to a file like any other
+// column once the first row needs it.
+const wideTypesColumn = "_types"
+
+// Attribute types recorded in wideTypesColumn. Non-empty strings are not
+// recorded, so rows written before types were recorded read as strings.
+const (
+	wideTypeString = "string"
+	wideTypeNumber = "number"
+	wideTypeBool   = "bool"
+	wideTypeNull   = "null"
+	wideTypeJSON   = "json"
+)
+
+// HeaderManifest records the ordered column set of an org's wide-layout CSV file.
+// Columns are only ever appended, so positions stay stable over time.
+type HeaderManifest struct {
+	Columns   []string  `json:"columns"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetLayout selects how rows are written: CSVLayoutJSON (default) or CSVLayoutWide.
+// Reading always detects the layout from the file header.
+func (s *CSVStorage) SetLayout(layout string) error {
+	switch layout {
+	case CSVLayoutJSON, CSVLayoutWide:
+	default:
+		return fmt.Errorf("unsupported CSV layout: %s (supported: json, wide)", layout)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layout = layout
+	return nil
+}
+
+// SetFormat selects the CSV dialect of new files and rewrites. Rows appended
+// to an existing file keep that file's delimiter, and reading detects the
+// delimiter from each file's header.
+func (s *CSVStorage) SetFormat(format csvfmt.Format) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.format = format
+}
+
+// manifestPath returns the header manifest path for a CSV data file
+func manifestPath(filePath string) string {
+	return strings.TrimSuffix(filePath, ".csv") + ".header.json"
+}
+
+// loadManifest reads the header manifest for a CSV file, returning the fixed
+// columns if no manifest exists yet
+func loadManifest(filePath string) (*HeaderManifest, error) {
+	data, err := os.ReadFile(manifestPath(filePath))
+	if os.IsNotExist(err) {
+		return &HeaderManifest{Columns: append([]string{}, wideFixedColumns...)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header manifest: %w", err)
+	}
+
+	var manifest HeaderManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse header manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// saveManifest atomically writes the header manifest for a CSV file
+func (s *CSVStorage) saveManifest(filePath string, manifest *HeaderManifest) error {
+	manifest.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal header manifest: %w", err)
+	}
+
+	path := manifestPath(filePath)
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write header manifest: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = s.durability.Sync(file, path)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write header manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace header manifest: %w", err)
+	}
+	return s.durability.SyncDir(s.dataDir)
+}
+
+// evolveColumns appends any data keys missing from the manifest, in sorted
+// order, and reports whether the column set changed
+func evolveColumns(manifest *HeaderManifest, data map[string]interface{}) bool {
+	known := make(map[string]bool, len(manifest.Columns))
+	for _, column := range manifest.Columns {
+		known[column] = true
+	}
+
+	var added []string
+	for key := range data {
+		if !known[key] {
+			added = append(added, key)
+		}
+	}
+	if len(added) == 0 {
+		return false
+	}
+
+	sort.Strings(added)
+	manifest.Columns = append(manifest.Columns, added...)
+	return true
+}
+
+// readHeader returns the header row and delimiter of an organization's CSV
+// file, or a nil header if the file does not exist
+func (s *CSVStorage) readHeader(filePath string, orgID uuid.UUID) ([]string, rune, error) {
+	file, err := s.openCSV(filePath, orgID)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+
+	reader, delimiter := csvfmt.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, nil
+	}
+	return header, delimiter, nil
+}
+
+// sameColumns reports whether two column lists are identical
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendWide appends a row in wide layout, evolving the header when new
+// attributes appear. Must be called with s.mu held.
+func (s *CSVStorage) appendWide(filePath string, orgID uuid.UUID, timestamp time.Time, data map[string]interface{}) error {
+	manifest, err := loadManifest(filePath)
+	if err != nil {
+		return err
+	}
+
+	attributes := wideAttributes(data)
+
+	changed := evolveColumns(manifest, attributes)
+	if changed {
+		if err := s.saveManifest(filePath, manifest); err != nil {
+			return err
+		}
+	}
+
+	// Bring the file header in line with the manifest (new file, new columns,
+	// or a legacy JSON-layout file) before appending
+	header, delimiter, err := s.readHeader(filePath, orgID)
+	if err != nil {
+		return err
+	}
+	if header != nil && !sameColumns(header, manifest.Columns) {
+		if err := s.rewriteWide(filePath, orgID, manifest); err != nil {
+			return err
+		}
+		header = manifest.Columns
+		delimiter = s.format.Delimiter
+	}
+
+	file, err := s.appendCSV(filePath, orgID)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Rows appended to an existing file keep its delimiter
+	writer := s.format.NewWriter(file)
+	if header != nil {
+		writer = s.format.NewAppendWriter(file, delimiter)
+	}
+
+	if header == nil {
+		if err := writer.Write(manifest.Columns); err != nil {
+			return fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		if !changed {
+			if err := s.saveManifest(filePath, manifest); err != nil {
+				return err
+			}
+		}
+	}
+
+	reportName := ""
+	if name, ok := data["report_name"].(string); ok {
+		reportName = name
+	}
+
+	row := wideRow(manifest.Columns, timestamp, orgID, reportName, attributes)
+	if err := writer.Write(row); err != nil {
+		return fmt.Errorf("failed to write CSV row: %w", err)
+	}
+
+	return closeCSV(writer, file)
+}
+
+// wideRow renders a row in manifest column order
+func wideRow(columns []string, timestamp time.Time, orgID uuid.UUID, reportName string, attributes map[string]interface{}) []string {
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "timestamp":
+			row[i] = timestamp.Format(time.RFC3339)
+		case "org_id":
+			row[i] = orgID.String()
+		case "report_name":
+			row[i] = reportName
+		default:
+			row[i] = formatWideValue(attributes[column])
+		}
+	}
+	return row
+}
+
+// wideAttributes returns the attribute columns of a data row: every key but
+// report_name, which is a fixed column, plus the types column if any
+// attribute is not a non-empty string. A client-supplied types column is
+// replaced.
+func wideAttributes(data map[string]interface{}) map[string]interface{} {
+	attributes := make(map[string]interface{}, len(data)+1)
+	types := make(map[string]string)
+	for k, v := range data {
+		if k == "report_name" || k == wideTypesColumn {
+			continue
+		}
+		attributes[k] = v
+		if t := wideType(v); t != "" {
+			types[k] = t
+		}
+	}
+	if len(types) > 0 {
+		encoded, err := json.Marshal(types)
+		if err == nil {
+			attributes[wideTypesColumn] = string(encoded)
+		}
+	}
+	return attributes
+}
+
+// wideType returns the type recorded for an attribute value, or "" for
+// non-empty strings
+func wideType(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return wideTypeString
+		}
+		return ""
+	case nil:
+		return wideTypeNull
+	case bool:
+		return wideTypeBool
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return wideTypeNumber
+	default:
+		return wideTypeJSON
+	}
+}
+
+// formatWideValue renders an attribute value as a CSV field; numbers and
+// nested values are written as JSON
+func formatWideValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(encoded)
+	}
+}
+
+// restoreWideTypes converts the attributes of a wide row back to the types
+// recorded in its types column and removes that column. Values that do not
+// parse as their recorded type, such as hand-edited fields, stay strings.
+func restoreWideTypes(data map[string]interface{}) {
+	encoded, ok := data[wideTypesColumn].(string)
+	delete(data, wideTypesColumn)
+	if !ok {
+		return
+	}
+
+	var types map[string]string
+	if err := json.Unmarshal([]byte(encoded), &types); err != nil {
+		return
+	}
+
+	for column, t := range types {
+		if column == "report_name" {
+			continue
+		}
+		value, _ := data[column].(string)
+		switch t {
+		case wideTypeString:
+			data[column] = value
+		case wideTypeNull:
+			data[column] = nil
+		case wideTypeBool:
+			if b, err := strconv.ParseBool(value); err == nil {
+				data[column] = b
+			}
+		case wideTypeNumber, wideTypeJSON:
+			var v interface{}
+			if err := json.Unmarshal([]byte(value), &v); err == nil {
+				data[column] = v
+			}
+		}
+	}
+}
+
+// isLegacyJSONHeader reports whether a header belongs to the JSON layout
+// (current 4-column format or the original 3-column format)
+func isLegacyJSONHeader(header []string) bool {
+	return sameColumns(header, []string{"timestamp", "org_id", "report_name", "data"}) ||
+		sameColumns(header, []string{"timestamp", "org_id", "data"})
+}
+
+// parseWideRecords converts wide-layout records into uploads, padding rows
+// that were written before later columns were added. Malformed rows are
+// skipped and counted in rejected (which may be nil).
+func parseWideRecords(header []string, records [][]string, rejected *RejectedLines) []DataUpload {
+	uploads := make([]DataUpload, 0, len(records))
+	for _, record := range records {
+		// Pad short rows so every column has a value
+		for len(record) < len(header) {
+			record = append(record, "")
+		}
+
+		upload := DataUpload{Data: make(map[string]interface{})}
+		reason := ""
+		for i, column := range header {
+			value := record[i]
+			switch column {
+			case "timestamp":
+				timestamp, err := time.Parse(time.RFC3339, value)
+				if err != nil && reason == "" {
+					reason = RejectInvalidTimestamp
+				}
+				upload.Timestamp = timestamp
+			case "org_id":
+				orgID, err := uuid.Parse(value)
+				if err != nil && reason == "" {
+					reason = RejectInvalidOrgID
+				}
+				upload.OrgID = orgID
+			case "report_name":
+				upload.ReportName = value
+				if value != "" {
+					upload.Data["report_name"] = value
+				}
+			default:
+				if value != "" {
+					upload.Data[column] = value
+				}
+			}
+		}
+
+		if reason != "" {
+			rejected.add(reason)
+			continue
+		}
+		restoreWideTypes(upload.Data)
+		upload.Lineage = lineageFromData(upload.Data)
+		uploads = append(uploads, upload)
+	}
+	return uploads
+}
+
+// readAllUploads reads every upload from a CSV file in either layout.
+// Must be called with s.mu held.
+func (s *CSVStorage) readAllUploads(filePath string, orgID uuid.UUID) ([]DataUpload, error) {
+	return s.readUploads(filePath, orgID, nil)
+}
+
+// readUploads reads every upload from a CSV file in either layout, counting
+// skipped malformed rows in rejected (which may be nil).
+// Must be called with s.mu held.
+func (s *CSVStorage) readUploads(filePath string, orgID uuid.UUID, rejected *RejectedLines) ([]DataUpload, error) {
+	file, err := s.openCSV(filePath, orgID)
+	if os.IsNotExist(err) {
+		return []DataUpload{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+
+	reader, _ := csvfmt.NewReader(file)
+	// Rows written before header evolution have fewer fields
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	if len(records) == 0 {
+		return []DataUpload{}, nil
+	}
+
+	header := records[0]
+	if isLegacyJSONHeader(header) {
+		return parseJSONRecords(records[1:], rejected), nil
+	}
+	return parseWideRecords(header, records[1:], rejected), nil
+}
+
+// rewriteWide atomically rewrites a CSV file in wide layout using the
+// manifest columns, padding old rows and converting JSON-layout rows.
+// Must be called with s.mu held.
+func (s *CSVStorage) rewriteWide(filePath string, orgID uuid.UUID, manifest *HeaderManifest) error {
+	uploads, err := s.readAllUploads(filePath, orgID)
+	if err != nil {
+		return err
+	}
+
+	// Converted JSON rows may contribute columns the manifest has not seen
+	changed := false
+	rows := make([]map[string]interface{}, len(uploads))
+	for i, upload := range uploads {
+		rows[i] = wideAttributes(upload.Data)
+		if evolveColumns(manifest, rows[i]) {
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.saveManifest(filePath, manifest); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := filePath + ".tmp"
+	file, err := s.createCSV(tmpPath, filePath, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary CSV file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	writer := s.format.NewWriter(file)
+	if err := writer.Write(manifest.Columns); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	for i, upload := range uploads {
+		if err := writer.Write(wideRow(manifest.Columns, upload.Timestamp, orgID, upload.ReportName, rows[i])); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to flush CSV file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary CSV file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to replace CSV file: %w", err)
+	}
+	return s.durability.SyncDir(s.dataDir)
+}
+
+// NormalizeHeaders rewrites an organization's CSV file in wide layout with a
+// complete header matching its manifest, padding historical rows and
+// converting JSON-layout rows. Files that do not exist are left untouched.
+func (s *CSVStorage) NormalizeHeaders(orgID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filePath, err := s.sanitizeFilePath(orgID)
+	if err != nil {
+		return fmt.Errorf("invalid org ID for file path: %w", err)
+	}
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	manifest, err := loadManifest(filePath)
+	if err != nil {
+		return err
+	}
+	if err := s.rewriteWide(filePath, orgID, manifest); err != nil {
+		return err
+	}
+	return s.saveManifest(filePath, manifest)
+}
+
+// ListOrgs returns the IDs of all organizations that have a CSV data file
+func (s *CSVStorage) ListOrgs() ([]uuid.UUID, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dataDir, "*.csv"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CSV files: %w", err)
+	}
+
+	orgIDs := make([]uuid.UUID, 0, len(matches))
+	for _, match := range matches {
+		orgID, err := uuid.Parse(strings.TrimSuffix(filepath.Base(match), ".csv"))
+		if err != nil {
+			continue
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	return orgIDs, nil
+}
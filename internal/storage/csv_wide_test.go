@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/google/uuid"
+)
+
+func TestCSVWideHeaderEvolution(t *testing.T) {
+	store, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.SetLayout(CSVLayoutWide); err != nil {
+		t.Fatalf("SetLayout failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	if err := store.AppendData(orgID, map[string]interface{}{"provider": "aws", "name": "a"}); err != nil {
+		t.Fatalf("First append failed: %v", err)
+	}
+	if err := store.AppendData(orgID, map[string]interface{}{"provider": "aws", "name": "b", "zone": "eu-1"}); err != nil {
+		t.Fatalf("Second append failed: %v", err)
+	}
+
+	filePath := filepath.Join(store.dataDir, orgID.String()+".csv")
+	content, _ := os.ReadFile(filePath)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if lines[0] != "timestamp,org_id,report_name,name,provider,zone" {
+		t.Errorf("Expected evolved complete header, got %q", lines[0])
+	}
+	for i, line := range lines {
+		if strings.Count(line, ",") != 5 {
+			t.Errorf("Line %d is not padded to the full header: %q", i, line)
+		}
+	}
+
+	manifest, err := loadManifest(filePath)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(manifest.Columns) != 6 {
+		t.Errorf("Expected 6 manifest columns, got %v", manifest.Columns)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(uploads))
+	}
+	if _, ok := uploads[0].Data["zone"]; ok {
+		t.Error("Padded column must not appear in rows written before it existed")
+	}
+	if uploads[1].Data["zone"] != "eu-1" {
+		t.Errorf("Expected zone on second row, got %v", uploads[1].Data)
+	}
+}
+
+func TestCSVWideReadPadsShortRows(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCSVStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	orgID := uuid.New()
+	content := "timestamp,org_id,report_name,name,zone\n" +
+		"2025-05-01T00:00:00Z," + orgID.String() + ",,a\n" +
+		"2025-05-02T00:00:00Z," + orgID.String() + ",,b,eu-1\n"
+	if err := os.WriteFile(filepath.Join(dir, orgID.String()+".csv"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if len(uploads) != 2 || uploads[0].Data["name"] != "a" {
+		t.Errorf("Expected short row to be padded and returned, got %+v", uploads)
+	}
+}
+
+func TestCSVNormalizeHeadersConvertsJSONLayout(t *testing.T) {
+	store, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	orgID := uuid.New()
+	if err := store.AppendData(orgID, map[string]interface{}{"provider": "aws", "report_name": "r1"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	if err := store.NormalizeHeaders(orgID); err != nil {
+		t.Fatalf("NormalizeHeaders failed: %v", err)
+	}
+
+	header, _, err := store.readHeader(filepath.Join(store.dataDir, orgID.String()+".csv"), orgID)
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if strings.Join(header, ",") != "timestamp,org_id,report_name,provider" {
+		t.Errorf("Unexpected normalized header: %v", header)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("Expected 1 upload after normalization, got %d (err=%v)", len(uploads), err)
+	}
+	if uploads[0].ReportName != "r1" || uploads[0].Data["provider"] != "aws" {
+		t.Errorf("Data lost during normalization: %+v", uploads[0])
+	}
+
+	// JSON-layout appends must not corrupt a converted file
+	if err := store.AppendData(orgID, map[string]interface{}{"provider": "gcp"}); err == nil {
+		t.Error("Expected JSON-layout append to wide file to fail")
+	}
+}
+
+func TestCSVWidePreservesTypes(t *testing.T) {
+	store, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.SetLayout(CSVLayoutWide); err != nil {
+		t.Fatalf("SetLayout failed: %v", err)
+	}
+
+	orgID := uuid.New()
+	if err := store.AppendData(orgID, map[string]interface{}{"name": "a", "zone": "eu-1"}); err != nil {
+		t.Fatalf("First append failed: %v", err)
+	}
+	row := map[string]interface{}{
+		"name":    "b",
+		"count":   float64(3),
+		"ratio":   0.5,
+		"enabled": false,
+		"zone":    nil,
+		"note":    "",
+		"tags":    map[string]interface{}{"env": "prod"},
+		"ports":   []interface{}{float64(80), float64(443)},
+		"version": "1.0",
+	}
+	if err := store.AppendData(orgID, row); err != nil {
+		t.Fatalf("Second append failed: %v", err)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(uploads))
+	}
+	if _, ok := uploads[0].Data[wideTypesColumn]; ok {
+		t.Error("Types column must not be returned as an attribute")
+	}
+	if uploads[0].Data["zone"] != "eu-1" {
+		t.Errorf("Expected string row to stay unchanged, got %v", uploads[0].Data)
+	}
+
+	got, _ := json.Marshal(uploads[1].Data)
+	want, _ := json.Marshal(row)
+	if string(got) != string(want) {
+		t.Errorf("Expected typed attributes %s, got %s", want, got)
+	}
+
+	// Rewriting the file keeps the recorded types
+	if err := store.NormalizeHeaders(orgID); err != nil {
+		t.Fatalf("NormalizeHeaders failed: %v", err)
+	}
+	uploads, err = store.GetOrgData(orgID)
+	if err != nil || len(uploads) != 2 {
+		t.Fatalf("Expected 2 uploads after normalization, got %d (err=%v)", len(uploads), err)
+	}
+	if got, _ := json.Marshal(uploads[1].Data); string(got) != string(want) {
+		t.Errorf("Expected typed attributes after normalization %s, got %s", want, got)
+	}
+}
+
+func TestCSVFormatKeepsExistingDelimiter(t *testing.T) {
+	for _, layout := range []string{CSVLayoutJSON, CSVLayoutWide} {
+		store, err := NewCSVStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if err := store.SetLayout(layout); err != nil {
+			t.Fatalf("SetLayout failed: %v", err)
+		}
+		store.SetFormat(csvfmt.Format{Delimiter: ';', BOM: true})
+
+		orgID := uuid.New()
+		if err := store.AppendData(orgID, map[string]interface{}{"provider": "aws", "note": "a;b"}); err != nil {
+			t.Fatalf("%s: first append failed: %v", layout, err)
+		}
+
+		// Changing the deployment dialect must not mix delimiters in a file
+		store.SetFormat(csvfmt.Default)
+		if err := store.AppendData(orgID, map[string]interface{}{"provider": "gcp", "note": "c,d"}); err != nil {
+			t.Fatalf("%s: second append failed: %v", layout, err)
+		}
+
+		content, _ := os.ReadFile(filepath.Join(store.dataDir, orgID.String()+".csv"))
+		if !strings.HasPrefix(string(content), "\xEF\xBB\xBFtimestamp;org_id;") {
+			t.Errorf("%s: expected BOM and semicolon header, got %q", layout, content)
+		}
+		if strings.Count(string(content), "\xEF\xBB\xBF") != 1 {
+			t.Errorf("%s: BOM must only be written once", layout)
+		}
+
+		uploads, err := store.GetOrgData(orgID)
+		if err != nil {
+			t.Fatalf("%s: GetOrgData failed: %v", layout, err)
+		}
+		if len(uploads) != 2 || uploads[0].Data["note"] != "a;b" || uploads[1].Data["note"] != "c,d" {
+			t.Errorf("%s: unexpected uploads %+v", layout, uploads)
+		}
+	}
+}
@@ -1,24 +1,172 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"sync"
 
+	"github.com/eterrain/tf-backend-service/internal/faults"
 	"github.com/google/uuid"
 )
 
-// DualStorage implements storage that writes to both CSV and MySQL
+// dualMySQL is the MySQL side of dual storage, an interface so dual storage
+// can be tested without a database
+type dualMySQL interface {
+	DataStorage
+	FieldSelector
+	Summarizer
+	Prober
+	Close() error
+}
+
+// DualStorage implements storage that writes to both CSV and MySQL.
+//
+// Reads go to CSV (the primary source) unless a CSV write of the
+// organization failed while the MySQL write succeeded; then they go to
+// MySQL, which has all rows, so clients read their own writes. When both
+// backends missed writes, rows of both are merged. The missed writes are
+// tracked in memory until the organization's data is deleted, so after a
+// restart reads go to CSV again.
 type DualStorage struct {
 	csv   *CSVStorage
-	mysql *MySQLStorage
+	mysql dualMySQL
+
+	// Organizations whose writes failed on one backend but not the other
+	missedMu    sync.RWMutex
+	csvMissed   map[uuid.UUID]bool
+	mysqlMissed map[uuid.UUID]bool
+
+	// Optional fault injection for resilience tests
+	faults *faults.Injector
 }
 
 // NewDualStorage creates a new dual storage backend (CSV + MySQL)
 func NewDualStorage(csv *CSVStorage, mysql *MySQLStorage) *DualStorage {
+	return newDualStorage(csv, mysql)
+}
+
+// newDualStorage creates a dual storage backend with any MySQL side
+func newDualStorage(csv *CSVStorage, mysql dualMySQL) *DualStorage {
 	return &DualStorage{
-		csv:   csv,
-		mysql: mysql,
+		csv:         csv,
+		mysql:       mysql,
+		csvMissed:   make(map[uuid.UUID]bool),
+		mysqlMissed: make(map[uuid.UUID]bool),
+	}
+}
+
+// setMissed records whether the CSV and MySQL backends are missing rows of
+// the organization
+func (s *DualStorage) setMissed(orgID uuid.UUID, csvMissed, mysqlMissed bool) {
+	s.missedMu.Lock()
+	defer s.missedMu.Unlock()
+	if csvMissed {
+		s.csvMissed[orgID] = true
+	} else {
+		delete(s.csvMissed, orgID)
+	}
+	if mysqlMissed {
+		s.mysqlMissed[orgID] = true
+	} else {
+		delete(s.mysqlMissed, orgID)
+	}
+}
+
+// missed reports whether the CSV and MySQL backends are missing rows of
+// the organization
+func (s *DualStorage) missed(orgID uuid.UUID) (csvMissed, mysqlMissed bool) {
+	s.missedMu.RLock()
+	defer s.missedMu.RUnlock()
+	return s.csvMissed[orgID], s.mysqlMissed[orgID]
+}
+
+// Divergence returns the number of organizations whose rows are missing
+// from CSV and from MySQL because writes failed on one backend
+func (s *DualStorage) Divergence() (csvMissing, mysqlMissing int) {
+	s.missedMu.RLock()
+	defer s.missedMu.RUnlock()
+	return len(s.csvMissed), len(s.mysqlMissed)
+}
+
+// readCSV reads the organization's rows from CSV
+func (s *DualStorage) readCSV(orgID uuid.UUID) ([]DataUpload, error) {
+	if err := s.faults.Inject(faults.LayerCSV, orgID); err != nil {
+		return nil, err
+	}
+	return s.csv.GetOrgData(orgID)
+}
+
+// readMySQL reads the organization's rows from MySQL
+func (s *DualStorage) readMySQL(orgID uuid.UUID) ([]DataUpload, error) {
+	if err := s.faults.Inject(faults.LayerMySQL, orgID); err != nil {
+		return nil, err
+	}
+	return s.mysql.GetOrgData(orgID)
+}
+
+// dualRead reads with fromCSV or fromMySQL, whichever backend has all of
+// the organization's rows, falling back to the other one if the read fails
+func dualRead[T any](s *DualStorage, orgID uuid.UUID, fromCSV, fromMySQL func() (T, error)) (T, error) {
+	first, second := fromCSV, fromMySQL
+	firstName, secondName := "CSV", "MySQL"
+	if csvMissed, _ := s.missed(orgID); csvMissed {
+		first, second = fromMySQL, fromCSV
+		firstName, secondName = "MySQL", "CSV"
+	}
+
+	result, err := first()
+	if err == nil {
+		return result, nil
+	}
+	slog.Warn("Storage read failed, falling back", "org_id", orgID, "backend", firstName, "fallback", secondName, "error", err)
+	return second()
+}
+
+// mergedOrgData reads the organization's rows from both backends when each
+// is missing rows of the other. Rows are matched by their data, so a row
+// stored in both backends is returned once; rows are ordered by time.
+func (s *DualStorage) mergedOrgData(orgID uuid.UUID) ([]DataUpload, error) {
+	csvData, csvErr := s.readCSV(orgID)
+	mysqlData, mysqlErr := s.readMySQL(orgID)
+	if csvErr != nil && mysqlErr != nil {
+		return nil, fmt.Errorf("both CSV and MySQL reads failed: CSV error: %v, MySQL error: %v", csvErr, mysqlErr)
+	}
+	if csvErr != nil {
+		slog.Warn("CSV storage read failed, returning MySQL rows only", "org_id", orgID, "error", csvErr)
+		return mysqlData, nil
+	}
+	if mysqlErr != nil {
+		slog.Warn("MySQL storage read failed, returning CSV rows only", "org_id", orgID, "error", mysqlErr)
+		return csvData, nil
+	}
+
+	// Count the CSV rows by content and add each MySQL row not among them
+	seen := make(map[string]int, len(csvData))
+	for _, upload := range csvData {
+		seen[rowKey(upload)]++
 	}
+	merged := csvData
+	for _, upload := range mysqlData {
+		key := rowKey(upload)
+		if seen[key] > 0 {
+			seen[key]--
+			continue
+		}
+		merged = append(merged, upload)
+	}
+	slices.SortStableFunc(merged, func(a, b DataUpload) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return merged, nil
+}
+
+// rowKey identifies a row by its data; map keys are encoded in sorted
+// order, so equal rows from either backend have equal keys
+func rowKey(upload DataUpload) string {
+	encoded, _ := json.Marshal(upload.Data)
+	return string(encoded)
 }
 
 // AppendData appends data to both CSV and MySQL storage
@@ -27,15 +175,27 @@ func (s *DualStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) e
 	var csvErr, mysqlErr error
 
 	// Write to CSV
-	csvErr = s.csv.AppendData(orgID, data)
+	csvErr = s.faults.Inject(faults.LayerCSV, orgID)
+	if csvErr == nil {
+		csvErr = s.csv.AppendData(orgID, data)
+	}
 	if csvErr != nil {
-		log.Printf("ERROR: Failed to write to CSV storage for org %s: %v", orgID, csvErr)
+		slog.Error("Failed to write to CSV storage", "org_id", orgID, "error", csvErr)
 	}
 
 	// Write to MySQL
-	mysqlErr = s.mysql.AppendData(orgID, data)
+	mysqlErr = s.faults.Inject(faults.LayerMySQL, orgID)
+	if mysqlErr == nil {
+		mysqlErr = s.mysql.AppendData(orgID, data)
+	}
 	if mysqlErr != nil {
-		log.Printf("ERROR: Failed to write to MySQL storage for org %s: %v", orgID, mysqlErr)
+		slog.Error("Failed to write to MySQL storage", "org_id", orgID, "error", mysqlErr)
+	}
+
+	// Route reads away from a backend that missed the row
+	if (csvErr == nil) != (mysqlErr == nil) {
+		csvMissed, mysqlMissed := s.missed(orgID)
+		s.setMissed(orgID, csvMissed || csvErr != nil, mysqlMissed || mysqlErr != nil)
 	}
 
 	// Return error if both failed
@@ -54,19 +214,94 @@ func (s *DualStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) e
 	return nil
 }
 
-// GetOrgData retrieves data from CSV storage (primary source)
-// Falls back to MySQL if CSV fails
+// GetOrgData retrieves data from the backend with all of the
+// organization's rows (CSV unless it missed a write), falling back to the
+// other backend if the read fails
 func (s *DualStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
-	// Try CSV first
-	data, err := s.csv.GetOrgData(orgID)
-	if err == nil {
-		return data, nil
+	if csvMissed, mysqlMissed := s.missed(orgID); csvMissed && mysqlMissed {
+		return s.mergedOrgData(orgID)
 	}
+	return dualRead(s, orgID, func() ([]DataUpload, error) {
+		return s.readCSV(orgID)
+	}, func() ([]DataUpload, error) {
+		return s.readMySQL(orgID)
+	})
+}
 
-	log.Printf("WARNING: Failed to read from CSV storage for org %s: %v, falling back to MySQL", orgID, err)
+// GetOrgDataFields retrieves the given fields like GetOrgData, projecting
+// CSV rows in memory and MySQL rows in the query
+func (s *DualStorage) GetOrgDataFields(orgID uuid.UUID, fields []string) ([]DataUpload, error) {
+	if csvMissed, mysqlMissed := s.missed(orgID); csvMissed && mysqlMissed {
+		data, err := s.mergedOrgData(orgID)
+		if err != nil {
+			return nil, err
+		}
+		return projectUploads(data, fields), nil
+	}
+	return dualRead(s, orgID, func() ([]DataUpload, error) {
+		data, err := s.readCSV(orgID)
+		if err != nil {
+			return nil, err
+		}
+		return projectUploads(data, fields), nil
+	}, func() ([]DataUpload, error) {
+		if err := s.faults.Inject(faults.LayerMySQL, orgID); err != nil {
+			return nil, err
+		}
+		return s.mysql.GetOrgDataFields(orgID, fields)
+	})
+}
 
-	// Fall back to MySQL
-	return s.mysql.GetOrgData(orgID)
+// SummarizeOrgData aggregates data like GetOrgData reads it, in memory for
+// CSV and in the query for MySQL
+func (s *DualStorage) SummarizeOrgData(orgID uuid.UUID, query SummaryQuery) ([]SummaryGroup, error) {
+	if csvMissed, mysqlMissed := s.missed(orgID); csvMissed && mysqlMissed {
+		data, err := s.mergedOrgData(orgID)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeUploads(data, query), nil
+	}
+	return dualRead(s, orgID, func() ([]SummaryGroup, error) {
+		data, err := s.readCSV(orgID)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeUploads(data, query), nil
+	}, func() ([]SummaryGroup, error) {
+		if err := s.faults.Inject(faults.LayerMySQL, orgID); err != nil {
+			return nil, err
+		}
+		return s.mysql.SummarizeOrgData(orgID, query)
+	})
+}
+
+// DeleteOrgData removes data from both CSV and MySQL storage
+func (s *DualStorage) DeleteOrgData(orgID uuid.UUID) error {
+	csvErr := s.faults.Inject(faults.LayerCSV, orgID)
+	if csvErr == nil {
+		csvErr = s.csv.DeleteOrgData(orgID)
+	}
+	mysqlErr := s.faults.Inject(faults.LayerMySQL, orgID)
+	if mysqlErr == nil {
+		mysqlErr = s.mysql.DeleteOrgData(orgID)
+	}
+
+	// A backend whose delete failed still has rows the other one dropped;
+	// one whose delete succeeded is no longer missing any
+	s.setMissed(orgID, csvErr != nil, mysqlErr != nil)
+
+	if csvErr != nil && mysqlErr != nil {
+		return fmt.Errorf("both CSV and MySQL deletes failed: CSV error: %v, MySQL error: %v", csvErr, mysqlErr)
+	}
+	if csvErr != nil {
+		return fmt.Errorf("CSV delete failed: %w", csvErr)
+	}
+	if mysqlErr != nil {
+		return fmt.Errorf("MySQL delete failed: %w", mysqlErr)
+	}
+
+	return nil
 }
 
 // Close closes both storage backends
@@ -74,3 +309,14 @@ func (s *DualStorage) Close() error {
 	// MySQL needs to be closed, CSV doesn't have a Close method
 	return s.mysql.Close()
 }
+
+// OrgDataSize returns the size of the organization's data in CSV storage
+// (the primary source)
+func (s *DualStorage) OrgDataSize(orgID uuid.UUID) (int64, error) {
+	return s.csv.OrgDataSize(orgID)
+}
+
+// CheckIntegrity reports malformed lines in CSV storage (the primary source)
+func (s *DualStorage) CheckIntegrity(orgID uuid.UUID) (RejectedLines, error) {
+	return s.csv.CheckIntegrity(orgID)
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/google/uuid"
+)
+
+// memoryMySQL stands in for MySQL in dual storage tests
+type memoryMySQL struct {
+	*MemoryDataStorage
+}
+
+func (m memoryMySQL) GetOrgDataFields(orgID uuid.UUID, fields []string) ([]DataUpload, error) {
+	data, err := m.GetOrgData(orgID)
+	return projectUploads(data, fields), err
+}
+
+func (m memoryMySQL) SummarizeOrgData(orgID uuid.UUID, query SummaryQuery) ([]SummaryGroup, error) {
+	data, err := m.GetOrgData(orgID)
+	return summarizeUploads(data, query), err
+}
+
+func (m memoryMySQL) Probe() error { return nil }
+
+func (m memoryMySQL) Close() error { return nil }
+
+// newTestDualStorage returns dual storage over a temporary CSV directory
+// and in-memory MySQL, with a fault injector to fail either backend
+func newTestDualStorage(t *testing.T) (*DualStorage, *faults.Injector) {
+	t.Helper()
+	csv, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCSVStorage failed: %v", err)
+	}
+	store := newDualStorage(csv, memoryMySQL{NewMemoryDataStorage()})
+	injector := faults.NewInjector()
+	store.SetFaultInjector(injector)
+	return store, injector
+}
+
+// failLayer makes every call of the layer fail for the organization, or
+// clears all faults when layer is empty
+func failLayer(t *testing.T, injector *faults.Injector, layer string, orgID uuid.UUID) {
+	t.Helper()
+	var rules []faults.Rule
+	if layer != "" {
+		rules = []faults.Rule{{Layer: layer, OrgID: orgID, Fault: faults.Fault{ErrorRate: 1}}}
+	}
+	if err := injector.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reportNames returns the report names of rows in order
+func reportNames(uploads []DataUpload) []string {
+	names := make([]string, len(uploads))
+	for i, upload := range uploads {
+		names[i], _ = upload.Data["report_name"].(string)
+	}
+	return names
+}
+
+func TestDualStorageReadsYourWritesAfterCSVFailure(t *testing.T) {
+	store, injector := newTestDualStorage(t)
+	orgID, otherOrgID := uuid.New(), uuid.New()
+
+	if err := store.AppendData(orgID, map[string]interface{}{"report_name": "a"}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	failLayer(t, injector, faults.LayerCSV, orgID)
+	if err := store.AppendData(orgID, map[string]interface{}{"report_name": "b"}); err == nil {
+		t.Fatal("Expected an error for the failed CSV write")
+	}
+	failLayer(t, injector, "", uuid.Nil)
+
+	data, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if names := reportNames(data); len(names) != 2 || names[1] != "b" {
+		t.Errorf("Expected the row saved only to MySQL to be read back, got %v", names)
+	}
+	if csvMissing, mysqlMissing := store.Divergence(); csvMissing != 1 || mysqlMissing != 0 {
+		t.Errorf("Divergence = %d, %d; want 1, 0", csvMissing, mysqlMissing)
+	}
+
+	groups, err := store.SummarizeOrgData(orgID, SummaryQuery{})
+	if err != nil || len(groups) != 1 || groups[0].Count != 2 {
+		t.Errorf("Expected a summary of 2 rows, got %+v, %v", groups, err)
+	}
+
+	// Reads of MySQL fall back to CSV, which is missing a row but is better
+	// than failing
+	failLayer(t, injector, faults.LayerMySQL, orgID)
+	if data, err := store.GetOrgData(orgID); err != nil || len(data) != 1 {
+		t.Errorf("Expected the CSV rows when MySQL fails, got %d rows, %v", len(data), err)
+	}
+	failLayer(t, injector, "", uuid.Nil)
+
+	// Other organizations still read CSV
+	if err := store.AppendData(otherOrgID, map[string]interface{}{"report_name": "c"}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	failLayer(t, injector, faults.LayerMySQL, otherOrgID)
+	if data, err := store.GetOrgData(otherOrgID); err != nil || len(data) != 1 {
+		t.Errorf("Expected CSV to serve an organization without failed writes, got %d rows, %v", len(data), err)
+	}
+}
+
+func TestDualStorageMergesWhenBothBackendsMissedWrites(t *testing.T) {
+	store, injector := newTestDualStorage(t)
+	orgID := uuid.New()
+
+	if err := store.AppendData(orgID, map[string]interface{}{"report_name": "a", "cost": 1.0}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	failLayer(t, injector, faults.LayerMySQL, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "b", "cost": 2.0})
+	failLayer(t, injector, faults.LayerCSV, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "c", "cost": 4.0})
+	failLayer(t, injector, "", uuid.Nil)
+
+	data, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if names := reportNames(data); len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Errorf("Expected each row once in time order, got %v", names)
+	}
+
+	fields, err := store.GetOrgDataFields(orgID, []string{"cost"})
+	if err != nil || len(fields) != 3 {
+		t.Errorf("Expected 3 projected rows, got %d, %v", len(fields), err)
+	}
+
+	groups, err := store.SummarizeOrgData(orgID, SummaryQuery{Aggregations: []Aggregation{{Func: AggregateSum, Field: "cost"}}})
+	if err != nil || len(groups) != 1 || *groups[0].Values["sum:cost"] != 7 {
+		t.Errorf("Expected a sum of 7 over the merged rows, got %+v, %v", groups, err)
+	}
+
+	// Deleting from both backends leaves nothing to merge
+	if err := store.DeleteOrgData(orgID); err != nil {
+		t.Fatalf("DeleteOrgData failed: %v", err)
+	}
+	if csvMissing, mysqlMissing := store.Divergence(); csvMissing != 0 || mysqlMissing != 0 {
+		t.Errorf("Divergence after delete = %d, %d; want 0, 0", csvMissing, mysqlMissing)
+	}
+}
+
+func TestDualStorageKeepsDuplicateRows(t *testing.T) {
+	store, injector := newTestDualStorage(t)
+	orgID := uuid.New()
+
+	// The same row uploaded twice is two rows, not one row in each backend
+	row := map[string]interface{}{"report_name": "a"}
+	store.AppendData(orgID, row)
+	store.AppendData(orgID, row)
+	failLayer(t, injector, faults.LayerMySQL, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "b"})
+	failLayer(t, injector, faults.LayerCSV, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "c"})
+	failLayer(t, injector, "", uuid.Nil)
+
+	data, err := store.GetOrgData(orgID)
+	if err != nil {
+		t.Fatalf("GetOrgData failed: %v", err)
+	}
+	if len(data) != 4 {
+		t.Errorf("Expected 4 rows, got %v", reportNames(data))
+	}
+}
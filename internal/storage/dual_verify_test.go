@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/google/uuid"
+)
+
+func TestDualStorageVerify(t *testing.T) {
+	store, injector := newTestDualStorage(t)
+	orgID := uuid.New()
+
+	for _, name := range []string{"a", "b"} {
+		if err := store.AppendData(orgID, map[string]interface{}{"report_name": name, "count": 1}); err != nil {
+			t.Fatalf("AppendData failed: %v", err)
+		}
+	}
+
+	report, err := store.Verify(orgID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !report.Consistent() || report.CSVRows != 2 || report.MySQLRows != 2 || report.CSVHash != report.MySQLHash {
+		t.Errorf("Expected consistent sides, got %+v", report)
+	}
+
+	// Each side misses one write
+	failLayer(t, injector, faults.LayerCSV, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "mysql-only"})
+	failLayer(t, injector, faults.LayerMySQL, orgID)
+	store.AppendData(orgID, map[string]interface{}{"report_name": "csv-only"})
+	failLayer(t, injector, "", uuid.Nil)
+
+	report, err = store.Verify(orgID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if report.Consistent() || report.CSVRows != 3 || report.MySQLRows != 3 || report.CSVHash == report.MySQLHash {
+		t.Fatalf("Expected diverged sides, got %+v", report)
+	}
+	missing := make(map[string]string)
+	for _, d := range report.Discrepancies {
+		missing[d.Data["report_name"].(string)] = d.MissingFrom
+		if len(d.Hash) != 64 {
+			t.Errorf("Expected a SHA-256 row hash, got %q", d.Hash)
+		}
+	}
+	if len(missing) != 2 || missing["mysql-only"] != SideCSV || missing["csv-only"] != SideMySQL {
+		t.Errorf("Unexpected discrepancies %+v", report.Discrepancies)
+	}
+
+	// Rows outside the range are not compared
+	report, err = store.Verify(orgID, time.Now().Add(time.Hour), time.Time{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !report.Consistent() || report.CSVRows != 0 || report.MySQLRows != 0 {
+		t.Errorf("Expected no rows in a future range, got %+v", report)
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"os"
+
+	"github.com/eterrain/tf-backend-service/internal/durability"
+)
+
+// SetDurability selects when CSV data files, header manifests and data keys
+// are synced to disk. Without it, writes are flushed to the OS but not
+// synced, and data keys are synced.
+func (s *CSVStorage) SetDurability(policy *durability.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.durability = policy
+	if s.encryption != nil {
+		s.encryption.durability = policy
+	}
+}
+
+// SetDurability selects when state files are synced to disk. Lock files
+// are synced unless the level is none. Without it, state files are flushed
+// to the OS but not synced. It must be called before the storage is used.
+func (f *FileStateStorage) SetDurability(policy *durability.Policy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.durability = policy
+}
+
+// durableFile syncs a file as the durability level requires when closed
+type durableFile struct {
+	*os.File
+	durability *durability.Policy
+	path       string // Final path of the file
+	closed     bool
+}
+
+// Close syncs and closes the file
+func (f *durableFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	err := f.durability.Sync(f.File, f.path)
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package storage
+
+import "github.com/eterrain/tf-backend-service/internal/faults"
+
+// FaultInjectable is implemented by backends that support fault injection
+type FaultInjectable interface {
+	SetFaultInjector(injector *faults.Injector)
+}
+
+// SetFaultInjector injects faults into state operations (the storage.state
+// layer) for resilience tests. It must be called before the storage is used.
+func (m *MemoryStorage) SetFaultInjector(injector *faults.Injector) {
+	m.faults = injector
+}
+
+// SetFaultInjector injects faults into the CSV and MySQL backends (the
+// storage.csv and storage.mysql layers), so the fallbacks between them can
+// be tested. It must be called before the storage is used.
+func (s *DualStorage) SetFaultInjector(injector *faults.Injector) {
+	s.faults = injector
+}
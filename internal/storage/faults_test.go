@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/google/uuid"
+)
+
+func TestMemoryStorageFaultInjection(t *testing.T) {
+	orgID := uuid.New()
+	store := NewMemoryStorage()
+	injector := faults.NewInjector()
+	store.SetFaultInjector(injector)
+
+	if err := store.PutState(orgID, "prod", []byte(`{"version":4}`)); err != nil {
+		t.Fatalf("PutState failed without faults: %v", err)
+	}
+
+	if err := injector.SetRules([]faults.Rule{{Layer: faults.LayerState, OrgID: orgID, Fault: faults.Fault{ErrorRate: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetState(orgID, "prod"); !errors.Is(err, faults.ErrInjected) {
+		t.Errorf("Expected an injected error, got %v", err)
+	}
+	if err := store.Probe(); err != nil {
+		t.Errorf("Expected faults of one org to leave the probe alone, got %v", err)
+	}
+
+	injector.SetRules(nil)
+	if _, err := store.GetState(orgID, "prod"); err != nil {
+		t.Errorf("Expected the state after clearing faults, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MaxSelectedFields limits the fields a data query can select
+const MaxSelectedFields = 50
+
+// fieldRegex matches a field: attribute keys separated by dots, where a dot
+// may also be part of a key
+var fieldRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// FieldSelector is implemented by data storage backends that can return
+// only selected fields of each row, e.g. with a SQL projection
+type FieldSelector interface {
+	// GetOrgDataFields retrieves all data for an organization with only the
+	// given fields in each row's data
+	GetOrgDataFields(orgID uuid.UUID, fields []string) ([]DataUpload, error)
+}
+
+// ParseFields parses a comma-separated field list such as
+// "resource_name,provider,tags.env", dropping duplicates
+func ParseFields(list string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if len(field) > 200 || !fieldRegex.MatchString(field) {
+			return nil, fmt.Errorf("invalid field %q: only alphanumeric characters, hyphens and underscores separated by dots allowed", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("at least one field is required")
+	}
+	if len(fields) > MaxSelectedFields {
+		return nil, fmt.Errorf("too many fields: maximum %d", MaxSelectedFields)
+	}
+	return fields, nil
+}
+
+// SelectOrgDataFields retrieves the organization's data with only the given
+// fields, projecting in the backend if it implements FieldSelector and
+// filtering the full rows otherwise
+func SelectOrgDataFields(store DataStorage, orgID uuid.UUID, fields []string) ([]DataUpload, error) {
+	if selector, ok := store.(FieldSelector); ok {
+		return selector.GetOrgDataFields(orgID, fields)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil {
+		return nil, err
+	}
+	return projectUploads(uploads, fields), nil
+}
+
+// projectUploads replaces the data of every upload with the given fields
+func projectUploads(uploads []DataUpload, fields []string) []DataUpload {
+	for i := range uploads {
+		uploads[i].Data = ProjectFields(uploads[i].Data, fields)
+	}
+	return uploads
+}
+
+// ProjectFields returns the given fields of a data row, keyed by field.
+// A field names a key, which may contain dots, or a path into nested
+// objects such as "tags.env". Missing fields are omitted.
+func ProjectFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := lookupField(data, field); ok {
+			projected[field] = value
+		}
+	}
+	return projected
+}
+
+// lookupField returns the value of the first of the field's paths that
+// exists in the row
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	for _, path := range fieldPaths(field) {
+		var current interface{} = data
+		found := true
+		for _, key := range path {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if current, ok = object[key]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			return current, true
+		}
+	}
+	return nil, false
+}
+
+// fieldPaths returns the key paths a field can refer to, in the order they
+// are tried: the field as a single key, then splitting off one more leading
+// segment at a time, e.g. "a.b.c", then "a" > "b.c", then "a" > "b" > "c"
+func fieldPaths(field string) [][]string {
+	segments := strings.Split(field, ".")
+	paths := make([][]string, 0, len(segments))
+	for i := range segments {
+		path := append([]string{}, segments[:i]...)
+		paths = append(paths, append(path, strings.Join(segments[i:], ".")))
+	}
+	return paths
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields(" resource_name,provider,,tags.env,provider ")
+	if err != nil {
+		t.Fatalf("ParseFields failed: %v", err)
+	}
+	if want := []string{"resource_name", "provider", "tags.env"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Expected %v, got %v", want, fields)
+	}
+
+	for _, invalid := range []string{"", ",", "name;drop", "tags..env", ".env", `a"b`} {
+		if _, err := ParseFields(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestProjectFields(t *testing.T) {
+	data := map[string]interface{}{
+		"resource_name": "web-1",
+		"provider":      "aws",
+		"dotted.key":    "literal",
+		"tags":          map[string]interface{}{"env": "prod", "team": "a"},
+		"a":             map[string]interface{}{"b.c": 1.0},
+	}
+
+	projected := ProjectFields(data, []string{"resource_name", "tags.env", "dotted.key", "a.b.c", "missing", "tags.missing"})
+	want := map[string]interface{}{
+		"resource_name": "web-1",
+		"tags.env":      "prod",
+		"dotted.key":    "literal",
+		"a.b.c":         1.0,
+	}
+	if !reflect.DeepEqual(projected, want) {
+		t.Errorf("Expected %v, got %v", want, projected)
+	}
+
+	paths := fieldPaths("a.b.c")
+	wantPaths := [][]string{{"a.b.c"}, {"a", "b.c"}, {"a", "b", "c"}}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Expected paths %v, got %v", wantPaths, paths)
+	}
+	if got := jsonPath([]string{"a", "b.c"}); got != `$."a"."b.c"` {
+		t.Errorf("Unexpected JSON path %s", got)
+	}
+}
+
+func TestSelectOrgDataFields(t *testing.T) {
+	store := NewMemoryDataStorage()
+	orgID := uuid.New()
+	row := map[string]interface{}{"resource_name": "web-1", "provider": "aws", "size": "large"}
+	Lineage{UploadID: "upload-1", SchemaVersion: 1}.Apply(row)
+	if err := store.AppendData(orgID, row); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+
+	uploads, err := SelectOrgDataFields(store, orgID, []string{"resource_name"})
+	if err != nil {
+		t.Fatalf("SelectOrgDataFields failed: %v", err)
+	}
+	if len(uploads) != 1 || !reflect.DeepEqual(uploads[0].Data, map[string]interface{}{"resource_name": "web-1"}) {
+		t.Errorf("Unexpected projection %+v", uploads)
+	}
+	if uploads[0].Lineage == nil || uploads[0].Lineage.UploadID != "upload-1" {
+		t.Errorf("Expected lineage to be kept, got %+v", uploads[0].Lineage)
+	}
+}
@@ -0,0 +1,569 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/durability"
+	"github.com/google/uuid"
+)
+
+// File name suffixes of states, their checksums and locks in FileStateStorage
+const (
+	stateFileSuffix    = ".tfstate"
+	checksumFileSuffix = ".tfstate.sha256"
+	lockFileSuffix     = ".tflock"
+)
+
+// stateRecord is the on-disk format of a state; Data is base64-encoded
+type stateRecord struct {
+	Version   int64     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Data      []byte    `json:"data"`
+}
+
+// checksumRecord is the on-disk format of the checksum sidecar of a state.
+// It holds the checksums of the current and the previous version: the
+// sidecar is written before the state, so a state whose write was
+// interrupted in between still verifies.
+type checksumRecord struct {
+	Checksums []versionChecksum `json:"checksums"`
+}
+
+// versionChecksum is the SHA-256 of the data of one state version
+type versionChecksum struct {
+	Version int64  `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+// lockRecord is the on-disk format of a held lock
+type lockRecord struct {
+	Lock      LockInfo  `json:"lock"`
+	Acquired  time.Time `json:"acquired,omitempty"`
+	Heartbeat time.Time `json:"heartbeat,omitempty"`
+}
+
+// lastActive returns when the lock was last acquired or refreshed
+func (r lockRecord) lastActive() time.Time {
+	if r.Heartbeat.After(r.Acquired) {
+		return r.Heartbeat
+	}
+	return r.Acquired
+}
+
+// FileStateStorage stores Terraform states and locks as files, one
+// directory per organization:
+//
+//	<dir>/<org-id>/<name>.tfstate         state data and version
+//	<dir>/<org-id>/<name>.tfstate.sha256  checksums of the latest versions
+//	<dir>/<org-id>/<name>.tflock          held lock
+//
+// Files are replaced atomically, so a crash never leaves a partial state.
+// States are verified against their checksums on read, so a damaged file
+// fails with ErrStateCorrupt instead of handing Terraform a wrong state.
+// States are synced as the durability level requires (see SetDurability);
+// locks are synced before they are granted or released.
+type FileStateStorage struct {
+	dir        string
+	durability *durability.Policy // Syncs written files (nil = flush)
+	lockTTL    time.Duration      // Locks idle for longer can be taken over (0 = never)
+	mu         sync.RWMutex
+}
+
+// NewFileStateStorage creates file-based state storage in dir
+func NewFileStateStorage(dir string) (*FileStateStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &FileStateStorage{dir: dir}, nil
+}
+
+// statePath returns the path of a state file without its suffix. Names are
+// validated by the handlers; the check here keeps paths inside the org's
+// directory regardless.
+func (f *FileStateStorage) statePath(orgID uuid.UUID, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid state name %q", name)
+	}
+	return filepath.Join(f.dir, orgID.String(), name), nil
+}
+
+// readState reads a state record, returning ErrNotFound if it does not exist
+func readState(path string) (*stateRecord, error) {
+	data, err := os.ReadFile(path + stateFileSuffix)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var record stateRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("state file %s is unreadable: %v: %w", path+stateFileSuffix, err, ErrStateCorrupt)
+	}
+	return &record, nil
+}
+
+// stateChecksum returns the hex SHA-256 of state data
+func stateChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readChecksums reads the checksum sidecar of a state. It returns nil
+// without an error if there is none, as for states written before
+// checksums were kept.
+func readChecksums(path string) (*checksumRecord, error) {
+	data, err := os.ReadFile(path + checksumFileSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state checksum: %w", err)
+	}
+
+	var record checksumRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("checksum file %s is unreadable: %w", path+checksumFileSuffix, ErrStateCorrupt)
+	}
+	return &record, nil
+}
+
+// verifyState checks a state against the checksum of its version. States
+// without a checksum sidecar are not verified.
+func verifyState(path string, record *stateRecord) error {
+	checksums, err := readChecksums(path)
+	if err != nil || checksums == nil {
+		return err
+	}
+
+	for _, checksum := range checksums.Checksums {
+		if checksum.Version != record.Version {
+			continue
+		}
+		if checksum.SHA256 != stateChecksum(record.Data) {
+			return fmt.Errorf("state %s version %d does not match its checksum: %w", path+stateFileSuffix, record.Version, ErrStateCorrupt)
+		}
+		return nil
+	}
+	return fmt.Errorf("state %s has no checksum for version %d: %w", path+stateFileSuffix, record.Version, ErrStateCorrupt)
+}
+
+// readLock reads a lock record, returning ErrNotLocked if there is none
+func readLock(path string) (*lockRecord, error) {
+	data, err := os.ReadFile(path + lockFileSuffix)
+	if os.IsNotExist(err) {
+		return nil, ErrNotLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+
+	var record lockRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path+lockFileSuffix, err)
+	}
+	return &record, nil
+}
+
+// writeRecord atomically replaces a file with the JSON encoding of v. The
+// file is synced before the rename as the durability level requires, or
+// unless the level is none if it is critical.
+func (f *FileStateStorage) writeRecord(path string, v interface{}, critical bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if critical {
+		err = f.durability.SyncCritical(file)
+	} else {
+		err = f.durability.Sync(file, path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+	if critical {
+		return f.durability.SyncDirCritical(filepath.Dir(path))
+	}
+	return f.durability.SyncDir(filepath.Dir(path))
+}
+
+// removeLock removes a lock file, syncing the removal unless the level is
+// none
+func (f *FileStateStorage) removeLock(path string) error {
+	if err := os.Remove(path + lockFileSuffix); err != nil {
+		return fmt.Errorf("failed to persist unlock: %w", err)
+	}
+	return f.durability.SyncDirCritical(filepath.Dir(path))
+}
+
+// GetState retrieves state data for an organization
+func (f *FileStateStorage) GetState(orgID uuid.UUID, name string) (*StateData, error) {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	record, err := readState(path)
+	if err == nil {
+		err = verifyState(path, record)
+	}
+	if errors.Is(err, ErrStateCorrupt) {
+		slog.Error("Corrupt state file", "org_id", orgID, "state", name, "error", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StateData{OrgID: orgID, Name: name, Data: record.Data, Version: record.Version}, nil
+}
+
+// ListStates returns the organization's states ordered by name. Unreadable
+// state files are skipped; checksums are only verified on read.
+func (f *FileStateStorage) ListStates(orgID uuid.UUID) ([]StateSummary, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	summaries := make([]StateSummary, 0)
+	matches, err := filepath.Glob(filepath.Join(f.dir, orgID.String(), "*"+stateFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list states: %w", err)
+	}
+	for _, match := range matches {
+		path := strings.TrimSuffix(match, stateFileSuffix)
+		record, err := readState(path)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if errors.Is(err, ErrStateCorrupt) {
+			slog.Error("Corrupt state file", "org_id", orgID, "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, lockErr := os.Stat(path + lockFileSuffix)
+		summaries = append(summaries, StateSummary{
+			Name:    filepath.Base(path),
+			Version: record.Version,
+			Size:    len(record.Data),
+			Locked:  lockErr == nil,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries, nil
+}
+
+// ListStateOrgs returns the IDs of all organizations with a state directory
+func (f *FileStateStorage) ListStateOrgs() ([]uuid.UUID, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state directories: %w", err)
+	}
+	orgIDs := make([]uuid.UUID, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		orgID, err := uuid.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	return orgIDs, nil
+}
+
+// PutState stores state data for an organization. A corrupt state is
+// replaced, so writing a good state recovers from damage.
+func (f *FileStateStorage) PutState(orgID uuid.UUID, name string, data []byte) error {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.putState(path, data)
+}
+
+// PutStateLocked stores state data if lockID may write it, checking the
+// lock under the same mutex as the write. An unreadable lock is treated as
+// held, as LockState does.
+func (f *FileStateStorage) PutStateLocked(orgID uuid.UUID, name string, data []byte, lockID string) error {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lock, err := f.liveLock(path)
+	if err != nil {
+		return err
+	}
+	if err := checkLockHolder(lock, lockID); err != nil {
+		return err
+	}
+	return f.putState(path, data)
+}
+
+// liveLock returns the lock held on the state at path, or nil if it is not
+// locked or its lock has expired. The caller must hold f.mu.
+func (f *FileStateStorage) liveLock(path string) (*LockInfo, error) {
+	record, err := readLock(path)
+	if errors.Is(err, ErrNotLocked) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockExpired(record.lastActive(), f.lockTTL, time.Now().UTC()) {
+		return nil, nil
+	}
+	return &record.Lock, nil
+}
+
+// putState replaces the state at path and bumps its version. The caller
+// must hold f.mu.
+func (f *FileStateStorage) putState(path string, data []byte) error {
+	version := int64(1)
+	checksums := checksumRecord{}
+	existing, err := readState(path)
+	switch {
+	case err == nil:
+		version = existing.Version + 1
+		// Keep the checksum of the current version until the state is replaced
+		if previous, _ := readChecksums(path); previous != nil {
+			for _, checksum := range previous.Checksums {
+				if checksum.Version == existing.Version {
+					checksums.Checksums = append(checksums.Checksums, checksum)
+				}
+			}
+		}
+	case errors.Is(err, ErrStateCorrupt):
+		// Start the versions after the latest checksummed one
+		if previous, _ := readChecksums(path); previous != nil {
+			for _, checksum := range previous.Checksums {
+				version = max(version, checksum.Version+1)
+			}
+		}
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+
+	checksums.Checksums = append([]versionChecksum{{Version: version, SHA256: stateChecksum(data)}}, checksums.Checksums...)
+	if err := f.writeRecord(path+checksumFileSuffix, checksums, false); err != nil {
+		return err
+	}
+	return f.writeRecord(path+stateFileSuffix, stateRecord{
+		Version:   version,
+		UpdatedAt: time.Now().UTC(),
+		Data:      data,
+	}, false)
+}
+
+// DeleteState deletes state data for an organization
+func (f *FileStateStorage) DeleteState(orgID uuid.UUID, name string) error {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := os.Stat(path + stateFileSuffix); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	// An expired lock is released with the state; an unreadable one is kept
+	expired, err := readLock(path)
+	switch {
+	case errors.Is(err, ErrNotLocked):
+	case err != nil, !lockExpired(expired.lastActive(), f.lockTTL, time.Now().UTC()):
+		return ErrAlreadyLocked
+	}
+
+	if err := os.Remove(path + stateFileSuffix); err != nil {
+		return fmt.Errorf("failed to delete state: %w", err)
+	}
+	if err := os.Remove(path + checksumFileSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete state checksum: %w", err)
+	}
+	if expired != nil {
+		if err := f.removeLock(path); err != nil {
+			return err
+		}
+		logExpiredLock(orgID, name, &expired.Lock, expired.lastActive())
+	}
+	return f.durability.SyncDir(filepath.Dir(path))
+}
+
+// LockState locks the state for an organization
+func (f *FileStateStorage) LockState(orgID uuid.UUID, name string, lockInfo *LockInfo) error {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// An expired lock is taken over; an unreadable one is kept
+	now := time.Now().UTC()
+	expired, err := readLock(path)
+	switch {
+	case errors.Is(err, ErrNotLocked):
+	case err != nil, !lockExpired(expired.lastActive(), f.lockTTL, now):
+		return ErrAlreadyLocked
+	}
+
+	if err := f.writeRecord(path+lockFileSuffix, lockRecord{Lock: *lockInfo, Acquired: now}, true); err != nil {
+		return fmt.Errorf("failed to persist lock: %w", err)
+	}
+	if expired != nil {
+		logExpiredLock(orgID, name, &expired.Lock, expired.lastActive())
+	}
+	return nil
+}
+
+// UnlockState unlocks the state for an organization
+func (f *FileStateStorage) UnlockState(orgID uuid.UUID, name string, lockID string) error {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record, err := readLock(path)
+	if err != nil {
+		return err
+	}
+	if record.Lock.ID != lockID {
+		return &LockHeldError{Lock: record.Lock}
+	}
+
+	return f.removeLock(path)
+}
+
+// RefreshLock records a heartbeat of the lock holder
+func (f *FileStateStorage) RefreshLock(orgID uuid.UUID, name string, lockID string) (time.Time, error) {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record, err := readLock(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if record.Lock.ID != lockID {
+		return time.Time{}, ErrLockMismatch
+	}
+
+	record.Heartbeat = time.Now().UTC()
+	// A lost heartbeat only shortens the lease, so it is not critical
+	if err := f.writeRecord(path+lockFileSuffix, record, false); err != nil {
+		return time.Time{}, err
+	}
+	return record.Heartbeat, nil
+}
+
+// SetLockTTL lets locks be taken over once they have not been acquired or
+// refreshed for longer than ttl (0 = never)
+func (f *FileStateStorage) SetLockTTL(ttl time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lockTTL = ttl
+}
+
+// ForceUnlockState removes the lock regardless of its ID
+func (f *FileStateStorage) ForceUnlockState(orgID uuid.UUID, name string) (*LockInfo, error) {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record, err := readLock(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.removeLock(path); err != nil {
+		return nil, err
+	}
+	return &record.Lock, nil
+}
+
+// GetLock retrieves lock information. An expired lock is reported as
+// absent, as LockState would take it over.
+func (f *FileStateStorage) GetLock(orgID uuid.UUID, name string) (*LockInfo, error) {
+	path, err := f.statePath(orgID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	lock, err := f.liveLock(path)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, ErrNotFound
+	}
+	return lock, nil
+}
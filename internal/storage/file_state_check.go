@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Problems found by FileStateStorage.CheckStates
+const (
+	// StateProblemCorrupt is a state, checksum or lock file that cannot be
+	// read or a state that does not match its checksum
+	StateProblemCorrupt = "corrupt"
+
+	// StateProblemUnverified is a state without a checksum, written before
+	// checksums were kept; it gets one on its next write
+	StateProblemUnverified = "unverified"
+
+	// StateProblemOrphaned is a file that belongs to no state: a checksum
+	// without its state, a leftover of an interrupted write, or a file the
+	// storage does not know
+	StateProblemOrphaned = "orphaned"
+)
+
+// StateIssue is a problem with a file in the state directory
+type StateIssue struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// StateCheckReport is the result of a scan of the state directory
+type StateCheckReport struct {
+	States int          `json:"states"`
+	Locks  int          `json:"locks"`
+	Issues []StateIssue `json:"issues"`
+}
+
+// Count returns the number of issues with a problem
+func (r StateCheckReport) Count(problem string) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Problem == problem {
+			count++
+		}
+	}
+	return count
+}
+
+// CheckStates scans every state in the directory, verifying states against
+// their checksums and reporting corrupt and orphaned files. Nothing is
+// changed. Writes wait until the scan is done.
+func (f *FileStateStorage) CheckStates() (StateCheckReport, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	report := StateCheckReport{Issues: make([]StateIssue, 0)}
+	orgDirs, err := os.ReadDir(f.dir)
+	if err != nil {
+		return StateCheckReport{}, fmt.Errorf("failed to list state directory: %w", err)
+	}
+
+	for _, orgDir := range orgDirs {
+		path := filepath.Join(f.dir, orgDir.Name())
+		if _, err := uuid.Parse(orgDir.Name()); err != nil || !orgDir.IsDir() {
+			report.add(path, StateProblemOrphaned, "not an organization directory")
+			continue
+		}
+		if err := checkOrgDir(path, &report); err != nil {
+			return StateCheckReport{}, err
+		}
+	}
+
+	sort.Slice(report.Issues, func(i, j int) bool { return report.Issues[i].Path < report.Issues[j].Path })
+	return report, nil
+}
+
+// checkOrgDir checks the files of an organization's state directory
+func checkOrgDir(dir string, report *StateCheckReport) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+			report.add(file, StateProblemOrphaned, "unexpected directory")
+		case strings.HasSuffix(file, stateFileSuffix):
+			report.States++
+			checkStateFile(strings.TrimSuffix(file, stateFileSuffix), report)
+		case strings.HasSuffix(file, checksumFileSuffix):
+			path := strings.TrimSuffix(file, checksumFileSuffix)
+			if _, err := os.Stat(path + stateFileSuffix); os.IsNotExist(err) {
+				report.add(file, StateProblemOrphaned, "checksum without state")
+			}
+		case strings.HasSuffix(file, lockFileSuffix):
+			// A lock without a state is held before the first write
+			report.Locks++
+			if _, err := readLock(strings.TrimSuffix(file, lockFileSuffix)); err != nil {
+				report.add(file, StateProblemCorrupt, err.Error())
+			}
+		case strings.HasSuffix(file, ".tmp"):
+			report.add(file, StateProblemOrphaned, "leftover of an interrupted write")
+		default:
+			report.add(file, StateProblemOrphaned, "unknown file")
+		}
+	}
+	return nil
+}
+
+// checkStateFile verifies a state against its checksum
+func checkStateFile(path string, report *StateCheckReport) {
+	file := path + stateFileSuffix
+	record, err := readState(path)
+	if err != nil {
+		report.add(file, StateProblemCorrupt, err.Error())
+		return
+	}
+
+	checksums, err := readChecksums(path)
+	if err != nil {
+		report.add(path+checksumFileSuffix, StateProblemCorrupt, err.Error())
+		return
+	}
+	if checksums == nil {
+		report.add(file, StateProblemUnverified, "no checksum file")
+		return
+	}
+	if err := verifyState(path, record); err != nil {
+		report.add(file, StateProblemCorrupt, err.Error())
+	}
+}
+
+// add records an issue
+func (r *StateCheckReport) add(path, problem, detail string) {
+	r.Issues = append(r.Issues, StateIssue{Path: path, Problem: problem, Detail: detail})
+}
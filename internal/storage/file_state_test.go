@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/durability"
+	"github.com/google/uuid"
+)
+
+func TestFileStateStorageStates(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStateStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStateStorage failed: %v", err)
+	}
+	orgID := uuid.New()
+
+	if _, err := store.GetState(orgID, "prod"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	store.PutState(orgID, "prod", []byte(`{"serial":2}`))
+	store.PutState(orgID, "env:dev", []byte(`{}`))
+	store.PutState(uuid.New(), "other-org", []byte(`{}`))
+
+	state, err := store.GetState(orgID, "prod")
+	if err != nil {
+		t.Fatalf("GetState failed: %v", err)
+	}
+	if string(state.Data) != `{"serial":2}` || state.Version != 2 {
+		t.Errorf("Expected version 2 of the state, got %d: %s", state.Version, state.Data)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, orgID.String(), "prod"+stateFileSuffix))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a state file with mode 0600, got %v, %v", info, err)
+	}
+
+	// States survive a restart
+	reopened, _ := NewFileStateStorage(dir)
+	summaries, err := reopened.ListStates(orgID)
+	if err != nil {
+		t.Fatalf("ListStates failed: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].Name != "env:dev" || summaries[1].Name != "prod" || summaries[1].Version != 2 {
+		t.Errorf("Unexpected states: %+v", summaries)
+	}
+
+	if err := store.DeleteState(orgID, "prod"); err != nil {
+		t.Fatalf("DeleteState failed: %v", err)
+	}
+	if err := store.DeleteState(orgID, "prod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	for _, name := range []string{"", "..", "a/b", `a\b`} {
+		if err := store.PutState(orgID, name, []byte(`{}`)); err == nil {
+			t.Errorf("Expected state name %q to be rejected", name)
+		}
+	}
+}
+
+func TestFileStateStorageLocks(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	orgID := uuid.New()
+	store.PutState(orgID, "prod", []byte(`{}`))
+
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-1", Who: "alice"}); err != nil {
+		t.Fatalf("LockState failed: %v", err)
+	}
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-2"}); !errors.Is(err, ErrAlreadyLocked) {
+		t.Errorf("Expected ErrAlreadyLocked, got %v", err)
+	}
+	if err := store.DeleteState(orgID, "prod"); !errors.Is(err, ErrAlreadyLocked) {
+		t.Errorf("Expected locked states to be kept, got %v", err)
+	}
+
+	// Locks survive a restart
+	reopened, _ := NewFileStateStorage(dir)
+	lock, err := reopened.GetLock(orgID, "prod")
+	if err != nil || lock.ID != "lock-1" || lock.Who != "alice" {
+		t.Fatalf("Expected lock-1 after reopening, got %+v, %v", lock, err)
+	}
+	if summaries, _ := reopened.ListStates(orgID); len(summaries) != 1 || !summaries[0].Locked {
+		t.Errorf("Expected the state to be listed as locked, got %+v", summaries)
+	}
+
+	if _, err := store.RefreshLock(orgID, "prod", "lock-2"); !errors.Is(err, ErrLockMismatch) {
+		t.Errorf("Expected ErrLockMismatch, got %v", err)
+	}
+	if _, err := store.RefreshLock(orgID, "prod", "lock-1"); err != nil {
+		t.Errorf("RefreshLock failed: %v", err)
+	}
+	var lockErr *LockHeldError
+	if err := store.UnlockState(orgID, "prod", "lock-2"); !errors.Is(err, ErrLockMismatch) || !errors.As(err, &lockErr) || lockErr.Lock.ID != "lock-1" {
+		t.Errorf("Expected a LockHeldError for lock-1, got %v", err)
+	}
+	if err := store.UnlockState(orgID, "prod", "lock-1"); err != nil {
+		t.Fatalf("UnlockState failed: %v", err)
+	}
+	if err := store.UnlockState(orgID, "prod", "lock-1"); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Expected ErrNotLocked, got %v", err)
+	}
+	if _, err := store.GetLock(orgID, "prod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a released lock, got %v", err)
+	}
+
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-3"})
+	removed, err := store.ForceUnlockState(orgID, "prod")
+	if err != nil || removed.ID != "lock-3" {
+		t.Errorf("Expected lock-3 to be force-unlocked, got %+v, %v", removed, err)
+	}
+	if _, err := store.ForceUnlockState(orgID, "prod"); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Expected ErrNotLocked, got %v", err)
+	}
+}
+
+func TestFileStateStorageLockTTL(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	store.SetLockTTL(time.Hour)
+	orgID := uuid.New()
+
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-2"}); !errors.Is(err, ErrAlreadyLocked) {
+		t.Fatalf("Expected a fresh lock to be kept, got %v", err)
+	}
+
+	// Backdate the lock as if its holder died two hours ago
+	path := filepath.Join(dir, orgID.String(), "prod")
+	record, _ := readLock(path)
+	record.Acquired = time.Now().Add(-2 * time.Hour)
+	store.writeRecord(path+lockFileSuffix, record, true)
+
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-2"}); err != nil {
+		t.Fatalf("Expected an expired lock to be taken over, got %v", err)
+	}
+	if lock, _ := store.GetLock(orgID, "prod"); lock == nil || lock.ID != "lock-2" {
+		t.Errorf("Expected lock-2 to be held, got %+v", lock)
+	}
+
+	// A heartbeat keeps the lock
+	record, _ = readLock(path)
+	record.Acquired = time.Now().Add(-2 * time.Hour)
+	record.Heartbeat = time.Now()
+	store.writeRecord(path+lockFileSuffix, record, true)
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-3"}); !errors.Is(err, ErrAlreadyLocked) {
+		t.Errorf("Expected a refreshed lock to be kept, got %v", err)
+	}
+}
+
+func TestFileStateStorageDeleteWithExpiredLock(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	store.SetLockTTL(time.Hour)
+	orgID := uuid.New()
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+
+	if err := store.DeleteState(orgID, "prod"); !errors.Is(err, ErrAlreadyLocked) {
+		t.Fatalf("Expected a fresh lock to block the delete, got %v", err)
+	}
+
+	// Backdate the lock as if its holder died two hours ago
+	path := filepath.Join(dir, orgID.String(), "prod")
+	record, _ := readLock(path)
+	record.Acquired = time.Now().Add(-2 * time.Hour)
+	store.writeRecord(path+lockFileSuffix, record, true)
+
+	if err := store.DeleteState(orgID, "prod"); err != nil {
+		t.Fatalf("Expected an expired lock not to block the delete, got %v", err)
+	}
+	if _, err := os.Stat(path + lockFileSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired lock file to be removed, got %v", err)
+	}
+}
+
+func TestFileStateStorageChecksums(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	orgID := uuid.New()
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	store.PutState(orgID, "prod", []byte(`{"serial":2}`))
+
+	path := filepath.Join(dir, orgID.String(), "prod")
+	good, _ := os.ReadFile(path + stateFileSuffix)
+
+	// Damaged data fails verification instead of being served
+	os.WriteFile(path+stateFileSuffix, []byte(strings.Replace(string(good), `"version":2`, `"version":1`, 1)), 0600)
+	if _, err := store.GetState(orgID, "prod"); !errors.Is(err, ErrStateCorrupt) {
+		t.Errorf("Expected ErrStateCorrupt for a rolled back version, got %v", err)
+	}
+	os.WriteFile(path+stateFileSuffix, good[:len(good)-5], 0600)
+	if _, err := store.GetState(orgID, "prod"); !errors.Is(err, ErrStateCorrupt) {
+		t.Errorf("Expected ErrStateCorrupt for a truncated file, got %v", err)
+	}
+
+	// A write interrupted after the checksum leaves the previous version valid
+	os.WriteFile(path+stateFileSuffix, good, 0600)
+	checksums, _ := readChecksums(path)
+	checksums.Checksums = append([]versionChecksum{{Version: 3, SHA256: stateChecksum([]byte("next"))}}, checksums.Checksums[0])
+	store.writeRecord(path+checksumFileSuffix, checksums, false)
+	if state, err := store.GetState(orgID, "prod"); err != nil || state.Version != 2 {
+		t.Errorf("Expected version 2 to verify after an interrupted write, got %+v, %v", state, err)
+	}
+
+	// Writing a good state recovers a corrupt one
+	os.WriteFile(path+stateFileSuffix, []byte("garbage"), 0600)
+	if err := store.PutState(orgID, "prod", []byte(`{"serial":4}`)); err != nil {
+		t.Fatalf("PutState failed: %v", err)
+	}
+	if state, err := store.GetState(orgID, "prod"); err != nil || state.Version != 4 {
+		t.Errorf("Expected version 4 after recovery, got %+v, %v", state, err)
+	}
+
+	// States written without checksums are served
+	os.Remove(path + checksumFileSuffix)
+	if _, err := store.GetState(orgID, "prod"); err != nil {
+		t.Errorf("Expected states without checksums to be read, got %v", err)
+	}
+}
+
+func TestFileStateStorageCheckStates(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	orgID := uuid.New()
+	store.PutState(orgID, "good", []byte(`{}`))
+	store.PutState(orgID, "damaged", []byte(`{"serial":1}`))
+	store.PutState(orgID, "legacy", []byte(`{}`))
+	store.LockState(orgID, "new", &LockInfo{ID: "lock-1"})
+
+	orgDir := filepath.Join(dir, orgID.String())
+	os.WriteFile(filepath.Join(orgDir, "damaged"+stateFileSuffix), []byte(`{"version":1,"data":"e30="}`), 0600)
+	os.Remove(filepath.Join(orgDir, "legacy"+checksumFileSuffix))
+	os.WriteFile(filepath.Join(orgDir, "gone"+checksumFileSuffix), []byte(`{}`), 0600)
+	os.WriteFile(filepath.Join(orgDir, "good"+stateFileSuffix+".tmp"), []byte(`{}`), 0600)
+	os.WriteFile(filepath.Join(dir, "stray.txt"), nil, 0600)
+
+	report, err := store.CheckStates()
+	if err != nil {
+		t.Fatalf("CheckStates failed: %v", err)
+	}
+	if report.States != 3 || report.Locks != 1 {
+		t.Errorf("Expected 3 states and 1 lock, got %d and %d", report.States, report.Locks)
+	}
+
+	problems := make(map[string]string)
+	for _, issue := range report.Issues {
+		rel, _ := filepath.Rel(dir, issue.Path)
+		problems[rel] = issue.Problem
+	}
+	want := map[string]string{
+		filepath.Join(orgID.String(), "damaged"+stateFileSuffix):     StateProblemCorrupt,
+		filepath.Join(orgID.String(), "legacy"+stateFileSuffix):      StateProblemUnverified,
+		filepath.Join(orgID.String(), "gone"+checksumFileSuffix):     StateProblemOrphaned,
+		filepath.Join(orgID.String(), "good"+stateFileSuffix+".tmp"): StateProblemOrphaned,
+		"stray.txt": StateProblemOrphaned,
+	}
+	if len(problems) != len(want) {
+		t.Errorf("Expected %d issues, got %+v", len(want), report.Issues)
+	}
+	for path, problem := range want {
+		if problems[path] != problem {
+			t.Errorf("Expected %s to be %s, got %q", path, problem, problems[path])
+		}
+	}
+}
+
+func TestFileStateStorageDurability(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	policy, err := durability.New(durability.FsyncInterval, time.Hour)
+	if err != nil {
+		t.Fatalf("durability.New failed: %v", err)
+	}
+	defer policy.Close()
+	store.SetDurability(policy)
+	orgID := uuid.New()
+
+	// States wait for the background sync
+	store.PutState(orgID, "prod", []byte(`{}`))
+	if syncs, _, pending := policy.Stats(); syncs != 0 || pending == 0 {
+		t.Errorf("Expected the state write to be pending, got %d syncs and %d pending", syncs, pending)
+	}
+
+	// Locks are synced before they are acknowledged
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+	if syncs, _, _ := policy.Stats(); syncs == 0 {
+		t.Error("Expected the lock to be synced")
+	}
+
+	if err := policy.SyncPending(); err != nil {
+		t.Fatalf("SyncPending failed: %v", err)
+	}
+	if _, failures, pending := policy.Stats(); failures != 0 || pending != 0 {
+		t.Errorf("Expected all writes synced, got %d failures and %d pending", failures, pending)
+	}
+}
+
+func TestFileStateStorageLockedWrites(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStateStorage(dir)
+	store.SetLockTTL(time.Hour)
+	orgID := uuid.New()
+
+	if err := store.PutStateLocked(orgID, "prod", []byte(`{"serial":1}`), "lock-1"); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("Expected ErrNotLocked without a lock, got %v", err)
+	}
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1", Who: "alice@ci"})
+
+	var held *LockHeldError
+	err := store.PutStateLocked(orgID, "prod", []byte(`{"serial":1}`), "")
+	if !errors.As(err, &held) || held.Lock.Who != "alice@ci" || !errors.Is(err, ErrLockMismatch) {
+		t.Fatalf("Expected a LockHeldError naming the holder, got %v", err)
+	}
+	if err := store.PutStateLocked(orgID, "prod", []byte(`{"serial":1}`), "lock-1"); err != nil {
+		t.Fatalf("Expected the holder's write to succeed, got %v", err)
+	}
+
+	// An expired lock is absent to readers and writers
+	path := filepath.Join(dir, orgID.String(), "prod")
+	record, _ := readLock(path)
+	record.Acquired = time.Now().Add(-2 * time.Hour)
+	store.writeRecord(path+lockFileSuffix, record, true)
+
+	if _, err := store.GetLock(orgID, "prod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired lock to be reported absent, got %v", err)
+	}
+	if err := store.PutStateLocked(orgID, "prod", []byte(`{"serial":2}`), ""); err != nil {
+		t.Errorf("Expected a write without a lock to succeed after expiry, got %v", err)
+	}
+	if state, _ := store.GetState(orgID, "prod"); state == nil || state.Version != 2 {
+		t.Errorf("Expected version 2, got %+v", state)
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a stored CSV line is skipped by readers
+const (
+	RejectTooFewColumns    = "too_few_columns"
+	RejectInvalidTimestamp = "invalid_timestamp"
+	RejectInvalidOrgID     = "invalid_org_id"
+	RejectInvalidJSON      = "invalid_json"
+)
+
+// RejectedLines counts stored lines that readers skip as malformed
+type RejectedLines struct {
+	Total   int            `json:"total"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// add counts a rejected line; it is a no-op on a nil receiver so readers
+// that don't report integrity can pass nil
+func (r *RejectedLines) add(reason string) {
+	if r == nil {
+		return
+	}
+	r.Total++
+	r.Reasons[reason]++
+}
+
+// IntegrityChecker is implemented by data storage backends that can report
+// stored lines which are silently skipped when reading
+type IntegrityChecker interface {
+	// CheckIntegrity counts the organization's malformed stored lines
+	CheckIntegrity(orgID uuid.UUID) (RejectedLines, error)
+}
+
+// CheckIntegrity reads the organization's CSV file and counts the lines
+// that GetOrgData skips, by reason
+func (s *CSVStorage) CheckIntegrity(orgID uuid.UUID) (RejectedLines, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filePath, err := s.sanitizeFilePath(orgID)
+	if err != nil {
+		return RejectedLines{}, fmt.Errorf("invalid org ID for file path: %w", err)
+	}
+
+	rejected := RejectedLines{Reasons: make(map[string]int)}
+	if _, err := s.readUploads(filePath, orgID, &rejected); err != nil {
+		return RejectedLines{}, err
+	}
+	return rejected, nil
+}
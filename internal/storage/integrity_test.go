@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCSVCheckIntegrity(t *testing.T) {
+	store, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	orgID := uuid.New()
+	if err := store.AppendData(orgID, map[string]interface{}{"provider": "aws", "name": "a"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Append lines that GetOrgData skips
+	filePath := filepath.Join(store.dataDir, orgID.String()+".csv")
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open CSV file: %v", err)
+	}
+	file.WriteString("yesterday," + orgID.String() + ",,{}\n")
+	file.WriteString("2024-01-01T00:00:00Z,not-a-uuid,,{}\n")
+	file.WriteString("2024-01-01T00:00:00Z," + orgID.String() + ",,{broken\n")
+	file.WriteString("2024-01-01T00:00:00Z,x\n")
+	file.Close()
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("Expected 1 readable upload, got %d (err: %v)", len(uploads), err)
+	}
+
+	rejected, err := store.CheckIntegrity(orgID)
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed: %v", err)
+	}
+	if rejected.Total != 4 {
+		t.Errorf("Expected 4 rejected lines, got %d", rejected.Total)
+	}
+	for _, reason := range []string{RejectInvalidTimestamp, RejectInvalidOrgID, RejectInvalidJSON, RejectTooFewColumns} {
+		if rejected.Reasons[reason] != 1 {
+			t.Errorf("Expected 1 line rejected for %s, got %v", reason, rejected.Reasons)
+		}
+	}
+}
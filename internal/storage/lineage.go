@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"strconv"
+)
+
+// Reserved data keys holding server-generated lineage metadata. They are
+// stored alongside the instance attributes in every backend.
+const (
+	LineageUploadIDKey        = "_upload_id"
+	LineageRequestIDKey       = "_request_id"
+	LineageSourceIPKey        = "_source_ip"
+	LineageProviderVersionKey = "_provider_version"
+	LineageSchemaVersionKey   = "_schema_version"
+	LineageContentSHA256Key   = "_content_sha256"
+	LineageClientKey          = "_client"
+)
+
+// Lineage records where a stored row came from
+type Lineage struct {
+	UploadID        string `json:"upload_id,omitempty"`
+	RequestID       string `json:"request_id,omitempty"`
+	SourceIP        string `json:"source_ip,omitempty"`
+	ProviderVersion string `json:"provider_version,omitempty"`
+	SchemaVersion   int    `json:"schema_version,omitempty"`
+
+	// ContentSHA256 is the hex SHA-256 digest of the upload request body
+	ContentSHA256 string `json:"content_sha256,omitempty"`
+
+	// Client is the client software and version, e.g.
+	// "terraform-provider-eterrain/1.4.2"
+	Client string `json:"client,omitempty"`
+}
+
+// Apply writes the lineage fields into a data row, overwriting any
+// client-supplied values for the reserved keys
+func (l Lineage) Apply(data map[string]interface{}) {
+	data[LineageUploadIDKey] = l.UploadID
+	data[LineageRequestIDKey] = l.RequestID
+	data[LineageSourceIPKey] = l.SourceIP
+	data[LineageProviderVersionKey] = l.ProviderVersion
+	data[LineageSchemaVersionKey] = l.SchemaVersion
+	data[LineageContentSHA256Key] = l.ContentSHA256
+	data[LineageClientKey] = l.Client
+}
+
+// lineageFromData extracts lineage from a stored data row. Rows written
+// before lineage was recorded return nil.
+func lineageFromData(data map[string]interface{}) *Lineage {
+	uploadID, _ := data[LineageUploadIDKey].(string)
+	if uploadID == "" {
+		return nil
+	}
+
+	lineage := &Lineage{UploadID: uploadID}
+	lineage.RequestID, _ = data[LineageRequestIDKey].(string)
+	lineage.SourceIP, _ = data[LineageSourceIPKey].(string)
+	lineage.ProviderVersion, _ = data[LineageProviderVersionKey].(string)
+	lineage.ContentSHA256, _ = data[LineageContentSHA256Key].(string)
+	lineage.Client, _ = data[LineageClientKey].(string)
+
+	// The schema version is a number, or a string in wide CSV rows written
+	// before attribute types were recorded
+	switch v := data[LineageSchemaVersionKey].(type) {
+	case int:
+		lineage.SchemaVersion = v
+	case float64:
+		lineage.SchemaVersion = int(v)
+	case string:
+		lineage.SchemaVersion, _ = strconv.Atoi(v)
+	}
+
+	return lineage
+}
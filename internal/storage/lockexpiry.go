@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// lockExpired reports whether a lock last acquired or refreshed at
+// lastActive has expired under ttl. Locks never expire with a zero ttl.
+func lockExpired(lastActive time.Time, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && !lastActive.IsZero() && now.Sub(lastActive) > ttl
+}
+
+// logExpiredLock records that an expired lock was released so another
+// client could take the state
+func logExpiredLock(orgID uuid.UUID, name string, lock *LockInfo, lastActive time.Time) {
+	slog.Info("Expired lock released", "org_id", orgID, "state", name, "lock_id", lock.ID,
+		"holder", lock.Who, "last_active", lastActive.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lock history actions
+const (
+	LockActionAcquire     = "acquire"
+	LockActionRelease     = "release"
+	LockActionForceUnlock = "force_unlock"
+)
+
+// defaultLockHistorySize is the number of events kept per state
+const defaultLockHistorySize = 100
+
+// LockEvent is a single entry in a state's lock history
+type LockEvent struct {
+	Action    string    `json:"action"`
+	LockID    string    `json:"lock_id"`
+	Who       string    `json:"who,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	Time      time.Time `json:"time"`
+
+	// HeldSeconds is how long the lock was held, set on release and force-unlock
+	HeldSeconds float64 `json:"held_seconds,omitempty"`
+
+	// ForcedBy is the ID of the API key that force-unlocked the lock
+	ForcedBy string `json:"forced_by,omitempty"`
+}
+
+// LockHistory keeps a bounded in-memory log of lock events per state
+type LockHistory struct {
+	mu       sync.RWMutex
+	size     int
+	events   map[string][]LockEvent
+	acquired map[string]time.Time // acquisition time of currently held locks
+}
+
+// NewLockHistory creates a lock history keeping up to size events per state
+// (defaults to 100 when size is not positive)
+func NewLockHistory(size int) *LockHistory {
+	if size <= 0 {
+		size = defaultLockHistorySize
+	}
+	return &LockHistory{
+		size:     size,
+		events:   make(map[string][]LockEvent),
+		acquired: make(map[string]time.Time),
+	}
+}
+
+// historyKey creates a unique key for org and state name
+func historyKey(orgID uuid.UUID, name string) string {
+	return orgID.String() + ":" + name
+}
+
+// Record appends an event to the state's history. Release and force-unlock
+// events get the duration since the matching acquire.
+func (h *LockHistory) Record(orgID uuid.UUID, name string, event LockEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := historyKey(orgID, name)
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	switch event.Action {
+	case LockActionAcquire:
+		h.acquired[key] = event.Time
+	case LockActionRelease, LockActionForceUnlock:
+		if acquiredAt, ok := h.acquired[key]; ok {
+			event.HeldSeconds = event.Time.Sub(acquiredAt).Seconds()
+			delete(h.acquired, key)
+		}
+	}
+
+	events := append(h.events[key], event)
+	if len(events) > h.size {
+		events = append([]LockEvent(nil), events[len(events)-h.size:]...)
+	}
+	h.events[key] = events
+}
+
+// Events returns the state's lock history, oldest first
+func (h *LockHistory) Events(orgID uuid.UUID, name string) []LockEvent {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	events := h.events[historyKey(orgID, name)]
+	result := make([]LockEvent, len(events))
+	copy(result, events)
+	return result
+}
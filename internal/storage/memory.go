@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/eterrain/tf-backend-service/internal/faults"
 	"github.com/google/uuid"
 )
 
@@ -12,13 +16,30 @@ type MemoryStorage struct {
 	mu     sync.RWMutex
 	states map[string]*StateData // key: "orgID:name"
 	locks  map[string]*LockInfo  // key: "orgID:name"
+
+	// Acquisition or last heartbeat of held locks, key: "orgID:name"
+	heartbeats map[string]time.Time
+
+	// Locks idle for longer can be taken over (0 = never)
+	lockTTL time.Duration
+
+	// Optional snapshot persistence (see snapshot.go)
+	snapshotPath string
+	dirty        bool
+	snapshotMu   sync.Mutex
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
+
+	// Optional fault injection for resilience tests
+	faults *faults.Injector
 }
 
 // NewMemoryStorage creates a new in-memory storage
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		states: make(map[string]*StateData),
-		locks:  make(map[string]*LockInfo),
+		states:     make(map[string]*StateData),
+		locks:      make(map[string]*LockInfo),
+		heartbeats: make(map[string]time.Time),
 	}
 }
 
@@ -28,6 +49,10 @@ func (m *MemoryStorage) stateKey(orgID uuid.UUID, name string) string {
 
 // GetState retrieves state data for an organization
 func (m *MemoryStorage) GetState(orgID uuid.UUID, name string) (*StateData, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return nil, err
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -46,35 +71,120 @@ func (m *MemoryStorage) GetState(orgID uuid.UUID, name string) (*StateData, erro
 	return &stateCopy, nil
 }
 
+// ListStates returns the organization's states ordered by name
+func (m *MemoryStorage) ListStates(orgID uuid.UUID) ([]StateSummary, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	summaries := make([]StateSummary, 0)
+	for key, state := range m.states {
+		if state.OrgID != orgID {
+			continue
+		}
+		_, locked := m.locks[key]
+		summaries = append(summaries, StateSummary{
+			Name:    state.Name,
+			Version: state.Version,
+			Size:    len(state.Data),
+			Locked:  locked,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries, nil
+}
+
+// ListStateOrgs returns the IDs of all organizations with states
+func (m *MemoryStorage) ListStateOrgs() ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[uuid.UUID]bool)
+	orgIDs := make([]uuid.UUID, 0)
+	for _, state := range m.states {
+		if !seen[state.OrgID] {
+			seen[state.OrgID] = true
+			orgIDs = append(orgIDs, state.OrgID)
+		}
+	}
+	return orgIDs, nil
+}
+
 // PutState stores state data for an organization
 func (m *MemoryStorage) PutState(orgID uuid.UUID, name string, data []byte) error {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	key := m.stateKey(orgID, name)
-
 	// Make a copy of the data
 	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
+	m.storeState(orgID, name, dataCopy)
+
+	return nil
+}
+
+// PutStateLocked stores state data if lockID may write it, checking the
+// lock under the same mutex as the write
+func (m *MemoryStorage) PutStateLocked(orgID uuid.UUID, name string, data []byte, lockID string) error {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkLockHolder(m.liveLock(m.stateKey(orgID, name), time.Now()), lockID); err != nil {
+		return err
+	}
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	m.storeState(orgID, name, dataCopy)
 
-	existing, exists := m.states[key]
+	return nil
+}
+
+// storeState replaces a state with data, which it takes ownership of, and
+// bumps its version. The caller must hold m.mu.
+func (m *MemoryStorage) storeState(orgID uuid.UUID, name string, data []byte) {
+	key := m.stateKey(orgID, name)
 	version := int64(1)
-	if exists {
+	if existing, exists := m.states[key]; exists {
 		version = existing.Version + 1
 	}
 
+	m.dirty = true
 	m.states[key] = &StateData{
 		OrgID:   orgID,
 		Name:    name,
-		Data:    dataCopy,
+		Data:    data,
 		Version: version,
 	}
+}
 
-	return nil
+// liveLock returns the lock held on a state, or nil if it is not locked or
+// its lock has expired. The caller must hold m.mu.
+func (m *MemoryStorage) liveLock(key string, now time.Time) *LockInfo {
+	lock, locked := m.locks[key]
+	if !locked || lockExpired(m.heartbeats[key], m.lockTTL, now) {
+		return nil
+	}
+	return lock
 }
 
 // DeleteState deletes state data for an organization
 func (m *MemoryStorage) DeleteState(orgID uuid.UUID, name string) error {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -85,36 +195,69 @@ func (m *MemoryStorage) DeleteState(orgID uuid.UUID, name string) error {
 		return ErrNotFound
 	}
 
-	// Check if state is locked
-	if _, locked := m.locks[key]; locked {
+	// Check if state is locked; an expired lock is released with the state
+	if m.liveLock(key, time.Now()) != nil {
 		return ErrAlreadyLocked
 	}
+	if expired, locked := m.locks[key]; locked {
+		delete(m.locks, key)
+		if err := m.persistLocks(); err != nil {
+			m.locks[key] = expired
+			return fmt.Errorf("failed to persist unlock: %w", err)
+		}
+		logExpiredLock(orgID, name, expired, m.heartbeats[key])
+		delete(m.heartbeats, key)
+	}
 
+	m.dirty = true
 	delete(m.states, key)
 	return nil
 }
 
 // LockState locks the state for an organization
 func (m *MemoryStorage) LockState(orgID uuid.UUID, name string, lockInfo *LockInfo) error {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	key := m.stateKey(orgID, name)
+	now := time.Now()
 
-	// Check if already locked
-	if _, locked := m.locks[key]; locked {
+	// Check if already locked; an expired lock is taken over
+	expired, locked := m.locks[key]
+	if locked && !lockExpired(m.heartbeats[key], m.lockTTL, now) {
 		return ErrAlreadyLocked
 	}
 
 	// Make a copy of lock info
 	lockCopy := *lockInfo
 	m.locks[key] = &lockCopy
+	if err := m.persistLocks(); err != nil {
+		if locked {
+			m.locks[key] = expired
+		} else {
+			delete(m.locks, key)
+		}
+		return fmt.Errorf("failed to persist lock: %w", err)
+	}
+	m.dirty = true
+	if locked {
+		logExpiredLock(orgID, name, expired, m.heartbeats[key])
+	}
+	m.heartbeats[key] = now
 
 	return nil
 }
 
 // UnlockState unlocks the state for an organization
 func (m *MemoryStorage) UnlockState(orgID uuid.UUID, name string, lockID string) error {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -128,22 +271,90 @@ func (m *MemoryStorage) UnlockState(orgID uuid.UUID, name string, lockID string)
 
 	// Verify lock ID matches
 	if lock.ID != lockID {
-		return fmt.Errorf("lock ID mismatch: expected %s, got %s", lock.ID, lockID)
+		return &LockHeldError{Lock: *lock}
 	}
 
 	delete(m.locks, key)
+	if err := m.persistLocks(); err != nil {
+		m.locks[key] = lock
+		return fmt.Errorf("failed to persist unlock: %w", err)
+	}
+	m.dirty = true
+	delete(m.heartbeats, key)
 	return nil
 }
 
-// GetLock retrieves lock information
+// RefreshLock records a heartbeat of the lock holder
+func (m *MemoryStorage) RefreshLock(orgID uuid.UUID, name string, lockID string) (time.Time, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return time.Time{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.stateKey(orgID, name)
+
+	lock, locked := m.locks[key]
+	if !locked {
+		return time.Time{}, ErrNotLocked
+	}
+	if lock.ID != lockID {
+		return time.Time{}, ErrLockMismatch
+	}
+
+	now := time.Now()
+	m.heartbeats[key] = now
+	return now, nil
+}
+
+// SetLockTTL lets locks be taken over once they have seen no heartbeat for
+// longer than ttl (0 = never). Heartbeats are not persisted, so locks loaded
+// from a snapshot expire ttl after the restart.
+func (m *MemoryStorage) SetLockTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockTTL = ttl
+}
+
+// ForceUnlockState removes the lock regardless of its ID
+func (m *MemoryStorage) ForceUnlockState(orgID uuid.UUID, name string) (*LockInfo, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.stateKey(orgID, name)
+
+	lock, locked := m.locks[key]
+	if !locked {
+		return nil, ErrNotLocked
+	}
+
+	delete(m.locks, key)
+	if err := m.persistLocks(); err != nil {
+		m.locks[key] = lock
+		return nil, fmt.Errorf("failed to persist unlock: %w", err)
+	}
+	m.dirty = true
+	delete(m.heartbeats, key)
+	return lock, nil
+}
+
+// GetLock retrieves lock information. An expired lock is reported as
+// absent, as LockState would take it over.
 func (m *MemoryStorage) GetLock(orgID uuid.UUID, name string) (*LockInfo, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return nil, err
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	key := m.stateKey(orgID, name)
-
-	lock, exists := m.locks[key]
-	if !exists {
+	lock := m.liveLock(m.stateKey(orgID, name), time.Now())
+	if lock == nil {
 		return nil, ErrNotFound
 	}
 
@@ -151,3 +362,96 @@ func (m *MemoryStorage) GetLock(orgID uuid.UUID, name string) (*LockInfo, error)
 	lockCopy := *lock
 	return &lockCopy, nil
 }
+
+// MemoryDataStorage provides an in-memory implementation of DataStorage
+// intended for tests and ephemeral deployments
+type MemoryDataStorage struct {
+	mu      sync.RWMutex
+	uploads map[uuid.UUID][]DataUpload
+}
+
+// NewMemoryDataStorage creates a new in-memory data storage
+func NewMemoryDataStorage() *MemoryDataStorage {
+	return &MemoryDataStorage{
+		uploads: make(map[uuid.UUID][]DataUpload),
+	}
+}
+
+// AppendData appends data to the organization's in-memory records
+func (m *MemoryDataStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.appendAt(orgID, time.Now().UTC(), data)
+	return nil
+}
+
+// ImportData appends rows with the timestamps they were uploaded at
+func (m *MemoryDataStorage) ImportData(orgID uuid.UUID, uploads []DataUpload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, upload := range uploads {
+		m.appendAt(orgID, upload.Timestamp.UTC(), importedData(upload))
+	}
+	return nil
+}
+
+// appendAt appends a row with the given timestamp. Must be called with
+// m.mu held.
+func (m *MemoryDataStorage) appendAt(orgID uuid.UUID, timestamp time.Time, data map[string]interface{}) {
+	// Make a shallow copy so later changes by the caller are not visible
+	dataCopy := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		dataCopy[k] = v
+	}
+
+	reportName := ""
+	if name, ok := data["report_name"].(string); ok {
+		reportName = name
+	}
+
+	m.uploads[orgID] = append(m.uploads[orgID], DataUpload{
+		Timestamp:  timestamp,
+		OrgID:      orgID,
+		ReportName: reportName,
+		Lineage:    lineageFromData(dataCopy),
+		Data:       dataCopy,
+	})
+}
+
+// GetOrgData retrieves all data for an organization
+func (m *MemoryDataStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	uploads := make([]DataUpload, len(m.uploads[orgID]))
+	copy(uploads, m.uploads[orgID])
+	return uploads, nil
+}
+
+// OrgDataSize returns the JSON-encoded size of the organization's records in bytes
+func (m *MemoryDataStorage) OrgDataSize(orgID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var size int64
+	for _, upload := range m.uploads[orgID] {
+		encoded, err := json.Marshal(upload.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode record: %w", err)
+		}
+		size += int64(len(encoded))
+	}
+
+	return size, nil
+}
+
+// DeleteOrgData removes all data for an organization
+func (m *MemoryDataStorage) DeleteOrgData(orgID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.uploads, orgID)
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryStorageUnlockWithAnotherLockID(t *testing.T) {
+	store := NewMemoryStorage()
+	orgID := uuid.New()
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"}); err != nil {
+		t.Fatalf("LockState failed: %v", err)
+	}
+
+	err := store.UnlockState(orgID, "prod", "lock-2")
+	var lockErr *LockHeldError
+	if !errors.Is(err, ErrLockMismatch) || !errors.As(err, &lockErr) || lockErr.Lock.ID != "lock-1" {
+		t.Errorf("Expected a LockHeldError for lock-1, got %v", err)
+	}
+	if _, err := store.GetLock(orgID, "prod"); err != nil {
+		t.Errorf("Expected lock-1 to be kept, got %v", err)
+	}
+}
+
+func TestMemoryStorageDeleteWithExpiredLock(t *testing.T) {
+	store := NewMemoryStorage()
+	store.SetLockTTL(time.Hour)
+	orgID := uuid.New()
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+
+	if err := store.DeleteState(orgID, "prod"); !errors.Is(err, ErrAlreadyLocked) {
+		t.Fatalf("Expected a fresh lock to block the delete, got %v", err)
+	}
+
+	// Backdate the lock as if its holder died two hours ago
+	store.heartbeats[store.stateKey(orgID, "prod")] = time.Now().Add(-2 * time.Hour)
+
+	if err := store.DeleteState(orgID, "prod"); err != nil {
+		t.Fatalf("Expected an expired lock not to block the delete, got %v", err)
+	}
+	if _, locked := store.locks[store.stateKey(orgID, "prod")]; locked {
+		t.Error("Expected the expired lock to be released")
+	}
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMigrationBatchSize is the number of rows imported at a time when no
+// batch size is given
+const DefaultMigrationBatchSize = 500
+
+// DataImporter is implemented by data storage backends that can store rows
+// with the timestamps they were originally uploaded at, so migrated data
+// keeps its history
+type DataImporter interface {
+	// ImportData appends rows keeping their timestamps
+	ImportData(orgID uuid.UUID, uploads []DataUpload) error
+}
+
+// OrgLister is implemented by data storage backends that can list the
+// organizations with data
+type OrgLister interface {
+	ListOrgs() ([]uuid.UUID, error)
+}
+
+// StateOrgLister is implemented by state storage backends that can list the
+// organizations with states
+type StateOrgLister interface {
+	ListStateOrgs() ([]uuid.UUID, error)
+}
+
+// MigrationProgress reports how far the migration of an organization's
+// data rows or states has come
+type MigrationProgress struct {
+	OrgID uuid.UUID
+	Kind  string // "data" or "states"
+	Done  int
+	Total int
+}
+
+// MigrationResult summarizes the migration of an organization
+type MigrationResult struct {
+	OrgID          uuid.UUID `json:"org_id"`
+	Rows           int       `json:"rows"`
+	RowsCopied     int       `json:"rows_copied"`
+	States         int       `json:"states"`
+	StatesCopied   int       `json:"states_copied"`
+	LockedStates   []string  `json:"locked_states,omitempty"`
+	RowsVerified   bool      `json:"rows_verified"`
+	StatesVerified bool      `json:"states_verified"`
+}
+
+// Migrator copies the data rows and states of organizations from one
+// storage backend to another. Copying is idempotent: rows already in the
+// destination (matched by content, as dual storage matches them) and
+// states with identical content are skipped, so an interrupted migration
+// resumes where it stopped when run again. Each organization is verified
+// after it is copied.
+type Migrator struct {
+	FromData DataStorage
+	ToData   DataStorage // must implement DataImporter
+
+	// FromStates and ToStates are the state backends; nil skips states
+	FromStates Storage
+	ToStates   Storage
+
+	// BatchSize is the number of rows imported at a time
+	// (0 = DefaultMigrationBatchSize)
+	BatchSize int
+
+	// Progress is called after each batch of rows and each state
+	Progress func(MigrationProgress)
+}
+
+// Orgs returns the organizations with data or states in the source
+// backends, in UUID order
+func (m *Migrator) Orgs() ([]uuid.UUID, error) {
+	lister, ok := m.FromData.(OrgLister)
+	if !ok {
+		return nil, fmt.Errorf("source data storage cannot list organizations")
+	}
+	orgIDs, err := lister.ListOrgs()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uuid.UUID]bool, len(orgIDs))
+	for _, orgID := range orgIDs {
+		seen[orgID] = true
+	}
+	if m.FromStates != nil {
+		lister, ok := m.FromStates.(StateOrgLister)
+		if !ok {
+			return nil, fmt.Errorf("source state storage cannot list organizations")
+		}
+		orgIDs, err := lister.ListStateOrgs()
+		if err != nil {
+			return nil, err
+		}
+		for _, orgID := range orgIDs {
+			seen[orgID] = true
+		}
+	}
+
+	orgIDs = make([]uuid.UUID, 0, len(seen))
+	for orgID := range seen {
+		orgIDs = append(orgIDs, orgID)
+	}
+	slices.SortFunc(orgIDs, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	return orgIDs, nil
+}
+
+// MigrateOrg copies and verifies the data rows and states of an organization
+func (m *Migrator) MigrateOrg(orgID uuid.UUID) (*MigrationResult, error) {
+	result := &MigrationResult{OrgID: orgID}
+	if err := m.migrateData(orgID, result); err != nil {
+		return result, err
+	}
+	if m.FromStates != nil && m.ToStates != nil {
+		if err := m.migrateStates(orgID, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// migrateData imports the source rows missing from the destination in
+// batches, then checks that the destination holds every source row
+func (m *Migrator) migrateData(orgID uuid.UUID, result *MigrationResult) error {
+	importer, ok := m.ToData.(DataImporter)
+	if !ok {
+		return fmt.Errorf("destination data storage cannot import rows")
+	}
+	batchSize := m.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultMigrationBatchSize
+	}
+
+	source, err := m.FromData.GetOrgData(orgID)
+	if err != nil {
+		return fmt.Errorf("failed to read source data: %w", err)
+	}
+	result.Rows = len(source)
+
+	existing, err := m.ToData.GetOrgData(orgID)
+	if err != nil {
+		return fmt.Errorf("failed to read destination data: %w", err)
+	}
+	present := rowCounts(existing)
+	var missing []DataUpload
+	for _, upload := range source {
+		key := rowKey(upload)
+		if present[key] > 0 {
+			present[key]--
+			continue
+		}
+		missing = append(missing, upload)
+	}
+
+	done := len(source) - len(missing)
+	m.report(orgID, "data", done, len(source))
+	for start := 0; start < len(missing); start += batchSize {
+		batch := missing[start:min(start+batchSize, len(missing))]
+		if err := importer.ImportData(orgID, batch); err != nil {
+			return fmt.Errorf("failed to import data: %w", err)
+		}
+		result.RowsCopied += len(batch)
+		done += len(batch)
+		m.report(orgID, "data", done, len(source))
+	}
+
+	migrated, err := m.ToData.GetOrgData(orgID)
+	if err != nil {
+		return fmt.Errorf("failed to read destination data: %w", err)
+	}
+	present = rowCounts(migrated)
+	absent := 0
+	for _, upload := range source {
+		key := rowKey(upload)
+		if present[key] > 0 {
+			present[key]--
+			continue
+		}
+		absent++
+	}
+	if absent > 0 {
+		return fmt.Errorf("verification failed: %d of %d row(s) missing from destination", absent, len(source))
+	}
+	result.RowsVerified = true
+	return nil
+}
+
+// migrateStates copies the source states whose content differs from the
+// destination's, then checks that the destination holds the same content.
+// Locks are not copied; locked states are reported so their holders can be
+// told to finish against the new backend.
+func (m *Migrator) migrateStates(orgID uuid.UUID, result *MigrationResult) error {
+	lister, ok := m.FromStates.(StateLister)
+	if !ok {
+		return fmt.Errorf("source state storage cannot list states")
+	}
+	summaries, err := lister.ListStates(orgID)
+	if err != nil {
+		return fmt.Errorf("failed to list source states: %w", err)
+	}
+	result.States = len(summaries)
+
+	for i, summary := range summaries {
+		if summary.Locked {
+			result.LockedStates = append(result.LockedStates, summary.Name)
+		}
+		state, err := m.FromStates.GetState(orgID, summary.Name)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was listed
+			result.States--
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read source state %q: %w", summary.Name, err)
+		}
+
+		current, err := m.ToStates.GetState(orgID, summary.Name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to read destination state %q: %w", summary.Name, err)
+		}
+		if current == nil || !bytes.Equal(current.Data, state.Data) {
+			if err := m.ToStates.PutState(orgID, summary.Name, state.Data); err != nil {
+				return fmt.Errorf("failed to write state %q: %w", summary.Name, err)
+			}
+			result.StatesCopied++
+
+			current, err = m.ToStates.GetState(orgID, summary.Name)
+			if err != nil {
+				return fmt.Errorf("failed to read destination state %q: %w", summary.Name, err)
+			}
+			if !bytes.Equal(current.Data, state.Data) {
+				return fmt.Errorf("verification failed: state %q differs in destination", summary.Name)
+			}
+		}
+		m.report(orgID, "states", i+1, len(summaries))
+	}
+	result.StatesVerified = true
+	return nil
+}
+
+// report calls the progress callback if any
+func (m *Migrator) report(orgID uuid.UUID, kind string, done, total int) {
+	if m.Progress != nil {
+		m.Progress(MigrationProgress{OrgID: orgID, Kind: kind, Done: done, Total: total})
+	}
+}
+
+// rowCounts counts rows by content
+func rowCounts(uploads []DataUpload) map[string]int {
+	counts := make(map[string]int, len(uploads))
+	for _, upload := range uploads {
+		counts[rowKey(upload)]++
+	}
+	return counts
+}
+
+// importedData returns the data of an imported row, restoring its report
+// name if the backend it was read from kept the name only in its own column
+func importedData(upload DataUpload) map[string]interface{} {
+	if _, ok := upload.Data["report_name"]; ok || upload.ReportName == "" {
+		return upload.Data
+	}
+	data := make(map[string]interface{}, len(upload.Data)+1)
+	for k, v := range upload.Data {
+		data[k] = v
+	}
+	data["report_name"] = upload.ReportName
+	return data
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMigratorCSVToMemory(t *testing.T) {
+	dir := t.TempDir()
+	csvStore, err := NewCSVStorage(dir)
+	if err != nil {
+		t.Fatalf("NewCSVStorage failed: %v", err)
+	}
+	stateStore, err := NewFileStateStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStateStorage failed: %v", err)
+	}
+	orgID, stateOnlyOrg := uuid.New(), uuid.New()
+
+	for _, name := range []string{"a", "b", "c", "c"} {
+		if err := csvStore.AppendData(orgID, map[string]interface{}{"report_name": name, "count": 1}); err != nil {
+			t.Fatalf("AppendData failed: %v", err)
+		}
+	}
+	stateStore.PutState(orgID, "prod", []byte(`{"serial":3}`))
+	stateStore.PutState(orgID, "dev", []byte(`{"serial":1}`))
+	stateStore.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+	stateStore.PutState(stateOnlyOrg, "default", []byte(`{}`))
+
+	// An earlier run copied the first row and the dev state
+	source, _ := csvStore.GetOrgData(orgID)
+	dataStore := NewMemoryDataStorage()
+	dataStore.ImportData(orgID, source[:1])
+	states := NewMemoryStorage()
+	states.PutState(orgID, "dev", []byte(`{"serial":1}`))
+
+	var progress []MigrationProgress
+	migrator := &Migrator{
+		FromData:   csvStore,
+		ToData:     dataStore,
+		FromStates: stateStore,
+		ToStates:   states,
+		BatchSize:  2,
+		Progress:   func(p MigrationProgress) { progress = append(progress, p) },
+	}
+
+	orgIDs, err := migrator.Orgs()
+	if err != nil {
+		t.Fatalf("Orgs failed: %v", err)
+	}
+	if len(orgIDs) != 2 {
+		t.Fatalf("Expected the data and state-only orgs, got %v", orgIDs)
+	}
+
+	result, err := migrator.MigrateOrg(orgID)
+	if err != nil {
+		t.Fatalf("MigrateOrg failed: %v", err)
+	}
+	if result.Rows != 4 || result.RowsCopied != 3 || !result.RowsVerified {
+		t.Errorf("Expected 3 of 4 rows copied and verified, got %+v", result)
+	}
+	if result.States != 2 || result.StatesCopied != 1 || !result.StatesVerified {
+		t.Errorf("Expected 1 of 2 states copied and verified, got %+v", result)
+	}
+	if len(result.LockedStates) != 1 || result.LockedStates[0] != "prod" {
+		t.Errorf("Expected the locked state to be reported, got %v", result.LockedStates)
+	}
+
+	// Progress starts at the rows already copied and ends with every state
+	dataProgress := []int{1, 3, 4}
+	for i, done := range dataProgress {
+		if progress[i].Kind != "data" || progress[i].Done != done || progress[i].Total != 4 {
+			t.Errorf("Unexpected progress %d: %+v", i, progress[i])
+		}
+	}
+	if last := progress[len(progress)-1]; last.Kind != "states" || last.Done != 2 || last.Total != 2 {
+		t.Errorf("Unexpected final progress %+v", last)
+	}
+
+	// Timestamps are kept
+	migrated, _ := dataStore.GetOrgData(orgID)
+	if len(migrated) != 4 {
+		t.Fatalf("Expected 4 migrated rows, got %d", len(migrated))
+	}
+	for i := range source {
+		if !migrated[i].Timestamp.Equal(source[i].Timestamp) {
+			t.Errorf("Row %d: expected timestamp %v, got %v", i, source[i].Timestamp, migrated[i].Timestamp)
+		}
+	}
+	state, err := states.GetState(orgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":3}` {
+		t.Errorf("Expected the prod state to be copied, got %v, %v", state, err)
+	}
+
+	// Running again copies nothing
+	result, err = migrator.MigrateOrg(orgID)
+	if err != nil {
+		t.Fatalf("MigrateOrg failed: %v", err)
+	}
+	if result.RowsCopied != 0 || result.StatesCopied != 0 {
+		t.Errorf("Expected nothing copied on a second run, got %+v", result)
+	}
+}
+
+func TestMigratorMemoryToCSV(t *testing.T) {
+	dataStore := NewMemoryDataStorage()
+	orgID := uuid.New()
+	past := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	dataStore.ImportData(orgID, []DataUpload{
+		{Timestamp: past, ReportName: "nightly", Data: map[string]interface{}{"count": float64(2)}},
+	})
+
+	csvStore, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCSVStorage failed: %v", err)
+	}
+	result, err := (&Migrator{FromData: dataStore, ToData: csvStore}).MigrateOrg(orgID)
+	if err != nil {
+		t.Fatalf("MigrateOrg failed: %v", err)
+	}
+	if result.RowsCopied != 1 || !result.RowsVerified {
+		t.Errorf("Expected the row copied and verified, got %+v", result)
+	}
+
+	uploads, _ := csvStore.GetOrgData(orgID)
+	if len(uploads) != 1 || !uploads[0].Timestamp.Equal(past) || uploads[0].ReportName != "nightly" {
+		t.Errorf("Expected the row with its timestamp and report name, got %+v", uploads)
+	}
+}
@@ -3,24 +3,44 @@ package storage
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/eterrain/tf-backend-service/internal/sqlbuild"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 )
 
-// MySQLStorage implements MySQL database-based storage for terraform data uploads
+// MySQLStorage implements MySQL database-based storage for terraform data
+// uploads and states (see mysql_state.go).
+// database/sql is safe for concurrent use, so only creating and dropping
+// tables is serialized.
 type MySQLStorage struct {
 	db         *sql.DB
 	dbName     string
-	mu         sync.RWMutex
-	tableMutex sync.Mutex // Protects table creation
+	tableMutex sync.Mutex    // Protects table creation and removal
+	inserts    *stmtCache    // Prepared INSERT statements per table
+	tables     *tableCache   // Tables known to exist
+	lockTTL    time.Duration // Locks idle for longer can be taken over (0 = never)
+
+	stopMonitor chan struct{} // Closed to stop the pool monitor
+	closeOnce   sync.Once
 }
 
+// MySQL errors that invalidate a table's cached statements, or mean a
+// state is already locked
+const (
+	mysqlErrBadField      = 1054 // ER_BAD_FIELD_ERROR
+	mysqlErrDupEntry      = 1062 // ER_DUP_ENTRY
+	mysqlErrNoSuchTable   = 1146 // ER_NO_SUCH_TABLE
+	mysqlErrNeedReprepare = 1615 // ER_NEED_REPREPARE
+)
+
 // NewMySQLStorage creates a new MySQL storage backend with retry logic
 func NewMySQLStorage(dsn string, dbName string) (*MySQLStorage, error) {
 	// Connect to MySQL
@@ -40,7 +60,7 @@ func NewMySQLStorage(dsn string, dbName string) (*MySQLStorage, error) {
 		}
 
 		if i < maxRetries-1 {
-			log.Printf("MySQL not ready yet (attempt %d/%d), retrying in %v...", i+1, maxRetries, retryDelay)
+			slog.Info("MySQL not ready yet, retrying", "attempt", i+1, "max_attempts", maxRetries, "retry_in", retryDelay.String())
 			time.Sleep(retryDelay)
 		}
 	}
@@ -55,10 +75,16 @@ func NewMySQLStorage(dsn string, dbName string) (*MySQLStorage, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &MySQLStorage{
-		db:     db,
-		dbName: dbName,
-	}, nil
+	s := &MySQLStorage{
+		db:          db,
+		dbName:      dbName,
+		inserts:     newStmtCache(db),
+		tables:      newTableCache(tableCacheTTL),
+		stopMonitor: make(chan struct{}),
+	}
+	go s.monitorPool(poolCheckInterval, s.stopMonitor)
+
+	return s, nil
 }
 
 // sanitizeTableName ensures the table name is safe to use
@@ -72,44 +98,67 @@ func (s *MySQLStorage) sanitizeTableName(orgID uuid.UUID) string {
 
 // ensureTableExists creates the organization's table if it doesn't exist
 func (s *MySQLStorage) ensureTableExists(orgID uuid.UUID) error {
+	tableName := s.sanitizeTableName(orgID)
+	if s.tables.exists(tableName) {
+		return nil
+	}
+
 	s.tableMutex.Lock()
 	defer s.tableMutex.Unlock()
 
-	tableName := s.sanitizeTableName(orgID)
+	// Another upload may have created it while this one waited
+	if s.tables.exists(tableName) {
+		return nil
+	}
 
 	// Create table if not exists
 	// Structure: timestamp, org_id, data (same as CSV)
-	createTableSQL := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
+	createTableSQL, _, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("CREATE TABLE IF NOT EXISTS ").Ident(tableName).Raw(` (
 			id INT AUTO_INCREMENT PRIMARY KEY,
 			timestamp DATETIME(6) NOT NULL,
 			org_id VARCHAR(36) NOT NULL,
 			data JSON NOT NULL,
 			INDEX idx_timestamp (timestamp),
 			INDEX idx_org_id (org_id)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
-	`, tableName)
-
-	_, err := s.db.Exec(createTableSQL)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`).
+		Build()
 	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(createTableSQL); err != nil {
 		return fmt.Errorf("failed to create table %s: %w", tableName, err)
 	}
+	s.tables.add(tableName)
 
 	return nil
 }
 
 // AppendData appends data to the organization's MySQL table
 func (s *MySQLStorage) AppendData(orgID uuid.UUID, data map[string]interface{}) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.insertAt(orgID, time.Now().UTC(), data)
+}
 
+// ImportData inserts rows into the organization's table with the
+// timestamps they were uploaded at
+func (s *MySQLStorage) ImportData(orgID uuid.UUID, uploads []DataUpload) error {
+	for _, upload := range uploads {
+		if err := s.insertAt(orgID, upload.Timestamp.UTC(), importedData(upload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertAt inserts a row with the given timestamp
+func (s *MySQLStorage) insertAt(orgID uuid.UUID, timestamp time.Time, data map[string]interface{}) error {
 	// Ensure table exists
 	if err := s.ensureTableExists(orgID); err != nil {
 		return err
 	}
 
 	tableName := s.sanitizeTableName(orgID)
-	timestamp := time.Now().UTC()
 
 	// Convert data to JSON
 	dataJSON, err := json.Marshal(data)
@@ -118,13 +167,29 @@ func (s *MySQLStorage) AppendData(orgID uuid.UUID, data map[string]interface{})
 	}
 
 	// Insert data
-	insertSQL := fmt.Sprintf(`
-		INSERT INTO %s (timestamp, org_id, data)
-		VALUES (?, ?, ?)
-	`, tableName)
+	insertSQL, args, err := sqlbuild.Insert{
+		Into:    tableName,
+		Columns: []string{"timestamp", "org_id", "data"},
+		Values:  []interface{}{timestamp, orgID.String(), dataJSON},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
 
-	_, err = s.db.Exec(insertSQL, timestamp, orgID.String(), dataJSON)
+	_, err = s.inserts.exec(tableName, insertSQL, args...)
+	if noSuchTable(err) {
+		// Dropped since it was cached, e.g. by another server: nothing was
+		// inserted, so create the table and insert again
+		s.tableChanged(tableName)
+		if err := s.ensureTableExists(orgID); err != nil {
+			return err
+		}
+		_, err = s.inserts.exec(tableName, insertSQL, args...)
+	}
 	if err != nil {
+		if schemaChanged(err) {
+			s.tableChanged(tableName)
+		}
 		return fmt.Errorf("failed to insert data into %s: %w", tableName, err)
 	}
 
@@ -133,37 +198,31 @@ func (s *MySQLStorage) AppendData(orgID uuid.UUID, data map[string]interface{})
 
 // GetOrgData retrieves all data for an organization
 func (s *MySQLStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	tableName := s.sanitizeTableName(orgID)
 
-	// Check if table exists
-	checkTableSQL := `
-		SELECT COUNT(*)
-		FROM information_schema.tables
-		WHERE table_schema = ?
-		AND table_name = ?
-	`
-	var tableCount int
-	err := s.db.QueryRow(checkTableSQL, s.dbName, tableName).Scan(&tableCount)
+	exists, err := s.tableExists(tableName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check if table exists: %w", err)
+		return nil, err
 	}
-
-	if tableCount == 0 {
+	if !exists {
 		// Table doesn't exist, return empty array
 		return []DataUpload{}, nil
 	}
 
 	// Query all data
-	querySQL := fmt.Sprintf(`
-		SELECT timestamp, org_id, data
-		FROM %s
-		ORDER BY timestamp ASC
-	`, tableName)
+	querySQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("timestamp"), sqlbuild.E("org_id"), sqlbuild.E("data")},
+		From:    tableName,
+		OrderBy: []sqlbuild.Order{{Expr: "timestamp"}},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := s.db.Query(querySQL)
+	rows, err := s.db.Query(querySQL, args...)
+	if s.dropped(tableName, err) {
+		return []DataUpload{}, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query data from %s: %w", tableName, err)
 	}
@@ -199,6 +258,136 @@ func (s *MySQLStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
 			Timestamp:  timestamp,
 			OrgID:      parsedOrgID,
 			ReportName: reportName,
+			Lineage:    lineageFromData(data),
+			Data:       data,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return uploads, nil
+}
+
+// tableExists checks whether the organization's table has been created
+func (s *MySQLStorage) tableExists(tableName string) (bool, error) {
+	if s.tables.exists(tableName) {
+		return true, nil
+	}
+
+	checkTableSQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("COUNT(*)")},
+		From:    "information_schema.tables",
+		Where:   sqlbuild.E("table_schema = ? AND table_name = ?", s.dbName, tableName),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return false, err
+	}
+
+	var tableCount int
+	if err := s.db.QueryRow(checkTableSQL, args...).Scan(&tableCount); err != nil {
+		return false, fmt.Errorf("failed to check if table exists: %w", err)
+	}
+	if tableCount > 0 {
+		s.tables.add(tableName)
+	}
+	return tableCount > 0, nil
+}
+
+// projectionMetaKeys are always selected so the response keeps each row's
+// report name and lineage
+var projectionMetaKeys = []string{
+	"report_name",
+	LineageUploadIDKey,
+	LineageRequestIDKey,
+	LineageSourceIPKey,
+	LineageProviderVersionKey,
+	LineageSchemaVersionKey,
+	LineageContentSHA256Key,
+	LineageClientKey,
+}
+
+// GetOrgDataFields retrieves all data for an organization, extracting only
+// the given fields from the JSON data column in the query
+func (s *MySQLStorage) GetOrgDataFields(orgID uuid.UUID, fields []string) ([]DataUpload, error) {
+	tableName := s.sanitizeTableName(orgID)
+
+	exists, err := s.tableExists(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []DataUpload{}, nil
+	}
+
+	// Each selected key becomes a column; JSON paths are bound as parameters
+	keys := append(append([]string{}, projectionMetaKeys...), fields...)
+	columns := []sqlbuild.Expr{sqlbuild.E("timestamp"), sqlbuild.E("org_id")}
+	for _, key := range keys {
+		columns = append(columns, jsonFieldExpr(key))
+	}
+
+	querySQL, args, err := sqlbuild.Select{
+		Columns: columns,
+		From:    tableName,
+		OrderBy: []sqlbuild.Order{{Expr: "timestamp"}},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(querySQL, args...)
+	if s.dropped(tableName, err) {
+		return []DataUpload{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data from %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	uploads := make([]DataUpload, 0)
+	for rows.Next() {
+		var timestamp time.Time
+		var orgIDStr string
+		values := make([]sql.NullString, len(keys))
+		dest := []interface{}{&timestamp, &orgIDStr}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+
+		parsedOrgID, err := uuid.Parse(orgIDStr)
+		if err != nil {
+			continue
+		}
+
+		meta := make(map[string]interface{}, len(projectionMetaKeys))
+		data := make(map[string]interface{}, len(fields))
+		for i, key := range keys {
+			if !values[i].Valid {
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(values[i].String), &value); err != nil {
+				continue
+			}
+			if i < len(projectionMetaKeys) {
+				meta[key] = value
+			} else {
+				data[key] = value
+			}
+		}
+
+		reportName, _ := meta["report_name"].(string)
+		uploads = append(uploads, DataUpload{
+			Timestamp:  timestamp,
+			OrgID:      parsedOrgID,
+			ReportName: reportName,
+			Lineage:    lineageFromData(meta),
 			Data:       data,
 		})
 	}
@@ -210,7 +399,380 @@ func (s *MySQLStorage) GetOrgData(orgID uuid.UUID) ([]DataUpload, error) {
 	return uploads, nil
 }
 
-// Close closes the database connection
+// jsonFieldExpr returns an expression extracting a field from the JSON data
+// column, trying the field's paths in order, with the JSON paths bound
+func jsonFieldExpr(field string) sqlbuild.Expr {
+	paths := fieldPaths(field)
+	extracts := make([]string, 0, len(paths))
+	args := make([]interface{}, 0, len(paths))
+	for _, path := range paths {
+		extracts = append(extracts, "JSON_EXTRACT(data, ?)")
+		args = append(args, jsonPath(path))
+	}
+	if len(extracts) == 1 {
+		return sqlbuild.E(extracts[0], args...)
+	}
+	return sqlbuild.E("COALESCE("+strings.Join(extracts, ", ")+")", args...)
+}
+
+// SummarizeOrgData groups and aggregates the organization's data in the
+// query. Only JSON numbers are aggregated, as in the in-memory summary.
+func (s *MySQLStorage) SummarizeOrgData(orgID uuid.UUID, query SummaryQuery) ([]SummaryGroup, error) {
+	tableName := s.sanitizeTableName(orgID)
+
+	exists, err := s.tableExists(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []SummaryGroup{}, nil
+	}
+
+	var columns []sqlbuild.Expr
+	var groupColumns []string
+	for i, field := range query.GroupBy {
+		column := jsonFieldExpr(field)
+		alias := fmt.Sprintf("g%d", i)
+		columns = append(columns, sqlbuild.E(column.SQL+" AS "+alias, column.Args...))
+		groupColumns = append(groupColumns, alias)
+	}
+	columns = append(columns, sqlbuild.E("COUNT(*)"))
+	for _, aggregation := range query.Aggregations {
+		value := jsonFieldExpr(aggregation.Field)
+		number := "CASE WHEN JSON_TYPE(" + value.SQL + ") IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') THEN " + value.SQL + " + 0 END"
+		args := append(append([]interface{}{}, value.Args...), value.Args...)
+
+		switch aggregation.Func {
+		case AggregateSum:
+			columns = append(columns, sqlbuild.E("COALESCE(SUM("+number+"), 0)", args...))
+		case AggregateCount:
+			columns = append(columns, sqlbuild.E("COUNT("+number+")", args...))
+		case AggregateAvg:
+			columns = append(columns, sqlbuild.E("AVG("+number+")", args...))
+		case AggregateMin:
+			columns = append(columns, sqlbuild.E("MIN("+number+")", args...))
+		case AggregateMax:
+			columns = append(columns, sqlbuild.E("MAX("+number+")", args...))
+		default:
+			return nil, fmt.Errorf("unsupported aggregation function: %s", aggregation.Func)
+		}
+	}
+
+	querySQL, args, err := sqlbuild.Select{
+		Columns: columns,
+		From:    tableName,
+		GroupBy: groupColumns,
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(querySQL, args...)
+	if s.dropped(tableName, err) {
+		return []SummaryGroup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize data from %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	groups := make([]SummaryGroup, 0)
+	for rows.Next() {
+		keys := make([]sql.NullString, len(query.GroupBy))
+		values := make([]sql.NullFloat64, len(query.Aggregations))
+		var count int64
+		dest := make([]interface{}, 0, len(keys)+1+len(values))
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		dest = append(dest, &count)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan summary row: %w", err)
+		}
+
+		// Without group-by fields, an empty table still yields one row
+		if count == 0 {
+			continue
+		}
+
+		group := SummaryGroup{
+			Key:    make(map[string]interface{}, len(keys)),
+			Count:  count,
+			Values: make(map[string]*float64, len(values)),
+		}
+		for i, field := range query.GroupBy {
+			var value interface{}
+			if keys[i].Valid {
+				json.Unmarshal([]byte(keys[i].String), &value)
+			}
+			group.Key[field] = value
+		}
+		for i, aggregation := range query.Aggregations {
+			if values[i].Valid {
+				v := values[i].Float64
+				group.Values[aggregation.Name()] = &v
+			} else {
+				group.Values[aggregation.Name()] = nil
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return groups, nil
+}
+
+// GetOrgDataPage retrieves a page of an organization's data with a keyset
+// query on (timestamp, id), so deep pages cost the same as the first one
+func (s *MySQLStorage) GetOrgDataPage(orgID uuid.UUID, query PageQuery) (DataPage, error) {
+	tableName := s.sanitizeTableName(orgID)
+
+	exists, err := s.tableExists(tableName)
+	if err != nil {
+		return DataPage{}, err
+	}
+	if !exists {
+		return DataPage{Uploads: []DataUpload{}}, nil
+	}
+
+	// Pages before a cursor are read backwards and reversed
+	var where sqlbuild.Expr
+	desc := false
+	switch {
+	case query.After != nil:
+		where = sqlbuild.E("timestamp > ? OR (timestamp = ? AND id > ?)", query.After.Timestamp, query.After.Timestamp, query.After.RowID)
+	case query.Before != nil:
+		where = sqlbuild.E("timestamp < ? OR (timestamp = ? AND id < ?)", query.Before.Timestamp, query.Before.Timestamp, query.Before.RowID)
+		desc = true
+	}
+
+	querySQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("id"), sqlbuild.E("timestamp"), sqlbuild.E("org_id"), sqlbuild.E("data")},
+		From:    tableName,
+		Where:   where,
+		OrderBy: []sqlbuild.Order{{Expr: "timestamp", Desc: desc}, {Expr: "id", Desc: desc}},
+		// One extra row tells whether another page follows
+		Limit: query.Limit + 1,
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return DataPage{}, err
+	}
+
+	rows, err := s.db.Query(querySQL, args...)
+	if s.dropped(tableName, err) {
+		return DataPage{Uploads: []DataUpload{}}, nil
+	}
+	if err != nil {
+		return DataPage{}, fmt.Errorf("failed to query data from %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	uploads := make([]DataUpload, 0, query.Limit+1)
+	cursors := make([]DataCursor, 0, query.Limit+1)
+	for rows.Next() {
+		var id int64
+		var timestamp time.Time
+		var orgIDStr string
+		var dataJSON []byte
+
+		if err := rows.Scan(&id, &timestamp, &orgIDStr, &dataJSON); err != nil {
+			continue
+		}
+
+		parsedOrgID, err := uuid.Parse(orgIDStr)
+		if err != nil {
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(dataJSON, &data); err != nil {
+			continue
+		}
+
+		reportName, _ := data["report_name"].(string)
+		uploads = append(uploads, DataUpload{
+			Timestamp:  timestamp,
+			OrgID:      parsedOrgID,
+			ReportName: reportName,
+			Lineage:    lineageFromData(data),
+			Data:       data,
+		})
+		cursors = append(cursors, DataCursor{Timestamp: timestamp, RowID: id})
+	}
+
+	if err := rows.Err(); err != nil {
+		return DataPage{}, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	more := len(uploads) > query.Limit
+	if more {
+		uploads, cursors = uploads[:query.Limit], cursors[:query.Limit]
+	}
+	if query.Before != nil {
+		slices.Reverse(uploads)
+		slices.Reverse(cursors)
+	}
+
+	page := DataPage{Uploads: uploads}
+	if len(cursors) > 0 {
+		first, last := cursors[0], cursors[len(cursors)-1]
+		if (query.Before != nil && more) || query.After != nil {
+			page.Prev = &first
+		}
+		if (query.Before == nil && more) || query.Before != nil {
+			page.Next = &last
+		}
+	}
+	return page, nil
+}
+
+// jsonPath builds a MySQL JSON path with quoted member names
+func jsonPath(keys []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, key := range keys {
+		b.WriteString(`."`)
+		b.WriteString(strings.ReplaceAll(key, `"`, `\"`))
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+// ListOrgs returns the IDs of all organizations that have a data table
+func (s *MySQLStorage) ListOrgs() ([]uuid.UUID, error) {
+	listSQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("table_name")},
+		From:    "information_schema.tables",
+		Where:   sqlbuild.E("table_schema = ? AND table_name LIKE ?", s.dbName, `org\_%`),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(listSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	orgIDs := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		// Tables are named org_<uuid> with underscores for hyphens
+		orgID, err := uuid.Parse(strings.ReplaceAll(strings.TrimPrefix(tableName, "org_"), "_", "-"))
+		if err != nil {
+			continue
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	return orgIDs, nil
+}
+
+// OrgDataSize returns the data and index size of the organization's table in bytes
+func (s *MySQLStorage) OrgDataSize(orgID uuid.UUID) (int64, error) {
+	sizeSQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("COALESCE(SUM(data_length + index_length), 0)")},
+		From:    "information_schema.tables",
+		Where:   sqlbuild.E("table_schema = ? AND table_name = ?", s.dbName, s.sanitizeTableName(orgID)),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	if err := s.db.QueryRow(sizeSQL, args...).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to query table size: %w", err)
+	}
+
+	return size, nil
+}
+
+// DeleteOrgData drops the organization's MySQL table
+func (s *MySQLStorage) DeleteOrgData(orgID uuid.UUID) error {
+	tableName := s.sanitizeTableName(orgID)
+
+	s.tableMutex.Lock()
+	defer s.tableMutex.Unlock()
+	s.tableChanged(tableName)
+
+	dropTableSQL, err := sqlbuild.DropTableIfExists(sqlbuild.MySQL, tableName)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(dropTableSQL); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+// tableChanged forgets what is cached about a table that was dropped or
+// altered
+func (s *MySQLStorage) tableChanged(tableName string) {
+	s.tables.remove(tableName)
+	s.inserts.invalidate(tableName)
+}
+
+// dropped reports whether a query failed because the table, cached as
+// existing, was dropped since, and forgets the table if so
+func (s *MySQLStorage) dropped(tableName string, err error) bool {
+	if !noSuchTable(err) {
+		return false
+	}
+	s.tableChanged(tableName)
+	return true
+}
+
+// noSuchTable reports whether a MySQL error means a table does not exist
+func noSuchTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrNoSuchTable
+}
+
+// schemaChanged reports whether a MySQL error means a table was dropped or
+// altered since its statements were prepared
+func schemaChanged(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	switch mysqlErr.Number {
+	case mysqlErrBadField, mysqlErrNoSuchTable, mysqlErrNeedReprepare:
+		return true
+	}
+	return false
+}
+
+// Stats returns the number of cached prepared statements, the statement
+// cache hits and misses, and the number of tables known to exist
+func (s *MySQLStorage) Stats() (statements int, hits, misses int64, tables int) {
+	statements, hits, misses = s.inserts.stats()
+	return statements, hits, misses, s.tables.size()
+}
+
+// Close stops the pool monitor and closes the cached statements and the
+// database connection. Further calls do nothing.
 func (s *MySQLStorage) Close() error {
-	return s.db.Close()
+	var err error
+	s.closeOnce.Do(func() {
+		if s.stopMonitor != nil {
+			close(s.stopMonitor)
+		}
+		s.inserts.close()
+		err = s.db.Close()
+	})
+	return err
 }
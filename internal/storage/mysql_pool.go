@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// poolCheckInterval is the time between checks of the connection pool for
+// requests that had to wait for a connection
+const poolCheckInterval = 30 * time.Second
+
+// SetConnMaxIdleTime sets how long a connection may be idle before it is
+// closed, so connections are replaced before MySQL or a proxy in between
+// drops them (0 keeps idle connections until ConnMaxLifetime)
+func (s *MySQLStorage) SetConnMaxIdleTime(d time.Duration) {
+	s.db.SetConnMaxIdleTime(d)
+}
+
+// PoolStats returns the connection pool statistics
+func (s *MySQLStorage) PoolStats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// monitorPool logs a warning whenever requests waited for a connection
+// because the pool was exhausted, until stop is closed
+func (s *MySQLStorage) monitorPool(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.db.Stats()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stats := s.db.Stats()
+			checkPool(last, stats, interval)
+			last = stats
+		}
+	}
+}
+
+// checkPool logs a warning if requests waited for a connection between two
+// pool statistics, and reports whether it did
+func checkPool(last, stats sql.DBStats, interval time.Duration) bool {
+	waits := stats.WaitCount - last.WaitCount
+	if waits <= 0 {
+		return false
+	}
+	waited := stats.WaitDuration - last.WaitDuration
+	slog.Warn("MySQL connection pool exhausted", "waits", waits, "average_wait", (waited / time.Duration(waits)).String(),
+		"interval", interval.String(), "in_use", stats.InUse, "max_open", stats.MaxOpenConnections)
+	return true
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckPool(t *testing.T) {
+	last := sql.DBStats{WaitCount: 3, WaitDuration: time.Second}
+	if checkPool(last, last, time.Minute) {
+		t.Error("Expected no warning without new waits")
+	}
+
+	stats := sql.DBStats{MaxOpenConnections: 25, InUse: 25, WaitCount: 5, WaitDuration: 3 * time.Second}
+	if !checkPool(last, stats, time.Minute) {
+		t.Error("Expected a warning after new waits")
+	}
+}
+
+func TestMySQLStoragePoolStats(t *testing.T) {
+	s, _ := newTestMySQLStorage(t, 4, 0)
+	s.SetConnMaxIdleTime(time.Minute)
+	if err := s.AppendData(uuid.New(), map[string]interface{}{"n": 1}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	if stats := s.PoolStats(); stats.MaxOpenConnections != 4 || stats.OpenConnections == 0 {
+		t.Errorf("Expected pool stats of the open connection, got %+v", stats)
+	}
+}
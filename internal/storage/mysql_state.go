@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/sqlbuild"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+// Tables holding Terraform states and locks of all organizations. Data
+// upload tables are named org_<uuid>, so the names never collide.
+const (
+	mysqlStateTable = "terraform_states"
+	mysqlLockTable  = "terraform_locks"
+)
+
+// ensureStateTables creates the state and lock tables if they don't exist.
+// State names are ASCII (see validation.ValidateStateName) and compared
+// byte by byte, like the other state backends.
+func (s *MySQLStorage) ensureStateTables() error {
+	if s.tables.exists(mysqlStateTable) && s.tables.exists(mysqlLockTable) {
+		return nil
+	}
+
+	s.tableMutex.Lock()
+	defer s.tableMutex.Unlock()
+
+	statements := []*sqlbuild.Query{
+		sqlbuild.New(sqlbuild.MySQL).Raw("CREATE TABLE IF NOT EXISTS ").Ident(mysqlStateTable).Raw(` (
+			org_id VARCHAR(36) NOT NULL,
+			name VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			data LONGBLOB NOT NULL,
+			version BIGINT NOT NULL,
+			updated_at DATETIME(6) NOT NULL,
+			PRIMARY KEY (org_id, name)
+		) ENGINE=InnoDB`),
+		sqlbuild.New(sqlbuild.MySQL).Raw("CREATE TABLE IF NOT EXISTS ").Ident(mysqlLockTable).Raw(` (
+			org_id VARCHAR(36) NOT NULL,
+			name VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			lock_id VARCHAR(255) NOT NULL,
+			info JSON NOT NULL,
+			created_at DATETIME(6) NOT NULL,
+			heartbeat_at DATETIME(6) NULL,
+			PRIMARY KEY (org_id, name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`),
+	}
+	for _, statement := range statements {
+		createSQL, _, err := statement.Build()
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(createSQL); err != nil {
+			return fmt.Errorf("failed to create state tables: %w", err)
+		}
+	}
+	s.tables.add(mysqlStateTable)
+	s.tables.add(mysqlLockTable)
+
+	return nil
+}
+
+// stateErr wraps a failed state query, forgetting the state tables if they
+// were dropped so the next request creates them again
+func (s *MySQLStorage) stateErr(action string, err error) error {
+	if noSuchTable(err) {
+		s.tables.remove(mysqlStateTable)
+		s.tables.remove(mysqlLockTable)
+	}
+	return fmt.Errorf("failed to %s: %w", action, err)
+}
+
+// stateKeyWhere matches a state or lock row
+func stateKeyWhere(orgID uuid.UUID, name string) sqlbuild.Expr {
+	return sqlbuild.E("org_id = ? AND name = ?", orgID.String(), name)
+}
+
+// GetState retrieves state data for an organization
+func (s *MySQLStorage) GetState(orgID uuid.UUID, name string) (*StateData, error) {
+	if err := s.ensureStateTables(); err != nil {
+		return nil, err
+	}
+
+	querySQL, args, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("data"), sqlbuild.E("version")},
+		From:    mysqlStateTable,
+		Where:   stateKeyWhere(orgID, name),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &StateData{OrgID: orgID, Name: name}
+	err = s.db.QueryRow(querySQL, args...).Scan(&state.Data, &state.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, s.stateErr("read state", err)
+	}
+	return state, nil
+}
+
+// ListStates returns the organization's states ordered by name
+func (s *MySQLStorage) ListStates(orgID uuid.UUID) ([]StateSummary, error) {
+	if err := s.ensureStateTables(); err != nil {
+		return nil, err
+	}
+
+	querySQL, args, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("SELECT s.name, s.version, LENGTH(s.data), l.lock_id IS NOT NULL FROM ").Ident(mysqlStateTable).
+		Raw(" s LEFT JOIN ").Ident(mysqlLockTable).Raw(" l ON l.org_id = s.org_id AND l.name = s.name").
+		Expr(sqlbuild.E(" WHERE s.org_id = ? ORDER BY s.name", orgID.String())).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(querySQL, args...)
+	if err != nil {
+		return nil, s.stateErr("list states", err)
+	}
+	defer rows.Close()
+
+	summaries := make([]StateSummary, 0)
+	for rows.Next() {
+		var summary StateSummary
+		if err := rows.Scan(&summary.Name, &summary.Version, &summary.Size, &summary.Locked); err != nil {
+			return nil, fmt.Errorf("failed to scan state: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.stateErr("list states", err)
+	}
+	return summaries, nil
+}
+
+// ListStateOrgs returns the IDs of all organizations with states
+func (s *MySQLStorage) ListStateOrgs() ([]uuid.UUID, error) {
+	if err := s.ensureStateTables(); err != nil {
+		return nil, err
+	}
+
+	querySQL, _, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("SELECT DISTINCT org_id FROM ").Ident(mysqlStateTable).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(querySQL)
+	if err != nil {
+		return nil, s.stateErr("list organizations", err)
+	}
+	defer rows.Close()
+
+	orgIDs := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.stateErr("list organizations", err)
+	}
+	return orgIDs, nil
+}
+
+// PutState stores state data for an organization, incrementing its version
+// in the same statement so concurrent writers never reuse a version
+func (s *MySQLStorage) PutState(orgID uuid.UUID, name string, data []byte) error {
+	if err := s.ensureStateTables(); err != nil {
+		return err
+	}
+
+	insertSQL, args, err := sqlbuild.Insert{
+		Into:    mysqlStateTable,
+		Columns: []string{"org_id", "name", "data", "version", "updated_at"},
+		Values:  []interface{}{orgID.String(), name, data, 1, time.Now().UTC()},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+	insertSQL += " ON DUPLICATE KEY UPDATE data = VALUES(data), version = version + 1, updated_at = VALUES(updated_at)"
+
+	if _, err := s.db.Exec(insertSQL, args...); err != nil {
+		return s.stateErr("store state", err)
+	}
+	return nil
+}
+
+// DeleteState deletes state data for an organization unless it is locked
+func (s *MySQLStorage) DeleteState(orgID uuid.UUID, name string) error {
+	if err := s.ensureStateTables(); err != nil {
+		return err
+	}
+
+	// An expired lock is released first, so it does not block the delete
+	if _, err := s.releaseExpiredLock(orgID, name); err != nil {
+		return err
+	}
+
+	// Check the lock in the same statement, so a lock taken concurrently
+	// either blocks the delete or is taken after it
+	deleteSQL, args, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("DELETE FROM ").Ident(mysqlStateTable).Raw(" WHERE ").Expr(stateKeyWhere(orgID, name)).
+		Raw(" AND NOT EXISTS (SELECT 1 FROM ").Ident(mysqlLockTable).Raw(" WHERE ").Expr(stateKeyWhere(orgID, name)).Raw(")").
+		Build()
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(deleteSQL, args...)
+	if err != nil {
+		return s.stateErr("delete state", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted > 0 {
+		return nil
+	}
+
+	if _, err := s.GetState(orgID, name); err != nil {
+		return err
+	}
+	return ErrAlreadyLocked
+}
+
+// LockState locks the state for an organization. The lock table's primary
+// key makes acquisition atomic across servers sharing the database.
+func (s *MySQLStorage) LockState(orgID uuid.UUID, name string, lockInfo *LockInfo) error {
+	if err := s.ensureStateTables(); err != nil {
+		return err
+	}
+
+	info, err := json.Marshal(lockInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock info: %w", err)
+	}
+	insertSQL, args, err := sqlbuild.Insert{
+		Into:    mysqlLockTable,
+		Columns: []string{"org_id", "name", "lock_id", "info", "created_at"},
+		Values:  []interface{}{orgID.String(), name, lockInfo.ID, info, time.Now().UTC()},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(insertSQL, args...)
+	if duplicateEntry(err) {
+		// Take over an expired lock, unless another server is faster
+		released, releaseErr := s.releaseExpiredLock(orgID, name)
+		if releaseErr != nil {
+			return releaseErr
+		}
+		if !released {
+			return ErrAlreadyLocked
+		}
+		_, err = s.db.Exec(insertSQL, args...)
+	}
+	if duplicateEntry(err) {
+		return ErrAlreadyLocked
+	}
+	if err != nil {
+		return s.stateErr("lock state", err)
+	}
+	return nil
+}
+
+// SetLockTTL lets locks be taken over once they have not been acquired or
+// refreshed for longer than ttl (0 = never). It must be called before the
+// storage is used.
+func (s *MySQLStorage) SetLockTTL(ttl time.Duration) {
+	s.lockTTL = ttl
+}
+
+// releaseExpiredLock removes the state's lock if it has expired and reports
+// whether the state is unlocked now. Only the lock that was read is
+// removed, so a lock refreshed or taken over in between is kept.
+func (s *MySQLStorage) releaseExpiredLock(orgID uuid.UUID, name string) (bool, error) {
+	if s.lockTTL <= 0 {
+		return false, nil
+	}
+
+	querySQL, args, err := lockQuery(orgID, name)
+	if err != nil {
+		return false, err
+	}
+
+	var info []byte
+	var lastActive time.Time
+	err = s.db.QueryRow(querySQL, args...).Scan(&info, &lastActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, s.stateErr("read lock", err)
+	}
+	now := time.Now().UTC()
+	if !lockExpired(lastActive, s.lockTTL, now) {
+		return false, nil
+	}
+
+	var lock LockInfo
+	if err := json.Unmarshal(info, &lock); err != nil {
+		return false, fmt.Errorf("failed to parse lock info: %w", err)
+	}
+	deleteSQL, args, err := sqlbuild.Delete{
+		From: mysqlLockTable,
+		Where: sqlbuild.E("org_id = ? AND name = ? AND lock_id = ? AND COALESCE(heartbeat_at, created_at) < ?",
+			orgID.String(), name, lock.ID, now.Add(-s.lockTTL)),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.db.Exec(deleteSQL, args...)
+	if err != nil {
+		return false, s.stateErr("release expired lock", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return false, nil
+	}
+	logExpiredLock(orgID, name, &lock, lastActive)
+	return true, nil
+}
+
+// UnlockState unlocks the state for an organization
+func (s *MySQLStorage) UnlockState(orgID uuid.UUID, name string, lockID string) error {
+	if err := s.ensureStateTables(); err != nil {
+		return err
+	}
+
+	deleteSQL, args, err := sqlbuild.Delete{
+		From:  mysqlLockTable,
+		Where: sqlbuild.E("org_id = ? AND name = ? AND lock_id = ?", orgID.String(), name, lockID),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(deleteSQL, args...)
+	if err != nil {
+		return s.stateErr("unlock state", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted > 0 {
+		return nil
+	}
+
+	lock, err := s.GetLock(orgID, name)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotLocked
+	}
+	if err != nil {
+		return err
+	}
+	return &LockHeldError{Lock: *lock}
+}
+
+// RefreshLock records a heartbeat of the lock holder
+func (s *MySQLStorage) RefreshLock(orgID uuid.UUID, name string, lockID string) (time.Time, error) {
+	if err := s.ensureStateTables(); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	updateSQL, args, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("UPDATE ").Ident(mysqlLockTable).
+		Expr(sqlbuild.E(" SET heartbeat_at = ? WHERE org_id = ? AND name = ? AND lock_id = ?", now, orgID.String(), name, lockID)).
+		Build()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	result, err := s.db.Exec(updateSQL, args...)
+	if err != nil {
+		return time.Time{}, s.stateErr("refresh lock", err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated > 0 {
+		return now, nil
+	}
+
+	if _, err := s.GetLock(orgID, name); errors.Is(err, ErrNotFound) {
+		return time.Time{}, ErrNotLocked
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, ErrLockMismatch
+}
+
+// ForceUnlockState removes the lock regardless of its ID
+func (s *MySQLStorage) ForceUnlockState(orgID uuid.UUID, name string) (*LockInfo, error) {
+	lock, err := s.GetLock(orgID, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only remove the lock that was read, so a lock released and taken
+	// again in between is kept
+	deleteSQL, args, err := sqlbuild.Delete{
+		From:  mysqlLockTable,
+		Where: sqlbuild.E("org_id = ? AND name = ? AND lock_id = ?", orgID.String(), name, lock.ID),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec(deleteSQL, args...)
+	if err != nil {
+		return nil, s.stateErr("force-unlock state", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return nil, ErrNotLocked
+	}
+	return lock, nil
+}
+
+// GetLock retrieves lock information. An expired lock is reported as
+// absent, as LockState would take it over.
+func (s *MySQLStorage) GetLock(orgID uuid.UUID, name string) (*LockInfo, error) {
+	if err := s.ensureStateTables(); err != nil {
+		return nil, err
+	}
+
+	querySQL, args, err := lockQuery(orgID, name)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := s.scanLiveLock(s.db.QueryRow(querySQL, args...))
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, ErrNotFound
+	}
+	return lock, nil
+}
+
+// PutStateLocked stores state data if lockID may write it. The lock row is
+// read with FOR UPDATE in the transaction of the write, so a lock cannot be
+// taken, taken over or released between the check and the write, even by
+// another server sharing the database.
+func (s *MySQLStorage) PutStateLocked(orgID uuid.UUID, name string, data []byte, lockID string) error {
+	if err := s.ensureStateTables(); err != nil {
+		return err
+	}
+
+	querySQL, args, err := lockQuery(orgID, name)
+	if err != nil {
+		return err
+	}
+	insertSQL, insertArgs, err := sqlbuild.Insert{
+		Into:    mysqlStateTable,
+		Columns: []string{"org_id", "name", "data", "version", "updated_at"},
+		Values:  []interface{}{orgID.String(), name, data, 1, time.Now().UTC()},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+	insertSQL += " ON DUPLICATE KEY UPDATE data = VALUES(data), version = version + 1, updated_at = VALUES(updated_at)"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return s.stateErr("store state", err)
+	}
+	defer tx.Rollback()
+
+	lock, err := s.scanLiveLock(tx.QueryRow(querySQL+" FOR UPDATE", args...))
+	if err != nil {
+		return err
+	}
+	if err := checkLockHolder(lock, lockID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(insertSQL, insertArgs...); err != nil {
+		return s.stateErr("store state", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return s.stateErr("store state", err)
+	}
+	return nil
+}
+
+// lockQuery selects the lock of a state and when it was last active
+func lockQuery(orgID uuid.UUID, name string) (string, []interface{}, error) {
+	return sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("info"), sqlbuild.E("COALESCE(heartbeat_at, created_at)")},
+		From:    mysqlLockTable,
+		Where:   stateKeyWhere(orgID, name),
+	}.Build(sqlbuild.MySQL)
+}
+
+// scanLiveLock scans the row of lockQuery, returning nil if the state is
+// not locked or its lock has expired
+func (s *MySQLStorage) scanLiveLock(row *sql.Row) (*LockInfo, error) {
+	var info []byte
+	var lastActive time.Time
+	err := row.Scan(&info, &lastActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.stateErr("read lock", err)
+	}
+	if lockExpired(lastActive, s.lockTTL, time.Now().UTC()) {
+		return nil, nil
+	}
+
+	var lock LockInfo
+	if err := json.Unmarshal(info, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock info: %w", err)
+	}
+	return &lock, nil
+}
+
+// duplicateEntry reports whether a MySQL error means a row with the same
+// primary key exists
+func duplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDupEntry
+}
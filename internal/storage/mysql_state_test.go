@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// lockTableDriver is a database/sql driver holding at most one row of the
+// state lock table. Statements that change rows affect none, as when the
+// lock is held by another lock ID.
+type lockTableDriver struct {
+	lock       *LockInfo
+	lastActive time.Time
+}
+
+func (d *lockTableDriver) Open(string) (driver.Conn, error) { return &lockTableConn{d: d}, nil }
+
+type lockTableConn struct{ d *lockTableDriver }
+
+func (c *lockTableConn) Prepare(query string) (driver.Stmt, error) {
+	return &lockTableStmt{d: c.d, query: query}, nil
+}
+func (c *lockTableConn) Close() error              { return nil }
+func (c *lockTableConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type lockTableStmt struct {
+	d     *lockTableDriver
+	query string
+}
+
+func (s *lockTableStmt) Close() error  { return nil }
+func (s *lockTableStmt) NumInput() int { return -1 }
+func (s *lockTableStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s *lockTableStmt) Query([]driver.Value) (driver.Rows, error) {
+	if !strings.Contains(s.query, mysqlLockTable) || s.d.lock == nil {
+		return &lockTableRows{}, nil
+	}
+	info, err := json.Marshal(s.d.lock)
+	if err != nil {
+		return nil, err
+	}
+	return &lockTableRows{row: []driver.Value{info, s.d.lastActive}}, nil
+}
+
+type lockTableRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *lockTableRows) Columns() []string { return []string{"info", "last_active"} }
+func (r *lockTableRows) Close() error      { return nil }
+func (r *lockTableRows) Next(dest []driver.Value) error {
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	copy(dest, r.row)
+	r.done = true
+	return nil
+}
+
+// newLockTableStorage creates MySQL storage on a lockTableDriver database
+func newLockTableStorage(t *testing.T, d *lockTableDriver) *MySQLStorage {
+	name := fmt.Sprintf("lock-table-%d", driverSeq.Add(1))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &MySQLStorage{
+		db:      db,
+		dbName:  "test",
+		inserts: newStmtCache(db),
+		tables:  newTableCache(tableCacheTTL),
+	}
+}
+
+func TestMySQLStorageUnlockWithAnotherLockID(t *testing.T) {
+	d := &lockTableDriver{lock: &LockInfo{ID: "lock-1", Who: "alice@ci"}, lastActive: time.Now().UTC()}
+	s := newLockTableStorage(t, d)
+
+	err := s.UnlockState(uuid.New(), "prod", "lock-2")
+	var lockErr *LockHeldError
+	if !errors.Is(err, ErrLockMismatch) || !errors.As(err, &lockErr) || lockErr.Lock.ID != "lock-1" {
+		t.Errorf("Expected a LockHeldError for lock-1, got %v", err)
+	}
+
+	d.lock = nil
+	if err := s.UnlockState(uuid.New(), "prod", "lock-2"); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Expected ErrNotLocked, got %v", err)
+	}
+}
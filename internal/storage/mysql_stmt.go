@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCachedStatements bounds the prepared statements kept by a stmtCache.
+// Each is prepared on every pooled connection that uses it, and MySQL
+// limits prepared statements server-wide (max_prepared_stmt_count).
+const maxCachedStatements = 256
+
+// stmtCache keeps prepared statements per table, so hot statements such as
+// inserts are parsed by the server once per connection instead of on every
+// call. Statements of a table must be invalidated when its schema changes or
+// it is dropped.
+type stmtCache struct {
+	db *sql.DB
+
+	// Executions hold the read lock, so a statement is never closed while
+	// it is in use
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt // table -> statement
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newStmtCache creates an empty statement cache
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{
+		db:    db,
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+// exec executes the table's cached statement, preparing query first if
+// it is not cached. query must be the same for every call with the table.
+func (c *stmtCache) exec(table, query string, args ...interface{}) (sql.Result, error) {
+	c.mu.RLock()
+	if stmt, ok := c.stmts[table]; ok {
+		defer c.mu.RUnlock()
+		c.hits.Add(1)
+		return stmt.Exec(args...)
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stmt, ok := c.stmts[table]
+	if ok {
+		c.hits.Add(1)
+		return stmt.Exec(args...)
+	}
+	c.misses.Add(1)
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.stmts) >= maxCachedStatements {
+		// Evict an arbitrary statement; hot tables are prepared again quickly
+		for evicted, old := range c.stmts {
+			old.Close()
+			delete(c.stmts, evicted)
+			break
+		}
+	}
+	c.stmts[table] = stmt
+	return stmt.Exec(args...)
+}
+
+// invalidate closes the table's cached statement, if any
+func (c *stmtCache) invalidate(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[table]; ok {
+		stmt.Close()
+		delete(c.stmts, table)
+	}
+}
+
+// close closes all cached statements
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for table, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, table)
+	}
+}
+
+// stats returns the number of cached statements and the cache hits and
+// misses since the cache was created
+func (c *stmtCache) stats() (statements int, hits, misses int64) {
+	c.mu.RLock()
+	statements = len(c.stmts)
+	c.mu.RUnlock()
+	return statements, c.hits.Load(), c.misses.Load()
+}
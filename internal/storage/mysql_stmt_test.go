@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// countingDriver is a database/sql driver whose statements do nothing but
+// wait for latency, and which counts prepares, CREATE TABLEs and closes
+type countingDriver struct {
+	latency  time.Duration
+	prepares atomic.Int64
+	creates  atomic.Int64
+	closes   atomic.Int64
+}
+
+func (d *countingDriver) Open(string) (driver.Conn, error) { return &countingConn{d: d}, nil }
+
+type countingConn struct{ d *countingDriver }
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.prepares.Add(1)
+	if strings.HasPrefix(query, "CREATE TABLE") {
+		c.d.creates.Add(1)
+	}
+	return &countingStmt{d: c.d}, nil
+}
+func (c *countingConn) Close() error              { return nil }
+func (c *countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type countingStmt struct{ d *countingDriver }
+
+func (s *countingStmt) Close() error  { s.d.closes.Add(1); return nil }
+func (s *countingStmt) NumInput() int { return -1 }
+func (s *countingStmt) Exec([]driver.Value) (driver.Result, error) {
+	time.Sleep(s.d.latency)
+	return driver.RowsAffected(1), nil
+}
+func (s *countingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+var driverSeq atomic.Int64
+
+// openCountingDB opens a database backed by a new countingDriver
+func openCountingDB(t testing.TB) (*sql.DB, *countingDriver) {
+	d := &countingDriver{}
+	name := fmt.Sprintf("counting-%d", driverSeq.Add(1))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func TestStmtCacheReusesStatements(t *testing.T) {
+	db, d := openCountingDB(t)
+	cache := newStmtCache(db)
+
+	for i := 0; i < 5; i++ {
+		if _, err := cache.exec("org_a", "INSERT INTO `org_a` (data) VALUES (?)", i); err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+	}
+	cache.exec("org_b", "INSERT INTO `org_b` (data) VALUES (?)", 1)
+
+	if got := d.prepares.Load(); got != 2 {
+		t.Errorf("Expected 2 prepares for 2 tables, got %d", got)
+	}
+	if statements, hits, misses := cache.stats(); statements != 2 || hits != 4 || misses != 2 {
+		t.Errorf("Expected 2 statements, 4 hits and 2 misses, got %d, %d and %d", statements, hits, misses)
+	}
+
+	// A schema change prepares the statement again
+	cache.invalidate("org_a")
+	cache.exec("org_a", "INSERT INTO `org_a` (data) VALUES (?)", 1)
+	if got := d.prepares.Load(); got != 3 {
+		t.Errorf("Expected a new prepare after invalidation, got %d prepares", got)
+	}
+	if got := d.closes.Load(); got != 1 {
+		t.Errorf("Expected the invalidated statement to be closed, got %d closes", got)
+	}
+
+	cache.close()
+	if statements, _, _ := cache.stats(); statements != 0 || d.closes.Load() != 3 {
+		t.Errorf("Expected all statements closed, got %d cached and %d closes", statements, d.closes.Load())
+	}
+}
+
+func TestStmtCacheBounded(t *testing.T) {
+	db, _ := openCountingDB(t)
+	cache := newStmtCache(db)
+
+	for i := 0; i < maxCachedStatements+10; i++ {
+		table := fmt.Sprintf("org_%d", i)
+		cache.exec(table, "INSERT INTO `"+table+"` (data) VALUES (?)", i)
+	}
+	if statements, _, _ := cache.stats(); statements != maxCachedStatements {
+		t.Errorf("Expected %d statements, got %d", maxCachedStatements, statements)
+	}
+}
+
+func TestStmtCacheConcurrentInvalidation(t *testing.T) {
+	db, _ := openCountingDB(t)
+	db.SetMaxOpenConns(4)
+	cache := newStmtCache(db)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := cache.exec("org_a", "INSERT INTO `org_a` (data) VALUES (?)", j); err != nil {
+					t.Errorf("Exec failed: %v", err)
+					return
+				}
+				if j%10 == 0 {
+					cache.invalidate("org_a")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSchemaChanged(t *testing.T) {
+	if !schemaChanged(fmt.Errorf("insert: %w", &mysql.MySQLError{Number: mysqlErrNoSuchTable})) {
+		t.Error("Expected a missing table to invalidate statements")
+	}
+	if schemaChanged(&mysql.MySQLError{Number: 1062}) || schemaChanged(errors.New("connection refused")) {
+		t.Error("Expected other errors to keep statements")
+	}
+	if !noSuchTable(&mysql.MySQLError{Number: mysqlErrNoSuchTable}) || noSuchTable(&mysql.MySQLError{Number: mysqlErrBadField}) || noSuchTable(nil) {
+		t.Error("Expected only ER_NO_SUCH_TABLE to report a missing table")
+	}
+}
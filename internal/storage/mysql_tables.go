@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// tableCacheTTL is how long a table is known to exist without checking.
+// It bounds how long another server's drop of the table goes unnoticed.
+const tableCacheTTL = 5 * time.Minute
+
+// tableCache remembers tables known to exist, so reads and writes skip the
+// information_schema query and CREATE TABLE IF NOT EXISTS on the hot path.
+// Missing tables are not remembered, since another server may create them.
+type tableCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu     sync.Mutex
+	tables map[string]time.Time // table -> when it was known to exist
+}
+
+// newTableCache creates an empty table cache
+func newTableCache(ttl time.Duration) *tableCache {
+	return &tableCache{
+		ttl:    ttl,
+		now:    time.Now,
+		tables: make(map[string]time.Time),
+	}
+}
+
+// exists reports whether the table is known to exist
+func (c *tableCache) exists(table string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen, ok := c.tables[table]
+	if !ok {
+		return false
+	}
+	if c.now().Sub(seen) > c.ttl {
+		delete(c.tables, table)
+		return false
+	}
+	return true
+}
+
+// add remembers that the table exists
+func (c *tableCache) add(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[table] = c.now()
+}
+
+// remove forgets the table, e.g. after it was dropped
+func (c *tableCache) remove(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tables, table)
+}
+
+// size returns the number of tables known to exist
+func (c *tableCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tables)
+}
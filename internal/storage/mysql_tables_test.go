@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTableCache(t *testing.T) {
+	cache := newTableCache(time.Minute)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return clock }
+
+	if cache.exists("org_a") {
+		t.Fatal("Expected an unknown table not to be known")
+	}
+	cache.add("org_a")
+	if !cache.exists("org_a") {
+		t.Error("Expected an added table to be known")
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	if cache.exists("org_a") {
+		t.Error("Expected the table to expire after the TTL")
+	}
+	if cache.size() != 0 {
+		t.Errorf("Expected the expired table to be removed, got %d tables", cache.size())
+	}
+
+	cache.add("org_a")
+	cache.remove("org_a")
+	if cache.exists("org_a") {
+		t.Error("Expected a removed table not to be known")
+	}
+}
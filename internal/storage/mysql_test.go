@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestMySQLStorage creates MySQL storage on a countingDriver database
+// with the given connection pool size
+func newTestMySQLStorage(tb testing.TB, conns int, latency time.Duration) (*MySQLStorage, *countingDriver) {
+	db, d := openCountingDB(tb)
+	d.latency = latency
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
+	return &MySQLStorage{
+		db:      db,
+		dbName:  "test",
+		inserts: newStmtCache(db),
+		tables:  newTableCache(tableCacheTTL),
+	}, d
+}
+
+func TestMySQLStorageConcurrentAppend(t *testing.T) {
+	s, d := newTestMySQLStorage(t, 8, 0)
+	orgs := []uuid.UUID{uuid.New(), uuid.New()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(orgID uuid.UUID) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := s.AppendData(orgID, map[string]interface{}{"n": j}); err != nil {
+					t.Errorf("AppendData failed: %v", err)
+					return
+				}
+			}
+		}(orgs[i%len(orgs)])
+	}
+	wg.Wait()
+
+	// Each table is created once, however many uploads race to create it
+	if got := d.creates.Load(); got != int64(len(orgs)) {
+		t.Errorf("Expected %d CREATE TABLEs, got %d", len(orgs), got)
+	}
+
+	if err := s.DeleteOrgData(orgs[0]); err != nil {
+		t.Fatalf("DeleteOrgData failed: %v", err)
+	}
+	if err := s.AppendData(orgs[0], map[string]interface{}{"n": 0}); err != nil {
+		t.Fatalf("AppendData failed: %v", err)
+	}
+	if got := d.creates.Load(); got != int64(len(orgs))+1 {
+		t.Errorf("Expected the table to be created again after deletion, got %d CREATE TABLEs", got)
+	}
+}
+
+// BenchmarkMySQLAppendDataParallel compares parallel uploads to different
+// orgs through the storage with uploads serialized by a global mutex, as
+// they were before, with a simulated 1ms database round trip
+func BenchmarkMySQLAppendDataParallel(b *testing.B) {
+	orgs := make([]uuid.UUID, 8)
+	for i := range orgs {
+		orgs[i] = uuid.New()
+	}
+	data := map[string]interface{}{"resource_name": "bench"}
+
+	run := func(b *testing.B, serialize bool) {
+		s, _ := newTestMySQLStorage(b, 16, time.Millisecond)
+		var mu sync.Mutex
+		var next int
+		b.SetParallelism(4)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			mu.Lock()
+			orgID := orgs[next%len(orgs)]
+			next++
+			mu.Unlock()
+
+			for pb.Next() {
+				if serialize {
+					mu.Lock()
+				}
+				s.AppendData(orgID, data)
+				if serialize {
+					mu.Unlock()
+				}
+			}
+		})
+	}
+
+	b.Run("global-mutex", func(b *testing.B) { run(b, true) })
+	b.Run("concurrent", func(b *testing.B) { run(b, false) })
+}
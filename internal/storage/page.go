@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Page size limits of data queries
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrInvalidCursor is returned for cursors that were not issued by this service
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DataCursor is a position in an organization's data, which is ordered by
+// ingestion timestamp and then row ID
+type DataCursor struct {
+	Timestamp time.Time
+	RowID     int64
+}
+
+// String encodes the cursor for clients
+func (c DataCursor) String() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + strconv.FormatInt(c.RowID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseDataCursor decodes a cursor returned by a previous page
+func ParseDataCursor(s string) (DataCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return DataCursor{}, ErrInvalidCursor
+	}
+	nanos, rowID, found := strings.Cut(string(raw), ":")
+	if !found {
+		return DataCursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return DataCursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(rowID, 10, 64)
+	if err != nil || id < 0 {
+		return DataCursor{}, ErrInvalidCursor
+	}
+	return DataCursor{Timestamp: time.Unix(0, n).UTC(), RowID: id}, nil
+}
+
+// before reports whether c sorts before other
+func (c DataCursor) before(other DataCursor) bool {
+	if !c.Timestamp.Equal(other.Timestamp) {
+		return c.Timestamp.Before(other.Timestamp)
+	}
+	return c.RowID < other.RowID
+}
+
+// PageQuery selects a page of data: the rows after After, or the rows
+// before Before, or the first rows if neither is set
+type PageQuery struct {
+	After  *DataCursor
+	Before *DataCursor
+	Limit  int
+}
+
+// DataPage is a page of an organization's data in cursor order. Next and
+// Prev are nil at the end and the start of the data.
+type DataPage struct {
+	Uploads []DataUpload
+	Next    *DataCursor
+	Prev    *DataCursor
+}
+
+// DataPager is implemented by data storage backends that can read a page
+// of data without reading all of it
+type DataPager interface {
+	// GetOrgDataPage retrieves a page of an organization's data
+	GetOrgDataPage(orgID uuid.UUID, query PageQuery) (DataPage, error)
+}
+
+// PageOrgData retrieves a page of the organization's data, paging in the
+// backend if it implements DataPager. Otherwise the rows are read in full
+// and numbered in storage order, which is stable for append-only backends.
+func PageOrgData(store DataStorage, orgID uuid.UUID, query PageQuery) (DataPage, error) {
+	if query.Limit <= 0 || query.Limit > MaxPageSize {
+		return DataPage{}, fmt.Errorf("invalid page size %d: must be between 1 and %d", query.Limit, MaxPageSize)
+	}
+	if query.After != nil && query.Before != nil {
+		return DataPage{}, fmt.Errorf("after and before cannot be combined")
+	}
+	if pager, ok := store.(DataPager); ok {
+		return pager.GetOrgDataPage(orgID, query)
+	}
+
+	uploads, err := store.GetOrgData(orgID)
+	if err != nil {
+		return DataPage{}, err
+	}
+
+	cursors := make([]DataCursor, len(uploads))
+	order := make([]int, len(uploads))
+	for i, upload := range uploads {
+		cursors[i] = DataCursor{Timestamp: upload.Timestamp, RowID: int64(i + 1)}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return cursors[order[a]].before(cursors[order[b]])
+	})
+
+	// Select the window [start, end) of the sorted rows
+	start, end := 0, len(order)
+	switch {
+	case query.After != nil:
+		start = sort.Search(len(order), func(i int) bool { return query.After.before(cursors[order[i]]) })
+		end = min(start+query.Limit, len(order))
+	case query.Before != nil:
+		end = sort.Search(len(order), func(i int) bool { return !cursors[order[i]].before(*query.Before) })
+		start = max(end-query.Limit, 0)
+	default:
+		end = min(query.Limit, len(order))
+	}
+
+	page := DataPage{Uploads: make([]DataUpload, 0, end-start)}
+	for _, i := range order[start:end] {
+		page.Uploads = append(page.Uploads, uploads[i])
+	}
+	if start > 0 && end > start {
+		prev := cursors[order[start]]
+		page.Prev = &prev
+	}
+	if end < len(order) && end > start {
+		next := cursors[order[end-1]]
+		page.Next = &next
+	}
+	return page, nil
+}
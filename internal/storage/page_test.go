@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDataCursorRoundTrip(t *testing.T) {
+	cursor := DataCursor{Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 123456000, time.UTC), RowID: 42}
+	parsed, err := ParseDataCursor(cursor.String())
+	if err != nil {
+		t.Fatalf("ParseDataCursor failed: %v", err)
+	}
+	if !parsed.Timestamp.Equal(cursor.Timestamp) || parsed.RowID != cursor.RowID {
+		t.Errorf("Expected %+v, got %+v", cursor, parsed)
+	}
+
+	for _, invalid := range []string{"", "!!", "bm8tY29sb24", "YTox", "MTotMQ"} {
+		if _, err := ParseDataCursor(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestPageOrgData(t *testing.T) {
+	store := NewMemoryDataStorage()
+	orgID := uuid.New()
+	for i := 0; i < 7; i++ {
+		if err := store.AppendData(orgID, map[string]interface{}{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatalf("AppendData failed: %v", err)
+		}
+	}
+
+	names := func(page DataPage) string {
+		var s string
+		for _, upload := range page.Uploads {
+			s += upload.Data["n"].(string)
+		}
+		return s
+	}
+
+	// Crawl forward without skipping or duplicating rows
+	var crawled string
+	query := PageQuery{Limit: 3}
+	var pages []DataPage
+	for {
+		page, err := PageOrgData(store, orgID, query)
+		if err != nil {
+			t.Fatalf("PageOrgData failed: %v", err)
+		}
+		pages = append(pages, page)
+		crawled += names(page)
+		if page.Next == nil {
+			break
+		}
+		query = PageQuery{After: page.Next, Limit: 3}
+	}
+	if crawled != "0123456" || len(pages) != 3 {
+		t.Fatalf("Expected 3 pages covering 0123456, got %d pages: %s", len(pages), crawled)
+	}
+	if pages[0].Prev != nil || pages[1].Prev == nil {
+		t.Errorf("Unexpected prev cursors: %v, %v", pages[0].Prev, pages[1].Prev)
+	}
+
+	// Page back from the last page
+	back, err := PageOrgData(store, orgID, PageQuery{Before: pages[2].Prev, Limit: 3})
+	if err != nil {
+		t.Fatalf("PageOrgData failed: %v", err)
+	}
+	if names(back) != "345" || back.Next == nil || back.Prev == nil {
+		t.Errorf("Expected 345 with both cursors, got %s (%v, %v)", names(back), back.Next, back.Prev)
+	}
+
+	// Rows appended while crawling appear on later pages
+	store.AppendData(orgID, map[string]interface{}{"n": "7"})
+	last, _ := PageOrgData(store, orgID, PageQuery{After: pages[1].Next, Limit: 3})
+	if names(last) != "67" {
+		t.Errorf("Expected 67 after appending, got %s", names(last))
+	}
+
+	if _, err := PageOrgData(store, orgID, PageQuery{Limit: MaxPageSize + 1}); err == nil {
+		t.Error("Expected error for an oversized page")
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/eterrain/tf-backend-service/internal/sqlbuild"
+	"github.com/google/uuid"
+)
+
+// probeFileName is the sentinel file written by CSV storage probes. It does
+// not end in .csv so it is never listed as an organization.
+const probeFileName = ".storage-probe"
+
+// probeTableName is the sentinel table written by MySQL storage probes
+const probeTableName = "_storage_probe"
+
+// probeStateName is the sentinel state written by memory storage probes
+// under the nil org ID, which no authenticated request can use
+const probeStateName = "_storage_probe"
+
+// Prober is implemented by storage backends that can verify they are
+// working with a tiny write, read and delete of a sentinel record
+type Prober interface {
+	// Probe writes, reads back and deletes a sentinel record
+	Probe() error
+}
+
+// Probe writes, syncs, reads back and deletes a sentinel file in the data
+// directory, so degraded or read-only disks are detected
+func (s *CSVStorage) Probe() error {
+	path := filepath.Join(s.dataDir, probeFileName)
+	payload := []byte(uuid.New().String())
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	_, err = file.Write(payload)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write probe file: %w", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to read probe file: %w", err)
+	}
+	if !bytes.Equal(content, payload) {
+		os.Remove(path)
+		return fmt.Errorf("probe file content mismatch")
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete probe file: %w", err)
+	}
+	return nil
+}
+
+// Probe inserts, selects and deletes a row in a sentinel table
+func (s *MySQLStorage) Probe() error {
+	createSQL, _, err := sqlbuild.New(sqlbuild.MySQL).
+		Raw("CREATE TABLE IF NOT EXISTS ").Ident(probeTableName).Raw(` (
+			id VARCHAR(36) PRIMARY KEY,
+			checked_at DATETIME(6) NOT NULL
+		) ENGINE=InnoDB`).
+		Build()
+	if err != nil {
+		return err
+	}
+	s.tableMutex.Lock()
+	_, err = s.db.Exec(createSQL)
+	s.tableMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to create probe table: %w", err)
+	}
+
+	id := uuid.New().String()
+	insertSQL, insertArgs, err := sqlbuild.Insert{
+		Into:    probeTableName,
+		Columns: []string{"id", "checked_at"},
+		Values:  []interface{}{id, time.Now().UTC()},
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+	selectSQL, selectArgs, err := sqlbuild.Select{
+		Columns: []sqlbuild.Expr{sqlbuild.E("id")},
+		From:    probeTableName,
+		Where:   sqlbuild.E("id = ?", id),
+	}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+	deleteSQL, deleteArgs, err := sqlbuild.Delete{From: probeTableName, Where: sqlbuild.E("id = ?", id)}.Build(sqlbuild.MySQL)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(insertSQL, insertArgs...); err != nil {
+		return fmt.Errorf("failed to insert probe row: %w", err)
+	}
+
+	var readID string
+	err = s.db.QueryRow(selectSQL, selectArgs...).Scan(&readID)
+	if _, deleteErr := s.db.Exec(deleteSQL, deleteArgs...); deleteErr != nil && err == nil {
+		return fmt.Errorf("failed to delete probe row: %w", deleteErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read probe row: %w", err)
+	}
+	return nil
+}
+
+// Probe checks both backends; either failing degrades dual storage
+func (s *DualStorage) Probe() error {
+	var errs []error
+	if err := s.faults.Inject(faults.LayerCSV, uuid.Nil); err != nil {
+		errs = append(errs, fmt.Errorf("CSV: %w", err))
+	} else if err := s.csv.Probe(); err != nil {
+		errs = append(errs, fmt.Errorf("CSV: %w", err))
+	}
+	if err := s.faults.Inject(faults.LayerMySQL, uuid.Nil); err != nil {
+		errs = append(errs, fmt.Errorf("MySQL: %w", err))
+	} else if err := s.mysql.Probe(); err != nil {
+		errs = append(errs, fmt.Errorf("MySQL: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Probe writes, reads back and deletes a sentinel state
+func (m *MemoryStorage) Probe() error {
+	payload := []byte(uuid.New().String())
+	if err := m.PutState(uuid.Nil, probeStateName, payload); err != nil {
+		return fmt.Errorf("failed to write probe state: %w", err)
+	}
+
+	state, err := m.GetState(uuid.Nil, probeStateName)
+	if err != nil {
+		return fmt.Errorf("failed to read probe state: %w", err)
+	}
+	if !bytes.Equal(state.Data, payload) {
+		return fmt.Errorf("probe state content mismatch")
+	}
+
+	if err := m.DeleteState(uuid.Nil, probeStateName); err != nil {
+		return fmt.Errorf("failed to delete probe state: %w", err)
+	}
+	return nil
+}
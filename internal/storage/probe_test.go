@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCSVProbe(t *testing.T) {
+	store, err := NewCSVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if err := store.Probe(); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.dataDir, probeFileName)); !os.IsNotExist(err) {
+		t.Error("Probe must delete its sentinel file")
+	}
+
+	// A data directory that can no longer be written fails the probe
+	os.RemoveAll(store.dataDir)
+	if err := store.Probe(); err == nil {
+		t.Error("Expected probe to fail without a data directory")
+	}
+}
+
+func TestMemoryProbe(t *testing.T) {
+	store := NewMemoryStorage()
+
+	if err := store.Probe(); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if _, err := store.GetState(uuid.Nil, probeStateName); err != ErrNotFound {
+		t.Errorf("Probe must delete its sentinel state, got: %v", err)
+	}
+}
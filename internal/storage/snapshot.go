@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// snapshotVersion is the format version of memory storage snapshot files
+const snapshotVersion = 1
+
+// memorySnapshot is the on-disk format of a memory storage snapshot
+type memorySnapshot struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	States  []snapshotState `json:"states"`
+	Locks   []snapshotLock  `json:"locks"`
+}
+
+// snapshotState is a stored state in a snapshot; Data is base64-encoded
+type snapshotState struct {
+	OrgID   uuid.UUID `json:"org_id"`
+	Name    string    `json:"name"`
+	Data    []byte    `json:"data"`
+	Version int64     `json:"version"`
+}
+
+// snapshotLock is a held lock in a snapshot
+type snapshotLock struct {
+	OrgID uuid.UUID `json:"org_id"`
+	Name  string    `json:"name"`
+	Lock  LockInfo  `json:"lock"`
+}
+
+// lockFile is the on-disk format of the lock file next to a snapshot. It is
+// rewritten on every lock change, so it is never older than the snapshot.
+type lockFile struct {
+	Version int            `json:"version"`
+	SavedAt time.Time      `json:"saved_at"`
+	Locks   []snapshotLock `json:"locks"`
+}
+
+// NewPersistentMemoryStorage creates an in-memory storage that is snapshotted
+// to the given file, for dev/test deployments that should survive restarts.
+// States and locks are loaded from the file if it exists. State changes are
+// written every interval (if any) and on Close; a crash loses at most one
+// interval. Lock changes are written to a separate lock file before they are
+// acknowledged, so a lock granted before a crash is still held after restart.
+func NewPersistentMemoryStorage(snapshotPath string, interval time.Duration) (*MemoryStorage, error) {
+	m := NewMemoryStorage()
+	m.snapshotPath = snapshotPath
+	m.stopChan = make(chan struct{})
+
+	if err := m.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := m.loadLockFile(); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		m.wg.Add(1)
+		go m.snapshotRoutine(interval)
+	}
+
+	return m, nil
+}
+
+// loadSnapshot restores states and locks from the snapshot file
+func (m *MemoryStorage) loadSnapshot() error {
+	data, err := os.ReadFile(m.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read memory snapshot: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse memory snapshot %s: %w", m.snapshotPath, err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported memory snapshot version %d in %s", snapshot.Version, m.snapshotPath)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, state := range snapshot.States {
+		m.states[m.stateKey(state.OrgID, state.Name)] = &StateData{
+			OrgID:   state.OrgID,
+			Name:    state.Name,
+			Data:    state.Data,
+			Version: state.Version,
+		}
+	}
+	now := time.Now()
+	for _, lock := range snapshot.Locks {
+		lockCopy := lock.Lock
+		key := m.stateKey(lock.OrgID, lock.Name)
+		m.locks[key] = &lockCopy
+		m.heartbeats[key] = now
+	}
+
+	slog.Info("Loaded memory snapshot", "file", m.snapshotPath, "states", len(snapshot.States),
+		"locks", len(snapshot.Locks), "saved_at", snapshot.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// lockFilePath returns the path of the lock file kept next to the snapshot
+func (m *MemoryStorage) lockFilePath() string {
+	return m.snapshotPath + ".locks"
+}
+
+// loadLockFile replaces the snapshotted locks with those of the lock file,
+// which has every lock change up to the last acknowledged one. Snapshots
+// written before the lock file existed keep their locks.
+func (m *MemoryStorage) loadLockFile() error {
+	path := m.lockFilePath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	var locks lockFile
+	if err := json.Unmarshal(data, &locks); err != nil {
+		return fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	if locks.Version != snapshotVersion {
+		return fmt.Errorf("unsupported lock file version %d in %s", locks.Version, path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.locks = make(map[string]*LockInfo, len(locks.Locks))
+	for _, lock := range locks.Locks {
+		lockCopy := lock.Lock
+		key := m.stateKey(lock.OrgID, lock.Name)
+		m.locks[key] = &lockCopy
+		m.heartbeats[key] = now
+	}
+
+	slog.Info("Loaded locks", "file", path, "locks", len(locks.Locks), "saved_at", locks.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// persistLocks writes all held locks to the lock file. The caller must hold
+// m.mu, which keeps lock file writes in the order of the changes. It is a
+// no-op without a snapshot file.
+func (m *MemoryStorage) persistLocks() error {
+	if m.snapshotPath == "" {
+		return nil
+	}
+
+	locks := lockFile{
+		Version: snapshotVersion,
+		SavedAt: time.Now().UTC(),
+		Locks:   make([]snapshotLock, 0, len(m.locks)),
+	}
+	for key, lock := range m.locks {
+		orgID, name, ok := parseStateKey(key)
+		if !ok || orgID == uuid.Nil {
+			continue
+		}
+		locks.Locks = append(locks.Locks, snapshotLock{OrgID: orgID, Name: name, Lock: *lock})
+	}
+	return writeLockFile(m.lockFilePath(), &locks)
+}
+
+// snapshotRoutine periodically writes snapshots until the storage is closed
+func (m *MemoryStorage) snapshotRoutine(interval time.Duration) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Snapshot(); err != nil {
+				slog.Error("Failed to snapshot memory storage", "error", err)
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// Snapshot writes states and locks to the snapshot file if they changed
+// since the last snapshot. The file is replaced atomically so a crash never
+// leaves a partial file. It is a no-op without a snapshot file.
+func (m *MemoryStorage) Snapshot() error {
+	if m.snapshotPath == "" {
+		return nil
+	}
+
+	// Serialize snapshots so an older one never replaces a newer one
+	m.snapshotMu.Lock()
+	defer m.snapshotMu.Unlock()
+
+	// State data is never modified in place, so the snapshot can share it
+	// and be written without holding the storage lock
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	snapshot := memorySnapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now().UTC(),
+		States:  make([]snapshotState, 0, len(m.states)),
+		Locks:   make([]snapshotLock, 0, len(m.locks)),
+	}
+	for _, state := range m.states {
+		// Skip the health probe sentinel
+		if state.OrgID == uuid.Nil {
+			continue
+		}
+		snapshot.States = append(snapshot.States, snapshotState{
+			OrgID:   state.OrgID,
+			Name:    state.Name,
+			Data:    state.Data,
+			Version: state.Version,
+		})
+	}
+	for key, lock := range m.locks {
+		orgID, name, ok := parseStateKey(key)
+		if !ok || orgID == uuid.Nil {
+			continue
+		}
+		snapshot.Locks = append(snapshot.Locks, snapshotLock{OrgID: orgID, Name: name, Lock: *lock})
+	}
+	m.dirty = false
+	m.mu.Unlock()
+
+	if err := writeSnapshot(m.snapshotPath, &snapshot); err != nil {
+		// Retry on the next interval
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot file
+func writeSnapshot(path string, snapshot *memorySnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	// State may contain secrets, so the snapshot is only readable by the owner
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write memory snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace memory snapshot: %w", err)
+	}
+	return nil
+}
+
+// writeLockFile atomically replaces the lock file. Unlike snapshots, the
+// file is synced before the rename: a lock is only granted once it is on disk.
+func writeLockFile(path string, locks *lockFile) error {
+	data, err := json.Marshal(locks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create lock file directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create lock file: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync lock file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close lock file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace lock file: %w", err)
+	}
+	return nil
+}
+
+// parseStateKey splits an "orgID:name" key
+func parseStateKey(key string) (uuid.UUID, string, bool) {
+	org, name, ok := strings.Cut(key, ":")
+	if !ok {
+		return uuid.Nil, "", false
+	}
+	orgID, err := uuid.Parse(org)
+	if err != nil {
+		return uuid.Nil, "", false
+	}
+	return orgID, name, true
+}
+
+// Close stops periodic snapshots and writes a final snapshot. It is a no-op
+// for storages created without a snapshot file.
+func (m *MemoryStorage) Close() error {
+	if m.stopChan == nil {
+		return nil
+	}
+	close(m.stopChan)
+	m.wg.Wait()
+	return m.Snapshot()
+}
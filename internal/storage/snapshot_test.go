@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryStorageSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	store, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	orgID := uuid.New()
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	store.PutState(orgID, "prod", []byte(`{"serial":2}`))
+	store.PutState(orgID, "dev", []byte(`{"serial":1}`))
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1", Who: "alice@host", Operation: "OperationTypeApply"})
+	if err := store.Probe(); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Snapshot not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected snapshot mode 0600, got %v", info.Mode().Perm())
+	}
+
+	restored, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	defer restored.Close()
+
+	state, err := restored.GetState(orgID, "prod")
+	if err != nil {
+		t.Fatalf("State not restored: %v", err)
+	}
+	if string(state.Data) != `{"serial":2}` || state.Version != 2 {
+		t.Errorf("Unexpected restored state: %s (version %d)", state.Data, state.Version)
+	}
+	if _, err := restored.GetState(orgID, "dev"); err != nil {
+		t.Errorf("Second state not restored: %v", err)
+	}
+
+	lock, err := restored.GetLock(orgID, "prod")
+	if err != nil || lock.ID != "lock-1" || lock.Who != "alice@host" {
+		t.Errorf("Lock not restored: %+v (err=%v)", lock, err)
+	}
+	if err := restored.DeleteState(orgID, "prod"); err != ErrAlreadyLocked {
+		t.Errorf("Expected restored lock to block delete, got %v", err)
+	}
+
+	if len(restored.states) != 2 {
+		t.Errorf("Expected only the 2 org states, got %d", len(restored.states))
+	}
+}
+
+func TestMemoryStorageSnapshotSkipsUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	store, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected no snapshot without changes")
+	}
+
+	store.PutState(uuid.New(), "prod", []byte(`{}`))
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected snapshot after a change: %v", err)
+	}
+}
+
+func TestMemoryStorageSnapshotInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	os.WriteFile(path, []byte("not json"), 0600)
+
+	if _, err := NewPersistentMemoryStorage(path, 0); err == nil {
+		t.Error("Expected error for corrupt snapshot")
+	}
+}
+
+// lockHelperEnv makes the test binary act as a server that takes a lock and
+// then waits to be killed
+const lockHelperEnv = "MEMORY_STORAGE_LOCK_HELPER"
+
+func TestMemoryStorageLockHelperProcess(t *testing.T) {
+	path := os.Getenv(lockHelperEnv)
+	if path == "" {
+		t.Skip("only runs as a helper process")
+	}
+
+	store, err := NewPersistentMemoryStorage(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	orgID := uuid.MustParse(os.Getenv(lockHelperEnv + "_ORG"))
+	store.PutState(orgID, "prod", []byte(`{"serial":1}`))
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-1", Who: "alice@host"}); err != nil {
+		t.Fatalf("LockState failed: %v", err)
+	}
+	os.Stdout.WriteString("locked\n")
+	select {}
+}
+
+func TestMemoryStorageLockSurvivesKill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a helper process")
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	orgID := uuid.New()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMemoryStorageLockHelperProcess$")
+	cmd.Env = append(os.Environ(), lockHelperEnv+"="+path, lockHelperEnv+"_ORG="+orgID.String())
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start helper: %v", err)
+	}
+	defer cmd.Wait()
+
+	// Kill the holder as soon as it has the lock, before any snapshot
+	scanner := bufio.NewScanner(stdout)
+	locked := false
+	for scanner.Scan() {
+		if scanner.Text() == "locked" {
+			locked = true
+			break
+		}
+	}
+	cmd.Process.Kill()
+	if !locked {
+		t.Fatal("Helper exited without taking the lock")
+	}
+
+	restored, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to recover storage: %v", err)
+	}
+	lock, err := restored.GetLock(orgID, "prod")
+	if err != nil || lock.ID != "lock-1" || lock.Who != "alice@host" {
+		t.Fatalf("Lock lost in crash: %+v (err=%v)", lock, err)
+	}
+	if err := restored.LockState(orgID, "prod", &LockInfo{ID: "lock-2"}); err != ErrAlreadyLocked {
+		t.Errorf("Expected the recovered lock to block others, got %v", err)
+	}
+
+	// The unlock is durable too, even if this process also dies before a
+	// snapshot
+	if err := restored.UnlockState(orgID, "prod", "lock-1"); err != nil {
+		t.Fatalf("UnlockState failed: %v", err)
+	}
+	again, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to recover storage: %v", err)
+	}
+	if _, err := again.GetLock(orgID, "prod"); err != ErrNotFound {
+		t.Errorf("Expected the unlock to survive a crash, got %v", err)
+	}
+}
+
+func TestMemoryStorageLockFileOverridesSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	orgID := uuid.New()
+
+	store, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := store.ForceUnlockState(orgID, "prod"); err != nil {
+		t.Fatalf("ForceUnlockState failed: %v", err)
+	}
+
+	// The snapshot still has the lock, but the lock file is newer
+	restored, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to recover storage: %v", err)
+	}
+	if _, err := restored.GetLock(orgID, "prod"); err != ErrNotFound {
+		t.Errorf("Expected the forced unlock to win over the snapshot, got %v", err)
+	}
+
+	// Without a lock file, the snapshotted locks are used
+	os.Remove(path + ".locks")
+	restored, err = NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to recover storage: %v", err)
+	}
+	if lock, err := restored.GetLock(orgID, "prod"); err != nil || lock.ID != "lock-1" {
+		t.Errorf("Expected the snapshotted lock, got %+v (err=%v)", lock, err)
+	}
+}
+
+func TestMemoryStorageLockNotGrantedWhenPersistFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	orgID := uuid.New()
+
+	store, err := NewPersistentMemoryStorage(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	store.LockState(orgID, "held", &LockInfo{ID: "lock-1"})
+
+	// A non-empty directory in place of the lock file cannot be replaced
+	os.Remove(path + ".locks")
+	if err := os.MkdirAll(filepath.Join(path+".locks", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.LockState(orgID, "prod", &LockInfo{ID: "lock-2"}); err == nil {
+		t.Fatal("Expected an error when the lock cannot be persisted")
+	}
+	if _, err := store.GetLock(orgID, "prod"); err != ErrNotFound {
+		t.Errorf("Expected an unpersisted lock not to be held, got %v", err)
+	}
+	if err := store.UnlockState(orgID, "held", "lock-1"); err == nil {
+		t.Fatal("Expected an error when the unlock cannot be persisted")
+	}
+	if _, err := store.GetLock(orgID, "held"); err != nil {
+		t.Errorf("Expected an unpersisted unlock to keep the lock, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -10,6 +12,8 @@ var (
 	ErrNotFound      = errors.New("state not found")
 	ErrAlreadyLocked = errors.New("state already locked")
 	ErrNotLocked     = errors.New("state is not locked")
+	ErrLockMismatch  = errors.New("state is locked by another lock ID")
+	ErrStateCorrupt  = errors.New("state failed checksum verification")
 )
 
 // StateData represents Terraform state data
@@ -46,13 +50,92 @@ type Storage interface {
 	// LockState locks the state for an organization
 	LockState(orgID uuid.UUID, name string, lockInfo *LockInfo) error
 
-	// UnlockState unlocks the state for an organization
+	// UnlockState unlocks the state for an organization. It fails with
+	// ErrNotLocked if the state is not locked and with a *LockHeldError if
+	// another lock ID holds the lock.
 	UnlockState(orgID uuid.UUID, name string, lockID string) error
 
+	// ForceUnlockState removes the lock regardless of its ID and returns the removed lock
+	ForceUnlockState(orgID uuid.UUID, name string) (*LockInfo, error)
+
 	// GetLock retrieves lock information
 	GetLock(orgID uuid.UUID, name string) (*LockInfo, error)
 }
 
+// StateSummary describes a stored state without its data
+type StateSummary struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+	Size    int    `json:"size"`
+	Locked  bool   `json:"locked"`
+}
+
+// StateLister is implemented by state storage backends that can list an
+// organization's states (Terraform workspaces)
+type StateLister interface {
+	// ListStates returns the organization's states ordered by name
+	ListStates(orgID uuid.UUID) ([]StateSummary, error)
+}
+
+// LockRefresher is implemented by state storage backends that let lock
+// holders extend their lease with heartbeats, so long applies keep their lock
+type LockRefresher interface {
+	// RefreshLock records a heartbeat of the holder of lockID and returns its
+	// time. It fails with ErrNotLocked or ErrLockMismatch if the caller does
+	// not hold the lock.
+	RefreshLock(orgID uuid.UUID, name string, lockID string) (time.Time, error)
+}
+
+// LockExpirer is implemented by state storage backends whose locks can
+// expire, so a lock left behind by a client that died does not block the
+// state forever
+type LockExpirer interface {
+	// SetLockTTL lets a lock be taken over by another client once it has
+	// seen no heartbeat (or, without heartbeats, has been held) for longer
+	// than ttl. Zero keeps locks until they are released.
+	SetLockTTL(ttl time.Duration)
+}
+
+// LockCheckedWriter is implemented by state storage backends that check a
+// write's lock atomically with the write, so a lock taken, taken over or
+// released while the write waited is respected
+type LockCheckedWriter interface {
+	// PutStateLocked stores the state like PutState if lockID may write it:
+	// with an empty lockID the state must not be locked, otherwise lockID
+	// must hold the lock. It fails with a *LockHeldError if another lock is
+	// held and with ErrNotLocked if lockID is given but the state is not
+	// locked. Expired locks count as released.
+	PutStateLocked(orgID uuid.UUID, name string, data []byte, lockID string) error
+}
+
+// LockHeldError is returned by lock-checked writes and unlocks of a state
+// locked by another lock ID. It matches ErrLockMismatch.
+type LockHeldError struct {
+	Lock LockInfo
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("state is locked by lock ID %s", e.Lock.ID)
+}
+
+func (e *LockHeldError) Unwrap() error {
+	return ErrLockMismatch
+}
+
+// checkLockHolder checks that lockID may write a state holding lock, nil if
+// the state is not locked or its lock has expired
+func checkLockHolder(lock *LockInfo, lockID string) error {
+	switch {
+	case lock == nil && lockID == "":
+		return nil
+	case lock == nil:
+		return ErrNotLocked
+	case lock.ID != lockID:
+		return &LockHeldError{Lock: *lock}
+	}
+	return nil
+}
+
 // DataStorage defines the interface for storing data uploads
 type DataStorage interface {
 	// AppendData appends data to the organization's storage
@@ -60,4 +143,14 @@ type DataStorage interface {
 
 	// GetOrgData retrieves all data for an organization
 	GetOrgData(orgID uuid.UUID) ([]DataUpload, error)
+
+	// DeleteOrgData removes all data for an organization
+	DeleteOrgData(orgID uuid.UUID) error
+}
+
+// SizeReporter is implemented by data storage backends that can report how
+// many bytes an organization's data occupies
+type SizeReporter interface {
+	// OrgDataSize returns the stored size of the organization's data in bytes
+	OrgDataSize(orgID uuid.UUID) (int64, error)
 }
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/faults"
+	"github.com/google/uuid"
+)
+
+// StateStreamer is implemented by state backends that can store a state from
+// a stream, so large states are not buffered in full before they are stored
+type StateStreamer interface {
+	// NewStateWriter starts writing a state. The stored state is only
+	// replaced when the writer is committed, which checks lockID like
+	// LockCheckedWriter.PutStateLocked.
+	NewStateWriter(orgID uuid.UUID, name string, lockID string) (StateWriter, error)
+}
+
+// StateWriter receives the content of a state
+type StateWriter interface {
+	io.Writer
+
+	// Commit replaces the stored state with the written content
+	Commit() error
+
+	// Abort discards the written content and keeps the stored state
+	Abort() error
+}
+
+// memoryStateWriter collects a state and stores it on commit
+type memoryStateWriter struct {
+	storage *MemoryStorage
+	orgID   uuid.UUID
+	name    string
+	lockID  string
+	buf     bytes.Buffer
+	done    bool
+}
+
+// NewStateWriter starts writing a state. The content is collected directly
+// into the buffer that is stored, so no further copy is made on commit.
+func (m *MemoryStorage) NewStateWriter(orgID uuid.UUID, name string, lockID string) (StateWriter, error) {
+	if err := m.faults.Inject(faults.LayerState, orgID); err != nil {
+		return nil, err
+	}
+	return &memoryStateWriter{storage: m, orgID: orgID, name: name, lockID: lockID}, nil
+}
+
+func (w *memoryStateWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, fmt.Errorf("state writer is closed")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memoryStateWriter) Commit() error {
+	if w.done {
+		return fmt.Errorf("state writer is closed")
+	}
+	w.done = true
+
+	m := w.storage
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkLockHolder(m.liveLock(m.stateKey(w.orgID, w.name), time.Now()), w.lockID); err != nil {
+		return err
+	}
+	m.storeState(w.orgID, w.name, w.buf.Bytes())
+	return nil
+}
+
+func (w *memoryStateWriter) Abort() error {
+	w.done = true
+	w.buf = bytes.Buffer{}
+	return nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryStateWriter(t *testing.T) {
+	store := NewMemoryStorage()
+	orgID := uuid.New()
+	if err := store.PutState(orgID, "prod", []byte(`{"serial":1}`)); err != nil {
+		t.Fatalf("PutState failed: %v", err)
+	}
+
+	// Aborted writes keep the stored state
+	writer, err := store.NewStateWriter(orgID, "prod", "")
+	if err != nil {
+		t.Fatalf("NewStateWriter failed: %v", err)
+	}
+	writer.Write([]byte(`{"serial":`))
+	writer.Abort()
+
+	state, err := store.GetState(orgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":1}` || state.Version != 1 {
+		t.Fatalf("Aborted write changed the state: %+v (err=%v)", state, err)
+	}
+
+	// Committed writes replace it and bump the version
+	writer, _ = store.NewStateWriter(orgID, "prod", "")
+	writer.Write([]byte(`{"serial":`))
+	writer.Write([]byte(`2}`))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	state, err = store.GetState(orgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":2}` || state.Version != 2 {
+		t.Fatalf("Unexpected state after commit: %+v (err=%v)", state, err)
+	}
+
+	if _, err := writer.Write([]byte("x")); err == nil {
+		t.Error("Expected write after commit to fail")
+	}
+	if _, err := store.GetState(uuid.New(), "prod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another org, got %v", err)
+	}
+}
+
+func TestMemoryStateWriterChecksLockOnCommit(t *testing.T) {
+	store := NewMemoryStorage()
+	orgID := uuid.New()
+
+	// A lock taken while the state was being written rejects the commit
+	writer, _ := store.NewStateWriter(orgID, "prod", "")
+	writer.Write([]byte(`{"serial":1}`))
+	store.LockState(orgID, "prod", &LockInfo{ID: "lock-1"})
+	var held *LockHeldError
+	if err := writer.Commit(); !errors.As(err, &held) || held.Lock.ID != "lock-1" {
+		t.Fatalf("Expected a LockHeldError, got %v", err)
+	}
+	if _, err := store.GetState(orgID, "prod"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the rejected write not to be stored, got %v", err)
+	}
+
+	writer, _ = store.NewStateWriter(orgID, "prod", "lock-1")
+	writer.Write([]byte(`{"serial":1}`))
+	if err := writer.Commit(); err != nil {
+		t.Errorf("Expected the holder's commit to succeed, got %v", err)
+	}
+}
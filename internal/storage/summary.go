@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Aggregation functions over numeric attribute values
+const (
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateCount = "count"
+)
+
+// MaxAggregations limits the aggregations of a summary query
+const MaxAggregations = 10
+
+// MaxGroupByFields limits the fields a summary can be grouped by
+const MaxGroupByFields = 5
+
+// Aggregation applies a function to the numeric values of a field. Values
+// that are not JSON numbers, such as numeric strings, are ignored.
+type Aggregation struct {
+	Func  string
+	Field string
+}
+
+// Name returns the aggregation as written in a query, e.g. "sum:cost_monthly"
+func (a Aggregation) Name() string {
+	return a.Func + ":" + a.Field
+}
+
+// ParseAggregations parses a comma-separated aggregation list such as
+// "sum:cost_monthly,avg:cpu", dropping duplicates
+func ParseAggregations(list string) ([]Aggregation, error) {
+	var aggregations []Aggregation
+	seen := make(map[Aggregation]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fn, field, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid aggregation %q: must be function:field", part)
+		}
+		switch fn {
+		case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount:
+		default:
+			return nil, fmt.Errorf("invalid aggregation %q: unsupported function %q (supported: sum, avg, min, max, count)", part, fn)
+		}
+		if len(field) > 200 || !fieldRegex.MatchString(field) {
+			return nil, fmt.Errorf("invalid aggregation %q: only alphanumeric characters, hyphens and underscores separated by dots allowed", part)
+		}
+		aggregation := Aggregation{Func: fn, Field: field}
+		if seen[aggregation] {
+			continue
+		}
+		seen[aggregation] = true
+		aggregations = append(aggregations, aggregation)
+	}
+	if len(aggregations) > MaxAggregations {
+		return nil, fmt.Errorf("too many aggregations: maximum %d", MaxAggregations)
+	}
+	return aggregations, nil
+}
+
+// ParseGroupBy parses a comma-separated list of fields to group by
+func ParseGroupBy(list string) ([]string, error) {
+	fields, err := ParseFields(list)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > MaxGroupByFields {
+		return nil, fmt.Errorf("too many group_by fields: maximum %d", MaxGroupByFields)
+	}
+	return fields, nil
+}
+
+// SummaryQuery groups an organization's rows by fields and aggregates
+// numeric fields per group. Without group-by fields all rows form a single
+// group.
+type SummaryQuery struct {
+	GroupBy      []string
+	Aggregations []Aggregation
+}
+
+// SummaryGroup holds the row count and aggregates of one group. Key maps
+// each group-by field to its value, which is null for rows without it.
+// Values maps each aggregation name to its result; sums and counts over no
+// numeric values are 0, averages, minimums and maximums are null.
+type SummaryGroup struct {
+	Key    map[string]interface{} `json:"key"`
+	Count  int64                  `json:"count"`
+	Values map[string]*float64    `json:"values"`
+}
+
+// Summarizer is implemented by data storage backends that can aggregate in
+// the query, e.g. with SQL GROUP BY
+type Summarizer interface {
+	// SummarizeOrgData groups and aggregates the organization's data
+	SummarizeOrgData(orgID uuid.UUID, query SummaryQuery) ([]SummaryGroup, error)
+}
+
+// SummarizeOrgData groups and aggregates the organization's data, in the
+// backend if it implements Summarizer and over the full rows otherwise.
+// Groups are ordered by key.
+func SummarizeOrgData(store DataStorage, orgID uuid.UUID, query SummaryQuery) ([]SummaryGroup, error) {
+	var groups []SummaryGroup
+	if summarizer, ok := store.(Summarizer); ok {
+		var err error
+		if groups, err = summarizer.SummarizeOrgData(orgID, query); err != nil {
+			return nil, err
+		}
+	} else {
+		uploads, err := store.GetOrgData(orgID)
+		if err != nil {
+			return nil, err
+		}
+		groups = summarizeUploads(uploads, query)
+	}
+	SortSummaryGroups(groups, query.GroupBy)
+	return groups, nil
+}
+
+// aggregateState accumulates the numeric values of one aggregation
+type aggregateState struct {
+	sum      float64
+	count    int64
+	min, max float64
+}
+
+// add accumulates a value
+func (a *aggregateState) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+// result returns the value of the aggregation function
+func (a *aggregateState) result(fn string) *float64 {
+	var v float64
+	switch fn {
+	case AggregateSum:
+		v = a.sum
+	case AggregateCount:
+		v = float64(a.count)
+	case AggregateAvg:
+		if a.count == 0 {
+			return nil
+		}
+		v = a.sum / float64(a.count)
+	case AggregateMin:
+		if a.count == 0 {
+			return nil
+		}
+		v = a.min
+	case AggregateMax:
+		if a.count == 0 {
+			return nil
+		}
+		v = a.max
+	}
+	return &v
+}
+
+// summarizeUploads groups and aggregates rows in memory
+func summarizeUploads(uploads []DataUpload, query SummaryQuery) []SummaryGroup {
+	type group struct {
+		key        map[string]interface{}
+		count      int64
+		aggregates []aggregateState
+	}
+
+	groups := make(map[string]*group)
+	var order []string
+	for _, upload := range uploads {
+		key := make(map[string]interface{}, len(query.GroupBy))
+		for _, field := range query.GroupBy {
+			value, _ := lookupField(upload.Data, field)
+			key[field] = value
+		}
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			continue
+		}
+
+		g, ok := groups[string(encoded)]
+		if !ok {
+			g = &group{key: key, aggregates: make([]aggregateState, len(query.Aggregations))}
+			groups[string(encoded)] = g
+			order = append(order, string(encoded))
+		}
+		g.count++
+		for i, aggregation := range query.Aggregations {
+			value, _ := lookupField(upload.Data, aggregation.Field)
+			if v, ok := NumericValue(value); ok {
+				g.aggregates[i].add(v)
+			}
+		}
+	}
+
+	result := make([]SummaryGroup, 0, len(groups))
+	for _, encoded := range order {
+		g := groups[encoded]
+		values := make(map[string]*float64, len(query.Aggregations))
+		for i, aggregation := range query.Aggregations {
+			values[aggregation.Name()] = g.aggregates[i].result(aggregation.Func)
+		}
+		result = append(result, SummaryGroup{Key: g.key, Count: g.count, Values: values})
+	}
+	return result
+}
+
+// NumericValue returns the value of a JSON number
+func NumericValue(value interface{}) (float64, bool) {
+	var v float64
+	switch n := value.(type) {
+	case float64:
+		v = n
+	case int:
+		v = float64(n)
+	case int64:
+		v = float64(n)
+	case json.Number:
+		parsed, err := strconv.ParseFloat(string(n), 64)
+		if err != nil {
+			return 0, false
+		}
+		v = parsed
+	default:
+		return 0, false
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// SortSummaryGroups orders groups by their key values in group-by field order
+func SortSummaryGroups(groups []SummaryGroup, groupBy []string) {
+	sortKey := func(group SummaryGroup) []string {
+		key := make([]string, len(groupBy))
+		for i, field := range groupBy {
+			encoded, _ := json.Marshal(group.Key[field])
+			key[i] = string(encoded)
+		}
+		return key
+	}
+	slices.SortStableFunc(groups, func(a, b SummaryGroup) int {
+		return slices.Compare(sortKey(a), sortKey(b))
+	})
+}
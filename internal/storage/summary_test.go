@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseAggregations(t *testing.T) {
+	aggregations, err := ParseAggregations(" sum:cost_monthly,avg:tags.cpu,,sum:cost_monthly ")
+	if err != nil {
+		t.Fatalf("ParseAggregations failed: %v", err)
+	}
+	if len(aggregations) != 2 || aggregations[0].Name() != "sum:cost_monthly" || aggregations[1].Name() != "avg:tags.cpu" {
+		t.Errorf("Unexpected aggregations %+v", aggregations)
+	}
+
+	for _, invalid := range []string{"cost", "median:cost", "sum:", "sum:a;b", "sum:a..b"} {
+		if _, err := ParseAggregations(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestSummarizeOrgData(t *testing.T) {
+	store := NewMemoryDataStorage()
+	orgID := uuid.New()
+	for _, row := range []map[string]interface{}{
+		{"provider": "gcp", "cost_monthly": 10.0},
+		{"provider": "aws", "cost_monthly": 100.0, "cpu": 2.0},
+		{"provider": "aws", "cost_monthly": 20.5, "cpu": 4.0},
+		{"provider": "aws", "cost_monthly": "12"},
+		{"cost_monthly": 1.0},
+	} {
+		if err := store.AppendData(orgID, row); err != nil {
+			t.Fatalf("AppendData failed: %v", err)
+		}
+	}
+
+	groups, err := SummarizeOrgData(store, orgID, SummaryQuery{
+		GroupBy: []string{"provider"},
+		Aggregations: []Aggregation{
+			{Func: AggregateSum, Field: "cost_monthly"},
+			{Func: AggregateCount, Field: "cost_monthly"},
+			{Func: AggregateAvg, Field: "cpu"},
+			{Func: AggregateMax, Field: "cpu"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SummarizeOrgData failed: %v", err)
+	}
+
+	got, _ := json.Marshal(groups)
+	want := `[` +
+		`{"key":{"provider":"aws"},"count":3,"values":{"avg:cpu":3,"count:cost_monthly":2,"max:cpu":4,"sum:cost_monthly":120.5}},` +
+		`{"key":{"provider":"gcp"},"count":1,"values":{"avg:cpu":null,"count:cost_monthly":1,"max:cpu":null,"sum:cost_monthly":10}},` +
+		`{"key":{"provider":null},"count":1,"values":{"avg:cpu":null,"count:cost_monthly":1,"max:cpu":null,"sum:cost_monthly":1}}` +
+		`]`
+	if string(got) != want {
+		t.Errorf("Unexpected summary\n got: %s\nwant: %s", got, want)
+	}
+
+	groups, err = SummarizeOrgData(store, orgID, SummaryQuery{})
+	if err != nil {
+		t.Fatalf("SummarizeOrgData failed: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Count != 5 {
+		t.Errorf("Expected a single group of 5 rows, got %+v", groups)
+	}
+}
@@ -0,0 +1,206 @@
+// Package taxonomy holds per-organization allowlists of providers,
+// categories and resource types that uploads are checked against.
+package taxonomy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Taxonomy is the allowlist of an organization. An empty list leaves that
+// field unrestricted.
+type Taxonomy struct {
+	Providers     []string `json:"providers"`
+	Categories    []string `json:"categories"`
+	ResourceTypes []string `json:"resource_types"`
+}
+
+// normalize sorts and deduplicates every list
+func (t Taxonomy) normalize() Taxonomy {
+	return Taxonomy{
+		Providers:     sortedUnique(t.Providers),
+		Categories:    sortedUnique(t.Categories),
+		ResourceTypes: sortedUnique(t.ResourceTypes),
+	}
+}
+
+// sortedUnique returns a sorted copy of values without duplicates
+func sortedUnique(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(out, value) {
+			out = append(out, value)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Violation reports an upload field whose value is not in the allowlist
+type Violation struct {
+	Field   string
+	Value   string
+	Allowed []string
+}
+
+// Error lists the allowed values and suggests a value that only differs
+// in case, which is the most common cause of fragmentation
+func (v *Violation) Error() string {
+	msg := fmt.Sprintf("%s '%s' is not allowed for this organization; allowed values: %s",
+		v.Field, v.Value, strings.Join(v.Allowed, ", "))
+	for _, allowed := range v.Allowed {
+		if strings.EqualFold(allowed, v.Value) {
+			return msg + fmt.Sprintf(" (did you mean '%s'?)", allowed)
+		}
+	}
+	return msg
+}
+
+// check returns a violation if value is not in a non-empty allowlist
+func check(field, value string, allowed []string) error {
+	if len(allowed) == 0 || slices.Contains(allowed, value) {
+		return nil
+	}
+	return &Violation{Field: field, Value: value, Allowed: allowed}
+}
+
+// Store keeps the allowlists of all organizations and persists them to a
+// JSON file on every change
+type Store struct {
+	mu       sync.RWMutex
+	orgs     map[uuid.UUID]Taxonomy
+	filePath string
+}
+
+// NewStore creates a taxonomy store backed by the given file. Existing
+// allowlists are loaded from the file if it exists. An empty path keeps
+// the allowlists in memory only.
+func NewStore(filePath string) (*Store, error) {
+	s := &Store{
+		orgs:     make(map[uuid.UUID]Taxonomy),
+		filePath: filePath,
+	}
+
+	if filePath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read taxonomy file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(data, &s.orgs); err != nil {
+		return nil, fmt.Errorf("failed to parse taxonomy file %s: %w", filePath, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the allowlist of an organization
+func (s *Store) Get(orgID uuid.UUID) (Taxonomy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, exists := s.orgs[orgID]
+	return t, exists
+}
+
+// Set replaces the allowlist of an organization
+func (s *Store) Set(orgID uuid.UUID, t Taxonomy) (Taxonomy, error) {
+	t = t.normalize()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	s.orgs[orgID] = t
+	if err := s.save(); err != nil {
+		if existed {
+			s.orgs[orgID] = previous
+		} else {
+			delete(s.orgs, orgID)
+		}
+		return Taxonomy{}, err
+	}
+	return t, nil
+}
+
+// Delete removes the allowlist of an organization, leaving its uploads
+// unrestricted. It reports whether an allowlist existed.
+func (s *Store) Delete(orgID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.orgs[orgID]
+	if !existed {
+		return false, nil
+	}
+
+	delete(s.orgs, orgID)
+	if err := s.save(); err != nil {
+		s.orgs[orgID] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// Check verifies an upload's provider, category and resource type against
+// the organization's allowlist. Organizations without one are unrestricted.
+func (s *Store) Check(orgID uuid.UUID, provider, category, resourceType string) error {
+	t, exists := s.Get(orgID)
+	if !exists {
+		return nil
+	}
+
+	if err := check("provider", provider, t.Providers); err != nil {
+		return err
+	}
+	if err := check("category", category, t.Categories); err != nil {
+		return err
+	}
+	return check("resource_type", resourceType, t.ResourceTypes)
+}
+
+// save writes all allowlists to disk. The file is replaced atomically so a
+// crash never leaves a partial file. The caller must hold the write lock.
+func (s *Store) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.orgs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal taxonomy: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create taxonomy directory: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write taxonomy file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to replace taxonomy file: %w", err)
+	}
+
+	return nil
+}
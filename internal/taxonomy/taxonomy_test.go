@@ -0,0 +1,77 @@
+package taxonomy
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckUnrestrictedOrg(t *testing.T) {
+	store, _ := NewStore("")
+
+	if err := store.Check(uuid.New(), "anything", "goes", "here"); err != nil {
+		t.Errorf("Org without allowlist should be unrestricted, got: %v", err)
+	}
+}
+
+func TestCheckViolation(t *testing.T) {
+	store, _ := NewStore("")
+	orgID := uuid.New()
+
+	if _, err := store.Set(orgID, Taxonomy{Providers: []string{"gcp", "aws", "aws"}, Categories: []string{"compute"}}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if err := store.Check(orgID, "aws", "compute", "ec2_instance"); err != nil {
+		t.Errorf("Allowed values rejected: %v", err)
+	}
+
+	err := store.Check(orgID, "amazon", "compute", "ec2_instance")
+	var violation *Violation
+	if !errors.As(err, &violation) {
+		t.Fatalf("Expected a Violation, got: %v", err)
+	}
+	if violation.Field != "provider" || strings.Join(violation.Allowed, ",") != "aws,gcp" {
+		t.Errorf("Unexpected violation: %+v", violation)
+	}
+	if strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("No suggestion expected for an unrelated value: %v", err)
+	}
+
+	err = store.Check(orgID, "aws", "Compute", "ec2_instance")
+	if err == nil || !strings.Contains(err.Error(), "did you mean 'compute'?") {
+		t.Errorf("Expected a case suggestion, got: %v", err)
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taxonomy.json")
+	orgID := uuid.New()
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if _, err := store.Set(orgID, Taxonomy{ResourceTypes: []string{"ec2_instance"}}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if err := reloaded.Check(orgID, "aws", "compute", "s3_bucket"); err == nil {
+		t.Error("Persisted allowlist was not enforced after reload")
+	}
+
+	if existed, err := reloaded.Delete(orgID); err != nil || !existed {
+		t.Fatalf("Delete failed: existed=%v err=%v", existed, err)
+	}
+	reloaded, _ = NewStore(path)
+	if _, exists := reloaded.Get(orgID); exists {
+		t.Error("Deleted allowlist is still persisted")
+	}
+}
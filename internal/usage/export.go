@@ -0,0 +1,40 @@
+package usage
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+)
+
+// WriteCSV writes a chargeback export with one row per organization and day
+// in the given CSV dialect
+func WriteCSV(w io.Writer, reports []OrgUsage, format csvfmt.Format) error {
+	writer := format.NewWriter(w)
+
+	header := []string{"month", "date", "org_id", "requests", "bytes_ingested", "bytes_stored", "state_operations"}
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, report := range reports {
+		for _, day := range report.Days {
+			row := []string{
+				report.Month,
+				day.Date,
+				report.OrgID.String(),
+				strconv.FormatInt(day.Requests, 10),
+				strconv.FormatInt(day.BytesIngested, 10),
+				strconv.FormatInt(day.BytesStored, 10),
+				strconv.FormatInt(day.StateOperations, 10),
+			}
+			if err := writer.Write(row); err != nil {
+				return fmt.Errorf("failed to write CSV row: %w", err)
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,240 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// dayFormat is the key format used for daily usage buckets
+const dayFormat = "2006-01-02"
+
+// monthFormat is the format accepted for monthly billing reports
+const monthFormat = "2006-01"
+
+// Counters holds the metered usage for a single organization and day
+type Counters struct {
+	Requests        int64 `json:"requests"`
+	BytesIngested   int64 `json:"bytes_ingested"`
+	BytesStored     int64 `json:"bytes_stored"`
+	StateOperations int64 `json:"state_operations"`
+}
+
+// add accumulates other into c
+func (c *Counters) add(other Counters) {
+	c.Requests += other.Requests
+	c.BytesIngested += other.BytesIngested
+	c.BytesStored += other.BytesStored
+	c.StateOperations += other.StateOperations
+}
+
+// DailyUsage represents the usage of an organization on a single day
+type DailyUsage struct {
+	Date string `json:"date"`
+	Counters
+}
+
+// OrgUsage represents the usage of an organization over a billing month
+type OrgUsage struct {
+	OrgID  uuid.UUID    `json:"org_id"`
+	Month  string       `json:"month"`
+	Totals Counters     `json:"totals"`
+	Days   []DailyUsage `json:"days"`
+}
+
+// Meter accumulates per-organization usage counters in daily buckets
+// and periodically persists them to a JSON file
+type Meter struct {
+	mu       sync.Mutex
+	days     map[string]map[uuid.UUID]*Counters // day -> orgID -> counters
+	filePath string
+	dirty    bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewMeter creates a usage meter backed by the given file.
+// Existing counters are loaded from the file if it exists.
+func NewMeter(filePath string, flushInterval time.Duration) (*Meter, error) {
+	m := &Meter{
+		days:     make(map[string]map[uuid.UUID]*Counters),
+		filePath: filePath,
+		stopChan: make(chan struct{}),
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	if flushInterval > 0 {
+		m.wg.Add(1)
+		go m.flushRoutine(flushInterval)
+	}
+
+	return m, nil
+}
+
+// load reads persisted counters from disk
+func (m *Meter) load() error {
+	data, err := os.ReadFile(m.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read usage file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &m.days); err != nil {
+		return fmt.Errorf("failed to parse usage file %s: %w", m.filePath, err)
+	}
+
+	return nil
+}
+
+// flushRoutine periodically persists counters until the meter is closed
+func (m *Meter) flushRoutine(interval time.Duration) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				slog.Error("Failed to persist usage counters", "error", err)
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// record applies fn to the counters of orgID for the current UTC day
+func (m *Meter) record(orgID uuid.UUID, fn func(c *Counters)) {
+	day := time.Now().UTC().Format(dayFormat)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs, exists := m.days[day]
+	if !exists {
+		orgs = make(map[uuid.UUID]*Counters)
+		m.days[day] = orgs
+	}
+
+	counters, exists := orgs[orgID]
+	if !exists {
+		counters = &Counters{}
+		orgs[orgID] = counters
+	}
+
+	fn(counters)
+	m.dirty = true
+}
+
+// AddRequest records a single API request for an organization
+func (m *Meter) AddRequest(orgID uuid.UUID) {
+	m.record(orgID, func(c *Counters) { c.Requests++ })
+}
+
+// AddBytesIngested records request payload bytes received from an organization
+func (m *Meter) AddBytesIngested(orgID uuid.UUID, n int64) {
+	m.record(orgID, func(c *Counters) { c.BytesIngested += n })
+}
+
+// AddBytesStored records bytes written to storage on behalf of an organization
+func (m *Meter) AddBytesStored(orgID uuid.UUID, n int64) {
+	m.record(orgID, func(c *Counters) { c.BytesStored += n })
+}
+
+// AddStateOperation records a Terraform state operation for an organization
+func (m *Meter) AddStateOperation(orgID uuid.UUID) {
+	m.record(orgID, func(c *Counters) { c.StateOperations++ })
+}
+
+// Flush writes the counters to disk if they changed since the last flush.
+// The file is replaced atomically so a crash never leaves a partial file.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m.days, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage counters: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+
+	tmpPath := m.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, m.filePath); err != nil {
+		return fmt.Errorf("failed to replace usage file: %w", err)
+	}
+
+	m.dirty = false
+	return nil
+}
+
+// Close stops the background flush routine and persists pending counters
+func (m *Meter) Close() error {
+	close(m.stopChan)
+	m.wg.Wait()
+	return m.Flush()
+}
+
+// MonthlyReport returns per-organization usage for the given month (YYYY-MM),
+// sorted by organization ID with days in chronological order
+func (m *Meter) MonthlyReport(month string) ([]OrgUsage, error) {
+	start, err := time.Parse(monthFormat, month)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month %q: expected YYYY-MM", month)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byOrg := make(map[uuid.UUID]*OrgUsage)
+	for day := start; day.Month() == start.Month(); day = day.AddDate(0, 0, 1) {
+		dayKey := day.Format(dayFormat)
+		for orgID, counters := range m.days[dayKey] {
+			report, exists := byOrg[orgID]
+			if !exists {
+				report = &OrgUsage{OrgID: orgID, Month: month, Days: []DailyUsage{}}
+				byOrg[orgID] = report
+			}
+			report.Totals.add(*counters)
+			report.Days = append(report.Days, DailyUsage{Date: dayKey, Counters: *counters})
+		}
+	}
+
+	reports := make([]OrgUsage, 0, len(byOrg))
+	for _, report := range byOrg {
+		reports = append(reports, *report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].OrgID.String() < reports[j].OrgID.String()
+	})
+
+	return reports, nil
+}
@@ -0,0 +1,99 @@
+package usage
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/csvfmt"
+	"github.com/google/uuid"
+)
+
+func TestMeterMonthlyReport(t *testing.T) {
+	meter, err := NewMeter(filepath.Join(t.TempDir(), "usage.json"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create meter: %v", err)
+	}
+	defer meter.Close()
+
+	orgA := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	orgB := uuid.MustParse("22222222-3333-4444-5555-666666666666")
+
+	meter.AddRequest(orgA)
+	meter.AddRequest(orgA)
+	meter.AddBytesIngested(orgA, 100)
+	meter.AddBytesStored(orgA, 80)
+	meter.AddStateOperation(orgB)
+
+	month := time.Now().UTC().Format(monthFormat)
+	reports, err := meter.MonthlyReport(month)
+	if err != nil {
+		t.Fatalf("MonthlyReport failed: %v", err)
+	}
+
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 org reports, got %d", len(reports))
+	}
+
+	if reports[0].OrgID != orgA {
+		t.Errorf("Expected reports sorted by org ID, got %s first", reports[0].OrgID)
+	}
+	want := Counters{Requests: 2, BytesIngested: 100, BytesStored: 80}
+	if reports[0].Totals != want {
+		t.Errorf("Unexpected totals for org A: got %+v, want %+v", reports[0].Totals, want)
+	}
+	if reports[1].Totals.StateOperations != 1 {
+		t.Errorf("Expected 1 state operation for org B, got %d", reports[1].Totals.StateOperations)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, reports, csvfmt.Default); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Errorf("Expected header plus 2 rows in CSV export, got %d lines", len(lines))
+	}
+}
+
+func TestMeterPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	orgID := uuid.New()
+
+	meter, err := NewMeter(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create meter: %v", err)
+	}
+	meter.AddRequest(orgID)
+	if err := meter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reloaded, err := NewMeter(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to reload meter: %v", err)
+	}
+	defer reloaded.Close()
+
+	reports, err := reloaded.MonthlyReport(time.Now().UTC().Format(monthFormat))
+	if err != nil {
+		t.Fatalf("MonthlyReport failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Totals.Requests != 1 {
+		t.Errorf("Expected persisted request count of 1, got %+v", reports)
+	}
+}
+
+func TestMeterInvalidMonth(t *testing.T) {
+	meter, err := NewMeter(filepath.Join(t.TempDir(), "usage.json"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create meter: %v", err)
+	}
+	defer meter.Close()
+
+	if _, err := meter.MonthlyReport("2025/05"); err == nil {
+		t.Error("Expected error for malformed month")
+	}
+}
@@ -0,0 +1,54 @@
+package usage
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// countingReader counts the bytes read from the wrapped request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Middleware meters request counts, ingested body bytes and state operations
+// per organization. It must run after the authentication middleware so the
+// org ID is available.
+func Middleware(meter *Meter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID, ok := auth.GetOrgIDFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body := &countingReader{ReadCloser: r.Body}
+			r.Body = body
+
+			next.ServeHTTP(w, r)
+
+			meter.AddRequest(orgID)
+			if body.n > 0 {
+				meter.AddBytesIngested(orgID, body.n)
+			}
+
+			// The route pattern is only resolved once the router has run
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				if strings.Contains(rctx.RoutePattern(), "/state/") {
+					meter.AddStateOperation(orgID)
+				}
+			}
+		})
+	}
+}
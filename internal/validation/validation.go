@@ -2,20 +2,29 @@ package validation
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 )
 
 var (
-	// stateNameRegex allows only alphanumeric, hyphens, underscores, and dots
-	stateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	// stateNameRegex allows only alphanumeric, hyphens, underscores, dots,
+	// and colons separating workspace prefixes
+	stateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.:-]+$`)
 
 	// attributeKeyRegex for validating attribute keys
 	attributeKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
 )
 
+// WorkspaceSeparator separates a workspace prefix from the workspace in a
+// state name, as in "env:prod"
+const WorkspaceSeparator = ":"
+
 // ValidateStateName validates a Terraform state name to prevent path traversal
 func ValidateStateName(name string) error {
 	if name == "" {
@@ -29,7 +38,12 @@ func ValidateStateName(name string) error {
 
 	// Validate against allowed characters
 	if !stateNameRegex.MatchString(name) {
-		return fmt.Errorf("invalid state name: only alphanumeric characters, hyphens, underscores, and dots allowed")
+		return fmt.Errorf("invalid state name: only alphanumeric characters, hyphens, underscores, dots, and colons allowed")
+	}
+
+	// Every workspace prefix and the workspace itself must be named
+	if slices.Contains(strings.Split(name, WorkspaceSeparator), "") {
+		return fmt.Errorf("invalid state name: empty workspace prefix or workspace")
 	}
 
 	// Limit length to prevent abuse
@@ -40,6 +54,12 @@ func ValidateStateName(name string) error {
 	return nil
 }
 
+// ValidateStatePrefix validates a workspace prefix, which follows the rules
+// of state names. A trailing separator is allowed and ignored.
+func ValidateStatePrefix(prefix string) error {
+	return ValidateStateName(strings.TrimSuffix(prefix, WorkspaceSeparator))
+}
+
 // ValidateAttributeKey validates an attribute key in upload data
 func ValidateAttributeKey(key string) error {
 	if key == "" {
@@ -107,6 +127,25 @@ func ValidateProvider(provider string) error {
 	return nil
 }
 
+// ValidateProviderVersion validates the optional provider version header
+func ValidateProviderVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+
+	if len(version) > 64 {
+		return fmt.Errorf("provider version too long: maximum 64 characters")
+	}
+
+	// Allow semantic versions with pre-release and build metadata
+	matched, _ := regexp.MatchString(`^[a-zA-Z0-9_.+-]+$`, version)
+	if !matched {
+		return fmt.Errorf("invalid provider version: only alphanumeric characters, dots, plus signs, hyphens, and underscores allowed")
+	}
+
+	return nil
+}
+
 // ValidateCategory validates a category field
 func ValidateCategory(category string) error {
 	if category == "" {
@@ -218,3 +257,81 @@ func ValidateJSONString(jsonData []byte, maxSize int) error {
 
 	return nil
 }
+
+// ValidateJSONStream reads r to the end and checks it holds a single
+// well-formed JSON value. Only the current token is buffered, so memory stays
+// bounded regardless of the document size. Read errors are returned as is.
+func ValidateJSONStream(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if depth == 0 {
+				return fmt.Errorf("invalid JSON format: empty document")
+			}
+			return fmt.Errorf("invalid JSON format: unexpected end of document")
+		}
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("invalid JSON format: %w", err)
+			}
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+
+	// Only whitespace may follow the value
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("invalid JSON format: data after top-level value")
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("invalid JSON format: %w", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// NormalizeTimestamp parses a client-provided RFC3339 timestamp (any offset),
+// converts it to UTC and checks it lies within the accepted window around now:
+// no more than maxSkew in the future and no older than maxAge
+func NormalizeTimestamp(value string, now time.Time, maxSkew, maxAge time.Duration) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("timestamp is empty")
+	}
+
+	if len(value) > 64 {
+		return time.Time{}, fmt.Errorf("timestamp too long: maximum 64 characters")
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: must be RFC3339 (e.g. 2025-05-01T12:00:00Z)", value)
+	}
+	parsed = parsed.UTC()
+
+	if parsed.After(now.Add(maxSkew)) {
+		return time.Time{}, fmt.Errorf("timestamp %s is more than %v in the future", parsed.Format(time.RFC3339), maxSkew)
+	}
+
+	if maxAge > 0 && parsed.Before(now.Add(-maxAge)) {
+		return time.Time{}, fmt.Errorf("timestamp %s is older than the maximum age of %v", parsed.Format(time.RFC3339), maxAge)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,514 @@
+// Package servertest provides an in-process instance of the backend service
+// for integration tests, so consumers such as the Terraform provider can test
+// against the real router without docker-compose.
+package servertest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/auth"
+	"github.com/eterrain/tf-backend-service/internal/changes"
+	"github.com/eterrain/tf-backend-service/internal/clients"
+	"github.com/eterrain/tf-backend-service/internal/download"
+	"github.com/eterrain/tf-backend-service/internal/features"
+	"github.com/eterrain/tf-backend-service/internal/ingesthook"
+	"github.com/eterrain/tf-backend-service/internal/metrics"
+	custommw "github.com/eterrain/tf-backend-service/internal/middleware"
+	"github.com/eterrain/tf-backend-service/internal/normalize"
+	"github.com/eterrain/tf-backend-service/internal/policy"
+	"github.com/eterrain/tf-backend-service/internal/public"
+	"github.com/eterrain/tf-backend-service/internal/quarantine"
+	"github.com/eterrain/tf-backend-service/internal/querycache"
+	"github.com/eterrain/tf-backend-service/internal/quota"
+	"github.com/eterrain/tf-backend-service/internal/ratelimit"
+	"github.com/eterrain/tf-backend-service/internal/rollup"
+	"github.com/eterrain/tf-backend-service/internal/server"
+	"github.com/eterrain/tf-backend-service/internal/statequeue"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/eterrain/tf-backend-service/internal/taxonomy"
+	"github.com/google/uuid"
+)
+
+// Version is reported by the health endpoint of test servers
+const Version = "servertest"
+
+// Options configures a test server
+type Options struct {
+	// RateLimitPerMinute overrides the per-org rate limit (default 6000)
+	RateLimitPerMinute float64
+
+	// AdminAPIKey enables the /admin/v1 routes
+	AdminAPIKey string
+
+	// DisableStateAPI disables the Terraform state routes
+	DisableStateAPI bool
+
+	// DisableDataAPI disables the data upload routes
+	DisableDataAPI bool
+
+	// EnableSelfTest enables the /api/v1/selftest endpoint
+	EnableSelfTest bool
+
+	// EnableMetrics enables Prometheus instrumentation and GET /metrics
+	EnableMetrics bool
+
+	// MaxStateSize overrides the state upload limit (default 10MB)
+	MaxStateSize int64
+
+	// Policy decides whether authenticated API requests are allowed
+	Policy Policy
+
+	// EnableSessionTokens enables POST /api/v1/token and bearer session tokens
+	EnableSessionTokens bool
+
+	// EnableDownloads enables signed download URLs at /api/v1/downloads
+	EnableDownloads bool
+
+	// EnableReplayProtection requires timestamp and nonce headers on
+	// state-changing requests
+	EnableReplayProtection bool
+
+	// EnableChangeLog records changes in a temporary file and enables
+	// GET /api/v1/changes
+	EnableChangeLog bool
+
+	// RollupSumFields enables daily rollups in a temporary directory,
+	// summing these fields, and GET /api/v1/data/rollups. Rollups are only
+	// built when the test calls Server.Rollups.Build.
+	RollupSumFields []string
+
+	// EnableQueryCache caches data query responses (1MB, no TTL)
+	EnableQueryCache bool
+
+	// EnableQuarantine keeps rejected uploads in a temporary directory and
+	// enables the quarantine admin routes (with AdminAPIKey)
+	EnableQuarantine bool
+
+	// UploadHooks transform accepted uploads before they are stored, in
+	// order
+	UploadHooks []UploadHook
+
+	// Features lists the feature flags to enable, or to disable with a "-"
+	// prefix, as FEATURES does; unlisted flags keep their default
+	Features []string
+
+	// MaxDailyIngestBytes and MaxDailyIngestRows enable daily ingest caps
+	// per org, with counters in a temporary file (0 = no cap)
+	MaxDailyIngestBytes int64
+	MaxDailyIngestRows  int64
+}
+
+// Policy decides whether an authenticated API request is allowed. Denied
+// requests get 403 with the reason, and requests the policy fails to
+// evaluate get 503.
+type Policy func(ctx context.Context, req PolicyRequest) (allow bool, reason string, err error)
+
+// PolicyRequest describes a request to a Policy
+type PolicyRequest struct {
+	OrgID     string   `json:"org_id"`
+	KeyID     string   `json:"key_id,omitempty"`
+	Scopes    []string `json:"scopes"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Resource  string   `json:"resource"` // state, lock, data, keys, health
+	Action    string   `json:"action"`   // read, write, delete
+	StateName string   `json:"state_name,omitempty"`
+	RemoteIP  string   `json:"remote_ip"`
+	RequestID string   `json:"request_id,omitempty"`
+
+	// Payload metadata; the body itself is never passed to the policy
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type,omitempty"`
+}
+
+// policyEvaluator adapts a Policy to policy.Evaluator
+type policyEvaluator Policy
+
+// Evaluate implements policy.Evaluator
+func (p policyEvaluator) Evaluate(ctx context.Context, input policy.Input) (policy.Decision, error) {
+	allow, reason, err := p(ctx, PolicyRequest(input))
+	return policy.Decision{Allow: allow, Reason: reason}, err
+}
+
+// UploadHook transforms accepted uploads before they are stored, as a hook
+// compiled into a custom build does
+type UploadHook struct {
+	Name string
+
+	// Transform may add, change and remove attributes of upload.Rows, but
+	// must keep their number and order. It refuses an upload by returning
+	// an error created with RejectUpload.
+	Transform func(ctx context.Context, upload *Upload) error
+
+	// Timeout limits each run (0 = the server default)
+	Timeout time.Duration
+
+	// SkipOnFailure stores the rows as they were before the hook when
+	// Transform fails, instead of failing the upload
+	SkipOnFailure bool
+}
+
+// Upload is an accepted upload handed to an UploadHook. Rows are the flat
+// rows that will be stored, one per instance.
+type Upload struct {
+	OrgID        uuid.UUID                `json:"org_id"`
+	UploadID     string                   `json:"upload_id"`
+	Provider     string                   `json:"provider"`
+	Category     string                   `json:"category"`
+	ResourceType string                   `json:"resource_type"`
+	Name         string                   `json:"name,omitempty"`
+	Rows         []map[string]interface{} `json:"rows"`
+}
+
+// RejectUpload creates the error by which an UploadHook refuses an upload.
+// The upload fails with 422 and the reason, whether or not the hook has
+// SkipOnFailure.
+func RejectUpload(reason string) error {
+	return ingesthook.Reject(reason)
+}
+
+// uploadHook adapts an UploadHook to ingesthook.Hook
+type uploadHook struct {
+	hook UploadHook
+}
+
+// Name implements ingesthook.Hook
+func (h uploadHook) Name() string {
+	return h.hook.Name
+}
+
+// Transform implements ingesthook.Hook
+func (h uploadHook) Transform(ctx context.Context, upload *ingesthook.Upload) error {
+	return h.hook.Transform(ctx, (*Upload)(upload))
+}
+
+// Server is a running in-process backend service listening on a random port
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:54321
+	URL string
+
+	// OrgID and APIKey are credentials provisioned for the default test org
+	OrgID  uuid.UUID
+	APIKey string
+
+	// Credentials holds all provisioned test credentials
+	Credentials *auth.InMemoryStore
+
+	// StateStorage and DataStorage expose the in-memory backends for assertions
+	StateStorage *storage.MemoryStorage
+	DataStorage  *storage.MemoryDataStorage
+
+	// Taxonomy holds the per-org upload allowlists (nil without the data API)
+	Taxonomy *taxonomy.Store
+
+	// Normalizer holds the per-org normalization rules (nil without the data API)
+	Normalizer *normalize.Store
+
+	// Rollups holds the daily rollups (nil without RollupSumFields)
+	Rollups *rollup.Builder
+
+	// QueryCache holds cached data query responses (nil without
+	// EnableQueryCache)
+	QueryCache *querycache.Cache
+
+	// RateLimitOverrides holds the per-org rate limit overrides
+	RateLimitOverrides *ratelimit.Store
+
+	// Quarantine holds rejected uploads (nil without EnableQuarantine)
+	Quarantine *quarantine.Store
+
+	// IngestCaps holds the daily ingest counters (nil without a daily
+	// ingest cap)
+	IngestCaps *quota.IngestCaps
+
+	// UploadHooks runs the upload hooks (nil without Options.UploadHooks)
+	UploadHooks *ingesthook.Runner
+
+	// Clients records the client versions of uploads and state writes
+	Clients *clients.Tracker
+
+	// StateWrites serializes concurrent writes of the same state
+	StateWrites *statequeue.Queue
+
+	httpServer       *httptest.Server
+	replayProtection bool
+	scopedKeys       *scopedKeys
+}
+
+// scopedKeys authenticates the keys provisioned by AddKey with their scopes
+type scopedKeys struct {
+	mu   sync.RWMutex
+	keys map[uuid.UUID]map[string]auth.StoredKey // org ID -> plaintext key -> key
+}
+
+// ValidateCredentials implements auth.CredentialStore
+func (k *scopedKeys) ValidateCredentials(orgID uuid.UUID, apiKey string) (bool, error) {
+	_, valid, err := k.AuthenticateKey(orgID, apiKey)
+	return valid, err
+}
+
+// AuthenticateKey implements auth.KeyAuthenticator
+func (k *scopedKeys) AuthenticateKey(orgID uuid.UUID, apiKey string) (auth.StoredKey, bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[orgID][apiKey]
+	return key, ok, nil
+}
+
+// New starts a test server with in-memory storage and credentials.
+// The server is shut down automatically when the test completes.
+func New(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	if opts.RateLimitPerMinute <= 0 {
+		opts.RateLimitPerMinute = 6000
+	}
+
+	credentials := auth.NewInMemoryStore()
+	s := &Server{
+		Credentials:      credentials,
+		replayProtection: opts.EnableReplayProtection,
+		scopedKeys:       &scopedKeys{keys: make(map[uuid.UUID]map[string]auth.StoredKey)},
+	}
+
+	routerOpts := server.Options{
+		Version:     Version,
+		Credentials: auth.NewChainStore(s.scopedKeys, credentials),
+		AdminAPIKey: opts.AdminAPIKey,
+
+		MaxStateSize: opts.MaxStateSize,
+	}
+	if opts.Policy != nil {
+		routerOpts.Policy = policyEvaluator(opts.Policy)
+	}
+
+	if !opts.DisableStateAPI {
+		s.StateStorage = storage.NewMemoryStorage()
+		routerOpts.StateStorage = s.StateStorage
+	}
+	if !opts.DisableDataAPI {
+		s.DataStorage = storage.NewMemoryDataStorage()
+		routerOpts.DataStorage = s.DataStorage
+		s.Taxonomy, _ = taxonomy.NewStore("")
+		routerOpts.Taxonomy = s.Taxonomy
+		s.Normalizer, _ = normalize.NewStore("")
+		routerOpts.Normalizer = s.Normalizer
+	}
+	if opts.EnableSelfTest {
+		routerOpts.SelfTestCredentials = credentials
+	}
+	if opts.EnableMetrics {
+		routerOpts.Metrics = metrics.New("memory")
+		routerOpts.Public = public.Set{}.With(public.Metrics)
+	}
+	if opts.EnableDownloads {
+		signer, err := download.NewSigner(nil, time.Hour)
+		if err != nil {
+			t.Fatalf("servertest: failed to create download signer: %v", err)
+		}
+		routerOpts.Downloads = signer
+	}
+	if opts.EnableSessionTokens {
+		tokens, err := auth.NewTokenIssuer(nil, 15*time.Minute)
+		if err != nil {
+			t.Fatalf("servertest: failed to create token issuer: %v", err)
+		}
+		routerOpts.Tokens = tokens
+	}
+
+	rateLimiter := custommw.NewPerOrgRateLimiter(opts.RateLimitPerMinute)
+	s.RateLimitOverrides, _ = ratelimit.NewStore("")
+	rateLimiter.SetOverrides(s.RateLimitOverrides)
+	routerOpts.RateLimiter = rateLimiter
+	routerOpts.RateLimitOverrides = s.RateLimitOverrides
+	if routerOpts.Metrics != nil {
+		routerOpts.Metrics.ObserveRateLimiter(rateLimiter)
+	}
+
+	s.Clients = clients.NewTracker()
+	routerOpts.Clients = s.Clients
+	s.StateWrites = statequeue.New(statequeue.DefaultConfig())
+	routerOpts.StateWrites = s.StateWrites
+
+	var changeLog *changes.Log
+	if opts.EnableChangeLog {
+		var err error
+		changeLog, err = changes.Open(filepath.Join(t.TempDir(), "changes.jsonl"), 1000)
+		if err != nil {
+			t.Fatalf("servertest: failed to open change log: %v", err)
+		}
+		routerOpts.Changes = changeLog
+	}
+
+	if opts.EnableQueryCache {
+		s.QueryCache = querycache.New(querycache.Config{MaxBytes: 1 << 20})
+		routerOpts.QueryCache = s.QueryCache
+	}
+
+	if opts.EnableQuarantine {
+		var err error
+		s.Quarantine, err = quarantine.Open(quarantine.Config{Dir: t.TempDir(), MaxBytes: 1 << 20})
+		if err != nil {
+			t.Fatalf("servertest: failed to open quarantine: %v", err)
+		}
+		routerOpts.Quarantine = s.Quarantine
+	}
+	if opts.MaxDailyIngestBytes > 0 || opts.MaxDailyIngestRows > 0 {
+		var err error
+		caps := quota.IngestConfig{MaxBytes: opts.MaxDailyIngestBytes, MaxRows: opts.MaxDailyIngestRows}
+		s.IngestCaps, err = quota.NewIngestCaps(caps, filepath.Join(t.TempDir(), "ingest_quota.json"), 0)
+		if err != nil {
+			t.Fatalf("servertest: failed to create ingest caps: %v", err)
+		}
+		routerOpts.IngestCaps = s.IngestCaps
+	}
+	if opts.UploadHooks != nil {
+		s.UploadHooks = ingesthook.NewRunner()
+		for _, hook := range opts.UploadHooks {
+			onFailure := ingesthook.PolicyReject
+			if hook.SkipOnFailure {
+				onFailure = ingesthook.PolicySkip
+			}
+			s.UploadHooks.Add(uploadHook{hook}, hook.Timeout, onFailure)
+		}
+		routerOpts.UploadHooks = s.UploadHooks
+	}
+	flags, err := features.Parse(strings.Join(opts.Features, ","))
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	routerOpts.Features = flags
+
+	if opts.RollupSumFields != nil && s.DataStorage != nil {
+		var err error
+		// A negative settle time rolls up rows uploaded in the current second
+		s.Rollups, err = rollup.NewBuilder(s.DataStorage, rollup.Config{
+			Dir:       t.TempDir(),
+			Interval:  time.Hour,
+			SumFields: opts.RollupSumFields,
+			Settle:    -time.Second,
+		})
+		if err != nil {
+			t.Fatalf("servertest: failed to create rollup builder: %v", err)
+		}
+		if s.QueryCache != nil {
+			s.Rollups.SetOnBuild(s.QueryCache.Invalidate)
+		}
+		routerOpts.Rollups = s.Rollups
+	}
+
+	var replayGuard *auth.ReplayGuard
+	if opts.EnableReplayProtection {
+		replayGuard = auth.NewReplayGuard(5 * time.Minute)
+		routerOpts.ReplayGuard = replayGuard
+	}
+
+	s.httpServer = httptest.NewServer(server.NewRouter(routerOpts))
+	s.URL = s.httpServer.URL
+
+	t.Cleanup(func() {
+		s.httpServer.Close()
+		rateLimiter.Stop()
+		if replayGuard != nil {
+			replayGuard.Stop()
+		}
+		if changeLog != nil {
+			changeLog.Close()
+		}
+	})
+
+	s.OrgID, s.APIKey = s.AddOrg(t)
+
+	return s
+}
+
+// AddOrg provisions an additional organization with a random API key
+func (s *Server) AddOrg(t testing.TB) (uuid.UUID, string) {
+	t.Helper()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("servertest: failed to generate API key: %v", err)
+	}
+
+	orgID := uuid.New()
+	apiKey := base64.URLEncoding.EncodeToString(b)
+	s.Credentials.AddCredentials(orgID, apiKey)
+
+	return orgID, apiKey
+}
+
+// AddKey provisions an additional API key for the default test org with the
+// given scopes, e.g. "state:admin", which default keys lack. Send it in
+// place of APIKey in the X-API-Key header.
+func (s *Server) AddKey(t testing.TB, scopes ...string) string {
+	t.Helper()
+
+	if err := auth.ValidateScopes(scopes); err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("servertest: failed to generate API key: %v", err)
+	}
+	apiKey := base64.URLEncoding.EncodeToString(b)
+
+	s.scopedKeys.mu.Lock()
+	defer s.scopedKeys.mu.Unlock()
+	if s.scopedKeys.keys[s.OrgID] == nil {
+		s.scopedKeys.keys[s.OrgID] = make(map[string]auth.StoredKey)
+	}
+	s.scopedKeys.keys[s.OrgID][apiKey] = auth.StoredKey{Key: apiKey, Scopes: scopes}
+	return apiKey
+}
+
+// Client returns an HTTP client configured for the server
+func (s *Server) Client() *http.Client {
+	return s.httpServer.Client()
+}
+
+// NewRequest builds a request to path authenticated as the default test org.
+// With replay protection enabled, state-changing requests get a fresh
+// timestamp and nonce.
+func (s *Server) NewRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Org-ID", s.OrgID.String())
+	req.Header.Set("X-API-Key", s.APIKey)
+	if s.replayProtection && method != http.MethodGet && method != http.MethodHead {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		req.Header.Set(auth.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+		req.Header.Set(auth.NonceHeader, base64.RawURLEncoding.EncodeToString(nonce))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// Do sends a request authenticated as the default test org
+func (s *Server) Do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := s.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.Client().Do(req)
+}
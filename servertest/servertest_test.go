@@ -0,0 +1,2514 @@
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eterrain/tf-backend-service/internal/features"
+	"github.com/eterrain/tf-backend-service/internal/handlers"
+	"github.com/eterrain/tf-backend-service/internal/quota"
+	"github.com/eterrain/tf-backend-service/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestServerUploadAndQuery(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if body.Count != 1 {
+		t.Errorf("Expected 1 record, got %d", body.Count)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data?fields=resource_name,provider", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var projected struct {
+		Data []struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&projected); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if len(projected.Data) != 1 || len(projected.Data[0].Data) != 2 || projected.Data[0].Data["resource_name"] != "web-1" {
+		t.Errorf("Expected only the selected fields, got %+v", projected.Data)
+	}
+}
+
+func TestServerDataSummary(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[` +
+		`{"attributes":{"name":"web-1","cost_monthly":100}},{"attributes":{"name":"web-2","cost_monthly":20.5}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data/summary?aggregate=median:cost_monthly", nil)
+	if err != nil {
+		t.Fatalf("Summary request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported function, got %d", resp.StatusCode)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data/summary?aggregate=sum:cost_monthly&group_by=provider", nil)
+	if err != nil {
+		t.Fatalf("Summary request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Count  int `json:"count"`
+		Groups []struct {
+			Key    map[string]interface{} `json:"key"`
+			Count  int                    `json:"count"`
+			Values map[string]float64     `json:"values"`
+		} `json:"groups"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode summary response: %v", err)
+	}
+	if body.Count != 2 || len(body.Groups) != 1 || body.Groups[0].Key["provider"] != "aws" || body.Groups[0].Values["sum:cost_monthly"] != 120.5 {
+		t.Errorf("Unexpected summary %+v", body)
+	}
+}
+
+func TestServerDataRollups(t *testing.T) {
+	srv := New(t, Options{RollupSumFields: []string{"cost_monthly"}})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[` +
+		`{"attributes":{"name":"web-1","cost_monthly":100}},{"attributes":{"name":"web-2","cost_monthly":20.5}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	summary := func(query string) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := srv.Do(http.MethodGet, "/api/v1/data/summary?"+query, nil)
+		if err != nil {
+			t.Fatalf("Summary request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode summary response: %v", err)
+			}
+		}
+		return resp.StatusCode, body
+	}
+
+	// Not built yet: summaries fall back to the raw data
+	if status, body := summary("aggregate=sum:cost_monthly"); status != http.StatusOK || body["source"] != "raw" {
+		t.Errorf("Expected a raw summary before the first build, got %d %v", status, body)
+	}
+	if status, _ := summary("source=rollup"); status != http.StatusConflict {
+		t.Errorf("Expected 409 for source=rollup before the first build, got %d", status)
+	}
+
+	if _, err := srv.Rollups.Build(srv.OrgID); err != nil {
+		t.Fatalf("Rollup build failed: %v", err)
+	}
+
+	status, body := summary("aggregate=sum:cost_monthly&group_by=provider")
+	if status != http.StatusOK || body["source"] != "rollup" || body["fresh_through"] == nil || body["count"] != 2.0 {
+		t.Errorf("Expected a rollup summary of 2 rows, got %d %v", status, body)
+	}
+	if groups, _ := body["groups"].([]interface{}); len(groups) != 1 {
+		t.Errorf("Expected 1 group, got %v", body["groups"])
+	}
+	if _, body := summary("aggregate=max:cost_monthly"); body["source"] != "raw" {
+		t.Errorf("Expected max to be served from the raw data, got %v", body)
+	}
+	if _, body := summary("aggregate=sum:cost_monthly&source=raw"); body["source"] != "raw" {
+		t.Errorf("Expected source=raw to bypass the rollups, got %v", body)
+	}
+	if status, _ := summary("source=cache"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown source, got %d", status)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data/rollups?from=2000-01-01", nil)
+	if err != nil {
+		t.Fatalf("Rollups request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var rollups struct {
+		SumFields []string `json:"sum_fields"`
+		Buckets   []struct {
+			Provider string             `json:"provider"`
+			Count    int                `json:"count"`
+			Sums     map[string]float64 `json:"sums"`
+		} `json:"buckets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rollups); err != nil {
+		t.Fatalf("Failed to decode rollups response: %v", err)
+	}
+	if len(rollups.Buckets) != 1 || rollups.Buckets[0].Count != 2 || rollups.Buckets[0].Sums["cost_monthly"] != 120.5 {
+		t.Errorf("Unexpected rollups %+v", rollups)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data/rollups?from=yesterday", nil)
+	if err != nil {
+		t.Fatalf("Rollups request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid date, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerPagination(t *testing.T) {
+	srv := New(t, Options{})
+
+	for i := 0; i < 5; i++ {
+		upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-` + strconv.Itoa(i) + `"}}]}`
+		resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+		}
+	}
+
+	type dataPage struct {
+		Data []struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		NextCursor *string `json:"next_cursor"`
+		PrevCursor *string `json:"prev_cursor"`
+	}
+	getPage := func(path string) dataPage {
+		t.Helper()
+		resp, err := srv.Do(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Query request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from %s, got %d", path, resp.StatusCode)
+		}
+		var page dataPage
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		return page
+	}
+
+	// Crawl the data two rows at a time with selected fields
+	var names []string
+	path := "/api/v1/data?limit=2&fields=resource_name"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Pagination did not terminate")
+		}
+		page := getPage(path)
+		for _, row := range page.Data {
+			names = append(names, row.Data["resource_name"].(string))
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		path = "/api/v1/data?limit=2&fields=resource_name&after=" + url.QueryEscape(*page.NextCursor)
+	}
+	if strings.Join(names, ",") != "web-0,web-1,web-2,web-3,web-4" {
+		t.Errorf("Expected every row once in upload order, got %v", names)
+	}
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/data?after=not-a-cursor", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid cursor, got %d", resp.StatusCode)
+	}
+
+	for _, name := range []string{"prod", "dev", "staging"} {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/"+name, strings.NewReader(`{"version":4}`))
+		if err != nil {
+			t.Fatalf("State request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from state update, got %d", resp.StatusCode)
+		}
+	}
+
+	var states []string
+	path = "/api/v1/state?limit=2"
+	for path != "" {
+		resp, err := srv.Do(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("List request failed: %v", err)
+		}
+		var list struct {
+			States []struct {
+				Name string `json:"name"`
+			} `json:"states"`
+			NextCursor *string `json:"next_cursor"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode state list: %v", err)
+		}
+		for _, state := range list.States {
+			states = append(states, state.Name)
+		}
+		path = ""
+		if list.NextCursor != nil {
+			path = "/api/v1/state?limit=2&after=" + url.QueryEscape(*list.NextCursor)
+		}
+	}
+	if strings.Join(states, ",") != "dev,prod,staging" {
+		t.Errorf("Expected states by name, got %v", states)
+	}
+}
+
+func TestServerFullSync(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := func(name string) {
+		t.Helper()
+		body := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"` + name + `"}}]}`
+		resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+		}
+	}
+	for _, name := range []string{"web-0", "web-1", "web-2", "web-3", "web-4"} {
+		upload(name)
+	}
+
+	type chunk struct {
+		Data []struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		SyncToken string `json:"sync_token"`
+		Complete  bool   `json:"complete"`
+		Cursor    string `json:"cursor"`
+	}
+	sync := func(path string) []chunk {
+		t.Helper()
+		resp, err := srv.Do(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Full sync request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("Expected 200 NDJSON from %s, got %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		var chunks []chunk
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			var c chunk
+			if err := decoder.Decode(&c); err != nil {
+				t.Fatalf("Failed to decode chunk: %v", err)
+			}
+			chunks = append(chunks, c)
+		}
+		return chunks
+	}
+	names := func(chunks []chunk) string {
+		var all []string
+		for _, c := range chunks {
+			for _, row := range c.Data {
+				all = append(all, row.Data["resource_name"].(string))
+			}
+		}
+		return strings.Join(all, ",")
+	}
+
+	chunks := sync("/api/v1/data/full-sync?chunk_size=2")
+	if len(chunks) != 3 || names(chunks) != "web-0,web-1,web-2,web-3,web-4" {
+		t.Fatalf("Expected 3 chunks with every row, got %d: %s", len(chunks), names(chunks))
+	}
+	last := chunks[2]
+	if !last.Complete || last.Cursor == "" || last.SyncToken != "" || chunks[0].SyncToken == "" || chunks[0].Complete {
+		t.Errorf("Unexpected chunk markers: %+v", chunks)
+	}
+
+	// Resume after the first chunk; rows uploaded since the sync started
+	// are left for the paged query continuing from the final cursor
+	upload("web-5")
+	resumed := sync("/api/v1/data/full-sync?chunk_size=2&sync_token=" + url.QueryEscape(chunks[0].SyncToken))
+	if names(resumed) != "web-2,web-3,web-4" || !resumed[len(resumed)-1].Complete {
+		t.Errorf("Expected the resumed sync to end at the original cutoff, got %s", names(resumed))
+	}
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/data?after="+url.QueryEscape(last.Cursor), nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	var page struct {
+		Data []struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode page: %v", err)
+	}
+	if len(page.Data) != 1 || page.Data[0].Data["resource_name"] != "web-5" {
+		t.Errorf("Expected only the row uploaded after the sync, got %+v", page.Data)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data/full-sync?sync_token=bogus", nil)
+	if err != nil {
+		t.Fatalf("Full sync request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid sync token, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerChangeFeed(t *testing.T) {
+	srv := New(t, Options{EnableChangeLog: true})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}},{"attributes":{"name":"web-2"}}]}`
+	for _, step := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/v1/upload", upload},
+		{http.MethodPost, "/api/v1/state/prod", `{"version":4}`},
+		{http.MethodPost, "/api/v1/state/prod", `{"version":4,"serial":2}`},
+		{http.MethodDelete, "/api/v1/state/prod", ""},
+	} {
+		resp, err := srv.Do(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from %s %s, got %d", step.method, step.path, resp.StatusCode)
+		}
+	}
+
+	type feed struct {
+		Changes []struct {
+			Seq       int64                  `json:"seq"`
+			Type      string                 `json:"type"`
+			StateName string                 `json:"state_name"`
+			Version   int64                  `json:"version"`
+			Data      map[string]interface{} `json:"data"`
+		} `json:"changes"`
+		NextSince int64 `json:"next_since"`
+		LatestSeq int64 `json:"latest_seq"`
+		HasMore   bool  `json:"has_more"`
+	}
+	getFeed := func(path string) feed {
+		t.Helper()
+		resp, err := srv.Do(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Changes request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from %s, got %d", path, resp.StatusCode)
+		}
+		var f feed
+		if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+			t.Fatalf("Failed to decode changes: %v", err)
+		}
+		return f
+	}
+
+	first := getFeed("/api/v1/changes?limit=3")
+	if len(first.Changes) != 3 || !first.HasMore || first.NextSince != 3 || first.LatestSeq != 5 {
+		t.Fatalf("Unexpected first page: %+v", first)
+	}
+	if first.Changes[0].Type != "insert" || first.Changes[0].Data["resource_name"] != "web-1" {
+		t.Errorf("Expected the first insert, got %+v", first.Changes[0])
+	}
+	if first.Changes[2].Type != "state_version" || first.Changes[2].Version != 1 {
+		t.Errorf("Expected state version 1, got %+v", first.Changes[2])
+	}
+
+	rest := getFeed("/api/v1/changes?since=3")
+	if len(rest.Changes) != 2 || rest.HasMore || rest.Changes[0].Version != 2 || rest.Changes[1].Type != "delete" || rest.Changes[1].StateName != "prod" {
+		t.Errorf("Unexpected remaining changes: %+v", rest)
+	}
+
+	if caughtUp := getFeed("/api/v1/changes?since=5"); len(caughtUp.Changes) != 0 || caughtUp.NextSince != 5 {
+		t.Errorf("Expected no changes after the latest, got %+v", caughtUp)
+	}
+}
+
+func TestServerUploadAccounting(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}},{"attributes":{"name":"web-2"}}]}`
+	req, err := srv.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("X-Upload-ID", "nightly-42")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	if got := resp.Header.Get("X-Ingested-Bytes"); got != strconv.Itoa(len(upload)) {
+		t.Errorf("Expected X-Ingested-Bytes %d, got %q", len(upload), got)
+	}
+	if got := resp.Header.Get("X-Ingested-Instances"); got != "2" {
+		t.Errorf("Expected X-Ingested-Instances 2, got %q", got)
+	}
+	if resp.Header.Get("X-Processing-Duration-Ms") == "" || resp.Header.Get("X-Upload-ID") != "nightly-42" {
+		t.Errorf("Missing duration or upload ID headers: %v", resp.Header)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/uploads/nightly-42", nil)
+	if err != nil {
+		t.Fatalf("Status request failed: %v", err)
+	}
+	var status struct {
+		Status          string `json:"status"`
+		BytesReceived   int    `json:"bytes_received"`
+		InstancesTotal  int    `json:"instances_total"`
+		InstancesStored int    `json:"instances_stored"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Status != "completed" || status.BytesReceived != len(upload) || status.InstancesTotal != 2 || status.InstancesStored != 2 {
+		t.Errorf("Unexpected upload status %+v", status)
+	}
+
+	// Failed uploads are reported too, and other orgs cannot see them
+	resp, _ = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(`{"provider":""}`))
+	resp.Body.Close()
+	uploadID := resp.Header.Get("X-Upload-ID")
+	if resp.StatusCode != http.StatusBadRequest || uploadID == "" || resp.Header.Get("X-Ingested-Instances") != "0" {
+		t.Errorf("Expected 400 with accounting headers, got %d: %v", resp.StatusCode, resp.Header)
+	}
+	resp, _ = srv.Do(http.MethodGet, "/api/v1/uploads/"+uploadID, nil)
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Status != "failed" {
+		t.Errorf("Expected failed upload status, got %+v", status)
+	}
+
+	otherOrg, otherKey := srv.AddOrg(t)
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/v1/uploads/nightly-42", nil)
+	req.Header.Set("X-Org-ID", otherOrg.String())
+	req.Header.Set("X-API-Key", otherKey)
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Status request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for another org's upload, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerStateRoundTrip(t *testing.T) {
+	srv := New(t, Options{})
+
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/test", strings.NewReader(`{"version":4,"serial":1}`))
+	if err != nil {
+		t.Fatalf("State put failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from state put, got %d", resp.StatusCode)
+	}
+
+	state, err := srv.StateStorage.GetState(srv.OrgID, "test")
+	if err != nil {
+		t.Fatalf("State not persisted in storage: %v", err)
+	}
+	if !strings.Contains(string(state.Data), `"serial":1`) {
+		t.Errorf("Unexpected stored state: %s", state.Data)
+	}
+}
+
+func TestServerConcurrentStateWrites(t *testing.T) {
+	srv := New(t, Options{})
+
+	// Hold the first write open while a second one arrives
+	body, writer := io.Pipe()
+	first := make(chan int, 1)
+	go func() {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod", body)
+		if err != nil {
+			first <- 0
+			return
+		}
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	writer.Write([]byte(`{"serial":`))
+	waitFor(t, func() bool { active, _, _, _ := srv.StateWrites.Stats(); return active == 1 })
+
+	second := make(chan int, 1)
+	go func() {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod", strings.NewReader(`{"serial":2}`))
+		if err != nil {
+			second <- 0
+			return
+		}
+		resp.Body.Close()
+		second <- resp.StatusCode
+	}()
+	waitFor(t, func() bool { _, queued, _, _ := srv.StateWrites.Stats(); return queued == 1 })
+
+	writer.Write([]byte(`1}`))
+	writer.Close()
+	if status := <-first; status != http.StatusOK {
+		t.Fatalf("Expected the first write to succeed, got %d", status)
+	}
+	if status := <-second; status != http.StatusConflict {
+		t.Errorf("Expected the queued write to conflict, got %d", status)
+	}
+
+	state, err := srv.StateStorage.GetState(srv.OrgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":1}` || state.Version != 1 {
+		t.Errorf("Expected only the first write to be stored, got %+v, %v", state, err)
+	}
+}
+
+// waitFor polls condition until it holds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerWorkspacePrefixes(t *testing.T) {
+	srv := New(t, Options{})
+
+	for _, name := range []string{"network", "network:staging", "network:prod", "network-legacy", "app:prod"} {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/"+name, strings.NewReader(`{"version":4}`))
+		if err != nil {
+			t.Fatalf("State request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from state update of %s, got %d", name, resp.StatusCode)
+		}
+	}
+	if _, err := srv.StateStorage.GetState(srv.OrgID, "network:prod"); err != nil {
+		t.Fatalf("Workspace state not stored under its full name: %v", err)
+	}
+
+	for _, prefix := range []string{"network", "network:"} {
+		resp, err := srv.Do(http.MethodGet, "/api/v1/state-prefixes/"+prefix, nil)
+		if err != nil {
+			t.Fatalf("Prefix request failed: %v", err)
+		}
+		var list struct {
+			Prefix     string `json:"prefix"`
+			Workspaces []struct {
+				Workspace string `json:"workspace"`
+				Name      string `json:"name"`
+			} `json:"workspaces"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode workspace list: %v", err)
+		}
+		var workspaces []string
+		for _, workspace := range list.Workspaces {
+			workspaces = append(workspaces, workspace.Workspace+"="+workspace.Name)
+		}
+		if got := strings.Join(workspaces, ","); list.Prefix != "network" || got != "default=network,prod=network:prod,staging=network:staging" {
+			t.Errorf("Unexpected workspaces under %q: %s %s", prefix, list.Prefix, got)
+		}
+	}
+
+	for _, path := range []string{"/api/v1/state/network::prod", "/api/v1/state/:prod", "/api/v1/state/network:", "/api/v1/state-prefixes/network::"} {
+		resp, err := srv.Do(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerStateStreaming(t *testing.T) {
+	srv := New(t, Options{MaxStateSize: 32 << 20})
+
+	// Larger than the 10MB limit of other requests
+	var large bytes.Buffer
+	large.WriteString(`{"version":4,"serial":2,"resources":[`)
+	for i := 0; large.Len() < 12<<20; i++ {
+		if i > 0 {
+			large.WriteString(",")
+		}
+		large.WriteString(`{"name":"r","attributes":{"tags":"` + strings.Repeat("x", 1000) + `"}}`)
+	}
+	large.WriteString("]}")
+
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/big", bytes.NewReader(large.Bytes()))
+	if err != nil {
+		t.Fatalf("State put failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for large state, got %d", resp.StatusCode)
+	}
+
+	state, err := srv.StateStorage.GetState(srv.OrgID, "big")
+	if err != nil {
+		t.Fatalf("Large state not stored: %v", err)
+	}
+	if !bytes.Equal(state.Data, large.Bytes()) {
+		t.Errorf("Stored state differs: %d bytes stored, %d sent", len(state.Data), large.Len())
+	}
+
+	// Invalid or oversized states must not replace the stored state
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"truncated", `{"version":4,"serial":3,"resources":[`, http.StatusBadRequest},
+		{"malformed", `{"version":4,"serial":3,}`, http.StatusBadRequest},
+		{"trailing data", `{"version":4} {"serial":3}`, http.StatusBadRequest},
+		{"empty", ``, http.StatusBadRequest},
+		{"too large", `{"data":"` + strings.Repeat("x", 33<<20) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/big", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%s: state put failed: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	state, err = srv.StateStorage.GetState(srv.OrgID, "big")
+	if err != nil || state.Version != 1 {
+		t.Fatalf("Rejected uploads must keep the stored state, got %+v (err=%v)", state, err)
+	}
+
+	// Other routes keep the default limit
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", bytes.NewReader(large.Bytes()))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("Expected the 10MB limit to apply to uploads")
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	srv := New(t, Options{Policy: func(ctx context.Context, req PolicyRequest) (bool, string, error) {
+		if req.Resource == "state" && req.Action == "delete" && strings.HasPrefix(req.StateName, "prod") {
+			return false, "prod states cannot be deleted by org " + req.OrgID, nil
+		}
+		return true, "", nil
+	}})
+
+	for _, name := range []string{"prod-network", "dev-network"} {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/state/"+name, strings.NewReader(`{"version":4}`))
+		if err != nil {
+			t.Fatalf("State put failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from state put, got %d", resp.StatusCode)
+		}
+	}
+
+	resp, err := srv.Do(http.MethodDelete, "/api/v1/state/prod-network", nil)
+	if err != nil {
+		t.Fatalf("State delete failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), srv.OrgID.String()) {
+		t.Errorf("Expected 403 with reason for prod delete, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp, err = srv.Do(http.MethodDelete, "/api/v1/state/dev-network", nil)
+	if err != nil {
+		t.Fatalf("State delete failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for dev delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerSessionTokens(t *testing.T) {
+	srv := New(t, Options{EnableSessionTokens: true})
+
+	resp, err := srv.Do(http.MethodPost, "/api/v1/token", nil)
+	if err != nil {
+		t.Fatalf("Token request failed: %v", err)
+	}
+	var token struct {
+		Token     string `json:"token"`
+		TokenType string `json:"token_type"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("Expected token, got %d: %v", resp.StatusCode, err)
+	}
+	if token.TokenType != "Bearer" || token.Token == "" || token.ExpiresIn <= 0 {
+		t.Fatalf("Unexpected token response %+v", token)
+	}
+
+	send := func(bearer string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/state/session", strings.NewReader(`{"version":4}`))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("State put failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := send(token.Token); code != http.StatusOK {
+		t.Errorf("Expected 200 with session token, got %d", code)
+	}
+	if code := send(token.Token + "x"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with tampered token, got %d", code)
+	}
+	if _, err := srv.StateStorage.GetState(srv.OrgID, "session"); err != nil {
+		t.Errorf("State not stored for the token's org: %v", err)
+	}
+
+	// Session tokens cannot be exchanged for new ones
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/token", nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Token request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 exchanging a session token, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerReplayProtection(t *testing.T) {
+	srv := New(t, Options{EnableReplayProtection: true})
+
+	req, err := srv.NewRequest(http.MethodPost, "/api/v1/state/replay", strings.NewReader(`{"version":4}`))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	send := func(req *http.Request) int {
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := send(req); code != http.StatusOK {
+		t.Fatalf("Expected 200 for a fresh request, got %d", code)
+	}
+
+	// Replay the captured request verbatim
+	replay, _ := http.NewRequest(http.MethodPost, req.URL.String(), strings.NewReader(`{"version":4}`))
+	replay.Header = req.Header.Clone()
+	if code := send(replay); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a replayed request, got %d", code)
+	}
+
+	stale, _ := srv.NewRequest(http.MethodPost, "/api/v1/state/replay", strings.NewReader(`{"version":4}`))
+	stale.Header.Set("X-Request-Timestamp", "1000000000")
+	if code := send(stale); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a stale timestamp, got %d", code)
+	}
+
+	missing, _ := srv.NewRequest(http.MethodPost, "/api/v1/state/replay", strings.NewReader(`{"version":4}`))
+	missing.Header.Del("X-Request-Nonce")
+	if code := send(missing); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a nonce, got %d", code)
+	}
+
+	// Reads are not checked
+	get, _ := srv.NewRequest(http.MethodGet, "/api/v1/state/replay", nil)
+	if code := send(get); code != http.StatusOK {
+		t.Errorf("Expected 200 for a read without nonce, got %d", code)
+	}
+}
+
+func TestServerSelfTest(t *testing.T) {
+	srv := New(t, Options{EnableSelfTest: true, EnableReplayProtection: true})
+
+	run := func() *http.Response {
+		resp, err := http.Post(srv.URL+"/api/v1/selftest", "application/json", nil)
+		if err != nil {
+			t.Fatalf("Self-test request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := run()
+	var result struct {
+		Status string `json:"status"`
+		OrgID  string `json:"org_id"`
+		Steps  []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"steps"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || result.Status != "passed" {
+		t.Fatalf("Expected the self-test to pass behind the replay guard, got %d %+v (%v)", resp.StatusCode, result, err)
+	}
+
+	// Nothing the run stored is left behind
+	if uploads, _ := srv.DataStorage.GetOrgData(uuid.MustParse(result.OrgID)); len(uploads) != 0 {
+		t.Errorf("Expected no self-test data to remain, got %d row(s)", len(uploads))
+	}
+	if orgs, _ := srv.StateStorage.ListStateOrgs(); len(orgs) != 0 {
+		t.Errorf("Expected no self-test states to remain, got orgs %v", orgs)
+	}
+
+	// Anonymous runs are rate limited per client IP
+	code := http.StatusOK
+	for i := 0; i < 10 && code == http.StatusOK; i++ {
+		resp := run()
+		resp.Body.Close()
+		code = resp.StatusCode
+	}
+	if code != http.StatusTooManyRequests {
+		t.Errorf("Expected repeated self-tests to be rate limited, got %d", code)
+	}
+}
+
+func TestServerRejectsUnauthenticated(t *testing.T) {
+	srv := New(t, Options{})
+
+	resp, err := srv.Client().Get(srv.URL + "/api/v1/data")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerDisabledAPIs(t *testing.T) {
+	srv := New(t, Options{DisableStateAPI: true})
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/state/test", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for disabled state API, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerUploadDryRun(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload?dry_run=true", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string                   `json:"status"`
+		Rows   []map[string]interface{} `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Status != "dry_run" || len(body.Rows) != 1 {
+		t.Fatalf("Unexpected dry-run response: %+v", body)
+	}
+	if body.Rows[0]["resource_name"] != "web-1" {
+		t.Errorf("Expected flattened row with resource_name, got %v", body.Rows[0])
+	}
+
+	uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID)
+	if len(uploads) != 0 {
+		t.Errorf("Dry run must not persist data, found %d rows", len(uploads))
+	}
+}
+
+func TestServerDailyIngestCaps(t *testing.T) {
+	srv := New(t, Options{MaxDailyIngestRows: 2})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}},{"attributes":{"name":"web-2"}}]}`
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("Upload %d: expected %d, got %d", i, expected, resp.StatusCode)
+		}
+		if expected != http.StatusTooManyRequests {
+			continue
+		}
+
+		resetAt, err := time.Parse(time.RFC3339, resp.Header.Get(quota.ResetHeader))
+		if err != nil || !resetAt.After(time.Now()) {
+			t.Errorf("Expected a future %s header, got %q", quota.ResetHeader, resp.Header.Get(quota.ResetHeader))
+		}
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter <= 0 {
+			t.Errorf("Expected a positive Retry-After, got %q", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	// Dry runs are not counted against the cap
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload?dry_run=true", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Dry-run request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected dry run to pass the cap, got %d", resp.StatusCode)
+	}
+
+	uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID)
+	if len(uploads) != 2 {
+		t.Errorf("Expected only the first upload to be stored, found %d rows", len(uploads))
+	}
+	if usage := srv.IngestCaps.Usage(srv.OrgID); usage.Rows != 2 || usage.Bytes == 0 {
+		t.Errorf("Unexpected ingest usage %+v", usage)
+	}
+}
+
+// costCenterHook enriches rows with the cost center of their resource and
+// refuses uploads of unknown resources
+func costCenterHook(costCenters map[string]string) UploadHook {
+	return UploadHook{
+		Name: "cost-center",
+		Transform: func(ctx context.Context, upload *Upload) error {
+			for _, row := range upload.Rows {
+				costCenter, ok := costCenters[row["resource_name"].(string)]
+				if !ok {
+					return RejectUpload("unknown resource " + row["resource_name"].(string))
+				}
+				row["cost_center"] = costCenter
+			}
+			return nil
+		},
+		Timeout: time.Second,
+	}
+}
+
+func TestServerUploadHooks(t *testing.T) {
+	broken := UploadHook{
+		Name:          "broken",
+		Transform:     func(ctx context.Context, upload *Upload) error { return errors.New("lookup failed") },
+		SkipOnFailure: true,
+	}
+	srv := New(t, Options{UploadHooks: []UploadHook{broken, costCenterHook(map[string]string{"web-1": "cc-42"})}})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the upload to be stored, got %d", resp.StatusCode)
+	}
+
+	uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID)
+	if len(uploads) != 1 || uploads[0].Data["cost_center"] != "cc-42" {
+		t.Fatalf("Expected the stored row to be enriched, got %+v", uploads)
+	}
+
+	upload = `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"rogue"}}]}`
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(body), "unknown resource rogue") {
+		t.Errorf("Expected the hook to reject the upload with 422, got %d: %s", resp.StatusCode, body)
+	}
+	if uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID); len(uploads) != 1 {
+		t.Errorf("Expected nothing to be stored for a rejected upload, found %d rows", len(uploads))
+	}
+
+	stats := srv.UploadHooks.Stats()
+	if stats["runs"] != 4 || stats["failures"] != 2 || stats["skipped"] != 2 || stats["rejected"] != 1 {
+		t.Errorf("Unexpected hook stats %v", stats)
+	}
+}
+
+func TestServerUploadV2(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := srv.Do(http.MethodPost, "/api/v2/upload", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	// Client timestamps with any offset are stored in UTC
+	observed := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	offset := observed.In(time.FixedZone("", 3600)).Format(time.RFC3339)
+	envelope := `{"schema_version":2,"idempotency_key":"nightly-1","provider":"aws","category":"compute","resource_type":"ec2_instance",` +
+		`"instances":[{"observed_at":"` + offset + `","attributes":{` +
+		`"name":{"type":"string","value":"web-1"},"cpu":{"type":"number","value":4},"spot":{"type":"bool","value":false},` +
+		`"launched":{"type":"timestamp","value":"2026-01-01T02:00:00+02:00"},"tags":{"type":"json","value":{"env":"prod"}}}}]}`
+	code, result := upload(envelope)
+	if code != http.StatusOK || result["status"] != "success" || result["schema_version"] != 2.0 || result["idempotency_key"] != "nightly-1" {
+		t.Fatalf("Unexpected v2 upload response %d: %v", code, result)
+	}
+
+	// Retrying with the same key stores nothing, even with other content
+	retry := strings.Replace(envelope, `"web-1"`, `"web-2"`, 1)
+	code, result = upload(retry)
+	if code != http.StatusOK || result["status"] != "duplicate" {
+		t.Errorf("Expected the retry to be acknowledged as a duplicate, got %d: %v", code, result)
+	}
+
+	// v1 keeps working alongside v2
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(`{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-3","cpu":4}}]}`))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from the v1 upload, got %d", resp.StatusCode)
+	}
+
+	data, err := srv.DataStorage.GetOrgData(srv.OrgID)
+	if err != nil || len(data) != 2 {
+		t.Fatalf("Expected 2 stored rows, got %d (err=%v)", len(data), err)
+	}
+	row := data[0].Data
+	tags, _ := row["tags"].(map[string]interface{})
+	if row["name"] != "web-1" || row["cpu"] != 4.0 || row["spot"] != false || tags["env"] != "prod" ||
+		row["launched"] != "2026-01-01T00:00:00Z" || row["observed_at"] != observed.Format(time.RFC3339) {
+		t.Errorf("Unexpected v2 row %v", row)
+	}
+	if v1 := data[1].Data; v1["cpu"] != row["cpu"] || v1["resource_type"] != row["resource_type"] {
+		t.Errorf("Expected v1 and v2 rows to share one layout, got %v and %v", v1, row)
+	}
+
+	for _, body := range []string{
+		`{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{}}]}`,
+		`{"schema_version":3,"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{}}]}`,
+		`{"schema_version":2,"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"cpu":{"type":"number","value":"4"}}}]}`,
+		`{"schema_version":2,"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"cpu":{"type":"integer","value":4}}}]}`,
+		`{"schema_version":2,"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"at":{"type":"timestamp","value":"yesterday"}}}]}`,
+		`{"schema_version":2,"idempotency_key":"bad key","provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{}}]}`,
+	} {
+		if code, result := upload(body); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d: %v", body, code, result)
+		}
+	}
+}
+
+func TestServerFeatureFlags(t *testing.T) {
+	versionFeatures := func(srv *Server) []string {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/version")
+		if err != nil {
+			t.Fatalf("Version request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result handlers.VersionResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		if resp.StatusCode != http.StatusOK || result.Version != Version {
+			t.Fatalf("Unexpected version response %d: %+v", resp.StatusCode, result)
+		}
+		return result.Features
+	}
+	upload := `{"schema_version":2,"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":{"type":"string","value":"web-1"}}}]}`
+
+	// Flags default to on for endpoints released before flags existed
+	srv := New(t, Options{})
+	if enabled := versionFeatures(srv); len(enabled) != 1 || enabled[0] != features.UploadV2 {
+		t.Errorf("Expected upload_v2 to be reported, got %v", enabled)
+	}
+
+	// A disabled flag removes the endpoint
+	srv = New(t, Options{Features: []string{"-upload_v2"}})
+	if enabled := versionFeatures(srv); len(enabled) != 0 {
+		t.Errorf("Expected no enabled flags, got %v", enabled)
+	}
+	resp, err := srv.Do(http.MethodPost, "/api/v2/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a disabled endpoint, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerUploadObservedAt(t *testing.T) {
+	srv := New(t, Options{})
+
+	observed := time.Now().Add(-time.Hour).In(time.FixedZone("CEST", 2*3600)).Truncate(time.Second)
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","observed_at":"` +
+		observed.Format(time.RFC3339) + `","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	uploads, err := srv.DataStorage.GetOrgData(srv.OrgID)
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("Expected 1 stored upload, got %d (err: %v)", len(uploads), err)
+	}
+	want := observed.UTC().Format(time.RFC3339Nano)
+	if got := uploads[0].Data["observed_at"]; got != want {
+		t.Errorf("Expected observed_at %q normalized to UTC, got %v", want, got)
+	}
+
+	// Timestamps too far in the future are rejected
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	upload = `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-2"},"observed_at":"` + future + `"}]}`
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for future observed_at, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerUploadLineage(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1","_upload_id":"spoofed"}}]}`
+	req, err := srv.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("X-Provider-Version", "1.4.0")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data []struct {
+			Lineage *struct {
+				UploadID        string `json:"upload_id"`
+				RequestID       string `json:"request_id"`
+				SourceIP        string `json:"source_ip"`
+				ProviderVersion string `json:"provider_version"`
+				SchemaVersion   int    `json:"schema_version"`
+			} `json:"lineage"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if len(body.Data) != 1 || body.Data[0].Lineage == nil {
+		t.Fatalf("Expected 1 record with lineage, got %+v", body.Data)
+	}
+
+	lineage := body.Data[0].Lineage
+	if lineage.UploadID == "" || lineage.UploadID == "spoofed" {
+		t.Errorf("Expected server-generated upload ID, got %q", lineage.UploadID)
+	}
+	if lineage.RequestID == "" {
+		t.Error("Expected request ID in lineage")
+	}
+	if lineage.SourceIP != "127.0.0.1" {
+		t.Errorf("Expected source IP 127.0.0.1, got %q", lineage.SourceIP)
+	}
+	if lineage.ProviderVersion != "1.4.0" {
+		t.Errorf("Expected provider version 1.4.0, got %q", lineage.ProviderVersion)
+	}
+	if lineage.SchemaVersion != 1 {
+		t.Errorf("Expected schema version 1, got %d", lineage.SchemaVersion)
+	}
+}
+
+func TestServerUploadChecksum(t *testing.T) {
+	srv := New(t, Options{})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	sum := sha256.Sum256([]byte(upload))
+	digest := hex.EncodeToString(sum[:])
+
+	send := func(path, checksum string) (*http.Response, map[string]interface{}) {
+		t.Helper()
+		req, err := srv.NewRequest(http.MethodPost, path, strings.NewReader(upload))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		if checksum != "" {
+			req.Header.Set("X-Content-SHA256", checksum)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	resp, _ := send("/api/v1/upload", "not-a-digest")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a malformed digest, got %d", resp.StatusCode)
+	}
+	resp, _ = send("/api/v1/upload", strings.Repeat("0", 64))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a mismatched digest, got %d", resp.StatusCode)
+	}
+
+	resp, body := send("/api/v1/upload", strings.ToUpper(digest))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for a matching digest, got %d", resp.StatusCode)
+	}
+	if body["content_sha256"] != digest || resp.Header.Get("X-Content-SHA256") != digest {
+		t.Errorf("Expected digest %s in response, got %v / %q", digest, body["content_sha256"], resp.Header.Get("X-Content-SHA256"))
+	}
+	original := body["upload_id"]
+
+	resp, body = send("/api/v1/upload?dedupe=true", "")
+	if resp.StatusCode != http.StatusOK || body["status"] != "duplicate" || body["duplicate_of"] != original {
+		t.Fatalf("Expected duplicate of %v, got %d %v", original, resp.StatusCode, body)
+	}
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/data", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Data []struct {
+			Lineage *struct {
+				ContentSHA256 string `json:"content_sha256"`
+			} `json:"lineage"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if len(data.Data) != 1 || data.Data[0].Lineage == nil {
+		t.Fatalf("Expected 1 record with lineage after dedupe, got %+v", data.Data)
+	}
+	if data.Data[0].Lineage.ContentSHA256 != digest {
+		t.Errorf("Expected lineage digest %s, got %q", digest, data.Data[0].Lineage.ContentSHA256)
+	}
+}
+
+func TestServerStateMetrics(t *testing.T) {
+	srv := New(t, Options{EnableMetrics: true})
+
+	lockBody := `{"ID":"lock-1","Operation":"OperationTypeApply","Who":"test"}`
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/state/test", `{"version":4,"serial":1}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/test/lock", lockBody, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/test/lock", lockBody, http.StatusLocked},
+		{http.MethodDelete, "/api/v1/state/test/lock", lockBody, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/test/lock", lockBody, http.StatusOK},
+	}
+	for _, step := range steps {
+		resp, err := srv.Do(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s: expected %d, got %d", step.method, step.path, step.want, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Metrics request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`tfbackend_state_requests_total{backend="memory",code="200",operation="put"} 1`,
+		`tfbackend_state_requests_total{backend="memory",code="423",operation="lock"} 1`,
+		`tfbackend_state_lock_contention_total{backend="memory"} 1`,
+		`tfbackend_state_lock_wait_seconds_count{backend="memory"} 1`,
+		`tfbackend_state_request_duration_seconds_count{backend="memory",operation="unlock"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestServerHTTPMetrics(t *testing.T) {
+	srv := New(t, Options{EnableMetrics: true, RateLimitPerMinute: 2})
+
+	for i := 0; i < 3; i++ {
+		resp, err := srv.Do(http.MethodGet, "/api/v1/state", nil)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := srv.NewRequest(http.MethodGet, "/api/v1/state", nil)
+	req.Header.Set("X-API-Key", "wrong-key")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request with a wrong key failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Metrics request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`tfbackend_http_requests_total{code="200",method="GET",route="/api/v1/state"} 2`,
+		`tfbackend_http_requests_total{code="429",method="GET",route="/api/v1/state"} 1`,
+		`tfbackend_http_request_duration_seconds_count{method="GET",route="/api/v1/state"} 4`,
+		`tfbackend_auth_failures_total{org_id="` + srv.OrgID.String() + `"} 1`,
+		`tfbackend_ratelimit_rejections_total 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestServerDownloadURLs(t *testing.T) {
+	srv := New(t, Options{EnableDownloads: true})
+
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod", strings.NewReader(`{"version":4,"serial":7}`))
+	if err != nil {
+		t.Fatalf("State upload failed: %v", err)
+	}
+	resp.Body.Close()
+
+	createURL := func(body string) (int, string) {
+		t.Helper()
+		resp, err := srv.Do(http.MethodPost, "/api/v1/downloads", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Download URL request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var issued struct {
+			URL string `json:"url"`
+		}
+		json.NewDecoder(resp.Body).Decode(&issued)
+		return resp.StatusCode, issued.URL
+	}
+
+	status, url := createURL(`{"resource":"state","name":"prod","ttl":"5m","one_time":true}`)
+	if status != http.StatusCreated || !strings.HasPrefix(url, "/api/v1/downloads/") {
+		t.Fatalf("Expected a download URL, got %d %q", status, url)
+	}
+
+	// The URL works without credentials, once
+	for i, want := range []int{http.StatusOK, http.StatusGone} {
+		resp, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatalf("Download %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("Download %d: expected %d, got %d: %s", i, want, resp.StatusCode, body)
+		}
+		if want == http.StatusOK && !strings.Contains(string(body), `"serial":7`) {
+			t.Errorf("Expected the state, got %s", body)
+		}
+	}
+
+	status, url = createURL(`{"resource":"data","query":"limit=10"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected a data export URL, got %d", status)
+	}
+	resp, err = http.Get(srv.URL + url)
+	if err != nil {
+		t.Fatalf("Data download failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected data export download to succeed, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + url + "x")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a tampered URL to be rejected, got %d", resp.StatusCode)
+	}
+
+	for _, body := range []string{
+		`{"resource":"billing"}`,
+		`{"resource":"state","name":"../etc"}`,
+		`{"resource":"state","name":"prod","ttl":"48h"}`,
+	} {
+		if status, _ := createURL(body); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, status)
+		}
+	}
+}
+
+func TestServerLockHistory(t *testing.T) {
+	srv := New(t, Options{EnableMetrics: true})
+
+	adminKey := srv.AddKey(t, "state:read", "state:write", "state:admin")
+
+	lockBody := `{"ID":"lock-1","Operation":"OperationTypeApply","Who":"alice@ci"}`
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		apiKey string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/state/prod/lock", lockBody, srv.APIKey, http.StatusOK},
+		{http.MethodDelete, "/api/v1/state/prod/lock", lockBody, srv.APIKey, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod/lock", lockBody, srv.APIKey, http.StatusOK},
+		// terraform force-unlock sends an empty body, which breaks another
+		// client's lock and so needs the state:admin scope
+		{http.MethodDelete, "/api/v1/state/prod/lock", "", srv.APIKey, http.StatusForbidden},
+		{"UNLOCK", "/api/v1/state/prod", "", srv.APIKey, http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/state/prod/lock", "", adminKey, http.StatusOK},
+	}
+	for _, step := range steps {
+		req, err := srv.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req.Header.Set("X-API-Key", step.apiKey)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s: expected %d, got %d", step.method, step.path, step.want, resp.StatusCode)
+		}
+		if step.want == http.StatusForbidden {
+			if lock, err := srv.StateStorage.GetLock(srv.OrgID, "prod"); err != nil || lock.ID != "lock-1" {
+				t.Fatalf("Expected lock-1 to survive an empty-body unlock without state:admin, got %+v, %v", lock, err)
+			}
+		}
+	}
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/state/prod/lock-history", nil)
+	if err != nil {
+		t.Fatalf("Lock history request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Count  int `json:"count"`
+		Events []struct {
+			Action string `json:"action"`
+			Who    string `json:"who"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode lock history: %v", err)
+	}
+
+	wantActions := []string{"acquire", "release", "acquire", "force_unlock"}
+	if body.Count != len(wantActions) {
+		t.Fatalf("Expected %d events, got %d", len(wantActions), body.Count)
+	}
+	for i, want := range wantActions {
+		if body.Events[i].Action != want || body.Events[i].Who != "alice@ci" {
+			t.Errorf("Event %d: expected %s by alice@ci, got %s by %s", i, want, body.Events[i].Action, body.Events[i].Who)
+		}
+	}
+
+	if _, err := srv.StateStorage.GetLock(srv.OrgID, "prod"); err == nil {
+		t.Error("Expected state to be unlocked after force-unlock")
+	}
+}
+
+func TestServerHTTPBackendMethods(t *testing.T) {
+	srv := New(t, Options{EnableMetrics: true})
+
+	// The requests of Terraform's http backend with its default methods
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"LOCK", "/api/v1/state/prod", `{"ID":"lock-1","Who":"alice@ci"}`, http.StatusOK},
+		{"LOCK", "/api/v1/state/prod", `{"ID":"lock-2","Who":"bob@ci"}`, http.StatusLocked},
+		{http.MethodPut, "/api/v1/state/prod?ID=lock-1", `{"serial":1}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod?ID=lock-1", `{"serial":2}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod?ID=lock-2", `{"serial":3}`, http.StatusLocked},
+		// A stale lock ID is a lock conflict, not a server error
+		{"UNLOCK", "/api/v1/state/prod", `{"ID":"lock-2"}`, http.StatusLocked},
+		{"UNLOCK", "/api/v1/state/prod", `{"ID":"lock-1"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod?ID=lock-1", `{"serial":3}`, http.StatusConflict},
+		{"UNLOCK", "/api/v1/state/prod", `{"ID":"lock-1"}`, http.StatusConflict},
+		{"PATCH", "/api/v1/state/prod", `{}`, http.StatusMethodNotAllowed},
+	}
+	for _, step := range steps {
+		resp, err := srv.Do(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		var holder struct{ ID string }
+		if step.want == http.StatusLocked {
+			json.NewDecoder(resp.Body).Decode(&holder)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s %s: expected %d, got %d", step.method, step.path, step.body, step.want, resp.StatusCode)
+		}
+		if step.want == http.StatusLocked && holder.ID != "lock-1" {
+			t.Errorf("%s %s %s: expected the current lock lock-1 in the body, got %q", step.method, step.path, step.body, holder.ID)
+		}
+	}
+
+	state, err := srv.StateStorage.GetState(srv.OrgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":2}` {
+		t.Errorf("Expected the writes under the held lock to be stored, got %+v, %v", state, err)
+	}
+}
+
+func TestServerLockedStateWrites(t *testing.T) {
+	srv := New(t, Options{})
+
+	steps := []struct {
+		method string
+		path   string
+		lockID string // Sent in handlers.LockIDHeader
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/state/prod", "", `{"serial":1}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod", "lock-1", `{"serial":2}`, http.StatusConflict},
+		{http.MethodPost, "/api/v1/state/prod/lock", "", `{"ID":"lock-1","Who":"alice@ci"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod", "", `{"serial":2}`, http.StatusLocked},
+		{http.MethodPost, "/api/v1/state/prod", "lock-2", `{"serial":2}`, http.StatusLocked},
+		{http.MethodPost, "/api/v1/state/prod", "lock-1", `{"serial":2}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod?ID=lock-1", "lock-2", `{"serial":3}`, http.StatusOK},
+		{http.MethodDelete, "/api/v1/state/prod/lock", "", `{"ID":"lock-1"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod", "", `{"serial":4}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		req, err := srv.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		if step.lockID != "" {
+			req.Header.Set(handlers.LockIDHeader, step.lockID)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s (lock %q) %s: expected %d, got %d", step.method, step.path, step.lockID, step.body, step.want, resp.StatusCode)
+		}
+	}
+
+	// A rejected write reports the lock holder
+	srv.Do(http.MethodPost, "/api/v1/state/prod/lock", strings.NewReader(`{"ID":"lock-3","Who":"carol@ci"}`))
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod", strings.NewReader(`{"serial":5}`))
+	if err != nil {
+		t.Fatalf("State write failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var lock struct {
+		ID  string
+		Who string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lock); err != nil || lock.ID != "lock-3" || lock.Who != "carol@ci" {
+		t.Errorf("Expected the lock info in the 423 response, got %+v, %v", lock, err)
+	}
+
+	state, err := srv.StateStorage.GetState(srv.OrgID, "prod")
+	if err != nil || string(state.Data) != `{"serial":4}` {
+		t.Errorf("Expected only lock-holder and unlocked writes to be stored, got %+v, %v", state, err)
+	}
+}
+
+func TestServerLockRefresh(t *testing.T) {
+	srv := New(t, Options{})
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/state/prod/lock/refresh", `{"ID":"lock-1"}`, http.StatusConflict},
+		{http.MethodPost, "/api/v1/state/prod/lock", `{"ID":"lock-1","Who":"alice@ci"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod/lock/refresh", `{"ID":"lock-2"}`, http.StatusLocked},
+		{http.MethodPost, "/api/v1/state/prod/lock/refresh", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/state/prod/lock/refresh", `{"ID":"lock-1"}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		resp, err := srv.Do(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s %s: expected %d, got %d", step.method, step.path, step.body, step.want, resp.StatusCode)
+		}
+	}
+
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod/lock/refresh", strings.NewReader(`{"ID":"lock-1"}`))
+	if err != nil {
+		t.Fatalf("Lock refresh request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		LockID      string    `json:"lock_id"`
+		RefreshedAt time.Time `json:"refreshed_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode lock refresh: %v", err)
+	}
+	if body.LockID != "lock-1" || time.Since(body.RefreshedAt) > time.Minute {
+		t.Errorf("Unexpected lock refresh response %+v", body)
+	}
+}
+
+func TestServerLockExpiry(t *testing.T) {
+	srv := New(t, Options{})
+	srv.StateStorage.SetLockTTL(50 * time.Millisecond)
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/state/prod/lock", `{"ID":"lock-1","Who":"alice@ci"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/state/prod/lock", `{"ID":"lock-2","Who":"bob@ci"}`, http.StatusLocked},
+		// Force-unlocking needs the state:admin scope, which is not a default
+		{http.MethodDelete, "/api/v1/state/prod/force-unlock", "", http.StatusForbidden},
+	}
+	for _, step := range steps {
+		resp, err := srv.Do(step.method, step.path, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", step.method, step.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("%s %s %s: expected %d, got %d", step.method, step.path, step.body, step.want, resp.StatusCode)
+		}
+	}
+
+	// Once lock-1 expires, the state is written as if it were unlocked
+	time.Sleep(100 * time.Millisecond)
+	for _, step := range []struct {
+		path string
+		want int
+	}{
+		{"/api/v1/state/prod?ID=lock-1", http.StatusConflict},
+		{"/api/v1/state/prod", http.StatusOK},
+	} {
+		resp, err := srv.Do(http.MethodPost, step.path, strings.NewReader(`{"serial":1}`))
+		if err != nil {
+			t.Fatalf("State write failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("POST %s after lock expiry: expected %d, got %d", step.path, step.want, resp.StatusCode)
+		}
+	}
+
+	// and bob can take it
+	resp, err := srv.Do(http.MethodPost, "/api/v1/state/prod/lock", strings.NewReader(`{"ID":"lock-2","Who":"bob@ci"}`))
+	if err != nil {
+		t.Fatalf("Lock request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the expired lock to be taken over, got %d", resp.StatusCode)
+	}
+	if lock, err := srv.StateStorage.GetLock(srv.OrgID, "prod"); err != nil || lock.Who != "bob@ci" {
+		t.Errorf("Expected bob to hold the lock, got %+v, %v", lock, err)
+	}
+
+	// Once bob's lock expires too, the state can be deleted
+	time.Sleep(100 * time.Millisecond)
+	resp, err = srv.Do(http.MethodDelete, "/api/v1/state/prod", nil)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the expired lock not to block the delete, got %d", resp.StatusCode)
+	}
+	if lock, err := srv.StateStorage.GetLock(srv.OrgID, "prod"); err == nil {
+		t.Errorf("Expected the expired lock to be released, got %+v", lock)
+	}
+}
+
+func TestServerUploadCSVMultipart(t *testing.T) {
+	srv := New(t, Options{})
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("provider", "aws")
+	form.WriteField("category", "compute")
+	form.WriteField("resource_type", "ec2_instance")
+	form.WriteField("mapping", `{"Instance Name":"name","State":"status"}`)
+	file, _ := form.CreateFormFile("file", "inventory.csv")
+	io.WriteString(file, "Instance Name,State,Ignored\nweb-1,running,x\n\nweb-2,stopped,y\n")
+	form.Close()
+
+	req, _ := srv.NewRequest(http.MethodPost, "/api/v1/upload/csv", &buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("CSV upload failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from CSV upload, got %d: %s", resp.StatusCode, body)
+	}
+
+	uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID)
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 stored rows, got %d", len(uploads))
+	}
+	for _, upload := range uploads {
+		if _, ok := upload.Data["Ignored"]; ok {
+			t.Errorf("Unmapped column must not be imported: %v", upload.Data)
+		}
+	}
+	if uploads[0].Data["resource_name"] != "web-1" || uploads[0].Data["status"] != "running" {
+		t.Errorf("Unexpected first row: %+v", uploads[0].Data)
+	}
+}
+
+func TestServerUploadCSVFormURLEncoded(t *testing.T) {
+	srv := New(t, Options{})
+
+	values := url.Values{
+		"provider":      {"aws"},
+		"category":      {"compute"},
+		"resource_type": {"ec2_instance"},
+		"csv":           {"name,region\nweb-1,us-east-1\n"},
+	}
+	req, _ := srv.NewRequest(http.MethodPost, "/api/v1/upload/csv?dry_run=true", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("CSV upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string                   `json:"status"`
+		Rows   []map[string]interface{} `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Status != "dry_run" || len(body.Rows) != 1 || body.Rows[0]["region"] != "us-east-1" {
+		t.Errorf("Unexpected dry-run response: %+v", body)
+	}
+
+	// The same validation pipeline applies
+	values.Set("provider", "")
+	req, _ = srv.NewRequest(http.MethodPost, "/api/v1/upload/csv", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("CSV upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for missing provider, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerTaxonomyAllowlist(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret"})
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/admin/v1/orgs/"+srv.OrgID.String()+"/taxonomy",
+		strings.NewReader(`{"providers":["aws","gcp"],"resource_types":["ec2_instance"]}`))
+	req.Header.Set("X-Admin-Key", "admin-secret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Taxonomy update failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from taxonomy update, got %d", resp.StatusCode)
+	}
+
+	upload := `{"provider":"AWS","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for disallowed provider, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "allowed values: aws, gcp") || !strings.Contains(string(body), "did you mean 'aws'?") {
+		t.Errorf("Error should list allowed values and suggest a match, got: %s", body)
+	}
+
+	// Categories are unrestricted because the allowlist leaves them empty
+	upload = `{"provider":"aws","category":"anything","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for allowed values, got %d", resp.StatusCode)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/taxonomy", nil)
+	if err != nil {
+		t.Fatalf("Taxonomy request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Restricted bool `json:"restricted"`
+		Taxonomy   struct {
+			Providers []string `json:"providers"`
+		} `json:"taxonomy"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode taxonomy: %v", err)
+	}
+	if !result.Restricted || len(result.Taxonomy.Providers) != 2 {
+		t.Errorf("Unexpected org taxonomy: %+v", result)
+	}
+}
+
+func TestServerNormalizationRules(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret"})
+
+	rules := `{"status": {"trim": true, "case": "lower", "enum": {"terminated": "stopped"}}, "memory": {"units": {"MB": 1, "GB": 1024}}}`
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/admin/v1/orgs/"+srv.OrgID.String()+"/normalization", strings.NewReader(rules))
+	req.Header.Set("X-Admin-Key", "admin-secret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Normalization update failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from normalization update, got %d", resp.StatusCode)
+	}
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[
+		{"attributes":{"name":"web-1","status":" RUNNING ","memory":"2 GB"}},
+		{"attributes":{"name":"web-2","status":"Terminated","memory":512}}]}`
+	resp, err = srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from upload, got %d", resp.StatusCode)
+	}
+
+	uploads, _ := srv.DataStorage.GetOrgData(srv.OrgID)
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 stored rows, got %d", len(uploads))
+	}
+	if uploads[0].Data["status"] != "running" || uploads[0].Data["memory"] != float64(2048) {
+		t.Errorf("Unexpected first row: %v", uploads[0].Data)
+	}
+	if uploads[1].Data["status"] != "stopped" || uploads[1].Data["memory"] != float64(512) {
+		t.Errorf("Unexpected second row: %v", uploads[1].Data)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+"/admin/v1/orgs/"+srv.OrgID.String()+"/normalization",
+		strings.NewReader(`{"status": {"case": "title"}}`))
+	req.Header.Set("X-Admin-Key", "admin-secret")
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Normalization update failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid rule, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerDataQuality(t *testing.T) {
+	srv := New(t, Options{})
+
+	for i := 0; i < 5; i++ {
+		srv.DataStorage.AppendData(srv.OrgID, map[string]interface{}{
+			"resource_type": "ec2_instance", "resource_name": "web", "status": "running", "zone": "a",
+		})
+	}
+	srv.DataStorage.AppendData(srv.OrgID, map[string]interface{}{
+		"resource_type": "ec2_instance", "status": "running", "zone": "a", "observed_at": "last tuesday",
+		"a": 1, "b": 2, "c": 3,
+	})
+
+	resp, err := srv.Do(http.MethodGet, "/api/v1/data/quality", nil)
+	if err != nil {
+		t.Fatalf("Quality request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from quality report, got %d", resp.StatusCode)
+	}
+
+	var report struct {
+		TotalRows            int `json:"total_rows"`
+		MissingResourceNames struct {
+			Count int `json:"count"`
+		} `json:"missing_resource_names"`
+		UnparseableTimestamps struct {
+			Count   int `json:"count"`
+			Samples []struct {
+				Detail string `json:"detail"`
+			} `json:"samples"`
+		} `json:"unparseable_timestamps"`
+		AttributeCountOutliers struct {
+			Count int `json:"count"`
+		} `json:"attribute_count_outliers"`
+		RejectedLines *struct{} `json:"rejected_lines"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode quality report: %v", err)
+	}
+
+	if report.TotalRows != 6 || report.MissingResourceNames.Count != 1 || report.AttributeCountOutliers.Count != 1 {
+		t.Errorf("Unexpected quality report: %+v", report)
+	}
+	if report.UnparseableTimestamps.Count != 1 || !strings.Contains(report.UnparseableTimestamps.Samples[0].Detail, "last tuesday") {
+		t.Errorf("Expected the unparseable observed_at to be reported: %+v", report.UnparseableTimestamps)
+	}
+	if report.RejectedLines != nil {
+		t.Error("Memory storage cannot report rejected lines")
+	}
+}
+
+func TestServerOpenAPISpec(t *testing.T) {
+	srv := New(t, Options{})
+
+	resp, err := srv.Client().Get(srv.URL + "/openapi.yaml")
+	if err != nil {
+		t.Fatalf("Spec request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 without credentials, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(string(body), "openapi:") {
+		t.Errorf("Expected the embedded OpenAPI spec, got %.40q", body)
+	}
+}
+
+func TestServerDataQueryETags(t *testing.T) {
+	srv := New(t, Options{EnableQueryCache: true})
+
+	upload := func(name string) {
+		t.Helper()
+		resp, err := srv.Do(http.MethodPost, "/api/v1/upload", strings.NewReader(`{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"`+name+`"}}]}`))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 from the upload, got %d", resp.StatusCode)
+		}
+	}
+	query := func(path, ifNoneMatch string) *http.Response {
+		t.Helper()
+		req, err := srv.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	upload("web-1")
+	first := query("/api/v1/data/summary?group_by=provider", "")
+	etag := first.Header.Get("ETag")
+	if first.StatusCode != http.StatusOK || etag == "" || first.Header.Get("X-Cache") != "MISS" || first.Header.Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("Unexpected first response %d: %v", first.StatusCode, first.Header)
+	}
+
+	// The same query is served from the cache and revalidates with 304
+	if resp := query("/api/v1/data/summary?group_by=provider", ""); resp.Header.Get("X-Cache") != "HIT" || resp.Header.Get("ETag") != etag {
+		t.Errorf("Expected a cache hit with the same ETag, got %v", resp.Header)
+	}
+	if resp := query("/api/v1/data/summary?group_by=provider", `W/"other", `+etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching If-None-Match, got %d", resp.StatusCode)
+	}
+
+	// An upload invalidates the cache and changes the ETag
+	upload("web-2")
+	resp := query("/api/v1/data/summary?group_by=provider", etag)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache") != "MISS" || resp.Header.Get("ETag") == etag {
+		t.Errorf("Expected fresh data after an upload, got %d: %v", resp.StatusCode, resp.Header)
+	}
+
+	// Errors are neither tagged nor cached
+	if resp := query("/api/v1/data?fields=,", ""); resp.StatusCode != http.StatusBadRequest || resp.Header.Get("ETag") != "" {
+		t.Errorf("Expected an untagged 400, got %d: %v", resp.StatusCode, resp.Header)
+	}
+
+	if entries, _, hits, _ := srv.QueryCache.Stats(); entries != 1 || hits != 2 {
+		t.Errorf("Expected 1 cached response and 2 hits, got %d and %d", entries, hits)
+	}
+}
+
+func TestServerUploadQuarantine(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret", EnableQuarantine: true})
+
+	admin := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "admin-secret")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Admin request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+	upload := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := srv.Do(http.MethodPost, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Upload request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if code, _ := admin(http.MethodPut, "/admin/v1/orgs/"+srv.OrgID.String()+"/taxonomy", `{"providers":["aws"]}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 from taxonomy update, got %d", code)
+	}
+
+	// A rejected upload is quarantined, and retries of it share the entry
+	payload := `{"provider":"azure","category":"compute","resource_type":"vm","instances":[{"attributes":{"name":"vm-1"}}]}`
+	resp := upload("/api/v1/upload?dedupe=true", payload)
+	id := resp.Header.Get("X-Quarantine-ID")
+	if resp.StatusCode != http.StatusBadRequest || id == "" {
+		t.Fatalf("Expected a quarantined 400, got %d: %v", resp.StatusCode, resp.Header)
+	}
+	if resp := upload("/api/v1/upload?dedupe=true", payload); resp.Header.Get("X-Quarantine-ID") != id {
+		t.Errorf("Expected the retry to reuse entry %s, got %q", id, resp.Header.Get("X-Quarantine-ID"))
+	}
+	if resp := upload("/api/v1/upload?dry_run=true", payload); resp.Header.Get("X-Quarantine-ID") != "" {
+		t.Error("Expected dry runs not to be quarantined")
+	}
+	if resp := upload("/api/v1/upload", `{"provider":`); resp.Header.Get("X-Quarantine-ID") == "" {
+		t.Error("Expected invalid JSON to be quarantined")
+	}
+
+	code, list := admin(http.MethodGet, "/admin/v1/quarantine?org_id="+srv.OrgID.String(), "")
+	if code != http.StatusOK || list["count"] != 2.0 {
+		t.Fatalf("Expected 2 quarantined uploads, got %d: %v", code, list)
+	}
+	code, entry := admin(http.MethodGet, "/admin/v1/quarantine/"+id, "")
+	if code != http.StatusOK || entry["rejections"] != 2.0 || entry["query"] != "dedupe=true" || !strings.Contains(entry["reason"].(string), "provider 'azure' is not allowed") {
+		t.Errorf("Unexpected entry %d: %v", code, entry)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/v1/quarantine/"+id+"/payload", nil)
+	req.Header.Set("X-Admin-Key", "admin-secret")
+	payloadResp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Payload request failed: %v", err)
+	}
+	body, _ := io.ReadAll(payloadResp.Body)
+	payloadResp.Body.Close()
+	if string(body) != payload {
+		t.Errorf("Expected the original payload, got %s", body)
+	}
+
+	// Re-ingesting before the fix records the attempt
+	code, result := admin(http.MethodPost, "/admin/v1/quarantine/"+id+"/reingest", "")
+	if code != http.StatusUnprocessableEntity || result["status"] != "rejected" || result["entry"].(map[string]interface{})["attempts"] != 1.0 {
+		t.Errorf("Expected a rejected re-ingestion, got %d: %v", code, result)
+	}
+
+	// After the allowlist is extended, the payload is stored and the entry removed
+	admin(http.MethodPut, "/admin/v1/orgs/"+srv.OrgID.String()+"/taxonomy", `{"providers":["aws","azure"]}`)
+	if code, result := admin(http.MethodPost, "/admin/v1/quarantine/"+id+"/reingest?dry_run=true", ""); code != http.StatusOK || result["status"] != "valid" {
+		t.Errorf("Expected a valid dry run, got %d: %v", code, result)
+	}
+	code, result = admin(http.MethodPost, "/admin/v1/quarantine/"+id+"/reingest", "")
+	if code != http.StatusOK || result["status"] != "reingested" {
+		t.Fatalf("Expected the payload to be re-ingested, got %d: %v", code, result)
+	}
+	if code, _ := admin(http.MethodGet, "/admin/v1/quarantine/"+id, ""); code != http.StatusNotFound {
+		t.Errorf("Expected the re-ingested entry to be removed, got %d", code)
+	}
+
+	// A corrected payload replaces a broken one
+	_, list = admin(http.MethodGet, "/admin/v1/quarantine", "")
+	brokenID := list["entries"].([]interface{})[0].(map[string]interface{})["id"].(string)
+	code, result = admin(http.MethodPost, "/admin/v1/quarantine/"+brokenID+"/reingest",
+		`{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`)
+	if code != http.StatusOK || result["status"] != "reingested" {
+		t.Errorf("Expected the corrected payload to be re-ingested, got %d: %v", code, result)
+	}
+
+	data, err := srv.DataStorage.GetOrgData(srv.OrgID)
+	if err != nil || len(data) != 2 || data[0].Data["provider"] != "azure" || data[1].Data["provider"] != "aws" {
+		t.Errorf("Expected both re-ingested uploads to be stored, got %v (err=%v)", data, err)
+	}
+	if entries, _ := srv.Quarantine.Stats(); entries != 0 {
+		t.Errorf("Expected an empty quarantine, got %d entries", entries)
+	}
+}
+
+func TestServerRateLimitOverrides(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret", RateLimitPerMinute: 2})
+
+	admin := func(method, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/admin/v1/orgs/"+srv.OrgID.String()+"/ratelimit", strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "admin-secret")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Admin request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	allowed := func(n int) int {
+		t.Helper()
+		count := 0
+		for i := 0; i < n; i++ {
+			resp, err := srv.Do(http.MethodGet, "/api/v1/data", nil)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusTooManyRequests {
+				count++
+			}
+		}
+		return count
+	}
+
+	if n := allowed(5); n != 2 {
+		t.Fatalf("Expected the global limit of 2 requests, got %d", n)
+	}
+
+	// An exemption applies to the next request without a restart
+	if code := admin(http.MethodPut, `{"exempt":true,"reason":"migration"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 from the override update, got %d", code)
+	}
+	if n := allowed(5); n != 5 {
+		t.Errorf("Expected the exempt org to be unlimited, got %d of 5", n)
+	}
+
+	// A multiplier raises the limit at once: the exhausted bucket gains the
+	// 4 requests the limit grew by
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if code := admin(http.MethodPut, `{"multiplier":3,"until":"`+until+`"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 from the override update, got %d", code)
+	}
+	if n := allowed(10); n != 4 {
+		t.Errorf("Expected 4 more requests with a multiplier of 3, got %d", n)
+	}
+
+	for _, body := range []string{`{}`, `{"exempt":true,"multiplier":2}`, `{"multiplier":-1}`, `{"exempt":true,"until":"2020-01-01T00:00:00Z"}`} {
+		if code := admin(http.MethodPut, body); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, code)
+		}
+	}
+
+	if code := admin(http.MethodDelete, ""); code != http.StatusNoContent {
+		t.Errorf("Expected 204 from the override deletion, got %d", code)
+	}
+	if code := admin(http.MethodGet, ""); code != http.StatusNotFound {
+		t.Errorf("Expected the override to be gone, got %d", code)
+	}
+}
+
+func TestServerAdminStateOperations(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret"})
+	otherOrg, _ := srv.AddOrg(t)
+
+	admin := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/admin/v1/orgs/"+srv.OrgID.String()+path, strings.NewReader(body))
+		req.Header.Set("X-Admin-Key", "admin-secret")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Admin request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	for _, name := range []string{"app:dev", "app:prod", "app:staging", "infra"} {
+		if err := srv.StateStorage.PutState(srv.OrgID, name, []byte(`{"serial":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// State names contain colons; the operation follows the last one
+	if code, _ := admin(http.MethodPost, "/state/app:dev:copy", `{"to":"app:qa"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 from the copy, got %d", code)
+	}
+	if _, err := srv.StateStorage.GetState(srv.OrgID, "app:dev"); err != nil {
+		t.Errorf("Expected the copy to keep the source, got %v", err)
+	}
+
+	if code, _ := admin(http.MethodPost, "/state/app:dev:rename", `{"to":"app:qa"}`); code != http.StatusConflict {
+		t.Errorf("Expected 409 when the destination exists, got %d", code)
+	}
+	if code, _ := admin(http.MethodPost, "/state/app:dev:rename", `{"to":"app:qa","overwrite":true}`); code != http.StatusOK {
+		t.Errorf("Expected 200 from the rename with overwrite, got %d", code)
+	}
+	if _, err := srv.StateStorage.GetState(srv.OrgID, "app:dev"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the rename to remove the source, got %v", err)
+	}
+
+	srv.StateStorage.LockState(srv.OrgID, "infra", &storage.LockInfo{ID: "lock-1"})
+	if code, _ := admin(http.MethodPost, "/state/infra:move", `{"to_org_id":"`+otherOrg.String()+`"}`); code != http.StatusLocked {
+		t.Errorf("Expected 423 when moving a locked state, got %d", code)
+	}
+	srv.StateStorage.UnlockState(srv.OrgID, "infra", "lock-1")
+	if code, _ := admin(http.MethodPost, "/state/infra:move", `{"to_org_id":"`+otherOrg.String()+`"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 from the move, got %d", code)
+	}
+	if state, err := srv.StateStorage.GetState(otherOrg, "infra"); err != nil || string(state.Data) != `{"serial":1}` {
+		t.Errorf("Expected the state in the other org, got %v", err)
+	}
+
+	for path, body := range map[string]string{
+		"/state/infra":            `{}`,
+		"/state/app:prod:rename":  `{}`,
+		"/state/app:prod:move":    `{"to":"other"}`,
+		"/state/app:prod:copy":    `{"to":"../x"}`,
+		"/state/app:prod:explode": `{}`,
+	} {
+		if code, _ := admin(http.MethodPost, path, body); code != http.StatusBadRequest && code != http.StatusNotFound {
+			t.Errorf("Expected 400 or 404 for %s %s, got %d", path, body, code)
+		}
+	}
+
+	srv.StateStorage.LockState(srv.OrgID, "app:staging", &storage.LockInfo{ID: "lock-2"})
+	code, result := admin(http.MethodDelete, "/states?prefix=app:&dry_run=true", "")
+	if code != http.StatusOK || len(result["deleted"].([]interface{})) != 2 {
+		t.Fatalf("Expected a dry run listing 2 states, got %d %v", code, result)
+	}
+	code, result = admin(http.MethodDelete, "/states?prefix=app:", "")
+	if code != http.StatusOK || len(result["deleted"].([]interface{})) != 2 || len(result["locked"].([]interface{})) != 1 {
+		t.Fatalf("Expected 2 deleted and 1 locked state, got %d %v", code, result)
+	}
+	if states, _ := srv.StateStorage.ListStates(srv.OrgID); len(states) != 1 || states[0].Name != "app:staging" {
+		t.Errorf("Expected only the locked state to remain, got %+v", states)
+	}
+	if code, _ := admin(http.MethodDelete, "/states", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a prefix, got %d", code)
+	}
+}
+
+func TestServerClientVersions(t *testing.T) {
+	srv := New(t, Options{AdminAPIKey: "admin-secret", EnableChangeLog: true})
+
+	upload := `{"provider":"aws","category":"compute","resource_type":"ec2_instance","instances":[{"attributes":{"name":"web-1"}}]}`
+	req, _ := srv.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(upload))
+	req.Header.Set("X-Client-Version", "terraform-provider-eterrain/1.4.2")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req, _ = srv.NewRequest(http.MethodPost, "/api/v1/state/infra", strings.NewReader(`{"version":4}`))
+	req.Header.Set("User-Agent", "Go-http-client/1.1 Terraform/1.9.0")
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("State request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// The client is stored in the upload lineage and the state change
+	resp, err = srv.Do(http.MethodGet, "/api/v1/data", nil)
+	if err != nil {
+		t.Fatalf("Query request failed: %v", err)
+	}
+	var data struct {
+		Data []struct {
+			Lineage struct {
+				Client string `json:"client"`
+			} `json:"lineage"`
+		} `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&data)
+	resp.Body.Close()
+	if len(data.Data) != 1 || data.Data[0].Lineage.Client != "terraform-provider-eterrain/1.4.2" {
+		t.Errorf("Expected the client in the lineage, got %+v", data.Data)
+	}
+
+	resp, err = srv.Do(http.MethodGet, "/api/v1/changes", nil)
+	if err != nil {
+		t.Fatalf("Changes request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"client":"terraform/1.9.0"`) {
+		t.Errorf("Expected the client in the state change, got %s", body)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/admin/v1/clients", nil)
+	req.Header.Set("X-Admin-Key", "admin-secret")
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Admin request failed: %v", err)
+	}
+	var report struct {
+		Clients []struct {
+			Name        string `json:"name"`
+			Version     string `json:"version"`
+			Uploads     int    `json:"uploads"`
+			StateWrites int    `json:"state_writes"`
+			Orgs        int    `json:"orgs"`
+		} `json:"clients"`
+	}
+	json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if len(report.Clients) != 2 {
+		t.Fatalf("Expected 2 client versions, got %+v", report.Clients)
+	}
+	terraform, provider := report.Clients[0], report.Clients[1]
+	if terraform.Name != "terraform" || terraform.Version != "1.9.0" || terraform.StateWrites != 1 || terraform.Orgs != 1 {
+		t.Errorf("Unexpected Terraform usage %+v", terraform)
+	}
+	if provider.Name != "terraform-provider-eterrain" || provider.Uploads != 1 || provider.StateWrites != 0 {
+		t.Errorf("Unexpected provider usage %+v", provider)
+	}
+}